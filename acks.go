@@ -0,0 +1,446 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 推送确认与重发 =====
+//
+// 推送带 "require_ack": true 时，中继给消息分配 message_id，下发的消息带 "id" 和 "require_ack":true，
+// 原生客户端（WebSocket / TCP）收到后回
+//
+//	{"type":"ack","id":"msg_9f2c..."}
+//
+// 每个目标连接单独计时：timeout_seconds 内没收到 ack 就给该连接重发同一条消息（id 不变，客户端按 id 去重），
+// 等待时间每次翻倍（不超过 max_timeout_seconds），重发 max_retries 次仍没有 ack 记为失败。
+// 投递状态用 GET /v1/messages/{id}/delivery 查询：
+//
+//	{"message_id":"msg_9f2c...","status":"partial","attempts":5,"counts":{"pending":0,"acked":2,"failed":1},"connections":[...]}
+//
+// Pusher / Centrifugo / Phoenix / SignalR / SSE 连接没有 ack 帧，照常下发但不跟踪。
+// 连接在等待期间断开记为失败（error: disconnected），不会转投到该用户的其它连接或之后的新连接。
+// 状态只保存在本节点内存中，按 retention_seconds 和 max_messages 淘汰。
+
+// AckRetryConfig 推送确认与重发配置
+type AckRetryConfig struct {
+	Enabled           bool `json:"enabled"`
+	TimeoutSeconds    int  `json:"timeout_seconds"`     // 第一次下发后等待 ack 的时间，默认 5
+	MaxTimeoutSeconds int  `json:"max_timeout_seconds"` // 每次重发后等待时间翻倍的上限，默认 60
+	MaxRetries        int  `json:"max_retries"`         // 超时后最多重发几次，默认 3；-1 表示只等待不重发
+	RetentionSeconds  int  `json:"retention_seconds"`   // 投递状态保留时间，默认 3600
+	MaxMessages       int  `json:"max_messages"`        // 最多保留的消息数，默认 10000，超出时淘汰最早的
+}
+
+const (
+	ackDefaultTimeout     = 5
+	ackDefaultMaxTimeout  = 60
+	ackDefaultMaxRetries  = 3
+	ackDefaultRetention   = 3600
+	ackDefaultMaxMessages = 10000
+	ackCheckInterval      = 500 * time.Millisecond
+
+	ackPending = "pending"
+	ackAcked   = "acked"
+	ackFailed  = "failed"
+	ackPartial = "partial" // 部分连接确认、部分失败
+	ackNone    = "no_recipients"
+
+	ackErrTimeout      = "ack_timeout"
+	ackErrDisconnected = "disconnected"
+)
+
+var errAckRetryNotEnabled = errors.New("require_ack needs ack_retry.enabled")
+
+// ackDelivery 一个连接对一条消息的确认状态
+type ackDelivery struct {
+	ConnectionID string     `json:"connection_id"`
+	UserID       string     `json:"user_id,omitempty"`
+	Status       string     `json:"status"` // pending / acked / failed
+	Attempts     int        `json:"attempts"`
+	LastSentAt   time.Time  `json:"last_sent_at"`
+	AckedAt      *time.Time `json:"acked_at,omitempty"`
+	Error        string     `json:"error,omitempty"`
+
+	client *Client
+	nextAt time.Time // 超时时刻，到了还没 ack 就重发
+}
+
+// ackCounts 按状态汇总
+type ackCounts struct {
+	Pending int `json:"pending"`
+	Acked   int `json:"acked"`
+	Failed  int `json:"failed"`
+}
+
+// ackMessage 一条需要确认的消息
+type ackMessage struct {
+	id           string
+	eventName    string
+	targetUserID string
+	channel      string
+	group        string
+	sentAt       time.Time
+	sent         bool      // 首次下发已完成，之后不会再有新连接加入
+	message      WSMessage // 首次下发的消息（已签名），重发时原样再发
+	conns        map[string]*ackDelivery
+	order        []string // 连接 ID，按首次下发顺序
+
+	callback *pushResult // 带 callback_url 时所有连接都有结果后回调，发出后置 nil
+}
+
+// ackStatusView 接口返回的投递状态
+type ackStatusView struct {
+	MessageID    string         `json:"message_id"`
+	EventName    string         `json:"event_name"`
+	TargetUserID string         `json:"target_user_id,omitempty"`
+	Channel      string         `json:"channel,omitempty"`
+	Group        string         `json:"group,omitempty"`
+	SentAt       time.Time      `json:"sent_at"`
+	Status       string         `json:"status"` // pending / acked / partial / failed / no_recipients
+	Attempts     int            `json:"attempts"`
+	Counts       ackCounts      `json:"counts"`
+	Connections  []*ackDelivery `json:"connections"`
+}
+
+var (
+	ackMu    sync.Mutex
+	ackMsgs  = make(map[string]*ackMessage)
+	ackOrder []string // 按发送时间排列的 message_id，用于淘汰
+
+	ackRetries  atomic.Uint64
+	ackAcks     atomic.Uint64
+	ackFailures atomic.Uint64
+)
+
+func prepareAckRetry(cfg *AckRetryConfig) {
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = ackDefaultTimeout
+	}
+	if cfg.MaxTimeoutSeconds < cfg.TimeoutSeconds {
+		cfg.MaxTimeoutSeconds = max(ackDefaultMaxTimeout, cfg.TimeoutSeconds)
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = ackDefaultMaxRetries
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.RetentionSeconds <= 0 {
+		cfg.RetentionSeconds = ackDefaultRetention
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = ackDefaultMaxMessages
+	}
+}
+
+// ackWait 第 attempt 次下发后等待 ack 的时间
+func ackWait(attempt int) time.Duration {
+	cfg := GlobalConfig.AckRetry
+	wait := time.Duration(cfg.TimeoutSeconds) * time.Second
+	limit := time.Duration(cfg.MaxTimeoutSeconds) * time.Second
+	for i := 1; i < attempt && wait < limit; i++ {
+		wait *= 2
+	}
+	return min(wait, limit)
+}
+
+// validatePushAck 推送请求里的 require_ack
+func validatePushAck(body PushRequest) (string, error) {
+	switch {
+	case !body.RequireAck:
+		return "", nil
+	case !GlobalConfig.AckRetry.Enabled:
+		return "require_ack", errAckRetryNotEnabled
+	case body.Ephemeral:
+		return "require_ack", errors.New("ephemeral pushes cannot require ack")
+	}
+	return "", nil
+}
+
+// trackAckMessage 发送前登记一条需要确认的消息
+func trackAckMessage(p *preparedPush) {
+	m := &ackMessage{
+		id:           p.message.ID,
+		eventName:    p.body.EventName,
+		targetUserID: p.target,
+		channel:      p.body.Channel,
+		group:        p.body.Group,
+		sentAt:       time.Now(),
+		conns:        make(map[string]*ackDelivery),
+	}
+	if p.body.CallbackURL != "" {
+		res := newPushResult(p)
+		m.callback = &res
+	}
+
+	ackMu.Lock()
+	defer ackMu.Unlock()
+	ackMsgs[m.id] = m
+	ackOrder = append(ackOrder, m.id)
+	pruneAcksLocked(m.sentAt)
+}
+
+// finishAckMessage 首次下发完成
+func finishAckMessage(id string, delivered int) {
+	ackMu.Lock()
+	defer ackMu.Unlock()
+	if m, ok := ackMsgs[id]; ok {
+		m.sent = true
+		if m.callback != nil {
+			m.callback.Delivered = delivered
+		}
+		m.reportLocked()
+	}
+}
+
+// reportLocked 需要回调的消息在所有连接都确认或失败后发出推送结果，只发一次
+func (m *ackMessage) reportLocked() {
+	if m.callback == nil || !m.sent || m.pendingLocked() > 0 {
+		return
+	}
+	v := m.viewLocked()
+	res := *m.callback
+	res.Status, res.Acks = v.Status, &v.Counts
+	m.callback = nil
+	enqueuePushResult(res)
+}
+
+// recordAckDelivery 首次下发到一个连接后开始计时；不需要确认的消息、不支持 ack 的协议不做任何事
+func recordAckDelivery(msg WSMessage, c *Client, err error) {
+	if !msg.RequireAck || c.frame != nil {
+		return
+	}
+	ackMu.Lock()
+	defer ackMu.Unlock()
+
+	m, ok := ackMsgs[msg.ID]
+	if !ok {
+		return
+	}
+	if m.message.ID == "" {
+		m.message = msg
+	}
+	now := time.Now()
+	d := &ackDelivery{
+		ConnectionID: c.id,
+		UserID:       c.userID,
+		Status:       ackPending,
+		Attempts:     1,
+		LastSentAt:   now,
+		client:       c,
+		nextAt:       now.Add(ackWait(1)),
+	}
+	if err != nil {
+		d.Status, d.Error, d.client = ackFailed, err.Error(), nil
+		ackFailures.Add(1)
+	}
+	if _, dup := m.conns[c.id]; !dup {
+		m.order = append(m.order, c.id)
+	}
+	m.conns[c.id] = d
+}
+
+// ackDeliveryFrom 客户端确认收到消息；未知、已淘汰或已经结束的忽略
+func ackDeliveryFrom(c *Client, msgID string) {
+	ackMu.Lock()
+	defer ackMu.Unlock()
+
+	m, ok := ackMsgs[msgID]
+	if !ok {
+		return
+	}
+	d, ok := m.conns[c.id]
+	if !ok || d.Status != ackPending {
+		return
+	}
+	now := time.Now()
+	d.Status, d.AckedAt, d.client = ackAcked, &now, nil
+	ackAcks.Add(1)
+	m.reportLocked()
+}
+
+// ackRetryLoop 定期检查超时的连接：还有重发次数就重发，否则记为失败
+func ackRetryLoop() {
+	ticker := time.NewTicker(ackCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		retryDueAcks(time.Now())
+	}
+}
+
+type ackResend struct {
+	msgID string
+	msg   WSMessage
+	c     *Client
+}
+
+func retryDueAcks(now time.Time) {
+	var resends []ackResend
+
+	ackMu.Lock()
+	for _, m := range ackMsgs {
+		for _, d := range m.conns {
+			if d.Status != ackPending || now.Before(d.nextAt) {
+				continue
+			}
+			if d.Attempts > GlobalConfig.AckRetry.MaxRetries {
+				d.Status, d.Error, d.client = ackFailed, ackErrTimeout, nil
+				ackFailures.Add(1)
+				log.Printf("⏰ 消息 %s 在 conn=%s 上 %d 次下发均未确认，记为失败\n", m.id, d.ConnectionID, d.Attempts)
+				m.reportLocked()
+				continue
+			}
+			d.Attempts++
+			d.LastSentAt = now
+			d.nextAt = now.Add(ackWait(d.Attempts))
+			resends = append(resends, ackResend{msgID: m.id, msg: m.message, c: d.client})
+		}
+	}
+	pruneAcksLocked(now)
+	ackMu.Unlock()
+
+	// 在锁外写连接，慢连接不阻塞 ack 处理；断开的连接已由 forgetAckConn 结束等待，不会出现在这里
+	for _, rs := range resends {
+		ackRetries.Add(1)
+		if err := rs.c.deliver(rs.msg); err != nil {
+			failAckDelivery(rs.msgID, rs.c.id, err.Error())
+			log.Printf("🧹 重发消息 %s 失败，清理连接 conn=%s: %v\n", rs.msgID, rs.c.id, err)
+			rs.c.conn.Close()
+			defaultHub.removeClient(rs.c)
+		}
+	}
+}
+
+// failAckDelivery 重发失败时结束该连接的等待
+func failAckDelivery(msgID, connID, reason string) {
+	ackMu.Lock()
+	defer ackMu.Unlock()
+	m, ok := ackMsgs[msgID]
+	if !ok {
+		return
+	}
+	if d, ok := m.conns[connID]; ok && d.Status == ackPending {
+		d.Status, d.Error, d.client = ackFailed, reason, nil
+		ackFailures.Add(1)
+		m.reportLocked()
+	}
+}
+
+// forgetAckConn 连接断开时结束它所有还在等待的确认
+func forgetAckConn(c *Client) {
+	ackMu.Lock()
+	defer ackMu.Unlock()
+	for _, m := range ackMsgs {
+		if d, ok := m.conns[c.id]; ok && d.Status == ackPending {
+			d.Status, d.Error, d.client = ackFailed, ackErrDisconnected, nil
+			ackFailures.Add(1)
+			m.reportLocked()
+		}
+	}
+}
+
+// pruneAcksLocked 淘汰过期和超出数量上限的消息；还有连接在等待确认的消息不会因过期被淘汰
+func pruneAcksLocked(now time.Time) {
+	cfg := GlobalConfig.AckRetry
+	cutoff := now.Add(-time.Duration(cfg.RetentionSeconds) * time.Second)
+
+	n := 0
+	for _, id := range ackOrder {
+		m, ok := ackMsgs[id]
+		if !ok {
+			n++
+			continue
+		}
+		if len(ackOrder)-n <= cfg.MaxMessages && (!m.sentAt.Before(cutoff) || m.pendingLocked() > 0) {
+			break
+		}
+		delete(ackMsgs, id)
+		n++
+	}
+	if n > 0 {
+		ackOrder = append([]string(nil), ackOrder[n:]...)
+	}
+}
+
+func (m *ackMessage) pendingLocked() int {
+	n := 0
+	for _, d := range m.conns {
+		if d.Status == ackPending {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *ackMessage) viewLocked() ackStatusView {
+	v := ackStatusView{
+		MessageID:    m.id,
+		EventName:    m.eventName,
+		TargetUserID: m.targetUserID,
+		Channel:      m.channel,
+		Group:        m.group,
+		SentAt:       m.sentAt,
+		Connections:  make([]*ackDelivery, 0, len(m.order)),
+	}
+	for _, id := range m.order {
+		d := *m.conns[id]
+		v.Connections = append(v.Connections, &d)
+		v.Attempts += d.Attempts
+		switch d.Status {
+		case ackPending:
+			v.Counts.Pending++
+		case ackAcked:
+			v.Counts.Acked++
+		default:
+			v.Counts.Failed++
+		}
+	}
+	switch {
+	case !m.sent || v.Counts.Pending > 0:
+		v.Status = ackPending
+	case len(m.order) == 0:
+		v.Status = ackNone
+	case v.Counts.Failed == 0:
+		v.Status = ackAcked
+	case v.Counts.Acked == 0:
+		v.Status = ackFailed
+	default:
+		v.Status = ackPartial
+	}
+	return v
+}
+
+// ackPendingTotal 还在等待确认的连接数，用于指标
+func ackPendingTotal() int {
+	ackMu.Lock()
+	defer ackMu.Unlock()
+	n := 0
+	for _, m := range ackMsgs {
+		n += m.pendingLocked()
+	}
+	return n
+}
+
+// v1DeliveryHandler GET /v1/messages/{id}/delivery
+func v1DeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	ackMu.Lock()
+	m, ok := ackMsgs[id]
+	var view ackStatusView
+	if ok {
+		view = m.viewLocked()
+	}
+	ackMu.Unlock()
+
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "delivery status not found: "+id)
+		return
+	}
+	writeV1(w, http.StatusOK, view)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ===== 连接元数据 & 管理接口 =====
+
+var defaultMetadataHeaders = []string{"User-Agent", "X-App-Version", "Accept-Language"}
+
+// captureMetadata 按 metadata_headers 采集升级请求头，key 统一小写
+func captureMetadata(r *http.Request) map[string]string {
+	meta := make(map[string]string, len(GlobalConfig.MetadataHeaders))
+	for _, name := range GlobalConfig.MetadataHeaders {
+		if v := r.Header.Get(name); v != "" {
+			meta[strings.ToLower(name)] = v
+		}
+	}
+	// LB 终止 TLS 时由 PROXY v2 头带过来的 TLS 信息
+	if info := proxyInfoFromRequest(r); info != nil && info.TLS {
+		meta["x-proxy-tls-version"] = info.TLSVersion
+		if info.TLSCN != "" {
+			meta["x-proxy-tls-cn"] = info.TLSCN
+		}
+	}
+	// 准入 webhook 附加的标签
+	admissionTags(r, meta)
+	return meta
+}
+
+// matchSelector 判断连接元数据是否满足选择器：所有条件都要满足，值以 * 结尾时按前缀匹配
+func matchSelector(c *Client, selector map[string]string) bool {
+	for key, want := range selector {
+		got, ok := c.meta[strings.ToLower(key)]
+		if !ok {
+			return false
+		}
+		if prefix, isPrefix := strings.CutSuffix(want, "*"); isPrefix {
+			if !strings.HasPrefix(got, prefix) {
+				return false
+			}
+		} else if got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// connectionInfo 管理接口里展示的连接快照
+type connectionInfo struct {
+	ID          string            `json:"id"`
+	UserID      string            `json:"user_id,omitempty"`
+	VisitorID   string            `json:"visitor_id,omitempty"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Device      *DeviceInfo       `json:"device,omitempty"`
+	Channels    []string          `json:"channels,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	RemoteAddr  string            `json:"remote_addr"`
+	ConnectedAt time.Time         `json:"connected_at"`
+}
+
+func snapshotConnection(c *Client) connectionInfo {
+	info := connectionInfo{
+		ID:          c.id,
+		UserID:      c.userID,
+		VisitorID:   c.visitorID,
+		Namespace:   c.namespaceName(),
+		Device:      c.device,
+		Meta:        c.meta,
+		RemoteAddr:  c.remoteAddr,
+		ConnectedAt: c.connectedAt,
+	}
+
+	info.Channels = defaultHub.channelsOf(c)
+	sort.Strings(info.Channels)
+	return info
+}
+
+// connectionSorters /api/admin/connections 支持的排序字段
+var connectionSorters = pageSorter[connectionInfo]{
+	"connected_at": func(c connectionInfo) string { return timeSortKey(c.ConnectedAt) },
+	"user_id":      func(c connectionInfo) string { return c.UserID },
+	"id":           func(c connectionInfo) string { return c.ID },
+}
+
+// connectionFilter 由查询参数构造连接过滤条件：
+// user_id / user_prefix / anonymous / connected_since / connected_before / platform / channel / meta.{header}
+func connectionFilter(q url.Values) (func(*Client) bool, string, error) {
+	userID, userPrefix, platform, channel := q.Get("user_id"), q.Get("user_prefix"), q.Get("platform"), q.Get("channel")
+	anonymous := q.Get("anonymous")
+	if anonymous != "" && anonymous != "true" && anonymous != "false" {
+		return nil, "anonymous", errors.New("anonymous must be true or false")
+	}
+
+	var since, before time.Time
+	if v := q.Get("connected_since"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			return nil, "connected_since", errors.New("connected_since must be RFC3339 or unix seconds")
+		}
+		since = t
+	}
+	if v := q.Get("connected_before"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			return nil, "connected_before", errors.New("connected_before must be RFC3339 or unix seconds")
+		}
+		before = t
+	}
+
+	// meta.x-app-version=3.* 按连接元数据过滤，语义同推送的 selector
+	selector := make(map[string]string)
+	for k, v := range q {
+		if name, ok := strings.CutPrefix(k, "meta."); ok && len(v) > 0 {
+			selector[name] = v[0]
+		}
+	}
+
+	return func(c *Client) bool {
+		switch {
+		case userID != "" && c.userID != userID,
+			userPrefix != "" && !strings.HasPrefix(c.userID, userPrefix),
+			anonymous == "true" && c.userID != "",
+			anonymous == "false" && c.userID == "",
+			!since.IsZero() && c.connectedAt.Before(since),
+			!before.IsZero() && !c.connectedAt.Before(before),
+			platform != "" && (c.device == nil || !strings.EqualFold(c.device.Platform, platform)),
+			channel != "" && !defaultHub.isSubscribed(c, channel):
+			return false
+		}
+		return matchSelector(c, selector)
+	}, "", nil
+}
+
+// adminConnectionsHandler GET /api/admin/connections：列出在线连接，支持过滤、排序和游标分页
+func adminConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, field, err := parsePageParams(q, "connected_at", []string{"connected_at", "user_id", "id"})
+	if err != nil {
+		writeValidationProblem(w, r, field, err.Error())
+		return
+	}
+	match, field, err := connectionFilter(q)
+	if err != nil {
+		writeValidationProblem(w, r, field, err.Error())
+		return
+	}
+
+	clients := defaultHub.clientsMatching(match)
+	list := make([]connectionInfo, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, snapshotConnection(c))
+	}
+	items, next := paginate(list, page, connectionSorters, func(c connectionInfo) string { return c.ID })
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{
+			"total":       len(list),
+			"connections": items,
+			"next_cursor": next,
+		},
+	})
+}
+
+// userGroupInfo 用户组概要
+type userGroupInfo struct {
+	UserID      string `json:"user_id"`
+	Connections int    `json:"connections"`
+}
+
+var userGroupSorters = pageSorter[userGroupInfo]{
+	"user_id":     func(u userGroupInfo) string { return u.UserID },
+	"connections": func(u userGroupInfo) string { return intSortKey(u.Connections) },
+}
+
+// adminUsersHandler GET /api/admin/users：列出在线用户组，支持 user_prefix / min_connections 过滤
+func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, field, err := parsePageParams(q, "user_id", []string{"user_id", "connections"})
+	if err != nil {
+		writeValidationProblem(w, r, field, err.Error())
+		return
+	}
+	minConns := 0
+	if v := q.Get("min_connections"); v != "" {
+		if minConns, err = strconv.Atoi(v); err != nil {
+			writeValidationProblem(w, r, "min_connections", "min_connections must be an integer")
+			return
+		}
+	}
+	prefix := q.Get("user_prefix")
+
+	list := make([]userGroupInfo, 0)
+	for userID, n := range defaultHub.userGroupSizes() {
+		if strings.HasPrefix(userID, prefix) && n >= minConns {
+			list = append(list, userGroupInfo{UserID: userID, Connections: n})
+		}
+	}
+	items, next := paginate(list, page, userGroupSorters, func(u userGroupInfo) string { return u.UserID })
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{
+			"total":       len(list),
+			"users":       items,
+			"next_cursor": next,
+		},
+	})
+}
+
+const (
+	statsDefaultTop = 10
+	statsMaxTop     = 100
+)
+
+// adminStatsHandler GET /api/admin/stats?top=10：连接 / 用户组 / 频道概况、连接数最多的用户组和断开原因统计
+func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	top := statsDefaultTop
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > statsMaxTop {
+			writeValidationProblem(w, r, "top", "top must be an integer between 0 and 100")
+			return
+		}
+		top = n
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{
+			"connections": defaultHub.connectionCount(),
+			"channels":    len(defaultHub.channelSubscribers()),
+			"users":       defaultHub.userGroupStats(top),
+			"disconnects": disconnectCounts(),
+			"api_keys":    apiKeyFingerprints(),
+			"namespaces":  collectNamespaceStats(),
+		},
+	})
+}
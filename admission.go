@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 连接准入 webhook =====
+//
+// 开启后每个建立长连接的请求（WebSocket / SSE / 各兼容协议）在升级前先 POST 给业务后端：
+//
+//	{"ip":"1.2.3.4","path":"/ws","headers":{"User-Agent":["..."]},"query":{"app_version":["3.0"]}}
+//
+// 后端返回 {"allow":true,"user_id":"42","tags":{"plan":"pro"}} 放行，可以顺带指定用户和标签；
+// 返回 {"allow":false,"reason":"plan expired"} 时以 403 拒绝，reason 原样带给客户端。
+// 付费套餐检查、黑名单这类准入逻辑因此都留在业务后端里。
+//
+// user_id 只对原生 WebSocket 和 SSE 生效（连接建立后直接归入该用户，不需要再 identify）；
+// tags 合并进连接元数据，推送时可以用 selector 按标签筛选，对所有协议生效。
+// 配置 secret 后请求带 X-Relay-Timestamp 和 X-Relay-Signature（hex(HMAC-SHA256(secret, timestamp + "\n" + body))）。
+// webhook 超时或返回非 2xx 时按 fail_open 决定放行还是 503。
+
+// AdmissionConfig 连接准入 webhook 配置
+type AdmissionConfig struct {
+	Enabled        bool   `json:"enabled"`
+	URL            string `json:"url"`
+	Secret         string `json:"secret"`          // 可选：请求签名密钥，支持密钥引用
+	TimeoutSeconds int    `json:"timeout_seconds"` // 默认 3
+	FailOpen       bool   `json:"fail_open"`       // webhook 不可用时放行（默认拒绝）
+}
+
+const admissionDefaultTimeout = 3
+
+// admissionRequest 发给 webhook 的请求体
+type admissionRequest struct {
+	IP      string              `json:"ip"`
+	Path    string              `json:"path"`
+	Headers map[string][]string `json:"headers"`
+	Query   map[string][]string `json:"query"`
+}
+
+// admissionResult webhook 的判定结果
+type admissionResult struct {
+	Allow  bool              `json:"allow"`
+	Reason string            `json:"reason"`
+	UserID string            `json:"user_id"`
+	Tags   map[string]string `json:"tags"`
+}
+
+type admissionKey struct{}
+
+var (
+	admissionClient   *http.Client
+	admissionAccepted atomic.Uint64
+	admissionRejected atomic.Uint64
+	admissionErrors   atomic.Uint64
+)
+
+func prepareAdmission(cfg *AdmissionConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.URL == "" {
+		log.Fatalln("❌ admission 已开启但没有配置 url")
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = admissionDefaultTimeout
+	}
+	admissionClient = &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}
+}
+
+// admitRequest upgradeGuard 调用：询问 webhook，拒绝时已写好响应；放行时结果放进请求 context
+func admitRequest(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	cfg := GlobalConfig.Admission
+	res, err := callAdmission(r)
+	if err != nil {
+		admissionErrors.Add(1)
+		log.Printf("⚠️ 准入 webhook 调用失败 from=%s: %v\n", r.RemoteAddr, err)
+		if cfg.FailOpen {
+			return r, true
+		}
+		writeProblem(w, r, http.StatusServiceUnavailable, problemUpstream, "admission webhook unavailable")
+		return nil, false
+	}
+	if !res.Allow {
+		admissionRejected.Add(1)
+		log.Printf("⛔ 准入 webhook 拒绝连接 from=%s path=%s reason=%q\n", r.RemoteAddr, r.URL.Path, res.Reason)
+		detail := res.Reason
+		if detail == "" {
+			detail = "connection rejected"
+		}
+		writeProblem(w, r, http.StatusForbidden, problemForbidden, detail)
+		return nil, false
+	}
+	admissionAccepted.Add(1)
+	return r.WithContext(context.WithValue(r.Context(), admissionKey{}, res)), true
+}
+
+func callAdmission(r *http.Request) (*admissionResult, error) {
+	cfg := GlobalConfig.Admission
+	body, err := json.Marshal(admissionRequest{
+		IP:      hostOf(r.RemoteAddr),
+		Path:    r.URL.Path,
+		Headers: r.Header,
+		Query:   r.URL.Query(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signWebhookRequest(req, liveSecret("admission.secret", cfg.Secret), body)
+
+	resp, err := admissionClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.New("webhook returned " + resp.Status)
+	}
+	var res admissionResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// admissionFromRequest 取 webhook 放行时的结果，未开启或 fail_open 放行时为 nil
+func admissionFromRequest(r *http.Request) *admissionResult {
+	res, _ := r.Context().Value(admissionKey{}).(*admissionResult)
+	return res
+}
+
+// admissionTags 合并进连接元数据的标签（key 小写，和请求头元数据同名时标签优先）
+func admissionTags(r *http.Request, meta map[string]string) {
+	if res := admissionFromRequest(r); res != nil {
+		for k, v := range res.Tags {
+			meta[strings.ToLower(k)] = v
+		}
+	}
+}
+
+// admittedUserID webhook 指定的用户 ID
+func admittedUserID(r *http.Request) string {
+	if res := admissionFromRequest(r); res != nil {
+		return res.UserID
+	}
+	return ""
+}
+
+// registerAdmittedUser 把连接归入 webhook 指定的用户：webhook 已经代表业务后端做过认证，不再校验 token，
+// 单会话策略照常生效；after > 0 时补发序号大于 after 的单用户历史
+func registerAdmittedUser(c *Client, userID string, after uint64) error {
+	if err := checkSingleSession(c, userID); err != nil {
+		return err
+	}
+	beginBacklog(c, after)
+	registerUser(c, userID)
+	if GlobalConfig.AuthExpiry.Enabled {
+		trackAuthExpiry(c, time.Time{})
+	}
+	kickOlderSessions(c, userID)
+	log.Printf("🎫 连接 %s 按准入 webhook 归入 user_id=%s\n", c.id, logUserID(userID))
+	deliverBacklog(c, userID, after)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+)
+
+// ===== 负载均衡亲和性 =====
+//
+// 给 LB 层做粘性路由用的几个小工具：
+//   - hello 消息带 node_id，客户端 / 排障时能知道自己连在哪个节点
+//   - affinity_cookie：升级响应里写一个 {name}={node_id} 的会话 cookie，LB 按 cookie 路由重连
+//   - GET /api/cluster/route?token=X：按用户 ID 在「本节点 + peers」上做 rendezvous 哈希，返回该用户归属的节点，
+//     各节点配置的节点集合一致时结果一致，LB 可以据此把同一用户的连接都路由到同一个节点
+
+// withAffinityCookie 开启 affinity_cookie 时往升级响应头里加上节点 cookie，h 为 nil 时新建
+func withAffinityCookie(r *http.Request, h http.Header) http.Header {
+	cfg := GlobalConfig.Cluster
+	if !cfg.Enabled || cfg.AffinityCookie == "" {
+		return h
+	}
+	if c, err := r.Cookie(cfg.AffinityCookie); err == nil && c.Value == cfg.NodeID {
+		return h
+	}
+	if h == nil {
+		h = http.Header{}
+	}
+	cookie := &http.Cookie{
+		Name:     cfg.AffinityCookie,
+		Value:    cfg.NodeID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   requestIsTLS(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+	h.Add("Set-Cookie", cookie.String())
+	return h
+}
+
+// ownerNode 用 rendezvous（最高随机权重）哈希选出用户归属的节点
+func ownerNode(userID string) string {
+	best, bestScore := "", uint64(0)
+	consider := func(node string) {
+		sum := sha256.Sum256([]byte(node + "\n" + userID))
+		score := binary.BigEndian.Uint64(sum[:8])
+		if best == "" || score > bestScore || (score == bestScore && node < best) {
+			best, bestScore = node, score
+		}
+	}
+	consider(GlobalConfig.Cluster.NodeID)
+	for node := range GlobalConfig.Cluster.Peers {
+		consider(node)
+	}
+	return best
+}
+
+// clusterRouteHandler GET /api/cluster/route?token=X：返回该 token 对应用户的归属节点
+func clusterRouteHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeValidationProblem(w, r, "token", "token is required")
+		return
+	}
+
+	// 开启 client_jwt 时 token 不是用户 ID，按 identify 的规则取出用户 ID 再哈希
+	userID := token
+	if GlobalConfig.ClientJWT.Enabled {
+		claims, err := clientJWTVerifier.verify(token)
+		if err != nil {
+			writeValidationProblem(w, r, "token", "invalid token")
+			return
+		}
+		if userID = claims.str(GlobalConfig.ClientJWT.UserClaim); userID == "" {
+			writeValidationProblem(w, r, "token", "invalid token")
+			return
+		}
+	}
+
+	node := ownerNode(userID)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{
+			"node_id": node,
+			"url":     GlobalConfig.Cluster.Peers[node], // 本节点时为空
+			"local":   node == GlobalConfig.Cluster.NodeID,
+		},
+	})
+}
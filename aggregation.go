@@ -0,0 +1,245 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 上行事件聚合 =====
+//
+// 埋点、心跳上报这类高频小事件，每条都调一次业务后端太浪费。开启 aggregation 后，命中规则的客户端上行事件
+// （原生 / Pusher / Phoenix / Centrifugo）按连接攒起来，每个窗口合并成一次 webhook：
+//
+//	"aggregation": {"enabled":true,"url":"https://backend/relay/events","rules":[{"name":"analytics","events":["analytics.*"],"window_seconds":10}]}
+//
+// 窗口到期时把该规则下所有连接的批次放进同一个请求：
+//
+//	{"rule":"analytics","window_start":1760580000000,"window_end":1760580010000,"node_id":"relay-1",
+//	 "batches":[{"conn_id":"1700000000.3","user_id":"u1","events":[{"event":"analytics.click","data":{...},"ts":1760580001234}],"dropped":0}]}
+//
+// 每个连接每个窗口最多 max_events 条，超出的只计数（dropped）；全局缓冲超过 max_pending_events 时直接丢弃。
+// 事件按第一条命中的规则归类，照常进入 firehose / 抓包；连接断开后已攒下的事件在本窗口结束时照常发出。
+// 发送失败重试 retries 次后丢弃这一批，不会无限堆积。
+
+// AggregationConfig 上行事件聚合配置
+type AggregationConfig struct {
+	Enabled          bool              `json:"enabled"`
+	URL              string            `json:"url"`
+	Secret           string            `json:"secret"`             // 可选：请求签名密钥，支持密钥引用，签名方式与准入 webhook 相同
+	TimeoutSeconds   int               `json:"timeout_seconds"`    // 默认 5
+	Retries          int               `json:"retries"`            // 失败重试次数，默认 2
+	MaxPendingEvents int               `json:"max_pending_events"` // 所有连接缓冲的事件总数上限，默认 100000
+	Rules            []AggregationRule `json:"rules"`
+}
+
+// AggregationRule 一条聚合规则
+type AggregationRule struct {
+	Name          string   `json:"name"`           // 请求里的 rule，默认取第一个事件模式
+	Events        []string `json:"events"`         // 事件名，支持 * / ? 通配
+	WindowSeconds int      `json:"window_seconds"` // 窗口长度，默认 10
+	MaxEvents     int      `json:"max_events"`     // 每个连接每个窗口最多多少条，默认 1000
+}
+
+const (
+	aggregationDefaultTimeout    = 5
+	aggregationDefaultRetries    = 2
+	aggregationDefaultMaxPending = 100000
+	aggregationDefaultWindow     = 10
+	aggregationDefaultMaxEvents  = 1000
+	aggregationRetryBackoff      = time.Second
+)
+
+// aggregatedEvent 批次里的一条事件
+type aggregatedEvent struct {
+	Event   string      `json:"event"`
+	Channel string      `json:"channel,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Ts      int64       `json:"ts"`
+}
+
+// aggregationBatch 一个连接在一个窗口内的事件
+type aggregationBatch struct {
+	ConnID   string            `json:"conn_id"`
+	UserID   string            `json:"user_id,omitempty"`
+	Protocol string            `json:"protocol"`
+	Events   []aggregatedEvent `json:"events"`
+	Dropped  int               `json:"dropped,omitempty"`
+}
+
+// aggregationPost 发给 webhook 的请求体
+type aggregationPost struct {
+	Rule        string              `json:"rule"`
+	WindowStart int64               `json:"window_start"`
+	WindowEnd   int64               `json:"window_end"`
+	NodeID      string              `json:"node_id,omitempty"`
+	Batches     []*aggregationBatch `json:"batches"`
+}
+
+// aggregationWindow 一条规则当前窗口的缓冲
+type aggregationWindow struct {
+	start   time.Time
+	batches map[string]*aggregationBatch // 连接 ID -> 批次
+}
+
+var (
+	aggregationClient *http.Client
+
+	aggregationMu      sync.Mutex
+	aggregationWindows []*aggregationWindow // 与 Rules 一一对应
+	aggregationPending int                  // 所有窗口里缓冲的事件数
+
+	aggregationEvents  atomic.Uint64
+	aggregationDropped atomic.Uint64
+	aggregationPosted  atomic.Uint64
+	aggregationErrors  atomic.Uint64
+)
+
+func prepareAggregation(cfg *AggregationConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.URL == "" {
+		log.Fatalln("❌ aggregation 已开启但没有配置 url")
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = aggregationDefaultTimeout
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = aggregationDefaultRetries
+	} else if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.MaxPendingEvents <= 0 {
+		cfg.MaxPendingEvents = aggregationDefaultMaxPending
+	}
+
+	rules := cfg.Rules[:0]
+	for _, rule := range cfg.Rules {
+		patterns := rule.Events[:0]
+		for _, p := range rule.Events {
+			if _, err := path.Match(p, ""); err != nil {
+				log.Printf("⚠️ aggregation.rules 事件模式无效，已忽略: %s\n", p)
+				continue
+			}
+			patterns = append(patterns, p)
+		}
+		if len(patterns) == 0 {
+			log.Printf("⚠️ aggregation 规则 %q 没有有效的事件模式，已忽略\n", rule.Name)
+			continue
+		}
+		rule.Events = patterns
+		if rule.Name == "" {
+			rule.Name = patterns[0]
+		}
+		if rule.WindowSeconds <= 0 {
+			rule.WindowSeconds = aggregationDefaultWindow
+		}
+		if rule.MaxEvents <= 0 {
+			rule.MaxEvents = aggregationDefaultMaxEvents
+		}
+		rules = append(rules, rule)
+	}
+	cfg.Rules = rules
+	aggregationClient = &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}
+}
+
+// startAggregation 每条规则一个协程，按各自的窗口发送
+func startAggregation() {
+	aggregationWindows = make([]*aggregationWindow, len(GlobalConfig.Aggregation.Rules))
+	for i, rule := range GlobalConfig.Aggregation.Rules {
+		aggregationWindows[i] = &aggregationWindow{start: time.Now(), batches: make(map[string]*aggregationBatch)}
+		go aggregationFlushLoop(i, time.Duration(rule.WindowSeconds)*time.Second)
+	}
+}
+
+// aggregationRuleFor 事件命中的第一条规则，没有命中返回 -1
+func aggregationRuleFor(event string) int {
+	for i, rule := range GlobalConfig.Aggregation.Rules {
+		for _, p := range rule.Events {
+			if ok, _ := path.Match(p, event); ok {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// aggregateInbound 命中规则的上行事件放进该连接当前窗口的批次
+func aggregateInbound(c *Client, protocol, event, channel string, data interface{}) {
+	i := aggregationRuleFor(event)
+	if i < 0 {
+		return
+	}
+	rule := GlobalConfig.Aggregation.Rules[i]
+
+	aggregationMu.Lock()
+	defer aggregationMu.Unlock()
+
+	w := aggregationWindows[i]
+	b, ok := w.batches[c.id]
+	if !ok {
+		b = &aggregationBatch{ConnID: c.id, UserID: c.userID, Protocol: protocol}
+		w.batches[c.id] = b
+	}
+	if len(b.Events) >= rule.MaxEvents || aggregationPending >= GlobalConfig.Aggregation.MaxPendingEvents {
+		b.Dropped++
+		aggregationDropped.Add(1)
+		return
+	}
+	if c.userID != "" {
+		b.UserID = c.userID // 窗口内才 identify 的连接以最新的为准
+	}
+	b.Events = append(b.Events, aggregatedEvent{Event: event, Channel: channel, Data: data, Ts: time.Now().UnixMilli()})
+	aggregationPending++
+	aggregationEvents.Add(1)
+}
+
+func aggregationFlushLoop(i int, window time.Duration) {
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+	for range ticker.C {
+		flushAggregation(i, time.Now())
+	}
+}
+
+// flushAggregation 结束规则 i 的当前窗口，有事件时发一次 webhook
+func flushAggregation(i int, now time.Time) {
+	aggregationMu.Lock()
+	w := aggregationWindows[i]
+	post := aggregationPost{
+		Rule:        GlobalConfig.Aggregation.Rules[i].Name,
+		WindowStart: w.start.UnixMilli(),
+		WindowEnd:   now.UnixMilli(),
+		Batches:     make([]*aggregationBatch, 0, len(w.batches)),
+	}
+	for _, b := range w.batches {
+		aggregationPending -= len(b.Events)
+		post.Batches = append(post.Batches, b)
+	}
+	aggregationWindows[i] = &aggregationWindow{start: now, batches: make(map[string]*aggregationBatch)}
+	aggregationMu.Unlock()
+
+	if len(post.Batches) == 0 {
+		return
+	}
+	if GlobalConfig.Cluster.Enabled {
+		post.NodeID = GlobalConfig.Cluster.NodeID
+	}
+	sendAggregation(post)
+}
+
+// sendAggregation 发送一个窗口的批次，失败按配置重试
+func sendAggregation(post aggregationPost) {
+	cfg := GlobalConfig.Aggregation
+	retry := webhookRetry{client: aggregationClient, retries: cfg.Retries, backoff: aggregationRetryBackoff}
+	if err := deliverWebhook(cfg.URL, liveSecret("aggregation.secret", cfg.Secret), post, retry); err != nil {
+		aggregationErrors.Add(1)
+		log.Printf("⚠️ 聚合 webhook 发送失败 rule=%s 连接数=%d，本批丢弃: %v\n", post.Rule, len(post.Batches), err)
+		return
+	}
+	aggregationPosted.Add(1)
+}
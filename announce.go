@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== 系统公告 =====
+//
+// POST /api/admin/announce（admin 认证）给在线连接广播系统级事件，事件名必须以 system. 开头：
+//
+//	{"event":"system.maintenance","data":{"at":"2026-10-16T22:00:00Z"},"require_ack":true,"ack_timeout_seconds":10}
+//
+// system. 是保留的事件命名空间：客户端上行（原生 / Pusher / Phoenix）和 /api/push、/v1/push 都不能使用，
+// 客户端据此可以信任 system.* 事件一定来自运维。
+// require_ack 为 true 时消息带 "id"，原生客户端回 {"type":"ack","id":"..."} 确认；接口等到全部确认或超时后返回
+// 送达数和确认数，之后也可以用 GET /api/admin/announcements/{id} 查询。公告不进用户历史，之后才连上的连接收不到。
+
+const (
+	systemEventPrefix         = "system."
+	announceDefaultAckTimeout = 10
+	announceMaxAckTimeout     = 60
+	announceRetention         = time.Hour // 公告记录保留时间
+	announceMaxKept           = 100       // 最多保留的公告记录数
+	announceIDPrefix          = "sys_"
+
+	errCodeReservedEvent   = "reserved_event"
+	systemEventReservedMsg = "event names starting with system. are reserved for /api/admin/announce"
+)
+
+// announceRequest POST /api/admin/announce 的请求体
+type announceRequest struct {
+	Event             string            `json:"event"`
+	Data              interface{}       `json:"data"`
+	RequireAck        bool              `json:"require_ack"`
+	AckTimeoutSeconds int               `json:"ack_timeout_seconds"` // 等待确认的时间，默认 10，最大 60
+	Selector          map[string]string `json:"selector"`            // 可选：按连接元数据过滤，同推送接口
+	Namespace         string            `json:"namespace"`           // 可选：只发给该命名空间的连接
+}
+
+// announcement 一次公告及其确认情况
+type announcement struct {
+	ID         string
+	Event      string
+	SentAt     time.Time
+	RequireAck bool
+	Delivered  int
+	Acked      int
+
+	sent       bool // 广播已完成，Delivered 是最终值
+	ackedConns map[string]struct{}
+	done       chan struct{} // 广播完成且全部确认时关闭
+}
+
+// announcementStatus 接口返回的公告状态
+type announcementStatus struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	SentAt     time.Time `json:"sent_at"`
+	RequireAck bool      `json:"require_ack"`
+	Delivered  int       `json:"delivered"`
+	Acked      int       `json:"acked"`
+	Pending    int       `json:"pending"`
+}
+
+var (
+	announcementsMu    sync.Mutex
+	announcements      = make(map[string]*announcement)
+	announcementsOrder []string
+)
+
+// isSystemEvent 是否是保留的系统事件名
+func isSystemEvent(event string) bool {
+	return strings.HasPrefix(event, systemEventPrefix)
+}
+
+func (a *announcement) statusLocked() announcementStatus {
+	st := announcementStatus{
+		ID: a.ID, Event: a.Event, SentAt: a.SentAt, RequireAck: a.RequireAck,
+		Delivered: a.Delivered, Acked: a.Acked,
+	}
+	if a.RequireAck && a.Delivered > a.Acked {
+		st.Pending = a.Delivered - a.Acked
+	}
+	return st
+}
+
+// ackAnnouncement 原生客户端的 ack，同一连接重复 ack 只计一次
+func ackAnnouncement(c *Client, id string) {
+	if !strings.HasPrefix(id, announceIDPrefix) {
+		return
+	}
+	announcementsMu.Lock()
+	defer announcementsMu.Unlock()
+
+	a, ok := announcements[id]
+	if !ok || !a.RequireAck {
+		return
+	}
+	if _, dup := a.ackedConns[c.id]; dup {
+		return
+	}
+	a.ackedConns[c.id] = struct{}{}
+	a.Acked++
+	a.checkDoneLocked()
+}
+
+// checkDoneLocked 广播完成且全部确认时唤醒等待的请求
+func (a *announcement) checkDoneLocked() {
+	if !a.sent || a.Acked < a.Delivered {
+		return
+	}
+	select {
+	case <-a.done:
+	default:
+		close(a.done)
+	}
+}
+
+// pruneAnnouncementsLocked 淘汰过期和超出数量的公告记录
+func pruneAnnouncementsLocked(now time.Time) {
+	for len(announcementsOrder) > 0 {
+		a := announcements[announcementsOrder[0]]
+		if len(announcementsOrder) <= announceMaxKept && (a == nil || now.Sub(a.SentAt) < announceRetention) {
+			return
+		}
+		delete(announcements, announcementsOrder[0])
+		announcementsOrder = announcementsOrder[1:]
+	}
+}
+
+// adminAnnounceHandler POST /api/admin/announce
+func adminAnnounceHandler(w http.ResponseWriter, r *http.Request) {
+	var req announceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	if !isSystemEvent(req.Event) || len(req.Event) == len(systemEventPrefix) {
+		writeValidationProblem(w, r, "event", "event must start with system., e.g. system.maintenance")
+		return
+	}
+	if req.Namespace != "" && namespaces[req.Namespace] == nil {
+		writeValidationProblem(w, r, "namespace", "unknown namespace "+req.Namespace)
+		return
+	}
+	timeout := req.AckTimeoutSeconds
+	switch {
+	case timeout < 0 || timeout > announceMaxAckTimeout:
+		writeValidationProblem(w, r, "ack_timeout_seconds", "ack_timeout_seconds must be between 0 and 60")
+		return
+	case timeout == 0:
+		timeout = announceDefaultAckTimeout
+	}
+
+	a := &announcement{
+		ID:         announceIDPrefix + randomHex(12),
+		Event:      req.Event,
+		SentAt:     time.Now(),
+		RequireAck: req.RequireAck,
+		ackedConns: make(map[string]struct{}),
+		done:       make(chan struct{}),
+	}
+	msg := WSMessage{Event: req.Event, Data: req.Data}
+	if req.RequireAck {
+		msg.ID = a.ID
+	}
+	var match func(*Client) bool
+	if len(req.Selector) > 0 || req.Namespace != "" {
+		match = func(c *Client) bool {
+			if req.Namespace != "" && c.namespaceName() != req.Namespace {
+				return false
+			}
+			return matchSelector(c, req.Selector)
+		}
+	}
+
+	// 先登记再发送，客户端 ack 比 broadcast 返回得早也能记上
+	announcementsMu.Lock()
+	announcements[a.ID] = a
+	announcementsOrder = append(announcementsOrder, a.ID)
+	pruneAnnouncementsLocked(a.SentAt)
+	announcementsMu.Unlock()
+
+	delivered := broadcastMatching(msg, match)
+
+	announcementsMu.Lock()
+	a.Delivered = delivered
+	a.sent = true
+	a.checkDoneLocked()
+	announcementsMu.Unlock()
+	log.Printf("📢 系统公告 %s id=%s 送达连接数=%d require_ack=%v\n", a.Event, a.ID, delivered, a.RequireAck)
+
+	timedOut := false
+	if req.RequireAck {
+		select {
+		case <-a.done:
+		case <-time.After(time.Duration(timeout) * time.Second):
+			timedOut = true
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	announcementsMu.Lock()
+	st := a.statusLocked()
+	announcementsMu.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{
+			"announcement": st,
+			"timed_out":    timedOut,
+		},
+	})
+}
+
+// adminAnnouncementHandler GET /api/admin/announcements/{id}
+func adminAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	announcementsMu.Lock()
+	a, ok := announcements[r.PathValue("id")]
+	var st announcementStatus
+	if ok {
+		st = a.statusLocked()
+	}
+	announcementsMu.Unlock()
+
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "announcement not found")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": st,
+	})
+}
+
+// adminAnnouncementsHandler GET /api/admin/announcements：最近的公告，新的在前
+func adminAnnouncementsHandler(w http.ResponseWriter, r *http.Request) {
+	announcementsMu.Lock()
+	pruneAnnouncementsLocked(time.Now())
+	list := make([]announcementStatus, 0, len(announcements))
+	for _, a := range announcements {
+		list = append(list, a.statusLocked())
+	}
+	announcementsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].SentAt.After(list[j].SentAt) })
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{"announcements": list},
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// ===== 默认 API Key 保护 =====
+//
+// 内置的默认 API Key 随源码公开，等于没有认证：
+//   - 首次运行创建 config.json 时不再写入默认值，而是随机生成一个（环境变量 RELAY_API_KEY 优先）
+//   - 生产模式（环境变量 RELAY_ENV=production）下检测到默认值直接拒绝启动，
+//     确实需要（例如内网演示环境）时配置 "allow_insecure_default_key": true 放行，但每次启动都会提醒轮换
+//   - 非生产模式下只记为配置问题（严格模式下同样拒绝启动）
+//
+// 日志和 /api/admin/stats 里只出现 key 的指纹（sha256 前 6 字节），从不输出 key 本身，
+// 用来核对各实例是否用的同一个 key、轮换是否生效。
+
+const productionEnv = "RELAY_ENV"
+
+// defaultAPIKey 内置的默认 API Key，随源码公开，不能用于生产
+const defaultAPIKey = "U2FsdGVkX18ucQzBA+ozhc3ySrVZ"
+
+// productionMode 是否运行在生产模式
+func productionMode() bool {
+	switch strings.ToLower(os.Getenv(productionEnv)) {
+	case "production", "prod":
+		return true
+	}
+	return false
+}
+
+// generateAPIKey 随机生成一个 API Key
+func generateAPIKey() string {
+	return randomHex(24)
+}
+
+// keyFingerprint key 的指纹，可以放心打日志
+func keyFingerprint(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// defaultKeyUsages 配置里仍在使用默认 key 的位置（api_key 以及各认证链 static 的 keys）
+func defaultKeyUsages() []string {
+	var found []string
+	if GlobalConfig.APIKey == defaultAPIKey {
+		found = append(found, "api_key")
+	}
+	chains := []struct {
+		name string
+		cfgs []AuthProviderConfig
+	}{
+		{"auth.push", GlobalConfig.Auth.Push},
+		{"auth.admin", GlobalConfig.Auth.Admin},
+		{"auth.firehose", GlobalConfig.Auth.Firehose},
+	}
+	for _, chain := range chains {
+		for i, cfg := range chain.cfgs {
+			for _, k := range cfg.Keys {
+				if k == defaultAPIKey {
+					found = append(found, fmt.Sprintf("%s[%d].keys", chain.name, i))
+				}
+			}
+		}
+	}
+	return found
+}
+
+// checkDefaultAPIKey 检查默认 key：生产模式下未显式放行直接退出，否则返回配置问题
+func checkDefaultAPIKey() []string {
+	usages := defaultKeyUsages()
+	if len(usages) == 0 {
+		return nil
+	}
+	where := strings.Join(usages, "、")
+
+	if GlobalConfig.AllowInsecureDefaultKey {
+		log.Printf("⚠️ %s 仍是内置默认 API Key（allow_insecure_default_key 已开启），任何人都能调用推送和管理接口，请尽快轮换\n", where)
+		return nil
+	}
+	if productionMode() {
+		log.Fatalf("❌ 生产模式（%s=%s）下拒绝使用内置默认 API Key：%s。请换成随机 key，或显式配置 allow_insecure_default_key: true\n",
+			productionEnv, os.Getenv(productionEnv), where)
+	}
+	return []string{where + " 仍是内置默认值，任何人都能调用推送和管理接口"}
+}
+
+// apiKeyFingerprint 统计接口里的一个 key
+type apiKeyFingerprint struct {
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	Default     bool   `json:"default,omitempty"`
+}
+
+// apiKeyFingerprints 当前生效的各个 key 的指纹
+func apiKeyFingerprints() []apiKeyFingerprint {
+	key := liveSecret("api_key", GlobalConfig.APIKey)
+	out := []apiKeyFingerprint{{Name: "api_key", Fingerprint: keyFingerprint(key), Default: key == defaultAPIKey}}
+
+	for _, endpoint := range []string{"push", "admin", "firehose"} {
+		for i, a := range authChains[endpoint] {
+			s, ok := a.(*staticKeyAuthenticator)
+			if !ok {
+				continue
+			}
+			for j, secret := range s.keys {
+				k := secret.get()
+				out = append(out, apiKeyFingerprint{
+					Name:        fmt.Sprintf("auth.%s[%d].keys[%d]", endpoint, i, j),
+					Fingerprint: keyFingerprint(k),
+					Default:     k == defaultAPIKey,
+				})
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ===== 版本化 HTTP API（/v1） =====
+//
+// /v1 下的接口契约保持稳定：字段只增不改，破坏性变更放到 /v2。
+// 配置的 push_path（默认 /api/push）作为旧版别名继续可用，请求体相同，响应保持原来的格式；
+// /v1/push 的响应带投递结果（立即发送时的 delivered，延迟发送时的 job 对象），支持 Idempotency-Key（见 idempotency.go）。
+// 所有 /v1 接口走 push 认证链（开启 push_signing 时同样需要签名），错误统一为 problem+json。
+
+const (
+	v1PushPath         = "/v1/push"
+	v1PresenceMaxUsers = 100
+)
+
+func registerV1Routes(mux *http.ServeMux) {
+	mux.Handle("POST "+v1PushPath, protectPush(withIdempotency(v1PushHandler)))
+	mux.Handle("GET /v1/presence", protectPush(v1PresenceHandler))
+	mux.Handle("GET /v1/jobs", protectPush(v1JobsHandler))
+	mux.Handle("GET /v1/jobs/{id}", protectPush(v1JobHandler))
+	mux.Handle("DELETE /v1/jobs/{id}", protectPush(v1JobHandler))
+	mux.Handle("GET /v1/recurring-jobs", protectPush(v1RecurringJobsHandler))
+	mux.Handle("GET /v1/recurring-jobs/{id}", protectPush(v1RecurringJobHandler))
+	mux.Handle("DELETE /v1/recurring-jobs/{id}", protectPush(v1RecurringJobHandler))
+	if GlobalConfig.AckRetry.Enabled {
+		mux.Handle("GET /v1/messages/{id}/delivery", protectPush(v1DeliveryHandler))
+		go ackRetryLoop()
+	}
+}
+
+// protectPush 推送类接口的公共中间件：请求体上限 → push 认证链 → 请求签名（可选）
+func protectPush(h http.HandlerFunc) http.Handler {
+	var next http.Handler = h
+	if GlobalConfig.PushSigning.Enabled {
+		next = checkPushSignature(next)
+	}
+	return limitBody(pushMaxBodyBytes, checkAuth("push", next))
+}
+
+// writeV1 输出 {"code":0,"msg":"ok","data":...}
+func writeV1(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": data,
+	})
+}
+
+// v1PushHandler POST /v1/push：立即发送返回 200 + delivered，延迟发送返回 202 + job，
+// 开启 push_queue 时入队返回 202 + message_id（带 sync 时等发送完返回 200 + delivered）
+func v1PushHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := preparePush(w, r)
+	if !ok {
+		return
+	}
+	respondV1Push(w, r, p)
+}
+
+// respondV1Push 发送（或排队 / 计划）校验通过的推送，并按 v1 格式写响应
+func respondV1Push(w http.ResponseWriter, r *http.Request, p *preparedPush) {
+	result := map[string]interface{}{
+		"event_name": p.body.EventName,
+		"broadcast":  p.broadcast(),
+	}
+	if len(p.targets) > 0 {
+		result["target_user_ids"] = p.targets
+	}
+	if p.target != "" {
+		result["target_user_id"] = p.target
+	}
+	if p.body.Channel != "" {
+		result["channel"] = p.body.Channel
+	}
+	if p.body.Group != "" {
+		result["group"] = p.body.Group
+	}
+
+	if p.cron != nil {
+		job, err := scheduleRecurring(p)
+		if err != nil {
+			writeRecurringLimit(w, r)
+			return
+		}
+		result["recurring_job"] = job
+		writeV1(w, http.StatusCreated, result)
+		return
+	}
+	if !p.runAt.IsZero() {
+		result["job"] = schedulePush(p)
+		writeV1(w, http.StatusAccepted, result)
+		return
+	}
+	if GlobalConfig.PushQueue.Enabled && !p.message.Ephemeral {
+		if reason := enqueuePush(p); reason != "" {
+			writeBackpressure(w, r, reason)
+			return
+		}
+		result["message_id"] = p.message.ID
+		if p.done == nil {
+			result["queued"] = true
+			writeV1(w, http.StatusAccepted, result)
+			return
+		}
+		delivered, ok := awaitQueuedPush(r, p)
+		if !ok {
+			return
+		}
+		result["delivered"] = delivered
+		if p.userCounts != nil {
+			result["users"] = p.userCounts
+		}
+		writeV1(w, http.StatusOK, result)
+		return
+	}
+	result["delivered"] = p.emit()
+	if p.userCounts != nil {
+		result["users"] = p.userCounts
+	}
+	if p.message.ID != "" {
+		result["message_id"] = p.message.ID
+	}
+	writeV1(w, http.StatusOK, result)
+}
+
+// userPresence 用户在线状态
+type userPresence struct {
+	Online      bool `json:"online"`
+	Connections int  `json:"connections"`
+}
+
+// v1PresenceHandler GET /v1/presence?user_id=a&user_id=b
+func v1PresenceHandler(w http.ResponseWriter, r *http.Request) {
+	ids := r.URL.Query()["user_id"]
+	switch {
+	case len(ids) == 0:
+		writeValidationProblem(w, r, "user_id", "at least one user_id is required")
+		return
+	case len(ids) > v1PresenceMaxUsers:
+		writeValidationProblem(w, r, "user_id", "too many user_id values")
+		return
+	}
+
+	users := make(map[string]userPresence, len(ids))
+	for _, id := range ids {
+		n := len(defaultHub.userConns(id))
+		users[id] = userPresence{Online: n > 0, Connections: n}
+	}
+	writeV1(w, http.StatusOK, map[string]interface{}{"users": users})
+}
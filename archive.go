@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ===== 消息归档（S3 / MinIO / 本地文件） =====
+//
+// 订阅流量总线（tap.go），把下发的每条消息（可选带上客户端上行）攒成一批，
+// 按间隔写成 gzip 压缩的 NDJSON 对象上传到 S3 兼容存储，用于合规留存和离线分析；
+// 也可以（或同时）写到本地轮转文件，见 archivefile.go。
+// 对象 key：{prefix}{yyyy}/{mm}/{dd}/{HH}/{node}-{unix_ms}-{rand}.ndjson.gz，每行一个 trafficEvent。
+//
+// 上传失败的批次留在内存里下个周期重试，最多保留 archiveMaxPending 批，再多就丢最旧的。
+// 进程异常退出会丢掉最后一个周期内还没上传的数据。
+
+// ArchiveConfig 消息归档配置
+type ArchiveConfig struct {
+	Enabled         bool               `json:"enabled"`
+	IntervalSeconds int                `json:"interval_seconds"` // 上传间隔，默认 60
+	MaxBatch        int                `json:"max_batch"`        // 单个对象最多消息数，攒够立即上传，默认 10000
+	IncludeInbound  bool               `json:"include_inbound"`  // 同时归档客户端上行事件
+	RedactFields    []string           `json:"redact_fields"`    // 在 redaction.fields 之外，归档额外抹掉的 data 字段路径
+	S3              ArchiveS3Config    `json:"s3"`
+	Local           ArchiveLocalConfig `json:"local"`
+}
+
+// ArchiveS3Config S3 兼容存储，Bucket 为空表示不上传 S3
+type ArchiveS3Config struct {
+	Endpoint  string `json:"endpoint"` // 默认 https://s3.{region}.amazonaws.com，MinIO 填 http://minio:9000
+	Region    string `json:"region"`   // 默认取 aws.region / AWS_REGION
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`     // 对象 key 前缀，默认 relay/
+	AccessKey string `json:"access_key"` // 为空时走 AWS 凭证链（环境变量 / 容器凭证 / IRSA）
+	SecretKey string `json:"secret_key"` // 支持 vault:// 等密钥引用
+}
+
+const (
+	archiveDefaultInterval = 60
+	archiveDefaultMaxBatch = 10000
+	archiveDefaultPrefix   = "relay/"
+	archiveMaxPending      = 16
+	archiveUploadTimeout   = 60 * time.Second
+)
+
+// archiveBatch 一个待上传的对象
+type archiveBatch struct {
+	Key   string
+	Body  []byte
+	Count int
+}
+
+var archiveHTTP = &http.Client{Timeout: archiveUploadTimeout}
+
+func initArchive() {
+	cfg := &GlobalConfig.Archive
+	if cfg.IntervalSeconds <= 0 {
+		cfg.IntervalSeconds = archiveDefaultInterval
+	}
+	if cfg.MaxBatch <= 0 {
+		cfg.MaxBatch = archiveDefaultMaxBatch
+	}
+	if cfg.S3.Bucket == "" && cfg.Local.Dir == "" {
+		log.Fatalln("❌ archive 配置错误：s3.bucket 和 local.dir 至少配置一个")
+	}
+
+	if cfg.S3.Bucket != "" {
+		if cfg.S3.Prefix == "" {
+			cfg.S3.Prefix = archiveDefaultPrefix
+		}
+		if cfg.S3.Region == "" {
+			cfg.S3.Region = awsRegion()
+		}
+		if cfg.S3.Region == "" {
+			log.Fatalln("❌ archive 配置错误：s3.region（或 aws.region / AWS_REGION）不能为空")
+		}
+		if cfg.S3.Endpoint == "" {
+			cfg.S3.Endpoint = "https://s3." + cfg.S3.Region + ".amazonaws.com"
+		}
+		cfg.S3.Endpoint = strings.TrimRight(cfg.S3.Endpoint, "/")
+		log.Printf("✅ 消息归档到 S3：%s/%s/%s，每 %ds 上传一次\n",
+			cfg.S3.Endpoint, cfg.S3.Bucket, cfg.S3.Prefix, cfg.IntervalSeconds)
+	}
+
+	if cfg.Local.Dir != "" {
+		a, err := openLocalArchive(cfg.Local)
+		if err != nil {
+			log.Fatalln("❌ 打开本地归档目录失败:", err)
+		}
+		archiveFiles = a
+		log.Printf("✅ 消息归档到本地：%s（单文件 %dMB，总量 %dMB，保留 %d 小时）\n",
+			a.dir, a.cfg.MaxFileMB, a.cfg.MaxTotalMB, a.cfg.MaxAgeHours)
+	}
+
+	go archiveLoop(*cfg)
+}
+
+// archiveLoop 消费流量总线，按间隔或条数切批，交给上传协程，上传慢不影响消费
+func archiveLoop(cfg ArchiveConfig) {
+	sub := subscribeTrafficRaw("archive", func(ev *trafficEvent) bool {
+		if ev.Ephemeral {
+			return false
+		}
+		return ev.Direction == trafficOutbound || cfg.IncludeInbound
+	})
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	toS3 := cfg.S3.Bucket != ""
+	batches := make(chan archiveBatch, archiveMaxPending)
+	if toS3 {
+		go archiveUploader(cfg.S3, interval, batches)
+	}
+
+	var (
+		buf     bytes.Buffer
+		zw      = gzip.NewWriter(&buf)
+		count   int
+		dropped uint64
+	)
+	flush := func() {
+		if d := sub.dropped.Load(); d != dropped {
+			log.Printf("⚠️ 归档消费跟不上，累计丢弃 %d 条\n", d)
+			dropped = d
+		}
+		if count > 0 {
+			_ = zw.Close()
+			batches <- archiveBatch{
+				Key:   archiveObjectKey(cfg.S3.Prefix, time.Now()),
+				Body:  bytes.Clone(buf.Bytes()),
+				Count: count,
+			}
+			buf.Reset()
+			zw.Reset(&buf)
+			count = 0
+		}
+	}
+
+	for {
+		select {
+		case ev := <-sub.C:
+			ev.Data = redactPaths(ev.Data, cfg.RedactFields)
+			line, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			line = append(line, '\n')
+			if archiveFiles != nil {
+				archiveFiles.write(line)
+			}
+			if toS3 {
+				_, _ = zw.Write(line)
+				count++
+				if count >= cfg.MaxBatch {
+					flush()
+				}
+			}
+		case <-ticker.C:
+			flush()
+			if archiveFiles != nil {
+				archiveFiles.flush()
+				archiveFiles.enforceRetention()
+			}
+		}
+	}
+}
+
+// archiveUploader 上传切好的批次，失败的留着每个周期重试
+func archiveUploader(cfg ArchiveS3Config, interval time.Duration, batches <-chan archiveBatch) {
+	retry := time.NewTicker(interval)
+	defer retry.Stop()
+
+	var pending []archiveBatch
+	for {
+		select {
+		case b := <-batches:
+			pending = queueArchiveBatch(pending, b)
+		case <-retry.C:
+			if len(pending) == 0 {
+				continue
+			}
+		}
+		pending = uploadArchivePending(cfg, pending)
+	}
+}
+
+func queueArchiveBatch(pending []archiveBatch, b archiveBatch) []archiveBatch {
+	pending = append(pending, b)
+	if over := len(pending) - archiveMaxPending; over > 0 {
+		for _, old := range pending[:over] {
+			log.Printf("❌ 归档积压过多，丢弃 %s（%d 条）\n", old.Key, old.Count)
+		}
+		pending = pending[over:]
+	}
+	return pending
+}
+
+// uploadArchivePending 按顺序上传积压的批次，遇到失败就停，返回剩下的留到下个周期再试
+func uploadArchivePending(cfg ArchiveS3Config, pending []archiveBatch) []archiveBatch {
+	for len(pending) > 0 {
+		b := pending[0]
+		if err := s3PutObject(cfg, b.Key, b.Body); err != nil {
+			log.Printf("⚠️ 归档上传失败 %s: %v（%d 批待重试）\n", b.Key, err, len(pending))
+			return pending
+		}
+		log.Printf("📦 归档已上传 %s（%d 条，%d 字节）\n", b.Key, b.Count, len(b.Body))
+		pending = pending[1:]
+	}
+	return nil
+}
+
+func archiveObjectKey(prefix string, now time.Time) string {
+	now = now.UTC()
+	return fmt.Sprintf("%s%s/%s-%d-%s.ndjson.gz", prefix, now.Format("2006/01/02/15"), archiveNodeName(), now.UnixMilli(), randomHex(4))
+}
+
+func archiveNodeName() string {
+	if GlobalConfig.Cluster.NodeID != "" {
+		return GlobalConfig.Cluster.NodeID
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "relay"
+}
+
+// s3PutObject 以 path-style 地址 PUT 一个对象，兼容 AWS S3 和 MinIO
+func s3PutObject(cfg ArchiveS3Config, key string, body []byte) error {
+	creds, err := s3Credentials(cfg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, cfg.Endpoint+"/"+cfg.Bucket+"/"+key, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	awsSignV4(req, body, creds, cfg.Region, "s3", time.Now().UTC())
+
+	resp, err := archiveHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 PutObject: %s %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+func s3Credentials(cfg ArchiveS3Config) (*awsCredentials, error) {
+	if cfg.AccessKey != "" {
+		return &awsCredentials{
+			AccessKeyID:     cfg.AccessKey,
+			SecretAccessKey: liveSecret("archive.s3.secret_key", cfg.SecretKey),
+		}, nil
+	}
+	return awsCurrentCredentials(cfg.Region)
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== 本地归档文件（轮转 + 保留） =====
+//
+// S3 归档的轻量替代：同样的 NDJSON 行直接追加写到本地目录，文件超过 max_file_mb 就轮转，
+// 超过 max_age_hours 或目录总量超过 max_total_mb 的旧文件会被删除。
+// 文件名 archive-{UTC 时间}.ndjson 按字典序即时间顺序，GET /api/history 在保留窗口内按条件查询。
+
+// ArchiveLocalConfig 本地归档文件配置，Dir 为空表示不写本地文件
+type ArchiveLocalConfig struct {
+	Dir         string `json:"dir"`           // 归档目录，相对路径基于程序所在目录
+	MaxFileMB   int    `json:"max_file_mb"`   // 单个文件上限，超过即轮转，默认 64
+	MaxTotalMB  int    `json:"max_total_mb"`  // 目录总量上限，超出从最旧的文件开始删，默认 1024
+	MaxAgeHours int    `json:"max_age_hours"` // 文件最长保留时间，默认 168（7 天）
+}
+
+const (
+	archiveFileDefaultMaxMB    = 64
+	archiveFileDefaultTotalMB  = 1024
+	archiveFileDefaultMaxAge   = 168
+	archiveFilePrefix          = "archive-"
+	archiveFileSuffix          = ".ndjson"
+	archiveQueryDefaultLimit   = 100
+	archiveQueryMaxLimit       = 1000
+	archiveFileMaxLineBytes    = 4 << 20
+	archiveFileTimestampFormat = "20060102T150405.000"
+)
+
+// localArchive 当前正在写的归档文件
+type localArchive struct {
+	mu   sync.Mutex
+	cfg  ArchiveLocalConfig
+	dir  string
+	name string // 当前文件名
+	file *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+var archiveFiles *localArchive
+
+func openLocalArchive(cfg ArchiveLocalConfig) (*localArchive, error) {
+	if cfg.MaxFileMB <= 0 {
+		cfg.MaxFileMB = archiveFileDefaultMaxMB
+	}
+	if cfg.MaxTotalMB <= 0 {
+		cfg.MaxTotalMB = archiveFileDefaultTotalMB
+	}
+	if cfg.MaxAgeHours <= 0 {
+		cfg.MaxAgeHours = archiveFileDefaultMaxAge
+	}
+	dir := cfg.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(getCurrentDir(), dir)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	a := &localArchive{cfg: cfg, dir: dir}
+	if err := a.rotate(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// rotate 关闭当前文件并新开一个，调用方持有 mu（或尚未发布）
+func (a *localArchive) rotate() error {
+	if a.file != nil {
+		_ = a.w.Flush()
+		_ = a.file.Close()
+	}
+	name := archiveFilePrefix + time.Now().UTC().Format(archiveFileTimestampFormat) + archiveFileSuffix
+	f, err := os.OpenFile(filepath.Join(a.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	a.name, a.file, a.w, a.size = name, f, bufio.NewWriter(f), 0
+	return nil
+}
+
+// write 追加一行，超过单文件上限先轮转
+func (a *localArchive) write(line []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.size > 0 && a.size+int64(len(line)) > int64(a.cfg.MaxFileMB)<<20 {
+		if err := a.rotate(); err != nil {
+			log.Println("❌ 归档文件轮转失败:", err)
+			return
+		}
+	}
+	n, err := a.w.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		log.Println("⚠️ 写归档文件失败:", err)
+	}
+}
+
+func (a *localArchive) flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.w.Flush(); err != nil {
+		log.Println("⚠️ 写归档文件失败:", err)
+	}
+}
+
+// listFiles 按时间顺序列出目录里的归档文件
+func (a *localArchive) listFiles() []os.DirEntry {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		log.Println("⚠️ 读取归档目录失败:", err)
+		return nil
+	}
+	files := entries[:0]
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), archiveFilePrefix) && strings.HasSuffix(e.Name(), archiveFileSuffix) {
+			files = append(files, e)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files
+}
+
+// enforceRetention 删除过期文件，再从最旧的开始删直到总量不超限；当前文件永远保留
+func (a *localArchive) enforceRetention() {
+	a.mu.Lock()
+	current := a.name
+	a.mu.Unlock()
+
+	cutoff := time.Now().Add(-time.Duration(a.cfg.MaxAgeHours) * time.Hour)
+	type fileInfo struct {
+		name string
+		size int64
+	}
+	var kept []fileInfo
+	var total int64
+	for _, e := range a.listFiles() {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if e.Name() != current && info.ModTime().Before(cutoff) {
+			a.remove(e.Name(), "过期")
+			continue
+		}
+		kept = append(kept, fileInfo{e.Name(), info.Size()})
+		total += info.Size()
+	}
+
+	limit := int64(a.cfg.MaxTotalMB) << 20
+	for _, f := range kept {
+		if total <= limit || f.name == current {
+			break
+		}
+		a.remove(f.name, "超出总量")
+		total -= f.size
+	}
+}
+
+func (a *localArchive) remove(name, reason string) {
+	if err := os.Remove(filepath.Join(a.dir, name)); err != nil {
+		log.Printf("⚠️ 删除归档文件 %s 失败: %v\n", name, err)
+		return
+	}
+	log.Printf("🧹 删除归档文件 %s（%s）\n", name, reason)
+}
+
+// ===== 数据删除 =====
+
+// eraseUser 按策略重写所有归档文件：delete 删掉该用户的行，redact 保留行但抹掉 user_id 和 data。
+// 重写期间持有 mu，归档写入会短暂阻塞；文件修改时间保持不变，不影响按时间的保留策略。
+func (a *localArchive) eraseUser(userID string, redact bool) (files, entries int, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.w.Flush(); err != nil {
+		return 0, 0, err
+	}
+	quoted, _ := json.Marshal(userID)
+	needle := append([]byte(`"user_id":`), quoted...)
+
+	for _, e := range a.listFiles() {
+		n, err := a.eraseUserInFile(e, userID, needle, redact)
+		if err != nil {
+			return files, entries, err
+		}
+		if n > 0 {
+			files++
+			entries += n
+		}
+	}
+
+	// 当前文件被替换过，重新以追加方式打开
+	if files > 0 {
+		_ = a.file.Close()
+		f, err := os.OpenFile(filepath.Join(a.dir, a.name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return files, entries, err
+		}
+		info, _ := f.Stat()
+		a.file, a.w = f, bufio.NewWriter(f)
+		if info != nil {
+			a.size = info.Size()
+		}
+	}
+	return files, entries, nil
+}
+
+func (a *localArchive) eraseUserInFile(e os.DirEntry, userID string, needle []byte, redact bool) (int, error) {
+	path := filepath.Join(a.dir, e.Name())
+	info, err := e.Info()
+	if err != nil {
+		return 0, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.Contains(data, needle) {
+		return 0, nil
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	n := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var ev trafficEvent
+		if !bytes.Contains(line, needle) || json.Unmarshal(line, &ev) != nil || ev.UserID != userID {
+			out.Write(line)
+			continue
+		}
+		n++
+		if redact {
+			ev.UserID, ev.Data = redactedValue, nil
+			b, _ := json.Marshal(ev)
+			out.Write(append(b, '\n'))
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out.Bytes(), 0o640); err != nil {
+		return 0, err
+	}
+	_ = os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	return n, nil
+}
+
+// ===== 查询 =====
+
+// archiveQuery GET /api/history 的过滤条件，时间为毫秒，0 表示不限
+type archiveQuery struct {
+	UserID  string
+	Channel string
+	Event   string
+	Since   int64
+	Until   int64
+	Limit   int
+}
+
+func (q archiveQuery) match(ev *trafficEvent) bool {
+	return (q.UserID == "" || ev.UserID == q.UserID) &&
+		(q.Channel == "" || ev.Channel == q.Channel) &&
+		(q.Event == "" || ev.Event == q.Event) &&
+		(q.Since == 0 || ev.Ts >= q.Since) &&
+		(q.Until == 0 || ev.Ts < q.Until)
+}
+
+// search 按时间顺序返回命中的前 Limit 条，第二个返回值表示后面还有
+func (a *localArchive) search(q archiveQuery) ([]trafficEvent, bool, error) {
+	a.flush()
+
+	out := []trafficEvent{}
+	for _, e := range a.listFiles() {
+		// 文件名即创建时间，创建晚于 until 的文件及之后的都不用看；最后修改早于 since 的文件里不会有命中
+		if q.Until > 0 && e.Name() >= archiveFilePrefix+time.UnixMilli(q.Until).UTC().Format(archiveFileTimestampFormat) {
+			break
+		}
+		if q.Since > 0 {
+			if info, err := e.Info(); err == nil && info.ModTime().UnixMilli() < q.Since {
+				continue
+			}
+		}
+
+		more, err := a.searchFile(e.Name(), q, &out)
+		if err != nil {
+			return nil, false, err
+		}
+		if more {
+			return out, true, nil
+		}
+	}
+	return out, false, nil
+}
+
+func (a *localArchive) searchFile(name string, q archiveQuery, out *[]trafficEvent) (bool, error) {
+	f, err := os.Open(filepath.Join(a.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil // 刚被保留策略删掉
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), archiveFileMaxLineBytes)
+	for sc.Scan() {
+		var ev trafficEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil || !q.match(&ev) {
+			continue
+		}
+		if len(*out) == q.Limit {
+			return true, nil
+		}
+		*out = append(*out, ev)
+	}
+	return false, sc.Err()
+}
+
+// archiveHistoryHandler GET /api/history?user_id=&channel=&event=&since=&until=&limit=
+func archiveHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := archiveQueryFromRequest(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, problemValidation, err.Error())
+		return
+	}
+
+	events, more, err := archiveFiles.search(q)
+	if err != nil {
+		log.Println("❌ 查询归档失败:", err)
+		writeProblem(w, r, http.StatusInternalServerError, problemInternal, "archive read failed")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{
+			"messages": events,
+			"has_more": more,
+		},
+	})
+}
+
+func archiveQueryFromRequest(r *http.Request) (archiveQuery, error) {
+	v := r.URL.Query()
+	q := archiveQuery{
+		UserID:  v.Get("user_id"),
+		Channel: v.Get("channel"),
+		Event:   v.Get("event"),
+		Limit:   archiveQueryDefaultLimit,
+	}
+	for name, dst := range map[string]*int64{"since": &q.Since, "until": &q.Until} {
+		if s := v.Get(name); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 0 {
+				return q, fmt.Errorf("invalid %s: must be a unix timestamp in milliseconds", name)
+			}
+			*dst = n
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > archiveQueryMaxLimit {
+			return q, fmt.Errorf("invalid limit: must be 1-%d", archiveQueryMaxLimit)
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== 认证链 =====
+//
+// 每类接口（push / admin）配置一条有序的认证链，依次尝试，任意一个通过即放行：
+//
+//	"auth": {
+//	  "push":  [{"type": "hmac", "secret": "..."}, {"type": "static"}],
+//	  "admin": [{"type": "jwt", "issuer": "...", "public_key": "..."}]
+//	}
+//
+// 未配置时默认只有 static（即原来的 api_key 校验）。
+// 内置类型：static（固定 key）、hmac（请求签名）、jwt（Bearer token）、callback（外部接口判定）。
+
+// AuthConfig 各类接口的认证链
+type AuthConfig struct {
+	Push     []AuthProviderConfig `json:"push"`
+	Admin    []AuthProviderConfig `json:"admin"`
+	Firehose []AuthProviderConfig `json:"firehose"` // 未配置时沿用 admin 的配置
+}
+
+// AuthProviderConfig 认证链中的一环，按 type 使用不同字段
+type AuthProviderConfig struct {
+	Type string `json:"type"` // static / hmac / jwt / callback
+
+	Keys []string `json:"keys"` // static：允许的 key 列表，为空时使用 api_key；支持密钥引用
+
+	Secret         string `json:"secret"`           // hmac：签名密钥；jwt：HS256 密钥；支持密钥引用
+	MaxSkewSeconds int    `json:"max_skew_seconds"` // hmac：时间戳允许的偏差，默认 300
+
+	PublicKey string `json:"public_key"` // jwt：RS256 公钥（PEM），支持密钥引用
+	JWKSURL   string `json:"jwks_url"`   // jwt：从 JWKS 按 kid 取 RS256 / ES256 公钥
+	Issuer    string `json:"issuer"`     // jwt：为空不校验 iss
+	Audience  string `json:"audience"`   // jwt：为空不校验 aud
+
+	URL            string `json:"url"`             // callback：认证接口地址
+	TimeoutSeconds int    `json:"timeout_seconds"` // callback：超时，默认 5
+}
+
+// authPrincipal 认证通过后的调用方
+type authPrincipal struct {
+	Method  string    // 通过的认证类型
+	Subject string    // 调用方标识（jwt sub / callback 返回的 subject），static / hmac 为空
+	Claims  jwtClaims // jwt 的 claims
+	Role    string    // 管理角色（admin / viewer），为空表示不受限（机器凭证）
+
+	KeyFingerprint string // static 认证通过的 key 的指纹，用于按 key 统计用量
+}
+
+// Authenticator 认证链中的一环
+type Authenticator interface {
+	Name() string
+	// Authenticate 请求里没有本认证方式需要的凭证时返回 errNoCredentials，链继续往下走
+	Authenticate(r *http.Request) (*authPrincipal, error)
+}
+
+var errNoCredentials = errors.New("no credentials")
+
+type authPrincipalKey struct{}
+
+// principalFromRequest 取认证链写入的调用方信息
+func principalFromRequest(r *http.Request) *authPrincipal {
+	p, _ := r.Context().Value(authPrincipalKey{}).(*authPrincipal)
+	return p
+}
+
+// checkAuth 用 endpoint 对应的认证链保护 next
+func checkAuth(endpoint string, next http.Handler) http.Handler {
+	chain := authChainFor(endpoint)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var firstErr error
+		for _, a := range chain {
+			p, err := a.Authenticate(r)
+			if err == nil {
+				// viewer 角色只能读
+				if p.Role == roleViewer && r.Method != http.MethodGet {
+					log.Printf("❌ %s 权限不足: %s %s %s role=%s\n", endpoint, p.Subject, r.Method, r.URL.Path, p.Role)
+					writeProblem(w, r, http.StatusForbidden, problemForbidden, "role viewer is read-only")
+					return
+				}
+				if p.KeyFingerprint != "" {
+					recordAPIKeyUsage(p.KeyFingerprint, endpoint, r)
+				}
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authPrincipalKey{}, p)))
+				return
+			}
+			if !errors.Is(err, errNoCredentials) && firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		var tooLarge *http.MaxBytesError
+		if errors.As(firstErr, &tooLarge) {
+			log.Printf("❌ %s 签名校验失败: %s %v\n", endpoint, r.URL.Path, firstErr)
+			writeBodyError(w, r, firstErr)
+			return
+		}
+		msg := "invalid api key"
+		if firstErr != nil {
+			msg = firstErr.Error()
+		}
+		log.Printf("❌ %s 认证失败: %s %s\n", endpoint, r.URL.Path, msg)
+		recordRequestAbuse(r, banSignalAuth)
+		writeProblem(w, r, http.StatusUnauthorized, problemUnauthorized, msg)
+	})
+}
+
+// authChains 每类接口的认证链只构造一次，多个路由共用
+var authChains = make(map[string][]Authenticator)
+
+func authChainFor(endpoint string) []Authenticator {
+	if chain, ok := authChains[endpoint]; ok {
+		return chain
+	}
+	// section 是配置所在的字段，firehose 沿用 admin 时密钥也按 auth.admin 的字段名热更新
+	var cfgs []AuthProviderConfig
+	section := endpoint
+	switch endpoint {
+	case "push":
+		cfgs = GlobalConfig.Auth.Push
+	case "admin":
+		cfgs = GlobalConfig.Auth.Admin
+	case "firehose":
+		cfgs = GlobalConfig.Auth.Firehose
+		if len(cfgs) == 0 {
+			cfgs, section = GlobalConfig.Auth.Admin, "admin"
+		}
+	}
+	chain := buildAuthChain(endpoint, section, cfgs)
+	// 开启 OIDC 登录时，管理后台会话排在 admin / firehose 认证链最前面（调试控制台可以直接用登录会话）
+	if (endpoint == "admin" || endpoint == "firehose") && GlobalConfig.OIDC.Enabled {
+		chain = append([]Authenticator{oidcSessionAuthenticator{}}, chain...)
+		log.Printf("🔐 %s 认证链前置 oidc_session\n", endpoint)
+	}
+	authChains[endpoint] = chain
+	return chain
+}
+
+// buildAuthChain 按配置构造认证链，配置错误直接退出
+func buildAuthChain(endpoint, section string, cfgs []AuthProviderConfig) []Authenticator {
+	if len(cfgs) == 0 {
+		cfgs = []AuthProviderConfig{{Type: "static"}}
+	}
+	chain := make([]Authenticator, 0, len(cfgs))
+	names := make([]string, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		a, err := newAuthenticator(fmt.Sprintf("auth.%s[%d]", section, i), cfg)
+		if err != nil {
+			log.Fatalf("❌ auth.%s[%d] 配置无效 %v\n", section, i, err)
+		}
+		chain = append(chain, a)
+		names = append(names, a.Name())
+	}
+	log.Printf("🔐 %s 认证链：%s\n", endpoint, strings.Join(names, " → "))
+	return chain
+}
+
+// authSecret 认证链里的一个密钥字段，每次请求取当前生效的值，密钥引用轮换后无需重启
+type authSecret struct {
+	name  string // 字段名，如 auth.push[0].secret，见 configSecretFields
+	value string // 配置里的值（引用已在加载时解析）
+}
+
+func newAuthSecret(name, value string) (authSecret, error) {
+	v, _, err := resolveSecret(value)
+	if err != nil {
+		return authSecret{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return authSecret{name: name, value: v}, nil
+}
+
+func (s authSecret) get() string { return liveSecret(s.name, s.value) }
+
+// newAuthenticator 构造认证链的一环，field 是它在配置里的位置（如 auth.push[0]）
+func newAuthenticator(field string, cfg AuthProviderConfig) (Authenticator, error) {
+	switch cfg.Type {
+	case "static":
+		a := &staticKeyAuthenticator{}
+		for j, k := range cfg.Keys {
+			key, err := newAuthSecret(fmt.Sprintf("%s.keys[%d]", field, j), k)
+			if err != nil {
+				return nil, err
+			}
+			a.keys = append(a.keys, key)
+		}
+		return a, nil
+
+	case "hmac":
+		secret, err := newAuthSecret(field+".secret", cfg.Secret)
+		if err != nil || secret.value == "" {
+			return nil, fmt.Errorf("hmac 需要 secret")
+		}
+		skew := cfg.MaxSkewSeconds
+		if skew <= 0 {
+			skew = pushSigningDefaultSkew
+		}
+		startPushNonceSweep()
+		return &hmacAuthenticator{secret: secret, window: time.Duration(skew) * time.Second}, nil
+
+	case "jwt":
+		// 机器凭证同样必须带 exp，不接受永不过期的 token
+		a := &jwtAuthenticator{base: jwtVerifier{Issuer: cfg.Issuer, Audience: cfg.Audience, RequireExp: true, Leeway: 30 * time.Second}}
+		if cfg.Secret != "" {
+			secret, err := newAuthSecret(field+".secret", cfg.Secret)
+			if err != nil {
+				return nil, err
+			}
+			a.secret = &secret
+		}
+		if cfg.PublicKey != "" {
+			pemData, err := newAuthSecret(field+".public_key", cfg.PublicKey)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := a.rsaKey(pemData.value); err != nil {
+				return nil, fmt.Errorf("jwt public_key: %w", err)
+			}
+			a.publicKey = &pemData
+		}
+		if cfg.JWKSURL != "" {
+			a.base.KeySet = newJWKSCache(cfg.JWKSURL)
+		}
+		if a.secret == nil && a.publicKey == nil && a.base.KeySet == nil {
+			return nil, fmt.Errorf("jwt 需要 secret / public_key / jwks_url 之一")
+		}
+		return a, nil
+
+	case "callback":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("callback 需要 url")
+		}
+		timeout := cfg.TimeoutSeconds
+		if timeout <= 0 {
+			timeout = 5
+		}
+		return &callbackAuthenticator{url: cfg.URL, client: &http.Client{Timeout: time.Duration(timeout) * time.Second}}, nil
+
+	default:
+		return nil, fmt.Errorf("未知的认证类型 %q", cfg.Type)
+	}
+}
+
+// ===== static：固定 key =====
+
+type staticKeyAuthenticator struct {
+	keys []authSecret // 为空时使用当前生效的 api_key
+}
+
+func (a *staticKeyAuthenticator) Name() string { return "static" }
+
+func (a *staticKeyAuthenticator) Authenticate(r *http.Request) (*authPrincipal, error) {
+	key := r.Header.Get("X-API-KEY")
+	if key == "" {
+		key = r.Header.Get("API-KEY")
+	}
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+		if key != "" {
+			if GlobalConfig.DisableQueryAPIKey {
+				log.Println("❌ 已禁用 ?api_key= 认证，请改用 X-API-KEY 请求头:", r.URL.Path)
+				return nil, errors.New("query api_key is disabled, use X-API-KEY header")
+			}
+			log.Println("⚠️ ?api_key= 认证方式已不推荐（会泄露到代理日志和浏览器历史），请改用 X-API-KEY 请求头:", r.URL.Path)
+		}
+	}
+	if key == "" {
+		return nil, errNoCredentials
+	}
+
+	// 每次请求取当前生效的 key，密钥引用轮换后无需重启
+	keys := make([]string, 0, max(len(a.keys), 1))
+	for _, k := range a.keys {
+		keys = append(keys, k.get())
+	}
+	if len(keys) == 0 {
+		keys = append(keys, liveSecret("api_key", GlobalConfig.APIKey))
+	}
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			return &authPrincipal{Method: "static", KeyFingerprint: keyFingerprint(k)}, nil
+		}
+	}
+	return nil, errors.New("invalid api key")
+}
+
+// ===== hmac：请求签名，规则同 push_signing =====
+
+type hmacAuthenticator struct {
+	secret authSecret
+	window time.Duration
+}
+
+func (a *hmacAuthenticator) Name() string { return "hmac" }
+
+func (a *hmacAuthenticator) Authenticate(r *http.Request) (*authPrincipal, error) {
+	if r.Header.Get("X-Relay-Signature") == "" {
+		return nil, errNoCredentials
+	}
+	if err := verifyRequestSignature(nil, r, a.secret.get(), a.window); err != nil {
+		return nil, err
+	}
+	return &authPrincipal{Method: "hmac"}, nil
+}
+
+// ===== jwt：Authorization: Bearer <token> =====
+
+type jwtAuthenticator struct {
+	base      jwtVerifier // iss / aud / exp 规则和 JWKS，密钥每次请求另取
+	secret    *authSecret // HS256 密钥
+	publicKey *authSecret // RS256 公钥（PEM）
+
+	mu     sync.Mutex
+	pemRaw string // 已解析的公钥对应的 PEM，轮换后重新解析
+	rsaPub *rsa.PublicKey
+}
+
+func (a *jwtAuthenticator) Name() string { return "jwt" }
+
+// rsaKey 解析 PEM 公钥，和上次相同时直接用缓存
+func (a *jwtAuthenticator) rsaKey(pemData string) (*rsa.PublicKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rsaPub != nil && pemData == a.pemRaw {
+		return a.rsaPub, nil
+	}
+	key, err := parseRSAPublicKeyPEM(pemData)
+	if err != nil {
+		return nil, err
+	}
+	a.pemRaw, a.rsaPub = pemData, key
+	return key, nil
+}
+
+// verifier 按当前生效的密钥构造本次请求用的校验参数
+func (a *jwtAuthenticator) verifier() *jwtVerifier {
+	v := a.base
+	if a.secret != nil {
+		v.HMACSecret = []byte(a.secret.get())
+	}
+	if a.publicKey != nil {
+		key, err := a.rsaKey(a.publicKey.get())
+		if err != nil {
+			log.Println("⚠️ 轮换后的 jwt public_key 无效，继续使用旧公钥:", err)
+			a.mu.Lock()
+			key = a.rsaPub
+			a.mu.Unlock()
+		}
+		v.RSAPublicKey = key
+	}
+	return &v
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*authPrincipal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, errNoCredentials
+	}
+	claims, err := a.verifier().verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return &authPrincipal{Method: "jwt", Subject: claims.str("sub"), Claims: claims}, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:]), true
+	}
+	return "", false
+}
+
+// ===== callback：交给外部接口判定 =====
+//
+// POST {url}，body 为 {"method","path","headers"}（只带认证相关的请求头），
+// 返回 2xx 即通过，可选返回 {"subject": "..."}。
+
+type callbackAuthenticator struct {
+	url    string
+	client *http.Client
+}
+
+var callbackAuthHeaders = []string{"Authorization", "X-API-KEY", "API-KEY"}
+
+func (a *callbackAuthenticator) Name() string { return "callback" }
+
+func (a *callbackAuthenticator) Authenticate(r *http.Request) (*authPrincipal, error) {
+	headers := make(map[string]string)
+	for _, h := range callbackAuthHeaders {
+		if v := r.Header.Get(h); v != "" {
+			headers[h] = v
+		}
+	}
+	if len(headers) == 0 {
+		return nil, errNoCredentials
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"method":  r.Method,
+		"path":    r.URL.Path,
+		"headers": headers,
+	})
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Println("⚠️ 认证回调请求失败:", err)
+		return nil, errors.New("auth callback unavailable")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.New("rejected by auth callback")
+	}
+
+	var out struct {
+		Subject string `json:"subject"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	return &authPrincipal{Method: "callback", Subject: out.Subject}, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedPushRequest 按 push_signing 的规则签名的推送请求
+func signedPushRequest(secret, nonce, body string) *http.Request {
+	return signedPushRequestAt(secret, strconv.FormatInt(time.Now().Unix(), 10), nonce, body)
+}
+
+// signedPushRequestAt 同 signedPushRequest，时间戳头原样使用 ts
+func signedPushRequestAt(secret, ts, nonce, body string) *http.Request {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "\n" + nonce + "\n" + body))
+	r := httptest.NewRequest(http.MethodPost, "/v1/push", strings.NewReader(body))
+	r.Header.Set("X-Relay-Timestamp", ts)
+	r.Header.Set("X-Relay-Nonce", nonce)
+	r.Header.Set("X-Relay-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+// useAuthChains 换配置时清掉已构造的认证链
+func useAuthChains(t *testing.T, edit func(cfg *Config)) {
+	t.Helper()
+	useConfig(t, edit)
+	saved := authChains
+	authChains = make(map[string][]Authenticator)
+	t.Cleanup(func() { authChains = saved })
+}
+
+func TestHMACAuthWithPushSigning(t *testing.T) {
+	for _, signingSecret := range []string{"hmac-secret", "other-secret"} {
+		useAuthChains(t, func(cfg *Config) {
+			cfg.Auth.Push = []AuthProviderConfig{{Type: "hmac", Secret: "hmac-secret"}, {Type: "static", Keys: []string{"static-key"}}}
+			cfg.PushSigning = PushSigningConfig{Enabled: true, Secret: signingSecret, MaxSkewSeconds: 300}
+		})
+		h := protectPush(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+		nonce := "n-" + signingSecret
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, signedPushRequest("hmac-secret", nonce, `{"event_name":"x"}`))
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("push_signing.secret=%s：hmac 认证的请求 status = %d: %s", signingSecret, w.Code, w.Body)
+		}
+
+		// 重放仍然被拒绝
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, signedPushRequest("hmac-secret", nonce, `{"event_name":"x"}`))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("重放 status = %d, want 401", w.Code)
+		}
+
+		// static 认证的请求仍然要过 push_signing
+		r := httptest.NewRequest(http.MethodPost, "/v1/push", strings.NewReader(`{}`))
+		r.Header.Set("X-API-KEY", "static-key")
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("static 认证未签名 status = %d, want 401", w.Code)
+		}
+	}
+}
+
+func TestAuthSecretsRotate(t *testing.T) {
+	useAuthChains(t, func(cfg *Config) {
+		cfg.Auth.Push = []AuthProviderConfig{{Type: "static", Keys: []string{"old-key"}}}
+	})
+	h := checkAuth("push", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+	call := func(key string) int {
+		r := httptest.NewRequest(http.MethodGet, "/v1/presence", nil)
+		r.Header.Set("X-API-KEY", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := call("old-key"); code != http.StatusNoContent {
+		t.Fatalf("old-key status = %d", code)
+	}
+
+	// 模拟引用刷新后的新值
+	secretsMu.Lock()
+	liveSecrets["auth.push[0].keys[0]"] = "new-key"
+	secretsMu.Unlock()
+	t.Cleanup(func() {
+		secretsMu.Lock()
+		delete(liveSecrets, "auth.push[0].keys[0]")
+		secretsMu.Unlock()
+	})
+	if code := call("new-key"); code != http.StatusNoContent {
+		t.Fatalf("轮换后 new-key status = %d", code)
+	}
+	if code := call("old-key"); code != http.StatusUnauthorized {
+		t.Fatalf("轮换后 old-key status = %d, want 401", code)
+	}
+}
+
+func TestJWTAuthenticatorRequiresExp(t *testing.T) {
+	a, err := newAuthenticator("auth.admin[0]", AuthProviderConfig{Type: "jwt", Secret: string(testJWTSecret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(claims map[string]interface{}) error {
+		r := httptest.NewRequest(http.MethodGet, "/api/admin/state", nil)
+		r.Header.Set("Authorization", "Bearer "+signTestJWT(claims))
+		_, err := a.Authenticate(r)
+		return err
+	}
+	if err := call(map[string]interface{}{"sub": "ops"}); err == nil {
+		t.Fatal("没有 exp 的 token 应当被拒绝")
+	}
+	if err := call(map[string]interface{}{"sub": "ops", "exp": time.Now().Add(time.Minute).Unix()}); err != nil {
+		t.Fatalf("带 exp 的 token 被拒绝: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ===== 认证过期与强制重新 identify =====
+//
+// 每个已认证连接记录一个认证过期时间：开启 client_jwt 时取 token 的 exp，
+// 否则（或 token 没有 exp 时）取 ttl_seconds。两者都有时取较早的那个。
+// 过期前 warn_seconds 下发 auth_expiring 事件，客户端应在过期前重新 identify（换新 token），
+// 重新 identify 成功会刷新过期时间；到期仍未续期的连接按 on_expiry 处理：
+//   - disconnect（默认）：下发 auth_expired 后以 CloseAuthExpired 关闭
+//   - anonymous：降级为匿名连接（有访客 cookie 的回到 visitor 分组），连接保留
+
+// AuthExpiryConfig 连接认证过期配置
+type AuthExpiryConfig struct {
+	Enabled     bool   `json:"enabled"`
+	TTLSeconds  int    `json:"ttl_seconds"`  // 非 JWT token 的认证有效期，0 表示不过期
+	WarnSeconds int    `json:"warn_seconds"` // 提前多久下发 auth_expiring，默认 60
+	OnExpiry    string `json:"on_expiry"`    // disconnect / anonymous，默认 disconnect
+}
+
+// CloseAuthExpired 认证到期且未重新 identify 时的关闭码
+const CloseAuthExpired = 4408
+
+const (
+	authExpiryDefaultWarn = 60
+	authExpiryDisconnect  = "disconnect"
+	authExpiryAnonymous   = "anonymous"
+	authExpiryCheckEvery  = time.Second
+)
+
+type authExpiryEntry struct {
+	expiresAt time.Time
+	warned    bool
+}
+
+var (
+	authExpiries   = make(map[*Client]*authExpiryEntry)
+	authExpiriesMu sync.Mutex
+)
+
+// initAuthExpiry 填默认值并启动检查循环
+func initAuthExpiry() {
+	cfg := &GlobalConfig.AuthExpiry
+	if cfg.WarnSeconds <= 0 {
+		cfg.WarnSeconds = authExpiryDefaultWarn
+	}
+	switch cfg.OnExpiry {
+	case "":
+		cfg.OnExpiry = authExpiryDisconnect
+	case authExpiryDisconnect, authExpiryAnonymous:
+	default:
+		log.Fatalf("❌ auth_expiry.on_expiry 只能是 disconnect 或 anonymous，当前为 %q\n", cfg.OnExpiry)
+	}
+	go authExpiryLoop()
+	log.Printf("✅ 连接认证过期已启用：ttl=%ds，提前 %ds 提醒，到期 %s\n", cfg.TTLSeconds, cfg.WarnSeconds, cfg.OnExpiry)
+}
+
+// authExpiryFor 计算一次 identify 的认证过期时间，tokenExp 为零值表示 token 本身没有过期时间
+func authExpiryFor(tokenExp time.Time) time.Time {
+	exp := tokenExp
+	if ttl := GlobalConfig.AuthExpiry.TTLSeconds; ttl > 0 {
+		byTTL := time.Now().Add(time.Duration(ttl) * time.Second)
+		if exp.IsZero() || byTTL.Before(exp) {
+			exp = byTTL
+		}
+	}
+	return exp
+}
+
+// trackAuthExpiry identify 成功后记录（或刷新）连接的认证过期时间
+func trackAuthExpiry(c *Client, tokenExp time.Time) {
+	exp := authExpiryFor(tokenExp)
+
+	authExpiriesMu.Lock()
+	defer authExpiriesMu.Unlock()
+	if exp.IsZero() {
+		delete(authExpiries, c)
+		return
+	}
+	authExpiries[c] = &authExpiryEntry{expiresAt: exp}
+}
+
+// authExpiryOf 返回连接当前的认证过期时间，未跟踪时为零值
+func authExpiryOf(c *Client) time.Time {
+	authExpiriesMu.Lock()
+	defer authExpiriesMu.Unlock()
+	if e, ok := authExpiries[c]; ok {
+		return e.expiresAt
+	}
+	return time.Time{}
+}
+
+// restoreAuthExpiry 沿用迁移前的认证过期时间（见 handoff.go）
+func restoreAuthExpiry(c *Client, exp time.Time) {
+	authExpiriesMu.Lock()
+	authExpiries[c] = &authExpiryEntry{expiresAt: exp}
+	authExpiriesMu.Unlock()
+}
+
+// forgetAuthExpiry 连接断开或降级时清理
+func forgetAuthExpiry(c *Client) {
+	authExpiriesMu.Lock()
+	delete(authExpiries, c)
+	authExpiriesMu.Unlock()
+}
+
+func authExpiryLoop() {
+	ticker := time.NewTicker(authExpiryCheckEvery)
+	defer ticker.Stop()
+	for range ticker.C {
+		checkAuthExpiries(time.Now())
+	}
+}
+
+func checkAuthExpiries(now time.Time) {
+	warnBefore := time.Duration(GlobalConfig.AuthExpiry.WarnSeconds) * time.Second
+
+	var warn, expired []*Client
+	var warnAt []time.Time
+	authExpiriesMu.Lock()
+	for c, e := range authExpiries {
+		switch {
+		case !now.Before(e.expiresAt):
+			expired = append(expired, c)
+			delete(authExpiries, c)
+		case !e.warned && now.Add(warnBefore).After(e.expiresAt):
+			e.warned = true
+			warn = append(warn, c)
+			warnAt = append(warnAt, e.expiresAt)
+		}
+	}
+	authExpiriesMu.Unlock()
+
+	for i, c := range warn {
+		_ = c.deliver(WSMessage{
+			Event: "auth_expiring",
+			Data: map[string]interface{}{
+				"expires_at":   warnAt[i].UnixMilli(),
+				"seconds_left": int(warnAt[i].Sub(now).Round(time.Second) / time.Second),
+			},
+		})
+	}
+	for _, c := range expired {
+		expireAuth(c)
+	}
+}
+
+// expireAuth 认证到期未续期：断开或降级为匿名
+func expireAuth(c *Client) {
+	if GlobalConfig.ClientJWT.Enabled {
+		forgetClientJWT(c)
+	}
+	action := GlobalConfig.AuthExpiry.OnExpiry
+	log.Printf("⌛ 连接 %s（user_id=%s）认证到期未重新 identify，处理方式：%s\n", c.id, logUserID(c.userID), action)
+
+	_ = c.deliver(WSMessage{Event: "auth_expired", Data: map[string]interface{}{"action": action}})
+	if action == authExpiryAnonymous {
+		unregisterUser(c)
+		if c.visitorID != "" {
+			registerUser(c, visitorUserID(c.visitorID))
+		}
+		return
+	}
+	c.closeWithCode(CloseAuthExpired, "authentication expired")
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== AWS Secrets Manager / SSM Parameter Store 来源 =====
+//
+// 密钥引用：
+//
+//	"awssm://relay/prod#api_key"   Secrets Manager，# 后为 JSON 字段名，省略则取整个 SecretString
+//	"ssm:///relay/prod/api_key"    SSM Parameter Store（自动解密 SecureString）
+//
+// 整份配置也可以从这里拉取：设置环境变量 RELAY_CONFIG_SOURCE=awssm://relay/config 或 ssm:///relay/config，
+// 此时不再读写本地 config.json。
+//
+// 不依赖 AWS SDK，直接用 SigV4 签名调用 JSON API。凭证按以下顺序获取：
+// AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY 环境变量 → ECS / EKS Pod Identity 容器凭证 → EKS IRSA（AssumeRoleWithWebIdentity）。
+
+// AWSConfig AWS 来源配置
+type AWSConfig struct {
+	Region         string `json:"region"`          // 为空时读 AWS_REGION / AWS_DEFAULT_REGION
+	RefreshSeconds int    `json:"refresh_seconds"` // 使用了 awssm:// / ssm:// 引用时重新读取的间隔，默认 300，-1 关闭
+}
+
+const (
+	awsDefaultRefreshSeconds = 300
+	awsRequestTimeout        = 10 * time.Second
+	awsEcsCredentialsHost    = "http://169.254.170.2"
+)
+
+// awsCredentials 一组临时或长期凭证
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time // 零值表示长期凭证
+}
+
+var (
+	awsCreds     *awsCredentials
+	awsCredsMu   sync.Mutex
+	awsHTTP      = &http.Client{Timeout: awsRequestTimeout}
+	awsRefsInUse bool // 是否解析过 AWS 引用，决定要不要启动刷新循环
+)
+
+func awsRegion() string {
+	if GlobalConfig.AWS.Region != "" {
+		return GlobalConfig.AWS.Region
+	}
+	if r := os.Getenv("AWS_REGION"); r != "" {
+		return r
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// resolveAWSSecretsManager 解析 awssm://secret-id#field
+func resolveAWSSecretsManager(ref string) (string, error) {
+	secretID, field, _ := strings.Cut(ref, "#")
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := awsCall("secretsmanager", "secretsmanager.GetSecretValue", map[string]interface{}{"SecretId": secretID}, &resp); err != nil {
+		return "", err
+	}
+	awsRefsInUse = true
+	if field == "" {
+		return resp.SecretString, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(resp.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s 不是 JSON，无法取字段 %s", secretID, field)
+	}
+	val, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret %s 中没有字段 %s", secretID, field)
+	}
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	return toJSON(val), nil
+}
+
+// resolveAWSParameter 解析 ssm:///parameter/name
+func resolveAWSParameter(name string) (string, error) {
+	var resp struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	body := map[string]interface{}{"Name": name, "WithDecryption": true}
+	if err := awsCall("ssm", "AmazonSSM.GetParameter", body, &resp); err != nil {
+		return "", err
+	}
+	awsRefsInUse = true
+	return resp.Parameter.Value, nil
+}
+
+// awsCall 调用 AWS JSON 1.1 协议的接口
+func awsCall(service, target string, body, out interface{}) error {
+	region := awsRegion()
+	if region == "" {
+		return fmt.Errorf("未配置 AWS region（aws.region 或 AWS_REGION）")
+	}
+	creds, err := awsCurrentCredentials(region)
+	if err != nil {
+		return err
+	}
+
+	payload, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, "https://"+service+"."+region+".amazonaws.com/", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	awsSignV4(req, payload, creds, region, service, time.Now().UTC())
+
+	resp, err := awsHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("aws %s: %s %s", target, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
+
+// awsSignV4 按 Signature Version 4 给请求签名（只签 content-type / host / x-amz-*）
+func awsSignV4(req *http.Request, payload []byte, creds *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	names := []string{"host"}
+	for k := range req.Header {
+		n := strings.ToLower(k)
+		if n == "content-type" || strings.HasPrefix(n, "x-amz-") {
+			headers[n] = req.Header.Get(k)
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, n := range names {
+		canonicalHeaders.WriteString(n + ":" + strings.TrimSpace(headers[n]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := awsHMAC([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = awsHMAC(key, region)
+	key = awsHMAC(key, service)
+	key = awsHMAC(key, "aws4_request")
+	signature := hex.EncodeToString(awsHMAC(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func awsHMAC(key []byte, s string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+// awsCurrentCredentials 返回缓存的凭证，临时凭证过期前 5 分钟重新获取
+func awsCurrentCredentials(region string) (*awsCredentials, error) {
+	awsCredsMu.Lock()
+	defer awsCredsMu.Unlock()
+	if awsCreds != nil && (awsCreds.Expiration.IsZero() || time.Until(awsCreds.Expiration) > 5*time.Minute) {
+		return awsCreds, nil
+	}
+	creds, err := awsLoadCredentials(region)
+	if err != nil {
+		return nil, err
+	}
+	awsCreds = creds
+	return creds, nil
+}
+
+func awsLoadCredentials(region string) (*awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	// ECS 任务角色 / EKS Pod Identity
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		return awsContainerCredentials(awsEcsCredentialsHost+rel, "")
+	}
+	if full := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); full != "" {
+		token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+		if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("读取容器凭证 token 失败: %w", err)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		return awsContainerCredentials(full, token)
+	}
+
+	// EKS IRSA
+	if file := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); file != "" {
+		return awsWebIdentityCredentials(region, file, os.Getenv("AWS_ROLE_ARN"))
+	}
+
+	return nil, fmt.Errorf("找不到 AWS 凭证（环境变量 / 容器凭证 / IRSA 均未配置）")
+}
+
+func awsContainerCredentials(endpoint, authToken string) (*awsCredentials, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if authToken != "" {
+		req.Header.Set("Authorization", authToken)
+	}
+	resp, err := awsHTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("获取容器凭证失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取容器凭证失败: %s", resp.Status)
+	}
+
+	var out struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &awsCredentials{
+		AccessKeyID:     out.AccessKeyID,
+		SecretAccessKey: out.SecretAccessKey,
+		SessionToken:    out.Token,
+		Expiration:      out.Expiration,
+	}, nil
+}
+
+func awsWebIdentityCredentials(region, tokenFile, roleARN string) (*awsCredentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取 web identity token 失败: %w", err)
+	}
+	q := url.Values{}
+	q.Set("Action", "AssumeRoleWithWebIdentity")
+	q.Set("Version", "2011-06-15")
+	q.Set("RoleArn", roleARN)
+	q.Set("RoleSessionName", "go-relay-"+randomHex(4))
+	q.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	resp, err := awsHTTP.Get("https://sts." + region + ".amazonaws.com/?" + q.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("AssumeRoleWithWebIdentity 失败: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("AssumeRoleWithWebIdentity 失败: %s %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var out struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return &awsCredentials{
+		AccessKeyID:     out.Credentials.AccessKeyID,
+		SecretAccessKey: out.Credentials.SecretAccessKey,
+		SessionToken:    out.Credentials.SessionToken,
+		Expiration:      out.Credentials.Expiration,
+	}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 推送接口背压 =====
+//
+// 中继已经处理不过来时，与其收下推送再悄悄延迟或丢弃，不如直接告诉调用方退避：
+//   - queue_full / queue_saturated：异步推送队列满了或排队数超过 queue_high_watermark → 429 rate_limited
+//   - queue_lagging：队列里最早一条已等待超过 max_queue_age_seconds → 429 rate_limited
+//   - memory_pressure：Go 堆内存超过 max_heap_mb → 503 unavailable（在解析请求体之前就拒绝）
+//
+// 响应带 Retry-After 和机器可读的 reason：
+//
+//	{"type":"urn:relay:problem:rate_limited","status":429,"code":"rate_limited","reason":"queue_saturated",
+//	 "retry_after_seconds":1,"detail":"push queue is saturated"}
+//
+// 堆内存每秒采样一次，推送请求只读采样值。
+
+// BackpressureConfig 推送接口背压配置
+type BackpressureConfig struct {
+	QueueHighWatermark float64 `json:"queue_high_watermark"`  // 排队数达到 push_queue.size 的该比例即拒绝，默认 1（队列满才拒绝）
+	MaxQueueAgeSeconds int     `json:"max_queue_age_seconds"` // 最早一条等待超过该秒数即拒绝，0 不检查
+	MaxHeapMB          int     `json:"max_heap_mb"`           // 堆内存上限，0 不检查
+	RetryAfterSeconds  int     `json:"retry_after_seconds"`   // 默认 1
+}
+
+const (
+	backpressureQueueFull      = "queue_full"
+	backpressureQueueSaturated = "queue_saturated"
+	backpressureQueueLagging   = "queue_lagging"
+	backpressureMemory         = "memory_pressure"
+
+	backpressureDefaultRetryAfter = 1
+	heapSampleInterval            = time.Second
+	heapMetricName                = "/memory/classes/heap/objects:bytes"
+)
+
+var (
+	heapBytes atomic.Uint64 // 最近一次采样的堆内存
+
+	backpressureMu       sync.Mutex
+	backpressureRejected = make(map[string]uint64) // reason -> 拒绝次数
+)
+
+func prepareBackpressure(cfg *BackpressureConfig) {
+	if cfg.QueueHighWatermark <= 0 || cfg.QueueHighWatermark > 1 {
+		cfg.QueueHighWatermark = 1
+	}
+	if cfg.RetryAfterSeconds <= 0 {
+		cfg.RetryAfterSeconds = backpressureDefaultRetryAfter
+	}
+}
+
+// startHeapSampler 配置了 max_heap_mb 时定期采样堆内存
+func startHeapSampler() {
+	if GlobalConfig.Backpressure.MaxHeapMB <= 0 {
+		return
+	}
+	sampleHeap()
+	go func() {
+		ticker := time.NewTicker(heapSampleInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			sampleHeap()
+		}
+	}()
+	log.Printf("✅ 推送背压：堆内存上限 %d MB\n", GlobalConfig.Backpressure.MaxHeapMB)
+}
+
+func sampleHeap() {
+	s := []metrics.Sample{{Name: heapMetricName}}
+	metrics.Read(s)
+	if s[0].Value.Kind() == metrics.KindUint64 {
+		heapBytes.Store(s[0].Value.Uint64())
+	}
+}
+
+// memoryPressure 堆内存是否超过上限
+func memoryPressure() bool {
+	limit := GlobalConfig.Backpressure.MaxHeapMB
+	return limit > 0 && heapBytes.Load() > uint64(limit)<<20
+}
+
+// queueBackpressureLocked 入队前检查队列水位，返回拒绝原因；调用方持有 pushQueueMu
+func queueBackpressureLocked(now time.Time) string {
+	cfg := GlobalConfig.Backpressure
+	size := GlobalConfig.PushQueue.Size
+	depth := len(pushQueueItems)
+	switch {
+	case depth >= size:
+		return backpressureQueueFull
+	case float64(depth) >= cfg.QueueHighWatermark*float64(size):
+		return backpressureQueueSaturated
+	case cfg.MaxQueueAgeSeconds > 0 && depth > 0 &&
+		now.Sub(pushQueueItems[0].enqueuedAt) > time.Duration(cfg.MaxQueueAgeSeconds)*time.Second:
+		return backpressureQueueLagging
+	}
+	return ""
+}
+
+// writeBackpressure 按原因返回 429 / 503，带 Retry-After 和 reason
+func writeBackpressure(w http.ResponseWriter, r *http.Request, reason string) {
+	backpressureMu.Lock()
+	backpressureRejected[reason]++
+	backpressureMu.Unlock()
+
+	retry := GlobalConfig.Backpressure.RetryAfterSeconds
+	status, code, detail := http.StatusTooManyRequests, problemRateLimited, ""
+	switch reason {
+	case backpressureQueueFull:
+		detail = "push queue is full"
+	case backpressureQueueSaturated:
+		detail = "push queue is saturated"
+	case backpressureQueueLagging:
+		detail = "push queue is lagging behind"
+	case backpressureMemory:
+		status, code, detail = http.StatusServiceUnavailable, problemUnavailable, "server is under memory pressure"
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	writeProblemWith(w, r, status, code, detail, map[string]interface{}{
+		"reason":              reason,
+		"retry_after_seconds": retry,
+	})
+}
+
+// backpressureCounts 各原因的拒绝次数，用于指标
+func backpressureCounts() map[string]uint64 {
+	backpressureMu.Lock()
+	defer backpressureMu.Unlock()
+	out := make(map[string]uint64, len(backpressureRejected))
+	for k, v := range backpressureRejected {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 滥用计分与自动临时封禁 =====
+//
+// 按来源 IP 和客户端 token 分别累计滥用信号，分数随时间衰减，达到阈值后临时封禁：
+//   - upgrade_failed：WebSocket 握手失败
+//   - auth_failed：接口认证失败、客户端 identify 的 token 校验失败
+//   - parse_error：上行帧格式不合法 / 事件不在白名单内
+//   - rate_violation：上行错误过多被断开（inbound.abuse_threshold）
+//
+// 被封禁的 IP 在监听器 Accept 后直接关闭（开启 proxy_protocol 时真实地址要读完 PROXY 头才知道，
+// 改为在 HTTP 层返回 403 banned）；被封禁的 token identify 时回 error 事件 code=banned。
+// 同一来源再次被封时封禁时长翻倍，最长 max_ban_seconds。
+// token 只保存 sha256 前缀，不落原文。
+
+// BansConfig 自动封禁配置
+type BansConfig struct {
+	Enabled       bool           `json:"enabled"`
+	Threshold     int            `json:"threshold"`       // 分数达到即封禁，默认 20
+	DecaySeconds  int            `json:"decay_seconds"`   // 每过多少秒扣 1 分，默认 30
+	BanSeconds    int            `json:"ban_seconds"`     // 首次封禁时长，默认 600
+	MaxBanSeconds int            `json:"max_ban_seconds"` // 反复被封时的封禁时长上限，默认 86400
+	Weights       map[string]int `json:"weights"`         // 各信号的分值，未配置的用默认值
+	ExemptCIDRs   []string       `json:"exempt_cidrs"`    // 不计分、不封禁的来源（内网 / 探活）
+}
+
+const (
+	banSignalUpgrade = "upgrade_failed"
+	banSignalAuth    = "auth_failed"
+	banSignalParse   = "parse_error"
+	banSignalRate    = "rate_violation"
+
+	bansDefaultThreshold  = 20
+	bansDefaultDecay      = 30
+	bansDefaultBanSeconds = 600
+	bansDefaultMaxBan     = 86400
+	bansSweepInterval     = time.Minute
+
+	banKindIP    = "ip"
+	banKindToken = "token"
+)
+
+// bansDefaultWeights 各信号的默认分值
+var bansDefaultWeights = map[string]int{
+	banSignalUpgrade: 1,
+	banSignalAuth:    3,
+	banSignalParse:   1,
+	banSignalRate:    10,
+}
+
+// errBanned identify 的 token 正在封禁中
+var errBanned = errors.New("temporarily banned")
+
+// banEntry 一个来源（ip:x / token:x）的计分与封禁状态
+type banEntry struct {
+	score       abuseScore
+	bannedUntil time.Time
+	strikes     int // 累计被封次数，决定下次封禁时长
+}
+
+// banRecord 管理接口里的一条封禁
+type banRecord struct {
+	Subject     string    `json:"subject"` // ip:1.2.3.4 / token:9f2c...
+	Score       int       `json:"score"`
+	Strikes     int       `json:"strikes"`
+	BannedUntil time.Time `json:"banned_until"`
+}
+
+var (
+	bansMu      sync.Mutex
+	banEntries  = make(map[string]*banEntry)
+	banExempt   []*net.IPNet
+	banSignals  = make(map[string]uint64) // 信号 -> 次数
+	bansIssued  = make(map[string]uint64) // ip / token -> 封禁次数
+	banRejected atomic.Uint64             // 因封禁被拒绝的连接 / 请求 / identify
+)
+
+func prepareBans(cfg *BansConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = bansDefaultThreshold
+	}
+	if cfg.DecaySeconds <= 0 {
+		cfg.DecaySeconds = bansDefaultDecay
+	}
+	if cfg.BanSeconds <= 0 {
+		cfg.BanSeconds = bansDefaultBanSeconds
+	}
+	if cfg.MaxBanSeconds < cfg.BanSeconds {
+		cfg.MaxBanSeconds = max(bansDefaultMaxBan, cfg.BanSeconds)
+	}
+	weights := make(map[string]int, len(bansDefaultWeights))
+	for k, v := range bansDefaultWeights {
+		weights[k] = v
+	}
+	for k, v := range cfg.Weights {
+		if _, ok := bansDefaultWeights[k]; !ok {
+			log.Fatalf("❌ bans.weights 不支持的信号: %s\n", k)
+		}
+		weights[k] = v
+	}
+	cfg.Weights = weights
+	for _, cidr := range cfg.ExemptCIDRs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("❌ bans.exempt_cidrs 无效 %q: %v\n", cidr, err)
+		}
+		banExempt = append(banExempt, n)
+	}
+}
+
+// hostOf 从 host:port 里取出 IP 部分
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// ipBanKey IP 的封禁标识，豁免的来源返回空
+func ipBanKey(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		for _, n := range banExempt {
+			if n.Contains(parsed) {
+				return ""
+			}
+		}
+	}
+	return banKindIP + ":" + ip
+}
+
+// tokenBanKey token 的封禁标识，只保留哈希前缀
+func tokenBanKey(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return banKindToken + ":" + hex.EncodeToString(sum[:8])
+}
+
+// recordAbuse 给一组来源（ipBanKey / tokenBanKey，空字符串跳过）记一次信号，返回是否有来源在封禁中
+func recordAbuse(signal string, subjects ...string) bool {
+	cfg := GlobalConfig.Bans
+	if !cfg.Enabled {
+		return false
+	}
+
+	now := time.Now()
+	decay := time.Duration(cfg.DecaySeconds) * time.Second
+	banned := false
+
+	bansMu.Lock()
+	defer bansMu.Unlock()
+	banSignals[signal]++
+	for _, s := range subjects {
+		if s == "" {
+			continue
+		}
+		e, ok := banEntries[s]
+		if !ok {
+			e = &banEntry{}
+			banEntries[s] = e
+		}
+		if now.Before(e.bannedUntil) {
+			banned = true
+			continue
+		}
+		if e.score.addN(now, decay, cfg.Weights[signal]) < cfg.Threshold {
+			continue
+		}
+		d := time.Duration(cfg.BanSeconds) * time.Second << min(e.strikes, 16)
+		d = min(d, time.Duration(cfg.MaxBanSeconds)*time.Second)
+		e.strikes++
+		e.bannedUntil = now.Add(d)
+		e.score = abuseScore{}
+		kind, _, _ := strings.Cut(s, ":")
+		bansIssued[kind]++
+		banned = true
+		log.Printf("🚷 临时封禁 %s %s（第 %d 次，最近信号 %s）\n", s, d, e.strikes, signal)
+	}
+	return banned
+}
+
+// bannedFor 来源是否在封禁中，返回剩余时长
+func bannedFor(subject string) (time.Duration, bool) {
+	if !GlobalConfig.Bans.Enabled || subject == "" {
+		return 0, false
+	}
+	bansMu.Lock()
+	defer bansMu.Unlock()
+	e, ok := banEntries[subject]
+	if !ok {
+		return 0, false
+	}
+	left := time.Until(e.bannedUntil)
+	return left, left > 0
+}
+
+func ipBanned(addr string) (time.Duration, bool) {
+	return bannedFor(ipBanKey(hostOf(addr)))
+}
+
+// recordRequestAbuse HTTP 请求上的信号记到来源 IP
+func recordRequestAbuse(r *http.Request, signal string) {
+	recordAbuse(signal, ipBanKey(hostOf(r.RemoteAddr)))
+}
+
+// recordConnAbuse 连接上的信号同时记到来源 IP 和它 identify 时用的 token
+func recordConnAbuse(c *Client, signal string) bool {
+	return recordAbuse(signal, ipBanKey(hostOf(c.remoteAddr)), c.banToken)
+}
+
+// ===== 拦截 =====
+
+// banListener 监听器包装：被封禁 IP 的连接 Accept 后直接关闭
+type banListener struct {
+	net.Listener
+}
+
+func (l banListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if _, banned := ipBanned(conn.RemoteAddr().String()); banned {
+			banRejected.Add(1)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
+
+// banGuard HTTP 层的封禁检查，开启 proxy_protocol 时 r.RemoteAddr 才是真实客户端地址
+func banGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if left, banned := ipBanned(r.RemoteAddr); banned {
+			banRejected.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(left.Seconds())+1))
+			writeProblem(w, r, http.StatusForbidden, problemBanned, "temporarily banned")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bansSweepLoop 定期清理分数已衰减完、也不在封禁中的来源
+func bansSweepLoop() {
+	ticker := time.NewTicker(bansSweepInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		decay := time.Duration(GlobalConfig.Bans.DecaySeconds) * time.Second
+		maxBan := time.Duration(GlobalConfig.Bans.MaxBanSeconds) * time.Second
+		bansMu.Lock()
+		for s, e := range banEntries {
+			// 封禁结束后再保留一个最长封禁周期的 strikes，反复作恶的来源封禁时长继续翻倍
+			last := e.score.updated
+			if e.bannedUntil.After(last) {
+				last = e.bannedUntil
+			}
+			if e.score.score-int(now.Sub(e.score.updated)/decay) <= 0 && now.Sub(last) > maxBan {
+				delete(banEntries, s)
+			}
+		}
+		bansMu.Unlock()
+	}
+}
+
+// ===== 管理接口与指标 =====
+
+// activeBans 当前生效的封禁，按解封时间排序
+func activeBans() []banRecord {
+	now := time.Now()
+	bansMu.Lock()
+	out := make([]banRecord, 0)
+	for s, e := range banEntries {
+		if now.Before(e.bannedUntil) {
+			out = append(out, banRecord{Subject: s, Score: e.score.score, Strikes: e.strikes, BannedUntil: e.bannedUntil})
+		}
+	}
+	bansMu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].BannedUntil.Before(out[j].BannedUntil) })
+	return out
+}
+
+// adminBansHandler GET /api/admin/bans 列出生效的封禁
+func adminBansHandler(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{"bans": activeBans()},
+	})
+}
+
+// adminUnbanHandler DELETE /api/admin/bans/{subject} 提前解封并清空计分
+func adminUnbanHandler(w http.ResponseWriter, r *http.Request) {
+	subject := r.PathValue("subject")
+	bansMu.Lock()
+	_, ok := banEntries[subject]
+	delete(banEntries, subject)
+	bansMu.Unlock()
+
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "no ban or score for: "+subject)
+		return
+	}
+	log.Printf("🔓 管理员解除封禁 %s\n", subject)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{"subject": subject},
+	})
+}
+
+// banCounts 各信号次数、各类封禁次数和当前生效的封禁数，用于指标
+func banCounts() (signals, issued map[string]uint64, active int) {
+	now := time.Now()
+	bansMu.Lock()
+	defer bansMu.Unlock()
+	signals = make(map[string]uint64, len(banSignals))
+	for k, v := range banSignals {
+		signals[k] = v
+	}
+	issued = map[string]uint64{banKindIP: bansIssued[banKindIP], banKindToken: bansIssued[banKindToken]}
+	for _, e := range banEntries {
+		if now.Before(e.bannedUntil) {
+			active++
+		}
+	}
+	return signals, issued, active
+}
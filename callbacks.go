@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 推送结果回调 =====
+//
+// 发完就走的生产者（异步队列、延迟任务、周期任务）拿不到同步的 delivered，又不想轮询投递状态时，
+// 推送请求可以带 callback_url，推送有了最终结果后中继 POST 给它：
+//
+//	{"event":"push_result","message_id":"msg_9f2c...","event_name":"order.paid","target_user_id":"u1",
+//	 "broadcast":false,"status":"delivered","delivered":2,"ts":1760580000000}
+//
+// status：
+//   - delivered / no_recipients：普通推送发送完成后回调，delivered 为投递到的连接数
+//   - acked / partial / failed / no_recipients：require_ack 推送在所有连接都确认或失败后回调，带 acks 计数
+//   - missed：延迟任务重启时错过发送时间、按 misfire 策略放弃
+//
+// 延迟任务和周期任务的回调带 job_id，周期任务每次发送各回调一次。回调由固定数量的 worker 发送，
+// 失败重试 retries 次，签名方式与准入 webhook 相同；队列满时丢弃并计数。
+// callback_url 需要开启 push_callbacks，可以用 allowed_hosts 限制回调目标，避免推送接口被用来探测内网。
+
+// PushCallbacksConfig 推送结果回调配置
+type PushCallbacksConfig struct {
+	Enabled        bool     `json:"enabled"`
+	Secret         string   `json:"secret"`          // 可选：请求签名密钥，支持密钥引用
+	TimeoutSeconds int      `json:"timeout_seconds"` // 默认 3
+	Retries        int      `json:"retries"`         // 失败重试次数，默认 2，-1 表示不重试
+	Workers        int      `json:"workers"`         // 并发发送的 worker 数，默认 4
+	AllowedHosts   []string `json:"allowed_hosts"`   // 允许的回调主机名，支持 * / ? 通配；为空表示不限制
+}
+
+const (
+	pushCallbackDefaultTimeout = 3
+	pushCallbackDefaultRetries = 2
+	pushCallbackDefaultWorkers = 4
+	pushCallbackQueueSize      = 4096
+	pushCallbackRetryBackoff   = time.Second
+	pushCallbackMaxURLLength   = 2048
+
+	pushResultEvent = "push_result"
+
+	pushResultDelivered = "delivered"
+	pushResultMissed    = "missed"
+)
+
+var errPushCallbacksNotEnabled = errors.New("callback_url needs push_callbacks.enabled")
+
+// pushResult 发给 callback_url 的请求体
+type pushResult struct {
+	Event        string         `json:"event"`
+	MessageID    string         `json:"message_id,omitempty"`
+	JobID        string         `json:"job_id,omitempty"`
+	EventName    string         `json:"event_name"`
+	TargetUserID string         `json:"target_user_id,omitempty"`
+	TargetUsers  []string       `json:"target_user_ids,omitempty"`
+	Channel      string         `json:"channel,omitempty"`
+	Group        string         `json:"group,omitempty"`
+	Broadcast    bool           `json:"broadcast"`
+	Status       string         `json:"status"`
+	Delivered    int            `json:"delivered"`
+	Users        map[string]int `json:"users,omitempty"` // 多用户推送各用户投递到的连接数
+	Acks         *ackCounts     `json:"acks,omitempty"`  // 只有 require_ack 推送有
+	Ts           int64          `json:"ts"`
+	NodeID       string         `json:"node_id,omitempty"`
+
+	url string
+}
+
+var (
+	pushCallbackClient *http.Client
+	pushCallbackQueue  chan pushResult
+
+	pushCallbacksOK      atomic.Uint64
+	pushCallbacksErrors  atomic.Uint64
+	pushCallbacksDropped atomic.Uint64
+)
+
+func preparePushCallbacks(cfg *PushCallbacksConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = pushCallbackDefaultTimeout
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = pushCallbackDefaultRetries
+	} else if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = pushCallbackDefaultWorkers
+	}
+	patterns := cfg.AllowedHosts[:0]
+	for _, p := range cfg.AllowedHosts {
+		if _, err := path.Match(p, ""); err != nil {
+			log.Printf("⚠️ push_callbacks.allowed_hosts 模式无效，已忽略: %s\n", p)
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	cfg.AllowedHosts = patterns
+	pushCallbackClient = &http.Client{
+		Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second,
+		// 不跟随重定向，否则 allowed_hosts 形同虚设；3xx 按失败处理
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// startPushCallbacks 启动发送 worker；要在恢复延迟任务之前调用，放弃的任务同样要回调
+func startPushCallbacks() {
+	pushCallbackQueue = make(chan pushResult, pushCallbackQueueSize)
+	for i := 0; i < GlobalConfig.PushCallbacks.Workers; i++ {
+		go func() {
+			for res := range pushCallbackQueue {
+				sendPushResult(res)
+			}
+		}()
+	}
+	log.Printf("✅ 推送结果回调已启用：%d 个 worker\n", GlobalConfig.PushCallbacks.Workers)
+}
+
+// validatePushCallback 推送请求里的 callback_url
+func validatePushCallback(body PushRequest) (string, error) {
+	if body.CallbackURL == "" {
+		return "", nil
+	}
+	cfg := GlobalConfig.PushCallbacks
+	switch {
+	case !cfg.Enabled:
+		return "callback_url", errPushCallbacksNotEnabled
+	case body.Ephemeral:
+		return "callback_url", errors.New("ephemeral pushes cannot have callback_url")
+	case len(body.CallbackURL) > pushCallbackMaxURLLength:
+		return "callback_url", errors.New("callback_url is too long")
+	}
+	u, err := url.Parse(body.CallbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "callback_url", errors.New("callback_url must be an absolute http(s) URL")
+	}
+	if !callbackHostAllowed(u.Hostname()) {
+		return "callback_url", errors.New("callback_url host is not allowed")
+	}
+	return "", nil
+}
+
+func callbackHostAllowed(host string) bool {
+	patterns := GlobalConfig.PushCallbacks.AllowedHosts
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// newPushResult 按推送构造回调请求体，status / delivered 由调用方填
+func newPushResult(p *preparedPush) pushResult {
+	res := pushResult{
+		Event:        pushResultEvent,
+		MessageID:    p.message.ID,
+		JobID:        p.jobID,
+		EventName:    p.body.EventName,
+		TargetUserID: p.target,
+		TargetUsers:  p.targets,
+		Channel:      p.body.Channel,
+		Group:        p.body.Group,
+		Broadcast:    p.broadcast(),
+		url:          p.body.CallbackURL,
+	}
+	if GlobalConfig.Cluster.Enabled {
+		res.NodeID = GlobalConfig.Cluster.NodeID
+	}
+	return res
+}
+
+// reportPushDelivered 普通推送发送完成
+func reportPushDelivered(p *preparedPush, delivered int) {
+	res := newPushResult(p)
+	res.Status, res.Delivered, res.Users = pushResultDelivered, delivered, p.userCounts
+	if delivered == 0 {
+		res.Status = ackNone
+	}
+	enqueuePushResult(res)
+}
+
+// enqueuePushResult 交给发送 worker，队列满时丢弃；调用方可能持有 ackMu，不能阻塞
+func enqueuePushResult(res pushResult) {
+	res.Ts = time.Now().UnixMilli()
+	select {
+	case pushCallbackQueue <- res:
+	default:
+		pushCallbacksDropped.Add(1)
+		log.Printf("⚠️ 推送结果回调队列已满，丢弃 message_id=%s event=%s\n", res.MessageID, res.EventName)
+	}
+}
+
+// sendPushResult 发送一次回调，失败按配置重试
+func sendPushResult(res pushResult) {
+	cfg := GlobalConfig.PushCallbacks
+	retry := webhookRetry{client: pushCallbackClient, retries: cfg.Retries, backoff: pushCallbackRetryBackoff}
+	if err := deliverWebhook(res.url, liveSecret("push_callbacks.secret", cfg.Secret), res, retry); err != nil {
+		pushCallbacksErrors.Add(1)
+		log.Printf("⚠️ 推送结果回调失败 message_id=%s status=%s: %v\n", res.MessageID, res.Status, err)
+		return
+	}
+	pushCallbacksOK.Add(1)
+}
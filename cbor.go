@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== CBOR（RFC 8949）编解码 =====
+//
+// 只覆盖 JSON 能表达的类型：整数 / 浮点 / 文本 / 数组 / map / true / false / null。
+// 解码时字节串按 base64 字符串处理，undefined 当作 null，tag 忽略只取内容，非字符串的 map key 转成字符串；
+// 不定长（indefinite-length）编码不支持。
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string        { return "cbor" }
+func (cborCodec) ContentType() string { return "application/cbor" }
+func (cborCodec) MessageType() int    { return websocket.BinaryMessage }
+
+func (cborCodec) Encode(v interface{}) ([]byte, error) {
+	g, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+	return appendCBOR(nil, g)
+}
+
+func (cborCodec) Decode(data []byte, v interface{}) error {
+	d := cborDecoder{buf: data}
+	g, err := d.value(0)
+	if err != nil {
+		return err
+	}
+	if d.pos != len(d.buf) {
+		return fmt.Errorf("trailing %d bytes after value", len(d.buf)-d.pos)
+	}
+	return fromGeneric(g, v)
+}
+
+const (
+	cborUint   = 0 << 5
+	cborNegint = 1 << 5
+	cborBytes  = 2 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborTag    = 6 << 5
+	cborSimple = 7 << 5
+)
+
+// appendCBORHead 写出主类型和长度 / 数值参数
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major|27), n)
+}
+
+func appendCBOR(b []byte, v interface{}) ([]byte, error) {
+	switch x := v.(type) {
+	case nil:
+		return append(b, cborSimple|22), nil
+	case bool:
+		if x {
+			return append(b, cborSimple|21), nil
+		}
+		return append(b, cborSimple|20), nil
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			if i >= 0 {
+				return appendCBORHead(b, cborUint, uint64(i)), nil
+			}
+			return appendCBORHead(b, cborNegint, uint64(-1-i)), nil
+		}
+		if u, err := strconv.ParseUint(string(x), 10, 64); err == nil {
+			return appendCBORHead(b, cborUint, u), nil
+		}
+		f, err := x.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(append(b, cborSimple|27), math.Float64bits(f)), nil
+	case string:
+		return append(appendCBORHead(b, cborText, uint64(len(x))), x...), nil
+	case []interface{}:
+		b = appendCBORHead(b, cborArray, uint64(len(x)))
+		var err error
+		for _, e := range x {
+			if b, err = appendCBOR(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = appendCBORHead(b, cborMap, uint64(len(x)))
+		var err error
+		for k, e := range x {
+			b = append(appendCBORHead(b, cborText, uint64(len(k))), k...)
+			if b, err = appendCBOR(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported type %T", v)
+}
+
+// cborDecoder 把 CBOR 解成 JSON 通用结构
+type cborDecoder struct {
+	buf []byte
+	pos int
+}
+
+// head 读一个数据项的头：主类型、附加信息和参数
+func (d *cborDecoder) head() (major, info byte, arg uint64, err error) {
+	if d.pos >= len(d.buf) {
+		return 0, 0, 0, errCodecTruncated
+	}
+	ib := d.buf[d.pos]
+	d.pos++
+	major, info = ib&0xe0, ib&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		size := 1 << (info - 24)
+		if size > len(d.buf)-d.pos {
+			return 0, 0, 0, errCodecTruncated
+		}
+		for _, c := range d.buf[d.pos : d.pos+size] {
+			arg = arg<<8 | uint64(c)
+		}
+		d.pos += size
+		return major, info, arg, nil
+	case info == 31:
+		return 0, 0, 0, fmt.Errorf("indefinite-length cbor is not supported")
+	}
+	return 0, 0, 0, fmt.Errorf("invalid cbor additional info %d", info)
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)-d.pos) {
+		return nil, errCodecTruncated
+	}
+	p := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return p, nil
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > codecMaxDepth {
+		return nil, errCodecTooDeep
+	}
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		return json.Number(strconv.FormatUint(arg, 10)), nil
+	case cborNegint:
+		if arg > math.MaxInt64 {
+			// -1-arg 超出 int64，JSON 里按浮点表示
+			return codecFloat(-1 - float64(arg))
+		}
+		return json.Number(strconv.FormatInt(-1-int64(arg), 10)), nil
+	case cborBytes:
+		p, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(p), nil
+	case cborText:
+		p, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(p), nil
+	case cborArray:
+		if arg > uint64(len(d.buf)-d.pos) {
+			return nil, errCodecTruncated
+		}
+		out := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case cborMap:
+		if arg > uint64(len(d.buf)-d.pos)/2 {
+			return nil, errCodecTruncated
+		}
+		out := make(map[string]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out[codecMapKey(k)] = v
+		}
+		return out, nil
+	case cborTag:
+		return d.value(depth + 1)
+	}
+
+	// 主类型 7：简单值和浮点
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return codecFloat(float16ToFloat64(uint16(arg)))
+	case 26:
+		return codecFloat(float64(math.Float32frombits(uint32(arg))))
+	case 27:
+		return codecFloat(math.Float64frombits(arg))
+	}
+	return nil, fmt.Errorf("unsupported cbor simple value %d", arg)
+}
+
+// float16ToFloat64 IEEE 754 半精度转双精度
+func float16ToFloat64(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	frac := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac == 0 {
+			return sign * math.Inf(1)
+		}
+		return math.NaN()
+	}
+	return sign * math.Ldexp(frac+1024, exp-25)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ===== Centrifugo / Centrifuge JSON 客户端协议适配 =====
+//
+// 兼容 centrifuge-js 等官方客户端（协议 v2，JSON 编码）：
+// 命令 connect / subscribe / unsubscribe / publish / presence / presence_stats，
+// 一帧内可以有多条以换行分隔的命令。connect.token 直接作为用户标识注册到用户组。
+// presence 里的 user 只在开启 client_jwt（用户 ID 来自校验过的声明）时返回，否则用户 ID 就是其他连接的 token，留空。
+
+// CentrifugoConfig Centrifugo 兼容端点配置
+type CentrifugoConfig struct {
+	Enabled      bool   `json:"enabled"`
+	Path         string `json:"path"`          // 默认 /connection/websocket
+	AllowPublish bool   `json:"allow_publish"` // 是否允许客户端 publish 到频道
+}
+
+const (
+	centrifugoDefaultPath = "/connection/websocket"
+	centrifugoVersion     = "GoRelay"
+	centrifugoPingSeconds = 25
+)
+
+// Centrifugo 错误码（与官方一致）
+const (
+	centrifugoErrUnauthorized     = 101
+	centrifugoErrPermissionDenied = 103
+	centrifugoErrMethodNotFound   = 104
+	centrifugoErrAlreadySub       = 105
+	centrifugoErrBadRequest       = 107
+)
+
+type centrifugoChannelRequest struct {
+	Channel string `json:"channel"`
+}
+
+type centrifugoCommand struct {
+	ID      uint32 `json:"id"`
+	Connect *struct {
+		Token string          `json:"token"`
+		Data  json.RawMessage `json:"data"`
+		Name  string          `json:"name"`
+	} `json:"connect"`
+	Subscribe     *centrifugoChannelRequest `json:"subscribe"`
+	Unsubscribe   *centrifugoChannelRequest `json:"unsubscribe"`
+	Presence      *centrifugoChannelRequest `json:"presence"`
+	PresenceStats *centrifugoChannelRequest `json:"presence_stats"`
+	Publish       *struct {
+		Channel string          `json:"channel"`
+		Data    json.RawMessage `json:"data"`
+	} `json:"publish"`
+}
+
+type centrifugoError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type centrifugoClientInfo struct {
+	Client string `json:"client"`
+	User   string `json:"user"`
+}
+
+type centrifugoPublication struct {
+	Data interface{}       `json:"data"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+func registerCentrifugoRoutes(mux *http.ServeMux) {
+	mux.HandleFunc(GlobalConfig.Centrifugo.Path, upgradeGuard(centrifugoWSHandler))
+	log.Printf("✅ Centrifugo 兼容端点已启用：ws %s\n", GlobalConfig.Centrifugo.Path)
+}
+
+// toCentrifugoFrame 频道消息转成 publication（事件名放在 tags.event），
+// 其余（单用户 / 广播）转成异步 message，data 为完整的 {event,data}
+func toCentrifugoFrame(msg WSMessage) interface{} {
+	if msg.Channel != "" {
+		return map[string]interface{}{
+			"push": map[string]interface{}{
+				"channel": msg.Channel,
+				"pub": centrifugoPublication{
+					Data: msg.Data,
+					Tags: map[string]string{"event": msg.Event},
+				},
+			},
+		}
+	}
+	return map[string]interface{}{
+		"push": map[string]interface{}{
+			"message": map[string]interface{}{"data": msg},
+		},
+	}
+}
+
+func centrifugoWSHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, withAffinityCookie(r, nil))
+	if err != nil {
+		log.Println("Centrifugo WebSocket upgrade error:", err)
+		return
+	}
+
+	client := newClient(conn, r)
+	client.frame, client.frameKey = toCentrifugoFrame, "centrifugo"
+	connected := false
+	done := make(chan struct{})
+
+	defer func() {
+		close(done)
+		conn.Close()
+		if connected {
+			removeClient(client)
+		}
+	}()
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			noteReadError(client, "Centrifugo", err)
+			break
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		for {
+			var cmd centrifugoCommand
+			if err := dec.Decode(&cmd); err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Println("⚠️ Centrifugo command parse error:", err)
+				}
+				break
+			}
+
+			// 空命令 {} 是客户端对服务端 ping 的回应
+			if cmd.ID == 0 {
+				continue
+			}
+
+			if cmd.Connect != nil {
+				if connected {
+					centrifugoReplyError(client, cmd.ID, centrifugoErrBadRequest, "already connected")
+					continue
+				}
+				connected = true
+				addClient(client)
+				if cmd.Connect.Token != "" {
+					if _, err := identifyUser(client, cmd.Connect.Token); err != nil {
+						centrifugoReplyError(client, cmd.ID, centrifugoErrUnauthorized, "unauthorized")
+						return
+					}
+				}
+				_ = client.sendJSON(map[string]interface{}{
+					"id": cmd.ID,
+					"connect": map[string]interface{}{
+						"client":  client.id,
+						"version": centrifugoVersion,
+						"ping":    centrifugoPingSeconds,
+						"pong":    true,
+					},
+				})
+				go centrifugoPingLoop(client, done)
+				continue
+			}
+
+			if !connected {
+				centrifugoReplyError(client, cmd.ID, centrifugoErrUnauthorized, "unauthorized")
+				continue
+			}
+			centrifugoHandleCommand(client, &cmd)
+		}
+	}
+}
+
+func centrifugoHandleCommand(c *Client, cmd *centrifugoCommand) {
+	switch {
+	case cmd.Subscribe != nil:
+		ch := cmd.Subscribe.Channel
+		if ch == "" {
+			centrifugoReplyError(c, cmd.ID, centrifugoErrBadRequest, "bad request")
+			return
+		}
+		if !nativeChannelAllowed(ch) {
+			centrifugoReplyError(c, cmd.ID, centrifugoErrPermissionDenied, "permission denied")
+			return
+		}
+		if isSubscribed(c, ch) {
+			centrifugoReplyError(c, cmd.ID, centrifugoErrAlreadySub, "already subscribed")
+			return
+		}
+		total := subscribeChannel(c, ch)
+		log.Printf("📡 Centrifugo 订阅 channel=%s client=%s, 频道连接数=%d\n", ch, c.id, total)
+		centrifugoReply(c, cmd.ID, "subscribe", map[string]interface{}{})
+
+	case cmd.Unsubscribe != nil:
+		unsubscribeChannel(c, cmd.Unsubscribe.Channel)
+		centrifugoReply(c, cmd.ID, "unsubscribe", map[string]interface{}{})
+
+	case cmd.Publish != nil:
+		if !GlobalConfig.Centrifugo.AllowPublish || readOnly.Load() || !isSubscribed(c, cmd.Publish.Channel) {
+			centrifugoReplyError(c, cmd.ID, centrifugoErrPermissionDenied, "permission denied")
+			return
+		}
+		// 上行白名单按固定的事件名 publication 检查，被拒绝同样计入 abuse 分数；断开时读循环随之退出
+		if e := inboundEventError(c, "publication"); e != nil {
+			rejectInboundWith(c, e, func(e *clientError) error {
+				return c.sendJSON(map[string]interface{}{"id": cmd.ID, "error": centrifugoError{Code: centrifugoErrPermissionDenied, Message: e.Msg}})
+			})
+			return
+		}
+		var data interface{}
+		if err := json.Unmarshal(cmd.Publish.Data, &data); err != nil {
+			centrifugoReplyError(c, cmd.ID, centrifugoErrBadRequest, "bad request")
+			return
+		}
+		sent := emitToChannel(cmd.Publish.Channel, WSMessage{Event: "publication", Channel: cmd.Publish.Channel, Data: data}, "")
+		if shouldLogEvent("publication") {
+			log.Printf("📡 Centrifugo publish channel=%s client=%s, 送达连接数=%d\n", cmd.Publish.Channel, c.id, sent)
+		}
+		publishInbound(c, "centrifugo", "publication", cmd.Publish.Channel, data)
+		centrifugoReply(c, cmd.ID, "publish", map[string]interface{}{})
+
+	case cmd.Presence != nil:
+		if !isSubscribed(c, cmd.Presence.Channel) {
+			centrifugoReplyError(c, cmd.ID, centrifugoErrPermissionDenied, "permission denied")
+			return
+		}
+		presence := make(map[string]centrifugoClientInfo)
+		for _, m := range channelMembers(cmd.Presence.Channel) {
+			presence[m.id] = centrifugoClientInfo{Client: m.id, User: presenceUserID(m.userID)}
+		}
+		centrifugoReply(c, cmd.ID, "presence", map[string]interface{}{"presence": presence})
+
+	case cmd.PresenceStats != nil:
+		if !isSubscribed(c, cmd.PresenceStats.Channel) {
+			centrifugoReplyError(c, cmd.ID, centrifugoErrPermissionDenied, "permission denied")
+			return
+		}
+		members := channelMembers(cmd.PresenceStats.Channel)
+		users := make(map[string]struct{})
+		for _, m := range members {
+			users[m.userID] = struct{}{}
+		}
+		centrifugoReply(c, cmd.ID, "presence_stats", map[string]interface{}{
+			"num_clients": len(members),
+			"num_users":   len(users),
+		})
+
+	default:
+		centrifugoReplyError(c, cmd.ID, centrifugoErrMethodNotFound, "method not found")
+	}
+}
+
+// centrifugoPingLoop 按约定间隔发送空命令作为 ping，连接关闭后退出
+func centrifugoPingLoop(c *Client, done <-chan struct{}) {
+	ticker := time.NewTicker(centrifugoPingSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.sendJSON(struct{}{}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// presenceUserID 可以给其他订阅者看的用户 ID：没开 client_jwt 时用户 ID 是连接的 token，不能外露
+func presenceUserID(userID string) string {
+	if !GlobalConfig.ClientJWT.Enabled {
+		return ""
+	}
+	return userID
+}
+
+func centrifugoReply(c *Client, id uint32, method string, result interface{}) {
+	_ = c.sendJSON(map[string]interface{}{"id": id, method: result})
+}
+
+func centrifugoReplyError(c *Client, id uint32, code int, message string) {
+	_ = c.sendJSON(map[string]interface{}{
+		"id":    id,
+		"error": centrifugoError{Code: code, Message: message},
+	})
+}
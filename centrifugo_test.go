@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCentrifugoPresenceHidesTokens(t *testing.T) {
+	for _, jwt := range []bool{false, true} {
+		useConfig(t, func(cfg *Config) { cfg.ClientJWT.Enabled = jwt })
+
+		a, connA := newMemClient(defaultHub, "secret-token-a")
+		b, _ := newMemClient(defaultHub, "secret-token-b")
+		t.Cleanup(func() {
+			defaultHub.removeClient(a)
+			defaultHub.removeClient(b)
+		})
+		subscribeChannel(a, "room")
+		subscribeChannel(b, "room")
+
+		centrifugoHandleCommand(a, &centrifugoCommand{ID: 7, Presence: &centrifugoChannelRequest{Channel: "room"}})
+		f, ok := connA.next(time.Second)
+		if !ok {
+			t.Fatal("没有收到 presence 回复")
+		}
+		var reply struct {
+			Presence struct {
+				Presence map[string]centrifugoClientInfo `json:"presence"`
+			} `json:"presence"`
+		}
+		if err := json.Unmarshal(f.Data, &reply); err != nil {
+			t.Fatal(err)
+		}
+		info, ok := reply.Presence.Presence[b.id]
+		switch {
+		case !ok:
+			t.Fatalf("presence 里没有连接 %s: %s", b.id, f.Data)
+		case !jwt && info.User != "":
+			t.Fatalf("未开启 client_jwt 时 presence 泄露了 token: %q", info.User)
+		case jwt && info.User != "secret-token-b":
+			t.Fatalf("开启 client_jwt 时 user = %q", info.User)
+		}
+	}
+}
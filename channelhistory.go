@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ===== 每频道消息历史 =====
+//
+// 每个频道一个定长环形缓冲，保存最近 size 条频道消息（瞬时消息除外），后加入的订阅者可以补看：
+//
+//	{"type":"subscribe","channel":"room:42","history":20}
+//	→ {"event":"subscribed","channel":"room:42","data":null}
+//	→ {"event":"channel_history","channel":"room:42","data":{"messages":[{"seq":118,"ts":...,"event":"chat.message","data":{...}}]}}
+//
+// 服务端也可以用 GET /api/history?channel=room:42&limit=50 查询（admin 认证）。每条消息按频道分配递增的 seq。
+// 没有订阅者的频道也照常记录；频道 ttl_seconds 内没有新消息就丢弃其历史，频道数超过 max_channels 时淘汰最久没有消息的。
+
+// ChannelHistoryConfig 频道历史配置，Size 为 0 表示关闭
+type ChannelHistoryConfig struct {
+	Size        int `json:"size"`         // 每个频道保留的最近消息条数，最大 1000
+	TTLSeconds  int `json:"ttl_seconds"`  // 频道多久没有新消息就丢弃其历史，默认 3600
+	MaxChannels int `json:"max_channels"` // 最多保留多少个频道的历史，默认 10000
+}
+
+const (
+	channelHistoryMaxSize         = 1000
+	channelHistoryDefaultTTL      = 3600
+	channelHistoryDefaultChannels = 10000
+
+	channelHistoryEvent = "channel_history"
+)
+
+// channelHistoryEntry 历史里的一条频道消息
+type channelHistoryEntry struct {
+	Seq   uint64      `json:"seq"`
+	Ts    int64       `json:"ts"`
+	Event string      `json:"event"`
+	ID    string      `json:"id,omitempty"`
+	Data  interface{} `json:"data"`
+}
+
+type channelHistory struct {
+	seq     uint64
+	buf     []channelHistoryEntry // 环形缓冲
+	next    int
+	updated time.Time
+}
+
+var (
+	channelHistoryMu sync.Mutex
+	channelHistories = make(map[string]*channelHistory)
+)
+
+func prepareChannelHistory(cfg *ChannelHistoryConfig) {
+	if cfg.Size <= 0 {
+		cfg.Size = 0
+		return
+	}
+	if cfg.Size > channelHistoryMaxSize {
+		log.Printf("⚠️ channel_history.size 超过 %d，按 %d 处理\n", channelHistoryMaxSize, channelHistoryMaxSize)
+		cfg.Size = channelHistoryMaxSize
+	}
+	if cfg.TTLSeconds <= 0 {
+		cfg.TTLSeconds = channelHistoryDefaultTTL
+	}
+	if cfg.MaxChannels <= 0 {
+		cfg.MaxChannels = channelHistoryDefaultChannels
+	}
+}
+
+// recordChannelHistory 把频道消息写入该频道的历史
+func recordChannelHistory(channel string, msg WSMessage) {
+	cfg := GlobalConfig.ChannelHistory
+	now := time.Now()
+
+	channelHistoryMu.Lock()
+	defer channelHistoryMu.Unlock()
+
+	h, ok := channelHistories[channel]
+	if !ok {
+		if len(channelHistories) >= cfg.MaxChannels {
+			evictOldestChannelHistoryLocked()
+		}
+		h = &channelHistory{buf: make([]channelHistoryEntry, 0, cfg.Size)}
+		channelHistories[channel] = h
+	}
+
+	h.seq++
+	e := channelHistoryEntry{Seq: h.seq, Ts: now.UnixMilli(), Event: msg.Event, ID: msg.ID, Data: msg.Data}
+	if len(h.buf) < cap(h.buf) {
+		h.buf = append(h.buf, e)
+	} else {
+		h.buf[h.next] = e
+		h.next = (h.next + 1) % len(h.buf)
+	}
+	h.updated = now
+}
+
+// evictOldestChannelHistoryLocked 淘汰最久没有新消息的频道；调用方持有 channelHistoryMu
+func evictOldestChannelHistoryLocked() {
+	var oldest string
+	var oldestAt time.Time
+	for ch, h := range channelHistories {
+		if oldest == "" || h.updated.Before(oldestAt) {
+			oldest, oldestAt = ch, h.updated
+		}
+	}
+	delete(channelHistories, oldest)
+}
+
+// channelHistorySince 按顺序返回 ts >= since 的前 limit 条；since 为 0 时返回最近的 limit 条。
+// 第二个返回值表示范围内还有没返回的消息
+func channelHistorySince(channel string, since int64, limit int) ([]channelHistoryEntry, bool) {
+	channelHistoryMu.Lock()
+	defer channelHistoryMu.Unlock()
+
+	out := []channelHistoryEntry{}
+	h, ok := channelHistories[channel]
+	if !ok {
+		return out, false
+	}
+	for i := 0; i < len(h.buf); i++ {
+		e := h.buf[(h.next+i)%len(h.buf)]
+		if e.Ts >= since {
+			out = append(out, e)
+		}
+	}
+	if len(out) <= limit {
+		return out, false
+	}
+	if since == 0 {
+		return out[len(out)-limit:], true
+	}
+	return out[:limit], true
+}
+
+// sendChannelHistory 订阅时带了 history，把最近的消息作为一个 channel_history 事件补发
+func sendChannelHistory(c *Client, channel string, n int) error {
+	if n > GlobalConfig.ChannelHistory.Size {
+		n = GlobalConfig.ChannelHistory.Size
+	}
+	msgs, _ := channelHistorySince(channel, 0, n)
+	return c.deliver(WSMessage{Event: channelHistoryEvent, Channel: channel, Data: map[string]interface{}{"messages": msgs}})
+}
+
+// channelHistorySweepLoop 定期清理过期的频道历史
+func channelHistorySweepLoop() {
+	ttl := time.Duration(GlobalConfig.ChannelHistory.TTLSeconds) * time.Second
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().Add(-ttl)
+		channelHistoryMu.Lock()
+		removed := 0
+		for ch, h := range channelHistories {
+			if h.updated.Before(cutoff) {
+				delete(channelHistories, ch)
+				removed++
+			}
+		}
+		remaining := len(channelHistories)
+		channelHistoryMu.Unlock()
+
+		if removed > 0 {
+			log.Printf("🧹 清理过期频道历史 %d 个，剩余 %d 个\n", removed, remaining)
+		}
+	}
+}
+
+// historyHandler GET /api/history：开启 channel_history 时按 channel 查询走内存历史，其余条件交给归档查询
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	channel := q.Get("channel")
+	memory := GlobalConfig.ChannelHistory.Size > 0 && channel != "" &&
+		q.Get("user_id") == "" && q.Get("event") == "" && q.Get("until") == ""
+	if !memory {
+		if archiveFiles == nil {
+			writeProblem(w, r, http.StatusBadRequest, problemValidation, "only channel queries (channel, since, limit) are supported without archive")
+			return
+		}
+		archiveHistoryHandler(w, r)
+		return
+	}
+
+	var since int64
+	if s := q.Get("since"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			writeValidationProblem(w, r, "since", "invalid since: must be a unix timestamp in milliseconds")
+			return
+		}
+		since = n
+	}
+	limit := archiveQueryDefaultLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > archiveQueryMaxLimit {
+			writeValidationProblem(w, r, "limit", fmt.Sprintf("invalid limit: must be 1-%d", archiveQueryMaxLimit))
+			return
+		}
+		limit = n
+	}
+
+	msgs, more := channelHistorySince(channel, since, limit)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{
+			"channel":  channel,
+			"messages": msgs,
+			"has_more": more,
+		},
+	})
+}
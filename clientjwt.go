@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 客户端 identify 使用 OIDC / JWT access token =====
+//
+// 开启后客户端上报的 token（?token= / identify / 各兼容协议的 token）必须是 issuer 签发的 JWT：
+// 通过 JWKS 校验签名、aud、exp（audience 必须配置，没有 exp 的 token 一律拒绝），用户 ID 取 user_claim（默认 sub）。
+// 每 revalidate_seconds 重新检查一次：token 过期，或开启 introspect 后被 IdP 标记为失效的，
+// 先下发 session_expired 事件，再以 CloseSessionExpired 关闭连接。
+// 未开启时 token 直接作为用户 ID，行为不变。
+
+// ClientJWTConfig 客户端 token 校验配置
+type ClientJWTConfig struct {
+	Enabled           bool   `json:"enabled"`
+	Issuer            string `json:"issuer"`
+	JWKSURL           string `json:"jwks_url"` // 为空时从 issuer 的发现文档获取
+	Audience          string `json:"audience"`
+	UserClaim         string `json:"user_claim"`         // 默认 sub
+	RevalidateSeconds int    `json:"revalidate_seconds"` // 默认 60
+
+	// 可选：RFC 7662 token introspection，用于发现被吊销的 token
+	Introspect       bool   `json:"introspect"`
+	IntrospectionURL string `json:"introspection_url"` // 为空时从发现文档获取
+	ClientID         string `json:"client_id"`
+	ClientSecret     string `json:"client_secret"` // 支持密钥引用
+}
+
+// CloseSessionExpired token 过期 / 被吊销时的关闭码（对应 HTTP 401）
+const CloseSessionExpired = 4401
+
+const clientJWTDefaultRevalidate = 60
+
+// clientJWTSession 一个通过 JWT 认证的连接
+type clientJWTSession struct {
+	token     string
+	userID    string
+	expiresAt time.Time
+}
+
+var (
+	clientJWTVerifier     *jwtVerifier
+	clientJWTIntrospectAt string
+
+	clientJWTSessions   = make(map[*Client]*clientJWTSession)
+	clientJWTSessionsMu sync.Mutex
+)
+
+// initClientJWT 准备 JWKS 与 introspection 地址
+func initClientJWT() {
+	cfg := &GlobalConfig.ClientJWT
+	if cfg.Issuer == "" {
+		log.Fatalln("❌ client_jwt 需要 issuer")
+	}
+	if cfg.Audience == "" {
+		// 不校验 aud 时，同一 IdP 给其它应用签发的 token 也能登录
+		log.Fatalln("❌ client_jwt 需要 audience")
+	}
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = "sub"
+	}
+	if cfg.RevalidateSeconds <= 0 {
+		cfg.RevalidateSeconds = clientJWTDefaultRevalidate
+	}
+
+	jwksURL, introspectURL := cfg.JWKSURL, cfg.IntrospectionURL
+	if jwksURL == "" || (cfg.Introspect && introspectURL == "") {
+		p, err := discoverOIDC(cfg.Issuer)
+		if err != nil {
+			log.Fatalf("❌ client_jwt 发现文档拉取失败 %v\n", err)
+		}
+		if jwksURL == "" {
+			jwksURL = p.JWKSURI
+		}
+		if introspectURL == "" {
+			introspectURL = p.IntrospectionEndpoint
+		}
+	}
+	if cfg.Introspect && introspectURL == "" {
+		log.Fatalln("❌ client_jwt 开启了 introspect 但找不到 introspection 地址")
+	}
+
+	clientJWTVerifier = &jwtVerifier{
+		KeySet:     newJWKSCache(jwksURL),
+		Issuer:     cfg.Issuer,
+		Audience:   cfg.Audience,
+		RequireExp: true,
+		Leeway:     30 * time.Second,
+	}
+	if cfg.Introspect {
+		clientJWTIntrospectAt = introspectURL
+	}
+	go clientJWTRevalidateLoop()
+	log.Printf("✅ 客户端 JWT 校验已启用：issuer=%s aud=%s\n", cfg.Issuer, cfg.Audience)
+}
+
+// identifyUser 客户端上报 token 时统一走这里：开启 client_jwt 时校验后取用户 ID，否则 token 即用户 ID
+func identifyUser(c *Client, token string) (string, error) {
+	return identifyUserSince(c, token, 0)
+}
+
+// identifyUserSince 同 identifyUser，after > 0 时在离线消息之前补发序号大于 after 的单用户历史（原生连接的 last_seq）
+func identifyUserSince(c *Client, token string, after uint64) (string, error) {
+	if _, banned := bannedFor(tokenBanKey(token)); banned {
+		banRejected.Add(1)
+		log.Printf("🚷 token 封禁中，拒绝 identify conn=%s\n", c.id)
+		return "", errBanned
+	}
+	if !GlobalConfig.ClientJWT.Enabled {
+		if err := checkSingleSession(c, token); err != nil {
+			return "", err
+		}
+		beginBacklog(c, after)
+		registerUser(c, token)
+		c.banToken = tokenBanKey(token)
+		if GlobalConfig.AuthExpiry.Enabled {
+			trackAuthExpiry(c, time.Time{})
+		}
+		kickOlderSessions(c, token)
+		deliverBacklog(c, token, after)
+		return token, nil
+	}
+
+	claims, err := clientJWTVerifier.verify(token)
+	if err != nil {
+		log.Printf("❌ 客户端 token 校验失败 conn=%s: %v\n", c.id, err)
+		return "", identifyAuthFailed(c, token, err)
+	}
+	userID := claims.str(GlobalConfig.ClientJWT.UserClaim)
+	if userID == "" {
+		return "", identifyAuthFailed(c, token, fmt.Errorf("token 缺少 %s", GlobalConfig.ClientJWT.UserClaim))
+	}
+	if err := checkSingleSession(c, userID); err != nil {
+		return "", err
+	}
+
+	exp, _ := claims["exp"].(float64) // verify 已保证有 exp
+	sess := &clientJWTSession{token: token, userID: userID, expiresAt: time.Unix(int64(exp), 0)}
+	clientJWTSessionsMu.Lock()
+	clientJWTSessions[c] = sess
+	clientJWTSessionsMu.Unlock()
+
+	beginBacklog(c, after)
+	registerUser(c, userID)
+	c.banToken = tokenBanKey(token)
+	if GlobalConfig.AuthExpiry.Enabled {
+		trackAuthExpiry(c, sess.expiresAt)
+	}
+	kickOlderSessions(c, userID)
+	deliverBacklog(c, userID, after)
+	return userID, nil
+}
+
+// identifyAuthFailed token 校验失败计入来源 IP 和该 token 的滥用分数，因此被封禁时返回 errBanned
+func identifyAuthFailed(c *Client, token string, err error) error {
+	if recordAbuse(banSignalAuth, ipBanKey(hostOf(c.remoteAddr)), tokenBanKey(token)) {
+		return errBanned
+	}
+	return err
+}
+
+// sendInvalidToken 原生 / SSE 连接 identify 失败时的提示；被封禁时提示后断开
+func sendInvalidToken(c *Client, err error) {
+	code := "invalid_token"
+	switch {
+	case errors.Is(err, errSessionConflict):
+		code = "session_conflict"
+	case errors.Is(err, errBanned):
+		code = "banned"
+	}
+	_ = c.deliver(WSMessage{
+		Event: "error",
+		Data: map[string]interface{}{
+			"code": code,
+			"msg":  err.Error(),
+		},
+	})
+	if code == "banned" {
+		c.closeWithCode(websocket.ClosePolicyViolation, "temporarily banned")
+	}
+}
+
+// clientJWTToken 返回连接 identify 时使用的 access token
+func clientJWTToken(c *Client) string {
+	clientJWTSessionsMu.Lock()
+	defer clientJWTSessionsMu.Unlock()
+	if s, ok := clientJWTSessions[c]; ok {
+		return s.token
+	}
+	return ""
+}
+
+// forgetClientJWT 连接断开时清理
+func forgetClientJWT(c *Client) {
+	clientJWTSessionsMu.Lock()
+	delete(clientJWTSessions, c)
+	clientJWTSessionsMu.Unlock()
+}
+
+// clientJWTRevalidateLoop 定期检查 token 是否过期 / 被吊销
+func clientJWTRevalidateLoop() {
+	ticker := time.NewTicker(time.Duration(GlobalConfig.ClientJWT.RevalidateSeconds) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		clientJWTSessionsMu.Lock()
+		snapshot := make(map[*Client]*clientJWTSession, len(clientJWTSessions))
+		for c, s := range clientJWTSessions {
+			snapshot[c] = s
+		}
+		clientJWTSessionsMu.Unlock()
+
+		now := time.Now()
+		for c, s := range snapshot {
+			reason := ""
+			// 开启 auth_expiry 时过期由它负责（先提醒再处理），这里只看吊销
+			if !GlobalConfig.AuthExpiry.Enabled && now.After(s.expiresAt) {
+				reason = "token expired"
+			} else if clientJWTIntrospectAt != "" {
+				active, err := introspectToken(s.token)
+				if err != nil {
+					// IdP 不可用时不踢人，下次再查
+					log.Println("⚠️ token introspection 失败:", err)
+					continue
+				}
+				if !active {
+					reason = "token revoked"
+				}
+			}
+			if reason != "" {
+				expireClientSession(c, s, reason)
+			}
+		}
+	}
+}
+
+func expireClientSession(c *Client, s *clientJWTSession, reason string) {
+	forgetClientJWT(c)
+	log.Printf("⛔ 连接 %s（user_id=%s）会话失效：%s\n", c.id, logUserID(s.userID), reason)
+	_ = c.deliver(WSMessage{Event: "session_expired", Data: map[string]interface{}{"reason": reason}})
+	c.closeWithCode(CloseSessionExpired, reason)
+}
+
+// introspectToken 调用 RFC 7662 introspection 接口
+func introspectToken(token string) (bool, error) {
+	cfg := GlobalConfig.ClientJWT
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+	req, err := http.NewRequest(http.MethodPost, clientJWTIntrospectAt, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(cfg.ClientID, liveSecret("client_jwt.client_secret", cfg.ClientSecret))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.New(resp.Status)
+	}
+	var out struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, err
+	}
+	return out.Active, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 断开原因分类 =====
+//
+// 读循环退出时按错误归类，正常的断开（客户端 1000 / 1001、服务端主动关闭）不再当作告警打日志，
+// 只计数；真正的异常（1006 网络中断、协议错误、心跳超时等）照常记日志。
+// 各原因的累计次数在指标 relay_disconnects_total{reason} 和 GET /api/admin/stats 的 disconnects 里。
+
+const (
+	disconnectNormal    = "normal"         // 1000
+	disconnectGoingAway = "going_away"     // 1001：页面关闭 / App 切后台
+	disconnectNoStatus  = "no_status"      // 1005：关闭帧不带状态码
+	disconnectServer    = "server_closed"  // 服务端主动关闭（踢下线、认证过期、回收等）
+	disconnectAbnormal  = "abnormal"       // 1006：没有关闭帧就断了（网络中断、进程被杀）
+	disconnectTimeout   = "timeout"        // 心跳 / 读超时
+	disconnectProtocol  = "protocol_error" // 1002 / 1003 / 1007
+	disconnectTooLarge  = "too_large"      // 1009 或超过读取上限
+	disconnectPolicy    = "policy"         // 1008
+	disconnectAppCode   = "app_code"       // 客户端发来的 4000～4999
+	disconnectError     = "error"          // 其它读错误
+)
+
+// disconnectReasons 指标里固定输出的原因，顺序即输出顺序
+var disconnectReasons = []string{
+	disconnectNormal, disconnectGoingAway, disconnectNoStatus, disconnectServer,
+	disconnectAbnormal, disconnectTimeout, disconnectProtocol, disconnectTooLarge,
+	disconnectPolicy, disconnectAppCode, disconnectError,
+}
+
+var (
+	disconnectsMu sync.Mutex
+	disconnects   = make(map[string]uint64)
+)
+
+// disconnectReason 把读循环的错误归类
+func disconnectReason(c *Client, err error) string {
+	if c != nil && c.serverClosed.Load() {
+		return disconnectServer
+	}
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		switch {
+		case ce.Code == websocket.CloseNormalClosure:
+			return disconnectNormal
+		case ce.Code == websocket.CloseGoingAway:
+			return disconnectGoingAway
+		case ce.Code == websocket.CloseNoStatusReceived:
+			return disconnectNoStatus
+		case ce.Code == websocket.CloseAbnormalClosure:
+			return disconnectAbnormal
+		case ce.Code == websocket.CloseMessageTooBig:
+			return disconnectTooLarge
+		case ce.Code == websocket.ClosePolicyViolation:
+			return disconnectPolicy
+		case ce.Code == websocket.CloseProtocolError, ce.Code == websocket.CloseUnsupportedData,
+			ce.Code == websocket.CloseInvalidFramePayloadData:
+			return disconnectProtocol
+		case ce.Code >= 4000 && ce.Code <= 4999:
+			return disconnectAppCode
+		}
+		return disconnectError
+	}
+	var ne net.Error
+	switch {
+	case errors.As(err, &ne) && ne.Timeout():
+		return disconnectTimeout
+	case errors.Is(err, websocket.ErrReadLimit):
+		return disconnectTooLarge
+	case errors.Is(err, io.EOF):
+		// 原始 TCP 连接对端正常关闭
+		return disconnectNormal
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return disconnectAbnormal
+	}
+	return disconnectError
+}
+
+// expectedDisconnect 属于日常断开，不需要告警
+func expectedDisconnect(reason string) bool {
+	switch reason {
+	case disconnectNormal, disconnectGoingAway, disconnectNoStatus, disconnectServer:
+		return true
+	}
+	return false
+}
+
+// noteReadError 读循环退出时调用：计数，异常断开才打日志；proto 用于日志前缀（WebSocket / Pusher / TCP ...）
+func noteReadError(c *Client, proto string, err error) {
+	reason := disconnectReason(c, err)
+	disconnectsMu.Lock()
+	disconnects[reason]++
+	disconnectsMu.Unlock()
+
+	switch {
+	case expectedDisconnect(reason):
+	case reason == disconnectTimeout:
+		log.Printf("💤 %s 心跳超时，断开连接 conn=%s user_id=%s class=%s\n", proto, c.id, logUserID(c.userID), c.heartbeat.Class)
+	default:
+		log.Printf("⚠️ %s read error conn=%s reason=%s: %v\n", proto, c.id, reason, err)
+	}
+}
+
+// disconnectCounts 各断开原因的累计次数
+func disconnectCounts() map[string]uint64 {
+	disconnectsMu.Lock()
+	defer disconnectsMu.Unlock()
+	out := make(map[string]uint64, len(disconnectReasons))
+	for _, reason := range disconnectReasons {
+		out[reason] = disconnects[reason]
+	}
+	return out
+}
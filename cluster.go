@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ===== 集群节点 =====
+//
+// 多个 relay 节点之间目前只有点对点的内部接口（/api/cluster/...），不共享连接表。
+// 节点间请求用 cluster.secret 签名，格式与推送签名一致（X-Relay-Timestamp / Nonce / Signature）。
+
+// ClusterConfig 集群节点配置
+type ClusterConfig struct {
+	Enabled   bool              `json:"enabled"`
+	NodeID    string            `json:"node_id"`    // 默认主机名
+	PublicURL string            `json:"public_url"` // 客户端连本节点用的 WebSocket 地址，如 wss://node-a.example.com/ws
+	Secret    string            `json:"secret"`     // 节点间请求签名密钥，各节点一致，支持密钥引用
+	Peers     map[string]string `json:"peers"`      // node_id -> 内部 HTTP 地址，如 http://10.0.0.2:3000
+
+	AffinityCookie string `json:"affinity_cookie"` // 可选：升级时写入 {name}={node_id} 的 cookie，供 LB 粘性路由
+}
+
+const (
+	clusterRequestTimeout = 10 * time.Second
+	clusterMaxSkew        = 5 * time.Minute
+)
+
+var clusterHTTP = &http.Client{Timeout: clusterRequestTimeout}
+
+// initCluster 校验配置，填默认节点 ID
+func initCluster() {
+	cfg := &GlobalConfig.Cluster
+	if cfg.Secret == "" {
+		log.Fatalln("❌ cluster 需要 secret")
+	}
+	if cfg.NodeID == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			log.Fatalln("❌ cluster.node_id 为空且无法获取主机名:", err)
+		}
+		cfg.NodeID = host
+	}
+	startPushNonceSweep()
+	log.Printf("✅ 集群节点已启用：node_id=%s，已知 peer %d 个\n", cfg.NodeID, len(cfg.Peers))
+}
+
+// clusterPeerURL peer 可以写 node_id（从 peers 里查）或者直接写地址
+func clusterPeerURL(peer string) (string, error) {
+	if u, ok := GlobalConfig.Cluster.Peers[peer]; ok {
+		return strings.TrimSuffix(u, "/"), nil
+	}
+	if strings.HasPrefix(peer, "http://") || strings.HasPrefix(peer, "https://") {
+		return strings.TrimSuffix(peer, "/"), nil
+	}
+	return "", fmt.Errorf("未知的 peer %q", peer)
+}
+
+// checkClusterSignature 节点间接口的签名中间件
+func checkClusterSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := liveSecret("cluster.secret", GlobalConfig.Cluster.Secret)
+		if err := verifyRequestSignature(w, r, secret, clusterMaxSkew); err != nil {
+			log.Println("❌ 集群请求签名校验失败:", err)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeBodyError(w, r, err)
+				return
+			}
+			writeProblem(w, r, http.StatusUnauthorized, problemUnauthorized, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clusterPost 向 peer 发送签名请求，响应按 {code,msg,data} 解析，data 写入 out
+func clusterPost(peerURL, path string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, peerURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := randomHex(16)
+	mac := hmac.New(sha256.New, []byte(liveSecret("cluster.secret", GlobalConfig.Cluster.Secret)))
+	mac.Write([]byte(ts + "\n" + nonce + "\n"))
+	mac.Write(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Relay-Timestamp", ts)
+	req.Header.Set("X-Relay-Nonce", nonce)
+	req.Header.Set("X-Relay-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := clusterHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 出错时是 problem+json（旧版本节点是 {"code":-1,"msg":...}），成功时是 {code:0,msg,data}
+	var envelope struct {
+		Code   json.RawMessage `json:"code"`
+		Msg    string          `json:"msg"`
+		Detail string          `json:"detail"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("peer 响应解析失败（HTTP %d）: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode >= 400 || string(envelope.Code) != "0" {
+		msg := envelope.Detail
+		if msg == "" {
+			msg = envelope.Msg
+		}
+		return fmt.Errorf("peer 返回错误（HTTP %d）: %s", resp.StatusCode, msg)
+	}
+	if out != nil {
+		return json.Unmarshal(envelope.Data, out)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 序列化编解码器 =====
+//
+// 原生 WebSocket 连接可以在握手时用 Sec-WebSocket-Protocol 协商线上格式，协商结果决定该连接上下行帧的编码：
+//
+//	new WebSocket("wss://relay.example.com/ws?token=u1", ["msgpack", "json"])
+//
+// 内置 json（默认，不带子协议时也是它）、msgpack、cbor；客户端按自己的偏好顺序列出，服务端选第一个认识的。
+// 非 JSON 格式按二进制帧收发，内容与 JSON 帧一一对应（{event, channel, data, ...}），
+// 上行帧先转成 JSON 再走原来的校验和处理，下行消息按格式各编码一次、同格式的连接共享（见 outbound.go）。
+// HTTP 推送接口按请求的 Content-Type 选择编解码器（application/msgpack、application/cbor），响应仍是 JSON。
+//
+// 开启 signing 时只协商 JSON：签名覆盖的是 data 的 JSON 原文，msgpack / CBOR 客户端拿不到这份字节，无法验签；
+// 只列了非 JSON 格式的客户端不会被选中任何子协议（浏览器会因此握手失败）。
+//
+// 新增格式只需实现 Codec 并在 init 里 registerCodec，hub 和各处理函数不需要改动。
+
+// Codec 一种线上格式
+type Codec interface {
+	// Name 协商用的 WebSocket 子协议名
+	Name() string
+	// ContentType HTTP 请求体的 MIME 类型
+	ContentType() string
+	// MessageType 下行 WebSocket 帧类型（websocket.TextMessage / BinaryMessage）
+	MessageType() int
+	// Encode 编码任意可 JSON 序列化的值
+	Encode(v interface{}) ([]byte, error)
+	// Decode 解码到 v，语义同 json.Unmarshal
+	Decode(data []byte, v interface{}) error
+}
+
+const errCodeInvalidFrame = "invalid_frame"
+
+var (
+	codecs     = make(map[string]Codec) // 子协议名 -> 编解码器
+	codecsByCT = make(map[string]Codec) // Content-Type -> 编解码器
+)
+
+// registerCodec 登记一种格式，名字或 Content-Type 重复时后登记的覆盖前面的
+func registerCodec(c Codec) {
+	codecs[c.Name()] = c
+	codecsByCT[c.ContentType()] = c
+}
+
+func init() {
+	registerCodec(jsonCodec{})
+	registerCodec(msgpackCodec{})
+	registerCodec(cborCodec{})
+}
+
+// negotiateCodec 按客户端提供的子协议选择格式，并写入升级响应头；
+// 没有提供或都不认识时用 JSON（返回 nil，走原来的编码路径），header 为 nil 时按需创建；开启 signing 时跳过非 JSON 格式
+func negotiateCodec(r *http.Request, header http.Header) (Codec, http.Header) {
+	for _, p := range websocket.Subprotocols(r) {
+		c, ok := codecs[p]
+		if !ok {
+			continue
+		}
+		if _, isJSON := c.(jsonCodec); !isJSON && GlobalConfig.Signing.Enabled {
+			continue
+		}
+		if header == nil {
+			header = make(http.Header)
+		}
+		header.Set("Sec-WebSocket-Protocol", p)
+		if _, isJSON := c.(jsonCodec); isJSON {
+			return nil, header
+		}
+		return c, header
+	}
+	return nil, header
+}
+
+// codecForContentType HTTP 请求体的编解码器，未登记的类型按 JSON 处理
+func codecForContentType(ct string) Codec {
+	if ct == "" {
+		return jsonCodec{}
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return jsonCodec{}
+	}
+	if c, ok := codecsByCT[strings.ToLower(mt)]; ok {
+		return c
+	}
+	return jsonCodec{}
+}
+
+// transcodeToJSON 把非 JSON 格式的上行帧转成等价的 JSON，交给原生协议的解析流程
+func transcodeToJSON(c Codec, raw []byte, maxBytes int) ([]byte, *clientError) {
+	if len(raw) > maxBytes {
+		return nil, &clientError{Code: errCodeFrameTooLarge, Msg: fmt.Sprintf("frame exceeds %d bytes", maxBytes)}
+	}
+	var v interface{}
+	if err := c.Decode(raw, &v); err != nil {
+		return nil, &clientError{Code: errCodeInvalidFrame, Msg: "frame is not valid " + c.Name() + ": " + err.Error()}
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, &clientError{Code: errCodeInvalidFrame, Msg: "frame cannot be represented as JSON"}
+	}
+	return out, nil
+}
+
+// decodePushBody 按 Content-Type 解码 HTTP 推送请求体
+func decodePushBody(r *http.Request, v interface{}) error {
+	c := codecForContentType(r.Header.Get("Content-Type"))
+	if _, isJSON := c.(jsonCodec); isJSON {
+		return json.NewDecoder(r.Body).Decode(v)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return c.Decode(data, v)
+}
+
+// encodeCodecFrame 按格式编码一条下行消息
+func encodeCodecFrame(c Codec, v interface{}) encodedFrame {
+	data, err := c.Encode(v)
+	if err != nil {
+		log.Printf("⚠️ %s 编码失败: %v\n", c.Name(), err)
+	}
+	return encodedFrame{messageType: c.MessageType(), data: data, err: err}
+}
+
+// ===== json =====
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                            { return "json" }
+func (jsonCodec) ContentType() string                     { return "application/json" }
+func (jsonCodec) MessageType() int                        { return websocket.TextMessage }
+func (jsonCodec) Encode(v interface{}) ([]byte, error)    { return json.Marshal(v) }
+func (jsonCodec) Decode(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// ===== 二进制格式的公共部分 =====
+//
+// msgpack / cbor 编码时先把值转成 JSON 的通用结构（null / bool / json.Number / string / 数组 / 对象），
+// 保证字段名、omitempty 等与 JSON 帧完全一致；解码得到通用结构后再按 JSON 规则填进 v。
+
+// toGeneric 把任意值转成 JSON 通用结构，数字保留为 json.Number
+func toGeneric(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out interface{}
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// fromGeneric 把解码出的通用结构按 JSON 规则填进 v
+func fromGeneric(g interface{}, v interface{}) error {
+	if p, ok := v.(*interface{}); ok {
+		*p = g
+		return nil
+	}
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// codecMaxDepth 解码时允许的最大嵌套层数
+const codecMaxDepth = 64
+
+var (
+	errCodecTruncated = errors.New("unexpected end of data")
+	errCodecTooDeep   = fmt.Errorf("nesting exceeds %d levels", codecMaxDepth)
+)
+
+// codecFloat 浮点数转 json.Number；NaN / Inf 在 JSON 里没有对应的值
+func codecFloat(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, errors.New("NaN / Inf cannot be represented as JSON")
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// codecMapKey 非字符串的 map key 按 JSON 的习惯转成字符串
+func codecMapKey(k interface{}) string {
+	switch x := k.(type) {
+	case string:
+		return x
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(k)
+}
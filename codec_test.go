@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBinaryCodecsRejectMalformedInput(t *testing.T) {
+	sample := map[string]interface{}{
+		"event_name": "chat.message",
+		"subject":    map[string]interface{}{"text": "你好", "n": -300, "f": 1.5, "ok": true, "tags": []interface{}{"a", nil}},
+	}
+	// nested 生成 depth 层单元素数组
+	nested := func(open []byte, depth int) []byte {
+		return append(bytes.Repeat(open, depth), 0x01)
+	}
+
+	cases := []struct {
+		codec     Codec
+		malformed map[string][]byte
+		deep      []byte
+	}{
+		{msgpackCodec{}, map[string][]byte{
+			"str32 声明 4GB":    {0xdb, 0xff, 0xff, 0xff, 0xff, 'a'},
+			"bin32 声明 4GB":    {0xc6, 0xff, 0xff, 0xff, 0xff},
+			"array32 声明 4G 项": {0xdd, 0xff, 0xff, 0xff, 0xff, 0x01},
+			"map32 声明 4G 项":   {0xdf, 0xff, 0xff, 0xff, 0xff, 0x01, 0x01},
+			"未知类型":            {0xc1},
+			"ext 类型":          {0xd4, 0x01, 0x00},
+			"NaN":             {0xcb, 0x7f, 0xf8, 0, 0, 0, 0, 0, 0},
+			"多余字节":            {0x01, 0x02},
+			"空输入":             {},
+		}, nested([]byte{0x91}, codecMaxDepth+2)},
+		{cborCodec{}, map[string][]byte{
+			"text 声明 2^64-1":  {0x7b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'a'},
+			"bytes 声明 4GB":    {0x5a, 0xff, 0xff, 0xff, 0xff},
+			"array 声明 2^64-1": {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+			"map 声明 2^64-1":   {0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x01},
+			"不定长数组":           {0x9f, 0x01, 0xff},
+			"保留的附加信息":         {0x1c},
+			"未知简单值":           {0xf8, 0x10},
+			"Inf":             {0xf9, 0x7c, 0x00},
+			"多余字节":            {0x01, 0x02},
+			"空输入":             {},
+		}, nested([]byte{0x81}, codecMaxDepth+2)},
+	}
+	for _, tc := range cases {
+		name := tc.codec.Name()
+		valid, err := tc.codec.Encode(sample)
+		if err != nil {
+			t.Fatalf("%s: encode: %v", name, err)
+		}
+		var out map[string]interface{}
+		if err := tc.codec.Decode(valid, &out); err != nil || out["event_name"] != "chat.message" {
+			t.Fatalf("%s: 往返失败 %v %v", name, out, err)
+		}
+
+		// 合法编码的任意前缀都必须报错，不能 panic
+		for i := 0; i < len(valid); i++ {
+			var v interface{}
+			if err := tc.codec.Decode(valid[:i], &v); err == nil {
+				t.Fatalf("%s: 前 %d 字节被当成完整的值 %v", name, i, v)
+			}
+		}
+		for label, data := range tc.malformed {
+			var v interface{}
+			if err := tc.codec.Decode(data, &v); err == nil {
+				t.Errorf("%s %s: 解码成功 %v", name, label, v)
+			}
+		}
+		var v interface{}
+		if err := tc.codec.Decode(tc.deep, &v); !errors.Is(err, errCodecTooDeep) {
+			t.Errorf("%s 超过 %d 层嵌套: err = %v", name, codecMaxDepth, err)
+		}
+	}
+
+	// cbor 标签不增加数据但算一层，不能用来绕过深度限制
+	var v interface{}
+	if err := (cborCodec{}).Decode(append(bytes.Repeat([]byte{0xc0}, codecMaxDepth+2), 0x01), &v); !errors.Is(err, errCodecTooDeep) {
+		t.Errorf("cbor 嵌套标签: err = %v", err)
+	}
+}
+
+func TestTranscodeToJSONLimitsFrameSize(t *testing.T) {
+	frame, _ := msgpackCodec{}.Encode(map[string]string{"event": "x"})
+	if _, cerr := transcodeToJSON(msgpackCodec{}, frame, len(frame)-1); cerr == nil || cerr.Code != errCodeFrameTooLarge {
+		t.Fatalf("超长帧 = %+v", cerr)
+	}
+	if _, cerr := transcodeToJSON(msgpackCodec{}, []byte{0xc1}, 64); cerr == nil || cerr.Code != errCodeInvalidFrame {
+		t.Fatalf("非法帧 = %+v", cerr)
+	}
+	out, cerr := transcodeToJSON(msgpackCodec{}, frame, 64)
+	if cerr != nil || string(out) != `{"event":"x"}` {
+		t.Fatalf("转码结果 %s %+v", out, cerr)
+	}
+}
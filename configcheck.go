@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ===== 启动时的配置校验 =====
+//
+// 默认情况下配置有问题时中继尽量启动：解析失败用默认配置、关键字段为空回退默认值、无法识别的字段忽略，
+// 只打一行 ⚠️ 日志，线上很容易带着错误配置跑很久。
+// 开启严格模式（配置 "strict_config": true，或环境变量 RELAY_STRICT_CONFIG=1）后，
+// 加载过程中发现的所有问题汇总成一份报告打印出来并拒绝启动。
+// 配置文件本身解析失败时读不到 strict_config，只能靠环境变量开启。
+//
+// 除了加载时的回退，这里还检查：
+//   - 各入口路径必须以 / 开头，且互不重复 / 互不覆盖（以 / 结尾的路径会吞掉它下面的所有路径）
+//   - port 必须是 1～65535 的数字
+//   - api_key 不能是内置的默认值（见 apikey.go）
+
+const strictConfigEnv = "RELAY_STRICT_CONFIG"
+
+// configProblems 本次加载中发现的配置问题
+var configProblems []string
+
+// noteConfigProblem 记录一个配置问题（调用方已经打过回退日志的，这里只记录）
+func noteConfigProblem(problem string) {
+	configProblems = append(configProblems, problem)
+}
+
+// strictConfig 是否开启严格模式
+func strictConfig() bool {
+	if v := os.Getenv(strictConfigEnv); v != "" {
+		on, _ := strconv.ParseBool(v)
+		return on
+	}
+	return GlobalConfig.StrictConfig
+}
+
+// validateConfig 补齐默认值之后做结构性检查，问题打日志并记录
+func validateConfig() {
+	var found []string
+
+	if n, err := strconv.Atoi(GlobalConfig.Port); err != nil || n < 1 || n > 65535 {
+		found = append(found, "port 必须是 1～65535 的数字，当前为 "+strconv.Quote(GlobalConfig.Port))
+	}
+	found = append(found, checkDefaultAPIKey()...)
+	found = append(found, checkEndpointPaths(configEndpoints())...)
+
+	for _, p := range found {
+		log.Printf("⚠️ 配置问题：%s\n", p)
+		noteConfigProblem(p)
+	}
+}
+
+// configEndpoint 一个 HTTP 入口路径
+type configEndpoint struct {
+	name string
+	path string
+}
+
+// configEndpoints 当前启用的各入口路径
+func configEndpoints() []configEndpoint {
+	cfg := GlobalConfig
+	eps := []configEndpoint{{"ws_path", cfg.WSPath}, {"push_path", cfg.PushPath}}
+	if cfg.SSE.Enabled {
+		eps = append(eps, configEndpoint{"sse.path", cfg.SSE.Path})
+	}
+	if cfg.SignalR.Enabled {
+		eps = append(eps, configEndpoint{"signalr.path", cfg.SignalR.Path})
+	}
+	if cfg.Phoenix.Enabled {
+		eps = append(eps, configEndpoint{"phoenix.path", cfg.Phoenix.Path})
+	}
+	if cfg.Centrifugo.Enabled {
+		eps = append(eps, configEndpoint{"centrifugo.path", cfg.Centrifugo.Path})
+	}
+	if cfg.Metrics.Enabled && cfg.Metrics.Path != "" {
+		eps = append(eps, configEndpoint{"metrics.path", cfg.Metrics.Path})
+	}
+	for _, ns := range cfg.Namespaces {
+		eps = append(eps, configEndpoint{"namespaces." + ns.Name + ".path", ns.Path})
+	}
+	return eps
+}
+
+// checkEndpointPaths 路径必须以 / 开头，且两两之间不能相同或互相覆盖
+func checkEndpointPaths(eps []configEndpoint) []string {
+	var found []string
+	for _, ep := range eps {
+		if !strings.HasPrefix(ep.path, "/") {
+			found = append(found, ep.name+" 必须以 / 开头，当前为 "+strconv.Quote(ep.path))
+		}
+	}
+	for i, a := range eps {
+		for _, b := range eps[i+1:] {
+			switch {
+			case a.path == b.path:
+				found = append(found, a.name+" 和 "+b.name+" 使用了同一个路径 "+a.path)
+			case strings.HasSuffix(a.path, "/") && strings.HasPrefix(b.path, a.path):
+				found = append(found, a.name+"（"+a.path+"）覆盖了 "+b.name+"（"+b.path+"）")
+			case strings.HasSuffix(b.path, "/") && strings.HasPrefix(a.path, b.path):
+				found = append(found, b.name+"（"+b.path+"）覆盖了 "+a.name+"（"+a.path+"）")
+			}
+		}
+	}
+	return found
+}
+
+// enforceStrictConfig 严格模式下有任何配置问题都打印报告并退出
+func enforceStrictConfig() {
+	if !strictConfig() || len(configProblems) == 0 {
+		return
+	}
+	problems := append([]string(nil), configProblems...)
+	sort.Strings(problems)
+	var b strings.Builder
+	b.WriteString("❌ 严格模式下配置校验未通过，拒绝启动（共 " + strconv.Itoa(len(problems)) + " 项）：\n")
+	for _, p := range problems {
+		b.WriteString("   - " + p + "\n")
+	}
+	log.Fatal(b.String())
+}
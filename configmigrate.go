@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ===== 配置版本与自动迁移 =====
+//
+// config.json 带 config_version 字段；旧版本文件在加载时按顺序执行迁移（改名、补齐配置段等），
+// 迁移只在内存中进行，不改动文件（配置文件常常受版本管理或只读挂载）。
+// 需要持久化时运行 relay migrate-config：迁移后的内容写回原文件，原文件备份为 config.json.bak-v{旧版本}。
+// 没有 config_version 的文件视为版本 1（引入版本号之前的格式）。
+//
+// 新增迁移：CurrentConfigVersion 加一，并在 configMigrations 末尾追加一项。
+
+const CurrentConfigVersion = 2
+
+// configMigration 把 from 版本的原始配置升级到 from+1
+type configMigration struct {
+	from  int
+	desc  string
+	apply func(raw map[string]interface{})
+}
+
+var configMigrations = []configMigration{
+	{
+		from: 1,
+		desc: "补齐可选配置段",
+		apply: func(raw map[string]interface{}) {
+			addMissingConfigSections(raw, getDefaultConfig())
+		},
+	},
+}
+
+// migrateConfig 解析原始配置并迁移到当前版本，返回迁移后的 JSON 和是否发生了迁移
+func migrateConfig(data []byte) ([]byte, int, bool, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, 0, false, err
+	}
+
+	version := 1
+	if v, ok := raw["config_version"].(float64); ok && v >= 1 {
+		version = int(v)
+	}
+	if version > CurrentConfigVersion {
+		log.Printf("⚠️ 配置版本 %d 高于当前程序支持的版本 %d，可能存在无法识别的字段\n", version, CurrentConfigVersion)
+		return data, version, false, nil
+	}
+	if version == CurrentConfigVersion {
+		return data, version, false, nil
+	}
+
+	from := version
+	for _, m := range configMigrations {
+		if m.from < version {
+			continue
+		}
+		if m.from != version {
+			return nil, from, false, fmt.Errorf("缺少从版本 %d 开始的配置迁移", version)
+		}
+		m.apply(raw)
+		version++
+		log.Printf("🔧 配置迁移 v%d → v%d：%s\n", m.from, version, m.desc)
+	}
+	raw["config_version"] = version
+
+	out, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return nil, from, false, err
+	}
+	return out, from, true, nil
+}
+
+// loadConfigFromSource 从外部来源（RELAY_CONFIG_SOURCE）加载整份配置，旧版本只在内存中迁移，不写回
+func loadConfigFromSource(src string, defaultCfg Config) {
+	data, isRef, err := resolveSecret(src)
+	if !isRef {
+		log.Fatalf("❌ RELAY_CONFIG_SOURCE 不是受支持的引用: %s\n", src)
+	}
+	if err != nil {
+		log.Fatalf("❌ 从 %s 拉取配置失败 %v\n", src, err)
+	}
+
+	raw := []byte(data)
+	if migrated, fromVersion, changed, mErr := migrateConfig(raw); mErr != nil {
+		log.Printf("⚠️ 配置迁移失败，按原内容加载！错误: %v\n", mErr)
+	} else if changed {
+		log.Printf("⚠️ 外部配置为 v%d，已在内存中迁移到 v%d，请更新配置来源\n", fromVersion, CurrentConfigVersion)
+		raw = migrated
+	}
+
+	GlobalConfig = defaultCfg
+	if err := json.Unmarshal(raw, &GlobalConfig); err != nil {
+		log.Fatalf("❌ 外部配置解析失败 %v\n", err)
+	}
+	log.Printf("✅ 已从 %s 加载配置！\n", strings.SplitN(src, "#", 2)[0])
+	warnUnknownConfigKeys(raw, GlobalConfig)
+}
+
+// runMigrateConfig 子命令 relay migrate-config：把配置文件迁移到当前版本并写回，旧文件备份为 .bak-v{旧版本}
+func runMigrateConfig(args []string) int {
+	fs := flag.NewFlagSet("migrate-config", flag.ContinueOnError)
+	path := fs.String("config", filepath.Join(getCurrentDir(), ConfigFileName), "配置文件路径")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	data, err := os.ReadFile(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ 读取配置失败:", err)
+		return 1
+	}
+	migrated, fromVersion, changed, err := migrateConfig(data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ 配置迁移失败:", err)
+		return 1
+	}
+	if !changed {
+		fmt.Fprintf(os.Stderr, "✅ %s 已是 v%d，无需迁移\n", *path, fromVersion)
+		return 0
+	}
+	if err := rewriteMigratedConfig(*path, data, migrated, fromVersion); err != nil {
+		fmt.Fprintln(os.Stderr, "❌", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "🎉 配置已迁移到 v%d 并写回 %s（旧文件备份为 %s.bak-v%d）\n", CurrentConfigVersion, *path, *path, fromVersion)
+	return 0
+}
+
+// rewriteMigratedConfig 备份旧文件并写回迁移后的配置
+func rewriteMigratedConfig(path string, old, migrated []byte, fromVersion int) error {
+	backup := fmt.Sprintf("%s.bak-v%d", path, fromVersion)
+	if err := os.WriteFile(backup, old, 0644); err != nil {
+		return fmt.Errorf("无法备份旧配置 %s，跳过写回: %w", backup, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, migrated, 0644); err != nil {
+		return fmt.Errorf("无法写回迁移后的配置: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("无法写回迁移后的配置: %w", err)
+	}
+	return nil
+}
+
+// addMissingConfigSections 把默认配置中缺失的配置段补到原始配置里，已有的值不动
+func addMissingConfigSections(raw map[string]interface{}, def Config) {
+	defMap := configToMap(def)
+	for key, val := range defMap {
+		if _, ok := raw[key]; ok || val == nil {
+			continue
+		}
+		if _, isSection := val.(map[string]interface{}); isSection {
+			raw[key] = val
+		}
+	}
+}
+
+// warnUnknownConfigKeys 提示配置中无法识别的字段（两层以内），避免改名后的旧字段被静默忽略
+func warnUnknownConfigKeys(data []byte, cfg Config) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return
+	}
+	known := configToMap(cfg)
+
+	var unknown []string
+	for key, val := range raw {
+		knownVal, ok := known[key]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		section, isSection := val.(map[string]interface{})
+		knownSection, knownIsSection := knownVal.(map[string]interface{})
+		if !isSection || !knownIsSection {
+			continue
+		}
+		for sub := range section {
+			if _, ok := knownSection[sub]; !ok {
+				unknown = append(unknown, key+"."+sub)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		log.Printf("⚠️ 配置中存在无法识别的字段 %q，已忽略\n", key)
+		noteConfigProblem(fmt.Sprintf("无法识别的字段 %q", key))
+	}
+}
+
+func configToMap(cfg Config) map[string]interface{} {
+	data, _ := json.Marshal(cfg)
+	var m map[string]interface{}
+	_ = json.Unmarshal(data, &m)
+	return m
+}
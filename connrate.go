@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 重连风暴保护 =====
+//
+// 网络抖动或发布之后，大量客户端会在同一时刻重连。开启 connect_rate 后所有长连接入口在升级前先过两道令牌桶：
+//   - 每个来源 IP 一个桶（per_ip_per_second / per_ip_burst），超出直接拒绝；
+//   - 全局一个桶（global_per_second / global_burst），超出时最多排队等待 max_wait_ms，仍等不到才拒绝。
+//
+// 拒绝时返回 429 rate_limited，Retry-After 在需要等待的时间上加 0～retry_jitter_seconds 秒的随机抖动，
+// 让客户端的下一轮重连自然错开，而不是再次同时涌来：
+//
+//	{"code":"rate_limited","reason":"connect_rate","retry_after_seconds":7,...}
+
+// ConnectRateConfig 连接升级限速配置
+type ConnectRateConfig struct {
+	Enabled            bool    `json:"enabled"`
+	PerIPPerSecond     float64 `json:"per_ip_per_second"`    // 每个 IP 每秒允许的新连接数，默认 5
+	PerIPBurst         int     `json:"per_ip_burst"`         // 每个 IP 的突发额度，默认 20
+	GlobalPerSecond    float64 `json:"global_per_second"`    // 全局每秒新连接数，默认 500
+	GlobalBurst        int     `json:"global_burst"`         // 全局突发额度，默认 1000
+	MaxWaitMs          int     `json:"max_wait_ms"`          // 全局桶用完时最多排队等待的毫秒数，默认 0（不排队）
+	RetryJitterSeconds int     `json:"retry_jitter_seconds"` // Retry-After 上叠加的随机抖动上限，默认 5
+}
+
+const (
+	connectRateDefaultPerIP       = 5
+	connectRateDefaultPerIPBurst  = 20
+	connectRateDefaultGlobal      = 500
+	connectRateDefaultGlobalBurst = 1000
+	connectRateDefaultJitter      = 5
+	connectRateSweepInterval      = time.Minute
+
+	connectRateReasonIP     = "ip_connect_rate"
+	connectRateReasonGlobal = "connect_rate"
+)
+
+// tokenBucket 令牌桶，tokens 可以为负，表示已被排队的请求预占
+type tokenBucket struct {
+	rate    float64
+	burst   float64
+	tokens  float64
+	updated time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), updated: now}
+}
+
+// take 取一个令牌：能在 maxWait 内等到时预占并返回需要等待的时间；等不到时不扣令牌，返回还要等多久
+func (b *tokenBucket) take(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if wait > maxWait {
+		return wait, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// full 桶已回满（该来源一段时间没有新连接），可以回收
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.updated).Seconds()*b.rate >= b.burst
+}
+
+var (
+	connectRateMu     sync.Mutex
+	connectRateGlobal *tokenBucket
+	connectRateByIP   = make(map[string]*tokenBucket)
+
+	connectRateLimitedIP     atomic.Uint64
+	connectRateLimitedGlobal atomic.Uint64
+	connectRateQueued        atomic.Uint64
+)
+
+func prepareConnectRate(cfg *ConnectRateConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.PerIPPerSecond <= 0 {
+		cfg.PerIPPerSecond = connectRateDefaultPerIP
+	}
+	if cfg.PerIPBurst <= 0 {
+		cfg.PerIPBurst = connectRateDefaultPerIPBurst
+	}
+	if cfg.GlobalPerSecond <= 0 {
+		cfg.GlobalPerSecond = connectRateDefaultGlobal
+	}
+	if cfg.GlobalBurst <= 0 {
+		cfg.GlobalBurst = connectRateDefaultGlobalBurst
+	}
+	if cfg.RetryJitterSeconds <= 0 {
+		cfg.RetryJitterSeconds = connectRateDefaultJitter
+	}
+	connectRateGlobal = newTokenBucket(cfg.GlobalPerSecond, cfg.GlobalBurst, time.Now())
+	log.Printf("✅ 连接升级限速：每 IP %.1f/s（突发 %d），全局 %.1f/s（突发 %d）\n",
+		cfg.PerIPPerSecond, cfg.PerIPBurst, cfg.GlobalPerSecond, cfg.GlobalBurst)
+}
+
+// admitConnectRate upgradeGuard 调用：先按来源 IP、再按全局限速，需要排队时在这里等待；拒绝时已写好响应
+func admitConnectRate(w http.ResponseWriter, r *http.Request) bool {
+	cfg := GlobalConfig.ConnectRate
+	ip := hostOf(r.RemoteAddr)
+	now := time.Now()
+
+	connectRateMu.Lock()
+	b, ok := connectRateByIP[ip]
+	if !ok {
+		b = newTokenBucket(cfg.PerIPPerSecond, cfg.PerIPBurst, now)
+		connectRateByIP[ip] = b
+	}
+	if wait, ok := b.take(now, 0); !ok {
+		connectRateMu.Unlock()
+		connectRateLimitedIP.Add(1)
+		writeConnectRateLimited(w, r, connectRateReasonIP, wait)
+		return false
+	}
+	wait, ok := connectRateGlobal.take(now, time.Duration(cfg.MaxWaitMs)*time.Millisecond)
+	if !ok {
+		// 全局拒绝时把该 IP 的令牌还回去，避免正常客户端在风暴中被连带计入自己的额度
+		b.tokens++
+	}
+	connectRateMu.Unlock()
+
+	if !ok {
+		connectRateLimitedGlobal.Add(1)
+		writeConnectRateLimited(w, r, connectRateReasonGlobal, wait)
+		return false
+	}
+	if wait > 0 {
+		connectRateQueued.Add(1)
+		if !sleepCtx(r.Context(), wait) {
+			return false
+		}
+	}
+	return true
+}
+
+// sleepCtx 等待 d，请求被取消时提前返回 false
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeConnectRateLimited 429 + 带随机抖动的 Retry-After；风暴期间拒绝量很大，不逐条打日志，看指标
+func writeConnectRateLimited(w http.ResponseWriter, r *http.Request, reason string, wait time.Duration) {
+	retry := int(math.Ceil(wait.Seconds())) + rand.IntN(GlobalConfig.ConnectRate.RetryJitterSeconds+1)
+	if retry < 1 {
+		retry = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	writeProblemWith(w, r, http.StatusTooManyRequests, problemRateLimited, "too many connection attempts", map[string]interface{}{
+		"reason":              reason,
+		"retry_after_seconds": retry,
+	})
+}
+
+// connectRateSweepLoop 定期回收已回满的 IP 桶
+func connectRateSweepLoop() {
+	ticker := time.NewTicker(connectRateSweepInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		connectRateMu.Lock()
+		for ip, b := range connectRateByIP {
+			if b.full(now) {
+				delete(connectRateByIP, ip)
+			}
+		}
+		connectRateMu.Unlock()
+	}
+}
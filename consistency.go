@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 注册表一致性巡检 =====
+//
+// Hub 同时维护全部连接、用户组、频道三张表，任何一处漏删都会造成泄漏（已断开的连接留在用户组里，
+// 推送时反复写失败）或丢消息（连接在线却不在自己的用户组里）。巡检定期对三张表做交叉核对并就地修复：
+//   - orphan_user_member：用户组里的连接已不在全部连接中 → 移出用户组
+//   - user_mismatch：连接所在的用户组与它的 user_id 不一致 → 移出该组
+//   - missing_user_member：连接有 user_id 却不在对应用户组里 → 补回
+//   - orphan_channel_member：频道里的连接已不在全部连接中 → 移出频道
+//   - channel_mismatch：频道里的连接自己没有记这个频道，或反过来 → 以连接记录为准补齐 / 移出
+//
+// 巡检按 all → users → channels 的顺序持有三把写锁（与 state 一致），每次只需遍历一遍。
+// 正常情况下修复数应当一直是 0，出现非 0 说明有记账 bug，会打日志并计入指标。
+
+const (
+	consistencyDefaultInterval = 300
+
+	inconsistencyOrphanUser    = "orphan_user_member"
+	inconsistencyUserMismatch  = "user_mismatch"
+	inconsistencyMissingUser   = "missing_user_member"
+	inconsistencyOrphanChannel = "orphan_channel_member"
+	inconsistencyChannel       = "channel_mismatch"
+)
+
+// inconsistencyKinds 指标里固定输出的修复类型
+var inconsistencyKinds = []string{
+	inconsistencyOrphanUser, inconsistencyUserMismatch, inconsistencyMissingUser,
+	inconsistencyOrphanChannel, inconsistencyChannel,
+}
+
+var (
+	consistencyMu      sync.Mutex
+	consistencyRepairs = make(map[string]uint64) // 修复类型 -> 累计次数
+	consistencySweeps  atomic.Uint64
+	consistencyLastRun atomic.Int64 // 最近一次巡检的 Unix 秒
+)
+
+func prepareConsistencySweep(seconds *int) {
+	if *seconds == 0 {
+		*seconds = consistencyDefaultInterval
+	}
+}
+
+// auditRegistry 核对并修复三张表，返回各类修复的次数
+func (h *Hub) auditRegistry() map[string]int {
+	h.allMu.Lock()
+	defer h.allMu.Unlock()
+	h.usersMu.Lock()
+	defer h.usersMu.Unlock()
+	h.channelsMu.Lock()
+	defer h.channelsMu.Unlock()
+
+	found := make(map[string]int)
+
+	for userID, set := range h.users {
+		for c := range set {
+			switch {
+			case !h.hasClientLocked(c):
+				found[inconsistencyOrphanUser]++
+			case c.userID != userID:
+				found[inconsistencyUserMismatch]++
+			default:
+				continue
+			}
+			delete(set, c)
+		}
+		if len(set) == 0 {
+			delete(h.users, userID)
+		}
+	}
+
+	for channel, set := range h.channels {
+		for c := range set {
+			if !h.hasClientLocked(c) {
+				found[inconsistencyOrphanChannel]++
+				delete(set, c)
+				continue
+			}
+			if _, ok := c.channels[channel]; !ok {
+				found[inconsistencyChannel]++
+				delete(set, c)
+			}
+		}
+		if len(set) == 0 {
+			delete(h.channels, channel)
+			h.channelVacatedLocked(channel)
+		}
+	}
+
+	for c := range h.all {
+		if c.userID != "" {
+			set, ok := h.users[c.userID]
+			if !ok {
+				set = make(map[*Client]struct{})
+				h.users[c.userID] = set
+			}
+			if _, in := set[c]; !in {
+				found[inconsistencyMissingUser]++
+				set[c] = struct{}{}
+			}
+		}
+		for channel := range c.channels {
+			set, ok := h.channels[channel]
+			if !ok {
+				set = make(map[*Client]struct{})
+				h.channels[channel] = set
+				h.channelOccupiedLocked(channel)
+			}
+			if _, in := set[c]; !in {
+				found[inconsistencyChannel]++
+				set[c] = struct{}{}
+			}
+		}
+	}
+	return found
+}
+
+// hasClientLocked 连接是否在全部连接中；调用方持有 allMu
+func (h *Hub) hasClientLocked(c *Client) bool {
+	_, ok := h.all[c]
+	return ok
+}
+
+// consistencySweepLoop 定期巡检默认 Hub
+func consistencySweepLoop() {
+	ticker := time.NewTicker(time.Duration(GlobalConfig.ConsistencySweepSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		runConsistencySweep(defaultHub)
+	}
+}
+
+func runConsistencySweep(h *Hub) {
+	found := h.auditRegistry()
+	consistencySweeps.Add(1)
+	consistencyLastRun.Store(time.Now().Unix())
+	if len(found) == 0 {
+		return
+	}
+
+	consistencyMu.Lock()
+	parts := make([]string, 0, len(found))
+	for kind, n := range found {
+		consistencyRepairs[kind] += uint64(n)
+		parts = append(parts, kind+"="+strconv.Itoa(n))
+	}
+	consistencyMu.Unlock()
+	sort.Strings(parts)
+	log.Printf("🩺 注册表一致性巡检发现并修复了不一致：%s\n", strings.Join(parts, " "))
+}
+
+// consistencyCounts 各类修复的累计次数，用于指标
+func consistencyCounts() map[string]uint64 {
+	consistencyMu.Lock()
+	defer consistencyMu.Unlock()
+	out := make(map[string]uint64, len(consistencyRepairs))
+	for k, v := range consistencyRepairs {
+		out[k] = v
+	}
+	return out
+}
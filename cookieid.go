@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ===== 访客身份 cookie =====
+//
+// 开启后，升级请求没带合法 cookie 的浏览器会在升级响应里拿到一个签名的 HttpOnly cookie，
+// 之后重连浏览器会自动带上，匿名访客因此有了跨重连稳定的身份：
+// 未 identify 的连接归入 visitor:{id} 用户组，推送时 token 填 "visitor:{id}" 即可定向。
+
+// IdentityCookieConfig 访客身份 cookie 配置
+type IdentityCookieConfig struct {
+	Enabled    bool   `json:"enabled"`
+	Name       string `json:"name"`         // cookie 名，默认 relay_vid
+	Secret     string `json:"secret"`       // 签名密钥，留空则每次启动随机生成（重启后旧 cookie 失效）
+	MaxAgeDays int    `json:"max_age_days"` // 默认 365
+	Secure     bool   `json:"secure"`       // 只在 HTTPS 下发送，线上建议开启
+}
+
+const (
+	identityCookieDefaultName = "relay_vid"
+	identityCookieDefaultDays = 365
+	visitorUserPrefix         = "visitor:"
+)
+
+// prepareIdentityCookie 补齐默认值
+func prepareIdentityCookie(cfg *IdentityCookieConfig) {
+	if cfg.Name == "" {
+		cfg.Name = identityCookieDefaultName
+	}
+	if cfg.MaxAgeDays <= 0 {
+		cfg.MaxAgeDays = identityCookieDefaultDays
+	}
+	if cfg.Secret == "" {
+		cfg.Secret = randomHex(32)
+		log.Println("⚠️ identity_cookie 未配置 secret，已随机生成，重启后访客身份会重置")
+	}
+}
+
+func visitorUserID(visitorID string) string {
+	return visitorUserPrefix + visitorID
+}
+
+func signVisitorID(id string) string {
+	mac := hmac.New(sha256.New, []byte(liveSecret("identity_cookie.secret", GlobalConfig.IdentityCookie.Secret)))
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyVisitorCookie 校验 cookie 值，合法时返回其中的访客 ID
+func verifyVisitorCookie(value string) (string, bool) {
+	id, _, ok := strings.Cut(value, ".")
+	if !ok || id == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(value), []byte(signVisitorID(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+// visitorIdentity 解析请求中的访客 cookie；没有或不合法时生成新 ID，并返回需要写入响应的 Set-Cookie 头。
+// 未开启时返回空 ID 和 nil
+func visitorIdentity(r *http.Request) (string, http.Header) {
+	cfg := GlobalConfig.IdentityCookie
+	if !cfg.Enabled {
+		return "", nil
+	}
+
+	if c, err := r.Cookie(cfg.Name); err == nil {
+		if id, ok := verifyVisitorCookie(c.Value); ok {
+			return id, nil
+		}
+		log.Println("⚠️ 访客 cookie 签名不合法，重新分配身份")
+	}
+
+	id := randomHex(12)
+	cookie := &http.Cookie{
+		Name:     cfg.Name,
+		Value:    signVisitorID(id),
+		Path:     "/",
+		MaxAge:   cfg.MaxAgeDays * 24 * 3600,
+		HttpOnly: true,
+		Secure:   cfg.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	header := http.Header{}
+	header.Add("Set-Cookie", cookie.String())
+	log.Println("🍪 新访客分配身份:", id)
+	return id, header
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifyVisitorCookie(t *testing.T) {
+	useConfig(t, func(cfg *Config) {
+		cfg.IdentityCookie = IdentityCookieConfig{Enabled: true, Secret: "cookie-secret"}
+		prepareIdentityCookie(&cfg.IdentityCookie)
+	})
+	valid := signVisitorID("abc123")
+	_, mac, _ := strings.Cut(valid, ".")
+
+	cases := []struct {
+		name   string
+		value  string
+		wantID string
+	}{
+		{"合法", valid, "abc123"},
+		{"空值", "", ""},
+		{"没有签名", "abc123", ""},
+		{"只有点", ".", ""},
+		{"ID 为空", "." + mac, ""},
+		{"签名为空", "abc123.", ""},
+		{"换了 ID", "abc124." + mac, ""},
+		{"签名被截断", valid[:len(valid)-1], ""},
+		{"多出一段", valid + ".x", ""},
+		{"签名不是 base64", "abc123.!!!", ""},
+		{"超长", strings.Repeat("a", 8192) + "." + mac, ""},
+	}
+	for _, tc := range cases {
+		id, ok := verifyVisitorCookie(tc.value)
+		if id != tc.wantID || ok != (tc.wantID != "") {
+			t.Errorf("%s: id=%q ok=%v", tc.name, id, ok)
+		}
+	}
+
+	// 换密钥后旧 cookie 失效
+	GlobalConfig.IdentityCookie.Secret = "rotated"
+	if _, ok := verifyVisitorCookie(valid); ok {
+		t.Fatal("密钥轮换后旧 cookie 仍然有效")
+	}
+}
+
+func TestVisitorIdentityReissuesBadCookie(t *testing.T) {
+	useConfig(t, func(cfg *Config) {
+		cfg.IdentityCookie = IdentityCookieConfig{Enabled: true, Secret: "cookie-secret"}
+		prepareIdentityCookie(&cfg.IdentityCookie)
+	})
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.AddCookie(&http.Cookie{Name: identityCookieDefaultName, Value: "forged.sig"})
+	id, header := visitorIdentity(r)
+	if id == "" || id == "forged" || header == nil {
+		t.Fatalf("伪造的 cookie: id=%q header=%v", id, header)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.AddCookie(&http.Cookie{Name: identityCookieDefaultName, Value: signVisitorID("known")})
+	if id, header := visitorIdentity(r); id != "known" || header != nil {
+		t.Fatalf("合法 cookie: id=%q header=%v", id, header)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== 周期推送（cron） =====
+//
+// 推送请求带 cron（标准 5 段：分 时 日 月 周）时不立即发送，而是登记为周期任务，每次到点按原请求重新构造一条推送发出：
+//
+//	{"event_name":"leaderboard.refresh","cron":"*/5 * * * *"}
+//	{"event_name":"daily.digest","token":"u1","cron":"0 9 * * 1-5","timezone":"Asia/Shanghai"}
+//
+// 每段支持 *、数字、a-b 范围、/n 步长和逗号列表，周日可以写 0 或 7；另支持 @hourly / @daily / @weekly / @monthly / @yearly。
+// 日和周同时限定时按标准 cron 的语义取并集。timezone 为 IANA 时区名，不填按 UTC。
+// 周期任务通过 /v1/recurring-jobs（push 认证）或 /api/recurring-jobs（admin 认证）列出、删除。
+// 配置 jobs.recurring_store_file 后落盘，重启后按当前时间重新计算下一次发送时间，停机期间错过的不补发。
+
+const (
+	recurringMaxJobs  = 1000
+	recurringIDPrefix = "cron_"
+	cronSearchHorizon = 5 * 366 * 24 * time.Hour // 找不到下一次发送时间时放弃（如 2 月 30 日）
+)
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// cronSchedule 解析后的 cron 表达式，每段是一个位图
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+	loc                           *time.Location
+}
+
+// parseCron 解析 5 段 cron 表达式
+func parseCron(spec string, loc *time.Location) (*cronSchedule, error) {
+	if m, ok := cronMacros[strings.TrimSpace(spec)]; ok {
+		spec = m
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New("cron must have 5 fields: minute hour day-of-month month day-of-week")
+	}
+	s := &cronSchedule{loc: loc}
+	var err error
+	if s.minute, _, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("cron minute: %w", err)
+	}
+	if s.hour, _, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("cron hour: %w", err)
+	}
+	if s.dom, s.domStar, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("cron day-of-month: %w", err)
+	}
+	if s.month, _, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("cron month: %w", err)
+	}
+	if s.dow, s.dowStar, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("cron day-of-week: %w", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 也是周日
+	}
+	return s, nil
+}
+
+// parseCronField 解析一段，返回位图和是否为 *
+func parseCronField(field string, lo, hi int) (uint64, bool, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, false, fmt.Errorf("invalid step %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		from, to := lo, hi
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			x, err1 := strconv.Atoi(a)
+			y, err2 := strconv.Atoi(b)
+			if err1 != nil || err2 != nil || x > y {
+				return 0, false, fmt.Errorf("invalid range %q", part)
+			}
+			from, to = x, y
+		default:
+			x, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, false, fmt.Errorf("invalid value %q", part)
+			}
+			from = x
+			if step == 1 {
+				to = x // 单个值；a/n 表示从 a 开始到最大值每 n 个
+			}
+		}
+		if from < lo || to > hi {
+			return 0, false, fmt.Errorf("%q out of range %d-%d", part, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+			if v > hi-step {
+				break // 步长很大时 v+step 会溢出成负数，又回到范围内
+			}
+		}
+	}
+	return bits, field == "*", nil
+}
+
+// dayMatches 日和周都限定时取并集，只限定一个时只看那一个
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	}
+	return dom || dow
+}
+
+// next 严格晚于 after 的下一次发送时间，找不到时返回零值
+func (s *cronSchedule) next(after time.Time) time.Time {
+	t := after.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchHorizon)
+	for t.Before(limit) {
+		y, mo, d := t.Date()
+		switch {
+		case s.month&(1<<uint(mo)) == 0:
+			t = cronAdvance(t, time.Date(y, mo+1, 1, 0, 0, 0, 0, s.loc))
+		case !s.dayMatches(t):
+			t = cronAdvance(t, time.Date(y, mo, d+1, 0, 0, 0, 0, s.loc))
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			// 按绝对时间加到下一个整点，夏令时切换当天不会因为 time.Date 归一化而原地打转
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// cronAdvance 跳到 next；当地时间的零点恰好不存在（夏令时）时 time.Date 可能往回归一化，这时退回按分钟前进
+func cronAdvance(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Minute)
+}
+
+// pushCron 解析推送请求里的 cron，出错时同时返回出错的字段
+func pushCron(body PushRequest, now time.Time) (*cronSchedule, string, error) {
+	switch {
+	case body.DelaySeconds > 0 || body.DeliverAt != "":
+		return nil, "cron", errors.New("cron cannot be combined with delay_seconds / deliver_at")
+	case body.Ephemeral:
+		return nil, "ephemeral", errors.New("ephemeral pushes cannot be recurring")
+	}
+	loc := time.UTC
+	if body.Timezone != "" {
+		l, err := time.LoadLocation(body.Timezone)
+		if err != nil || body.Timezone == "Local" {
+			return nil, "timezone", errors.New("timezone must be an IANA name, e.g. Asia/Shanghai")
+		}
+		loc = l
+	}
+	s, err := parseCron(body.Cron, loc)
+	if err != nil {
+		return nil, "cron", err
+	}
+	if s.next(now).IsZero() {
+		return nil, "cron", errors.New("cron never fires")
+	}
+	return s, "", nil
+}
+
+// recurringJob 一个周期推送任务；对外返回的都是拷贝
+type recurringJob struct {
+	ID            string     `json:"id"`
+	Cron          string     `json:"cron"`
+	Timezone      string     `json:"timezone,omitempty"`
+	EventName     string     `json:"event_name"`
+	TargetUserID  string     `json:"target_user_id,omitempty"`
+	TargetUsers   []string   `json:"target_user_ids,omitempty"` // token 为数组时
+	Channel       string     `json:"channel,omitempty"`
+	Group         string     `json:"group,omitempty"`
+	Broadcast     bool       `json:"broadcast"`
+	CreatedAt     time.Time  `json:"created_at"`
+	NextRunAt     time.Time  `json:"next_run_at"`
+	LastRunAt     *time.Time `json:"last_run_at,omitempty"`
+	LastDelivered int        `json:"last_delivered"` // 最近一次发送时成功投递的连接数
+	Runs          uint64     `json:"runs"`
+
+	request  PushRequest
+	schedule *cronSchedule
+	timer    *time.Timer
+}
+
+// storedRecurringJob 落盘格式：任务状态 + 原始推送请求
+type storedRecurringJob struct {
+	recurringJob
+	Request PushRequest `json:"request"`
+}
+
+var (
+	recurringMu   sync.Mutex
+	recurringJobs = make(map[string]*recurringJob)
+
+	recurringSaveMu sync.Mutex
+
+	errRecurringLimit = fmt.Errorf("already %d recurring jobs", recurringMaxJobs)
+)
+
+// scheduleRecurring 登记周期推送，返回任务快照
+func scheduleRecurring(p *preparedPush) (recurringJob, error) {
+	now := time.Now()
+	job := &recurringJob{
+		ID:           recurringIDPrefix + randomHex(8),
+		Cron:         p.body.Cron,
+		Timezone:     p.body.Timezone,
+		EventName:    p.body.EventName,
+		TargetUserID: p.target,
+		TargetUsers:  p.targets,
+		Channel:      p.body.Channel,
+		Group:        p.body.Group,
+		Broadcast:    p.broadcast(),
+		CreatedAt:    now,
+		NextRunAt:    p.cron.next(now),
+		request:      p.body,
+		schedule:     p.cron,
+	}
+
+	recurringMu.Lock()
+	if len(recurringJobs) >= recurringMaxJobs {
+		recurringMu.Unlock()
+		return recurringJob{}, errRecurringLimit
+	}
+	recurringJobs[job.ID] = job
+	armRecurringLocked(job)
+	snapshot := *job
+	recurringMu.Unlock()
+
+	log.Printf("🔁 已登记周期推送 job=%s cron=%q event=%s，下一次 %s\n", job.ID, job.Cron, job.EventName, job.NextRunAt.Format(time.RFC3339))
+	saveRecurringJobs()
+	return snapshot, nil
+}
+
+// armRecurringLocked 按 NextRunAt 设置定时器；调用方持有 recurringMu
+func armRecurringLocked(job *recurringJob) {
+	job.timer = time.AfterFunc(max(0, time.Until(job.NextRunAt)), func() { runRecurringJob(job) })
+}
+
+// runRecurringJob 按原请求重新构造推送（新的 ts / message_id）并发送，然后排下一次
+func runRecurringJob(job *recurringJob) {
+	recurringMu.Lock()
+	if recurringJobs[job.ID] != job {
+		recurringMu.Unlock()
+		return // 已删除
+	}
+	req := job.request
+	recurringMu.Unlock()
+
+	delivered := 0
+	if p, field, err := buildPush(req, shouldLogEvent(req.EventName)); err != nil {
+		log.Printf("⚠️ 周期推送构造失败 job=%s（%s: %v），本次跳过\n", job.ID, field, err)
+	} else {
+		p.jobID = job.ID
+		delivered = p.emit()
+	}
+
+	recurringMu.Lock()
+	now := time.Now()
+	job.LastRunAt = &now
+	job.LastDelivered = delivered
+	job.Runs++
+	if recurringJobs[job.ID] == job {
+		job.NextRunAt = job.schedule.next(now)
+		armRecurringLocked(job)
+	}
+	recurringMu.Unlock()
+
+	saveRecurringJobs()
+}
+
+// removeRecurringJob 删除周期任务，返回删除前的快照
+func removeRecurringJob(id string) (recurringJob, bool) {
+	recurringMu.Lock()
+	job, ok := recurringJobs[id]
+	if !ok {
+		recurringMu.Unlock()
+		return recurringJob{}, false
+	}
+	job.timer.Stop()
+	delete(recurringJobs, id)
+	snapshot := *job
+	recurringMu.Unlock()
+
+	log.Printf("🛑 已删除周期推送 job=%s event=%s\n", id, snapshot.EventName)
+	saveRecurringJobs()
+	return snapshot, true
+}
+
+// forgetUserRecurringJobs 用户数据删除时清理周期推送任务（连同 jobs.recurring_store_file）：只发给该用户的任务删除，
+// 多用户任务从 token 数组里去掉该用户后继续按计划发送。返回删除或改写的任务数
+func forgetUserRecurringJobs(userID string) int {
+	n := 0
+	recurringMu.Lock()
+	for id, job := range recurringJobs {
+		req := job.request
+		keep, changed := scrubPushUser(&req, userID)
+		if !changed {
+			continue
+		}
+		n++
+		if keep {
+			if targets, _, err := parseTargetUsers(req.Token); err == nil {
+				job.request = req
+				job.TargetUsers = targets
+				continue
+			}
+		}
+		job.timer.Stop()
+		delete(recurringJobs, id)
+	}
+	recurringMu.Unlock()
+
+	if n > 0 {
+		saveRecurringJobs()
+	}
+	return n
+}
+
+// listRecurringJobs 按下一次发送时间排序
+func listRecurringJobs() []recurringJob {
+	recurringMu.Lock()
+	out := make([]recurringJob, 0, len(recurringJobs))
+	for _, job := range recurringJobs {
+		out = append(out, *job)
+	}
+	recurringMu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].NextRunAt.Before(out[j].NextRunAt) })
+	return out
+}
+
+// saveRecurringJobs 把周期任务写入 jobs.recurring_store_file；没有配置时不做任何事
+func saveRecurringJobs() {
+	path := GlobalConfig.Jobs.RecurringStoreFile
+	if path == "" {
+		return
+	}
+
+	recurringSaveMu.Lock()
+	defer recurringSaveMu.Unlock()
+
+	recurringMu.Lock()
+	stored := make([]storedRecurringJob, 0, len(recurringJobs))
+	for _, job := range recurringJobs {
+		stored = append(stored, storedRecurringJob{recurringJob: *job, Request: job.request})
+	}
+	recurringMu.Unlock()
+	sort.Slice(stored, func(i, j int) bool { return stored[i].CreatedAt.Before(stored[j].CreatedAt) })
+
+	if err := writeFileAtomic(path, stored); err != nil {
+		log.Printf("❌ 周期推送任务落盘失败 %s: %v\n", path, err)
+	}
+}
+
+// loadRecurringJobs 启动时恢复落盘的周期任务，下一次发送时间按当前时间重新计算
+func loadRecurringJobs() {
+	path := GlobalConfig.Jobs.RecurringStoreFile
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 读取周期推送任务失败 %s: %v\n", path, err)
+		}
+		return
+	}
+	var stored []storedRecurringJob
+	if err := json.Unmarshal(data, &stored); err != nil {
+		log.Printf("⚠️ 解析周期推送任务失败 %s: %v\n", path, err)
+		return
+	}
+
+	now := time.Now()
+	recurringMu.Lock()
+	for _, s := range stored {
+		sched, field, err := pushCron(s.Request, now)
+		if err != nil {
+			log.Printf("⚠️ 跳过无法恢复的周期推送 job=%s（%s: %v）\n", s.ID, field, err)
+			continue
+		}
+		job := s.recurringJob
+		job.request = s.Request
+		job.schedule = sched
+		job.NextRunAt = sched.next(now)
+		recurringJobs[job.ID] = &job
+		armRecurringLocked(&job)
+	}
+	n := len(recurringJobs)
+	recurringMu.Unlock()
+
+	log.Printf("✅ 已恢复周期推送任务 %d 个，来自 %s\n", n, path)
+}
+
+// ===== /v1/recurring-jobs =====
+
+// v1RecurringJobsHandler GET /v1/recurring-jobs
+func v1RecurringJobsHandler(w http.ResponseWriter, r *http.Request) {
+	writeV1(w, http.StatusOK, map[string]interface{}{"recurring_jobs": listRecurringJobs()})
+}
+
+// v1RecurringJobHandler GET / DELETE /v1/recurring-jobs/{id}
+func v1RecurringJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var (
+		job recurringJob
+		ok  bool
+	)
+	if r.Method == http.MethodDelete {
+		job, ok = removeRecurringJob(id)
+	} else {
+		recurringMu.Lock()
+		var j *recurringJob
+		if j, ok = recurringJobs[id]; ok {
+			job = *j
+		}
+		recurringMu.Unlock()
+	}
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "recurring job not found: "+id)
+		return
+	}
+	writeV1(w, http.StatusOK, map[string]interface{}{"recurring_job": job})
+}
+
+// writeRecurringLimit 周期任务数达到上限
+func writeRecurringLimit(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusConflict, problemQuotaExceeded, errRecurringLimit.Error())
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	valid := []struct {
+		spec       string
+		after      string
+		wantNext   string
+		wantMinute uint64
+	}{
+		{"*/15 * * * *", "2026-01-01T10:07:00Z", "2026-01-01T10:15:00Z", 1<<0 | 1<<15 | 1<<30 | 1<<45},
+		{"5/20 9 * * *", "2026-01-01T10:00:00Z", "2026-01-02T09:05:00Z", 1<<5 | 1<<25 | 1<<45},
+		{"0 9 * * 7", "2026-01-01T00:00:00Z", "2026-01-04T09:00:00Z", 1},
+		{"30 8 1-3,15 * *", "2026-01-03T09:00:00Z", "2026-01-15T08:30:00Z", 1 << 30},
+		{"@daily", "2026-01-01T10:00:00Z", "2026-01-02T00:00:00Z", 1},
+		{" @hourly ", "2026-01-01T10:00:00Z", "2026-01-01T11:00:00Z", 1},
+		// 步长远大于取值范围时只取起点，不能因为溢出绕回来
+		{"5/9223372036854775807 * * * *", "2026-01-01T10:00:00Z", "2026-01-01T10:05:00Z", 1 << 5},
+		{"*/100 * * * *", "2026-01-01T10:00:00Z", "2026-01-01T11:00:00Z", 1},
+	}
+	for _, tc := range valid {
+		s, err := parseCron(tc.spec, time.UTC)
+		if err != nil {
+			t.Errorf("%q: %v", tc.spec, err)
+			continue
+		}
+		if s.minute != tc.wantMinute {
+			t.Errorf("%q: minute 位图 %b, want %b", tc.spec, s.minute, tc.wantMinute)
+		}
+		after, _ := time.Parse(time.RFC3339, tc.after)
+		if got := s.next(after).Format(time.RFC3339); got != tc.wantNext {
+			t.Errorf("%q: next(%s) = %s, want %s", tc.spec, tc.after, got, tc.wantNext)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@every 5m",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"-1 * * * *",
+		"*/0 * * * *",
+		"*/-5 * * * *",
+		"*/x * * * *",
+		"1/ * * * *",
+		"10-5 * * * *",
+		"5- * * * *",
+		"-5 * * * *",
+		"1-2-3 * * * *",
+		"1,,2 * * * *",
+		"a * * * *",
+		"99999999999999999999 * * * *",
+		"*/99999999999999999999 * * * *",
+		strings.Repeat("1,", 10000) + "99 * * * *",
+	}
+	for _, spec := range invalid {
+		if _, err := parseCron(spec, time.UTC); err == nil {
+			t.Errorf("%q: 应当报错", spec)
+		}
+	}
+}
+
+func TestPushCronNeverFires(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, field, err := pushCron(PushRequest{Cron: "0 0 30 2 *"}, now); err == nil || field != "cron" {
+		t.Fatalf("2 月 30 日: field=%q err=%v", field, err)
+	}
+	if _, field, err := pushCron(PushRequest{Cron: "@daily", Timezone: "Mars/Base"}, now); err == nil || field != "timezone" {
+		t.Fatalf("未知时区: field=%q err=%v", field, err)
+	}
+}
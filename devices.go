@@ -0,0 +1,380 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== 设备 / 会话登记 =====
+//
+// 每个连接带一个设备描述：客户端 identify 时在 data.device 中上报，
+// 或者连接时用 ?device_id= 指定，都没有时从 User-Agent 解析。
+// 设备按用户登记，记录首次 / 最后在线时间和当前在线连接数，
+// 配置 store_file 后定期落盘，重启后 last_seen 依然可查。
+
+// DevicesConfig 设备登记配置
+type DevicesConfig struct {
+	Enabled    bool   `json:"enabled"`
+	StoreFile  string `json:"store_file"`   // 落盘文件（相对当前工作目录），留空只保存在内存
+	MaxPerUser int    `json:"max_per_user"` // 每用户最多保留的设备数，默认 20，超出时淘汰最久未在线的离线设备
+}
+
+// DeviceInfo 设备描述
+type DeviceInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name,omitempty"`
+	Platform  string `json:"platform,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// DeviceRecord 用户名下的一台设备
+type DeviceRecord struct {
+	DeviceInfo
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Online    int       `json:"online_connections"`
+}
+
+const (
+	devicesDefaultMaxPerUser = 20
+	devicesSaveInterval      = 10 * time.Second
+)
+
+var (
+	devicesMu    sync.Mutex
+	userDevices  = make(map[string]map[string]*DeviceRecord) // user_id -> device_id -> 记录
+	devicesDirty bool
+)
+
+// deviceFromRequest 从升级请求里提取设备描述
+func deviceFromRequest(r *http.Request) *DeviceInfo {
+	ua := r.UserAgent()
+	name, platform := parseUserAgent(ua)
+
+	id := r.URL.Query().Get("device_id")
+	if id == "" {
+		// 没有上报设备 ID 时按 User-Agent 归并，同一浏览器的多个标签页算一台设备
+		sum := sha256.Sum256([]byte(ua))
+		id = "ua-" + hex.EncodeToString(sum[:6])
+	}
+	return &DeviceInfo{ID: id, Name: name, Platform: platform, UserAgent: ua}
+}
+
+// mergeDeviceInfo 用 identify 上报的字段覆盖已有描述
+func mergeDeviceInfo(base, override *DeviceInfo) *DeviceInfo {
+	merged := DeviceInfo{}
+	if base != nil {
+		merged = *base
+	}
+	if override.ID != "" {
+		merged.ID = override.ID
+	}
+	if override.Name != "" {
+		merged.Name = override.Name
+	}
+	if override.Platform != "" {
+		merged.Platform = override.Platform
+	}
+	return &merged
+}
+
+// parseUserAgent 粗略识别浏览器和系统，够“管理登录设备”列表展示用
+func parseUserAgent(ua string) (name, platform string) {
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		platform = "iOS"
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		platform = "macOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	default:
+		platform = "Unknown"
+	}
+
+	browser := ""
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	}
+
+	if browser == "" {
+		return platform, platform
+	}
+	return browser + " on " + platform, platform
+}
+
+// touchDevice 把连接的设备登记到用户名下（registerUser 时调用）
+func touchDevice(c *Client, userID string) {
+	if c.device == nil || strings.HasPrefix(userID, visitorUserPrefix) {
+		return
+	}
+
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+
+	now := time.Now()
+	if c.deviceUser == userID && c.deviceRegID == c.device.ID {
+		if rec := userDevices[userID][c.device.ID]; rec != nil {
+			rec.LastSeen = now
+		}
+		return
+	}
+	releaseDeviceLocked(c, now)
+
+	devices, ok := userDevices[userID]
+	if !ok {
+		devices = make(map[string]*DeviceRecord)
+		userDevices[userID] = devices
+	}
+	rec, ok := devices[c.device.ID]
+	if !ok {
+		rec = &DeviceRecord{FirstSeen: now}
+		devices[c.device.ID] = rec
+		evictOldDevicesLocked(devices)
+	}
+	rec.DeviceInfo = *c.device
+	rec.LastSeen = now
+	rec.Online++
+
+	c.deviceUser = userID
+	c.deviceRegID = c.device.ID
+	devicesDirty = true
+}
+
+// releaseDevice 连接断开时更新设备的最后在线时间
+func releaseDevice(c *Client) {
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+	releaseDeviceLocked(c, time.Now())
+}
+
+func releaseDeviceLocked(c *Client, now time.Time) {
+	if c.deviceUser == "" {
+		return
+	}
+	if rec := userDevices[c.deviceUser][c.deviceRegID]; rec != nil {
+		if rec.Online > 0 {
+			rec.Online--
+		}
+		rec.LastSeen = now
+	}
+	c.deviceUser = ""
+	c.deviceRegID = ""
+	devicesDirty = true
+}
+
+// evictOldDevicesLocked 超过上限时淘汰最久未在线的离线设备
+func evictOldDevicesLocked(devices map[string]*DeviceRecord) {
+	max := GlobalConfig.Devices.MaxPerUser
+	if max <= 0 {
+		max = devicesDefaultMaxPerUser
+	}
+	for len(devices) > max {
+		oldestID := ""
+		for id, rec := range devices {
+			if rec.Online > 0 {
+				continue
+			}
+			if oldestID == "" || rec.LastSeen.Before(devices[oldestID].LastSeen) {
+				oldestID = id
+			}
+		}
+		if oldestID == "" {
+			return
+		}
+		delete(devices, oldestID)
+	}
+}
+
+// listUserDevices 返回用户的设备列表，最近在线的排前面
+func listUserDevices(userID string) []DeviceRecord {
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+
+	list := make([]DeviceRecord, 0, len(userDevices[userID]))
+	for _, rec := range userDevices[userID] {
+		list = append(list, *rec)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+	return list
+}
+
+// forgetUserDevices 删除用户的全部设备记录，返回删除的设备数；在线连接应先断开
+func forgetUserDevices(userID string) int {
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+
+	n := len(userDevices[userID])
+	if n > 0 {
+		delete(userDevices, userID)
+		devicesDirty = true
+	}
+	return n
+}
+
+// ===== 查询接口 =====
+
+var deviceSorters = pageSorter[DeviceRecord]{
+	"last_seen":  func(d DeviceRecord) string { return timeSortKey(d.LastSeen) },
+	"first_seen": func(d DeviceRecord) string { return timeSortKey(d.FirstSeen) },
+	"id":         func(d DeviceRecord) string { return d.ID },
+}
+
+// userDevicesHandler GET /api/users/{id}/devices：支持 platform / online / seen_since 过滤、排序和游标分页
+func userDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	q := r.URL.Query()
+	page, field, err := parsePageParams(q, "-last_seen", []string{"last_seen", "first_seen", "id"})
+	if err != nil {
+		writeValidationProblem(w, r, field, err.Error())
+		return
+	}
+	online := q.Get("online")
+	if online != "" && online != "true" && online != "false" {
+		writeValidationProblem(w, r, "online", "online must be true or false")
+		return
+	}
+	var since time.Time
+	if v := q.Get("seen_since"); v != "" {
+		if since, err = parseTimeParam(v); err != nil {
+			writeValidationProblem(w, r, "seen_since", "seen_since must be RFC3339 or unix seconds")
+			return
+		}
+	}
+	platform := q.Get("platform")
+
+	list := make([]DeviceRecord, 0)
+	for _, d := range listUserDevices(userID) {
+		switch {
+		case platform != "" && !strings.EqualFold(d.Platform, platform),
+			online == "true" && d.Online == 0,
+			online == "false" && d.Online > 0,
+			!since.IsZero() && d.LastSeen.Before(since):
+			continue
+		}
+		list = append(list, d)
+	}
+	items, next := paginate(list, page, deviceSorters, func(d DeviceRecord) string { return d.ID })
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{
+			"user_id":     userID,
+			"total":       len(list),
+			"devices":     items,
+			"next_cursor": next,
+		},
+	})
+}
+
+// ===== 落盘 =====
+
+func devicesStorePath() string {
+	if GlobalConfig.Devices.StoreFile == "" {
+		return ""
+	}
+	return filepath.Join(getCurrentDir(), GlobalConfig.Devices.StoreFile)
+}
+
+// loadDevices 启动时从文件恢复设备记录，在线连接数清零
+func loadDevices() {
+	path := devicesStorePath()
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 读取设备记录失败 %s: %v\n", path, err)
+		}
+		return
+	}
+
+	var stored map[string][]DeviceRecord
+	if err := json.Unmarshal(data, &stored); err != nil {
+		log.Printf("⚠️ 解析设备记录失败 %s: %v\n", path, err)
+		return
+	}
+
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+	total := 0
+	for userID, list := range stored {
+		devices := make(map[string]*DeviceRecord, len(list))
+		for i := range list {
+			rec := list[i]
+			rec.Online = 0
+			devices[rec.ID] = &rec
+			total++
+		}
+		userDevices[userID] = devices
+	}
+	log.Printf("✅ 已恢复设备记录：%d 个用户，%d 台设备\n", len(stored), total)
+}
+
+// devicesSaveLoop 有变更时定期落盘
+func devicesSaveLoop() {
+	path := devicesStorePath()
+	if path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(devicesSaveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		devicesMu.Lock()
+		if !devicesDirty {
+			devicesMu.Unlock()
+			continue
+		}
+		snapshot := make(map[string][]DeviceRecord, len(userDevices))
+		for userID, devices := range userDevices {
+			list := make([]DeviceRecord, 0, len(devices))
+			for _, rec := range devices {
+				list = append(list, *rec)
+			}
+			snapshot[userID] = list
+		}
+		devicesDirty = false
+		devicesMu.Unlock()
+
+		if err := writeFileAtomic(path, snapshot); err != nil {
+			log.Printf("❌ 设备记录落盘失败 %s: %v\n", path, err)
+		}
+	}
+}
+
+// writeFileAtomic 先写临时文件再改名，避免写到一半被读到
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
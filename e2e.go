@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 端到端加密载荷透传 =====
+//
+// 推送时带 ciphertext（base64）+ key_id，中继不解析、不记录内容，原样下发：
+//   - 默认：JSON 事件，data 为 {"key_id","ciphertext","ts"}，ciphertext 保持 base64
+//   - 原生 WebSocket 连接带 ?e2e=binary 时：二进制帧 [1 字节 key_id 长度][key_id][密文原始字节]
+
+const e2eMaxKeyIDLen = 255
+
+// EncryptedPayload 端到端加密推送下发给客户端的 data
+type EncryptedPayload struct {
+	KeyID      string `json:"key_id"`
+	Ciphertext string `json:"ciphertext"` // base64
+	Ts         int64  `json:"ts"`
+}
+
+// validateCiphertext 校验推送请求里的密文字段
+func validateCiphertext(body *PushRequest) error {
+	if body.Subject != nil {
+		return fmt.Errorf("ciphertext 与 subject 不能同时使用")
+	}
+	if body.KeyID == "" || len(body.KeyID) > e2eMaxKeyIDLen {
+		return fmt.Errorf("ciphertext 需要配合 key_id（1-%d 字节）使用", e2eMaxKeyIDLen)
+	}
+	if _, err := base64.StdEncoding.DecodeString(body.Ciphertext); err != nil {
+		return fmt.Errorf("ciphertext 不是合法的 base64")
+	}
+	return nil
+}
+
+// redactedPushLog 推送请求的日志形式，密文只记录长度，其余字段按 redaction.fields 脱敏
+func redactedPushLog(body PushRequest) string {
+	if body.Ciphertext != "" {
+		body.Ciphertext = fmt.Sprintf("<%d bytes base64>", len(body.Ciphertext))
+	}
+	return redactLog(body)
+}
+
+// encryptedPayloadLog 加密载荷的日志形式
+func encryptedPayloadLog(p EncryptedPayload) string {
+	return fmt.Sprintf(`{"key_id":%q,"ciphertext":"<%d bytes base64>","ts":%d}`, p.KeyID, len(p.Ciphertext), p.Ts)
+}
+
+// encodeE2EBinary 组装二进制帧：[key_id 长度][key_id][密文]
+func encodeE2EBinary(p EncryptedPayload) ([]byte, error) {
+	cipher, err := base64.StdEncoding.DecodeString(p.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 0, 1+len(p.KeyID)+len(cipher))
+	frame = append(frame, byte(len(p.KeyID)))
+	frame = append(frame, p.KeyID...)
+	frame = append(frame, cipher...)
+	return frame, nil
+}
+
+// isEncryptedPayload 消息是否为端到端加密载荷，开启了二进制模式的原生连接对这类消息按二进制帧下发
+func isEncryptedPayload(msg WSMessage) bool {
+	_, ok := msg.Data.(EncryptedPayload)
+	return ok
+}
+
+// encodeE2EFrame 把加密载荷编码成二进制帧
+func encodeE2EFrame(msg WSMessage) encodedFrame {
+	frame, err := encodeE2EBinary(msg.Data.(EncryptedPayload))
+	return encodedFrame{messageType: websocket.BinaryMessage, data: frame, err: err}
+}
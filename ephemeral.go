@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ===== 瞬时消息 =====
+//
+// 正在输入、光标位置这类消息只关心最新值，晚到的没有意义。推送请求带 "ephemeral": true 时走最短路径：
+//   - 不进用户历史（断线重连不补发）、不分配 message_id（不记投递回执，客户端不需要 ack）、不写归档
+//   - 不进异步推送队列，在请求线程里直接下发；不能和 delay_seconds / deliver_at 一起使用
+//   - 连接正在写别的消息（慢连接）时直接丢弃这条，不排队等锁
+//
+// 下发的消息带 "ephemeral": true，客户端可以据此不落本地存储。流量订阅（tap / firehose）仍能看到。
+
+var (
+	ephemeralPushes  atomic.Uint64
+	ephemeralDropped atomic.Uint64
+)
+
+// errEphemeralDropped 瞬时消息因连接正忙被丢弃：不算投递成功，也不是连接故障，调用方不能据此断开连接
+var errEphemeralDropped = errors.New("ephemeral message dropped: connection busy")
+
+// emitEphemeral 瞬时消息直接发给在线连接，返回成功投递的连接数
+func (p *preparedPush) emitEphemeral() int {
+	ephemeralPushes.Add(1)
+	if p.body.Group != "" {
+		return emitToGroup(p.body.Group, p.message, p.match)
+	}
+	if p.body.Channel != "" {
+		return emitToChannelMatching(p.body.Channel, p.message, p.match)
+	}
+	if len(p.targets) > 0 {
+		return p.routeUsers()
+	}
+	if p.target != "" {
+		return emitToUserConns(p.target, p.message, p.match)
+	}
+	return broadcastMatching(p.message, p.match)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ===== 用户数据删除（GDPR 被遗忘权） =====
+//
+// DELETE /api/users/{id}（admin 认证）一次性清掉 relay 上与该用户相关的数据并返回删除报告：
+// 断开在线连接 → 作废未领取的迁移票据和续接会话 → 删除消息历史和离线消息 → 删除设备登记和 KV 状态
+// → 取消并清理发给该用户的延迟推送和周期推送（连同 jobs.store_file / recurring_store_file）→ 删除推送目标含该用户的幂等键 → 按策略处理本地归档。
+// 已上传到 S3 的归档对象不在这里处理，需要用存储侧的生命周期规则或离线任务删除。
+
+// ErasureConfig 用户数据删除策略
+type ErasureConfig struct {
+	ArchivePolicy string `json:"archive_policy"` // 本地归档的处理方式：delete（默认，删除该用户的行）/ redact（保留行，抹掉 user_id 和 data）/ keep
+}
+
+// CloseUserErased 用户数据被删除时断开其连接的关闭码（对应 HTTP 410）
+const CloseUserErased = 4410
+
+const (
+	erasureArchiveDelete = "delete"
+	erasureArchiveRedact = "redact"
+	erasureArchiveKeep   = "keep"
+)
+
+// erasureReport 删除报告，各项为实际删除的数量
+type erasureReport struct {
+	UserID            string `json:"user_id"`
+	ConnectionsClosed int    `json:"connections_closed"`
+	HandoffTickets    int    `json:"handoff_tickets"`
+	ResumeSessions    int    `json:"resume_sessions"`
+	HistoryMessages   int    `json:"history_messages"`
+	OfflineMessages   int    `json:"offline_messages"`
+	Devices           int    `json:"devices"`
+	Receipts          int    `json:"receipts"`
+	KVKeys            int    `json:"kv_keys"`
+	Groups            int    `json:"groups"`
+	ScheduledJobs     int    `json:"scheduled_jobs"`   // 删除或去掉该用户的延迟推送任务
+	RecurringJobs     int    `json:"recurring_jobs"`   // 删除或去掉该用户的周期推送任务
+	IdempotencyKeys   int    `json:"idempotency_keys"` // 推送目标含该用户的幂等键
+	ArchivePolicy     string `json:"archive_policy,omitempty"`
+	ArchiveFiles      int    `json:"archive_files"`
+	ArchiveEntries    int    `json:"archive_entries"`
+	S3Archive         bool   `json:"s3_archive_not_scrubbed,omitempty"` // 开启了 S3 归档，需要另行处理
+}
+
+func initErasure() {
+	switch GlobalConfig.Erasure.ArchivePolicy {
+	case "", erasureArchiveDelete, erasureArchiveRedact, erasureArchiveKeep:
+	default:
+		log.Fatalf("❌ erasure.archive_policy 不支持: %s（可选 delete / redact / keep）\n", GlobalConfig.Erasure.ArchivePolicy)
+	}
+}
+
+// eraseUserHandler DELETE /api/users/{id}
+func eraseUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	report, err := eraseUser(userID)
+	if err != nil {
+		log.Printf("❌ 删除用户数据失败 user_id=%s: %v\n", logUserID(userID), err)
+		writeProblemWith(w, r, http.StatusInternalServerError, problemInternal, "erasure incomplete: "+err.Error(),
+			map[string]interface{}{"report": report})
+		return
+	}
+
+	log.Printf("🗑️ 已删除用户数据 user_id=%s：连接 %d，历史 %d 条，设备 %d 台，归档 %d 条\n",
+		logUserID(userID), report.ConnectionsClosed, report.HistoryMessages, report.Devices, report.ArchiveEntries)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": report,
+	})
+}
+
+// eraseUser 删除用户在本节点上的所有数据；出错时返回已完成部分的报告
+func eraseUser(userID string) (erasureReport, error) {
+	report := erasureReport{UserID: userID}
+
+	conns := defaultHub.userConns(userID)
+	for _, c := range conns {
+		// 先退出用户组，避免连接真正关闭前还能收到该用户的消息
+		unregisterUser(c)
+		c.closeWithCode(CloseUserErased, "user data erased")
+	}
+	report.ConnectionsClosed = len(conns)
+
+	if GlobalConfig.Cluster.Enabled {
+		report.HandoffTickets = dropUserHandoffTickets(userID)
+	}
+	report.ResumeSessions = dropUserResumeSessions(userID)
+	report.HistoryMessages = deleteUserHistory(userID)
+	if GlobalConfig.OfflineQueue.Enabled {
+		report.OfflineMessages = forgetUserOffline(userID)
+	}
+	if GlobalConfig.Devices.Enabled {
+		report.Devices = forgetUserDevices(userID)
+	}
+	if GlobalConfig.Receipts.Enabled {
+		report.Receipts = forgetUserReceipts(userID)
+	}
+	if GlobalConfig.KV.Enabled {
+		report.KVKeys = forgetUserKV(userID)
+	}
+	if GlobalConfig.Groups.Enabled {
+		report.Groups = forgetGroupUser(userID)
+	}
+	report.ScheduledJobs = forgetUserPushJobs(userID)
+	report.RecurringJobs = forgetUserRecurringJobs(userID)
+	report.IdempotencyKeys = forgetUserIdempotency(userID)
+
+	if GlobalConfig.Archive.Enabled {
+		report.S3Archive = GlobalConfig.Archive.S3.Bucket != ""
+		if archiveFiles != nil {
+			policy := GlobalConfig.Erasure.ArchivePolicy
+			if policy == "" {
+				policy = erasureArchiveDelete
+			}
+			report.ArchivePolicy = policy
+			if policy != erasureArchiveKeep {
+				files, entries, err := archiveFiles.eraseUser(userID, policy == erasureArchiveRedact)
+				report.ArchiveFiles, report.ArchiveEntries = files, entries
+				if err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// postV1Push 调一次 /v1/push（不经过鉴权），key 非空时带 Idempotency-Key，返回状态码
+func postV1Push(t *testing.T, key, body string) int {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, v1PushPath, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(idempotencyHeader, key)
+	w := httptest.NewRecorder()
+	withIdempotency(v1PushHandler)(w, r)
+	return w.Code
+}
+
+func TestEraseUserScrubsJobsAndIdempotency(t *testing.T) {
+	store := filepath.Join(t.TempDir(), "jobs.json")
+	useConfig(t, func(cfg *Config) { cfg.Jobs.StoreFile = store })
+	t.Cleanup(func() {
+		for _, job := range listPushJobs("") {
+			cancelPushJob(job.ID)
+		}
+		pushJobsMu.Lock()
+		clear(pushJobs)
+		pushJobsMu.Unlock()
+		idempotencyMu.Lock()
+		clear(idempotencyKeys)
+		idempotencyMu.Unlock()
+	})
+
+	pushes := map[string]string{
+		"erase-single": `{"event_name":"remind","token":"erase-u1","subject":{"note":"secret"},"delay_seconds":3600}`,
+		"erase-multi":  `{"event_name":"remind","token":["erase-u1","erase-u2"],"delay_seconds":3600}`,
+		"erase-other":  `{"event_name":"remind","token":"erase-u2","delay_seconds":3600}`,
+		"erase-now":    `{"event_name":"hello","token":"erase-u1"}`,
+	}
+	for key, body := range pushes {
+		if code := postV1Push(t, key, body); code/100 != 2 {
+			t.Fatalf("%s: status %d", key, code)
+		}
+	}
+
+	report, err := eraseUser("erase-u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ScheduledJobs != 2 || report.IdempotencyKeys != 3 {
+		t.Fatalf("scheduled_jobs=%d idempotency_keys=%d, want 2 / 3", report.ScheduledJobs, report.IdempotencyKeys)
+	}
+
+	// 只发给该用户的任务被删掉，多用户任务只剩其他用户
+	jobs := listPushJobs("")
+	if len(jobs) != 2 {
+		t.Fatalf("剩余任务 %d 个, want 2", len(jobs))
+	}
+	for _, job := range jobs {
+		if job.Status != jobStatusScheduled || job.TargetUserID == "erase-u1" {
+			t.Fatalf("任务 %+v", job)
+		}
+		if job.TargetUserID == "" && (len(job.TargetUsers) != 1 || job.TargetUsers[0] != "erase-u2") {
+			t.Fatalf("多用户任务的目标 = %v, want [erase-u2]", job.TargetUsers)
+		}
+	}
+	data, err := os.ReadFile(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "erase-u1") || strings.Contains(string(data), "secret") {
+		t.Fatalf("jobs.store_file 里还有该用户的数据: %s", data)
+	}
+
+	// 幂等键已删除，同一个键重新执行；不涉及该用户的键照常回放
+	idempotencyMu.Lock()
+	_, erased := idempotencyKeys["erase-now"]
+	_, kept := idempotencyKeys["erase-other"]
+	idempotencyMu.Unlock()
+	if erased || !kept {
+		t.Fatalf("erase-now 仍在=%v erase-other 仍在=%v", erased, kept)
+	}
+}
+
+func TestEraseUserScrubsRecurringJobs(t *testing.T) {
+	store := filepath.Join(t.TempDir(), "recurring.json")
+	useConfig(t, func(cfg *Config) { cfg.Jobs.RecurringStoreFile = store })
+	t.Cleanup(func() {
+		for _, job := range listRecurringJobs() {
+			removeRecurringJob(job.ID)
+		}
+	})
+
+	for _, body := range []string{
+		`{"event_name":"daily","token":"erase-u1","subject":{"note":"secret"},"cron":"0 9 * * *"}`,
+		`{"event_name":"daily","token":["erase-u1","erase-u2"],"cron":"0 9 * * *"}`,
+		`{"event_name":"daily","channel":"news","exclude_tokens":["erase-u1"],"cron":"0 9 * * *"}`,
+	} {
+		if code := postV1Push(t, "", body); code/100 != 2 {
+			t.Fatalf("%s: status %d", body, code)
+		}
+	}
+
+	report, err := eraseUser("erase-u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.RecurringJobs != 3 {
+		t.Fatalf("recurring_jobs = %d, want 3", report.RecurringJobs)
+	}
+	if jobs := listRecurringJobs(); len(jobs) != 2 {
+		t.Fatalf("剩余周期任务 %d 个, want 2", len(jobs))
+	}
+	data, err := os.ReadFile(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "erase-u1") || strings.Contains(string(data), "secret") {
+		t.Fatalf("recurring_store_file 里还有该用户的数据: %s", data)
+	}
+}
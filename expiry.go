@@ -0,0 +1,246 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 频道 / 组的自动过期 =====
+//
+// 长时间运行的中继会攒下大量早就没人用的频道和组。开启 expiry 后后台定期清理：
+//
+//   - 频道 channel_idle_seconds 内没有任何推送或新订阅，或者从第一次被订阅起超过 channel_max_age_seconds，
+//     就把所有订阅者退订，并给每个连接发 {"event":"channel_expired","channel":"room:42","data":{"reason":"idle"}}
+//   - 接口创建的组 group_idle_seconds 内没有推送也没有成员变动就删除（组原有的 ttl 过期照旧）
+//
+// 配置了 callback_url 时，每次过期 POST 给业务后端（签名方式同准入 webhook，失败重试 retries 次）：
+//
+//	{"event":"channel_expired","channel":"room:42","reason":"idle","subscribers":3,"ts":1760580000000,"node_id":"relay-1"}
+//	{"event":"group_expired","group":"call-42","reason":"ttl","ts":1760580000000}
+//
+// reason 为 idle / max_age / ttl。频道过期只作用于本节点的订阅者，集群部署时各节点各自判断。
+
+// ExpiryConfig 频道 / 组自动过期配置
+type ExpiryConfig struct {
+	Enabled              bool     `json:"enabled"`
+	ChannelIdleSeconds   int      `json:"channel_idle_seconds"`    // 频道多久没有推送或新订阅就过期，0 表示不按空闲过期
+	ChannelMaxAgeSeconds int      `json:"channel_max_age_seconds"` // 频道从第一次被订阅起最长存活时间，0 表示不限
+	Channels             []string `json:"channels"`                // 只清理这些频道，支持 * / ? 通配；为空表示全部
+	GroupIdleSeconds     int      `json:"group_idle_seconds"`      // 组多久没有推送也没有成员变动就删除，0 表示不按空闲过期
+	CallbackURL          string   `json:"callback_url"`            // 可选：过期时通知业务后端
+	CallbackSecret       string   `json:"callback_secret"`         // 可选：回调签名密钥，支持密钥引用
+	TimeoutSeconds       int      `json:"timeout_seconds"`         // 回调超时，默认 3
+	Retries              int      `json:"retries"`                 // 回调失败重试次数，默认 2
+}
+
+const (
+	expiryDefaultTimeout = 3
+	expiryDefaultRetries = 2
+	expiryQueueSize      = 1024
+	expiryRetryBackoff   = time.Second
+	expirySweepInterval  = 5 * time.Second
+
+	expiryReasonIdle   = "idle"
+	expiryReasonMaxAge = "max_age"
+	expiryReasonTTL    = "ttl"
+
+	expiryEventChannel = "channel_expired"
+	expiryEventGroup   = "group_expired"
+)
+
+// expiryEvent 过期回调的请求体
+type expiryEvent struct {
+	Event       string `json:"event"`
+	Channel     string `json:"channel,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Reason      string `json:"reason"`
+	Subscribers int    `json:"subscribers,omitempty"`
+	Ts          int64  `json:"ts"`
+	NodeID      string `json:"node_id,omitempty"`
+}
+
+// channelActivity 频道第一次被订阅和最后一次活动的时间
+type channelActivity struct {
+	createdAt time.Time
+	activeAt  time.Time
+}
+
+var (
+	expiryClient *http.Client
+	expiryQueue  chan expiryEvent
+
+	expiryMu          sync.Mutex
+	channelActivities = make(map[string]*channelActivity)
+
+	expiredChannels  atomic.Uint64
+	expiredGroups    atomic.Uint64
+	expiryCallbackOK atomic.Uint64
+	expiryErrors     atomic.Uint64
+	expiryDropped    atomic.Uint64
+)
+
+func prepareExpiry(cfg *ExpiryConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.ChannelIdleSeconds < 0 {
+		cfg.ChannelIdleSeconds = 0
+	}
+	if cfg.ChannelMaxAgeSeconds < 0 {
+		cfg.ChannelMaxAgeSeconds = 0
+	}
+	if cfg.GroupIdleSeconds < 0 {
+		cfg.GroupIdleSeconds = 0
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = expiryDefaultTimeout
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = expiryDefaultRetries
+	} else if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	patterns := cfg.Channels[:0]
+	for _, p := range cfg.Channels {
+		if _, err := path.Match(p, ""); err != nil {
+			log.Printf("⚠️ expiry.channels 模式无效，已忽略: %s\n", p)
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	cfg.Channels = patterns
+	expiryClient = &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}
+}
+
+// startExpiry 启动清理协程和回调发送协程
+func startExpiry() {
+	expiryQueue = make(chan expiryEvent, expiryQueueSize)
+	go func() {
+		for ev := range expiryQueue {
+			sendExpiryEvent(ev)
+		}
+	}()
+	go expirySweepLoop()
+}
+
+// channelExpiryTracked 频道是否参与自动过期
+func channelExpiryTracked(channel string) bool {
+	cfg := GlobalConfig.Expiry
+	if !cfg.Enabled || (cfg.ChannelIdleSeconds == 0 && cfg.ChannelMaxAgeSeconds == 0) {
+		return false
+	}
+	if len(cfg.Channels) == 0 {
+		return true
+	}
+	for _, p := range cfg.Channels {
+		if ok, _ := path.Match(p, channel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// touchChannel 频道有推送或新订阅；created 表示频道刚从无到有，存活时间从这时重新算
+func touchChannel(channel string, created bool) {
+	if !channelExpiryTracked(channel) {
+		return
+	}
+	now := time.Now()
+	expiryMu.Lock()
+	defer expiryMu.Unlock()
+	if a, ok := channelActivities[channel]; ok && !created {
+		a.activeAt = now
+		return
+	}
+	channelActivities[channel] = &channelActivity{createdAt: now, activeAt: now}
+}
+
+func expirySweepLoop() {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		expireChannels(now)
+		if GlobalConfig.Groups.Enabled && GlobalConfig.Expiry.GroupIdleSeconds > 0 {
+			expireIdleGroups(now)
+		}
+	}
+}
+
+// expireChannels 找出空闲或超龄的频道并退订所有订阅者
+func expireChannels(now time.Time) {
+	cfg := GlobalConfig.Expiry
+	idle := time.Duration(cfg.ChannelIdleSeconds) * time.Second
+	maxAge := time.Duration(cfg.ChannelMaxAgeSeconds) * time.Second
+
+	expired := make(map[string]string)
+	expiryMu.Lock()
+	for ch, a := range channelActivities {
+		switch {
+		case maxAge > 0 && now.Sub(a.createdAt) >= maxAge:
+			expired[ch] = expiryReasonMaxAge
+		case idle > 0 && now.Sub(a.activeAt) >= idle:
+			expired[ch] = expiryReasonIdle
+		default:
+			continue
+		}
+		delete(channelActivities, ch)
+	}
+	expiryMu.Unlock()
+
+	for ch, reason := range expired {
+		members := channelMembers(ch)
+		if len(members) == 0 {
+			continue // 订阅者早就走光了，频道已被删除
+		}
+		for _, c := range members {
+			unsubscribeChannel(c, ch)
+			_ = c.deliver(WSMessage{Event: expiryEventChannel, Channel: ch, Data: map[string]string{"reason": reason}})
+		}
+		expiredChannels.Add(1)
+		log.Printf("⌛ 频道 %s 已过期（%s），退订 %d 个连接\n", ch, reason, len(members))
+		enqueueExpiryEvent(expiryEvent{Event: expiryEventChannel, Channel: ch, Reason: reason, Subscribers: len(members)})
+	}
+}
+
+// groupExpired 组因 ttl 或空闲被删除；调用方持有 groupsMu
+func groupExpired(name, reason string) {
+	expiredGroups.Add(1)
+	log.Printf("⌛ 组 %s 已过期（%s）\n", name, reason)
+	if GlobalConfig.Expiry.Enabled {
+		enqueueExpiryEvent(expiryEvent{Event: expiryEventGroup, Group: name, Reason: reason})
+	}
+}
+
+// enqueueExpiryEvent 交给回调发送协程，没配置 callback_url 时不做任何事，队列满时丢弃
+func enqueueExpiryEvent(ev expiryEvent) {
+	if GlobalConfig.Expiry.CallbackURL == "" || expiryQueue == nil {
+		return
+	}
+	ev.Ts = time.Now().UnixMilli()
+	if GlobalConfig.Cluster.Enabled {
+		ev.NodeID = GlobalConfig.Cluster.NodeID
+	}
+	select {
+	case expiryQueue <- ev:
+	default:
+		expiryDropped.Add(1)
+		log.Printf("⚠️ 过期回调队列已满，丢弃 %s %s%s\n", ev.Event, ev.Channel, ev.Group)
+	}
+}
+
+// sendExpiryEvent 发送一个回调，失败按配置重试
+func sendExpiryEvent(ev expiryEvent) {
+	cfg := GlobalConfig.Expiry
+	retry := webhookRetry{client: expiryClient, retries: cfg.Retries, backoff: expiryRetryBackoff}
+	if err := deliverWebhook(cfg.CallbackURL, liveSecret("expiry.callback_secret", cfg.CallbackSecret), ev, retry); err != nil {
+		expiryErrors.Add(1)
+		log.Printf("⚠️ 过期回调发送失败 %s %s%s: %v\n", ev.Event, ev.Channel, ev.Group, err)
+		return
+	}
+	expiryCallbackOK.Add(1)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ===== firehose =====
+//
+// GET /api/firehose（WebSocket）实时输出 relay 下发的每一条消息的副本，附带投递元数据
+// （目标类型、命中连接数、成功数、耗时），给分析管道、调试控制台用。
+// 认证走 firehose 认证链（未配置时沿用 admin 的配置）；JWT 调用方还需要 scope 里带 firehose。
+// 浏览器里连接可以用 ?api_key=（除非开启了 disable_query_api_key）。
+//
+// 繁忙的部署上订阅方通常不需要全量，可以在服务端先过滤 / 采样（见 firehoseFilter），
+// 条件用查询参数给出，连接后也可以随时发一条 {"sample":0.1,"events":["order_*"]} 整体替换。
+
+// FirehoseConfig firehose 配置
+type FirehoseConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+const (
+	firehosePath          = "/api/firehose"
+	firehoseScope         = "firehose"
+	firehoseDropReportGap = 5 * time.Second
+)
+
+func registerFirehoseRoutes(mux *http.ServeMux) {
+	mux.Handle("GET "+firehosePath, checkAuth("firehose", http.HandlerFunc(firehoseHandler)))
+	log.Printf("✅ firehose 已启用：%s\n", firehosePath)
+}
+
+// principalHasScope JWT 调用方按 scope / scp claim 判断；其他认证方式（API key 等）本身就是按接口配置的，直接放行
+func principalHasScope(p *authPrincipal, scope string) bool {
+	if p == nil || p.Claims == nil {
+		return true
+	}
+	if v, ok := p.Claims["scope"].(string); ok {
+		for _, s := range strings.Fields(v) {
+			if s == scope {
+				return true
+			}
+		}
+	}
+	if list, ok := p.Claims["scp"].([]interface{}); ok {
+		for _, s := range list {
+			if s == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// firehoseFilter 订阅方的过滤与采样条件，各条件之间是「且」，同一条件的多个值是「或」
+type firehoseFilter struct {
+	IncludeInbound bool     `json:"include_inbound"`
+	Sample         float64  `json:"sample"`        // 采样率 (0,1]，0 或不填表示不采样
+	Events         []string `json:"events"`        // 事件名模式，支持 * 通配（path.Match 语法）
+	Namespaces     []string `json:"namespaces"`    // 频道命名空间，即频道名第一个 ':' 之前的部分
+	UserPrefixes   []string `json:"user_prefixes"` // 用户 ID 前缀
+}
+
+// firehoseFilterFromQuery ?sample=0.1&events=a,b*&namespaces=chat&user_prefixes=vip_&include_inbound=1
+func firehoseFilterFromQuery(q url.Values) (*firehoseFilter, error) {
+	f := &firehoseFilter{IncludeInbound: q.Get("include_inbound") == "1"}
+	if s := q.Get("sample"); s != "" {
+		rate, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		f.Sample = rate
+	}
+	split := func(key string) []string {
+		if v := q.Get(key); v != "" {
+			return strings.Split(v, ",")
+		}
+		return nil
+	}
+	f.Events = split("events")
+	f.Namespaces = split("namespaces")
+	f.UserPrefixes = split("user_prefixes")
+	return f, f.validate()
+}
+
+func (f *firehoseFilter) validate() error {
+	if f.Sample < 0 || f.Sample > 1 {
+		return errors.New("sample 必须在 0 到 1 之间")
+	}
+	for _, p := range f.Events {
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *firehoseFilter) match(ev *trafficEvent) bool {
+	if !f.IncludeInbound && ev.Direction != trafficOutbound {
+		return false
+	}
+	if len(f.Events) > 0 && !matchAnyPattern(f.Events, ev.Event) {
+		return false
+	}
+	if len(f.Namespaces) > 0 {
+		ns, _, _ := strings.Cut(ev.Channel, ":")
+		if ev.Channel == "" || !slices.Contains(f.Namespaces, ns) {
+			return false
+		}
+	}
+	if len(f.UserPrefixes) > 0 && !hasAnyPrefix(ev.UserID, f.UserPrefixes) {
+		return false
+	}
+	// 采样放在最后，采样率是针对过滤之后的流量
+	if f.Sample > 0 && f.Sample < 1 && rand.Float64() >= f.Sample {
+		return false
+	}
+	return true
+}
+
+func matchAnyPattern(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	if s == "" {
+		return false
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func firehoseHandler(w http.ResponseWriter, r *http.Request) {
+	if p := principalFromRequest(r); !principalHasScope(p, firehoseScope) {
+		log.Printf("❌ firehose 缺少 scope %s: %s\n", firehoseScope, p.Subject)
+		writeProblem(w, r, http.StatusForbidden, problemForbidden, "missing scope "+firehoseScope)
+		return
+	}
+
+	// 默认只看下发；?include_inbound=1 时连客户端上行一起看
+	initial, err := firehoseFilterFromQuery(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, problemValidation, err.Error())
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("firehose upgrade error:", err)
+		return
+	}
+	defer conn.Close()
+
+	var filter atomic.Pointer[firehoseFilter]
+	filter.Store(initial)
+	sub := subscribeTraffic("firehose", func(ev *trafficEvent) bool {
+		return filter.Load().match(ev)
+	})
+	defer unsubscribeTraffic(sub)
+	log.Printf("🔭 firehose 订阅开始 from=%s filter=%+v\n", r.RemoteAddr, *initial)
+
+	// 读循环：对端关闭，或者发来新的过滤条件
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			_, raw, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var f firehoseFilter
+			if err := json.Unmarshal(raw, &f); err != nil || f.validate() != nil {
+				log.Println("⚠️ firehose 过滤条件无效:", string(raw))
+				continue
+			}
+			filter.Store(&f)
+			log.Printf("🔭 firehose 过滤条件已更新 filter=%+v\n", f)
+		}
+	}()
+
+	ticker := time.NewTicker(firehoseDropReportGap)
+	defer ticker.Stop()
+	var reported uint64
+	for {
+		select {
+		case <-done:
+			log.Printf("🔭 firehose 订阅结束，期间丢弃 %d 条\n", sub.dropped.Load())
+			return
+		case ev := <-sub.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ticker.C:
+			// 消费跟不上时告诉订阅方丢了多少
+			if d := sub.dropped.Load(); d != reported {
+				_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := conn.WriteJSON(WSMessage{Event: "firehose_dropped", Data: map[string]uint64{"dropped": d - reported}}); err != nil {
+					return
+				}
+				reported = d
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// ===== 功能开关（feature flags） =====
+//
+// 一个小型 key/value 存储：初始值来自配置 flags，可通过管理接口修改。
+// 当前值随 hello 下发，变更时向所有连接广播 flags_updated 事件。
+// 管理接口的修改只保存在内存中，重启后回到配置文件里的值。
+
+var (
+	flags   = make(map[string]interface{})
+	flagsMu sync.RWMutex
+)
+
+// initFlags 用配置中的初始值填充开关表
+func initFlags(initial map[string]interface{}) {
+	flagsMu.Lock()
+	defer flagsMu.Unlock()
+	for k, v := range initial {
+		flags[k] = v
+	}
+}
+
+// currentFlags 返回当前开关的拷贝
+func currentFlags() map[string]interface{} {
+	flagsMu.RLock()
+	defer flagsMu.RUnlock()
+	out := make(map[string]interface{}, len(flags))
+	for k, v := range flags {
+		out[k] = v
+	}
+	return out
+}
+
+// updateFlags 合并更新开关，值为 null 表示删除；返回实际发生变化的 key
+func updateFlags(changes map[string]interface{}) map[string]interface{} {
+	flagsMu.Lock()
+	defer flagsMu.Unlock()
+
+	changed := make(map[string]interface{})
+	for k, v := range changes {
+		old, exists := flags[k]
+		if v == nil {
+			if exists {
+				delete(flags, k)
+				changed[k] = nil
+			}
+			continue
+		}
+		if exists && toJSON(old) == toJSON(v) {
+			continue
+		}
+		flags[k] = v
+		changed[k] = v
+	}
+	return changed
+}
+
+// adminFlagsHandler GET 查询 / PUT 合并更新功能开关
+func adminFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var changes map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+
+		if changed := updateFlags(changes); len(changed) > 0 {
+			log.Println("🚩 功能开关更新:", toJSON(changed))
+			broadcastToAll(WSMessage{
+				Event: "flags_updated",
+				Data: map[string]interface{}{
+					"changed": changed,
+					"flags":   currentFlags(),
+				},
+			})
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": currentFlags(),
+	})
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 具名连接组 =====
+//
+// 除了按用户推送，业务后端还可以建临时的具名组（“通话 X 的参与者”这类短期人群），组里放用户 ID 或连接 ID，
+// 推送时带 "group":"call-42" 发给组内所有在线连接，不用再靠 selector 之类的标签拼凑：
+//
+//	POST   /api/groups/{name}/members   {"user_ids":["u1","u2"],"connection_ids":["1700000000.3"],"ttl_seconds":3600}
+//	DELETE /api/groups/{name}/members?user_id=u1&connection_id=1700000000.3
+//	GET    /api/groups/{name}           成员列表和在线连接数
+//	GET    /api/groups                  所有组的概况
+//	DELETE /api/groups/{name}
+//
+// 第一次添加成员时自动建组，成员删光时自动删除；每次修改都会把过期时间顺延 ttl_seconds（默认 default_ttl_seconds）。
+// 用户成员对该用户当前和以后的所有连接生效；连接成员在连接断开时自动移出。
+// 组只保存在本节点内存中，重启后丢失，集群模式下各节点互不同步。
+
+// GroupsConfig 具名连接组配置
+type GroupsConfig struct {
+	Enabled           bool `json:"enabled"`
+	MaxGroups         int  `json:"max_groups"`          // 最多同时存在的组数，默认 10000
+	MaxMembers        int  `json:"max_members"`         // 每组成员（用户 + 连接）上限，默认 10000
+	DefaultTTLSeconds int  `json:"default_ttl_seconds"` // 组最后一次修改后多久过期，默认 86400，-1 表示不过期
+}
+
+const (
+	groupsDefaultMaxGroups  = 10000
+	groupsDefaultMaxMembers = 10000
+	groupsDefaultTTL        = 86400
+	groupsMaxNameLength     = 128
+	groupsSweepInterval     = time.Minute
+)
+
+// connGroup 一个具名组
+type connGroup struct {
+	users     map[string]struct{}
+	conns     map[string]struct{}
+	createdAt time.Time
+	updatedAt time.Time
+	pushedAt  time.Time // 最后一次推送，零值表示还没推送过（见 expiry.group_idle_seconds）
+	expiresAt time.Time // 零值表示不过期
+}
+
+// groupView 接口返回的组信息
+type groupView struct {
+	Name              string     `json:"name"`
+	UserIDs           []string   `json:"user_ids,omitempty"`
+	ConnectionIDs     []string   `json:"connection_ids,omitempty"`
+	Users             int        `json:"users"`
+	Connections       int        `json:"connections"`
+	OnlineConnections *int       `json:"online_connections,omitempty"` // 只在单个组的接口里返回
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	PushedAt          *time.Time `json:"pushed_at,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+}
+
+// groupMembersRequest POST /api/groups/{name}/members 的请求体
+type groupMembersRequest struct {
+	UserIDs       []string `json:"user_ids"`
+	ConnectionIDs []string `json:"connection_ids"`
+	TTLSeconds    int      `json:"ttl_seconds"` // 可选：覆盖 default_ttl_seconds，-1 表示不过期
+}
+
+var (
+	groupsMu   sync.Mutex
+	groups     = make(map[string]*connGroup)
+	groupConns = make(map[string]map[string]struct{}) // 连接 ID -> 所在的组，断开时据此清理
+
+	groupPushes atomic.Uint64
+)
+
+var (
+	errGroupTooMany    = errors.New("too many groups")
+	errGroupTooLarge   = errors.New("too many members")
+	errGroupNoMembers  = errors.New("no members")
+	errGroupBadTTL     = errors.New("bad ttl")
+	errGroupNotEnabled = errors.New("groups are not enabled")
+)
+
+func prepareGroups(cfg *GroupsConfig) {
+	if cfg.MaxGroups <= 0 {
+		cfg.MaxGroups = groupsDefaultMaxGroups
+	}
+	if cfg.MaxMembers <= 0 {
+		cfg.MaxMembers = groupsDefaultMaxMembers
+	}
+	if cfg.DefaultTTLSeconds == 0 {
+		cfg.DefaultTTLSeconds = groupsDefaultTTL
+	}
+}
+
+// validGroupName 组名：1-128 个 [A-Za-z0-9_.:-] 字符
+func validGroupName(name string) bool {
+	if name == "" || len(name) > groupsMaxNameLength {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '_', r == '-', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// liveGroupLocked 取未过期的组，过期的顺手删掉；调用方持有 groupsMu
+func liveGroupLocked(name string, now time.Time) *connGroup {
+	g, ok := groups[name]
+	if !ok {
+		return nil
+	}
+	if !g.expiresAt.IsZero() && now.After(g.expiresAt) {
+		deleteGroupLocked(name)
+		groupExpired(name, expiryReasonTTL)
+		return nil
+	}
+	return g
+}
+
+// deleteGroupLocked 删除组并清理连接索引
+func deleteGroupLocked(name string) {
+	g, ok := groups[name]
+	if !ok {
+		return
+	}
+	for id := range g.conns {
+		unindexGroupConnLocked(id, name)
+	}
+	delete(groups, name)
+}
+
+func unindexGroupConnLocked(connID, name string) {
+	if set, ok := groupConns[connID]; ok {
+		delete(set, name)
+		if len(set) == 0 {
+			delete(groupConns, connID)
+		}
+	}
+}
+
+// addGroupMembers 添加成员，组不存在时创建；ttl 为 0 时用 default_ttl_seconds
+func addGroupMembers(name string, userIDs, connIDs []string, ttl int) (groupView, error) {
+	cfg := GlobalConfig.Groups
+	switch {
+	case len(userIDs) == 0 && len(connIDs) == 0:
+		return groupView{}, errGroupNoMembers
+	case ttl < -1:
+		return groupView{}, errGroupBadTTL
+	case ttl == 0:
+		ttl = cfg.DefaultTTLSeconds
+	}
+
+	now := time.Now()
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+
+	g := liveGroupLocked(name, now)
+	if g == nil {
+		if len(groups) >= cfg.MaxGroups {
+			return groupView{}, errGroupTooMany
+		}
+		g = &connGroup{users: make(map[string]struct{}), conns: make(map[string]struct{}), createdAt: now}
+	}
+	added := 0
+	for _, id := range userIDs {
+		if _, ok := g.users[id]; !ok && id != "" {
+			added++
+		}
+	}
+	for _, id := range connIDs {
+		if _, ok := g.conns[id]; !ok && id != "" {
+			added++
+		}
+	}
+	if len(g.users)+len(g.conns)+added > cfg.MaxMembers {
+		return groupView{}, errGroupTooLarge
+	}
+
+	groups[name] = g
+	for _, id := range userIDs {
+		if id != "" {
+			g.users[id] = struct{}{}
+		}
+	}
+	for _, id := range connIDs {
+		if id == "" {
+			continue
+		}
+		g.conns[id] = struct{}{}
+		if groupConns[id] == nil {
+			groupConns[id] = make(map[string]struct{})
+		}
+		groupConns[id][name] = struct{}{}
+	}
+	g.updatedAt = now
+	g.expiresAt = time.Time{}
+	if ttl > 0 {
+		g.expiresAt = now.Add(time.Duration(ttl) * time.Second)
+	}
+	return g.viewLocked(name, true), nil
+}
+
+// removeGroupMembers 移除成员，组空了就删除；组不存在时返回 false
+func removeGroupMembers(name string, userIDs, connIDs []string) (groupView, bool) {
+	now := time.Now()
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+
+	g := liveGroupLocked(name, now)
+	if g == nil {
+		return groupView{}, false
+	}
+	for _, id := range userIDs {
+		delete(g.users, id)
+	}
+	for _, id := range connIDs {
+		if _, ok := g.conns[id]; ok {
+			delete(g.conns, id)
+			unindexGroupConnLocked(id, name)
+		}
+	}
+	g.updatedAt = now
+	view := g.viewLocked(name, true)
+	if len(g.users) == 0 && len(g.conns) == 0 {
+		delete(groups, name)
+	}
+	return view, true
+}
+
+// viewLocked 组信息；withMembers 为 false 时不列出成员
+func (g *connGroup) viewLocked(name string, withMembers bool) groupView {
+	v := groupView{
+		Name:        name,
+		Users:       len(g.users),
+		Connections: len(g.conns),
+		CreatedAt:   g.createdAt,
+		UpdatedAt:   g.updatedAt,
+	}
+	if !g.expiresAt.IsZero() {
+		exp := g.expiresAt
+		v.ExpiresAt = &exp
+	}
+	if !g.pushedAt.IsZero() {
+		pushed := g.pushedAt
+		v.PushedAt = &pushed
+	}
+	if withMembers {
+		v.UserIDs = sortedKeys(g.users)
+		v.ConnectionIDs = sortedKeys(g.conns)
+	}
+	return v
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// groupMatcher 组内连接的过滤器（成员快照），组不存在时返回 nil；extra 非 nil 时同时满足
+func groupMatcher(name string, extra func(*Client) bool) func(*Client) bool {
+	groupsMu.Lock()
+	g := liveGroupLocked(name, time.Now())
+	if g == nil {
+		groupsMu.Unlock()
+		return nil
+	}
+	users := make(map[string]struct{}, len(g.users))
+	for id := range g.users {
+		users[id] = struct{}{}
+	}
+	conns := make(map[string]struct{}, len(g.conns))
+	for id := range g.conns {
+		conns[id] = struct{}{}
+	}
+	groupsMu.Unlock()
+
+	return func(c *Client) bool {
+		_, byConn := conns[c.id]
+		_, byUser := users[c.userID]
+		if !byConn && (!byUser || c.userID == "") {
+			return false
+		}
+		return extra == nil || extra(c)
+	}
+}
+
+// groupExists 组是否存在（未过期）
+func groupExists(name string) bool {
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+	return liveGroupLocked(name, time.Now()) != nil
+}
+
+// emitToGroup 推送给组内所有在线连接，组不存在时返回 0
+func emitToGroup(name string, msg WSMessage, extra func(*Client) bool) int {
+	match := groupPushMatcher(name, extra)
+	if match == nil {
+		return 0
+	}
+	return broadcastMatching(msg, match)
+}
+
+// groupPushMatcher 一次组推送：计数、记录推送时间，返回组成员的匹配函数；组不存在时返回 nil
+func groupPushMatcher(name string, extra func(*Client) bool) func(*Client) bool {
+	groupPushes.Add(1)
+	match := groupMatcher(name, extra)
+	if match == nil {
+		log.Printf("🔍 组 %s 不存在或已过期，本次不推送\n", name)
+		return nil
+	}
+	groupsMu.Lock()
+	if g, ok := groups[name]; ok {
+		g.pushedAt = time.Now()
+	}
+	groupsMu.Unlock()
+	return match
+}
+
+// forgetGroupConn 连接断开时移出所有组
+func forgetGroupConn(c *Client) {
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+
+	for name := range groupConns[c.id] {
+		g, ok := groups[name]
+		if !ok {
+			continue
+		}
+		delete(g.conns, c.id)
+		if len(g.users) == 0 && len(g.conns) == 0 {
+			delete(groups, name)
+		}
+	}
+	delete(groupConns, c.id)
+}
+
+// forgetGroupUser 把用户从所有组中移除（数据擦除用），返回涉及的组数
+func forgetGroupUser(userID string) int {
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+
+	n := 0
+	for name, g := range groups {
+		if _, ok := g.users[userID]; !ok {
+			continue
+		}
+		n++
+		delete(g.users, userID)
+		if len(g.users) == 0 && len(g.conns) == 0 {
+			deleteGroupLocked(name)
+		}
+	}
+	return n
+}
+
+// groupsSweepLoop 定期删除过期的组
+func groupsSweepLoop() {
+	ticker := time.NewTicker(groupsSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		groupsMu.Lock()
+		for name := range groups {
+			liveGroupLocked(name, now)
+		}
+		groupsMu.Unlock()
+	}
+}
+
+// expireIdleGroups 删除 expiry.group_idle_seconds 内没有推送也没有成员变动的组
+func expireIdleGroups(now time.Time) {
+	idle := time.Duration(GlobalConfig.Expiry.GroupIdleSeconds) * time.Second
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+	for name := range groups {
+		g := liveGroupLocked(name, now) // ttl 已到的按 ttl 过期
+		if g == nil {
+			continue
+		}
+		last := g.updatedAt
+		if g.pushedAt.After(last) {
+			last = g.pushedAt
+		}
+		if now.Sub(last) >= idle {
+			deleteGroupLocked(name)
+			groupExpired(name, expiryReasonIdle)
+		}
+	}
+}
+
+// groupCounts 组数和成员总数，用于指标
+func groupCounts() (int, int) {
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+	members := 0
+	for _, g := range groups {
+		members += len(g.users) + len(g.conns)
+	}
+	return len(groups), members
+}
+
+// validatePushGroup 推送请求里的 group：可以再用 selector / namespace 过滤，不能和其它定向方式同时使用
+func validatePushGroup(body PushRequest) (string, error) {
+	switch {
+	case !GlobalConfig.Groups.Enabled:
+		return "group", errGroupNotEnabled
+	case !validGroupName(body.Group):
+		return "group", errors.New("group name must be 1-" + strconv.Itoa(groupsMaxNameLength) + " characters of [A-Za-z0-9_.:-]")
+	case body.Channel != "":
+		return "group", errors.New("group cannot be combined with channel")
+	case body.Token != nil && parseUserToID(body.Token) != "":
+		return "group", errors.New("group cannot be combined with token")
+	case body.DeviceID != "" || body.ClientID != "":
+		return "group", errors.New("group cannot be combined with device_id / client_id")
+	}
+	return "", nil
+}
+
+// ===== 接口 =====
+
+// groupName 取路径里的组名，不合法时已写好错误响应
+func groupName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.PathValue("name")
+	if !validGroupName(name) {
+		writeValidationProblem(w, r, "name", "group name must be 1-"+strconv.Itoa(groupsMaxNameLength)+" characters of [A-Za-z0-9_.:-]")
+		return "", false
+	}
+	return name, true
+}
+
+// groupAddMembersHandler POST /api/groups/{name}/members
+func groupAddMembersHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := groupName(w, r)
+	if !ok {
+		return
+	}
+	var req groupMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	view, err := addGroupMembers(name, req.UserIDs, req.ConnectionIDs, req.TTLSeconds)
+	switch {
+	case errors.Is(err, errGroupNoMembers):
+		writeValidationProblem(w, r, "user_ids", "user_ids or connection_ids is required")
+		return
+	case errors.Is(err, errGroupBadTTL):
+		writeValidationProblem(w, r, "ttl_seconds", "ttl_seconds must be -1, 0 or positive")
+		return
+	case errors.Is(err, errGroupTooMany):
+		writeProblem(w, r, http.StatusConflict, problemQuotaExceeded, "already "+strconv.Itoa(GlobalConfig.Groups.MaxGroups)+" groups")
+		return
+	case errors.Is(err, errGroupTooLarge):
+		writeProblem(w, r, http.StatusConflict, problemQuotaExceeded, "group would exceed "+strconv.Itoa(GlobalConfig.Groups.MaxMembers)+" members")
+		return
+	}
+	view.OnlineConnections = countGroupOnline(name)
+	log.Printf("👥 组 %s 添加成员 users=%d conns=%d，当前成员 users=%d conns=%d\n",
+		name, len(req.UserIDs), len(req.ConnectionIDs), view.Users, view.Connections)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": view,
+	})
+}
+
+// groupRemoveMembersHandler DELETE /api/groups/{name}/members?user_id=a&connection_id=b
+func groupRemoveMembersHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := groupName(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	if len(q["user_id"]) == 0 && len(q["connection_id"]) == 0 {
+		writeValidationProblem(w, r, "user_id", "user_id or connection_id is required")
+		return
+	}
+	view, ok := removeGroupMembers(name, q["user_id"], q["connection_id"])
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "group not found: "+name)
+		return
+	}
+	view.OnlineConnections = countGroupOnline(name)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": view,
+	})
+}
+
+// groupHandler GET / DELETE /api/groups/{name}
+func groupHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := groupName(w, r)
+	if !ok {
+		return
+	}
+	groupsMu.Lock()
+	g := liveGroupLocked(name, time.Now())
+	var view groupView
+	if g != nil {
+		view = g.viewLocked(name, true)
+		if r.Method == http.MethodDelete {
+			deleteGroupLocked(name)
+		}
+	}
+	groupsMu.Unlock()
+
+	if g == nil {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "group not found: "+name)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		log.Printf("👥 组 %s 已删除\n", name)
+	} else {
+		view.OnlineConnections = countGroupOnline(name)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": view,
+	})
+}
+
+// groupsHandler GET /api/groups：所有组的概况，不列成员
+func groupsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	groupsMu.Lock()
+	list := make([]groupView, 0, len(groups))
+	for name := range groups {
+		if g := liveGroupLocked(name, now); g != nil {
+			list = append(list, g.viewLocked(name, false))
+		}
+	}
+	groupsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{"groups": list},
+	})
+}
+
+// countGroupOnline 组内当前在线的连接数
+func countGroupOnline(name string) *int {
+	n := 0
+	if match := groupMatcher(name, nil); match != nil {
+		n = len(defaultHub.clientsMatching(match))
+	}
+	return &n
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// ===== gRPC 流量订阅接口 =====
+//
+// 后端服务通过 server-streaming RPC relay.v1.Relay/Subscribe 订阅 relay 流量（proto 见 proto/relay.proto），
+// 不需要轮询，也不需要自己跑一个 WebSocket 客户端。
+//
+// gRPC 只是 HTTP/2 上的一层分帧，这里直接基于 net/http 实现，protobuf 也是手写的几个字段的编解码，
+// 不引入 grpc-go。要求 HTTP/2：开启 server.h2c（内网明文）或 server.tls_cert。
+// 认证走 admin 认证链，gRPC metadata 里带 x-api-key 或 authorization: Bearer ... 即可。
+
+// GRPCConfig gRPC 接口配置
+type GRPCConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+const grpcSubscribePath = "/relay.v1.Relay/Subscribe"
+
+// gRPC 状态码
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcUnavailable     = 14
+)
+
+const grpcMaxRequestBytes = 64 << 10
+
+type grpcSubscribeRequest struct {
+	UserID  string
+	Channel string
+	Events  []string
+}
+
+func (req grpcSubscribeRequest) match(ev *trafficEvent) bool {
+	if req.UserID != "" && ev.UserID != req.UserID {
+		return false
+	}
+	if req.Channel != "" && ev.Channel != req.Channel {
+		return false
+	}
+	if len(req.Events) > 0 && !slices.Contains(req.Events, ev.Event) {
+		return false
+	}
+	return true
+}
+
+func registerGRPCRoutes(mux *http.ServeMux) {
+	cfg := GlobalConfig.Server
+	if !cfg.H2C && cfg.TLSCert == "" {
+		log.Println("⚠️ gRPC 需要 HTTP/2，请开启 server.h2c 或配置 server.tls_cert")
+	}
+	mux.Handle("POST "+grpcSubscribePath, checkAuth("admin", http.HandlerFunc(grpcSubscribeHandler)))
+	log.Printf("✅ gRPC 流量订阅已启用：%s\n", grpcSubscribePath)
+}
+
+// grpcSubscribeHandler 处理 Subscribe：读一条请求消息，然后持续写 TrafficEvent，直到客户端取消
+func grpcSubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	msg, err := readGRPCMessage(r.Body)
+	if err != nil {
+		writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+	req, err := decodeSubscribeRequest(msg)
+	if err != nil {
+		writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+
+	sub := subscribeTraffic("grpc", req.match)
+	defer unsubscribeTraffic(sub)
+
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	log.Printf("🔭 gRPC 订阅开始 user_id=%q channel=%q events=%v\n", logUserID(req.UserID), req.Channel, req.Events)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Printf("🔭 gRPC 订阅结束，期间丢弃 %d 条\n", sub.dropped.Load())
+			return
+		case ev := <-sub.C:
+			if _, err := w.Write(grpcFrame(encodeTrafficEvent(&ev))); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeGRPCStatus 写 gRPC 状态（还没写过响应头时相当于 Trailers-Only 响应）
+func writeGRPCStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Del("Trailer")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", msg)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ===== gRPC 分帧：1 字节压缩标记 + 4 字节大端长度 + 消息 =====
+
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, errors.New("missing request message")
+	}
+	if hdr[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > grpcMaxRequestBytes {
+		return nil, errors.New("request message too large")
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errors.New("truncated request message")
+	}
+	return msg, nil
+}
+
+func grpcFrame(msg []byte) []byte {
+	out := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(out[1:], uint32(len(msg)))
+	return append(out, msg...)
+}
+
+// ===== 手写 protobuf（只覆盖 relay.proto 用到的类型） =====
+
+const (
+	protoVarint = 0
+	protoBytes  = 2
+)
+
+func decodeSubscribeRequest(b []byte) (grpcSubscribeRequest, error) {
+	var req grpcSubscribeRequest
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return req, errors.New("malformed request")
+		}
+		b = b[n:]
+		field, wire := key>>3, key&7
+
+		switch wire {
+		case protoVarint:
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return req, errors.New("malformed request")
+			}
+			b = b[n:]
+		case protoBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return req, errors.New("malformed request")
+			}
+			v := string(b[n : n+int(l)])
+			b = b[n+int(l):]
+			switch field {
+			case 1:
+				req.UserID = v
+			case 2:
+				req.Channel = v
+			case 3:
+				req.Events = append(req.Events, v)
+			}
+		default:
+			return req, errors.New("unsupported wire type")
+		}
+	}
+	return req, nil
+}
+
+func encodeTrafficEvent(ev *trafficEvent) []byte {
+	var b []byte
+	b = protoAppendString(b, 1, ev.Direction)
+	b = protoAppendString(b, 2, ev.Event)
+	b = protoAppendString(b, 3, ev.Channel)
+	b = protoAppendString(b, 4, ev.UserID)
+	b = protoAppendString(b, 5, ev.ConnID)
+	b = protoAppendString(b, 6, ev.Protocol)
+	if ev.Data != nil {
+		data, _ := json.Marshal(ev.Data)
+		b = protoAppendString(b, 7, string(data))
+	}
+	b = protoAppendVarint(b, 8, uint64(ev.Ts))
+	b = protoAppendString(b, 9, ev.Target)
+	b = protoAppendVarint(b, 10, uint64(ev.Recipients))
+	b = protoAppendVarint(b, 11, uint64(ev.Delivered))
+	b = protoAppendVarint(b, 12, uint64(ev.DurationUs))
+	b = protoAppendVarint(b, 13, ev.Seq)
+	return b
+}
+
+// protoAppendVarint 写整数字段，0 按 proto3 规则省略
+func protoAppendVarint(b []byte, field, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, field<<3|protoVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+// protoAppendString 写 string / bytes 字段，空值按 proto3 规则省略
+func protoAppendString(b []byte, field uint64, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = binary.AppendUvarint(b, field<<3|protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
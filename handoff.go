@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 连接迁移（节点下线 / 再平衡） =====
+//
+// POST /api/admin/handoff 把本节点的原生 WebSocket 连接迁到另一个节点：
+//  1. 打包会话状态（用户 ID、订阅频道、设备描述、用户历史及序号）发给 peer 的 /api/cluster/handoff，
+//     peer 为每个会话生成一次性 ticket
+//  2. 给客户端下发 reconnect 事件，url 指向 peer 的 public_url 并带上 ?handoff=ticket
+//  3. grace_seconds 后以 1012 (Service Restart) 关闭
+//
+// 客户端带 ticket 连到 peer 后直接恢复会话，不需要重新 identify；
+// 同时带上 ?last_seq= 的话，会从迁移过来的历史里补发之后的消息。
+
+// handoffSession 迁移的会话状态
+type handoffSession struct {
+	UserID    string      `json:"user_id"`
+	VisitorID string      `json:"visitor_id,omitempty"`
+	Channels  []string    `json:"channels,omitempty"`
+	Device    *DeviceInfo `json:"device,omitempty"`
+	Seq       uint64      `json:"seq,omitempty"`         // 用户历史当前序号
+	JWT       string      `json:"jwt,omitempty"`         // 开启 client_jwt 时的 access token，新节点重新校验
+	AuthExpMs int64       `json:"auth_exp_ms,omitempty"` // 开启 auth_expiry 时的认证过期时间
+	History   []WSMessage `json:"history,omitempty"`     // 用户历史（未开启 history 时为空）
+}
+
+type handoffRequest struct {
+	From     string           `json:"from"`
+	Sessions []handoffSession `json:"sessions"`
+}
+
+type handoffResponse struct {
+	URL     string   `json:"url"`     // peer 的 public_url
+	Tickets []string `json:"tickets"` // 与 sessions 一一对应
+}
+
+const (
+	handoffTicketTTL      = 2 * time.Minute
+	handoffDefaultGrace   = 5
+	handoffPath           = "/api/cluster/handoff"
+	handoffTicketQueryKey = "handoff"
+)
+
+type handoffTicket struct {
+	session   handoffSession
+	expiresAt time.Time
+}
+
+var (
+	handoffTickets   = make(map[string]*handoffTicket)
+	handoffTicketsMu sync.Mutex
+)
+
+// adminHandoffHandler POST /api/admin/handoff：{"peer":"node-b","conn_ids":[...],"grace_seconds":5}
+// conn_ids 为空时迁移本节点所有已识别的原生连接
+func adminHandoffHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Peer         string   `json:"peer"`
+		ConnIDs      []string `json:"conn_ids"`
+		GraceSeconds int      `json:"grace_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	peerURL, err := clusterPeerURL(body.Peer)
+	if err != nil {
+		writeValidationProblem(w, r, "peer", err.Error())
+		return
+	}
+	if body.GraceSeconds <= 0 {
+		body.GraceSeconds = handoffDefaultGrace
+	}
+
+	clients := handoffCandidates(body.ConnIDs)
+	if len(clients) == 0 {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"code": 0,
+			"msg":  "ok",
+			"data": map[string]interface{}{"handed_off": 0},
+		})
+		return
+	}
+
+	req := handoffRequest{From: GlobalConfig.Cluster.NodeID}
+	for _, c := range clients {
+		req.Sessions = append(req.Sessions, exportSession(c))
+	}
+
+	var resp handoffResponse
+	if err := clusterPost(peerURL, handoffPath, req, &resp); err != nil {
+		log.Printf("❌ 连接迁移到 %s 失败: %v\n", body.Peer, err)
+		writeProblem(w, r, http.StatusBadGateway, problemUpstream, err.Error())
+		return
+	}
+	if len(resp.Tickets) != len(clients) {
+		writeProblem(w, r, http.StatusBadGateway, problemUpstream, "peer returned mismatched ticket count")
+		return
+	}
+
+	grace := time.Duration(body.GraceSeconds) * time.Second
+	for i, c := range clients {
+		handOff(c, resp.URL, resp.Tickets[i], grace)
+	}
+	log.Printf("🚚 已将 %d 个连接迁移到 %s\n", len(clients), body.Peer)
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{"handed_off": len(clients), "peer": body.Peer},
+	})
+}
+
+// handoffCandidates 可迁移的连接：原生 WebSocket、已注册用户组、不在回收中
+func handoffCandidates(connIDs []string) []*Client {
+	want := make(map[string]bool, len(connIDs))
+	for _, id := range connIDs {
+		want[id] = true
+	}
+
+	return defaultHub.clientsMatching(func(c *Client) bool {
+		if c.frame != nil || c.userID == "" || c.recycling.Load() {
+			return false
+		}
+		return len(want) == 0 || want[c.id]
+	})
+}
+
+// exportSession 打包连接的会话状态
+func exportSession(c *Client) handoffSession {
+	info := snapshotConnection(c)
+	s := handoffSession{
+		UserID:    info.UserID,
+		VisitorID: info.VisitorID,
+		Channels:  info.Channels,
+		Device:    info.Device,
+	}
+	if GlobalConfig.History.Size > 0 {
+		s.Seq, s.History = exportUserHistory(c.userID)
+	}
+	if GlobalConfig.ClientJWT.Enabled {
+		s.JWT = clientJWTToken(c)
+	}
+	if GlobalConfig.AuthExpiry.Enabled {
+		if exp := authExpiryOf(c); !exp.IsZero() {
+			s.AuthExpMs = exp.UnixMilli()
+		}
+	}
+	return s
+}
+
+// handOff 通知客户端去新节点，宽限期后关闭
+func handOff(c *Client, publicURL, ticket string, grace time.Duration) {
+	if !c.recycling.CompareAndSwap(false, true) {
+		return
+	}
+	target, err := url.Parse(publicURL)
+	if err != nil {
+		log.Printf("⚠️ peer public_url 无效 %q: %v\n", publicURL, err)
+		return
+	}
+	q := target.Query()
+	q.Set(handoffTicketQueryKey, ticket)
+	target.RawQuery = q.Encode()
+
+	_ = c.deliver(WSMessage{
+		Event: "reconnect",
+		Data: map[string]interface{}{
+			"reason":   "handoff",
+			"url":      target.String(),
+			"delay_ms": 0,
+		},
+	})
+	time.AfterFunc(grace, func() {
+		c.closeWithCode(websocket.CloseServiceRestart, "handed off to another node")
+	})
+}
+
+// clusterHandoffHandler POST /api/cluster/handoff：接收 peer 迁移过来的会话，返回 ticket
+func clusterHandoffHandler(w http.ResponseWriter, r *http.Request) {
+	var req handoffRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	if GlobalConfig.Cluster.PublicURL == "" {
+		writeProblem(w, r, http.StatusServiceUnavailable, problemUnavailable, "cluster.public_url is not configured")
+		return
+	}
+	if m := currentMaintenance(); m != nil {
+		writeMaintenance(w, r, m)
+		return
+	}
+
+	now := time.Now()
+	resp := handoffResponse{URL: GlobalConfig.Cluster.PublicURL}
+	handoffTicketsMu.Lock()
+	for t, ticket := range handoffTickets {
+		if now.After(ticket.expiresAt) {
+			delete(handoffTickets, t)
+		}
+	}
+	for _, s := range req.Sessions {
+		t := randomHex(16)
+		handoffTickets[t] = &handoffTicket{session: s, expiresAt: now.Add(handoffTicketTTL)}
+		resp.Tickets = append(resp.Tickets, t)
+	}
+	handoffTicketsMu.Unlock()
+
+	// 历史先导入，迁移期间客户端还没连上来的消息也能补发
+	if GlobalConfig.History.Size > 0 {
+		for _, s := range req.Sessions {
+			importUserHistory(s.UserID, s.Seq, s.History)
+		}
+	}
+	log.Printf("📦 收到节点 %s 迁移过来的 %d 个会话\n", req.From, len(req.Sessions))
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": resp,
+	})
+}
+
+// takeHandoffTicket 校验并消费 ticket
+func takeHandoffTicket(ticket string) (handoffSession, bool) {
+	handoffTicketsMu.Lock()
+	defer handoffTicketsMu.Unlock()
+
+	t, ok := handoffTickets[ticket]
+	delete(handoffTickets, ticket)
+	if !ok || time.Now().After(t.expiresAt) {
+		return handoffSession{}, false
+	}
+	return t.session, true
+}
+
+// restoreHandoff 原生连接带 ?handoff= 时恢复会话，返回是否成功
+func restoreHandoff(c *Client, r *http.Request) bool {
+	s, ok := takeHandoffTicket(r.URL.Query().Get(handoffTicketQueryKey))
+	if !ok {
+		_ = c.deliver(WSMessage{Event: "error", Data: map[string]interface{}{"code": "invalid_handoff"}})
+		return false
+	}
+	if !restoreSession(c, s, r) {
+		return false
+	}
+	log.Printf("🚚 连接 %s 已恢复迁移会话 user_id=%s，频道 %d 个\n", c.id, logUserID(s.UserID), len(s.Channels))
+	return true
+}
+
+// restoreSession 把会话状态恢复到新连接上（迁移 / 断线续接共用），带 ?last_seq= 时补发之后的历史；
+// 认证失败返回 false
+func restoreSession(c *Client, s handoffSession, r *http.Request) bool {
+	if s.Device != nil {
+		c.device = s.Device
+	}
+	if c.visitorID == "" {
+		c.visitorID = s.VisitorID
+	}
+	after := lastSeqParam(r)
+	if GlobalConfig.History.Size == 0 {
+		after = 0
+	}
+	// JWT 会话重新校验（同时恢复吊销检查，补发放在离线消息之前）；其他会话沿用原来的认证过期时间
+	if s.JWT != "" && GlobalConfig.ClientJWT.Enabled {
+		if _, err := identifyUserSince(c, s.JWT, after); err != nil {
+			sendInvalidToken(c, err)
+			return false
+		}
+		after = 0
+	} else {
+		registerUser(c, s.UserID)
+		if GlobalConfig.AuthExpiry.Enabled && s.AuthExpMs > 0 {
+			restoreAuthExpiry(c, time.UnixMilli(s.AuthExpMs))
+		}
+	}
+	for _, ch := range s.Channels {
+		subscribeChannel(c, ch)
+	}
+
+	if after > 0 {
+		_, _ = replayUserHistory(c, s.UserID, after)
+	}
+	return true
+}
+
+// dropUserHandoffTickets 作废某用户还没被领取的迁移票据，返回作废数量
+func dropUserHandoffTickets(userID string) int {
+	handoffTicketsMu.Lock()
+	defer handoffTicketsMu.Unlock()
+
+	n := 0
+	for id, t := range handoffTickets {
+		if t.session.UserID == userID {
+			delete(handoffTickets, id)
+			n++
+		}
+	}
+	return n
+}
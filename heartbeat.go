@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 心跳与按客户端类别的保活参数 =====
+//
+// 原生 WebSocket 客户端连接时用 ?client_class=mobile（或 X-Client-Class 请求头）声明自己的类别，
+// 服务端按 heartbeat.classes 里该类别的参数决定建议的 ping 间隔和超时，在 hello / welcome 的 heartbeat 字段里告诉客户端：
+//
+//	"heartbeat":{"class":"mobile","ping_interval_ms":120000,"timeout_ms":300000}
+//
+// 超时 > 0 时，连接在 timeout 内收不到任何帧（业务帧、{"type":"ping"}、WebSocket ping 控制帧）就被断开。
+// 没声明或声明了未配置的类别按默认参数（class=default）处理。
+// 这样手机端可以把 ping 间隔拉长省电，而不会被按桌面端的超时回收。
+
+// HeartbeatConfig 心跳配置
+type HeartbeatConfig struct {
+	PingIntervalSeconds int                       `json:"ping_interval_seconds"` // 建议客户端发 ping 的间隔，默认 25
+	TimeoutSeconds      int                       `json:"timeout_seconds"`       // 多久收不到任何帧就断开，0（默认）表示不断开
+	Classes             map[string]HeartbeatClass `json:"classes"`               // 按客户端类别覆盖，如 mobile / dashboard
+}
+
+// HeartbeatClass 一个客户端类别的心跳参数，为 0 的字段沿用默认值
+type HeartbeatClass struct {
+	PingIntervalSeconds int `json:"ping_interval_seconds"`
+	TimeoutSeconds      int `json:"timeout_seconds"`
+}
+
+const (
+	heartbeatDefaultPingInterval = 25
+	heartbeatDefaultClass        = "default"
+	clientClassQueryKey          = "client_class"
+	clientClassHeader            = "X-Client-Class"
+)
+
+// heartbeatPolicy 一个连接协商后的心跳参数，原样下发给客户端
+type heartbeatPolicy struct {
+	Class             string `json:"class"`
+	PingIntervalMs    int    `json:"ping_interval_ms"` // 0 表示客户端不需要发 ping
+	TimeoutMs         int    `json:"timeout_ms"`       // 0 表示服务端不因缺少 ping 断开
+	ServerKeepaliveMs int64  `json:"server_keepalive_ms,omitempty"`
+}
+
+func prepareHeartbeat(cfg *HeartbeatConfig) {
+	if cfg.PingIntervalSeconds <= 0 {
+		cfg.PingIntervalSeconds = heartbeatDefaultPingInterval
+	}
+	if cfg.TimeoutSeconds < 0 {
+		cfg.TimeoutSeconds = 0
+	}
+	for name, class := range cfg.Classes {
+		if class.PingIntervalSeconds <= 0 {
+			class.PingIntervalSeconds = cfg.PingIntervalSeconds
+		}
+		if class.TimeoutSeconds <= 0 {
+			class.TimeoutSeconds = cfg.TimeoutSeconds
+		}
+		if class.TimeoutSeconds > 0 && class.TimeoutSeconds <= class.PingIntervalSeconds {
+			log.Fatalf("❌ heartbeat.classes.%s 配置错误：timeout_seconds（%d）必须大于 ping_interval_seconds（%d）\n",
+				name, class.TimeoutSeconds, class.PingIntervalSeconds)
+		}
+		cfg.Classes[name] = class
+	}
+	if cfg.TimeoutSeconds > 0 && cfg.TimeoutSeconds <= cfg.PingIntervalSeconds {
+		log.Fatalf("❌ heartbeat 配置错误：timeout_seconds（%d）必须大于 ping_interval_seconds（%d）\n",
+			cfg.TimeoutSeconds, cfg.PingIntervalSeconds)
+	}
+}
+
+// clientClassFromRequest 连接声明的客户端类别
+func clientClassFromRequest(r *http.Request) string {
+	if v := r.URL.Query().Get(clientClassQueryKey); v != "" {
+		return v
+	}
+	return r.Header.Get(clientClassHeader)
+}
+
+// wsHeartbeat 原生 WebSocket 连接按类别协商心跳参数
+func wsHeartbeat(class string) heartbeatPolicy {
+	cfg := GlobalConfig.Heartbeat
+	if c, ok := cfg.Classes[class]; ok {
+		return heartbeatPolicy{Class: class, PingIntervalMs: c.PingIntervalSeconds * 1000, TimeoutMs: c.TimeoutSeconds * 1000}
+	}
+	return heartbeatPolicy{
+		Class:          heartbeatDefaultClass,
+		PingIntervalMs: cfg.PingIntervalSeconds * 1000,
+		TimeoutMs:      cfg.TimeoutSeconds * 1000,
+	}
+}
+
+// sseHeartbeat SSE 是单向的，客户端不发 ping，由服务端定时写注释行保活
+func sseHeartbeat() heartbeatPolicy {
+	return heartbeatPolicy{Class: heartbeatDefaultClass, ServerKeepaliveMs: sseKeepAlive.Milliseconds()}
+}
+
+// tcpHeartbeat TCP 传输按 read_timeout_seconds 断开，建议间隔不超过超时的一半
+func tcpHeartbeat() heartbeatPolicy {
+	timeout := GlobalConfig.TCP.ReadTimeoutSeconds * 1000
+	return heartbeatPolicy{
+		Class:          heartbeatDefaultClass,
+		PingIntervalMs: min(GlobalConfig.Heartbeat.PingIntervalSeconds*1000, timeout/2),
+		TimeoutMs:      timeout,
+	}
+}
+
+// armHeartbeat 超时 > 0 时设置读超时，WebSocket ping 控制帧同样续期；每读到一帧后调用 extendHeartbeat
+func armHeartbeat(conn *websocket.Conn, p heartbeatPolicy) {
+	if p.TimeoutMs <= 0 {
+		return
+	}
+	timeout := time.Duration(p.TimeoutMs) * time.Millisecond
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPingHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		// 与 gorilla 默认的 ping 处理一致：回 pong，连接已关闭或临时错误不算失败
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		var ne net.Error
+		if errors.Is(err, websocket.ErrCloseSent) || (errors.As(err, &ne) && ne.Timeout()) {
+			return nil
+		}
+		return err
+	})
+}
+
+// extendHeartbeat 收到一帧后续期读超时
+func extendHeartbeat(conn *websocket.Conn, p heartbeatPolicy) {
+	if p.TimeoutMs > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Duration(p.TimeoutMs) * time.Millisecond))
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// ===== 连接握手 hello =====
+//
+// 原生 WebSocket / SSE 连接建立后服务端先下发一条 hello 事件，
+// 告诉客户端本连接的 conn_id 以及服务端下发的各类初始状态（如功能开关、协商后的心跳参数、能力）。
+
+// helloData 构造 hello 事件的 data
+func helloData(c *Client) map[string]interface{} {
+	data := map[string]interface{}{
+		"conn_id":     c.id,
+		"server_time": time.Now().UnixMilli(),
+		"flags":       currentFlags(),
+	}
+	if len(knownCapabilities) > 0 {
+		data["capabilities"] = c.capabilityList()
+	}
+	if c.codec != nil {
+		data["codec"] = c.codec.Name()
+	}
+	if a := currentReconnect.Load(); a != nil {
+		data["reconnect"] = a
+	}
+	if c.heartbeat.Class != "" {
+		data["heartbeat"] = c.heartbeat
+	}
+	if GlobalConfig.Cluster.Enabled {
+		data["node_id"] = GlobalConfig.Cluster.NodeID
+	}
+	return data
+}
+
+// sendHello 下发 hello 事件
+func sendHello(c *Client) error {
+	if err := c.deliver(WSMessage{Event: "hello", Data: helloData(c)}); err != nil {
+		log.Println("⚠️ hello 发送失败:", err)
+		return err
+	}
+	return nil
+}
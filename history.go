@@ -0,0 +1,215 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ===== 每用户消息历史（断线补发） =====
+//
+// 每个用户一个定长环形缓冲，单用户消息按用户维度分配递增序号（WSMessage.Seq）。
+// 用户不在线时也会记录；长时间没有新消息的用户缓冲会被定期清掉，避免无限增长。
+//
+// 客户端记下收到的最后一个 seq，重连时带 ?last_seq=（或 identify 的 data.last_seq）就能补齐断线期间的消息。
+// 缓冲已经接不上（被挤掉、过期清理或重启过）时先发一条 history_gap，客户端据此自己全量同步，不会悄悄丢消息：
+//
+//	{"event":"history_gap","data":{"last_seq":120,"oldest_seq":131,"latest_seq":230}}
+
+// HistoryConfig 消息历史配置，Size 为 0 表示关闭
+type HistoryConfig struct {
+	Size       int `json:"size"`        // 每用户保留的最近消息条数
+	TTLSeconds int `json:"ttl_seconds"` // 用户多久没有新消息就丢弃其历史，默认 300
+}
+
+const (
+	historyDefaultTTLSeconds = 300
+
+	historyGapEvent = "history_gap"
+)
+
+type userHistory struct {
+	seq     uint64      // 最近一次分配的序号
+	buf     []WSMessage // 环形缓冲
+	next    int         // 下一次写入位置
+	updated time.Time
+}
+
+var (
+	historyMu sync.Mutex
+	histories = make(map[string]*userHistory)
+)
+
+// recordUserHistory 给消息分配序号并写入该用户的历史，返回带序号的消息
+func recordUserHistory(userID string, msg WSMessage) WSMessage {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	h, ok := histories[userID]
+	if !ok {
+		h = &userHistory{buf: make([]WSMessage, 0, GlobalConfig.History.Size)}
+		histories[userID] = h
+	}
+
+	h.seq++
+	msg.Seq = h.seq
+	if len(h.buf) < cap(h.buf) {
+		h.buf = append(h.buf, msg)
+	} else {
+		h.buf[h.next] = msg
+		h.next = (h.next + 1) % len(h.buf)
+	}
+	h.updated = time.Now()
+	return msg
+}
+
+// userHistorySince 按顺序返回序号大于 after 的历史消息
+func userHistorySince(userID string, after uint64) []WSMessage {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	h, ok := histories[userID]
+	if !ok {
+		return nil
+	}
+
+	var out []WSMessage
+	for i := 0; i < len(h.buf); i++ {
+		m := h.buf[(h.next+i)%len(h.buf)]
+		if m.Seq > after {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// lastSeqParam 重连时带的 ?last_seq=，没带或不合法时为 0
+func lastSeqParam(r *http.Request) uint64 {
+	after, _ := strconv.ParseUint(r.URL.Query().Get("last_seq"), 10, 64)
+	return after
+}
+
+// replayedSeqs 一次断线补发实际下发的序号区间（缓冲里的消息序号连续），没有补发时为零值
+type replayedSeqs struct{ first, last uint64 }
+
+// covers 序号为 seq 的消息是否已经随断线补发下发过
+func (r replayedSeqs) covers(seq uint64) bool {
+	return seq != 0 && r.first <= seq && seq <= r.last
+}
+
+// replayUserHistory 补发序号大于 after 的单用户消息，返回补发的序号区间；缓冲里最早的消息接不上 after 时先发 history_gap
+func replayUserHistory(c *Client, userID string, after uint64) (replayedSeqs, error) {
+	historyMu.Lock()
+	var oldest, latest uint64
+	h, ok := histories[userID]
+	if ok {
+		latest = h.seq
+		if len(h.buf) > 0 {
+			oldest = h.buf[h.next%len(h.buf)].Seq
+		}
+	}
+	historyMu.Unlock()
+
+	// 序号比当前还新说明重启或过期后重新计数了，缓冲里的全部补发
+	from := after
+	if after > latest {
+		from = 0
+	}
+	missed := userHistorySince(userID, from)
+	// 没有历史、重新计数过，或者中间有消息已经被挤出缓冲
+	if !ok || after > latest || oldest > after+1 {
+		log.Printf("⚠️ 断线补发接不上 user_id=%s last_seq=%d 最早=%d 最新=%d\n", logUserID(userID), after, oldest, latest)
+		gap := map[string]uint64{"last_seq": after, "oldest_seq": oldest, "latest_seq": latest}
+		if err := c.deliver(WSMessage{Event: historyGapEvent, Data: gap}); err != nil {
+			return replayedSeqs{}, err
+		}
+	}
+	log.Printf("⏪ 断线补发 conn=%s user_id=%s last_seq=%d，补发 %d 条\n", c.id, logUserID(userID), after, len(missed))
+	var replayed replayedSeqs
+	for _, m := range missed {
+		if err := c.deliver(m); err != nil {
+			return replayed, err
+		}
+		if replayed.first == 0 {
+			replayed.first = m.Seq
+		}
+		replayed.last = m.Seq
+	}
+	return replayed, nil
+}
+
+// historySweepLoop 定期清理过期的用户历史
+func historySweepLoop() {
+	ttl := time.Duration(GlobalConfig.History.TTLSeconds) * time.Second
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().Add(-ttl)
+		historyMu.Lock()
+		removed := 0
+		for userID, h := range histories {
+			if h.updated.Before(cutoff) {
+				delete(histories, userID)
+				removed++
+			}
+		}
+		remaining := len(histories)
+		historyMu.Unlock()
+
+		if removed > 0 {
+			log.Printf("🧹 清理过期用户历史 %d 个，剩余 %d 个\n", removed, remaining)
+		}
+	}
+}
+
+// exportUserHistory 导出用户历史（按序）和当前序号，用于连接迁移
+func exportUserHistory(userID string) (uint64, []WSMessage) {
+	historyMu.Lock()
+	h, ok := histories[userID]
+	var seq uint64
+	if ok {
+		seq = h.seq
+	}
+	historyMu.Unlock()
+
+	if !ok {
+		return 0, nil
+	}
+	return seq, userHistorySince(userID, 0)
+}
+
+// importUserHistory 导入迁移过来的用户历史；本地已有更新的序号时保持不变
+func importUserHistory(userID string, seq uint64, msgs []WSMessage) {
+	if userID == "" || seq == 0 {
+		return
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	if h, ok := histories[userID]; ok && h.seq >= seq {
+		return
+	}
+	h := &userHistory{buf: make([]WSMessage, 0, GlobalConfig.History.Size), seq: seq, updated: time.Now()}
+	if len(msgs) > cap(h.buf) {
+		msgs = msgs[len(msgs)-cap(h.buf):]
+	}
+	h.buf = append(h.buf, msgs...)
+	histories[userID] = h
+}
+
+// deleteUserHistory 删除用户的全部历史，返回删除的消息条数
+func deleteUserHistory(userID string) int {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	h, ok := histories[userID]
+	if !ok {
+		return 0
+	}
+	delete(histories, userID)
+	return len(h.buf)
+}
@@ -0,0 +1,851 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== Hub：连接注册表 + 分发 =====
+//
+// Hub 持有一组连接的注册表（全部连接 / 用户分组 / 频道分组）以及推送到这些连接的逻辑，
+// 依赖（配置、日志、时钟、历史、指标）通过 functional options 注入，便于在同一进程里
+// 跑多个互不影响的 Hub，或者在测试里换成固定时钟和内存实现。
+// 进程内默认使用 defaultHub，下面的同名包级函数都转发给它，原有调用方式不变。
+// Hub 的方法（包括下发时的能力降级、日志里的 user_id 脱敏）只读 WithConfig 注入的配置，不读 GlobalConfig。
+// 签名、设备登记、JWT 会话、回执、流量旁路等功能模块仍是进程级的，Hub 只通过 HubHooks 调用它们：
+// defaultHub 接到这些模块上，NewHub 不指定 WithHooks 时全部为空操作，不会碰到进程级状态。
+// GlobalConfig 本身没有去掉：HTTP 接口、兼容协议适配器和上述功能模块仍然直接读它，defaultHub 也注入的是它。
+
+// HistoryStore 用户消息历史，Record 返回带上序号后的消息
+type HistoryStore interface {
+	Record(userID string, msg WSMessage) WSMessage
+}
+
+// ChannelMetrics 频道推送指标
+type ChannelMetrics interface {
+	ChannelMessage(channel string, delivered int)
+}
+
+// HubHooks 连接变化和投递过程中要通知进程级功能模块的地方
+type HubHooks interface {
+	// 下发前签名
+	SignMessage(msg WSMessage) WSMessage
+
+	// 连接加入用户组（持有 usersMu）
+	UserJoined(c *Client, userID string, conns int)
+
+	// 连接离开用户组（持有 usersMu）
+	UserLeft(c *Client, userID string, conns int)
+
+	// registerUser 完成，已释放锁
+	UserRegistered(c *Client, userID string)
+
+	// 连接从注册表移除
+	ClientRemoved(c *Client)
+
+	// 连接订阅频道（持有 channelsMu）
+	ChannelSubscribed(channel string, created bool)
+
+	// 频道推送，投递之前
+	ChannelPublished(channel string, msg WSMessage)
+
+	// 用户不在线，消息入离线队列
+	QueueOffline(userID string, msg WSMessage, now time.Time)
+
+	// 一个连接的投递结果
+	Delivered(msg WSMessage, c *Client, err error)
+
+	// 一次推送结束
+	Outbound(target, targetID string, msg WSMessage, recipients, delivered int, start time.Time)
+}
+
+// Hub 一组连接及其分发逻辑
+type Hub struct {
+	cfg     *Config
+	logger  *log.Logger
+	now     func() time.Time
+	history HistoryStore
+	metrics ChannelMetrics
+	hooks   HubHooks
+
+	initialData InitialDataProvider // identify 后下发的初始数据，为 nil 时不下发（见 initialdata.go）
+	occupancy   ChannelOccupancy    // 频道有人 / 没人订阅的变化，为 nil 时不通知（见 occupancy.go）
+
+	allMu sync.RWMutex
+	all   map[*Client]struct{}
+
+	usersMu sync.RWMutex
+	users   map[string]map[*Client]struct{}
+	// userSets 回收清空的用户组集合，断线重连时同一用户组反复删除 / 重建不必每次重新分配
+	userSets sync.Pool
+
+	userRegisters   atomic.Uint64 // 连接加入用户组的次数
+	userUnregisters atomic.Uint64 // 连接离开用户组的次数（含断开）
+
+	channelsMu sync.RWMutex // 同时保护各连接的 Client.channels 和 patterns
+	channels   map[string]map[*Client]struct{}
+	patterns   topicTrie // channels 中的通配频道（见 topics.go）
+}
+
+// HubOption 构造 Hub 时的可选项
+type HubOption func(*Hub)
+
+// WithConfig 指定配置，默认是一份空配置（所有可选功能关闭）
+func WithConfig(cfg *Config) HubOption {
+	return func(h *Hub) { h.cfg = cfg }
+}
+
+// WithLogger 指定日志输出，默认使用标准库的全局 logger
+func WithLogger(l *log.Logger) HubOption {
+	return func(h *Hub) { h.logger = l }
+}
+
+// WithClock 指定时钟，默认 time.Now
+func WithClock(now func() time.Time) HubOption {
+	return func(h *Hub) { h.now = now }
+}
+
+// WithHistory 指定用户消息历史，为 nil 时不记录
+func WithHistory(s HistoryStore) HubOption {
+	return func(h *Hub) { h.history = s }
+}
+
+// WithMetrics 指定频道指标，为 nil 时不统计
+func WithMetrics(m ChannelMetrics) HubOption {
+	return func(h *Hub) { h.metrics = m }
+}
+
+// WithHooks 指定进程级功能模块的挂钩，默认全部为空操作
+func WithHooks(hooks HubHooks) HubOption {
+	return func(h *Hub) { h.hooks = hooks }
+}
+
+// WithInitialData 指定连接 identify 后的初始数据来源，为 nil 时不下发
+func WithInitialData(p InitialDataProvider) HubOption {
+	return func(h *Hub) { h.initialData = p }
+}
+
+// WithOccupancy 指定频道占用变化的观察者，为 nil 时不通知
+func WithOccupancy(o ChannelOccupancy) HubOption {
+	return func(h *Hub) { h.occupancy = o }
+}
+
+func NewHub(opts ...HubOption) *Hub {
+	h := &Hub{
+		cfg:      &Config{},
+		logger:   log.Default(),
+		now:      time.Now,
+		hooks:    nopHooks{},
+		all:      make(map[*Client]struct{}),
+		users:    make(map[string]map[*Client]struct{}),
+		channels: make(map[string]map[*Client]struct{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// 进程级的历史 / 指标实现，适配成 Hub 的依赖接口
+type (
+	globalHistory struct{}
+	globalMetrics struct{}
+)
+
+func (globalHistory) Record(userID string, msg WSMessage) WSMessage {
+	return recordUserHistory(userID, msg)
+}
+
+func (globalMetrics) ChannelMessage(channel string, delivered int) {
+	recordChannelMessage(channel, delivered)
+}
+
+// nopHooks 不接任何进程级模块，独立构造的 Hub 默认使用
+type nopHooks struct{}
+
+func (nopHooks) SignMessage(msg WSMessage) WSMessage                     { return msg }
+func (nopHooks) UserJoined(*Client, string, int)                         {}
+func (nopHooks) UserLeft(*Client, string, int)                           {}
+func (nopHooks) UserRegistered(*Client, string)                          {}
+func (nopHooks) ClientRemoved(*Client)                                   {}
+func (nopHooks) ChannelSubscribed(string, bool)                          {}
+func (nopHooks) ChannelPublished(string, WSMessage)                      {}
+func (nopHooks) QueueOffline(string, WSMessage, time.Time)               {}
+func (nopHooks) Delivered(WSMessage, *Client, error)                     {}
+func (nopHooks) Outbound(string, string, WSMessage, int, int, time.Time) {}
+
+// globalHooks 把 Hub 接到进程级的功能模块上，各模块是否开启看全局配置
+type globalHooks struct{}
+
+func (globalHooks) SignMessage(msg WSMessage) WSMessage { return signMessage(msg) }
+
+func (globalHooks) UserJoined(c *Client, userID string, conns int) {
+	if GlobalConfig.PresenceStream.Enabled {
+		publishPresence(userID, conns, c, true)
+	}
+}
+
+func (globalHooks) UserLeft(c *Client, userID string, conns int) {
+	if GlobalConfig.PresenceStream.Enabled {
+		publishPresence(userID, conns, c, false)
+	}
+}
+
+func (globalHooks) UserRegistered(c *Client, userID string) {
+	if GlobalConfig.Devices.Enabled {
+		touchDevice(c, userID)
+	}
+}
+
+func (globalHooks) ClientRemoved(c *Client) {
+	if GlobalConfig.Devices.Enabled {
+		releaseDevice(c)
+	}
+	if GlobalConfig.ClientJWT.Enabled {
+		forgetClientJWT(c)
+	}
+	if GlobalConfig.AuthExpiry.Enabled {
+		forgetAuthExpiry(c)
+	}
+	if GlobalConfig.Groups.Enabled {
+		forgetGroupConn(c)
+	}
+	if GlobalConfig.AckRetry.Enabled {
+		forgetAckConn(c)
+	}
+	forgetSubscriptionTTLs(c)
+}
+
+func (globalHooks) ChannelSubscribed(channel string, created bool) {
+	if GlobalConfig.Expiry.Enabled {
+		touchChannel(channel, created)
+	}
+}
+
+func (globalHooks) ChannelPublished(channel string, msg WSMessage) {
+	if GlobalConfig.Expiry.Enabled {
+		touchChannel(channel, false)
+	}
+	if GlobalConfig.ChannelHistory.Size > 0 && !msg.Ephemeral {
+		recordChannelHistory(channel, msg)
+	}
+}
+
+func (globalHooks) QueueOffline(userID string, msg WSMessage, now time.Time) {
+	enqueueOffline(userID, msg, now)
+}
+
+func (globalHooks) Delivered(msg WSMessage, c *Client, err error) {
+	recordReceipt(msg.ID, c, err)
+	recordAckDelivery(msg, c, err)
+}
+
+func (globalHooks) Outbound(target, targetID string, msg WSMessage, recipients, delivered int, start time.Time) {
+	publishOutbound(target, targetID, msg, recipients, delivered, start)
+}
+
+// defaultHub 进程默认的 Hub，使用全局配置
+var defaultHub = NewHub(
+	WithConfig(&GlobalConfig),
+	WithHooks(globalHooks{}),
+	WithHistory(globalHistory{}),
+	WithMetrics(globalMetrics{}),
+	WithInitialData(webhookInitialData{cfg: &GlobalConfig}),
+	WithOccupancy(webhookOccupancy{cfg: &GlobalConfig}),
+)
+
+// ===== 连接管理 =====
+
+func (h *Hub) addClient(c *Client) {
+	if c.id == "" {
+		c.id = newConnID()
+	}
+
+	h.allMu.Lock()
+	h.all[c] = struct{}{}
+	total := len(h.all)
+	h.allMu.Unlock()
+
+	h.logger.Printf("🔌 新连接接入，当前 allClients 数量: %d\n", total)
+}
+
+func (h *Hub) removeClient(c *Client) {
+	h.allMu.Lock()
+	delete(h.all, c)
+	h.allMu.Unlock()
+
+	if c.userID != "" {
+		h.usersMu.Lock()
+		h.leaveUserLocked(c)
+		h.usersMu.Unlock()
+	}
+
+	h.channelsMu.Lock()
+	for ch := range c.channels {
+		if set, ok := h.channels[ch]; ok {
+			delete(set, c)
+			if len(set) == 0 {
+				delete(h.channels, ch)
+				h.channelVacatedLocked(ch)
+			}
+		}
+	}
+	c.channels = nil
+	h.channelsMu.Unlock()
+
+	h.hooks.ClientRemoved(c)
+}
+
+func (h *Hub) registerUser(c *Client, userID string) {
+	if userID == "" {
+		return
+	}
+
+	h.usersMu.Lock()
+	// 先从旧 userID 解绑，解绑和加入在同一把锁内完成
+	if c.userID != "" && c.userID != userID {
+		h.leaveUserLocked(c)
+	}
+	c.userID = userID
+
+	set, ok := h.users[userID]
+	if !ok {
+		set, _ = h.userSets.Get().(map[*Client]struct{})
+		if set == nil {
+			set = make(map[*Client]struct{})
+		}
+		h.users[userID] = set
+	}
+	if _, joined := set[c]; !joined {
+		set[c] = struct{}{}
+		h.userRegisters.Add(1)
+		h.hooks.UserJoined(c, userID, len(set))
+	}
+	total := len(set)
+	h.usersMu.Unlock()
+
+	h.logger.Printf("🆔 用户组注册完成 user_id=%s, 该用户连接数=%d\n", h.logUserID(userID), total)
+	h.hooks.UserRegistered(c, userID)
+}
+
+// unregisterUser 把连接从当前用户组移除，连接本身保留（变为匿名）
+func (h *Hub) unregisterUser(c *Client) {
+	if c.userID == "" {
+		return
+	}
+
+	userID := c.userID
+	h.usersMu.Lock()
+	h.leaveUserLocked(c)
+	c.userID = "" // 和移出用户组在同一把锁内，一致性巡检不会看到中间状态
+	h.usersMu.Unlock()
+
+	h.logger.Printf("🆔 连接 %s 已退出用户组 user_id=%s\n", c.id, h.logUserID(userID))
+}
+
+// leaveUserLocked 把连接从 c.userID 的用户组移除，组空时回收集合；调用方持有 usersMu 写锁
+func (h *Hub) leaveUserLocked(c *Client) {
+	set, ok := h.users[c.userID]
+	if !ok {
+		return
+	}
+	if _, in := set[c]; !in {
+		return
+	}
+	delete(set, c)
+	h.userUnregisters.Add(1)
+	h.hooks.UserLeft(c, c.userID, len(set))
+	if len(set) == 0 {
+		delete(h.users, c.userID)
+		h.userSets.Put(set)
+	}
+}
+
+// subscribeChannel 把连接加入频道，返回加入后频道内的连接数
+func (h *Hub) subscribeChannel(c *Client, channel string) int {
+	h.channelsMu.Lock()
+	defer h.channelsMu.Unlock()
+
+	if c.channels == nil {
+		c.channels = make(map[string]struct{})
+	}
+	c.channels[channel] = struct{}{}
+
+	set, ok := h.channels[channel]
+	if !ok {
+		set = make(map[*Client]struct{})
+		h.channels[channel] = set
+		h.channelOccupiedLocked(channel)
+	}
+	set[c] = struct{}{}
+	h.hooks.ChannelSubscribed(channel, !ok)
+	return len(set)
+}
+
+// unsubscribeChannel 把连接移出频道，频道空了就删除
+func (h *Hub) unsubscribeChannel(c *Client, channel string) {
+	h.channelsMu.Lock()
+	defer h.channelsMu.Unlock()
+
+	delete(c.channels, channel)
+	if set, ok := h.channels[channel]; ok {
+		delete(set, c)
+		if len(set) == 0 {
+			delete(h.channels, channel)
+			h.channelVacatedLocked(channel)
+		}
+	}
+}
+
+// channelOccupiedLocked / channelVacatedLocked 频道建立 / 删除时维护通配索引并通知观察者；调用方持有 channelsMu 写锁
+func (h *Hub) channelOccupiedLocked(channel string) {
+	if isChannelPattern(channel) {
+		h.patterns.insert(channel)
+	}
+	if h.occupancy != nil {
+		h.occupancy.ChannelOccupied(channel)
+	}
+}
+
+func (h *Hub) channelVacatedLocked(channel string) {
+	if isChannelPattern(channel) {
+		h.patterns.remove(channel)
+	}
+	if h.occupancy != nil {
+		h.occupancy.ChannelVacated(channel)
+	}
+}
+
+// isSubscribed 连接是否已订阅某频道
+func (h *Hub) isSubscribed(c *Client, channel string) bool {
+	h.channelsMu.RLock()
+	defer h.channelsMu.RUnlock()
+	_, ok := c.channels[channel]
+	return ok
+}
+
+// broadcastToAll 广播给所有连接，返回成功投递的连接数
+func (h *Hub) broadcastToAll(dataObj WSMessage) int {
+	return h.broadcastMatching(dataObj, nil)
+}
+
+// broadcastMatching 广播给满足 match 的连接，match 为 nil 表示全部，返回成功投递的连接数
+func (h *Hub) broadcastMatching(dataObj WSMessage, match func(*Client) bool) int {
+	dataObj = h.hooks.SignMessage(dataObj)
+
+	start, sent := h.now(), 0
+	var clients []*Client
+	defer func() { h.hooks.Outbound("broadcast", "", dataObj, len(clients), sent, start) }()
+
+	// 复制一份当前连接快照，避免长时间持有锁
+	h.allMu.RLock()
+	if len(h.all) == 0 {
+		h.allMu.RUnlock()
+		h.logger.Println("📊 广播请求但当前无在线连接，跳过发送")
+		return 0
+	}
+	clients = make([]*Client, 0, len(h.all))
+	for c := range h.all {
+		if match == nil || match(c) {
+			clients = append(clients, c)
+		}
+	}
+	h.allMu.RUnlock()
+
+	out := h.newOutbound(dataObj)
+	for _, c := range clients {
+		err := c.deliverPush(out)
+		if errors.Is(err, errEphemeralDropped) {
+			continue
+		}
+		h.hooks.Delivered(dataObj, c, err)
+		if err != nil {
+			h.logger.Println("🧹 广播时发送失败，清理连接:", err)
+			c.conn.Close()
+			h.removeClient(c)
+			continue
+		}
+		sent++
+	}
+
+	h.usersMu.RLock()
+	userCount := len(h.users)
+	h.usersMu.RUnlock()
+	h.logger.Printf("📊 广播完成：当前 allClients=%d, userClients 用户数=%d\n", len(clients), userCount)
+	return sent
+}
+
+// emitToUser 推送给用户的所有连接，返回成功投递的连接数
+func (h *Hub) emitToUser(userID string, dataObj WSMessage) int {
+	// 先签名再进历史，补发时带的是原始签名
+	dataObj = h.hooks.SignMessage(dataObj)
+
+	// 开启 history 时先记录（用户不在线也记录），便于重连后补发
+	if h.history != nil && h.cfg.History.Size > 0 {
+		dataObj = h.history.Record(userID, dataObj)
+	}
+
+	// 开启 offline_queue 时用户不在线先入队，下次 identify 时补发
+	if h.cfg.OfflineQueue.Enabled && !dataObj.Ephemeral && h.queueIfOffline(userID, dataObj) {
+		h.logger.Printf("📭 user_id=%s 不在线，消息已放入离线队列\n", h.logUserID(userID))
+		return 0
+	}
+
+	return h.emitToUserConns(userID, dataObj, nil)
+}
+
+// emitToUserConns 推送给用户的部分连接，match 为 nil 表示全部连接
+// （按设备 / 连接定向的消息只对特定连接有意义，不进用户历史）
+func (h *Hub) emitToUserConns(userID string, dataObj WSMessage, match func(*Client) bool) int {
+	dataObj = h.hooks.SignMessage(dataObj)
+
+	start, sent := h.now(), 0
+	var clients []*Client
+	defer func() { h.hooks.Outbound("user", userID, dataObj, len(clients), sent, start) }()
+
+	h.usersMu.RLock()
+	set, ok := h.users[userID]
+	if !ok || len(set) == 0 {
+		h.usersMu.RUnlock()
+		h.logger.Printf("🔍 未找到在线 user_id=%s，本次不推送\n", h.logUserID(userID))
+		return 0
+	}
+	clients = make([]*Client, 0, len(set))
+	for c := range set {
+		if match == nil || match(c) {
+			clients = append(clients, c)
+		}
+	}
+	h.usersMu.RUnlock()
+
+	if len(clients) == 0 {
+		h.logger.Printf("🔍 user_id=%s 没有匹配的连接，本次不推送\n", h.logUserID(userID))
+		return 0
+	}
+
+	out := h.newOutbound(dataObj)
+	for _, c := range clients {
+		err := c.deliverPush(out)
+		if errors.Is(err, errEphemeralDropped) {
+			continue
+		}
+		h.hooks.Delivered(dataObj, c, err)
+		if err != nil {
+			h.logger.Printf("🧹 单用户推送时发送失败，清理 user_id=%s: %v\n", h.logUserID(userID), err)
+			c.conn.Close()
+			h.removeClient(c)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// channelMembers 返回频道内连接的快照
+func (h *Hub) channelMembers(channel string) []*Client {
+	h.channelsMu.RLock()
+	defer h.channelsMu.RUnlock()
+
+	set := h.channels[channel]
+	clients := make([]*Client, 0, len(set))
+	for c := range set {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// emitToChannel 推送给频道内所有连接，exceptID 非空时跳过该连接（Pusher 的 socket_id 排除）
+func (h *Hub) emitToChannel(channel string, dataObj WSMessage, exceptID string) int {
+	if exceptID == "" {
+		return h.emitToChannelMatching(channel, dataObj, nil)
+	}
+	return h.emitToChannelMatching(channel, dataObj, func(c *Client) bool { return c.id != exceptID })
+}
+
+// emitToChannelMatching 推送给频道内满足 match 的连接，match 为 nil 表示全部（推送请求的 exclude_tokens）
+func (h *Hub) emitToChannelMatching(channel string, dataObj WSMessage, match func(*Client) bool) int {
+	dataObj = h.hooks.SignMessage(dataObj)
+	h.hooks.ChannelPublished(channel, dataObj)
+
+	start, sent := h.now(), 0
+	var clients []*Client
+	defer func() {
+		if h.metrics != nil {
+			h.metrics.ChannelMessage(channel, sent)
+		}
+		h.hooks.Outbound("channel", channel, dataObj, len(clients), sent, start)
+	}()
+
+	h.channelsMu.RLock()
+	clients = h.channelSubscribersLocked(channel, match)
+	h.channelsMu.RUnlock()
+
+	if len(clients) == 0 {
+		h.logger.Printf("🔍 频道 %s 当前无订阅者，本次不推送\n", channel)
+		return 0
+	}
+
+	out := h.newOutbound(dataObj)
+	for _, c := range clients {
+		err := c.deliverPush(out)
+		if errors.Is(err, errEphemeralDropped) {
+			continue
+		}
+		h.hooks.Delivered(dataObj, c, err)
+		if err != nil {
+			h.logger.Printf("🧹 频道推送时发送失败，清理连接 channel=%s: %v\n", channel, err)
+			c.conn.Close()
+			h.removeClient(c)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// channelSubscribersLocked 频道的精确订阅者加上匹配的通配订阅者（同一连接只出现一次）中满足 match 的连接；
+// 调用方持有 channelsMu 读锁
+func (h *Hub) channelSubscribersLocked(channel string, match func(*Client) bool) []*Client {
+	set := h.channels[channel]
+	patterns := h.patterns.match(channel)
+	if len(patterns) > 0 && !nativeChannelAllowed(channel) {
+		patterns = nil
+	}
+
+	clients := make([]*Client, 0, len(set))
+	var seen map[*Client]struct{}
+	if len(patterns) > 0 {
+		seen = make(map[*Client]struct{}, len(set))
+	}
+	add := func(c *Client) {
+		if seen != nil {
+			if _, dup := seen[c]; dup {
+				return
+			}
+			seen[c] = struct{}{}
+		}
+		if match == nil || match(c) {
+			clients = append(clients, c)
+		}
+	}
+	for c := range set {
+		add(c)
+	}
+	for _, p := range patterns {
+		for c := range h.channels[p] {
+			add(c)
+		}
+	}
+	return clients
+}
+
+// ===== 快照 =====
+
+// clientsMatching 返回满足 match 的连接快照，match 为 nil 表示全部
+func (h *Hub) clientsMatching(match func(*Client) bool) []*Client {
+	h.allMu.RLock()
+	defer h.allMu.RUnlock()
+
+	clients := make([]*Client, 0, len(h.all))
+	for c := range h.all {
+		if match == nil || match(c) {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// userConns 返回某用户所有连接的快照
+func (h *Hub) userConns(userID string) []*Client {
+	h.usersMu.RLock()
+	defer h.usersMu.RUnlock()
+
+	set := h.users[userID]
+	clients := make([]*Client, 0, len(set))
+	for c := range set {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// channelsOf 返回连接已订阅的频道（无序）
+func (h *Hub) channelsOf(c *Client) []string {
+	h.channelsMu.RLock()
+	defer h.channelsMu.RUnlock()
+
+	out := make([]string, 0, len(c.channels))
+	for ch := range c.channels {
+		out = append(out, ch)
+	}
+	return out
+}
+
+// userGroupSizes 每个用户组当前的连接数
+func (h *Hub) userGroupSizes() map[string]int {
+	h.usersMu.RLock()
+	defer h.usersMu.RUnlock()
+
+	out := make(map[string]int, len(h.users))
+	for id, set := range h.users {
+		out[id] = len(set)
+	}
+	return out
+}
+
+// userGroupSummary 用户组规模概况
+type userGroupSummary struct {
+	Groups      int             `json:"groups"`
+	Connections int             `json:"connections"` // 已归入用户组的连接数
+	MaxSize     int             `json:"max_size"`
+	BySize      map[string]int  `json:"by_size"` // 按连接数分档的用户组数
+	Registers   uint64          `json:"registers_total"`
+	Unregisters uint64          `json:"unregisters_total"`
+	Largest     []userGroupInfo `json:"largest"` // 连接数最多的用户组
+}
+
+// userGroupSizeBuckets 用户组规模分档（上界，含），最后一档不设上界
+var userGroupSizeBuckets = []struct {
+	label string
+	max   int
+}{{"1", 1}, {"2-3", 3}, {"4-10", 10}, {"11-100", 100}, {"101+", 0}}
+
+// userGroupStats 用户组规模概况，largest 取连接数最多的前 top 个；
+// 同一个 token 被大量设备共用（常见于配置错误）时会在这里冒出来
+func (h *Hub) userGroupStats(top int) userGroupSummary {
+	s := userGroupSummary{
+		BySize:      make(map[string]int, len(userGroupSizeBuckets)),
+		Registers:   h.userRegisters.Load(),
+		Unregisters: h.userUnregisters.Load(),
+	}
+	for _, b := range userGroupSizeBuckets {
+		s.BySize[b.label] = 0
+	}
+	all := make([]userGroupInfo, 0)
+
+	h.usersMu.RLock()
+	s.Groups = len(h.users)
+	for id, set := range h.users {
+		n := len(set)
+		s.Connections += n
+		s.MaxSize = max(s.MaxSize, n)
+		for _, b := range userGroupSizeBuckets {
+			if b.max == 0 || n <= b.max {
+				s.BySize[b.label]++
+				break
+			}
+		}
+		if top > 0 && n > 1 {
+			all = append(all, userGroupInfo{UserID: id, Connections: n})
+		}
+	}
+	h.usersMu.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Connections != all[j].Connections {
+			return all[i].Connections > all[j].Connections
+		}
+		return all[i].UserID < all[j].UserID
+	})
+	s.Largest = all[:min(top, len(all))]
+	return s
+}
+
+// connectionCount 当前连接数
+func (h *Hub) connectionCount() int {
+	h.allMu.RLock()
+	defer h.allMu.RUnlock()
+	return len(h.all)
+}
+
+// channelSubscribers 每个频道当前的订阅连接数
+func (h *Hub) channelSubscribers() map[string]int {
+	h.channelsMu.RLock()
+	defer h.channelsMu.RUnlock()
+
+	out := make(map[string]int, len(h.channels))
+	for ch, set := range h.channels {
+		out[ch] = len(set)
+	}
+	return out
+}
+
+// hubState 注册表的一致快照：连接列表、用户组和频道订阅（后两者记录连接 ID）
+type hubState struct {
+	clients  []*Client
+	users    map[string][]string
+	channels map[string][]string
+}
+
+// state 同时持有三把读锁取快照，保证连接、用户组、频道三者互相对得上；
+// 加锁顺序 all → users → channels，其它地方不会嵌套持有这几把锁
+func (h *Hub) state() hubState {
+	h.allMu.RLock()
+	defer h.allMu.RUnlock()
+	h.usersMu.RLock()
+	defer h.usersMu.RUnlock()
+	h.channelsMu.RLock()
+	defer h.channelsMu.RUnlock()
+
+	st := hubState{
+		clients:  make([]*Client, 0, len(h.all)),
+		users:    make(map[string][]string, len(h.users)),
+		channels: make(map[string][]string, len(h.channels)),
+	}
+	for c := range h.all {
+		st.clients = append(st.clients, c)
+	}
+	for id, set := range h.users {
+		st.users[id] = connIDs(set)
+	}
+	for ch, set := range h.channels {
+		st.channels[ch] = connIDs(set)
+	}
+	return st
+}
+
+func connIDs(set map[*Client]struct{}) []string {
+	ids := make([]string, 0, len(set))
+	for c := range set {
+		ids = append(ids, c.id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// logUserID 按 Hub 的配置输出日志里的 user_id（见 redact.go）
+func (h *Hub) logUserID(userID string) string { return logUserIDFor(h.cfg, userID) }
+
+// ===== defaultHub 的包级入口 =====
+
+func addClient(c *Client)                             { defaultHub.addClient(c) }
+func removeClient(c *Client)                          { defaultHub.removeClient(c) }
+func sendInitialData(c *Client, userID string)        { defaultHub.sendInitialData(c, userID) }
+func registerUser(c *Client, userID string)           { defaultHub.registerUser(c, userID) }
+func unregisterUser(c *Client)                        { defaultHub.unregisterUser(c) }
+func subscribeChannel(c *Client, channel string) int  { return defaultHub.subscribeChannel(c, channel) }
+func unsubscribeChannel(c *Client, channel string)    { defaultHub.unsubscribeChannel(c, channel) }
+func isSubscribed(c *Client, channel string) bool     { return defaultHub.isSubscribed(c, channel) }
+func broadcastToAll(dataObj WSMessage) int            { return defaultHub.broadcastToAll(dataObj) }
+func emitToUser(userID string, dataObj WSMessage) int { return defaultHub.emitToUser(userID, dataObj) }
+func channelMembers(channel string) []*Client         { return defaultHub.channelMembers(channel) }
+func newOutbound(msg WSMessage) *outboundMessage      { return defaultHub.newOutbound(msg) }
+
+func broadcastMatching(dataObj WSMessage, match func(*Client) bool) int {
+	return defaultHub.broadcastMatching(dataObj, match)
+}
+
+func emitToUserConns(userID string, dataObj WSMessage, match func(*Client) bool) int {
+	return defaultHub.emitToUserConns(userID, dataObj, match)
+}
+
+func emitToChannel(channel string, dataObj WSMessage, exceptID string) int {
+	return defaultHub.emitToChannel(channel, dataObj, exceptID)
+}
+
+func emitToChannelMatching(channel string, dataObj WSMessage, match func(*Client) bool) int {
+	return defaultHub.emitToChannelMatching(channel, dataObj, match)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// recordingHooks 记下 Hub 调用的挂钩，其余为空操作
+type recordingHooks struct {
+	nopHooks
+	queued    []time.Time
+	delivered int
+}
+
+func (r *recordingHooks) QueueOffline(_ string, _ WSMessage, now time.Time) {
+	r.queued = append(r.queued, now)
+}
+
+func (r *recordingHooks) Delivered(WSMessage, *Client, error) { r.delivered++ }
+
+func newTestHub(opts ...HubOption) *Hub {
+	return NewHub(append([]HubOption{WithConfig(&Config{}), WithLogger(log.New(io.Discard, "", 0))}, opts...)...)
+}
+
+func TestHubRouting(t *testing.T) {
+	h := newTestHub()
+	_, a := newMemClient(h, "a")
+	_, b := newMemClient(h, "b")
+	_, broken := newMemClient(h, "b")
+	broken.failWith(errors.New("write failed"))
+
+	if sent := h.emitToUser("a", WSMessage{Event: "user"}); sent != 1 {
+		t.Fatalf("emitToUser sent = %d, want 1", sent)
+	}
+	if _, err := a.expectEvent("user", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.expectNothing(10 * time.Millisecond); err != nil {
+		t.Fatalf("用户 b 收到了发给 a 的消息: %v", err)
+	}
+
+	if sent := h.broadcastToAll(WSMessage{Event: "all"}); sent != 2 {
+		t.Fatalf("broadcastToAll sent = %d, want 2", sent)
+	}
+	for _, conn := range []*memConn{a, b} {
+		if _, err := conn.expectEvent("all", time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 发送失败的连接被清理，注册表保持一致
+	if !broken.isClosed() || h.connectionCount() != 2 {
+		t.Fatalf("发送失败的连接没有被清理（当前连接数 %d）", h.connectionCount())
+	}
+	if found := h.auditRegistry(); len(found) > 0 {
+		t.Fatalf("注册表不一致: %v", found)
+	}
+}
+
+func TestHubChannelWildcardRouting(t *testing.T) {
+	h := newTestHub()
+	exact, exactConn := newMemClient(h, "")
+	wild, wildConn := newMemClient(h, "")
+	_, otherConn := newMemClient(h, "")
+	h.subscribeChannel(exact, "orders.1")
+	h.subscribeChannel(wild, "orders.*")
+	h.subscribeChannel(wild, "orders.#") // 同一连接命中多个订阅也只收一次
+
+	if sent := h.emitToChannel("orders.1", WSMessage{Event: "paid", Channel: "orders.1"}, ""); sent != 2 {
+		t.Fatalf("emitToChannel sent = %d, want 2", sent)
+	}
+	if _, err := exactConn.expectEvent("paid", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := wildConn.expectEvent("paid", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Channel != "orders.1" {
+		t.Fatalf("通配订阅收到的 channel = %q, want 实际推送的 orders.1", msg.Channel)
+	}
+	if err := wildConn.expectNothing(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := otherConn.expectNothing(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	h.removeClient(wild)
+	if sent := h.emitToChannel("orders.2", WSMessage{Event: "paid", Channel: "orders.2"}, ""); sent != 0 {
+		t.Fatalf("断开后仍投递给通配订阅 sent = %d", sent)
+	}
+}
+
+func TestHubOfflineQueueUsesClock(t *testing.T) {
+	fixed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	hooks := &recordingHooks{}
+	h := newTestHub(WithConfig(&Config{OfflineQueue: OfflineQueueConfig{Enabled: true}}), WithClock(func() time.Time { return fixed }), WithHooks(hooks))
+
+	if sent := h.emitToUser("nobody", WSMessage{Event: "later"}); sent != 0 {
+		t.Fatalf("离线用户 sent = %d", sent)
+	}
+	if len(hooks.queued) != 1 || !hooks.queued[0].Equal(fixed) {
+		t.Fatalf("入队时间 = %v, want [%v]", hooks.queued, fixed)
+	}
+
+	// ephemeral 消息不入队
+	h.emitToUser("nobody", WSMessage{Event: "typing", Ephemeral: true})
+	if len(hooks.queued) != 1 {
+		t.Fatalf("ephemeral 消息不应入队")
+	}
+}
+
+func TestHubEphemeralDropWhenBusy(t *testing.T) {
+	hooks := &recordingHooks{}
+	h := newTestHub(WithHooks(hooks))
+	c, conn := newMemClient(h, "u1")
+
+	// 连接正忙（持有写锁）时瞬时消息直接丢弃：不算投递、不回调、也不清理连接
+	c.mu.Lock()
+	sent := h.emitToUser("u1", WSMessage{Event: "typing", Ephemeral: true})
+	c.mu.Unlock()
+	if sent != 0 || hooks.delivered != 0 || h.connectionCount() != 1 {
+		t.Fatalf("sent=%d delivered=%d conns=%d", sent, hooks.delivered, h.connectionCount())
+	}
+	if err := conn.expectNothing(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent := h.emitToUser("u1", WSMessage{Event: "typing", Ephemeral: true}); sent != 1 || hooks.delivered != 1 {
+		t.Fatalf("空闲连接 sent=%d delivered=%d", sent, hooks.delivered)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	useConfig(t, nil)
+	c, conn := newMemClient(defaultHub, "")
+	t.Cleanup(func() { defaultHub.removeClient(c) })
+
+	if !injectFrame(c, WSMessage{Event: "identify", Data: IdentifyData{Token: "identify-u1"}}) {
+		t.Fatal("identify 后连接被断开")
+	}
+	if c.userID != "identify-u1" {
+		t.Fatalf("userID = %q", c.userID)
+	}
+	emitToUser("identify-u1", WSMessage{Event: "hi"})
+	if _, err := conn.expectEvent("hi", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// 无效的上行帧回错误，连接保留
+	if !injectFrame(c, []byte(`{"type":"subscribe"}`)) {
+		t.Fatal("单个无效帧不应断开连接")
+	}
+	if _, err := conn.expectEvent("error", time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdentifyKickOlder(t *testing.T) {
+	useConfig(t, func(cfg *Config) { cfg.SingleSession.Policy = sessionPolicyKickOlder })
+	old, oldConn := newMemClient(defaultHub, "")
+	cur, curConn := newMemClient(defaultHub, "")
+	t.Cleanup(func() {
+		defaultHub.removeClient(old)
+		defaultHub.removeClient(cur)
+	})
+
+	injectFrame(old, WSMessage{Event: "identify", Data: IdentifyData{Token: "kick-u1"}})
+	injectFrame(cur, WSMessage{Event: "identify", Data: IdentifyData{Token: "kick-u1"}})
+
+	if _, err := oldConn.expectEvent("logged_in_elsewhere", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := oldConn.closeCode(); code != CloseSessionReplaced {
+		t.Fatalf("旧连接关闭码 = %d, want %d", code, CloseSessionReplaced)
+	}
+	if conns := defaultHub.userConns("kick-u1"); len(conns) != 1 || conns[0] != cur {
+		t.Fatalf("用户组里应只剩新连接，实际 %d 个", len(conns))
+	}
+	emitToUser("kick-u1", WSMessage{Event: "hi"})
+	if _, err := curConn.expectEvent("hi", time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdentifyFlushesOfflineQueue(t *testing.T) {
+	useConfig(t, func(cfg *Config) {
+		cfg.OfflineQueue.Enabled = true
+		prepareOfflineQueue(&cfg.OfflineQueue)
+	})
+	t.Cleanup(func() { forgetUserOffline("offline-u1") })
+
+	emitToUser("offline-u1", WSMessage{Event: "first"})
+	emitToUser("offline-u1", WSMessage{Event: "second"})
+
+	c, conn := newMemClient(defaultHub, "")
+	t.Cleanup(func() { defaultHub.removeClient(c) })
+	injectFrame(c, WSMessage{Event: "identify", Data: IdentifyData{Token: "offline-u1"}})
+
+	for _, event := range []string{"first", "second"} {
+		if _, err := conn.expectEvent(event, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.pushHold.Load() {
+		t.Fatal("补发结束后仍在攒推送")
+	}
+	if _, n := offlineTotals(); n != 0 {
+		t.Fatalf("补发后队列里还有 %d 条", n)
+	}
+}
+
+func TestHubTransformsUseHubConfig(t *testing.T) {
+	useConfig(t, nil) // 全局配置里没有 transforms，Hub 只看自己的配置
+	h := newTestHub(WithConfig(&Config{Transforms: []TransformRule{{Event: "order.*", UnlessCapability: "order_v2", Drop: []string{"items"}}}}))
+	_, conn := newMemClient(h, "u1")
+
+	h.emitToUser("u1", WSMessage{Event: "order.paid", Data: map[string]interface{}{"id": 1, "items": []int{1, 2}}})
+	msg, err := conn.expectEvent("order.paid", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := msg.Data.(map[string]interface{}); data == nil || data["items"] != nil || data["id"] == nil {
+		t.Fatalf("降级后的 data = %v, want 去掉 items", msg.Data)
+	}
+}
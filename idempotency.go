@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 推送幂等键（/v1/push） =====
+//
+// 调用方重试推送时带同一个 Idempotency-Key，中继只执行一次，重复请求直接回放第一次的响应：
+//
+//	Idempotency-Key: 6f1c2e9a0b7d4c38
+//
+// 回放的响应带 Idempotent-Replayed: true。只缓存 2xx 响应，失败（限流、维护、校验错误等）不占用键，
+// 调用方可以用同一个键重试。同一个键的请求还在处理时再来一次返回 409（reason: in_progress，稍后重试即可），
+// 同一个键换了请求体也返回 409（reason: body_mismatch）。
+// 键在本节点内存中保留 idempotencyTTL，集群部署时重试应发往同一节点（或依赖负载均衡的会话保持）。
+// 删除用户数据（DELETE /api/users/{id}）时，推送目标含该用户的键连同缓存的响应一起删除，之后同一个键的重试会重新执行。
+
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	idempotencyReplayed  = "Idempotent-Replayed"
+	idempotencyMaxKeyLen = 128
+	idempotencyTTL       = 10 * time.Minute
+	idempotencyMaxKeys   = 100000 // 超过后新键不再缓存，只打日志
+)
+
+// idempotentEntry 一个幂等键对应的请求
+type idempotentEntry struct {
+	bodyHash    [32]byte
+	done        bool // 响应已缓存
+	status      int
+	contentType string
+	body        []byte
+	expiresAt   time.Time
+	users       []string // 推送的目标用户，删除用户数据时据此清理
+}
+
+// idempotencyCtxKey 请求上下文里当前幂等键对应的 *idempotentEntry
+type idempotencyCtxKey struct{}
+
+var (
+	idempotencyMu   sync.Mutex
+	idempotencyKeys = make(map[string]*idempotentEntry)
+
+	idempotencyReplays   atomic.Uint64
+	idempotencyConflicts atomic.Uint64
+
+	idempotencySweepOnce sync.Once
+)
+
+// idempotentRecorder 记下响应，处理完后存入缓存
+type idempotentRecorder struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (rec *idempotentRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotentRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.buf.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// withIdempotency 按 Idempotency-Key 去重，没带这个头的请求原样放行
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > idempotencyMaxKeyLen {
+			writeValidationProblem(w, r, idempotencyHeader, "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(body)
+
+		idempotencySweepOnce.Do(func() { go idempotencySweepLoop() })
+
+		idempotencyMu.Lock()
+		e, ok := idempotencyKeys[key]
+		if ok && time.Now().After(e.expiresAt) {
+			delete(idempotencyKeys, key)
+			ok = false
+		}
+		switch {
+		case ok && e.bodyHash != hash:
+			idempotencyMu.Unlock()
+			idempotencyConflicts.Add(1)
+			writeProblemWith(w, r, http.StatusConflict, problemConflict, "Idempotency-Key was already used with a different request body",
+				map[string]interface{}{"reason": "body_mismatch"})
+			return
+		case ok && !e.done:
+			idempotencyMu.Unlock()
+			idempotencyConflicts.Add(1)
+			writeProblemWith(w, r, http.StatusConflict, problemConflict, "a request with this Idempotency-Key is still in progress",
+				map[string]interface{}{"reason": "in_progress"})
+			return
+		case ok:
+			status, contentType, cached := e.status, e.contentType, e.body
+			idempotencyMu.Unlock()
+			idempotencyReplays.Add(1)
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set(idempotencyReplayed, "true")
+			w.WriteHeader(status)
+			_, _ = w.Write(cached)
+			return
+		}
+		if len(idempotencyKeys) >= idempotencyMaxKeys {
+			idempotencyMu.Unlock()
+			log.Printf("⚠️ 幂等键缓存已满（%d），本次请求不去重\n", idempotencyMaxKeys)
+			next(w, r)
+			return
+		}
+		e = &idempotentEntry{bodyHash: hash, expiresAt: time.Now().Add(idempotencyTTL)}
+		idempotencyKeys[key] = e
+		idempotencyMu.Unlock()
+
+		rec := &idempotentRecorder{ResponseWriter: w}
+		next(rec, r.WithContext(context.WithValue(r.Context(), idempotencyCtxKey{}, e)))
+
+		idempotencyMu.Lock()
+		defer idempotencyMu.Unlock()
+		if rec.status/100 != 2 {
+			// 失败不占用键，调用方可以用同一个键重试；处理期间键可能已被用户数据删除清掉、又被新请求占用
+			if idempotencyKeys[key] == e {
+				delete(idempotencyKeys, key)
+			}
+			return
+		}
+		e.done = true
+		e.status = rec.status
+		e.contentType = w.Header().Get("Content-Type")
+		e.body = rec.buf.Bytes()
+	}
+}
+
+// noteIdempotentUsers 记下幂等请求推送的目标用户；请求没带 Idempotency-Key 时不做任何事
+func noteIdempotentUsers(r *http.Request, p *preparedPush) {
+	e, ok := r.Context().Value(idempotencyCtxKey{}).(*idempotentEntry)
+	if !ok {
+		return
+	}
+	idempotencyMu.Lock()
+	if p.target != "" {
+		e.users = append(e.users, p.target)
+	}
+	e.users = append(e.users, p.targets...)
+	idempotencyMu.Unlock()
+}
+
+// forgetUserIdempotency 删除推送目标含该用户的幂等键，返回删除的数量
+func forgetUserIdempotency(userID string) int {
+	n := 0
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+	for key, e := range idempotencyKeys {
+		if slices.Contains(e.users, userID) {
+			delete(idempotencyKeys, key)
+			n++
+		}
+	}
+	return n
+}
+
+// idempotencySweepLoop 定期清理过期的幂等键
+func idempotencySweepLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		idempotencyMu.Lock()
+		for key, e := range idempotencyKeys {
+			if e.done && now.After(e.expiresAt) {
+				delete(idempotencyKeys, key)
+			}
+		}
+		idempotencyMu.Unlock()
+	}
+}
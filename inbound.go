@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 原生协议上行帧解析 =====
+//
+// 原生 WebSocket / TCP 的上行帧只解析一次：先检查大小，再按严格的信封结构解码
+// （必须是单个 JSON 对象、不允许未知字段、字段类型必须正确）。
+// 解析失败时给客户端回一个 error 事件，带上错误码、说明和出错的字段，连接保持不断：
+//
+//	{"event":"error","data":{"code":"invalid_type","msg":"...","field":"data.token"}}
+//
+// 配置了 allowed_events 时，不在白名单里的事件同样回 error（event_not_allowed），不再进入默认分支。
+// 每个被拒绝的帧给连接记 1 分（abuse 分数），每 abuse_decay_seconds 扣 1 分；
+// 分数达到 abuse_threshold 时以 1008 (Policy Violation) 断开，防止客户端 bug 或恶意连接无限刷错误帧。
+
+// InboundConfig 上行帧限制
+type InboundConfig struct {
+	MaxFrameBytes    int `json:"max_frame_bytes"`    // 单帧上限，默认 64KB
+	MaxEventLength   int `json:"max_event_length"`   // event 名最大长度，默认 128
+	MaxChannelLength int `json:"max_channel_length"` // channel 名最大长度，默认 200
+
+	AllowedEvents     []string `json:"allowed_events"`      // 允许客户端发送的事件名，支持 * / ? 通配；为空不限制，identify 始终允许
+	AbuseThreshold    int      `json:"abuse_threshold"`     // 被拒绝的帧累计多少分断开连接，0 表示不断开
+	AbuseDecaySeconds int      `json:"abuse_decay_seconds"` // 每多少秒扣 1 分，默认 10
+}
+
+const (
+	inboundDefaultMaxFrameBytes    = 64 << 10
+	inboundDefaultMaxEventLength   = 128
+	inboundDefaultMaxChannelLength = 200
+	inboundDefaultAbuseDecay       = 10
+)
+
+// 上行帧错误码
+const (
+	errCodeFrameTooLarge = "frame_too_large"
+	errCodeInvalidJSON   = "invalid_json"
+	errCodeUnknownField  = "unknown_field"
+	errCodeInvalidType   = "invalid_type"
+	errCodeMissingField  = "missing_field"
+	errCodeInvalidValue  = "invalid_value"
+	errCodeNotAllowed    = "event_not_allowed"
+	errCodeAbuse         = "too_many_errors"
+)
+
+// inboundFrame 原生协议的上行帧：type=ping / ack / subscribe / unsubscribe 控制帧，或者 {event, channel, data} 事件
+type inboundFrame struct {
+	Type    string          `json:"type"`
+	Ts      int64           `json:"ts"`
+	ID      string          `json:"id"` // type=ack 时为确认的 message_id
+	Event   string          `json:"event"`
+	Channel string          `json:"channel"`
+	History int             `json:"history"` // type=subscribe 时可选：补发最近多少条频道消息（见 channelhistory.go）
+	TTL     int             `json:"ttl"`     // type=subscribe 时可选：订阅有效期（秒），到期自动退订（见 subttl.go）
+	Data    json.RawMessage `json:"data"`
+}
+
+// clientError 回给客户端的结构化错误
+type clientError struct {
+	Code  string `json:"code"`
+	Msg   string `json:"msg"`
+	Field string `json:"field,omitempty"`
+}
+
+func (e *clientError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s (%s)", e.Code, e.Msg, e.Field)
+	}
+	return e.Code + ": " + e.Msg
+}
+
+// sendClientError 下发 error 事件
+func sendClientError(c *Client, e *clientError) error {
+	return c.deliver(WSMessage{Event: "error", Data: e})
+}
+
+func prepareInbound(cfg *InboundConfig) {
+	if cfg.MaxFrameBytes <= 0 {
+		cfg.MaxFrameBytes = inboundDefaultMaxFrameBytes
+	}
+	if cfg.MaxEventLength <= 0 {
+		cfg.MaxEventLength = inboundDefaultMaxEventLength
+	}
+	if cfg.MaxChannelLength <= 0 {
+		cfg.MaxChannelLength = inboundDefaultMaxChannelLength
+	}
+	if cfg.AbuseDecaySeconds <= 0 {
+		cfg.AbuseDecaySeconds = inboundDefaultAbuseDecay
+	}
+	allowed := cfg.AllowedEvents[:0]
+	for _, p := range cfg.AllowedEvents {
+		if _, err := path.Match(p, ""); err != nil {
+			log.Printf("⚠️ inbound.allowed_events 模式无效，已忽略: %s\n", p)
+			continue
+		}
+		allowed = append(allowed, p)
+	}
+	cfg.AllowedEvents = allowed
+}
+
+// eventAllowed 事件是否在白名单内；没有配置白名单时全部允许
+func eventAllowed(cfg InboundConfig, event string) bool {
+	if len(cfg.AllowedEvents) == 0 || event == "identify" {
+		return true
+	}
+	for _, p := range cfg.AllowedEvents {
+		if matched, _ := path.Match(p, event); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// inboundEventError 上行事件转发前的检查：系统保留事件、不在白名单内的事件返回对应错误，通过时返回 nil。
+// 原生协议和各适配协议（Pusher client event、Phoenix、Centrifugo publish）共用
+func inboundEventError(c *Client, event string) *clientError {
+	if isSystemEvent(event) {
+		return &clientError{Code: errCodeReservedEvent, Msg: systemEventReservedMsg, Field: "event"}
+	}
+	if !eventAllowed(inboundConfigFor(c), event) {
+		if shouldLogEvent(event) {
+			log.Printf("⛔ 上行事件不在白名单内 conn=%s event=%s\n", c.id, event)
+		}
+		return &clientError{Code: errCodeNotAllowed, Msg: "event is not allowed", Field: "event"}
+	}
+	return nil
+}
+
+// abuseScore 连接上行被拒绝的累计分数，只在该连接的读循环里访问
+type abuseScore struct {
+	score   int
+	updated time.Time
+}
+
+// add 先按经过的时间扣分，再记 1 分，返回当前分数
+func (a *abuseScore) add(now time.Time, decay time.Duration) int {
+	return a.addN(now, decay, 1)
+}
+
+// addN 同 add，记 n 分
+func (a *abuseScore) addN(now time.Time, decay time.Duration, n int) int {
+	if a.score > 0 {
+		a.score = max(0, a.score-int(now.Sub(a.updated)/decay))
+	}
+	a.score += n
+	a.updated = now
+	return a.score
+}
+
+var (
+	inboundRejectedMu sync.Mutex
+	inboundRejected   = make(map[string]uint64) // 错误码 -> 次数
+	abuseDisconnects  atomic.Uint64
+)
+
+// rejectInbound 拒绝一帧：回 error 事件、计数、累计 abuse 分数；返回 false 表示应断开连接
+func rejectInbound(c *Client, e *clientError) bool {
+	return rejectInboundWith(c, e, func(e *clientError) error { return sendClientError(c, e) })
+}
+
+// rejectInboundWith 同 rejectInbound，错误按适配协议自己的格式回给客户端（Pusher 的 pusher:error、Phoenix 的 phx_reply 等）
+func rejectInboundWith(c *Client, e *clientError, send func(*clientError) error) bool {
+	inboundRejectedMu.Lock()
+	inboundRejected[e.Code]++
+	inboundRejectedMu.Unlock()
+
+	if err := send(e); err != nil {
+		return false
+	}
+	if recordConnAbuse(c, banSignalParse) {
+		banRejected.Add(1)
+		c.closeWithCode(websocket.ClosePolicyViolation, "temporarily banned")
+		return false
+	}
+
+	cfg := GlobalConfig.Inbound
+	if cfg.AbuseThreshold <= 0 {
+		return true
+	}
+	score := c.abuse.add(time.Now(), time.Duration(cfg.AbuseDecaySeconds)*time.Second)
+	if score < cfg.AbuseThreshold {
+		return true
+	}
+
+	abuseDisconnects.Add(1)
+	recordConnAbuse(c, banSignalRate)
+	log.Printf("🚫 上行错误过多，断开连接 conn=%s user_id=%s score=%d\n", c.id, logUserID(c.userID), score)
+	_ = send(&clientError{Code: errCodeAbuse, Msg: "too many rejected messages, disconnecting"})
+	c.closeWithCode(websocket.ClosePolicyViolation, "too many rejected messages")
+	return false
+}
+
+// inboundRejectedCounts 各错误码的拒绝次数拷贝，用于指标
+func inboundRejectedCounts() map[string]uint64 {
+	inboundRejectedMu.Lock()
+	defer inboundRejectedMu.Unlock()
+	out := make(map[string]uint64, len(inboundRejected))
+	for k, v := range inboundRejected {
+		out[k] = v
+	}
+	return out
+}
+
+// parseInbound 解析并校验一帧
+func parseInbound(raw []byte, cfg InboundConfig) (inboundFrame, *clientError) {
+	var f inboundFrame
+	if len(raw) > cfg.MaxFrameBytes {
+		return f, &clientError{Code: errCodeFrameTooLarge, Msg: fmt.Sprintf("frame exceeds %d bytes", cfg.MaxFrameBytes)}
+	}
+	if !utf8.Valid(raw) {
+		return f, &clientError{Code: errCodeInvalidJSON, Msg: "frame is not valid UTF-8"}
+	}
+	if err := decodeStrict(raw, &f); err != nil {
+		return f, err
+	}
+
+	switch f.Type {
+	case "ping":
+		return f, nil
+	case "ack":
+		if f.ID == "" {
+			return f, &clientError{Code: errCodeMissingField, Msg: "ack requires id", Field: "id"}
+		}
+		return f, nil
+	case "subscribe", "unsubscribe":
+		switch {
+		case f.Channel == "":
+			return f, &clientError{Code: errCodeMissingField, Msg: f.Type + " requires channel", Field: "channel"}
+		case len(f.Channel) > cfg.MaxChannelLength:
+			return f, &clientError{Code: errCodeInvalidValue, Msg: fmt.Sprintf("channel longer than %d bytes", cfg.MaxChannelLength), Field: "channel"}
+		case f.Type == "subscribe" && channelWildcardCount(f.Channel) > topicMaxWildcards:
+			return f, &clientError{Code: errCodeInvalidValue, Msg: fmt.Sprintf("channel has more than %d * / # wildcard segments", topicMaxWildcards), Field: "channel"}
+		case f.History < 0 || (f.History > 0 && f.Type != "subscribe"):
+			return f, &clientError{Code: errCodeInvalidValue, Msg: "history must be a non-negative count on subscribe", Field: "history"}
+		case f.TTL < 0 || f.TTL > GlobalConfig.SubscriptionTTL.MaxSeconds || (f.TTL > 0 && f.Type != "subscribe"):
+			return f, &clientError{Code: errCodeInvalidValue, Msg: fmt.Sprintf("ttl must be 0-%d seconds on subscribe", GlobalConfig.SubscriptionTTL.MaxSeconds), Field: "ttl"}
+		}
+		return f, nil
+	case "":
+	default:
+		return f, &clientError{Code: errCodeInvalidValue, Msg: "unsupported type, only \"ping\", \"ack\", \"subscribe\" and \"unsubscribe\" are allowed", Field: "type"}
+	}
+
+	switch {
+	case f.Event == "":
+		return f, &clientError{Code: errCodeMissingField, Msg: "event is required", Field: "event"}
+	case len(f.Event) > cfg.MaxEventLength:
+		return f, &clientError{Code: errCodeInvalidValue, Msg: fmt.Sprintf("event longer than %d bytes", cfg.MaxEventLength), Field: "event"}
+	case len(f.Channel) > cfg.MaxChannelLength:
+		return f, &clientError{Code: errCodeInvalidValue, Msg: fmt.Sprintf("channel longer than %d bytes", cfg.MaxChannelLength), Field: "channel"}
+	}
+	return f, nil
+}
+
+// parseIdentify 解析 identify 事件的 data
+func parseIdentify(data json.RawMessage) (IdentifyData, *clientError) {
+	var id IdentifyData
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return id, &clientError{Code: errCodeMissingField, Msg: "identify requires data", Field: "data"}
+	}
+	if err := decodeStrict(data, &id); err != nil {
+		if err.Field != "" {
+			err.Field = "data." + err.Field
+		} else {
+			err.Field = "data"
+		}
+		return id, err
+	}
+	return id, nil
+}
+
+// inboundData 把事件的 data 解码成通用结构，交给 tap / 日志使用
+func inboundData(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	var v interface{}
+	_ = json.Unmarshal(data, &v) // 信封解码时已校验过是合法 JSON
+	return v
+}
+
+// decodeStrict 把 raw 解码到 v：必须是单个 JSON 对象，不允许未知字段和多余内容
+func decodeStrict(raw []byte, v interface{}) *clientError {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return &clientError{Code: errCodeInvalidJSON, Msg: "expected a JSON object"}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return jsonClientError(err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return &clientError{Code: errCodeInvalidJSON, Msg: "unexpected data after JSON object"}
+	}
+	return nil
+}
+
+// jsonClientError 把 encoding/json 的错误转成客户端错误，尽量带上字段名
+func jsonClientError(err error) *clientError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &clientError{
+			Code:  errCodeInvalidType,
+			Msg:   fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value),
+			Field: typeErr.Field,
+		}
+	}
+	// DisallowUnknownFields 的错误没有导出类型，格式为 json: unknown field "xxx"
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		return &clientError{
+			Code:  errCodeUnknownField,
+			Msg:   "unknown field",
+			Field: strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`),
+		}
+	}
+	return &clientError{Code: errCodeInvalidJSON, Msg: err.Error()}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialTestWS 连上测试服务器的 WebSocket 端点
+func dialTestWS(t *testing.T, srv *httptest.Server, path string) *websocket.Conn {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// readUntil 读到包含 want 的帧为止，返回该帧
+func readUntil(t *testing.T, ws *websocket.Conn, want string) string {
+	t.Helper()
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("等待 %s 时连接出错: %v", want, err)
+		}
+		if strings.Contains(string(data), want) {
+			return string(data)
+		}
+	}
+}
+
+// expectAbuseClose 读到连接因 abuse 被关闭为止
+func expectAbuseClose(t *testing.T, ws *websocket.Conn) {
+	t.Helper()
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				t.Fatalf("关闭原因 = %v, want 1008", err)
+			}
+			return
+		}
+	}
+}
+
+func TestAdaptersCheckAllowedEvents(t *testing.T) {
+	useConfig(t, func(cfg *Config) {
+		cfg.Inbound.AllowedEvents = []string{"chat.*"}
+		cfg.Inbound.AbuseThreshold = 2
+		cfg.Pusher.Key = "test-key"
+		cfg.Phoenix.UserTopic = phoenixDefaultUserTopic
+		cfg.Centrifugo.AllowPublish = true
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app/{key}", pusherWSHandler)
+	mux.HandleFunc(phoenixDefaultPath, phoenixWSHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	t.Run("pusher", func(t *testing.T) {
+		ws := dialTestWS(t, srv, "/app/test-key")
+		readUntil(t, ws, "pusher:connection_established")
+
+		_ = ws.WriteJSON(map[string]interface{}{"event": "client-typing", "channel": "room", "data": "{}"})
+		frame := readUntil(t, ws, "pusher:error")
+		if !strings.Contains(frame, "event is not allowed") {
+			t.Fatalf("错误帧 = %s", frame)
+		}
+		_ = ws.WriteJSON(map[string]interface{}{"event": "client-typing", "channel": "room", "data": "{}"})
+		expectAbuseClose(t, ws)
+	})
+
+	t.Run("phoenix", func(t *testing.T) {
+		ws := dialTestWS(t, srv, phoenixDefaultPath+"?vsn=1.0.0")
+		send := func(ref, event string) {
+			_ = ws.WriteJSON(map[string]interface{}{"topic": "room", "event": event, "payload": map[string]interface{}{}, "ref": ref})
+		}
+		reply := func(ref string) (status, reason string) {
+			var msg struct {
+				Payload struct {
+					Status   string `json:"status"`
+					Response struct {
+						Reason string `json:"reason"`
+					} `json:"response"`
+				} `json:"payload"`
+			}
+			if err := json.Unmarshal([]byte(readUntil(t, ws, `"ref":"`+ref+`"`)), &msg); err != nil {
+				t.Fatal(err)
+			}
+			return msg.Payload.Status, msg.Payload.Response.Reason
+		}
+
+		send("1", "phx_join")
+		if status, _ := reply("1"); status != "ok" {
+			t.Fatalf("join status = %s", status)
+		}
+		send("2", "chat.message")
+		if status, _ := reply("2"); status != "ok" {
+			t.Fatalf("白名单内的事件 status = %s", status)
+		}
+		send("3", "typing")
+		if status, reason := reply("3"); status != "error" || reason != errCodeNotAllowed {
+			t.Fatalf("白名单外的事件 status=%s reason=%s", status, reason)
+		}
+		send("4", "typing")
+		expectAbuseClose(t, ws)
+	})
+	t.Run("centrifugo", func(t *testing.T) {
+		c, conn := newMemClient(defaultHub, "")
+		t.Cleanup(func() { defaultHub.removeClient(c) })
+		subscribeChannel(c, "room")
+
+		var cmd centrifugoCommand
+		_ = json.Unmarshal([]byte(`{"id":5,"publish":{"channel":"room","data":{}}}`), &cmd)
+		centrifugoHandleCommand(c, &cmd)
+		f, ok := conn.next(time.Second)
+		if !ok || !strings.Contains(string(f.Data), `"code":103`) {
+			t.Fatalf("白名单不含 publication 时 publish 应被拒绝: %s", f.Data)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 连接初始数据 =====
+//
+// 客户端连上并 identify 之后，通常马上还要调一次业务接口拉未读数、在线好友这类初始状态。
+// 开启 initial_data 后中继在 identify 成功时 POST 给业务后端：
+//
+//	{"user_id":"42","connection_id":"123.7","platform":"ios","meta":{"x-app-id":"shop"}}
+//
+// 后端返回的 JSON 原样作为 identify 之后的第一个事件下发：{"event":"initial_data","data":{...}}，
+// 返回 204 表示这次没有初始数据。webhook 失败只记日志和指标，不影响连接。
+// 内嵌使用时可以用 WithInitialData 给 Hub 指定自己的数据源，不走 HTTP。
+// 配置 secret 后请求签名方式与准入 webhook 相同。
+
+// InitialDataConfig 连接初始数据配置
+type InitialDataConfig struct {
+	Enabled        bool   `json:"enabled"`
+	URL            string `json:"url"`
+	Secret         string `json:"secret"`          // 可选：请求签名密钥，支持密钥引用
+	TimeoutSeconds int    `json:"timeout_seconds"` // 默认 2
+	Event          string `json:"event"`           // 下发的事件名，默认 initial_data
+}
+
+// InitialDataProvider 连接 identify 成功后提供初始数据，返回 nil 表示没有数据
+type InitialDataProvider interface {
+	InitialData(ctx context.Context, c *Client, userID string) (interface{}, error)
+}
+
+// InitialDataFunc 函数形式的 InitialDataProvider
+type InitialDataFunc func(ctx context.Context, c *Client, userID string) (interface{}, error)
+
+func (f InitialDataFunc) InitialData(ctx context.Context, c *Client, userID string) (interface{}, error) {
+	return f(ctx, c, userID)
+}
+
+const (
+	initialDataDefaultTimeout = 2
+	initialDataDefaultEvent   = "initial_data"
+)
+
+// initialDataRequest 发给 webhook 的请求体
+type initialDataRequest struct {
+	UserID       string            `json:"user_id"`
+	ConnectionID string            `json:"connection_id"`
+	Platform     string            `json:"platform,omitempty"`
+	Meta         map[string]string `json:"meta,omitempty"`
+}
+
+var (
+	initialDataClient    *http.Client
+	initialDataDelivered atomic.Uint64
+	initialDataEmpty     atomic.Uint64
+	initialDataErrors    atomic.Uint64
+)
+
+func prepareInitialData(cfg *InitialDataConfig) {
+	if cfg.Event == "" {
+		cfg.Event = initialDataDefaultEvent
+	}
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.URL == "" {
+		log.Fatalln("❌ initial_data 已开启但没有配置 url")
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = initialDataDefaultTimeout
+	}
+	initialDataClient = &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}
+}
+
+// sendInitialData 向刚 identify 的连接下发初始数据；Hub 没有数据源时不做任何事
+func (h *Hub) sendInitialData(c *Client, userID string) {
+	if h.initialData == nil {
+		return
+	}
+	timeout := time.Duration(h.cfg.InitialData.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = initialDataDefaultTimeout * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	data, err := h.initialData.InitialData(ctx, c, userID)
+	if err != nil {
+		initialDataErrors.Add(1)
+		h.logger.Printf("⚠️ 获取初始数据失败 conn=%s user_id=%s: %v\n", c.id, h.logUserID(userID), err)
+		return
+	}
+	if data == nil {
+		initialDataEmpty.Add(1)
+		return
+	}
+	event := h.cfg.InitialData.Event
+	if event == "" {
+		event = initialDataDefaultEvent
+	}
+	if err := c.deliverOutbound(h.newOutbound(WSMessage{Event: event, Data: data})); err != nil {
+		h.logger.Printf("⚠️ 初始数据下发失败 conn=%s: %v\n", c.id, err)
+		return
+	}
+	initialDataDelivered.Add(1)
+}
+
+// webhookInitialData 默认数据源：按构造时给定的配置调用 webhook，未开启 initial_data 时没有数据
+type webhookInitialData struct {
+	cfg *Config
+}
+
+func (w webhookInitialData) InitialData(ctx context.Context, c *Client, userID string) (interface{}, error) {
+	cfg := w.cfg.InitialData
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	in := initialDataRequest{UserID: userID, ConnectionID: c.id, Meta: c.meta}
+	if c.device != nil {
+		in.Platform = c.device.Platform
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signWebhookRequest(req, liveSecret("initial_data.secret", cfg.Secret), body)
+
+	resp, err := initialDataClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.New("webhook returned " + resp.Status)
+	}
+	var data json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	return data, nil
+}
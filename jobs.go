@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	_ "time/tzdata" // 容器镜像里常常没有 zoneinfo，时区数据打进二进制
+)
+
+// ===== 延迟推送任务 =====
+//
+// 带 delay_seconds > 0 或 deliver_at 的推送登记为一个任务，到点后由定时器发送：
+//   - delay_seconds：相对现在的秒数
+//   - deliver_at：RFC3339 绝对时间，如 2026-01-02T09:00:00+08:00
+//   - deliver_at + timezone：当地时间（不带偏移）+ IANA 时区名，如 "2026-01-02T09:00:00" + "America/New_York"，
+//     由 relay 换算成绝对时间（夏令时切换当天不存在的时刻按 Go 的规则顺延）
+//
+// 任务可以通过 /v1/jobs（push 认证）或 /api/jobs（admin 认证，给运维用）查询、取消；已结束（发送 / 取消）的任务保留 jobRetention 便于查询结果，之后清理。
+// 配置 jobs.store_file 后任务落盘，重启后未发送的任务会重新排期；否则只保存在内存中。
+// 停机期间已经错过发送时间的任务（misfire）按 jobs.misfire_policy 处理：
+//   - fire（默认）：立即发送
+//   - skip：不发送，任务标记为 missed
+//   - grace：错过不超过 misfire_grace_seconds 的立即发送，否则标记为 missed
+//
+// 发送结果在发送完成后才落盘，发送过程中进程退出的任务重启后会再发一次（至少一次）。
+
+// JobsConfig 延迟推送任务配置
+type JobsConfig struct {
+	StoreFile           string `json:"store_file"`            // 落盘文件（相对当前工作目录），留空只保存在内存
+	MisfirePolicy       string `json:"misfire_policy"`        // fire（默认）/ skip / grace
+	MisfireGraceSeconds int    `json:"misfire_grace_seconds"` // grace 策略的宽限时间，默认 300
+	RecurringStoreFile  string `json:"recurring_store_file"`  // 周期推送（cron）落盘文件，留空只保存在内存
+}
+
+const (
+	jobStatusScheduled = "scheduled"
+	jobStatusDelivered = "delivered"
+	jobStatusCancelled = "cancelled"
+	jobStatusMissed    = "missed" // 停机期间错过发送时间且按策略不再发送
+
+	misfirePolicyFire  = "fire"
+	misfirePolicySkip  = "skip"
+	misfirePolicyGrace = "grace"
+
+	misfireDefaultGraceSeconds = 300
+
+	jobRetention = 10 * time.Minute
+
+	jobMaxHorizon     = 366 * 24 * time.Hour // deliver_at 最远一年
+	jobPastTolerance  = 5 * time.Second      // deliver_at 早于现在不超过这个时间时立即发送
+	jobLocalLayout    = "2006-01-02T15:04:05"
+	jobLocalLayoutMin = "2006-01-02T15:04"
+)
+
+// pushJob 一个延迟推送任务；对外返回的都是拷贝
+type pushJob struct {
+	ID           string     `json:"id"`
+	Status       string     `json:"status"`
+	EventName    string     `json:"event_name"`
+	TargetUserID string     `json:"target_user_id,omitempty"`
+	TargetUsers  []string   `json:"target_user_ids,omitempty"` // token 为数组时
+	Channel      string     `json:"channel,omitempty"`
+	Group        string     `json:"group,omitempty"`
+	Broadcast    bool       `json:"broadcast"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RunAt        time.Time  `json:"run_at"`
+	DeliverAt    string     `json:"deliver_at,omitempty"` // 请求中的 deliver_at 原值
+	Timezone     string     `json:"timezone,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	Delivered    int        `json:"delivered"`            // 发送时成功投递的连接数
+	Misfire      string     `json:"misfire,omitempty"`    // 重启时已错过发送时间：fired（补发）/ skipped（放弃）
+	MessageID    string     `json:"message_id,omitempty"` // 开启 receipts 时发送后分配
+
+	push  *preparedPush
+	timer *time.Timer
+}
+
+// storedJob 落盘格式：任务状态 + 原始推送请求，重启后据此重建
+type storedJob struct {
+	pushJob
+	Request PushRequest `json:"request"`
+}
+
+var (
+	pushJobsMu sync.Mutex
+	pushJobs   = make(map[string]*pushJob)
+
+	jobsSaveMu sync.Mutex // 串行化落盘，保证后写入的一定是更新的快照
+
+	jobMisfiresFired   atomic.Uint64
+	jobMisfiresSkipped atomic.Uint64
+)
+
+func prepareJobs(cfg *JobsConfig) {
+	switch cfg.MisfirePolicy {
+	case "":
+		cfg.MisfirePolicy = misfirePolicyFire
+	case misfirePolicyFire, misfirePolicySkip, misfirePolicyGrace:
+	default:
+		log.Fatalf("❌ jobs.misfire_policy 不支持: %s（可选 fire / skip / grace）\n", cfg.MisfirePolicy)
+	}
+	if cfg.MisfireGraceSeconds <= 0 {
+		cfg.MisfireGraceSeconds = misfireDefaultGraceSeconds
+	}
+}
+
+// misfireShouldFire 错过 late 的任务是否仍要发送
+func misfireShouldFire(cfg JobsConfig, late time.Duration) bool {
+	switch cfg.MisfirePolicy {
+	case misfirePolicySkip:
+		return false
+	case misfirePolicyGrace:
+		return late <= time.Duration(cfg.MisfireGraceSeconds)*time.Second
+	}
+	return true
+}
+
+// pushRunAt 解析推送的计划发送时间，出错时同时返回出错的字段；立即发送时返回零值
+func pushRunAt(body PushRequest, now time.Time) (time.Time, string, error) {
+	if body.DeliverAt == "" {
+		if body.Timezone != "" {
+			return time.Time{}, "timezone", errors.New("timezone requires deliver_at")
+		}
+		if body.DelaySeconds > 0 {
+			return now.Add(time.Duration(body.DelaySeconds) * time.Second), "", nil
+		}
+		return time.Time{}, "", nil
+	}
+	if body.DelaySeconds > 0 {
+		return time.Time{}, "deliver_at", errors.New("delay_seconds and deliver_at are mutually exclusive")
+	}
+
+	var at time.Time
+	if body.Timezone == "" {
+		t, err := time.Parse(time.RFC3339, body.DeliverAt)
+		if err != nil {
+			return time.Time{}, "deliver_at", errors.New("deliver_at must be RFC3339 with an offset, e.g. 2026-01-02T09:00:00+08:00, or a local time together with timezone")
+		}
+		at = t
+	} else {
+		loc, err := time.LoadLocation(body.Timezone)
+		if err != nil || body.Timezone == "Local" {
+			return time.Time{}, "timezone", errors.New("timezone must be an IANA name, e.g. Asia/Shanghai")
+		}
+		if _, err := time.Parse(time.RFC3339, body.DeliverAt); err == nil {
+			return time.Time{}, "deliver_at", errors.New("deliver_at must be a local time without offset when timezone is set")
+		}
+		t, err := time.ParseInLocation(jobLocalLayout, body.DeliverAt, loc)
+		if err != nil {
+			if t, err = time.ParseInLocation(jobLocalLayoutMin, body.DeliverAt, loc); err != nil {
+				return time.Time{}, "deliver_at", errors.New("deliver_at must look like 2026-01-02T09:00:00 when timezone is set")
+			}
+		}
+		at = t
+	}
+
+	switch {
+	case at.Before(now.Add(-jobPastTolerance)):
+		return time.Time{}, "deliver_at", errors.New("deliver_at is in the past")
+	case at.After(now.Add(jobMaxHorizon)):
+		return time.Time{}, "deliver_at", errors.New("deliver_at is more than a year ahead")
+	case !at.After(now):
+		return time.Time{}, "", nil
+	}
+	return at, "", nil
+}
+
+// schedulePush 按 p.runAt 登记延迟推送，返回任务快照
+func schedulePush(p *preparedPush) pushJob {
+	now := time.Now()
+	job := &pushJob{
+		ID:           "job_" + randomHex(8),
+		Status:       jobStatusScheduled,
+		EventName:    p.body.EventName,
+		TargetUserID: p.target,
+		TargetUsers:  p.targets,
+		Channel:      p.body.Channel,
+		Group:        p.body.Group,
+		Broadcast:    p.broadcast(),
+		CreatedAt:    now,
+		RunAt:        p.runAt,
+		DeliverAt:    p.body.DeliverAt,
+		Timezone:     p.body.Timezone,
+		push:         p,
+	}
+	p.jobID = job.ID
+
+	if p.logIt {
+		scope := "全站广播"
+		if p.target != "" {
+			scope = "单用户 user_id=" + p.target
+		} else if len(p.targets) > 0 {
+			scope = fmt.Sprintf("%d 个用户", len(p.targets))
+		} else if p.body.Channel != "" {
+			scope = "频道 channel=" + p.body.Channel
+		} else if p.body.Group != "" {
+			scope = "组 group=" + p.body.Group
+		}
+		log.Printf("⏱ 计划在 %s 发送事件 \"%s\"（%s）job=%s\n", p.runAt.Format(time.RFC3339), p.body.EventName, scope, job.ID)
+	}
+
+	pushJobsMu.Lock()
+	sweepPushJobsLocked(now)
+	pushJobs[job.ID] = job
+	armPushJobLocked(job)
+	snapshot := *job
+	pushJobsMu.Unlock()
+
+	savePushJobs()
+	return snapshot
+}
+
+// armPushJobLocked 按 RunAt 设置定时器，已过期的立即发送；调用方持有 pushJobsMu
+func armPushJobLocked(job *pushJob) {
+	job.timer = time.AfterFunc(max(0, time.Until(job.RunAt)), func() { runPushJob(job) })
+}
+
+func runPushJob(job *pushJob) {
+	pushJobsMu.Lock()
+	if job.Status != jobStatusScheduled {
+		pushJobsMu.Unlock()
+		return
+	}
+	// 先占住状态，避免发送期间被取消后又标记为已发送
+	job.Status = jobStatusDelivered
+	pushJobsMu.Unlock()
+
+	delivered := job.push.emit()
+
+	pushJobsMu.Lock()
+	finished := time.Now()
+	job.Delivered = delivered
+	job.MessageID = job.push.message.ID
+	job.FinishedAt = &finished
+	pushJobsMu.Unlock()
+
+	savePushJobs()
+}
+
+// cancelPushJob 取消尚未发送的任务；任务不存在返回 false，已结束的任务原样返回
+func cancelPushJob(id string) (pushJob, bool) {
+	pushJobsMu.Lock()
+	job, ok := pushJobs[id]
+	if !ok {
+		pushJobsMu.Unlock()
+		return pushJob{}, false
+	}
+	cancelled := false
+	if job.Status == jobStatusScheduled && job.timer.Stop() {
+		now := time.Now()
+		job.Status = jobStatusCancelled
+		job.FinishedAt = &now
+		cancelled = true
+		log.Printf("🛑 已取消延迟推送 job=%s event=%s\n", job.ID, job.EventName)
+	}
+	snapshot := *job
+	pushJobsMu.Unlock()
+
+	if cancelled {
+		savePushJobs()
+	}
+	return snapshot, true
+}
+
+// forgetUserPushJobs 用户数据删除时清理延迟推送任务（连同 jobs.store_file）：只发给该用户的任务不论是否已结束都整条删除，
+// 未发送的先取消；多用户任务从 token 数组里去掉该用户后按新请求重建。返回删除或改写的任务数
+func forgetUserPushJobs(userID string) int {
+	n := 0
+	pushJobsMu.Lock()
+	for id, job := range pushJobs {
+		body := job.push.body
+		keep, changed := scrubPushUser(&body, userID)
+		if !changed {
+			continue
+		}
+		n++
+		if keep {
+			if p, _, err := buildPush(body, false); err == nil {
+				p.runAt, p.jobID = job.push.runAt, job.ID
+				job.push = p
+				job.TargetUsers = p.targets
+				continue
+			}
+		}
+		if job.Status == jobStatusScheduled {
+			// 定时器可能已经触发、正等着锁，改掉状态让 runPushJob 直接返回
+			job.timer.Stop()
+			job.Status = jobStatusCancelled
+		}
+		delete(pushJobs, id)
+	}
+	pushJobsMu.Unlock()
+
+	if n > 0 {
+		savePushJobs()
+	}
+	return n
+}
+
+// getPushJob 查询任务
+func getPushJob(id string) (pushJob, bool) {
+	pushJobsMu.Lock()
+	defer pushJobsMu.Unlock()
+
+	sweepPushJobsLocked(time.Now())
+	job, ok := pushJobs[id]
+	if !ok {
+		return pushJob{}, false
+	}
+	return *job, true
+}
+
+// listPushJobs 按 run_at 排序列出任务，status 为空表示全部
+func listPushJobs(status string) []pushJob {
+	pushJobsMu.Lock()
+	sweepPushJobsLocked(time.Now())
+	out := make([]pushJob, 0, len(pushJobs))
+	for _, job := range pushJobs {
+		if status == "" || job.Status == status {
+			out = append(out, *job)
+		}
+	}
+	pushJobsMu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].RunAt.Before(out[j].RunAt) })
+	return out
+}
+
+// sweepPushJobsLocked 清理结束超过 jobRetention 的任务；调用方持有 pushJobsMu
+func sweepPushJobsLocked(now time.Time) {
+	for id, job := range pushJobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > jobRetention {
+			delete(pushJobs, id)
+		}
+	}
+}
+
+// ===== 落盘 =====
+
+// savePushJobs 把当前任务表写入 jobs.store_file；没有配置时不做任何事
+func savePushJobs() {
+	path := GlobalConfig.Jobs.StoreFile
+	if path == "" {
+		return
+	}
+
+	jobsSaveMu.Lock()
+	defer jobsSaveMu.Unlock()
+
+	pushJobsMu.Lock()
+	stored := make([]storedJob, 0, len(pushJobs))
+	for _, job := range pushJobs {
+		stored = append(stored, storedJob{pushJob: *job, Request: job.push.body})
+	}
+	pushJobsMu.Unlock()
+	sort.Slice(stored, func(i, j int) bool { return stored[i].RunAt.Before(stored[j].RunAt) })
+
+	if err := writeFileAtomic(path, stored); err != nil {
+		log.Printf("❌ 延迟推送任务落盘失败 %s: %v\n", path, err)
+	}
+}
+
+// loadPushJobs 启动时恢复落盘的任务：未发送的重新排期，已结束的保留到 jobRetention 到期
+func loadPushJobs() {
+	path := GlobalConfig.Jobs.StoreFile
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 读取延迟推送任务失败 %s: %v\n", path, err)
+		}
+		return
+	}
+	var stored []storedJob
+	if err := json.Unmarshal(data, &stored); err != nil {
+		log.Printf("⚠️ 解析延迟推送任务失败 %s: %v\n", path, err)
+		return
+	}
+
+	now := time.Now()
+	scheduled, fired, skipped := 0, 0, 0
+	pushJobsMu.Lock()
+	for _, s := range stored {
+		p, field, err := buildPush(s.Request, shouldLogEvent(s.Request.EventName))
+		if err != nil {
+			log.Printf("⚠️ 跳过无法恢复的延迟推送 job=%s（%s: %v）\n", s.ID, field, err)
+			continue
+		}
+		p.runAt = s.RunAt
+		p.jobID = s.ID
+		job := s.pushJob
+		job.push = p
+		pushJobs[job.ID] = &job
+		if job.Status != jobStatusScheduled {
+			continue
+		}
+		if late := now.Sub(job.RunAt); late > 0 {
+			if !misfireShouldFire(GlobalConfig.Jobs, late) {
+				job.Status = jobStatusMissed
+				job.Misfire = "skipped"
+				job.FinishedAt = &now
+				skipped++
+				log.Printf("⏭️ 延迟推送错过发送时间 %s，按 %s 策略放弃 job=%s event=%s\n",
+					late.Round(time.Second), GlobalConfig.Jobs.MisfirePolicy, job.ID, job.EventName)
+				if p.body.CallbackURL != "" {
+					res := newPushResult(p)
+					res.Status = pushResultMissed
+					enqueuePushResult(res)
+				}
+				continue
+			}
+			job.Misfire = "fired"
+			fired++
+			log.Printf("⏩ 延迟推送错过发送时间 %s，立即补发 job=%s event=%s\n", late.Round(time.Second), job.ID, job.EventName)
+		}
+		armPushJobLocked(&job)
+		scheduled++
+	}
+	sweepPushJobsLocked(now)
+	pushJobsMu.Unlock()
+
+	jobMisfiresFired.Add(uint64(fired))
+	jobMisfiresSkipped.Add(uint64(skipped))
+	log.Printf("✅ 已恢复延迟推送任务：待发送 %d 个（其中错过时间补发 %d 个），错过时间放弃 %d 个，来自 %s\n",
+		scheduled, fired, skipped, path)
+	if skipped > 0 {
+		savePushJobs()
+	}
+}
+
+// ===== /v1/jobs =====
+
+// v1JobsHandler GET /v1/jobs?status=scheduled
+func v1JobsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", jobStatusScheduled, jobStatusDelivered, jobStatusCancelled, jobStatusMissed:
+	default:
+		writeValidationProblem(w, r, "status", "status must be scheduled, delivered, cancelled or missed")
+		return
+	}
+	writeV1(w, http.StatusOK, map[string]interface{}{"jobs": listPushJobs(status)})
+}
+
+// v1JobHandler GET / DELETE /v1/jobs/{id}
+func v1JobHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var (
+		job pushJob
+		ok  bool
+	)
+	if r.Method == http.MethodDelete {
+		job, ok = cancelPushJob(id)
+	} else {
+		job, ok = getPushJob(id)
+	}
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "job not found: "+id)
+		return
+	}
+	writeV1(w, http.StatusOK, map[string]interface{}{"job": job})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ===== JWKS 公钥集缓存 =====
+//
+// 从 jwks_uri 拉取 RSA / EC(P-256) 公钥，按 kid 查找；遇到未知 kid 时重新拉取（至少间隔 jwksMinRefresh），
+// 以便签发方轮换密钥后无需重启。
+
+const (
+	jwksMinRefresh = time.Minute
+	jwksMaxAge     = time.Hour
+)
+
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{} // kid -> *rsa.PublicKey / *ecdsa.PublicKey
+	fetchedAt time.Time
+}
+
+func newJWKSCache(url string) *jwksCache {
+	return &jwksCache{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// key 按 kid 查公钥，缓存过期或找不到时刷新
+func (c *jwksCache) key(kid string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if k, ok := c.keys[kid]; ok && time.Since(c.fetchedAt) < jwksMaxAge {
+		return k, nil
+	}
+	if time.Since(c.fetchedAt) >= jwksMinRefresh {
+		if err := c.refreshLocked(); err != nil {
+			log.Println("⚠️ JWKS 拉取失败:", err)
+		}
+	}
+	if k, ok := c.keys[kid]; ok {
+		return k, nil
+	}
+	// 只有一个 key 且 token 没带 kid 时直接用它
+	if kid == "" && len(c.keys) == 1 {
+		for _, k := range c.keys {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("JWKS 中找不到 kid=%s", kid)
+}
+
+func (c *jwksCache) refreshLocked() error {
+	c.fetchedAt = time.Now()
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", c.url, resp.Status)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	c.keys = keys
+	log.Printf("🔑 JWKS 已更新：%s，共 %d 个公钥\n", c.url, len(keys))
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ===== JWT 校验 =====
+//
+// 只实现中继需要的部分：HS256 / RS256 / ES256 验签，exp / nbf / iss / aud 校验。
+// RS256 / ES256 的公钥可以固定配置，也可以来自 JWKS（按 kid 查找）。
+
+// jwtClaims 解码后的 claims
+type jwtClaims map[string]interface{}
+
+// jwtVerifier 校验参数，HMACSecret / RSAPublicKey / KeySet 至少配置一个
+type jwtVerifier struct {
+	HMACSecret   []byte
+	RSAPublicKey *rsa.PublicKey
+	KeySet       *jwksCache
+	Issuer       string // 为空不校验
+	Audience     string // 为空不校验
+	RequireExp   bool   // 没有 exp 的 token 视为无效
+	Leeway       time.Duration
+}
+
+// verify 校验 token 并返回 claims
+func (v *jwtVerifier) verify(token string) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("jwt 格式错误")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := jwtDecodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("jwt header 解析失败: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("jwt 签名不是合法的 base64url")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch header.Alg {
+	case "HS256":
+		if v.HMACSecret == nil {
+			return nil, errors.New("未配置 HS256 密钥")
+		}
+		mac := hmac.New(sha256.New, v.HMACSecret)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errors.New("jwt 签名无效")
+		}
+	case "RS256":
+		pub := v.RSAPublicKey
+		if pub == nil && v.KeySet != nil {
+			k, err := v.KeySet.key(header.Kid)
+			if err != nil {
+				return nil, err
+			}
+			pub, _ = k.(*rsa.PublicKey)
+		}
+		if pub == nil {
+			return nil, errors.New("未配置 RS256 公钥")
+		}
+		sum := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+			return nil, errors.New("jwt 签名无效")
+		}
+	case "ES256":
+		if v.KeySet == nil {
+			return nil, errors.New("未配置 ES256 公钥")
+		}
+		k, err := v.KeySet.key(header.Kid)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := k.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return nil, errors.New("jwt 签名无效")
+		}
+		sum := sha256.Sum256(signed)
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, sum[:], r, s) {
+			return nil, errors.New("jwt 签名无效")
+		}
+	default:
+		return nil, fmt.Errorf("不支持的 jwt alg: %s", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := jwtDecodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("jwt payload 解析失败: %w", err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *jwtVerifier) checkClaims(claims jwtClaims) error {
+	now := time.Now()
+	exp, hasExp := claims["exp"].(float64)
+	if v.RequireExp && !hasExp {
+		return errors.New("jwt 缺少 exp")
+	}
+	if hasExp && now.After(time.Unix(int64(exp), 0).Add(v.Leeway)) {
+		return errors.New("jwt 已过期")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("jwt 尚未生效")
+	}
+	if v.Issuer != "" && claims.str("iss") != v.Issuer {
+		return errors.New("jwt iss 不匹配")
+	}
+	if v.Audience != "" && !claims.hasAudience(v.Audience) {
+		return errors.New("jwt aud 不匹配")
+	}
+	return nil
+}
+
+func (c jwtClaims) str(key string) string {
+	s, _ := c[key].(string)
+	return s
+}
+
+// hasAudience aud 可以是字符串或字符串数组
+func (c jwtClaims) hasAudience(aud string) bool {
+	switch v := c["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jwtDecodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// parseRSAPublicKeyPEM 解析 PEM 格式的 RSA 公钥（PKIX 或 PKCS#1）
+func parseRSAPublicKeyPEM(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("不是合法的 PEM")
+	}
+	if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		if rsaPub, ok := pub.(*rsa.PublicKey); ok {
+			return rsaPub, nil
+		}
+		return nil, errors.New("不是 RSA 公钥")
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var testJWTSecret = []byte("test-secret")
+
+// signTestJWT 用 HS256 签一个 token
+func signTestJWT(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, testJWTSecret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTVerifierClaims(t *testing.T) {
+	v := &jwtVerifier{
+		HMACSecret: testJWTSecret,
+		Issuer:     "https://idp.example.com",
+		Audience:   "relay",
+		RequireExp: true,
+		Leeway:     30 * time.Second,
+	}
+	now := time.Now().Unix()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{"sub": "u1", "iss": "https://idp.example.com", "aud": "relay", "exp": now + 300}
+	}
+
+	cases := []struct {
+		name    string
+		edit    func(c map[string]interface{})
+		wantErr string
+	}{
+		{"valid", func(map[string]interface{}) {}, ""},
+		{"aud 数组", func(c map[string]interface{}) { c["aud"] = []string{"other", "relay"} }, ""},
+		{"leeway 内过期", func(c map[string]interface{}) { c["exp"] = now - 10 }, ""},
+		{"缺少 exp", func(c map[string]interface{}) { delete(c, "exp") }, "jwt 缺少 exp"},
+		{"已过期", func(c map[string]interface{}) { c["exp"] = now - 120 }, "jwt 已过期"},
+		{"尚未生效", func(c map[string]interface{}) { c["nbf"] = now + 120 }, "jwt 尚未生效"},
+		{"iss 不匹配", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, "jwt iss 不匹配"},
+		{"aud 不匹配", func(c map[string]interface{}) { c["aud"] = "other-app" }, "jwt aud 不匹配"},
+		{"缺少 aud", func(c map[string]interface{}) { delete(c, "aud") }, "jwt aud 不匹配"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := valid()
+			tc.edit(claims)
+			got, err := v.verify(signTestJWT(claims))
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatalf("verify 失败: %v", err)
+			case tc.wantErr == "" && got.str("sub") != "u1":
+				t.Fatalf("sub = %q", got.str("sub"))
+			case tc.wantErr != "" && (err == nil || err.Error() != tc.wantErr):
+				t.Fatalf("err = %v, want %s", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierOptionalExp(t *testing.T) {
+	// 不要求 exp 的场景（如推送接口的 jwt 认证）没有 exp 也能通过
+	v := &jwtVerifier{HMACSecret: testJWTSecret}
+	if _, err := v.verify(signTestJWT(map[string]interface{}{"sub": "u1"})); err != nil {
+		t.Fatalf("verify 失败: %v", err)
+	}
+}
+
+func TestJWTVerifierSignature(t *testing.T) {
+	v := &jwtVerifier{HMACSecret: []byte("another-secret")}
+	if _, err := v.verify(signTestJWT(map[string]interface{}{"sub": "u1"})); err == nil || err.Error() != "jwt 签名无效" {
+		t.Fatalf("err = %v, want jwt 签名无效", err)
+	}
+	if _, err := v.verify("not-a-jwt"); err == nil {
+		t.Fatal("格式错误的 token 应当失败")
+	}
+}
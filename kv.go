@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 每用户 KV 状态 =====
+//
+// 给每个用户一份小的键值存储，用来在同一用户的多台设备之间同步“最后已读消息 ID”这类轻量状态，
+// 不需要业务后端再做一套同步：
+//   - identify 成功后下发一次全量：{"event":"kv_state","data":{"last_read":{"value":"m-123","version":7,"updated_at":"..."}}}
+//   - 之后每次修改都推给该用户的所有在线连接：{"event":"kv_update","data":{"key":"last_read","value":"m-124","version":8,...}}，
+//     删除时 data 里带 "deleted": true、没有 value
+//
+// 写入有两种方式：业务后端调 PUT / DELETE /api/users/{id}/kv/{key}（push 认证链），
+// 或者客户端在原生协议上发 kv_set / kv_delete（只能写 client_keys 允许的键，只读模式下拒绝）。
+// 每个用户的键数和单个值的大小有上限，超出时 API 返回 409 / 413，客户端收到 kv_quota_exceeded 错误事件。
+// 版本号按用户单调递增（删除也占一个版本），客户端以版本号大的为准。
+// 配置 store_file 后定期落盘；存储在本节点内存中，集群模式下各节点互不同步。
+
+// KVConfig 每用户 KV 配置
+type KVConfig struct {
+	Enabled       bool     `json:"enabled"`
+	StoreFile     string   `json:"store_file"`      // 落盘文件（相对当前工作目录），留空只保存在内存
+	MaxKeys       int      `json:"max_keys"`        // 每用户最多的键数，默认 64
+	MaxValueBytes int      `json:"max_value_bytes"` // 单个值（JSON 编码后）的上限，默认 1024
+	ClientKeys    []string `json:"client_keys"`     // 允许客户端自己写的键，支持 * / ? 通配；为空表示客户端只读
+	MaxKeyLength  int      `json:"max_key_length"`  // 键名最大长度，默认 128
+}
+
+// KVEntry 一个键的当前值
+type KVEntry struct {
+	Value     json.RawMessage `json:"value"`
+	Version   uint64          `json:"version"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Source    string          `json:"source"` // api / client
+}
+
+// kvUpdate kv_update 事件的 data
+type kvUpdate struct {
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Deleted   bool            `json:"deleted,omitempty"`
+	Version   uint64          `json:"version"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Source    string          `json:"source"`
+}
+
+// userKV 一个用户的全部键
+type userKV struct {
+	entries map[string]*KVEntry
+	version uint64 // 最近一次修改的版本号，删除也会递增
+}
+
+// storedUserKV 落盘格式
+type storedUserKV struct {
+	Version uint64             `json:"version"`
+	Entries map[string]KVEntry `json:"entries"`
+}
+
+const (
+	kvDefaultMaxKeys       = 64
+	kvDefaultMaxValueBytes = 1024
+	kvDefaultMaxKeyLength  = 128
+	kvSaveInterval         = 10 * time.Second
+
+	kvStateEvent  = "kv_state"
+	kvUpdateEvent = "kv_update"
+	kvSetEvent    = "kv_set"
+	kvDeleteEvent = "kv_delete"
+
+	kvSourceAPI    = "api"
+	kvSourceClient = "client"
+)
+
+// 客户端写入被拒绝时的错误码
+const (
+	errCodeKVForbidden     = "kv_forbidden"
+	errCodeKVQuotaExceeded = "kv_quota_exceeded"
+)
+
+var (
+	kvMu    sync.Mutex
+	kvStore = make(map[string]*userKV) // user_id -> 键值
+	kvDirty bool
+
+	kvWritesAPI    atomic.Uint64
+	kvWritesClient atomic.Uint64
+	kvRejected     atomic.Uint64
+)
+
+var (
+	errKVTooManyKeys = errors.New("too many keys")
+	errKVValueTooBig = errors.New("value too large")
+)
+
+func prepareKV(cfg *KVConfig) {
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = kvDefaultMaxKeys
+	}
+	if cfg.MaxValueBytes <= 0 {
+		cfg.MaxValueBytes = kvDefaultMaxValueBytes
+	}
+	if cfg.MaxKeyLength <= 0 {
+		cfg.MaxKeyLength = kvDefaultMaxKeyLength
+	}
+	keys := cfg.ClientKeys[:0]
+	for _, p := range cfg.ClientKeys {
+		if _, err := path.Match(p, ""); err != nil {
+			log.Printf("⚠️ kv.client_keys 模式无效，已忽略: %s\n", p)
+			continue
+		}
+		keys = append(keys, p)
+	}
+	cfg.ClientKeys = keys
+}
+
+// validKVKey 键名只允许字母、数字和 _ - . :
+func validKVKey(key string) bool {
+	if key == "" || len(key) > GlobalConfig.KV.MaxKeyLength {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '_', r == '-', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// clientWritableKey 客户端是否可以写这个键
+func clientWritableKey(key string) bool {
+	for _, p := range GlobalConfig.KV.ClientKeys {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// setKV 写入一个键并推给用户的在线连接
+func setKV(userID, key string, value json.RawMessage, source string) (KVEntry, error) {
+	cfg := GlobalConfig.KV
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, value); err != nil {
+		return KVEntry{}, err
+	}
+	if compact.Len() > cfg.MaxValueBytes {
+		kvRejected.Add(1)
+		return KVEntry{}, errKVValueTooBig
+	}
+
+	kvMu.Lock()
+	u, ok := kvStore[userID]
+	if !ok {
+		u = &userKV{entries: make(map[string]*KVEntry)}
+		kvStore[userID] = u
+	}
+	if _, exists := u.entries[key]; !exists && len(u.entries) >= cfg.MaxKeys {
+		kvMu.Unlock()
+		kvRejected.Add(1)
+		return KVEntry{}, errKVTooManyKeys
+	}
+	u.version++
+	entry := &KVEntry{Value: compact.Bytes(), Version: u.version, UpdatedAt: time.Now(), Source: source}
+	u.entries[key] = entry
+	kvDirty = true
+	out := *entry
+	kvMu.Unlock()
+
+	countKVWrite(source)
+	defaultHub.emitToUserConns(userID, WSMessage{Event: kvUpdateEvent, Data: kvUpdate{
+		Key: key, Value: out.Value, Version: out.Version, UpdatedAt: out.UpdatedAt, Source: source,
+	}}, nil)
+	return out, nil
+}
+
+// deleteKV 删除一个键并推给用户的在线连接，键不存在时返回 false
+func deleteKV(userID, key, source string) bool {
+	kvMu.Lock()
+	u, ok := kvStore[userID]
+	if !ok || u.entries[key] == nil {
+		kvMu.Unlock()
+		return false
+	}
+	delete(u.entries, key)
+	u.version++
+	version := u.version
+	kvDirty = true
+	kvMu.Unlock()
+
+	countKVWrite(source)
+	defaultHub.emitToUserConns(userID, WSMessage{Event: kvUpdateEvent, Data: kvUpdate{
+		Key: key, Deleted: true, Version: version, UpdatedAt: time.Now(), Source: source,
+	}}, nil)
+	return true
+}
+
+func countKVWrite(source string) {
+	if source == kvSourceClient {
+		kvWritesClient.Add(1)
+	} else {
+		kvWritesAPI.Add(1)
+	}
+}
+
+// userKVEntries 用户当前的全部键值（副本）
+func userKVEntries(userID string) map[string]KVEntry {
+	kvMu.Lock()
+	defer kvMu.Unlock()
+
+	out := make(map[string]KVEntry)
+	if u, ok := kvStore[userID]; ok {
+		for key, e := range u.entries {
+			out[key] = *e
+		}
+	}
+	return out
+}
+
+// forgetUserKV 删除用户的全部键，返回删除的键数
+func forgetUserKV(userID string) int {
+	kvMu.Lock()
+	defer kvMu.Unlock()
+
+	u, ok := kvStore[userID]
+	if !ok {
+		return 0
+	}
+	delete(kvStore, userID)
+	kvDirty = true
+	return len(u.entries)
+}
+
+// kvTotals 有键的用户数和键总数
+func kvTotals() (users, keys int) {
+	kvMu.Lock()
+	defer kvMu.Unlock()
+	for _, u := range kvStore {
+		if len(u.entries) > 0 {
+			users++
+			keys += len(u.entries)
+		}
+	}
+	return users, keys
+}
+
+// sendKVState identify 成功后给连接下发用户的全量键值；匿名访客没有 KV
+func sendKVState(c *Client, userID string) {
+	if !GlobalConfig.KV.Enabled || strings.HasPrefix(userID, visitorUserPrefix) {
+		return
+	}
+	if err := c.deliver(WSMessage{Event: kvStateEvent, Data: userKVEntries(userID)}); err != nil {
+		log.Printf("⚠️ KV 状态下发失败 conn=%s: %v\n", c.id, err)
+	}
+}
+
+// ===== 客户端写入 =====
+
+// isKVClientEvent 是否是客户端的 KV 写入事件
+func isKVClientEvent(event string) bool {
+	return event == kvSetEvent || event == kvDeleteEvent
+}
+
+// handleKVClientEvent 处理 kv_set / kv_delete，返回 false 表示应断开连接
+func handleKVClientEvent(c *Client, event string, raw json.RawMessage) bool {
+	var req struct {
+		Key   string          `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	if len(raw) == 0 {
+		return rejectInbound(c, &clientError{Code: errCodeMissingField, Msg: "data is required", Field: "data"})
+	}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return rejectInbound(c, &clientError{Code: errCodeInvalidType, Msg: "data must be an object", Field: "data"})
+	}
+	if !validKVKey(req.Key) {
+		return rejectInbound(c, &clientError{Code: errCodeInvalidValue, Msg: "invalid key", Field: "data.key"})
+	}
+	if event == kvSetEvent && len(req.Value) == 0 {
+		return rejectInbound(c, &clientError{Code: errCodeMissingField, Msg: "value is required", Field: "data.value"})
+	}
+	if rejectReadOnly(c, event) {
+		return true
+	}
+
+	userID := c.userID
+	if userID == "" || strings.HasPrefix(userID, visitorUserPrefix) {
+		kvRejected.Add(1)
+		return sendClientError(c, &clientError{Code: errCodeKVForbidden, Msg: "identify before writing kv", Field: "data.key"}) == nil
+	}
+	if !clientWritableKey(req.Key) {
+		kvRejected.Add(1)
+		return sendClientError(c, &clientError{Code: errCodeKVForbidden, Msg: "key is not writable by clients", Field: "data.key"}) == nil
+	}
+
+	if event == kvDeleteEvent {
+		deleteKV(userID, req.Key, kvSourceClient)
+		return true
+	}
+	if _, err := setKV(userID, req.Key, req.Value, kvSourceClient); err != nil {
+		return sendClientError(c, &clientError{Code: errCodeKVQuotaExceeded, Msg: err.Error(), Field: "data.value"}) == nil
+	}
+	return true
+}
+
+// ===== 管理接口 =====
+
+// userKVHandler GET /api/users/{id}/kv
+func userKVHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{
+			"user_id": userID,
+			"entries": userKVEntries(userID),
+		},
+	})
+}
+
+// userKVSetHandler PUT /api/users/{id}/kv/{key}，请求体就是值（任意 JSON）
+func userKVSetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, key := r.PathValue("id"), r.PathValue("key")
+	if !validKVKey(key) {
+		writeValidationProblem(w, r, "key", "key must be 1-"+strconv.Itoa(GlobalConfig.KV.MaxKeyLength)+" characters of [A-Za-z0-9_.:-]")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	if !json.Valid(body) {
+		writeProblem(w, r, http.StatusBadRequest, problemInvalidJSON, "request body is not valid JSON")
+		return
+	}
+
+	entry, err := setKV(userID, key, body, kvSourceAPI)
+	switch {
+	case errors.Is(err, errKVValueTooBig):
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, problemPayloadTooLarge,
+			"value exceeds "+strconv.Itoa(GlobalConfig.KV.MaxValueBytes)+" bytes")
+		return
+	case errors.Is(err, errKVTooManyKeys):
+		writeProblem(w, r, http.StatusConflict, problemQuotaExceeded,
+			"user already has "+strconv.Itoa(GlobalConfig.KV.MaxKeys)+" keys")
+		return
+	case err != nil:
+		writeProblem(w, r, http.StatusBadRequest, problemInvalidJSON, "request body is not valid JSON")
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{
+			"user_id": userID,
+			"key":     key,
+			"entry":   entry,
+		},
+	})
+}
+
+// userKVDeleteHandler DELETE /api/users/{id}/kv/{key}
+func userKVDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, key := r.PathValue("id"), r.PathValue("key")
+	if !deleteKV(userID, key, kvSourceAPI) {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "key not found")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{"user_id": userID, "key": key},
+	})
+}
+
+// ===== 落盘 =====
+
+func kvStorePath() string {
+	if GlobalConfig.KV.StoreFile == "" {
+		return ""
+	}
+	return filepath.Join(getCurrentDir(), GlobalConfig.KV.StoreFile)
+}
+
+// loadKV 启动时从文件恢复键值
+func loadKV() {
+	path := kvStorePath()
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 读取 KV 数据失败 %s: %v\n", path, err)
+		}
+		return
+	}
+
+	var stored map[string]storedUserKV
+	if err := json.Unmarshal(data, &stored); err != nil {
+		log.Printf("⚠️ 解析 KV 数据失败 %s: %v\n", path, err)
+		return
+	}
+
+	kvMu.Lock()
+	defer kvMu.Unlock()
+	total := 0
+	for userID, s := range stored {
+		u := &userKV{entries: make(map[string]*KVEntry, len(s.Entries)), version: s.Version}
+		for key, e := range s.Entries {
+			e := e
+			u.entries[key] = &e
+			if e.Version > u.version {
+				u.version = e.Version
+			}
+		}
+		kvStore[userID] = u
+		total += len(s.Entries)
+	}
+	log.Printf("✅ 已恢复 KV 数据：%d 个用户，%d 个键\n", len(stored), total)
+}
+
+// kvSaveLoop 有变更时定期落盘
+func kvSaveLoop() {
+	path := kvStorePath()
+	if path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(kvSaveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		kvMu.Lock()
+		if !kvDirty {
+			kvMu.Unlock()
+			continue
+		}
+		snapshot := make(map[string]storedUserKV, len(kvStore))
+		for userID, u := range kvStore {
+			entries := make(map[string]KVEntry, len(u.entries))
+			for key, e := range u.entries {
+				entries[key] = *e
+			}
+			snapshot[userID] = storedUserKV{Version: u.version, Entries: entries}
+		}
+		kvDirty = false
+		kvMu.Unlock()
+
+		if err := writeFileAtomic(path, snapshot); err != nil {
+			log.Printf("❌ KV 数据落盘失败 %s: %v\n", path, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 连接最大存活时间 =====
+//
+// 长连接一挂就是几周，负载均衡扩容后新节点分不到连接，老节点的内存碎片也越积越多。
+// 开启后每个连接在 max_age_seconds 附近（减去一个 0 ~ jitter_seconds 的随机量，避免同一批连接同时到期）
+// 收到 reconnect 事件，data 中的 delay_ms 是建议的重连等待时间（0 ~ reconnect_jitter_ms 随机），
+// grace_seconds 后服务端以 1001 (Going Away) 关闭连接。
+
+// ConnLifetimeConfig 连接最大存活时间配置
+type ConnLifetimeConfig struct {
+	Enabled           bool `json:"enabled"`
+	MaxAgeSeconds     int  `json:"max_age_seconds"`     // 连接最长存活时间
+	JitterSeconds     int  `json:"jitter_seconds"`      // 到期时间的随机提前量，默认 max_age 的 10%
+	ReconnectJitterMs int  `json:"reconnect_jitter_ms"` // 建议客户端重连前随机等待的上限，默认 5000
+	GraceSeconds      int  `json:"grace_seconds"`       // 下发 reconnect 后多久关闭，默认 5
+}
+
+const (
+	lifetimeDefaultReconnectJitterMs = 5000
+	lifetimeDefaultGraceSeconds      = 5
+	lifetimeCheckInterval            = 5 * time.Second
+)
+
+// initConnLifetime 填默认值并启动回收循环
+func initConnLifetime() {
+	cfg := &GlobalConfig.ConnLifetime
+	if cfg.MaxAgeSeconds <= 0 {
+		log.Fatalln("❌ conn_lifetime 需要 max_age_seconds")
+	}
+	if cfg.JitterSeconds <= 0 {
+		cfg.JitterSeconds = cfg.MaxAgeSeconds / 10
+	}
+	if cfg.ReconnectJitterMs <= 0 {
+		cfg.ReconnectJitterMs = lifetimeDefaultReconnectJitterMs
+	}
+	if cfg.GraceSeconds <= 0 {
+		cfg.GraceSeconds = lifetimeDefaultGraceSeconds
+	}
+	go connLifetimeLoop()
+	log.Printf("✅ 连接最大存活时间已启用：%ds（随机提前 0~%ds）\n", cfg.MaxAgeSeconds, cfg.JitterSeconds)
+}
+
+// connRecycleAt 计算连接的回收时间，newClient 时调用一次
+func connRecycleAt(connectedAt time.Time) time.Time {
+	cfg := GlobalConfig.ConnLifetime
+	if !cfg.Enabled || cfg.MaxAgeSeconds <= 0 {
+		return time.Time{}
+	}
+	age := time.Duration(cfg.MaxAgeSeconds) * time.Second
+	if cfg.JitterSeconds > 0 {
+		age -= rand.N(time.Duration(cfg.JitterSeconds) * time.Second)
+	}
+	return connectedAt.Add(age)
+}
+
+func connLifetimeLoop() {
+	ticker := time.NewTicker(lifetimeCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+
+		due := defaultHub.clientsMatching(func(c *Client) bool {
+			return !c.recycleAt.IsZero() && now.After(c.recycleAt) && !c.recycling.Load()
+		})
+
+		for _, c := range due {
+			recycleClient(c)
+		}
+	}
+}
+
+// recycleClient 提示客户端重连，宽限期后关闭
+func recycleClient(c *Client) {
+	if !c.recycling.CompareAndSwap(false, true) {
+		return
+	}
+	cfg := GlobalConfig.ConnLifetime
+	delay := rand.IntN(cfg.ReconnectJitterMs + 1)
+	log.Printf("♻️ 连接 %s 已存活 %s，提示重连（建议等待 %dms）\n", c.id, time.Since(c.connectedAt).Round(time.Second), delay)
+
+	_ = c.deliver(WSMessage{
+		Event: "reconnect",
+		Data: map[string]interface{}{
+			"reason":   "max_age",
+			"delay_ms": delay,
+		},
+	})
+	time.AfterFunc(time.Duration(cfg.GraceSeconds)*time.Second, func() {
+		c.closeWithCode(websocket.CloseGoingAway, "max connection age reached")
+	})
+}
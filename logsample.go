@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+)
+
+// ===== 按事件的日志采样 =====
+//
+// cursor.move、typing 这类高频事件每条都打日志会把日志淹没。log_sampling 配置「事件名模式 → N」，
+// 命中的事件每 N 条只记 1 条（第 1 条、第 N+1 条……），不影响投递。模式支持 * / ? 通配（path.Match 语法），
+// 精确匹配优先于通配。初始值来自配置，可通过 PUT /api/admin/log-sampling 在运行时修改（只保存在内存中）。
+// 采样作用于推送 / 上行事件的逐条日志；连接、订阅、错误等日志始终输出。
+
+// logCountersMax 计数器表上限，客户端可以发任意事件名，超过就清空重新计数
+const logCountersMax = 10000
+
+var (
+	logSamplingMu sync.RWMutex
+	logSampling   = make(map[string]int)            // 事件名模式 -> N
+	logCounters   = make(map[string]*atomic.Uint64) // 事件名 -> 已见条数
+)
+
+// initLogSampling 用配置中的初始值填充采样表
+func initLogSampling(initial map[string]int) {
+	logSamplingMu.Lock()
+	defer logSamplingMu.Unlock()
+	for k, n := range initial {
+		if _, err := path.Match(k, ""); err != nil {
+			log.Printf("⚠️ log_sampling 模式无效，已忽略: %s\n", k)
+			continue
+		}
+		if n > 1 {
+			logSampling[k] = n
+		}
+	}
+}
+
+// shouldLogEvent 该事件的这一条是否要打日志
+func shouldLogEvent(event string) bool {
+	logSamplingMu.RLock()
+	n, ok := logSampling[event]
+	if !ok {
+		for pattern, v := range logSampling {
+			if matched, _ := path.Match(pattern, event); matched {
+				n = v
+				break
+			}
+		}
+	}
+	counter := logCounters[event]
+	logSamplingMu.RUnlock()
+
+	if n <= 1 {
+		return true
+	}
+	if counter == nil {
+		logSamplingMu.Lock()
+		if counter = logCounters[event]; counter == nil {
+			if len(logCounters) >= logCountersMax {
+				logCounters = make(map[string]*atomic.Uint64)
+			}
+			counter = new(atomic.Uint64)
+			logCounters[event] = counter
+		}
+		logSamplingMu.Unlock()
+	}
+	return (counter.Add(1)-1)%uint64(n) == 0
+}
+
+// currentLogSampling 返回当前采样表的拷贝
+func currentLogSampling() map[string]int {
+	logSamplingMu.RLock()
+	defer logSamplingMu.RUnlock()
+	out := make(map[string]int, len(logSampling))
+	for k, v := range logSampling {
+		out[k] = v
+	}
+	return out
+}
+
+// updateLogSampling 合并更新采样表，值为 null 或 <= 1 表示删除（恢复逐条记录）
+func updateLogSampling(changes map[string]*int) {
+	logSamplingMu.Lock()
+	defer logSamplingMu.Unlock()
+	for k, n := range changes {
+		if n == nil || *n <= 1 {
+			delete(logSampling, k)
+			continue
+		}
+		logSampling[k] = *n
+	}
+	// 计数器按事件名累计，规则变了就从头数，避免旧计数让新规则的第一条被跳过
+	logCounters = make(map[string]*atomic.Uint64)
+}
+
+// adminLogSamplingHandler GET 查询 / PUT 合并更新日志采样规则
+func adminLogSamplingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var changes map[string]*int
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, problemInvalidJSON, "values must be integers or null")
+			return
+		}
+		for pattern := range changes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				writeValidationProblem(w, r, pattern, "invalid pattern: "+pattern)
+				return
+			}
+		}
+		updateLogSampling(changes)
+		log.Println("🎚️ 日志采样规则更新:", toJSON(currentLogSampling()))
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": currentLogSampling(),
+	})
+}
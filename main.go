@@ -2,13 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -22,10 +25,124 @@ const (
 
 // Config 结构体定义了配置文件中的字段
 type Config struct {
+	ConfigVersion int `json:"config_version"` // 配置格式版本，旧版本加载时会自动迁移，见 configmigrate.go
+
+	StrictConfig bool `json:"strict_config"` // 配置有任何问题都拒绝启动，而不是回退默认值（见 configcheck.go）
+
+	AllowInsecureDefaultKey bool `json:"allow_insecure_default_key"` // 生产模式下仍允许使用内置默认 API Key（见 apikey.go）
+
 	Port     string `json:"port"`
 	APIKey   string `json:"api_key"`
 	WSPath   string `json:"ws_path"`
 	PushPath string `json:"push_path"` // 新增：HTTP 推送接口路径
+
+	// 拒绝 ?api_key= 方式的认证（URL 中的 key 会泄露到代理日志和浏览器历史），只接受请求头
+	DisableQueryAPIKey bool `json:"disable_query_api_key"`
+
+	Auth AuthConfig `json:"auth"` // 可选：push / admin 接口的认证链，默认只校验 api_key
+	OIDC OIDCConfig `json:"oidc"` // 可选：管理后台 OIDC 登录
+
+	ClientJWT  ClientJWTConfig  `json:"client_jwt"`  // 可选：客户端 token 必须是 OIDC / JWT access token
+	AuthExpiry AuthExpiryConfig `json:"auth_expiry"` // 可选：连接认证过期与强制重新 identify
+
+	ConnLifetime ConnLifetimeConfig `json:"conn_lifetime"` // 可选：连接最大存活时间，到期提示重连
+
+	Cluster ClusterConfig `json:"cluster"` // 可选：集群节点间接口（连接迁移等）
+
+	ProxyProtocol ProxyProtocolConfig `json:"proxy_protocol"` // 可选：监听器接受 PROXY protocol v1/v2 头
+
+	Server ServerConfig `json:"server"` // 可选：HTTPS / HTTP/2、SO_REUSEPORT、超时等监听设置
+	TCP    TCPConfig    `json:"tcp"`    // 可选：原始 TCP 传输（长度前缀 JSON），给没有 WebSocket 栈的设备用
+	GRPC   GRPCConfig   `json:"grpc"`   // 可选：gRPC 流量订阅接口（需要 HTTP/2）
+
+	Redaction RedactionConfig `json:"redaction"` // 可选：日志 / firehose / 归档中需要脱敏的字段
+	Metrics   MetricsConfig   `json:"metrics"`   // 可选：Prometheus 指标（按频道的订阅数和消息量）
+
+	Firehose FirehoseConfig `json:"firehose"` // 可选：/api/firehose 实时输出所有下发消息
+	Archive  ArchiveConfig  `json:"archive"`  // 可选：下发消息定期归档到 S3 / MinIO / 本地文件
+	Erasure  ErasureConfig  `json:"erasure"`  // 可选：DELETE /api/users/{id} 的数据删除策略
+
+	Pusher     PusherConfig     `json:"pusher"`     // 可选：Pusher 协议兼容端点
+	Centrifugo CentrifugoConfig `json:"centrifugo"` // 可选：Centrifugo 协议兼容端点
+	Phoenix    PhoenixConfig    `json:"phoenix"`    // 可选：Phoenix Channels 协议兼容端点
+	SignalR    SignalRConfig    `json:"signalr"`    // 可选：ASP.NET SignalR 协议兼容端点
+	SSE        SSEConfig        `json:"sse"`        // 可选：Server-Sent Events 传输
+
+	History HistoryConfig `json:"history"` // 可选：每用户最近消息缓存，用于断线补发
+
+	ChannelHistory ChannelHistoryConfig `json:"channel_history"` // 可选：每频道最近消息缓存，后加入的订阅者补看
+
+	OrderedDelivery OrderedDeliveryConfig `json:"ordered_delivery"` // 可选：同一用户的推送经过单一派发队列，不同来源之间不乱序
+
+	PresenceStream PresenceStreamConfig `json:"presence_stream"` // 可选：GET /api/presence/stream 推送用户上线 / 下线变化
+
+	SubscriptionTTL SubscriptionTTLConfig `json:"subscription_ttl"` // 原生订阅的 ttl 上限和默认有效期
+
+	PushCallbacks PushCallbacksConfig `json:"push_callbacks"` // 可选：推送请求带 callback_url 时把最终投递结果 POST 回去
+
+	Vault VaultConfig `json:"vault"` // 可选：从 HashiCorp Vault 读取密钥（vault:// 引用）
+	AWS   AWSConfig   `json:"aws"`   // 可选：从 AWS Secrets Manager / SSM 读取密钥（awssm:// / ssm:// 引用）
+
+	Signing     SigningConfig     `json:"signing"`      // 可选：下发消息的 Ed25519 签名
+	PushSigning PushSigningConfig `json:"push_signing"` // 可选：推送请求 HMAC 签名 + 防重放
+
+	IdentityCookie IdentityCookieConfig `json:"identity_cookie"` // 可选：匿名访客的持久身份 cookie
+	Devices        DevicesConfig        `json:"devices"`         // 可选：每用户设备 / 会话登记
+
+	// 升级请求中需要保留到连接元数据里的请求头，默认 User-Agent / X-App-Version / Accept-Language
+	MetadataHeaders []string `json:"metadata_headers"`
+
+	MinClientVersion     string `json:"min_client_version"`     // 可选：最低客户端版本，低于该版本的连接会被要求升级并断开
+	RequireClientVersion bool   `json:"require_client_version"` // 开启后未上报版本的连接也视为过低
+
+	Flags map[string]interface{} `json:"flags"` // 可选：功能开关初始值，随 hello 下发
+
+	LogSampling map[string]int `json:"log_sampling"` // 可选：按事件名采样日志，如 {"cursor.move": 1000} 表示每 1000 条记 1 条
+
+	Inbound InboundConfig `json:"inbound"` // 可选：原生协议上行帧的大小 / 长度限制
+
+	Welcome   WelcomeConfig   `json:"welcome"`   // 可选：断线续接
+	Heartbeat HeartbeatConfig `json:"heartbeat"` // 可选：心跳间隔 / 超时，可按客户端类别区分
+
+	SingleSession SingleSessionConfig `json:"single_session"` // 可选：同一用户重复 identify 时的策略
+
+	Jobs JobsConfig `json:"jobs"` // 可选：延迟推送任务落盘
+
+	Receipts ReceiptsConfig `json:"receipts"` // 可选：按消息记录每个连接的投递 / ack 结果
+
+	Bans BansConfig `json:"bans"` // 可选：按 IP / token 滥用计分，自动临时封禁
+
+	Admission AdmissionConfig `json:"admission"` // 可选：连接升级前调用业务后端的准入 webhook
+
+	Namespaces []NamespaceConfig `json:"namespaces"` // 可选：多个 WebSocket 路径，各自的 Origin 白名单和限制
+
+	InitialData InitialDataConfig `json:"initial_data"` // 可选：identify 后从业务后端拉取初始数据作为第一个事件下发
+
+	Transforms []TransformRule `json:"transforms"` // 可选：按客户端声明的能力降级下发的消息结构
+
+	PushQueue PushQueueConfig `json:"push_queue"` // 可选：立即推送先入有界队列，由 worker 异步发送
+
+	Backpressure BackpressureConfig `json:"backpressure"` // 可选：队列 / 内存饱和时推送接口返回 429 / 503
+
+	ConnectRate ConnectRateConfig `json:"connect_rate"` // 可选：按 IP / 全局限制新连接速率，防止重连风暴
+
+	ReconnectAdvice ReconnectAdviceConfig `json:"reconnect_advice"` // 可选：在 hello 和服务端关闭原因里下发重连退避建议
+
+	ConsistencySweepSeconds int `json:"consistency_sweep_seconds"` // 注册表一致性巡检间隔，默认 300，-1 关闭
+
+	KV KVConfig `json:"kv"` // 可选：每用户键值状态，identify 时下发、修改时推给用户的所有连接
+
+	Occupancy OccupancyConfig `json:"occupancy"` // 可选：频道有人 / 没人订阅时通知业务后端
+
+	Groups GroupsConfig `json:"groups"` // 可选：业务后端通过接口管理的具名连接组，推送时按组名发送
+
+	AckRetry AckRetryConfig `json:"ack_retry"` // 可选：require_ack 推送等待客户端确认，超时按退避重发
+
+	Expiry ExpiryConfig `json:"expiry"` // 可选：空闲 / 超龄的频道和组自动清理，过期时回调业务后端
+
+	Aggregation AggregationConfig `json:"aggregation"` // 可选：高频上行事件按连接攒批，每个窗口合并成一次 webhook
+
+	OfflineQueue OfflineQueueConfig `json:"offline_queue"` // 可选：单用户推送时用户不在线先入队，下次 identify 时补发
 }
 
 // GlobalConfig 存储加载或生成的配置
@@ -44,10 +161,11 @@ func getEnv(key, def string) string {
 // getDefaultConfig 返回默认配置，同时考虑了环境变量
 func getDefaultConfig() Config {
 	return Config{
+		ConfigVersion: CurrentConfigVersion,
 		// 默认端口 3000
 		Port: getEnv("PORT", "3000"),
-		// 默认 API Key
-		APIKey: getEnv("RELAY_API_KEY", "U2FsdGVkX18ucQzBA+ozhc3ySrVZ"),
+		// API Key：未通过环境变量指定时随机生成，不再使用内置默认值
+		APIKey: getEnv("RELAY_API_KEY", generateAPIKey()),
 		// 默认 WebSocket 路径
 		WSPath: getEnv("WS_PATH", "/ws"),
 		// 默认 Push 接口路径
@@ -77,21 +195,38 @@ func getCurrentDir() string {
 
 // loadOrCreateConfig 尝试加载配置，如果不存在则创建默认配置，并确保关键字段非空
 func loadOrCreateConfig() {
+	defaultCfg := getDefaultConfig()
+
+	// 0. 设置了 RELAY_CONFIG_SOURCE 时整份配置从外部来源拉取（awssm:// / ssm:// / file:// 等），不读写本地文件
+	if src := os.Getenv("RELAY_CONFIG_SOURCE"); src != "" {
+		loadConfigFromSource(src, defaultCfg)
+		postProcessConfig(defaultCfg)
+		return
+	}
+
 	// configPath 使用 getCurrentDir() 来确定位置
 	configPath := filepath.Join(getCurrentDir(), ConfigFileName)
 	log.Printf("尝试从路径加载配置: %s\n", configPath)
 
-	defaultCfg := getDefaultConfig()
-
 	// 1. 尝试加载配置
 	data, err := os.ReadFile(configPath)
 	if err == nil {
+		// 旧版本配置先在内存中迁移到当前格式，文件不动（relay migrate-config 才写回）
+		if migrated, fromVersion, changed, mErr := migrateConfig(data); mErr != nil {
+			log.Printf("⚠️ 配置迁移失败，按原内容加载！错误: %v\n", mErr)
+		} else if changed {
+			log.Printf("⚠️ 配置文件为 v%d，已在内存中迁移到 v%d；运行 relay migrate-config 可写回文件\n", fromVersion, CurrentConfigVersion)
+			data = migrated
+		}
+
 		// 成功读取，解析 JSON
 		if err := json.Unmarshal(data, &GlobalConfig); err != nil {
 			log.Printf("⚠️ 配置解析失败，将使用默认配置！错误: %v\n", err)
+			noteConfigProblem("配置文件解析失败: " + err.Error())
 			GlobalConfig = defaultCfg
 		} else {
 			log.Println("✅ 成功加载配置！")
+			warnUnknownConfigKeys(data, GlobalConfig)
 		}
 	} else {
 		// 2. 配置不存在或读取失败，创建默认配置
@@ -108,61 +243,238 @@ func loadOrCreateConfig() {
 				log.Printf("❌ 无法写入默认配置文件 %s: %v\n", configPath, err)
 			} else {
 				log.Printf("🎉 已创建默认配置文件: %s\n", configPath)
+				if os.Getenv("RELAY_API_KEY") == "" {
+					log.Printf("🔑 已随机生成 API Key 并写入配置文件（指纹 %s）\n", keyFingerprint(GlobalConfig.APIKey))
+				}
 			}
 		}
 	}
 
+	postProcessConfig(defaultCfg)
+}
+
+// postProcessConfig 解析密钥引用并补齐默认值
+func postProcessConfig(defaultCfg Config) {
+	// Vault 需要在解析 vault:// 引用之前登录
+	if GlobalConfig.Vault.Enabled {
+		if GlobalConfig.Vault.RefreshSeconds <= 0 {
+			GlobalConfig.Vault.RefreshSeconds = vaultDefaultRefreshSeconds
+		}
+		if err := initVault(GlobalConfig.Vault); err != nil {
+			log.Fatalf("❌ Vault 初始化失败 %v\n", err)
+		}
+	}
+
+	// 配置中的密钥引用（env:// / file:// / exec://）在这里解析，解析失败直接退出，避免把引用本身当成密钥
+	if err := resolveConfigSecrets(&GlobalConfig); err != nil {
+		log.Fatalf("❌ 密钥引用解析失败 %v\n", err)
+	}
+
 	// 4. 配置后处理：强制检查关键字段是否为空，防止 ServeMux panic
 	if GlobalConfig.Port == "" {
 		GlobalConfig.Port = defaultCfg.Port
 		log.Printf("⚠️ 配置中的 Port 字段为空，已回退使用默认值: %s\n", GlobalConfig.Port)
+		noteConfigProblem("port 为空")
 	}
 	if GlobalConfig.WSPath == "" {
 		GlobalConfig.WSPath = defaultCfg.WSPath
 		log.Printf("⚠️ 配置中的 WSPath 字段为空，已回退使用默认值: %s\n", GlobalConfig.WSPath)
+		noteConfigProblem("ws_path 为空")
 	}
 	if GlobalConfig.APIKey == "" {
 		GlobalConfig.APIKey = defaultCfg.APIKey
-		log.Printf("⚠️ 配置中的 APIKey 字段为空，已回退使用默认值: [隐藏值]\n")
+		log.Printf("⚠️ 配置中的 APIKey 字段为空，已回退使用默认值（指纹 %s）\n", keyFingerprint(GlobalConfig.APIKey))
+		noteConfigProblem("api_key 为空")
 	}
 	if GlobalConfig.PushPath == "" {
 		GlobalConfig.PushPath = defaultCfg.PushPath
 		log.Printf("⚠️ 配置中的 PushPath 字段为空，已回退使用默认值: %s\n", GlobalConfig.PushPath)
+		noteConfigProblem("push_path 为空")
+	}
+	if p := GlobalConfig.Pusher; p.Enabled && (p.AppID == "" || p.Key == "" || p.Secret == "") {
+		GlobalConfig.Pusher.Enabled = false
+		log.Println("⚠️ Pusher 兼容端点缺少 app_id / key / secret，已禁用")
+		noteConfigProblem("pusher 已开启但缺少 app_id / key / secret")
+	}
+	if GlobalConfig.Centrifugo.Enabled && GlobalConfig.Centrifugo.Path == "" {
+		GlobalConfig.Centrifugo.Path = centrifugoDefaultPath
+	}
+	if GlobalConfig.Phoenix.Path == "" {
+		GlobalConfig.Phoenix.Path = phoenixDefaultPath
+	}
+	if GlobalConfig.Phoenix.UserTopic == "" {
+		GlobalConfig.Phoenix.UserTopic = phoenixDefaultUserTopic
+	}
+	if GlobalConfig.SignalR.Path == "" {
+		GlobalConfig.SignalR.Path = signalRDefaultPath
+	}
+	if GlobalConfig.SSE.Path == "" {
+		GlobalConfig.SSE.Path = sseDefaultPath
 	}
+	if GlobalConfig.AWS.RefreshSeconds == 0 {
+		GlobalConfig.AWS.RefreshSeconds = awsDefaultRefreshSeconds
+	}
+	if GlobalConfig.History.Size > 0 && GlobalConfig.History.TTLSeconds <= 0 {
+		GlobalConfig.History.TTLSeconds = historyDefaultTTLSeconds
+	}
+	prepareInbound(&GlobalConfig.Inbound)
+	prepareWelcome(&GlobalConfig.Welcome)
+	prepareHeartbeat(&GlobalConfig.Heartbeat)
+	prepareSingleSession(&GlobalConfig.SingleSession)
+	prepareJobs(&GlobalConfig.Jobs)
+	prepareReceipts(&GlobalConfig.Receipts)
+	prepareBans(&GlobalConfig.Bans)
+	prepareAdmission(&GlobalConfig.Admission)
+	prepareNamespaces(GlobalConfig.Namespaces)
+	prepareInitialData(&GlobalConfig.InitialData)
+	prepareTransforms(GlobalConfig.Transforms)
+	preparePushQueue(&GlobalConfig.PushQueue)
+	prepareBackpressure(&GlobalConfig.Backpressure)
+	prepareConnectRate(&GlobalConfig.ConnectRate)
+	prepareReconnectAdvice(&GlobalConfig.ReconnectAdvice)
+	prepareConsistencySweep(&GlobalConfig.ConsistencySweepSeconds)
+	prepareKV(&GlobalConfig.KV)
+	prepareOccupancy(&GlobalConfig.Occupancy)
+	prepareGroups(&GlobalConfig.Groups)
+	prepareAckRetry(&GlobalConfig.AckRetry)
+	prepareExpiry(&GlobalConfig.Expiry)
+	prepareAggregation(&GlobalConfig.Aggregation)
+	prepareChannelHistory(&GlobalConfig.ChannelHistory)
+	prepareSubscriptionTTL(&GlobalConfig.SubscriptionTTL)
+	preparePushCallbacks(&GlobalConfig.PushCallbacks)
+	prepareOfflineQueue(&GlobalConfig.OfflineQueue)
+	if GlobalConfig.MetadataHeaders == nil {
+		GlobalConfig.MetadataHeaders = defaultMetadataHeaders
+	}
+	if GlobalConfig.IdentityCookie.Enabled {
+		prepareIdentityCookie(&GlobalConfig.IdentityCookie)
+	}
+	if GlobalConfig.Signing.Enabled {
+		prepareSigning(&GlobalConfig.Signing)
+	}
+	if p := GlobalConfig.PushSigning; p.Enabled && p.Secret == "" {
+		log.Fatalln("❌ push_signing 已开启但未配置 secret")
+	}
+	if GlobalConfig.PushSigning.MaxSkewSeconds <= 0 {
+		GlobalConfig.PushSigning.MaxSkewSeconds = pushSigningDefaultSkew
+	}
+
+	validateConfig()
+	enforceStrictConfig()
 }
 
 // ===== WebSocket 客户端结构 =====
 
+// clientConn 连接的底层传输，*websocket.Conn 直接满足；SSE 等其它传输实现同样的方法即可进 hub
+type clientConn interface {
+	WriteJSON(v interface{}) error
+	WriteMessage(messageType int, data []byte) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
 type Client struct {
-	conn   *websocket.Conn
+	conn   clientConn
 	mu     sync.Mutex // 写锁，保证多 goroutine 写同一个 conn 安全
+	id     string     // 连接 ID，格式 "数字.数字"（兼容 Pusher socket_id）
 	userID string     // 这里存的是“用户标识”，可以是 user_id 或 token 对应的id
+
+	visitorID string // 访客身份 cookie 中的 ID，未开启时为空
+
+	device      *DeviceInfo // 设备描述（identify 上报或从 User-Agent 解析）
+	deviceUser  string      // 设备当前登记在哪个用户下，受 devicesMu 保护
+	deviceRegID string      // 当前登记的设备 ID，受 devicesMu 保护
+
+	channels map[string]struct{} // 已订阅的频道，受 Hub.channelsMu 保护
+
+	meta        map[string]string // 升级请求中采集的请求头（见 metadata_headers），只读
+	connectedAt time.Time
+	remoteAddr  string
+
+	e2eBinary bool // 原生连接带 ?e2e=binary：加密载荷按二进制帧下发
+
+	codec Codec // 原生连接握手时协商的非 JSON 线上格式（见 codec.go），nil 表示 JSON
+
+	caps map[string]bool // 连接声明且服务端认识的能力（见 transform.go），只读
+
+	namespace *namespace // 从命名空间路径连入时非空（见 namespace.go）
+
+	abuse    abuseScore // 上行被拒绝的累计分数（见 inbound.abuse_threshold）
+	banToken string     // identify 成功时 token 的封禁标识（见 bans.go），未 identify 为空
+
+	heartbeat heartbeatPolicy // 协商后的心跳参数，在 hello / welcome 中下发
+
+	recycleAt time.Time   // 到达最大存活时间的时刻（见 conn_lifetime），零值表示不回收
+	recycling atomic.Bool // 已下发 reconnect，等待关闭
+
+	resumeToken  string      // welcome 中下发的断线续接凭证，为空表示该连接不支持续接
+	serverClosed atomic.Bool // 服务端主动关闭（closeWithCode），这类连接断开后不保留续接会话
+
+	pushHold   atomic.Bool        // identify 后补发积压消息期间为 true，推送先攒着（见 holdPushes）
+	heldPushes []*outboundMessage // 补发期间攒下的推送，受 mu 保护
+
+	// frame 把标准 WSMessage 转成该连接协议的出站帧，nil 表示原生 {event,data} 格式
+	frame func(WSMessage) interface{}
+	// frameKey 标识 frame 的编码结果只取决于消息本身，同一 key 的连接共享一次推送的编码结果（见 outbound.go）；
+	// 为空时每个连接单独编码
+	frameKey string
+}
+
+// newClient 基于升级请求创建连接对象，统一采集设备描述和请求头元数据
+func newClient(conn clientConn, r *http.Request) *Client {
+	now := time.Now()
+	return &Client{
+		conn:        conn,
+		id:          newConnID(),
+		device:      deviceFromRequest(r),
+		meta:        captureMetadata(r),
+		caps:        capabilitiesFromRequest(r),
+		connectedAt: now,
+		remoteAddr:  r.RemoteAddr,
+		recycleAt:   connRecycleAt(now),
+	}
 }
 
-// ===== 分组：所有连接 + 用户分组 =====
+// ===== 连接 ID =====
 
 var (
-	allClientsMu sync.RWMutex
-	allClients   = make(map[*Client]struct{})
-
-	userClientsMu sync.RWMutex
-	userClients   = make(map[string]map[*Client]struct{})
+	connIDPrefix = rand.Int64N(1_000_000_000)
+	connIDSeq    atomic.Uint64
 )
 
+func newConnID() string {
+	return fmt.Sprintf("%d.%d", connIDPrefix, connIDSeq.Add(1))
+}
+
 // ===== WebSocket upgrader =====
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// 简单放行，生产可以根据域名限制
-		return true
+	// ws_path 简单放行；命名空间按各自的 allowed_origins 检查
+	CheckOrigin: checkOrigin,
+	// 与 gorilla 默认的错误响应一致，额外把握手失败记入滥用计分
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		recordRequestAbuse(r, banSignalUpgrade)
+		w.Header().Set("Sec-Websocket-Version", "13")
+		http.Error(w, http.StatusText(status), status)
 	},
 }
 
 // ===== WebSocket 消息格式 =====
 
 type WSMessage struct {
-	Event string      `json:"event"`
-	Data  interface{} `json:"data"`
+	ID      string      `json:"id,omitempty"` // 开启 receipts 或 require_ack 的推送消息才有，客户端用它回 ack
+	Event   string      `json:"event"`
+	Channel string      `json:"channel,omitempty"` // 频道消息才有
+	Seq     uint64      `json:"seq,omitempty"`     // 用户历史序号，开启 history 后单用户消息才有
+	Data    interface{} `json:"data"`
+	Ts      int64       `json:"ts,omitempty"`  // 开启 signing 后的签名时间戳（毫秒）
+	Sig     string      `json:"sig,omitempty"` // 开启 signing 后的 Ed25519 签名，见 signing.go
+
+	Ephemeral bool `json:"ephemeral,omitempty"` // 瞬时消息：不进历史、不需要 ack，见 ephemeral.go
+
+	RequireAck bool `json:"require_ack,omitempty"` // 客户端必须回 ack，否则超时重发，见 acks.go
+
+	Txn *txnInfo `json:"txn,omitempty"` // 多事件事务推送的位置，见 txn.go
 }
 
 type PingMessage struct {
@@ -171,7 +483,10 @@ type PingMessage struct {
 }
 
 type IdentifyData struct {
-	Token string `json:"token"`
+	Token   string      `json:"token"`
+	Device  *DeviceInfo `json:"device,omitempty"`   // 可选：设备描述，覆盖从 User-Agent 解析出的信息
+	Version string      `json:"version,omitempty"`  // 可选：客户端版本，用于最低版本检查
+	LastSeq uint64      `json:"last_seq,omitempty"` // 可选：断线前收到的最后一个 seq，identify 成功后补发之后的消息
 }
 
 // 推送给前端 data 字段的结构
@@ -186,67 +501,48 @@ type PushRequest struct {
 	EventName    string      `json:"event_name"`
 	Subject      interface{} `json:"subject"`
 	DelaySeconds int         `json:"delay_seconds"`
+	DeliverAt    string      `json:"deliver_at"` // 可选：计划发送时间，RFC3339；配合 timezone 时为当地时间（不带时区偏移）
+	Timezone     string      `json:"timezone"`   // 可选：IANA 时区名，如 Asia/Shanghai
 	Token        interface{} `json:"token"`
-}
+	DeviceID     string      `json:"device_id"` // 可选：只推给该用户的这台设备
+	ClientID     string      `json:"client_id"` // 可选：只推给该用户的这个连接
 
-// ===== 连接管理 =====
+	// 可选：按连接元数据过滤，key 为请求头名（不区分大小写），值以 * 结尾时按前缀匹配
+	Selector map[string]string `json:"selector"`
 
-func addClient(c *Client) {
-	allClientsMu.Lock()
-	allClients[c] = struct{}{}
-	total := len(allClients)
-	allClientsMu.Unlock()
+	// 可选：只发给该命名空间的连接
+	Namespace string `json:"namespace"`
 
-	log.Printf("🔌 新连接接入，当前 allClients 数量: %d\n", total)
-}
+	// 可选：端到端加密载荷（base64），中继不解析、不记录，原样下发；需配合 key_id，不能和 subject 同时使用
+	Ciphertext string `json:"ciphertext"`
+	KeyID      string `json:"key_id"`
 
-func removeClient(c *Client) {
-	allClientsMu.Lock()
-	delete(allClients, c)
-	allClientsMu.Unlock()
+	// 可选：瞬时消息（正在输入等），不进历史 / 队列 / 回执 / 归档，见 ephemeral.go
+	Ephemeral bool `json:"ephemeral"`
 
-	if c.userID != "" {
-		userClientsMu.Lock()
-		if set, ok := userClients[c.userID]; ok {
-			delete(set, c)
-			if len(set) == 0 {
-				delete(userClients, c.userID)
-			}
-		}
-		userClientsMu.Unlock()
-	}
-}
+	// 可选：发给频道（房间）内所有订阅连接，不能和 token / device_id / client_id / selector / namespace 同时使用
+	Channel string `json:"channel"`
 
-func registerUser(c *Client, userID string) {
-	if userID == "" {
-		return
-	}
+	// 可选：发给具名组内所有在线连接（见 groups.go），可以再用 selector / namespace 过滤
+	Group string `json:"group"`
 
-	// 先从旧 userID 解绑
-	if c.userID != "" && c.userID != userID {
-		userClientsMu.Lock()
-		if set, ok := userClients[c.userID]; ok {
-			delete(set, c)
-			if len(set) == 0 {
-				delete(userClients, c.userID)
-			}
-		}
-		userClientsMu.Unlock()
-	}
+	// 可选：要求原生客户端回 ack，超时按退避重发（需开启 ack_retry，见 acks.go）
+	RequireAck bool `json:"require_ack"`
 
-	c.userID = userID
+	// 可选：cron 表达式，登记为周期推送而不立即发送（见 cron.go），可配合 timezone
+	Cron string `json:"cron"`
 
-	userClientsMu.Lock()
-	set, ok := userClients[userID]
-	if !ok {
-		set = make(map[*Client]struct{})
-		userClients[userID] = set
-	}
-	set[c] = struct{}{}
-	total := len(set)
-	userClientsMu.Unlock()
+	// 可选：推送有了最终结果（发送完成 / 全部确认或失败）后 POST 到这个地址（需开启 push_callbacks，见 callbacks.go）
+	CallbackURL string `json:"callback_url"`
 
-	log.Printf("🆔 用户组注册完成 user_id=%s, 该用户连接数=%d\n", userID, total)
+	// 可选：多个事件作为一笔事务按顺序连续发给每个目标连接（见 txn.go），此时 event_name 可省略
+	Events []PushEvent `json:"events,omitempty"`
+
+	// 可选：广播 / 频道 / 组推送时跳过这些用户的所有连接，格式与 token 相同（见 multiuser.go）
+	ExcludeTokens []interface{} `json:"exclude_tokens,omitempty"`
+
+	// 可选：扇出完成后再响应，响应带 delivered；开启 push_queue 时入队后等 worker 发送完（见 pushqueue.go）
+	Sync bool `json:"sync,omitempty"`
 }
 
 // ===== 发送工具（轻度优化） =====
@@ -258,237 +554,562 @@ func (c *Client) sendJSON(v interface{}) error {
 	// 防止写操作无限阻塞，设置一个写超时时间（比如 10 秒）
 	_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 
+	if c.codec != nil {
+		f := encodeCodecFrame(c.codec, v)
+		if f.err != nil {
+			return f.err
+		}
+		return c.conn.WriteMessage(f.messageType, f.data)
+	}
 	return c.conn.WriteJSON(v)
 }
 
-func broadcastToAll(dataObj WSMessage) {
-	// 复制一份当前连接快照，避免长时间持有锁
-	allClientsMu.RLock()
-	if len(allClients) == 0 {
-		allClientsMu.RUnlock()
-		log.Println("📊 广播请求但当前无在线连接，跳过发送")
-		return
-	}
-	clients := make([]*Client, 0, len(allClients))
-	for c := range allClients {
-		clients = append(clients, c)
-	}
-	allClientsMu.RUnlock()
+// rawFrame 已经编码好的文本帧，deliver 时原样写出（用于非纯 JSON 帧的协议，比如 SignalR）
+type rawFrame []byte
 
-	for _, c := range clients {
-		if err := c.sendJSON(dataObj); err != nil {
-			log.Println("🧹 广播时发送失败，清理连接:", err)
-			c.conn.Close()
-			removeClient(c)
-		}
-	}
+func (c *Client) sendRaw(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	userClientsMu.RLock()
-	userCount := len(userClients)
-	userClientsMu.RUnlock()
-	log.Printf("📊 广播完成：当前 allClients=%d, userClients 用户数=%d\n", len(clients), userCount)
+	_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+
+	return c.conn.WriteMessage(websocket.TextMessage, b)
 }
 
-func emitToUser(userID string, dataObj WSMessage) {
-	userClientsMu.RLock()
-	set, ok := userClients[userID]
-	if !ok || len(set) == 0 {
-		userClientsMu.RUnlock()
-		log.Printf("🔍 未找到在线 user_id=%s，本次不推送\n", userID)
-		return
-	}
-	clients := make([]*Client, 0, len(set))
-	for c := range set {
-		clients = append(clients, c)
-	}
-	userClientsMu.RUnlock()
+// closeWithCode 发送带关闭码的关闭帧后断开连接，读循环随之退出并清理；
+// 期望客户端重连的关闭码会在 reason 后附上重连建议（见 reconnect.go）
+func (c *Client) closeWithCode(code int, reason string) {
+	c.serverClosed.Store(true)
+	c.mu.Lock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, closeReasonWithAdvice(code, reason)))
+	c.mu.Unlock()
 
-	for _, c := range clients {
-		if err := c.sendJSON(dataObj); err != nil {
-			log.Printf("🧹 单用户推送时发送失败，清理 user_id=%s: %v\n", userID, err)
-			c.conn.Close()
-			removeClient(c)
-		}
-	}
+	c.conn.Close()
+}
+
+// deliver 按连接协议发送一条标准消息
+func (c *Client) deliver(msg WSMessage) error {
+	return c.deliverOutbound(newOutbound(msg))
 }
 
 // ===== WebSocket 处理 =====
 
 func wsHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// 可选：访客身份 cookie，新访客会在升级响应里下发 Set-Cookie
+	visitorID, respHeader := visitorIdentity(r)
+	// 可选：按 Sec-WebSocket-Protocol 协商线上格式
+	codec, respHeader := negotiateCodec(r, respHeader)
+
+	conn, err := upgrader.Upgrade(w, r, withAffinityCookie(r, respHeader))
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
 		return
 	}
 
-	client := &Client{conn: conn}
-	addClient(client)
+	client := newClient(conn, r)
+	client.visitorID = visitorID
+	client.e2eBinary = r.URL.Query().Get("e2e") == "binary"
+	client.codec = codec
+	client.heartbeat = wsHeartbeat(clientClassFromRequest(r))
+	client.namespace = namespaceFromRequest(r)
 
-	// 可选：如果你前端在 URL 上带了 ?token=xxx，这里也可以直接注册
-	if token := r.URL.Query().Get("token"); token != "" {
-		log.Println("🔐 连接携带 token:", token)
-		registerUser(client, token)
+	if !enforceClientVersion(client, clientVersionFromRequest(r)) {
+		return
 	}
+	addClient(client)
 
 	defer func() {
 		conn.Close()
+		parkResumeSession(client)
 		removeClient(client)
 	}()
 
+	if err := sendHello(client); err != nil {
+		return
+	}
+	if err := sendWelcome(client, "websocket"); err != nil {
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	admitted := admittedUserID(r)
+	// 普通重连带 ?last_seq= 补发断线期间的单用户消息（迁移 / 续接在恢复会话时补）
+	after := lastSeqParam(r)
+	if GlobalConfig.History.Size == 0 {
+		after = 0
+	}
+	switch {
+	case GlobalConfig.Cluster.Enabled && r.URL.Query().Has(handoffTicketQueryKey) && restoreHandoff(client, r):
+		// 其他节点迁移过来的连接带 ?handoff=ticket，会话已恢复，不需要再 identify
+	case r.URL.Query().Has(resumeQueryKey) && restoreResume(client, r):
+		// 断线重连带 ?resume=token，恢复断开前的会话
+	case admitted != "":
+		// 准入 webhook 已指定用户，不需要再 identify
+		if err := registerAdmittedUser(client, admitted, after); err != nil {
+			sendInvalidToken(client, err)
+			return
+		}
+	case token != "":
+		// 可选：如果你前端在 URL 上带了 ?token=xxx，这里也可以直接注册
+		log.Println("🔐 连接携带 token:", logToken(token))
+		if _, err := identifyUserSince(client, token, after); err != nil {
+			sendInvalidToken(client, err)
+			return
+		}
+	case visitorID != "":
+		// 匿名访客先归到 visitor:{id} 分组，identify 后会切换到真正的用户组
+		registerUser(client, visitorUserID(visitorID))
+		if after > 0 {
+			if _, err := replayUserHistory(client, client.userID, after); err != nil {
+				return
+			}
+		}
+	}
+
+	armHeartbeat(conn, client.heartbeat)
 	for {
 		_, raw, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("⚠️ WebSocket read error:", err)
+			noteReadError(client, "WebSocket", err)
+			break
+		}
+		extendHeartbeat(conn, client.heartbeat)
+		if !handleNativeMessage(client, raw) {
 			break
 		}
+	}
+}
 
-		var pingMsg PingMessage
-		if err := json.Unmarshal(raw, &pingMsg); err == nil && pingMsg.Type == "ping" {
-			if err := conn.WriteJSON(PingMessage{Type: "pong", Ts: pingMsg.Ts}); err != nil {
-				log.Println("⚠️ WebSocket pong error:", err)
-				break
-			}
-			continue
+// handleNativeMessage 处理原生协议的一条上行消息（WebSocket / TCP 共用），返回 false 表示应断开连接
+func handleNativeMessage(client *Client, raw []byte) bool {
+	client.countInbound(len(raw))
+	if client.codec != nil {
+		var perr *clientError
+		if raw, perr = transcodeToJSON(client.codec, raw, inboundConfigFor(client).MaxFrameBytes); perr != nil {
+			log.Printf("⚠️ 上行消息无效 conn=%s: %v\n", client.id, perr)
+			return rejectInbound(client, perr)
 		}
+	}
+	msg, perr := parseInbound(raw, inboundConfigFor(client))
+	if perr != nil {
+		log.Printf("⚠️ 上行消息无效 conn=%s: %v\n", client.id, perr)
+		return rejectInbound(client, perr)
+	}
 
-		var msg WSMessage
-		if err := json.Unmarshal(raw, &msg); err != nil {
-			log.Println("⚠️ WebSocket message parse error:", err)
-			continue
+	if msg.Type == "ping" {
+		if err := client.sendJSON(PingMessage{Type: "pong", Ts: msg.Ts}); err != nil {
+			log.Println("⚠️ pong 发送失败:", err)
+			return false
 		}
+		return true
+	}
+	if msg.Type == "ack" {
+		ackReceipt(client, msg.ID)
+		ackAnnouncement(client, msg.ID)
+		if GlobalConfig.AckRetry.Enabled {
+			ackDeliveryFrom(client, msg.ID)
+		}
+		return true
+	}
+	if msg.Type == "subscribe" || msg.Type == "unsubscribe" {
+		return handleNativeSubscription(client, msg.Type, msg.Channel, msg.History, msg.TTL)
+	}
+	if GlobalConfig.KV.Enabled && isKVClientEvent(msg.Event) {
+		return handleKVClientEvent(client, msg.Event, msg.Data)
+	}
 
-		switch msg.Event {
-		case "identify":
-			// 解析 data.token（字符串）
-			raw, _ := json.Marshal(msg.Data)
-			var idData IdentifyData
-			if err := json.Unmarshal(raw, &idData); err != nil {
-				log.Println("identify 解析失败:", err)
-				continue
-			}
-			if idData.Token != "" {
-				log.Println("🆔 identify 收到 token:", idData.Token)
-				// 直接用 token 作为分组 key
-				registerUser(client, idData.Token)
-			} else {
-				log.Println("🆔 identify 收到空 token")
+	switch msg.Event {
+	case "identify":
+		idData, perr := parseIdentify(msg.Data)
+		if perr != nil {
+			log.Printf("⚠️ identify 解析失败 conn=%s: %v\n", client.id, perr)
+			return rejectInbound(client, perr)
+		}
+		if idData.Version != "" && !enforceClientVersion(client, idData.Version) {
+			return false
+		}
+		if idData.Device != nil {
+			client.device = mergeDeviceInfo(client.device, idData.Device)
+		}
+		if idData.Token != "" {
+			log.Println("🆔 identify 收到 token:", logToken(idData.Token))
+			// 直接用 token 作为分组 key（开启 client_jwt 时先校验，用户 ID 取自 claims）
+			if _, err := identifyUserSince(client, idData.Token, idData.LastSeq); err != nil {
+				sendInvalidToken(client, err)
 			}
-		default:
-			log.Printf("📨 [WS event] %s %v\n", msg.Event, msg.Data)
+		} else {
+			log.Println("🆔 identify 收到空 token")
 		}
+	default:
+		if e := inboundEventError(client, msg.Event); e != nil {
+			return rejectInbound(client, e)
+		}
+		if rejectReadOnly(client, msg.Event) {
+			return true
+		}
+		data := inboundData(msg.Data)
+		if shouldLogEvent(msg.Event) {
+			log.Printf("📨 [WS event] %s %s\n", msg.Event, redactLog(data))
+		}
+		publishInbound(client, "native", msg.Event, msg.Channel, data)
 	}
+	return true
 }
 
-// ===== API KEY 中间件 (使用 GlobalConfig) =====
+// ===== push 处理 =====
 
-func checkAPIKey(next http.Handler) http.Handler {
-	apiKey := GlobalConfig.APIKey // 从全局配置获取 API Key
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := r.Header.Get("X-API-KEY")
-		if key == "" {
-			key = r.Header.Get("API-KEY")
+func pushHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := preparePush(w, r)
+	if !ok {
+		return
+	}
+
+	data := map[string]interface{}{
+		"event_name":      p.body.EventName,
+		"delay_seconds":   p.body.DelaySeconds,
+		"target_user_id":  p.target,
+		"device_id":       p.body.DeviceID,
+		"client_id":       p.body.ClientID,
+		"channel":         p.body.Channel,
+		"group":           p.body.Group,
+		"broadcast":       p.broadcast(),
+		"parsed_user_raw": p.body.Token,
+	}
+	if len(p.targets) > 0 {
+		data["target_user_ids"] = p.targets
+	}
+	switch {
+	case p.cron != nil:
+		job, err := scheduleRecurring(p)
+		if err != nil {
+			writeRecurringLimit(w, r)
+			return
+		}
+		data["recurring_job_id"] = job.ID
+		data["next_run_at"] = job.NextRunAt
+	case p.runAt.IsZero() && GlobalConfig.PushQueue.Enabled && !p.message.Ephemeral:
+		if reason := enqueuePush(p); reason != "" {
+			writeBackpressure(w, r, reason)
+			return
 		}
-		if key == "" {
-			key = r.URL.Query().Get("api_key")
+		data["message_id"] = p.message.ID
+		if p.done == nil {
+			data["queued"] = true
+			break
 		}
-
-		if key == "" || key != apiKey {
-			log.Println("❌ API KEY 校验失败:", key)
-			w.WriteHeader(http.StatusUnauthorized)
-			_ = json.NewEncoder(w).Encode(map[string]interface{}{
-				"code": -1,
-				"msg":  "invalid api key",
-			})
+		delivered, ok := awaitQueuedPush(r, p)
+		if !ok {
 			return
 		}
-		next.ServeHTTP(w, r)
+		data["delivered"] = delivered
+		if p.userCounts != nil {
+			data["users"] = p.userCounts
+		}
+	case p.runAt.IsZero():
+		delivered := p.emit()
+		if p.body.Sync {
+			data["delivered"] = delivered
+		}
+		if p.message.ID != "" {
+			data["message_id"] = p.message.ID
+		}
+		if p.userCounts != nil {
+			data["users"] = p.userCounts
+		}
+	default:
+		job := schedulePush(p)
+		data["job_id"] = job.ID
+		data["run_at"] = job.RunAt
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": data,
 	})
 }
 
-// ===== push 处理 =====
+// preparedPush 校验通过、待发送的推送
+type preparedPush struct {
+	body       PushRequest
+	target     string // 目标用户，空表示广播
+	message    WSMessage
+	match      func(*Client) bool // 设备 / 连接 / 选择器过滤，nil 表示不过滤
+	logIt      bool
+	payloadLog string
+	runAt      time.Time      // 计划发送时间，零值表示立即发送
+	order      *orderedSlot   // 入队时在用户派发队列里占的位置（ordered_delivery），nil 表示发送时再排
+	cron       *cronSchedule  // 周期推送的计划，nil 表示不是周期推送
+	jobID      string         // 所属的延迟 / 周期任务，写进结果回调
+	events     []WSMessage    // 事务推送的各个事件，空表示普通推送
+	targets    []string       // token 为数组时的目标用户（此时 target 为空），见 multiuser.go
+	userCounts map[string]int // 多用户推送发送后各用户投递到的连接数
+	done       chan int       // sync 推送入队后由 worker 写入投递连接数，nil 表示不等待
+}
+
+// preparePush 解析并校验推送请求，失败时已写好错误响应
+func preparePush(w http.ResponseWriter, r *http.Request) (*preparedPush, bool) {
+	if m := currentMaintenance(); m != nil {
+		writeMaintenance(w, r, m)
+		return nil, false
+	}
+	if memoryPressure() {
+		writeBackpressure(w, r, backpressureMemory)
+		return nil, false
+	}
 
-func pushHandler(w http.ResponseWriter, r *http.Request) {
 	var body PushRequest
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+	if err := decodePushBody(r, &body); err != nil {
 		log.Println("解析 /push body 失败:", err)
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]interface{}{
-			"code": -1,
-			"msg":  "invalid json",
-		})
-		return
+		writeBodyError(w, r, err)
+		return nil, false
 	}
+	return preparePushBody(w, r, body)
+}
 
-	log.Println("📥 [push] body =", toJSON(body))
+// preparePushBody 校验已解码的推送请求，JSON 和 protobuf 接口共用
+func preparePushBody(w http.ResponseWriter, r *http.Request, body PushRequest) (*preparedPush, bool) {
+	if isSystemEvent(body.EventName) {
+		writeValidationProblem(w, r, "event_name", systemEventReservedMsg)
+		return nil, false
+	}
 
+	// 日志采样按整条推送请求决定，同一请求的几行日志要么都打要么都不打
+	logIt := shouldLogEvent(body.EventName)
+	if logIt {
+		log.Println("📥 [push] body =", redactedPushLog(body))
+	}
+
+	p, field, err := buildPush(body, logIt)
+	if err != nil {
+		writeValidationProblem(w, r, field, err.Error())
+		return nil, false
+	}
+	if body.Cron != "" {
+		if p.cron, field, err = pushCron(body, time.Now()); err != nil {
+			writeValidationProblem(w, r, field, err.Error())
+			return nil, false
+		}
+	} else if p.runAt, field, err = pushRunAt(body, time.Now()); err != nil {
+		writeValidationProblem(w, r, field, err.Error())
+		return nil, false
+	}
+	if body.Ephemeral && !p.runAt.IsZero() {
+		writeValidationProblem(w, r, "ephemeral", "ephemeral pushes cannot be scheduled")
+		return nil, false
+	}
+	if body.Sync && (p.cron != nil || !p.runAt.IsZero()) {
+		writeValidationProblem(w, r, "sync", "sync pushes cannot be scheduled")
+		return nil, false
+	}
+	// 组只在内存里，持久化的延迟任务重启后按组名重建，组不存在时发 0 个连接；请求时的组必须存在
+	if body.Group != "" && !groupExists(body.Group) {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "group not found: "+body.Group)
+		return nil, false
+	}
+	noteIdempotentUsers(r, p)
+	return p, true
+}
+
+// buildPush 校验推送请求并构造待发送的推送（不含发送时间），出错时同时返回出错的字段；
+// 持久化的延迟任务重启后也用它重建
+func buildPush(body PushRequest, logIt bool) (*preparedPush, string, error) {
+	var events []WSMessage
+	if len(body.Events) > 0 {
+		var (
+			field string
+			err   error
+		)
+		if events, field, err = buildTxnEvents(&body); err != nil {
+			return nil, field, err
+		}
+	}
 	if body.EventName == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]interface{}{
-			"code": -1,
-			"msg":  "缺少 event_name",
-		})
-		return
+		return nil, "event_name", errors.New("event_name is required")
 	}
 
 	// subject 直接透传；token 给客户端也保持原来 data.* 的位置，只是改名
-	payload := Payload{
+	var payload interface{} = Payload{
 		Subject: body.Subject,
 		Ts:      time.Now().UnixMilli(),
 		Token:   body.Token, // ⭐ 推给前端的 data.token = token
 	}
+	payloadLog := redactLog(payload)
+
+	// 端到端加密载荷：原样透传，日志里不出现密文
+	if body.Ciphertext != "" {
+		if err := validateCiphertext(&body); err != nil {
+			return nil, "ciphertext", err
+		}
+		encrypted := EncryptedPayload{KeyID: body.KeyID, Ciphertext: body.Ciphertext, Ts: time.Now().UnixMilli()}
+		payload = encrypted
+		payloadLog = encryptedPayloadLog(encrypted)
+	}
 
 	// 用 token 做路由（实际上是用户id / 会话标识）
 	targetUserId := parseUserToID(body.Token)
-	log.Println("🔎 解析出的 token =", toJSON(body.Token))
-	log.Println("🔎 最终 targetUserId =", targetUserId)
+	if logIt {
+		log.Println("🔎 解析出的 token =", logToken(toJSON(body.Token)))
+		log.Println("🔎 最终 targetUserId =", logUserID(targetUserId))
+	}
 
-	dataObj := WSMessage{
-		Event: body.EventName,
-		Data:  payload,
+	targets, field, err := parseTargetUsers(body.Token)
+	if err != nil {
+		return nil, field, err
+	}
+	if targets != nil {
+		if field, err := validateTargetUsers(body); err != nil {
+			return nil, field, err
+		}
 	}
 
-	doEmit := func() {
-		if targetUserId != "" {
-			log.Printf("🎯 单用户推送 \"%s\" 给 user_id=%s, payload=%s\n",
-				body.EventName, targetUserId, toJSON(payload))
-			emitToUser(targetUserId, dataObj)
-		} else {
-			log.Printf("🚀 广播事件 \"%s\" 给所有在线客户端, payload=%s\n",
-				body.EventName, toJSON(payload))
-			broadcastToAll(dataObj)
+	if body.Group != "" {
+		if field, err := validatePushGroup(body); err != nil {
+			return nil, field, err
+		}
+	} else if body.Channel != "" {
+		if field, err := validatePushChannel(body); err != nil {
+			return nil, field, err
 		}
+	} else if targetUserId == "" && (body.DeviceID != "" || body.ClientID != "") {
+		return nil, "token", errors.New("device_id / client_id require token")
+	}
+	if field, err := validatePushAck(body); err != nil {
+		return nil, field, err
+	}
+	if field, err := validatePushCallback(body); err != nil {
+		return nil, field, err
 	}
 
-	delay := body.DelaySeconds
-	if delay <= 0 {
-		doEmit()
-	} else {
-		log.Printf("⏱ 计划在 %d 秒后发送事件 \"%s\"（%s）\n",
-			delay,
-			body.EventName,
-			func() string {
-				if targetUserId != "" {
-					return "单用户 user_id=" + targetUserId
-				}
-				return "全站广播"
-			}())
-		go func() {
-			time.Sleep(time.Duration(delay) * time.Second)
-			doEmit()
-		}()
+	p := &preparedPush{
+		body:       body,
+		target:     targetUserId,
+		message:    WSMessage{Event: body.EventName, Channel: body.Channel, Data: payload, Ephemeral: body.Ephemeral},
+		logIt:      logIt,
+		payloadLog: payloadLog,
+		events:     events,
+		targets:    targets,
 	}
 
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"code": 0,
-		"msg":  "ok",
-		"data": map[string]interface{}{
-			"event_name":      body.EventName,
-			"delay_seconds":   delay,
-			"target_user_id":  targetUserId,
-			"broadcast":       targetUserId == "",
-			"parsed_user_raw": body.Token,
-		},
-	})
+	if body.Namespace != "" && namespaces[body.Namespace] == nil {
+		return nil, "namespace", fmt.Errorf("unknown namespace %q", body.Namespace)
+	}
+
+	// 设备 / 连接 / 元数据选择器 / 命名空间都是在目标连接集合上再做过滤
+	if body.DeviceID != "" || body.ClientID != "" || len(body.Selector) > 0 || body.Namespace != "" {
+		p.match = func(c *Client) bool {
+			if body.Namespace != "" && c.namespaceName() != body.Namespace {
+				return false
+			}
+			if body.ClientID != "" && c.id != body.ClientID {
+				return false
+			}
+			if body.DeviceID != "" && (c.device == nil || c.device.ID != body.DeviceID) {
+				return false
+			}
+			return matchSelector(c, body.Selector)
+		}
+	}
+	if p.match, field, err = excludeUsersMatch(body, targetUserId != "" || targets != nil, p.match); err != nil {
+		return nil, field, err
+	}
+	return p, "", nil
+}
+
+// broadcast 是否是全站广播（没有目标用户、频道或组）
+func (p *preparedPush) broadcast() bool {
+	return p.target == "" && len(p.targets) == 0 && p.body.Channel == "" && p.body.Group == ""
+}
+
+// emit 立即发送，返回成功投递的连接数；开启 receipts、require_ack、带 callback_url 或事务推送时先分配 message_id（入队时已分配的沿用）
+func (p *preparedPush) emit() int {
+	if p.message.Ephemeral {
+		return p.emitEphemeral()
+	}
+	if p.body.RequireAck {
+		if p.message.ID == "" {
+			p.message.ID = newMessageID()
+		}
+		p.message.RequireAck = true
+		trackAckMessage(p)
+	}
+	if GlobalConfig.Receipts.Enabled || p.body.CallbackURL != "" || len(p.events) > 0 {
+		if p.message.ID == "" {
+			p.message.ID = newMessageID()
+		}
+	}
+	if GlobalConfig.Receipts.Enabled {
+		trackMessage(p)
+	}
+	// 单用户推送按用户保序：经过该用户的派发队列（入队时已占位的沿用）；多用户推送逐个用户排
+	var delivered int
+	switch {
+	case len(p.targets) > 0:
+		delivered = p.routeUsers()
+	case p.target != "" && GlobalConfig.OrderedDelivery.Enabled:
+		slot := p.order
+		if slot == nil {
+			slot = reserveUserOrder(p.target)
+		}
+		delivered = slot.run(p.route)
+	default:
+		delivered = p.route()
+	}
+
+	switch {
+	case p.body.RequireAck:
+		finishAckMessage(p.message.ID, delivered)
+	case p.body.CallbackURL != "":
+		reportPushDelivered(p, delivered)
+	}
+	return delivered
+}
+
+// route 按组 / 频道 / 用户 / 广播分发，返回成功投递的连接数
+func (p *preparedPush) route() int {
+	if len(p.events) > 0 {
+		return p.routeTxn()
+	}
+	body := p.body
+	switch {
+	case body.Group != "":
+		if p.logIt {
+			log.Printf("👥 组推送 \"%s\" 给 group=%s, payload=%s\n",
+				body.EventName, body.Group, p.payloadLog)
+		}
+		return emitToGroup(body.Group, p.message, p.match)
+	case body.Channel != "":
+		if p.logIt {
+			log.Printf("📡 频道推送 \"%s\" 给 channel=%s, payload=%s\n",
+				body.EventName, body.Channel, p.payloadLog)
+		}
+		return emitToChannelMatching(body.Channel, p.message, p.match)
+	case p.target != "" && p.match != nil:
+		if p.logIt {
+			log.Printf("🎯 单用户定向推送 \"%s\" 给 user_id=%s device_id=%s client_id=%s selector=%s, payload=%s\n",
+				body.EventName, logUserID(p.target), body.DeviceID, body.ClientID, toJSON(body.Selector), p.payloadLog)
+		}
+		return emitToUserConns(p.target, p.message, p.match)
+	case p.target != "":
+		if p.logIt {
+			log.Printf("🎯 单用户推送 \"%s\" 给 user_id=%s, payload=%s\n",
+				body.EventName, logUserID(p.target), p.payloadLog)
+		}
+		return emitToUser(p.target, p.message)
+	case p.match != nil:
+		if p.logIt {
+			log.Printf("🚀 按选择器广播事件 \"%s\" selector=%s, payload=%s\n",
+				body.EventName, toJSON(body.Selector), p.payloadLog)
+		}
+		return broadcastMatching(p.message, p.match)
+	default:
+		if p.logIt {
+			log.Printf("🚀 广播事件 \"%s\" 给所有在线客户端, payload=%s\n",
+				body.EventName, p.payloadLog)
+		}
+		return broadcastToAll(p.message)
+	}
 }
 
 func parseUserToID(u interface{}) string {
@@ -525,35 +1146,323 @@ func toJSON(v interface{}) string {
 // ===== 入口 =====
 
 func main() {
+	// 子命令：relay selftest 跑一遍端到端自检后退出
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		os.Exit(runSelftest(os.Args[2:]))
+	}
+	// 子命令：relay dump 拉取运行中实例的状态快照
+	if len(os.Args) > 1 && os.Args[1] == "dump" {
+		os.Exit(runDump(os.Args[2:]))
+	}
+	// 子命令：relay migrate-config 把旧版本配置文件迁移并写回
+	if len(os.Args) > 1 && os.Args[1] == "migrate-config" {
+		os.Exit(runMigrateConfig(os.Args[2:]))
+	}
+
 	// 确保配置被加载或创建，并修复了空字段问题
 	loadOrCreateConfig()
 
 	// 此时 GlobalConfig 中的所有关键字段都已填充，不会是空字符串
 	port := GlobalConfig.Port
 	wsPath := GlobalConfig.WSPath
-	apiKey := GlobalConfig.APIKey
 	pushPath := GlobalConfig.PushPath
 
+	mux := newMux()
+
+	addr := ":" + port
+	log.Printf("✅ Go Relay server listening on http://localhost:%s\n", port)
+	log.Printf("✅ WebSocket path = %s\n", wsPath)
+	log.Printf("✅ Push API path = %s\n", pushPath)
+	log.Printf("✅ API_KEY 指纹 = %s\n", keyFingerprint(GlobalConfig.APIKey))
+
+	listeners, err := listen(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logServerSetup(len(listeners))
+
+	if err := serve(newHTTPServer(mux), listeners); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newMux 按 GlobalConfig 注册所有路由，并初始化各可选功能
+func newMux() *http.ServeMux {
 	mux := http.NewServeMux()
 
+	// 可选：客户端 token 校验
+	if GlobalConfig.ClientJWT.Enabled {
+		initClientJWT()
+	}
+	if GlobalConfig.AuthExpiry.Enabled {
+		initAuthExpiry()
+	}
+	if GlobalConfig.ConnLifetime.Enabled {
+		initConnLifetime()
+	}
+
+	// 可选：管理后台 OIDC 登录（需要在管理接口注册前初始化，会话会加入 admin 认证链）
+	if GlobalConfig.OIDC.Enabled {
+		initOIDC(mux)
+	}
+
 	// WebSocket
-	mux.HandleFunc(wsPath, wsHandler)
+	mux.HandleFunc(GlobalConfig.WSPath, upgradeGuard(wsHandler))
+	registerNamespaceRoutes(mux)
+
+	// HTTP push（支持自定义路径，作为 /v1/push 的旧版别名保留）
+	if GlobalConfig.PushSigning.Enabled {
+		startPushNonceSweep()
+	}
+	if GlobalConfig.PushPath == v1PushPath {
+		log.Printf("⚠️ push_path 与 %s 相同，只提供 v1 接口\n", v1PushPath)
+	} else {
+		mux.Handle(GlobalConfig.PushPath, protectPush(pushHandler))
+	}
+	mux.Handle("POST "+pushPBPath, protectPush(withIdempotency(pushPBHandler)))
+
+	// 可选：异步推送队列
+	if GlobalConfig.PushQueue.Enabled {
+		startPushQueue()
+	}
+
+	// 可选：重连风暴保护
+	if GlobalConfig.ConnectRate.Enabled {
+		go connectRateSweepLoop()
+	}
+
+	// 注册表一致性巡检
+	if GlobalConfig.ConsistencySweepSeconds > 0 {
+		go consistencySweepLoop()
+	}
+
+	// 可选：推送背压的堆内存采样
+	startHeapSampler()
+
+	// 可选：推送结果回调（恢复延迟任务时错过的任务也要回调，先启动）
+	if GlobalConfig.PushCallbacks.Enabled {
+		startPushCallbacks()
+	}
+
+	// 版本化 HTTP API（落盘的延迟推送任务先恢复）
+	loadPushJobs()
+	loadRecurringJobs()
+	registerV1Routes(mux)
+
+	// 管理接口：在线连接列表
+	mux.Handle("GET /api/admin/connections", checkAuth("admin", http.HandlerFunc(adminConnectionsHandler)))
+	mux.Handle("GET /api/admin/users", checkAuth("admin", http.HandlerFunc(adminUsersHandler)))
+	mux.Handle("GET /api/admin/stats", checkAuth("admin", http.HandlerFunc(adminStatsHandler)))
+
+	// 管理接口：按命名空间 / API Key 的用量统计
+	startUsageSampler()
+	mux.Handle("GET /api/admin/stats/namespaces", checkAuth("admin", http.HandlerFunc(adminNamespaceStatsHandler)))
+	mux.Handle("GET /api/admin/stats/namespaces/{name}", checkAuth("admin", http.HandlerFunc(adminNamespaceStatHandler)))
+	mux.Handle("GET /api/admin/stats/api-keys", checkAuth("admin", http.HandlerFunc(adminAPIKeyStatsHandler)))
+	mux.Handle("GET /api/admin/stats/api-keys/{fingerprint}", checkAuth("admin", http.HandlerFunc(adminAPIKeyStatHandler)))
+
+	// 管理接口：系统公告
+	mux.Handle("POST /api/admin/announce", checkAuth("admin", http.HandlerFunc(adminAnnounceHandler)))
+	mux.Handle("GET /api/admin/announcements", checkAuth("admin", http.HandlerFunc(adminAnnouncementsHandler)))
+	mux.Handle("GET /api/admin/announcements/{id}", checkAuth("admin", http.HandlerFunc(adminAnnouncementHandler)))
+
+	// 管理接口：延迟推送任务（与 /v1/jobs 相同，走 admin 认证链，给运维查看和取消）
+	mux.Handle("GET /api/jobs", checkAuth("admin", http.HandlerFunc(v1JobsHandler)))
+	mux.Handle("GET /api/jobs/{id}", checkAuth("admin", http.HandlerFunc(v1JobHandler)))
+	mux.Handle("DELETE /api/jobs/{id}", checkAuth("admin", http.HandlerFunc(v1JobHandler)))
+	mux.Handle("GET /api/recurring-jobs", checkAuth("admin", http.HandlerFunc(v1RecurringJobsHandler)))
+	mux.Handle("GET /api/recurring-jobs/{id}", checkAuth("admin", http.HandlerFunc(v1RecurringJobHandler)))
+	mux.Handle("DELETE /api/recurring-jobs/{id}", checkAuth("admin", http.HandlerFunc(v1RecurringJobHandler)))
+
+	// 管理接口：运行时状态快照
+	mux.Handle("GET /api/admin/state", checkAuth("admin", http.HandlerFunc(adminStateHandler)))
+
+	// 管理接口：维护模式开关
+	mux.Handle("GET /api/admin/maintenance", checkAuth("admin", http.HandlerFunc(adminMaintenanceHandler)))
+	mux.Handle("POST /api/admin/maintenance", checkAuth("admin", http.HandlerFunc(adminMaintenanceHandler)))
+
+	// 管理接口：只读模式开关
+	mux.Handle("GET /api/admin/readonly", checkAuth("admin", http.HandlerFunc(adminReadOnlyHandler)))
+	mux.Handle("POST /api/admin/readonly", checkAuth("admin", http.HandlerFunc(adminReadOnlyHandler)))
+
+	// 管理接口：日志采样
+	initLogSampling(GlobalConfig.LogSampling)
+	mux.Handle("GET /api/admin/log-sampling", checkAuth("admin", http.HandlerFunc(adminLogSamplingHandler)))
+	mux.Handle("PUT /api/admin/log-sampling", checkAuth("admin", http.HandlerFunc(adminLogSamplingHandler)))
+
+	// 管理接口：功能开关
+	initFlags(GlobalConfig.Flags)
+	mux.Handle("GET /api/admin/flags", checkAuth("admin", http.HandlerFunc(adminFlagsHandler)))
+	mux.Handle("PUT /api/admin/flags", checkAuth("admin", http.HandlerFunc(adminFlagsHandler)))
+
+	// 管理接口：重连退避建议
+	mux.Handle("GET /api/admin/reconnect-advice", checkAuth("admin", http.HandlerFunc(adminReconnectAdviceHandler)))
+	mux.Handle("PUT /api/admin/reconnect-advice", checkAuth("admin", http.HandlerFunc(adminReconnectAdviceHandler)))
+
+	// 管理接口：删除用户数据（GDPR）
+	initErasure()
+	mux.Handle("DELETE /api/users/{id}", checkAuth("admin", http.HandlerFunc(eraseUserHandler)))
+
+	// 可选：滥用计分与自动封禁
+	if GlobalConfig.Bans.Enabled {
+		mux.Handle("GET /api/admin/bans", checkAuth("admin", http.HandlerFunc(adminBansHandler)))
+		mux.Handle("DELETE /api/admin/bans/{subject}", checkAuth("admin", http.HandlerFunc(adminUnbanHandler)))
+		go bansSweepLoop()
+	}
+
+	// 可选：投递回执
+	if GlobalConfig.Receipts.Enabled {
+		loadReceipts()
+		mux.Handle("GET /api/messages/{id}/receipts", checkAuth("admin", http.HandlerFunc(messageReceiptsHandler)))
+		go receiptsSaveLoop()
+	}
+
+	// 可选：设备 / 会话登记
+	if GlobalConfig.Devices.Enabled {
+		loadDevices()
+		mux.Handle("GET /api/users/{id}/devices", checkAuth("admin", http.HandlerFunc(userDevicesHandler)))
+		go devicesSaveLoop()
+	}
+
+	// 可选：频道占用 webhook
+	if GlobalConfig.Occupancy.Enabled {
+		startOccupancySender()
+	}
+
+	// 可选：每用户 KV 状态
+	if GlobalConfig.KV.Enabled {
+		loadKV()
+		mux.Handle("GET /api/users/{id}/kv", checkAuth("push", http.HandlerFunc(userKVHandler)))
+		// 请求体允许带缩进，压缩后再按 max_value_bytes 判断
+		mux.Handle("PUT /api/users/{id}/kv/{key}", checkAuth("push", limitBody(int64(GlobalConfig.KV.MaxValueBytes)<<2, http.HandlerFunc(userKVSetHandler))))
+		mux.Handle("DELETE /api/users/{id}/kv/{key}", checkAuth("push", http.HandlerFunc(userKVDeleteHandler)))
+		go kvSaveLoop()
+	}
+
+	// 可选：具名连接组
+	if GlobalConfig.Groups.Enabled {
+		mux.Handle("GET /api/groups", checkAuth("push", http.HandlerFunc(groupsHandler)))
+		mux.Handle("GET /api/groups/{name}", checkAuth("push", http.HandlerFunc(groupHandler)))
+		mux.Handle("DELETE /api/groups/{name}", checkAuth("push", http.HandlerFunc(groupHandler)))
+		mux.Handle("POST /api/groups/{name}/members", checkAuth("push", limitBody(pushMaxBodyBytes, http.HandlerFunc(groupAddMembersHandler))))
+		mux.Handle("DELETE /api/groups/{name}/members", checkAuth("push", http.HandlerFunc(groupRemoveMembersHandler)))
+		go groupsSweepLoop()
+	}
+
+	// 可选：频道 / 组自动过期
+	if GlobalConfig.Expiry.Enabled {
+		startExpiry()
+	}
+
+	// 可选：上行事件聚合
+	if GlobalConfig.Aggregation.Enabled {
+		startAggregation()
+	}
+
+	// 可选：集群节点间接口与连接迁移
+	if GlobalConfig.Cluster.Enabled {
+		initCluster()
+		mux.Handle("POST "+handoffPath, checkClusterSignature(http.HandlerFunc(clusterHandoffHandler)))
+		mux.Handle("POST /api/admin/handoff", checkAuth("admin", http.HandlerFunc(adminHandoffHandler)))
+		mux.HandleFunc("GET /api/cluster/route", clusterRouteHandler)
+	}
+
+	// 可选：Vault token 续期与密钥热更新
+	if GlobalConfig.Vault.Enabled {
+		go vaultRefreshLoop()
+	}
+
+	// 可选：AWS Secrets Manager / SSM 引用定期刷新
+	if awsRefsInUse && GlobalConfig.AWS.RefreshSeconds > 0 {
+		go secretsRefreshLoop(time.Duration(GlobalConfig.AWS.RefreshSeconds) * time.Second)
+	}
+
+	// 可选：消息签名公钥
+	if GlobalConfig.Signing.Enabled {
+		mux.HandleFunc("GET "+signingWellKnownPath, signingKeyHandler)
+	}
+
+	// 可选：Pusher 协议兼容端点
+	if GlobalConfig.Pusher.Enabled {
+		registerPusherRoutes(mux)
+	}
+
+	// 可选：Centrifugo 协议兼容端点
+	if GlobalConfig.Centrifugo.Enabled {
+		registerCentrifugoRoutes(mux)
+	}
+
+	// 可选：Phoenix Channels 协议兼容端点
+	if GlobalConfig.Phoenix.Enabled {
+		registerPhoenixRoutes(mux)
+	}
+
+	// 可选：SignalR 协议兼容端点
+	if GlobalConfig.SignalR.Enabled {
+		registerSignalRRoutes(mux)
+	}
+
+	// 可选：SSE 传输
+	if GlobalConfig.SSE.Enabled {
+		registerSSERoutes(mux)
+	}
 
-	// HTTP push（支持自定义路径）
-	mux.Handle(pushPath, checkAPIKey(http.HandlerFunc(pushHandler)))
+	// 可选：firehose
+	if GlobalConfig.Firehose.Enabled {
+		registerFirehoseRoutes(mux)
+	}
+
+	// 可选：在线用户变化流
+	if GlobalConfig.PresenceStream.Enabled {
+		registerPresenceStreamRoutes(mux)
+	}
+
+	// 可选：消息归档
+	if GlobalConfig.Archive.Enabled {
+		initArchive()
+	}
+	// 消息历史查询：频道历史走内存，其余条件查归档
+	if archiveFiles != nil || GlobalConfig.ChannelHistory.Size > 0 {
+		mux.Handle("GET /api/history", checkAuth("admin", http.HandlerFunc(historyHandler)))
+	}
+
+	// 可选：gRPC 流量订阅
+	if GlobalConfig.GRPC.Enabled {
+		registerGRPCRoutes(mux)
+	}
+
+	// 可选：原始 TCP 传输
+	if GlobalConfig.TCP.Enabled {
+		startTCPListener()
+	}
+
+	// 可选：每用户消息历史
+	if GlobalConfig.History.Size > 0 {
+		go historySweepLoop()
+	}
+
+	// 可选：每频道消息历史
+	if GlobalConfig.ChannelHistory.Size > 0 {
+		go channelHistorySweepLoop()
+	}
+
+	// 可选：离线消息队列
+	if GlobalConfig.OfflineQueue.Enabled {
+		go offlineSweepLoop()
+	}
+
+	// 可选：Prometheus 指标
+	if GlobalConfig.Metrics.Enabled {
+		registerMetricsRoutes(mux)
+	}
+
+	// HTTP API 描述（按当前配置生成）
+	mux.HandleFunc("GET "+openAPIPath, openAPIHandler)
 
 	// 健康检查
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 	})
 
-	addr := ":" + port
-	log.Printf("✅ Go Relay server listening on http://localhost:%s\n", port)
-	log.Printf("✅ WebSocket path = %s\n", wsPath)
-	log.Printf("✅ Push API path = %s\n", pushPath)
-	log.Printf("✅ 使用 API_KEY = %s\n", apiKey)
-
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatal(err)
-	}
+	return mux
 }
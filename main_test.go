@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// 逐条日志对测试结果没有意义，失败信息由 t.Errorf 输出
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// useConfig 测试期间把 GlobalConfig 换成默认配置（edit 可以再改），结束时恢复
+func useConfig(t *testing.T, edit func(cfg *Config)) {
+	t.Helper()
+	saved := GlobalConfig
+	cfg := getDefaultConfig()
+	if edit != nil {
+		edit(&cfg)
+	}
+	prepareInbound(&cfg.Inbound)
+	GlobalConfig = cfg
+	t.Cleanup(func() { GlobalConfig = saved })
+}
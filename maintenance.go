@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 维护模式 / 只读模式 =====
+//
+// 通过管理接口开关：开启后拒绝新的连接升级（503 + Retry-After），
+// 推送接口返回 maintenance 状态，可选给现有连接广播 maintenance 事件；进程本身不退出。
+//
+// 只读模式：服务端推送照常下发，客户端上行事件（ping / identify 除外）一律回 error 事件拒绝，
+// 用于事故处理期间压住客户端发起的流量。
+
+const maintenanceDefaultRetryAfter = 60
+
+type maintenanceState struct {
+	Enabled    bool      `json:"enabled"`
+	Message    string    `json:"message,omitempty"`
+	RetryAfter int       `json:"retry_after"` // 秒
+	Since      time.Time `json:"since"`
+}
+
+var maintenance atomic.Pointer[maintenanceState]
+
+func currentMaintenance() *maintenanceState {
+	if m := maintenance.Load(); m != nil && m.Enabled {
+		return m
+	}
+	return nil
+}
+
+// upgradeGuard 包装所有建立长连接的入口（WebSocket / SSE / negotiate），在升级前统一做准入检查
+func upgradeGuard(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m := currentMaintenance(); m != nil {
+			writeMaintenance(w, r, m)
+			return
+		}
+		if GlobalConfig.ConnectRate.Enabled && !admitConnectRate(w, r) {
+			return
+		}
+		if GlobalConfig.Admission.Enabled {
+			var ok bool
+			if r, ok = admitRequest(w, r); !ok {
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// writeMaintenance 输出 503 + Retry-After 的维护中响应
+func writeMaintenance(w http.ResponseWriter, r *http.Request, m *maintenanceState) {
+	w.Header().Set("Retry-After", strconv.Itoa(m.RetryAfter))
+	writeProblemWith(w, r, http.StatusServiceUnavailable, problemMaintenance, m.Message,
+		map[string]interface{}{"maintenance": m})
+}
+
+// maintenanceRequest POST /api/admin/maintenance 的请求体
+type maintenanceRequest struct {
+	Enabled       bool   `json:"enabled"`
+	Message       string `json:"message"`
+	RetryAfter    int    `json:"retry_after"`
+	NotifyClients bool   `json:"notify_clients"` // 是否给现有连接广播 maintenance 事件
+}
+
+// adminMaintenanceHandler GET 查询 / POST 切换维护模式
+func adminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var req maintenanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+		if req.RetryAfter <= 0 {
+			req.RetryAfter = maintenanceDefaultRetryAfter
+		}
+
+		state := &maintenanceState{
+			Enabled:    req.Enabled,
+			Message:    req.Message,
+			RetryAfter: req.RetryAfter,
+			Since:      time.Now(),
+		}
+		maintenance.Store(state)
+
+		if req.Enabled {
+			log.Printf("🚧 进入维护模式：%s（Retry-After=%ds）\n", req.Message, req.RetryAfter)
+		} else {
+			log.Println("✅ 退出维护模式")
+		}
+
+		if req.NotifyClients {
+			broadcastToAll(WSMessage{Event: "maintenance", Data: state})
+		}
+	}
+
+	state := maintenance.Load()
+	if state == nil {
+		state = &maintenanceState{}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": state,
+	})
+}
+
+// ===== 只读模式 =====
+
+var readOnly atomic.Bool
+
+// rejectReadOnly 只读模式下拒绝客户端上行事件并回一条 error 事件，返回 true 表示已拒绝
+func rejectReadOnly(c *Client, event string) bool {
+	if !readOnly.Load() {
+		return false
+	}
+	log.Printf("🔒 只读模式，拒绝上行事件 %s conn=%s\n", event, c.id)
+	_ = c.deliver(WSMessage{
+		Event: "error",
+		Data: map[string]interface{}{
+			"code":  "read_only",
+			"event": event,
+			"msg":   "server is in read-only mode",
+		},
+	})
+	return true
+}
+
+// adminReadOnlyHandler GET 查询 / POST {"enabled":bool} 切换只读模式
+func adminReadOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+		if readOnly.Swap(req.Enabled) != req.Enabled {
+			if req.Enabled {
+				log.Println("🔒 进入只读模式：拒绝客户端上行事件")
+			} else {
+				log.Println("🔓 退出只读模式")
+			}
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{"enabled": readOnly.Load()},
+	})
+}
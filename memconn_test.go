@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 内存传输（测试用） =====
+//
+// memConn 是一个只在进程内的 clientConn：写出的帧按顺序进缓冲区，不经过网络，也不需要监听端口。
+// 只在测试里编译，配合 NewHub(WithClock(...)) 确定性地验证路由、identify、踢连接等逻辑：
+//
+//	h := NewHub(WithConfig(&Config{}))
+//	c, conn := newMemClient(h, "u1")
+//	h.emitToUser("u1", WSMessage{Event: "hi"})
+//	msg, err := conn.expectEvent("hi", time.Second)
+//
+// 上行消息用 injectFrame 注入，走的是原生协议的 handleNativeMessage（identify 等会作用在 defaultHub 上）。
+
+// errMemConnClosed 向已关闭的内存连接写数据
+var errMemConnClosed = errors.New("mem conn closed")
+
+// memFrame 内存连接收到的一帧
+type memFrame struct {
+	Type int
+	Data []byte
+}
+
+// memConn 进程内的 clientConn 实现，记录所有写出的帧
+type memConn struct {
+	mu       sync.Mutex
+	frames   []memFrame
+	read     int           // 已被 next 取走的帧数
+	notify   chan struct{} // 有新帧或连接关闭时唤醒等待方
+	closed   bool
+	closeMsg []byte // 收到的关闭帧载荷（关闭码 + 原因）
+
+	// failWrites 非空时所有写操作返回该错误，用于模拟发送失败（慢连接 / 对端已断）
+	failWrites error
+}
+
+func newMemConn() *memConn {
+	return &memConn{notify: make(chan struct{}, 1)}
+}
+
+func (m *memConn) wake() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *memConn) WriteMessage(messageType int, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failWrites != nil {
+		return m.failWrites
+	}
+	if m.closed {
+		return errMemConnClosed
+	}
+	if messageType == websocket.CloseMessage {
+		m.closeMsg = append([]byte(nil), data...)
+		return nil
+	}
+	m.frames = append(m.frames, memFrame{Type: messageType, Data: append([]byte(nil), data...)})
+	m.wake()
+	return nil
+}
+
+func (m *memConn) WriteJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return m.WriteMessage(websocket.TextMessage, b)
+}
+
+func (m *memConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (m *memConn) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		m.wake()
+	}
+	return nil
+}
+
+// failWith 之后的写操作都返回 err，传 nil 恢复
+func (m *memConn) failWith(err error) {
+	m.mu.Lock()
+	m.failWrites = err
+	m.mu.Unlock()
+}
+
+// isClosed 连接是否已被服务端关闭
+func (m *memConn) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+// closeCode 服务端发送的关闭码，没有发送关闭帧时返回 0
+func (m *memConn) closeCode() (int, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.closeMsg) < 2 {
+		return 0, ""
+	}
+	return int(m.closeMsg[0])<<8 | int(m.closeMsg[1]), string(m.closeMsg[2:])
+}
+
+// allFrames 到目前为止收到的所有帧的拷贝
+func (m *memConn) allFrames() []memFrame {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]memFrame(nil), m.frames...)
+}
+
+// next 取下一帧，超时或连接关闭且没有未读帧时返回 false
+func (m *memConn) next(timeout time.Duration) (memFrame, bool) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		m.mu.Lock()
+		if m.read < len(m.frames) {
+			f := m.frames[m.read]
+			m.read++
+			m.mu.Unlock()
+			return f, true
+		}
+		closed := m.closed
+		m.mu.Unlock()
+		if closed {
+			return memFrame{}, false
+		}
+
+		select {
+		case <-m.notify:
+		case <-deadline.C:
+			return memFrame{}, false
+		}
+	}
+}
+
+// expectEvent 跳过其它帧，等到指定事件的原生消息；超时返回错误（附带期间收到的事件名，便于排查）
+func (m *memConn) expectEvent(event string, timeout time.Duration) (WSMessage, error) {
+	deadline := time.Now().Add(timeout)
+	var seen []string
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			break
+		}
+		f, ok := m.next(left)
+		if !ok {
+			break
+		}
+		var msg WSMessage
+		if err := json.Unmarshal(f.Data, &msg); err != nil || msg.Event == "" {
+			seen = append(seen, "<non-event frame>")
+			continue
+		}
+		if msg.Event == event {
+			return msg, nil
+		}
+		seen = append(seen, msg.Event)
+	}
+	return WSMessage{}, fmt.Errorf("没有收到事件 %q（期间收到 %v）", event, seen)
+}
+
+// expectNothing 在 wait 时间内没有新帧则返回 nil，用于断言消息没有被投递
+func (m *memConn) expectNothing(wait time.Duration) error {
+	if f, ok := m.next(wait); ok {
+		return fmt.Errorf("不应收到消息，实际收到 %s", f.Data)
+	}
+	return nil
+}
+
+// newMemClient 创建一个内存连接并加入 h，userID 非空时注册到该用户组
+func newMemClient(h *Hub, userID string) (*Client, *memConn) {
+	conn := newMemConn()
+	r, _ := http.NewRequest(http.MethodGet, "/ws", nil)
+	r.RemoteAddr = "mem"
+	c := newClient(conn, r)
+	h.addClient(c)
+	if userID != "" {
+		h.registerUser(c, userID)
+	}
+	return c, conn
+}
+
+// injectFrame 模拟客户端发来一条原生协议的上行消息，返回 false 表示服务端要求断开
+func injectFrame(c *Client, v interface{}) bool {
+	raw, ok := v.([]byte)
+	if !ok {
+		raw, _ = json.Marshal(v)
+	}
+	return handleNativeMessage(c, raw)
+}
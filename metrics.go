@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== Prometheus 指标（按频道） =====
+//
+// GET /metrics 输出 Prometheus 文本格式：每个频道的订阅连接数、消息数、投递数。
+// 频道名是无界的，带 channel 标签的序列最多 max_channel_series 个，按先到先得分配，
+// 超出的频道统一累加到 channel="other"；一个频道空闲（没有订阅者也没有消息）超过
+// channel_idle_seconds 后释放它的序列名额，之后出现的新频道可以补上。
+
+// MetricsConfig 指标端点配置
+type MetricsConfig struct {
+	Enabled            bool   `json:"enabled"`
+	Path               string `json:"path"`                 // 默认 /metrics
+	Public             bool   `json:"public"`               // 不走 admin 认证链，方便 Prometheus 直接抓取（只在内网暴露时开启）
+	MaxChannelSeries   int    `json:"max_channel_series"`   // 带 channel 标签的序列上限，默认 100
+	ChannelIdleSeconds int    `json:"channel_idle_seconds"` // 频道空闲多久释放序列名额，默认 600
+}
+
+const (
+	metricsDefaultPath        = "/metrics"
+	metricsDefaultMaxChannels = 100
+	metricsDefaultChannelIdle = 600
+	metricsOtherChannel       = "other"
+)
+
+// channelStats 一个频道（或 other）的累计计数
+type channelStats struct {
+	messages   uint64
+	deliveries uint64
+	lastActive time.Time
+}
+
+var (
+	channelMetricsMu sync.Mutex
+	channelSeries    = make(map[string]*channelStats) // 有独立序列的频道
+	channelOther     channelStats                     // 超出上限的频道汇总
+)
+
+func registerMetricsRoutes(mux *http.ServeMux) {
+	cfg := &GlobalConfig.Metrics
+	if cfg.Path == "" {
+		cfg.Path = metricsDefaultPath
+	}
+	if cfg.MaxChannelSeries <= 0 {
+		cfg.MaxChannelSeries = metricsDefaultMaxChannels
+	}
+	if cfg.ChannelIdleSeconds <= 0 {
+		cfg.ChannelIdleSeconds = metricsDefaultChannelIdle
+	}
+
+	var h http.Handler = http.HandlerFunc(metricsHandler)
+	if !cfg.Public {
+		h = checkAuth("admin", h)
+	}
+	mux.Handle("GET "+cfg.Path, h)
+	log.Printf("✅ 指标端点已启用：%s，频道序列上限 %d\n", cfg.Path, cfg.MaxChannelSeries)
+}
+
+// channelStatsLocked 返回频道对应的计数，没有名额时返回 other；调用方持有 channelMetricsMu
+func channelStatsLocked(channel string) *channelStats {
+	if s, ok := channelSeries[channel]; ok {
+		return s
+	}
+	if channel == metricsOtherChannel || len(channelSeries) >= GlobalConfig.Metrics.MaxChannelSeries {
+		return &channelOther
+	}
+	s := &channelStats{}
+	channelSeries[channel] = s
+	return s
+}
+
+// recordChannelMessage 记录一次频道推送及其成功投递数
+func recordChannelMessage(channel string, delivered int) {
+	if !GlobalConfig.Metrics.Enabled {
+		return
+	}
+	channelMetricsMu.Lock()
+	s := channelStatsLocked(channel)
+	s.messages++
+	s.deliveries += uint64(delivered)
+	s.lastActive = time.Now()
+	channelMetricsMu.Unlock()
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	subscribers := defaultHub.channelSubscribers()
+	connections := defaultHub.connectionCount()
+
+	now := time.Now()
+	idle := time.Duration(GlobalConfig.Metrics.ChannelIdleSeconds) * time.Second
+
+	type row struct {
+		channel              string
+		subs                 int
+		messages, deliveries uint64
+	}
+	channelMetricsMu.Lock()
+	// 有订阅者的频道算活跃；空闲太久的释放名额
+	for ch, n := range subscribers {
+		if n > 0 {
+			channelStatsLocked(ch).lastActive = now
+		}
+	}
+	for ch, s := range channelSeries {
+		if subscribers[ch] == 0 && now.Sub(s.lastActive) > idle {
+			delete(channelSeries, ch)
+		}
+	}
+	rows := make([]row, 0, len(channelSeries)+1)
+	for ch, s := range channelSeries {
+		rows = append(rows, row{channel: ch, subs: subscribers[ch], messages: s.messages, deliveries: s.deliveries})
+	}
+	other := row{channel: metricsOtherChannel, messages: channelOther.messages, deliveries: channelOther.deliveries}
+	untracked := 0
+	for ch, n := range subscribers {
+		if _, ok := channelSeries[ch]; !ok {
+			other.subs += n
+			untracked++
+		}
+	}
+	channelMetricsMu.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].channel < rows[j].channel })
+	rows = append(rows, other)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP relay_connections Current number of client connections.\n# TYPE relay_connections gauge\nrelay_connections %d\n", connections)
+	fmt.Fprintf(&b, "# HELP relay_channels Current number of channels with at least one subscriber.\n# TYPE relay_channels gauge\nrelay_channels %d\n", len(subscribers))
+	users := defaultHub.userGroupStats(0)
+	fmt.Fprintf(&b, "# HELP relay_user_groups Current number of user groups.\n# TYPE relay_user_groups gauge\nrelay_user_groups %d\n", users.Groups)
+	fmt.Fprintf(&b, "# HELP relay_user_group_max_size Connections in the largest user group.\n# TYPE relay_user_group_max_size gauge\nrelay_user_group_max_size %d\n", users.MaxSize)
+	b.WriteString("# HELP relay_user_groups_by_size User groups bucketed by connection count.\n# TYPE relay_user_groups_by_size gauge\n")
+	for _, sb := range userGroupSizeBuckets {
+		fmt.Fprintf(&b, "relay_user_groups_by_size{size=\"%s\"} %d\n", sb.label, users.BySize[sb.label])
+	}
+	fmt.Fprintf(&b, "# HELP relay_user_registrations_total Connections joining a user group.\n# TYPE relay_user_registrations_total counter\nrelay_user_registrations_total %d\n", users.Registers)
+	fmt.Fprintf(&b, "# HELP relay_user_unregistrations_total Connections leaving a user group, including disconnects.\n# TYPE relay_user_unregistrations_total counter\nrelay_user_unregistrations_total %d\n", users.Unregisters)
+	b.WriteString("# HELP relay_disconnects_total Client disconnects by reason (close code class).\n# TYPE relay_disconnects_total counter\n")
+	dc := disconnectCounts()
+	for _, reason := range disconnectReasons {
+		fmt.Fprintf(&b, "relay_disconnects_total{reason=\"%s\"} %d\n", reason, dc[reason])
+	}
+	repairs := consistencyCounts()
+	b.WriteString("# HELP relay_consistency_repairs_total Registry inconsistencies found and repaired by the consistency sweep, by kind.\n# TYPE relay_consistency_repairs_total counter\n")
+	for _, kind := range inconsistencyKinds {
+		fmt.Fprintf(&b, "relay_consistency_repairs_total{kind=\"%s\"} %d\n", kind, repairs[kind])
+	}
+	fmt.Fprintf(&b, "# HELP relay_consistency_sweeps_total Registry consistency sweeps run.\n# TYPE relay_consistency_sweeps_total counter\nrelay_consistency_sweeps_total %d\n", consistencySweeps.Load())
+	fmt.Fprintf(&b, "# HELP relay_consistency_last_sweep_timestamp_seconds Unix time of the last consistency sweep.\n# TYPE relay_consistency_last_sweep_timestamp_seconds gauge\nrelay_consistency_last_sweep_timestamp_seconds %d\n", consistencyLastRun.Load())
+	fmt.Fprintf(&b, "# HELP relay_channels_untracked Subscribed channels aggregated into channel=\"other\".\n# TYPE relay_channels_untracked gauge\nrelay_channels_untracked %d\n", untracked)
+
+	b.WriteString("# HELP relay_channel_subscribers Current subscribers per channel.\n# TYPE relay_channel_subscribers gauge\n")
+	for _, r := range rows {
+		fmt.Fprintf(&b, "relay_channel_subscribers{channel=\"%s\"} %d\n", promLabel(r.channel), r.subs)
+	}
+	b.WriteString("# HELP relay_channel_messages_total Messages published per channel.\n# TYPE relay_channel_messages_total counter\n")
+	for _, r := range rows {
+		fmt.Fprintf(&b, "relay_channel_messages_total{channel=\"%s\"} %d\n", promLabel(r.channel), r.messages)
+	}
+	b.WriteString("# HELP relay_channel_deliveries_total Successful per-connection deliveries per channel.\n# TYPE relay_channel_deliveries_total counter\n")
+	for _, r := range rows {
+		fmt.Fprintf(&b, "relay_channel_deliveries_total{channel=\"%s\"} %d\n", promLabel(r.channel), r.deliveries)
+	}
+
+	rejected := inboundRejectedCounts()
+	codes := make([]string, 0, len(rejected))
+	for code := range rejected {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	b.WriteString("# HELP relay_inbound_rejected_total Rejected client frames by error code.\n# TYPE relay_inbound_rejected_total counter\n")
+	for _, code := range codes {
+		fmt.Fprintf(&b, "relay_inbound_rejected_total{code=\"%s\"} %d\n", code, rejected[code])
+	}
+	fmt.Fprintf(&b, "# HELP relay_abuse_disconnects_total Connections closed for exceeding inbound.abuse_threshold.\n# TYPE relay_abuse_disconnects_total counter\nrelay_abuse_disconnects_total %d\n", abuseDisconnects.Load())
+
+	b.WriteString("# HELP relay_job_misfires_total Persisted push jobs whose run time passed while the relay was down, by action.\n# TYPE relay_job_misfires_total counter\n")
+	fmt.Fprintf(&b, "relay_job_misfires_total{action=\"fired\"} %d\n", jobMisfiresFired.Load())
+	fmt.Fprintf(&b, "relay_job_misfires_total{action=\"skipped\"} %d\n", jobMisfiresSkipped.Load())
+
+	if len(namespaces) > 0 {
+		nsConns := namespaceConnections()
+		names := make([]string, 0, len(nsConns))
+		for name := range nsConns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		b.WriteString("# HELP relay_namespace_connections Current connections per namespace.\n# TYPE relay_namespace_connections gauge\n")
+		for _, name := range names {
+			fmt.Fprintf(&b, "relay_namespace_connections{namespace=\"%s\"} %d\n", promLabel(name), nsConns[name])
+		}
+
+		traffic := trafficByNamespace()
+		names = append(names, defaultNamespaceName)
+		sort.Strings(names)
+		b.WriteString("# HELP relay_namespace_messages_total Frames sent to / received from connections, per namespace.\n# TYPE relay_namespace_messages_total counter\n")
+		for _, name := range names {
+			t := traffic[name]
+			fmt.Fprintf(&b, "relay_namespace_messages_total{namespace=\"%s\",direction=\"in\"} %d\n", promLabel(name), t.MessagesIn)
+			fmt.Fprintf(&b, "relay_namespace_messages_total{namespace=\"%s\",direction=\"out\"} %d\n", promLabel(name), t.MessagesOut)
+		}
+		b.WriteString("# HELP relay_namespace_bytes_total Frame bytes sent to / received from connections, per namespace.\n# TYPE relay_namespace_bytes_total counter\n")
+		for _, name := range names {
+			t := traffic[name]
+			fmt.Fprintf(&b, "relay_namespace_bytes_total{namespace=\"%s\",direction=\"in\"} %d\n", promLabel(name), t.BytesIn)
+			fmt.Fprintf(&b, "relay_namespace_bytes_total{namespace=\"%s\",direction=\"out\"} %d\n", promLabel(name), t.BytesOut)
+		}
+	}
+
+	if GlobalConfig.Admission.Enabled {
+		b.WriteString("# HELP relay_admission_total Admission webhook decisions, by result.\n# TYPE relay_admission_total counter\n")
+		fmt.Fprintf(&b, "relay_admission_total{result=\"accepted\"} %d\n", admissionAccepted.Load())
+		fmt.Fprintf(&b, "relay_admission_total{result=\"rejected\"} %d\n", admissionRejected.Load())
+		fmt.Fprintf(&b, "relay_admission_total{result=\"error\"} %d\n", admissionErrors.Load())
+	}
+
+	fmt.Fprintf(&b, "# HELP relay_ephemeral_pushes_total Pushes sent on the ephemeral fast path.\n# TYPE relay_ephemeral_pushes_total counter\nrelay_ephemeral_pushes_total %d\n", ephemeralPushes.Load())
+	fmt.Fprintf(&b, "# HELP relay_idempotent_replays_total /v1/push requests answered from the idempotency cache.\n# TYPE relay_idempotent_replays_total counter\nrelay_idempotent_replays_total %d\n", idempotencyReplays.Load())
+	fmt.Fprintf(&b, "# HELP relay_idempotency_conflicts_total /v1/push requests rejected because their Idempotency-Key was in use.\n# TYPE relay_idempotency_conflicts_total counter\nrelay_idempotency_conflicts_total %d\n", idempotencyConflicts.Load())
+	fmt.Fprintf(&b, "# HELP relay_ephemeral_dropped_total Ephemeral deliveries dropped because the connection was busy writing.\n# TYPE relay_ephemeral_dropped_total counter\nrelay_ephemeral_dropped_total %d\n", ephemeralDropped.Load())
+
+	if GlobalConfig.PushQueue.Enabled {
+		depth, oldest := pushQueueStats()
+		b.WriteString("# HELP relay_push_queue_depth Pushes waiting in the async push queue.\n# TYPE relay_push_queue_depth gauge\n")
+		fmt.Fprintf(&b, "relay_push_queue_depth %d\n", depth)
+		b.WriteString("# HELP relay_push_queue_capacity Capacity of the async push queue.\n# TYPE relay_push_queue_capacity gauge\n")
+		fmt.Fprintf(&b, "relay_push_queue_capacity %d\n", GlobalConfig.PushQueue.Size)
+		b.WriteString("# HELP relay_push_queue_oldest_age_seconds Time the oldest queued push has been waiting.\n# TYPE relay_push_queue_oldest_age_seconds gauge\n")
+		fmt.Fprintf(&b, "relay_push_queue_oldest_age_seconds %.3f\n", oldest.Seconds())
+		b.WriteString("# HELP relay_push_queue_total Async push queue operations, by result.\n# TYPE relay_push_queue_total counter\n")
+		fmt.Fprintf(&b, "relay_push_queue_total{result=\"enqueued\"} %d\n", pushQueueEnqueued.Load())
+		fmt.Fprintf(&b, "relay_push_queue_total{result=\"rejected\"} %d\n", pushQueueRejected.Load())
+		fmt.Fprintf(&b, "relay_push_queue_total{result=\"processed\"} %d\n", pushQueueProcessed.Load())
+	}
+
+	if GlobalConfig.PushQueue.Enabled || GlobalConfig.Backpressure.MaxHeapMB > 0 {
+		bp := backpressureCounts()
+		b.WriteString("# HELP relay_push_backpressure_total Push requests rejected by backpressure, by reason.\n# TYPE relay_push_backpressure_total counter\n")
+		for _, reason := range []string{backpressureQueueFull, backpressureQueueSaturated, backpressureQueueLagging, backpressureMemory} {
+			fmt.Fprintf(&b, "relay_push_backpressure_total{reason=\"%s\"} %d\n", reason, bp[reason])
+		}
+	}
+	if GlobalConfig.Backpressure.MaxHeapMB > 0 {
+		b.WriteString("# HELP relay_heap_bytes Sampled Go heap size used for push backpressure.\n# TYPE relay_heap_bytes gauge\n")
+		fmt.Fprintf(&b, "relay_heap_bytes %d\n", heapBytes.Load())
+	}
+
+	if GlobalConfig.ConnectRate.Enabled {
+		b.WriteString("# HELP relay_connect_rate_limited_total Connection upgrades rejected by the connect rate limiter, by scope.\n# TYPE relay_connect_rate_limited_total counter\n")
+		fmt.Fprintf(&b, "relay_connect_rate_limited_total{scope=\"ip\"} %d\n", connectRateLimitedIP.Load())
+		fmt.Fprintf(&b, "relay_connect_rate_limited_total{scope=\"global\"} %d\n", connectRateLimitedGlobal.Load())
+		b.WriteString("# HELP relay_connect_rate_queued_total Connection upgrades delayed by the global connect rate limiter.\n# TYPE relay_connect_rate_queued_total counter\n")
+		fmt.Fprintf(&b, "relay_connect_rate_queued_total %d\n", connectRateQueued.Load())
+	}
+
+	if GlobalConfig.InitialData.Enabled {
+		b.WriteString("# HELP relay_initial_data_total Initial data lookups after identify, by result.\n# TYPE relay_initial_data_total counter\n")
+		fmt.Fprintf(&b, "relay_initial_data_total{result=\"delivered\"} %d\n", initialDataDelivered.Load())
+		fmt.Fprintf(&b, "relay_initial_data_total{result=\"empty\"} %d\n", initialDataEmpty.Load())
+		fmt.Fprintf(&b, "relay_initial_data_total{result=\"error\"} %d\n", initialDataErrors.Load())
+	}
+
+	if GlobalConfig.Occupancy.Enabled {
+		b.WriteString("# HELP relay_occupancy_webhooks_total Channel occupancy webhooks delivered, by event.\n# TYPE relay_occupancy_webhooks_total counter\n")
+		fmt.Fprintf(&b, "relay_occupancy_webhooks_total{event=\"channel_occupied\"} %d\n", occupancyOccupiedSent.Load())
+		fmt.Fprintf(&b, "relay_occupancy_webhooks_total{event=\"channel_vacated\"} %d\n", occupancyVacatedSent.Load())
+		fmt.Fprintf(&b, "# HELP relay_occupancy_webhook_errors_total Occupancy webhooks that failed after all retries.\n# TYPE relay_occupancy_webhook_errors_total counter\nrelay_occupancy_webhook_errors_total %d\n", occupancyErrors.Load())
+		fmt.Fprintf(&b, "# HELP relay_occupancy_events_dropped_total Occupancy events dropped because the send queue was full.\n# TYPE relay_occupancy_events_dropped_total counter\nrelay_occupancy_events_dropped_total %d\n", occupancyDropped.Load())
+		fmt.Fprintf(&b, "# HELP relay_occupancy_vacates_suppressed_total Vacate events cancelled because the channel was re-occupied within the delay.\n# TYPE relay_occupancy_vacates_suppressed_total counter\nrelay_occupancy_vacates_suppressed_total %d\n", occupancySuppressed.Load())
+	}
+
+	if GlobalConfig.KV.Enabled {
+		users, keys := kvTotals()
+		fmt.Fprintf(&b, "# HELP relay_kv_users Users with at least one KV key.\n# TYPE relay_kv_users gauge\nrelay_kv_users %d\n", users)
+		fmt.Fprintf(&b, "# HELP relay_kv_keys KV keys stored across all users.\n# TYPE relay_kv_keys gauge\nrelay_kv_keys %d\n", keys)
+		b.WriteString("# HELP relay_kv_writes_total KV sets and deletes, by source.\n# TYPE relay_kv_writes_total counter\n")
+		fmt.Fprintf(&b, "relay_kv_writes_total{source=\"api\"} %d\n", kvWritesAPI.Load())
+		fmt.Fprintf(&b, "relay_kv_writes_total{source=\"client\"} %d\n", kvWritesClient.Load())
+		fmt.Fprintf(&b, "# HELP relay_kv_rejected_total KV writes refused for quota or permission.\n# TYPE relay_kv_rejected_total counter\nrelay_kv_rejected_total %d\n", kvRejected.Load())
+	}
+
+	if GlobalConfig.Groups.Enabled {
+		count, members := groupCounts()
+		fmt.Fprintf(&b, "# HELP relay_groups Named connection groups currently defined.\n# TYPE relay_groups gauge\nrelay_groups %d\n", count)
+		fmt.Fprintf(&b, "# HELP relay_group_members User and connection members across all groups.\n# TYPE relay_group_members gauge\nrelay_group_members %d\n", members)
+		fmt.Fprintf(&b, "# HELP relay_group_pushes_total Pushes sent to a named group.\n# TYPE relay_group_pushes_total counter\nrelay_group_pushes_total %d\n", groupPushes.Load())
+	}
+
+	if GlobalConfig.Aggregation.Enabled {
+		fmt.Fprintf(&b, "# HELP relay_aggregated_events_total Inbound client events buffered for aggregation webhooks.\n# TYPE relay_aggregated_events_total counter\nrelay_aggregated_events_total %d\n", aggregationEvents.Load())
+		fmt.Fprintf(&b, "# HELP relay_aggregation_dropped_total Inbound events dropped by max_events or max_pending_events.\n# TYPE relay_aggregation_dropped_total counter\nrelay_aggregation_dropped_total %d\n", aggregationDropped.Load())
+		b.WriteString("# HELP relay_aggregation_posts_total Aggregation webhook posts by result.\n# TYPE relay_aggregation_posts_total counter\n")
+		fmt.Fprintf(&b, "relay_aggregation_posts_total{result=\"ok\"} %d\n", aggregationPosted.Load())
+		fmt.Fprintf(&b, "relay_aggregation_posts_total{result=\"error\"} %d\n", aggregationErrors.Load())
+	}
+
+	if GlobalConfig.Expiry.Enabled {
+		b.WriteString("# HELP relay_expired_total Channels and groups removed by automatic expiry.\n# TYPE relay_expired_total counter\n")
+		fmt.Fprintf(&b, "relay_expired_total{kind=\"channel\"} %d\n", expiredChannels.Load())
+		fmt.Fprintf(&b, "relay_expired_total{kind=\"group\"} %d\n", expiredGroups.Load())
+		b.WriteString("# HELP relay_expiry_callbacks_total Expiry callbacks by result.\n# TYPE relay_expiry_callbacks_total counter\n")
+		fmt.Fprintf(&b, "relay_expiry_callbacks_total{result=\"ok\"} %d\n", expiryCallbackOK.Load())
+		fmt.Fprintf(&b, "relay_expiry_callbacks_total{result=\"error\"} %d\n", expiryErrors.Load())
+		fmt.Fprintf(&b, "relay_expiry_callbacks_total{result=\"dropped\"} %d\n", expiryDropped.Load())
+	}
+
+	if GlobalConfig.OfflineQueue.Enabled {
+		users, messages := offlineTotals()
+		fmt.Fprintf(&b, "# HELP relay_offline_queue_users Users with queued offline messages.\n# TYPE relay_offline_queue_users gauge\nrelay_offline_queue_users %d\n", users)
+		fmt.Fprintf(&b, "# HELP relay_offline_queue_messages Messages waiting in offline queues.\n# TYPE relay_offline_queue_messages gauge\nrelay_offline_queue_messages %d\n", messages)
+		b.WriteString("# HELP relay_offline_messages_total Offline queue messages, by outcome.\n# TYPE relay_offline_messages_total counter\n")
+		fmt.Fprintf(&b, "relay_offline_messages_total{outcome=\"queued\"} %d\n", offlineQueued.Load())
+		fmt.Fprintf(&b, "relay_offline_messages_total{outcome=\"flushed\"} %d\n", offlineFlushed.Load())
+		fmt.Fprintf(&b, "relay_offline_messages_total{outcome=\"dropped\"} %d\n", offlineDropped.Load())
+		fmt.Fprintf(&b, "relay_offline_messages_total{outcome=\"expired\"} %d\n", offlineExpired.Load())
+	}
+
+	if GlobalConfig.PresenceStream.Enabled {
+		fmt.Fprintf(&b, "# HELP relay_presence_stream_subscribers Open presence stream subscriptions.\n# TYPE relay_presence_stream_subscribers gauge\nrelay_presence_stream_subscribers %d\n", presenceActive.Load())
+		fmt.Fprintf(&b, "# HELP relay_presence_stream_overflows_total Presence stream subscribers disconnected for falling behind.\n# TYPE relay_presence_stream_overflows_total counter\nrelay_presence_stream_overflows_total %d\n", presenceOverflows.Load())
+	}
+
+	if GlobalConfig.PushCallbacks.Enabled {
+		b.WriteString("# HELP relay_push_callbacks_total Push result callbacks by result.\n# TYPE relay_push_callbacks_total counter\n")
+		fmt.Fprintf(&b, "relay_push_callbacks_total{result=\"ok\"} %d\n", pushCallbacksOK.Load())
+		fmt.Fprintf(&b, "relay_push_callbacks_total{result=\"error\"} %d\n", pushCallbacksErrors.Load())
+		fmt.Fprintf(&b, "relay_push_callbacks_total{result=\"dropped\"} %d\n", pushCallbacksDropped.Load())
+	}
+
+	if GlobalConfig.OrderedDelivery.Enabled {
+		fmt.Fprintf(&b, "# HELP relay_ordered_pending User pushes waiting in per-user dispatch queues.\n# TYPE relay_ordered_pending gauge\nrelay_ordered_pending %d\n", orderedPending.Load())
+		fmt.Fprintf(&b, "# HELP relay_ordered_users Users with an active dispatch queue.\n# TYPE relay_ordered_users gauge\nrelay_ordered_users %d\n", orderedUsers())
+	}
+
+	if GlobalConfig.AckRetry.Enabled {
+		fmt.Fprintf(&b, "# HELP relay_ack_pending Connections still waiting to ack a require_ack push.\n# TYPE relay_ack_pending gauge\nrelay_ack_pending %d\n", ackPendingTotal())
+		fmt.Fprintf(&b, "# HELP relay_ack_retries_total Resends of require_ack pushes after an ack timeout.\n# TYPE relay_ack_retries_total counter\nrelay_ack_retries_total %d\n", ackRetries.Load())
+		b.WriteString("# HELP relay_ack_results_total Final outcome per connection of require_ack pushes.\n# TYPE relay_ack_results_total counter\n")
+		fmt.Fprintf(&b, "relay_ack_results_total{result=\"acked\"} %d\n", ackAcks.Load())
+		fmt.Fprintf(&b, "relay_ack_results_total{result=\"failed\"} %d\n", ackFailures.Load())
+	}
+
+	if GlobalConfig.Bans.Enabled {
+		signals, issued, active := banCounts()
+		b.WriteString("# HELP relay_abuse_signals_total Abuse signals recorded for temp-ban scoring, by signal.\n# TYPE relay_abuse_signals_total counter\n")
+		for _, s := range []string{banSignalUpgrade, banSignalAuth, banSignalParse, banSignalRate} {
+			fmt.Fprintf(&b, "relay_abuse_signals_total{signal=\"%s\"} %d\n", s, signals[s])
+		}
+		b.WriteString("# HELP relay_bans_total Temporary bans issued, by kind.\n# TYPE relay_bans_total counter\n")
+		fmt.Fprintf(&b, "relay_bans_total{kind=\"ip\"} %d\n", issued[banKindIP])
+		fmt.Fprintf(&b, "relay_bans_total{kind=\"token\"} %d\n", issued[banKindToken])
+		fmt.Fprintf(&b, "# HELP relay_bans_active Bans currently in effect.\n# TYPE relay_bans_active gauge\nrelay_bans_active %d\n", active)
+		fmt.Fprintf(&b, "# HELP relay_banned_rejections_total Connections, requests and identifies refused because of a ban.\n# TYPE relay_banned_rejections_total counter\nrelay_banned_rejections_total %d\n", banRejected.Load())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabel 按 Prometheus 文本格式转义标签值
+func promLabel(s string) string {
+	return promLabelEscaper.Replace(s)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ===== 多用户推送 =====
+//
+// 同一条通知发给一批用户（群聊成员、订单相关的几方）时，不必逐个调用推送接口：token 可以是用户 ID 数组，
+// 元素和单个 token 一样可以是字符串、数字或带 id / user_id 的对象：
+//
+//	{"event_name":"chat.message","token":["u1","u2",42],"subject":{...}}
+//	→ {"code":0,"msg":"ok","data":{"event_name":"chat.message","broadcast":false,"target_user_ids":["u1","u2","42"],
+//	    "delivered":3,"users":{"u1":2,"u2":1,"42":0}}}
+//
+// 每个用户按单用户推送发送（进用户历史、按用户保序），users 是各用户投递到的连接数，delivered 是总数。
+// 下发给每个用户的 data.token 是该用户自己的 ID，不会把整个收件人列表发给所有人。
+// 重复的 ID 只发一次；不能和 channel / group / device_id / client_id 同时使用，selector / namespace 照常过滤。
+
+const pushMaxTargetUsers = 1000
+
+// parseTargetUsers token 为数组时解析出去重后的用户 ID 列表；不是数组时返回 nil
+func parseTargetUsers(token interface{}) ([]string, string, error) {
+	list, ok := token.([]interface{})
+	if !ok {
+		return nil, "", nil
+	}
+	if len(list) == 0 {
+		return nil, "token", errors.New("token array must not be empty")
+	}
+	return parseUserList("token", list)
+}
+
+// parseUserList 把 token 形式的数组（字符串 / 数字 / 带 id 的对象）解析成去重后的用户 ID，出错时返回元素的字段名
+func parseUserList(field string, list []interface{}) ([]string, string, error) {
+	if len(list) > pushMaxTargetUsers {
+		return nil, field, fmt.Errorf("at most %d users per push", pushMaxTargetUsers)
+	}
+	ids := make([]string, 0, len(list))
+	seen := make(map[string]struct{}, len(list))
+	for i, v := range list {
+		id := parseUserToID(v)
+		if id == "" {
+			return nil, fmt.Sprintf("%s[%d]", field, i), errors.New("must be a user id")
+		}
+		if _, dup := seen[id]; dup {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	return ids, "", nil
+}
+
+// validateTargetUsers 多用户推送不能再叠加的定向方式
+func validateTargetUsers(body PushRequest) (string, error) {
+	switch {
+	case body.Channel != "" || body.Group != "":
+		return "token", errors.New("token array cannot be combined with channel / group")
+	case body.DeviceID != "" || body.ClientID != "":
+		return "token", errors.New("token array cannot be combined with device_id / client_id")
+	}
+	return "", nil
+}
+
+// routeUsers 逐个用户按单用户推送发送，记录各用户的投递数，返回总数
+func (p *preparedPush) routeUsers() int {
+	if p.logIt {
+		log.Printf("👥 多用户推送 \"%s\" 给 %d 个用户, payload=%s\n", p.body.EventName, len(p.targets), p.payloadLog)
+	}
+	counts := make(map[string]int, len(p.targets))
+	total := 0
+	for _, uid := range p.targets {
+		sub := p.forUser(uid)
+		var n int
+		switch {
+		case p.message.Ephemeral:
+			n = emitToUserConns(uid, sub.message, p.match)
+		case GlobalConfig.OrderedDelivery.Enabled:
+			n = reserveUserOrder(uid).run(sub.route)
+		default:
+			n = sub.route()
+		}
+		counts[uid] = n
+		total += n
+	}
+	p.userCounts = counts
+	return total
+}
+
+// forUser 拆出发给其中一个用户的单用户推送，载荷里的 token 换成该用户自己的 ID
+func (p *preparedPush) forUser(uid string) *preparedPush {
+	sub := *p
+	sub.target, sub.targets, sub.logIt = uid, nil, false
+	sub.message.Data = payloadForUser(p.message.Data, uid)
+	if len(p.events) > 0 {
+		sub.events = make([]WSMessage, len(p.events))
+		for i, m := range p.events {
+			m.Data = payloadForUser(m.Data, uid)
+			sub.events[i] = m
+		}
+	}
+	return &sub
+}
+
+func payloadForUser(data interface{}, uid string) interface{} {
+	if pl, ok := data.(Payload); ok {
+		pl.Token = uid
+		return pl
+	}
+	return data
+}
+
+// ===== 排除用户 =====
+//
+// 广播、频道和组推送可以带 exclude_tokens 跳过一部分用户的所有连接，典型场景是聊天消息不回显给发送者：
+//
+//	{"event_name":"chat.message","channel":"room:1","subject":{...},"exclude_tokens":["u1"]}
+//
+// 元素格式与 token 相同；不能用于单用户 / 多用户推送（直接不写进 token 即可）。匿名连接不受影响。
+
+// excludeUsersMatch 在原有的连接过滤上再排除 exclude_tokens 里的用户，出错时同时返回字段名
+func excludeUsersMatch(body PushRequest, targeted bool, match func(*Client) bool) (func(*Client) bool, string, error) {
+	if len(body.ExcludeTokens) == 0 {
+		return match, "", nil
+	}
+	if targeted {
+		return nil, "exclude_tokens", errors.New("exclude_tokens only applies to broadcast, channel and group pushes")
+	}
+	ids, field, err := parseUserList("exclude_tokens", body.ExcludeTokens)
+	if err != nil {
+		return nil, field, err
+	}
+	excluded := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		excluded[id] = struct{}{}
+	}
+	return func(c *Client) bool {
+		if _, skip := excluded[c.userID]; skip && c.userID != "" {
+			return false
+		}
+		return match == nil || match(c)
+	}, "", nil
+}
+
+// scrubPushUser 用户数据删除时从持久化的推送请求里去掉该用户：token 数组和 exclude_tokens 去掉对应元素。
+// keep 为 false 表示整条推送都是发给该用户的（单用户 token，或数组去掉后为空），应整条删除；changed 表示请求涉及该用户
+func scrubPushUser(body *PushRequest, userID string) (keep, changed bool) {
+	if userID == "" {
+		return true, false
+	}
+	if parseUserToID(body.Token) == userID {
+		return false, true
+	}
+	if list, ok := body.Token.([]interface{}); ok {
+		rest, removed := withoutUser(list, userID)
+		if removed {
+			if len(rest) == 0 {
+				return false, true
+			}
+			body.Token, changed = rest, true
+		}
+	}
+	if rest, removed := withoutUser(body.ExcludeTokens, userID); removed {
+		body.ExcludeTokens, changed = rest, true
+		if len(rest) == 0 {
+			body.ExcludeTokens = nil
+		}
+	}
+	return true, changed
+}
+
+// withoutUser 返回去掉 userID 之后的新数组，不改动原数组
+func withoutUser(list []interface{}, userID string) ([]interface{}, bool) {
+	rest := make([]interface{}, 0, len(list))
+	for _, v := range list {
+		if parseUserToID(v) != userID {
+			rest = append(rest, v)
+		}
+	}
+	return rest, len(rest) != len(list)
+}
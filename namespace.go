@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// ===== 命名空间（多个 WebSocket 路径） =====
+//
+// 一个中继同时服务多个前端应用时，每个应用用自己的路径连接，各自有独立的 Origin 白名单和限制：
+//
+//	"namespaces": [
+//	  {"name": "app1", "path": "/ws/app1", "allowed_origins": ["https://app1.example.com"], "max_connections": 10000},
+//	  {"name": "app2", "path": "/ws/app2", "allowed_origins": ["https://*.app2.example.com"], "max_frame_bytes": 16384}
+//	]
+//
+// 命名空间路径上的连接就是原生 WebSocket 连接，协议与 ws_path 完全一致，只是带上了命名空间；
+// 推送时带 "namespace":"app1" 只发给该命名空间的连接（用户组、频道仍是全局的，按连接过滤）。
+// ws_path 上的连接不属于任何命名空间，不受这里的限制。
+
+// NamespaceConfig 一个命名空间
+type NamespaceConfig struct {
+	Name           string   `json:"name"`
+	Path           string   `json:"path"`
+	AllowedOrigins []string `json:"allowed_origins"` // 允许的 Origin，支持 * 通配；为空不限制，不带 Origin 的非浏览器客户端总是放行
+	MaxConnections int      `json:"max_connections"` // 连接数上限，0 不限制
+	MaxFrameBytes  int      `json:"max_frame_bytes"` // 覆盖 inbound.max_frame_bytes，0 沿用全局
+	AllowedEvents  []string `json:"allowed_events"`  // 覆盖 inbound.allowed_events，为空沿用全局
+}
+
+// namespace 运行时的命名空间
+type namespace struct {
+	cfg     NamespaceConfig
+	conns   atomic.Int64
+	traffic trafficCounters // 收发计数，见 usagestats.go
+}
+
+type namespaceKey struct{}
+
+// namespaces name → 命名空间，启动后只读
+var namespaces = make(map[string]*namespace)
+
+func prepareNamespaces(cfgs []NamespaceConfig) {
+	paths := map[string]bool{GlobalConfig.WSPath: true}
+	for _, cfg := range cfgs {
+		switch {
+		case cfg.Name == "":
+			log.Fatalln("❌ namespaces 配置错误：name 不能为空")
+		case cfg.Name == defaultNamespaceName:
+			log.Fatalf("❌ namespaces 配置错误：name %s 保留给不属于任何命名空间的连接\n", defaultNamespaceName)
+		case namespaces[cfg.Name] != nil:
+			log.Fatalf("❌ namespaces 配置错误：name 重复 %s\n", cfg.Name)
+		case !strings.HasPrefix(cfg.Path, "/"):
+			log.Fatalf("❌ namespaces.%s 配置错误：path 必须以 / 开头\n", cfg.Name)
+		case paths[cfg.Path]:
+			log.Fatalf("❌ namespaces.%s 配置错误：path %s 已被占用\n", cfg.Name, cfg.Path)
+		}
+		for _, o := range cfg.AllowedOrigins {
+			if _, err := path.Match(o, ""); err != nil {
+				log.Fatalf("❌ namespaces.%s.allowed_origins 无效 %q: %v\n", cfg.Name, o, err)
+			}
+		}
+		paths[cfg.Path] = true
+		namespaces[cfg.Name] = &namespace{cfg: cfg}
+	}
+}
+
+// registerNamespaceRoutes 每个命名空间的路径都挂原生 WebSocket 入口
+func registerNamespaceRoutes(mux *http.ServeMux) {
+	for _, ns := range namespaces {
+		mux.HandleFunc(ns.cfg.Path, namespaceGuard(ns, upgradeGuard(wsHandler)))
+		log.Printf("✅ 命名空间 %s：%s\n", ns.cfg.Name, ns.cfg.Path)
+	}
+}
+
+// namespaceGuard 检查连接数上限并把命名空间放进请求 context；wsHandler 在连接存续期间不返回，计数随之准确
+func namespaceGuard(ns *namespace, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if max := int64(ns.cfg.MaxConnections); max > 0 {
+			if ns.conns.Add(1) > max {
+				ns.conns.Add(-1)
+				log.Printf("⛔ 命名空间 %s 连接数已达上限 %d，拒绝 from=%s\n", ns.cfg.Name, max, r.RemoteAddr)
+				writeProblem(w, r, http.StatusServiceUnavailable, problemUnavailable, "namespace connection limit reached")
+				return
+			}
+		} else {
+			ns.conns.Add(1)
+		}
+		defer ns.conns.Add(-1)
+		next(w, r.WithContext(context.WithValue(r.Context(), namespaceKey{}, ns)))
+	}
+}
+
+// namespaceFromRequest 请求所属的命名空间，ws_path 等其它入口为 nil
+func namespaceFromRequest(r *http.Request) *namespace {
+	ns, _ := r.Context().Value(namespaceKey{}).(*namespace)
+	return ns
+}
+
+// checkOrigin 升级时的 Origin 检查：命名空间配置了 allowed_origins 时按白名单，否则放行
+func checkOrigin(r *http.Request) bool {
+	ns := namespaceFromRequest(r)
+	origin := r.Header.Get("Origin")
+	if ns == nil || len(ns.cfg.AllowedOrigins) == 0 || origin == "" {
+		return true
+	}
+	for _, pattern := range ns.cfg.AllowedOrigins {
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	log.Printf("⛔ 命名空间 %s 拒绝来源 Origin=%s from=%s\n", ns.cfg.Name, origin, r.RemoteAddr)
+	return false
+}
+
+// inboundConfigFor 连接生效的上行校验配置：命名空间的覆盖项优先
+func inboundConfigFor(c *Client) InboundConfig {
+	cfg := GlobalConfig.Inbound
+	if c.namespace == nil {
+		return cfg
+	}
+	if c.namespace.cfg.MaxFrameBytes > 0 {
+		cfg.MaxFrameBytes = c.namespace.cfg.MaxFrameBytes
+	}
+	if len(c.namespace.cfg.AllowedEvents) > 0 {
+		cfg.AllowedEvents = c.namespace.cfg.AllowedEvents
+	}
+	return cfg
+}
+
+// namespaceName 连接所属命名空间的名字，不属于任何命名空间时为空
+func (c *Client) namespaceName() string {
+	if c.namespace == nil {
+		return ""
+	}
+	return c.namespace.cfg.Name
+}
+
+// namespaceConnections 各命名空间当前的连接数，用于指标
+func namespaceConnections() map[string]int64 {
+	out := make(map[string]int64, len(namespaces))
+	for name, ns := range namespaces {
+		out[name] = ns.conns.Load()
+	}
+	return out
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 频道占用 webhook =====
+//
+// 行情、比分这类实时数据，没人看的频道就不该继续生产。开启 occupancy 后频道在“没人订阅”和“有人订阅”之间切换时
+// POST 给业务后端：
+//
+//	{"event":"channel_occupied","channel":"prices:BTC","ts":1760580000000,"node_id":"relay-1"}
+//	{"event":"channel_vacated","channel":"prices:BTC","ts":1760580012000,"node_id":"relay-1"}
+//
+// 第一个订阅者加入时立即发 channel_occupied；最后一个订阅者离开后等 vacate_delay_seconds，期间没人重新订阅才发
+// channel_vacated，页面刷新、断线重连这类短暂的空窗不会让后端反复启停。
+// 事件由单个协程按顺序发送，失败重试 retries 次；队列满时丢弃并计数。
+// 占用状态按节点统计，集群部署时后端需要按 node_id 汇总。
+// 内嵌使用时可以用 WithOccupancy 给 Hub 指定自己的观察者，不走 HTTP。
+
+// OccupancyConfig 频道占用 webhook 配置
+type OccupancyConfig struct {
+	Enabled            bool     `json:"enabled"`
+	URL                string   `json:"url"`
+	Secret             string   `json:"secret"`               // 可选：请求签名密钥，支持密钥引用，签名方式与准入 webhook 相同
+	TimeoutSeconds     int      `json:"timeout_seconds"`      // 默认 3
+	VacateDelaySeconds int      `json:"vacate_delay_seconds"` // 频道空了多久才发 channel_vacated，默认 5，-1 表示立即发送
+	Retries            int      `json:"retries"`              // 失败重试次数，默认 2
+	Channels           []string `json:"channels"`             // 只报告这些频道，支持 * / ? 通配；为空表示全部
+}
+
+// ChannelOccupancy 频道占用变化的观察者；Hub 持有频道锁时调用，实现不能阻塞
+type ChannelOccupancy interface {
+	ChannelOccupied(channel string)
+	ChannelVacated(channel string)
+}
+
+const (
+	occupancyDefaultTimeout     = 3
+	occupancyDefaultVacateDelay = 5
+	occupancyDefaultRetries     = 2
+	occupancyQueueSize          = 1024
+	occupancyRetryBackoff       = time.Second
+
+	occupancyEventOccupied = "channel_occupied"
+	occupancyEventVacated  = "channel_vacated"
+)
+
+// occupancyEvent 发给 webhook 的请求体
+type occupancyEvent struct {
+	Event   string `json:"event"`
+	Channel string `json:"channel"`
+	Ts      int64  `json:"ts"`
+	NodeID  string `json:"node_id,omitempty"`
+
+	cfg OccupancyConfig // 发送时用的配置，取自入队的观察者
+}
+
+// occupancyState 一个已报告为占用的频道
+type occupancyState struct {
+	pending *time.Timer // 等待发送 channel_vacated 的定时器
+	gen     uint64      // 每次安排 / 取消定时器递增，过期的定时器回调据此放弃
+}
+
+var (
+	occupancyClient *http.Client
+	occupancyQueue  chan occupancyEvent
+
+	occupancyMu       sync.Mutex
+	occupancyChannels = make(map[string]*occupancyState) // 已报告占用的频道
+
+	occupancyOccupiedSent atomic.Uint64
+	occupancyVacatedSent  atomic.Uint64
+	occupancyErrors       atomic.Uint64
+	occupancyDropped      atomic.Uint64
+	occupancySuppressed   atomic.Uint64 // 空窗期内重新有人订阅，没有发出的 channel_vacated
+)
+
+func prepareOccupancy(cfg *OccupancyConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.URL == "" {
+		log.Fatalln("❌ occupancy 已开启但没有配置 url")
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = occupancyDefaultTimeout
+	}
+	switch {
+	case cfg.VacateDelaySeconds < 0:
+		cfg.VacateDelaySeconds = 0
+	case cfg.VacateDelaySeconds == 0:
+		cfg.VacateDelaySeconds = occupancyDefaultVacateDelay
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = occupancyDefaultRetries
+	} else if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	patterns := cfg.Channels[:0]
+	for _, p := range cfg.Channels {
+		if _, err := path.Match(p, ""); err != nil {
+			log.Printf("⚠️ occupancy.channels 模式无效，已忽略: %s\n", p)
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	cfg.Channels = patterns
+	occupancyClient = &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}
+}
+
+// startOccupancySender 启动发送协程
+func startOccupancySender() {
+	occupancyQueue = make(chan occupancyEvent, occupancyQueueSize)
+	go func() {
+		for ev := range occupancyQueue {
+			sendOccupancyEvent(ev)
+		}
+	}()
+}
+
+// webhookOccupancy 默认观察者：按构造时给定的配置报告，未开启 occupancy 时什么都不做。
+// 已报告的频道和发送队列是进程级的，同一进程里只应有一个 Hub 使用它
+type webhookOccupancy struct {
+	cfg *Config
+}
+
+func (o webhookOccupancy) ChannelOccupied(channel string) {
+	if !o.tracked(channel) {
+		return
+	}
+	occupancyMu.Lock()
+	defer occupancyMu.Unlock()
+
+	if st, ok := occupancyChannels[channel]; ok {
+		// 还在空窗期内，取消待发的 channel_vacated，后端看不到这次空窗
+		if st.pending != nil {
+			st.pending.Stop()
+			st.pending = nil
+			st.gen++
+			occupancySuppressed.Add(1)
+		}
+		return
+	}
+	occupancyChannels[channel] = &occupancyState{}
+	o.enqueue(occupancyEventOccupied, channel)
+}
+
+func (o webhookOccupancy) ChannelVacated(channel string) {
+	if !o.tracked(channel) {
+		return
+	}
+	occupancyMu.Lock()
+	defer occupancyMu.Unlock()
+
+	st, ok := occupancyChannels[channel]
+	if !ok || st.pending != nil {
+		return
+	}
+	delay := time.Duration(o.cfg.Occupancy.VacateDelaySeconds) * time.Second
+	if delay <= 0 {
+		delete(occupancyChannels, channel)
+		o.enqueue(occupancyEventVacated, channel)
+		return
+	}
+	st.gen++
+	gen := st.gen
+	st.pending = time.AfterFunc(delay, func() { o.fireVacated(channel, st, gen) })
+}
+
+// fireVacated 空窗期结束仍然没人订阅，发 channel_vacated
+func (o webhookOccupancy) fireVacated(channel string, st *occupancyState, gen uint64) {
+	occupancyMu.Lock()
+	defer occupancyMu.Unlock()
+
+	if occupancyChannels[channel] != st || st.gen != gen {
+		return
+	}
+	delete(occupancyChannels, channel)
+	o.enqueue(occupancyEventVacated, channel)
+}
+
+// tracked 频道是否需要报告
+func (o webhookOccupancy) tracked(channel string) bool {
+	cfg := o.cfg.Occupancy
+	if !cfg.Enabled {
+		return false
+	}
+	if len(cfg.Channels) == 0 {
+		return true
+	}
+	for _, p := range cfg.Channels {
+		if ok, _ := path.Match(p, channel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue 交给发送协程，队列满时丢弃；调用方持有 occupancyMu，保证同一频道的事件按顺序入队
+func (o webhookOccupancy) enqueue(event, channel string) {
+	ev := occupancyEvent{Event: event, Channel: channel, Ts: time.Now().UnixMilli(), cfg: o.cfg.Occupancy}
+	if o.cfg.Cluster.Enabled {
+		ev.NodeID = o.cfg.Cluster.NodeID
+	}
+	select {
+	case occupancyQueue <- ev:
+	default:
+		occupancyDropped.Add(1)
+		log.Printf("⚠️ 频道占用事件队列已满，丢弃 %s channel=%s\n", event, channel)
+	}
+}
+
+// sendOccupancyEvent 发送一个事件，失败按配置重试
+func sendOccupancyEvent(ev occupancyEvent) {
+	cfg := ev.cfg
+	retry := webhookRetry{client: occupancyClient, retries: cfg.Retries, backoff: occupancyRetryBackoff}
+	if err := deliverWebhook(cfg.URL, liveSecret("occupancy.secret", cfg.Secret), ev, retry); err != nil {
+		occupancyErrors.Add(1)
+		log.Printf("⚠️ 频道占用 webhook 发送失败 %s channel=%s: %v\n", ev.Event, ev.Channel, err)
+		return
+	}
+
+	if ev.Event == occupancyEventOccupied {
+		occupancyOccupiedSent.Add(1)
+	} else {
+		occupancyVacatedSent.Add(1)
+	}
+	log.Printf("📡 频道占用变化 %s channel=%s\n", ev.Event, ev.Channel)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 每用户离线消息队列 =====
+//
+// 单用户推送时用户一个在线连接都没有，消息先放进该用户的有界队列，用户下次 identify（含准入 webhook 指定用户）
+// 后按原顺序补发给这个连接，在 initial_data / kv_state 和 last_seq 断线补发之后：
+//
+//	"offline_queue": { "enabled": true, "max_messages": 100, "ttl_seconds": 86400, "overflow": "drop_oldest" }
+//
+// 队列满时按 overflow 处理：drop_oldest（默认）挤掉最早的一条，drop_newest 丢弃新来的消息。
+// 超过 ttl_seconds 的消息不再补发，定期清理。
+// 只接单用户推送（emitToUser）：按设备 / 连接定向、频道、组、广播和 ephemeral 消息不入队。
+// 补发期间发给这个连接的实时推送先攒着，补完再写出，不会插到离线消息前面（见 deliverBacklog）。
+// 开启 history 时入队的消息带 seq，同一次 identify 里 last_seq 断线补发已经下发过的不再重复补发。
+// 队列只在本节点内存中，重启或集群部署时连到其它节点都不会补发。
+
+// OfflineQueueConfig 离线消息队列配置
+type OfflineQueueConfig struct {
+	Enabled     bool   `json:"enabled"`
+	MaxMessages int    `json:"max_messages"` // 每用户最多保留的消息条数，默认 100
+	TTLSeconds  int    `json:"ttl_seconds"`  // 消息最多保留多久，默认 86400
+	Overflow    string `json:"overflow"`     // 队列满时：drop_oldest（默认）/ drop_newest
+}
+
+const (
+	offlineQueueDefaultMax = 100
+	offlineQueueDefaultTTL = 86400
+
+	offlineOverflowDropOldest = "drop_oldest"
+	offlineOverflowDropNewest = "drop_newest"
+)
+
+func prepareOfflineQueue(cfg *OfflineQueueConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = offlineQueueDefaultMax
+	}
+	if cfg.TTLSeconds <= 0 {
+		cfg.TTLSeconds = offlineQueueDefaultTTL
+	}
+	switch cfg.Overflow {
+	case "":
+		cfg.Overflow = offlineOverflowDropOldest
+	case offlineOverflowDropOldest, offlineOverflowDropNewest:
+	default:
+		log.Printf("⚠️ offline_queue.overflow 不支持: %s，已回退为 %s\n", cfg.Overflow, offlineOverflowDropOldest)
+		noteConfigProblem("offline_queue.overflow 不支持: " + cfg.Overflow)
+		cfg.Overflow = offlineOverflowDropOldest
+	}
+}
+
+// offlineMessage 队列里的一条消息
+type offlineMessage struct {
+	msg      WSMessage
+	queuedAt time.Time
+}
+
+var (
+	offlineMu     sync.Mutex
+	offlineQueues = make(map[string][]offlineMessage)
+
+	offlineQueued  atomic.Uint64
+	offlineFlushed atomic.Uint64
+	offlineDropped atomic.Uint64 // 队列满被挤掉或拒绝
+	offlineExpired atomic.Uint64
+)
+
+// queueIfOffline 用户没有在线连接时把消息放进离线队列，返回是否已入队；
+// 在线判断和入队都在 usersMu 读锁内完成，identify 注册用户组之后再取队列，不会漏掉中间入队的消息
+func (h *Hub) queueIfOffline(userID string, msg WSMessage) bool {
+	h.usersMu.RLock()
+	defer h.usersMu.RUnlock()
+	if len(h.users[userID]) > 0 {
+		return false
+	}
+	h.hooks.QueueOffline(userID, msg, h.now())
+	return true
+}
+
+func enqueueOffline(userID string, msg WSMessage, now time.Time) {
+	cfg := GlobalConfig.OfflineQueue
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+
+	q := offlineQueues[userID]
+	if len(q) >= cfg.MaxMessages {
+		offlineDropped.Add(1)
+		if cfg.Overflow == offlineOverflowDropNewest {
+			return
+		}
+		q = q[1:]
+	}
+	offlineQueues[userID] = append(q, offlineMessage{msg: msg, queuedAt: now})
+	offlineQueued.Add(1)
+}
+
+// beginBacklog 连接加入用户组之前调用：有积压消息要补发（离线队列或 after > 0 的断线补发）时先攒住实时推送
+func beginBacklog(c *Client, after uint64) {
+	if GlobalConfig.OfflineQueue.Enabled || (after > 0 && GlobalConfig.History.Size > 0) {
+		c.holdPushes()
+	}
+}
+
+// deliverBacklog 连接加入用户组之后依次下发初始数据、kv 状态、断线补发（after > 0）和离线消息，
+// 最后写出期间攒下的实时推送
+func deliverBacklog(c *Client, userID string, after uint64) {
+	defer func() {
+		if err := c.releasePushes(); err != nil {
+			log.Printf("⚠️ 补发期间的推送写出失败 conn=%s: %v\n", c.id, err)
+		}
+	}()
+	sendInitialData(c, userID)
+	sendKVState(c, userID)
+	var replayed replayedSeqs
+	if after > 0 && GlobalConfig.History.Size > 0 {
+		var err error
+		if replayed, err = replayUserHistory(c, userID, after); err != nil {
+			return
+		}
+	}
+	flushOfflineQueue(c, userID, replayed)
+}
+
+// flushOfflineQueue identify 成功后把用户的离线消息按顺序补发给该连接，跳过断线补发已经下发过的；
+// 发送失败时没发出去的放回队列
+func flushOfflineQueue(c *Client, userID string, replayed replayedSeqs) {
+	if !GlobalConfig.OfflineQueue.Enabled {
+		return
+	}
+	offlineMu.Lock()
+	q := offlineQueues[userID]
+	delete(offlineQueues, userID)
+	offlineMu.Unlock()
+	if len(q) == 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-time.Duration(GlobalConfig.OfflineQueue.TTLSeconds) * time.Second)
+	sent := 0
+	for i, m := range q {
+		if m.queuedAt.Before(cutoff) {
+			offlineExpired.Add(1)
+			continue
+		}
+		if replayed.covers(m.msg.Seq) {
+			continue
+		}
+		err := c.deliver(m.msg)
+		recordReceipt(m.msg.ID, c, err)
+		recordAckDelivery(m.msg, c, err)
+		if err != nil {
+			log.Printf("⚠️ 离线消息补发失败 conn=%s user_id=%s，%d 条放回队列: %v\n", c.id, logUserID(userID), len(q)-i, err)
+			requeueOffline(userID, q[i:])
+			return
+		}
+		sent++
+	}
+	offlineFlushed.Add(uint64(sent))
+	log.Printf("📬 离线消息补发 conn=%s user_id=%s，补发 %d 条\n", c.id, logUserID(userID), sent)
+}
+
+// requeueOffline 把没发出去的消息放回队首，期间新入队的排在后面，超出上限时按 overflow 截断
+func requeueOffline(userID string, rest []offlineMessage) {
+	cfg := GlobalConfig.OfflineQueue
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+
+	q := append(rest[:len(rest):len(rest)], offlineQueues[userID]...)
+	if over := len(q) - cfg.MaxMessages; over > 0 {
+		offlineDropped.Add(uint64(over))
+		if cfg.Overflow == offlineOverflowDropNewest {
+			q = q[:cfg.MaxMessages]
+		} else {
+			q = q[over:]
+		}
+	}
+	offlineQueues[userID] = q
+}
+
+// offlineSweepLoop 定期清理过期的离线消息
+func offlineSweepLoop() {
+	ttl := time.Duration(GlobalConfig.OfflineQueue.TTLSeconds) * time.Second
+	interval := min(ttl/2, time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().Add(-ttl)
+		offlineMu.Lock()
+		expired := 0
+		for userID, q := range offlineQueues {
+			n := 0
+			for n < len(q) && q[n].queuedAt.Before(cutoff) {
+				n++
+			}
+			if n == 0 {
+				continue
+			}
+			expired += n
+			if n == len(q) {
+				delete(offlineQueues, userID)
+			} else {
+				offlineQueues[userID] = q[n:]
+			}
+		}
+		users := len(offlineQueues)
+		offlineMu.Unlock()
+
+		if expired > 0 {
+			offlineExpired.Add(uint64(expired))
+			log.Printf("🧹 清理过期离线消息 %d 条，剩余 %d 个用户有离线消息\n", expired, users)
+		}
+	}
+}
+
+// offlineTotals 有离线消息的用户数和消息总数
+func offlineTotals() (users, messages int) {
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+	for _, q := range offlineQueues {
+		messages += len(q)
+	}
+	return len(offlineQueues), messages
+}
+
+// forgetUserOffline 删除用户的离线消息，返回删除的条数
+func forgetUserOffline(userID string) int {
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+	n := len(offlineQueues[userID])
+	delete(offlineQueues, userID)
+	return n
+}
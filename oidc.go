@@ -0,0 +1,384 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== 管理后台 OIDC 登录 =====
+//
+// 授权码流程（带 PKCE）：
+//
+//	GET  /admin/login     跳转到 IdP 登录，?return_to= 登录后回到的地址（只允许站内路径）
+//	GET  /admin/callback  IdP 回调：换 token、校验 id_token、按组映射角色、写会话 cookie
+//	GET  /admin/me        当前登录用户
+//	POST /admin/logout    退出
+//
+// 开启后会话 cookie 自动成为 admin 认证链的第一环；
+// 角色 admin 可以调用全部管理接口，viewer 只能调用 GET。没有映射到任何角色的用户拒绝登录。
+
+// OIDCConfig OIDC 登录配置
+type OIDCConfig struct {
+	Enabled      bool              `json:"enabled"`
+	Issuer       string            `json:"issuer"`
+	ClientID     string            `json:"client_id"`
+	ClientSecret string            `json:"client_secret"` // 支持密钥引用
+	RedirectURL  string            `json:"redirect_url"`  // 如 https://relay.example.com/admin/callback
+	Scopes       []string          `json:"scopes"`        // 默认 openid profile email groups
+	GroupsClaim  string            `json:"groups_claim"`  // 默认 groups
+	RoleMapping  map[string]string `json:"role_mapping"`  // 组名 -> 角色（admin / viewer）
+	SessionHours int               `json:"session_hours"` // 默认 8
+}
+
+const (
+	oidcSessionCookie  = "relay_admin_session"
+	oidcStateTTL       = 10 * time.Minute
+	oidcDefaultGroups  = "groups"
+	oidcDefaultSession = 8
+
+	roleAdmin  = "admin"
+	roleViewer = "viewer"
+)
+
+var oidcDefaultScopes = []string{"openid", "profile", "email", "groups"}
+
+// oidcProvider 发现文档里用到的端点
+type oidcProvider struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	IntrospectionEndpoint string `json:"introspection_endpoint"`
+
+	verifier *jwtVerifier
+}
+
+// oidcPending 登录中的 state
+type oidcPending struct {
+	nonce     string
+	verifier  string // PKCE code_verifier
+	returnTo  string
+	expiresAt time.Time
+}
+
+// adminSession 登录后的会话
+type adminSession struct {
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var (
+	oidc *oidcProvider
+
+	oidcMu       sync.Mutex
+	oidcPendings = make(map[string]*oidcPending)  // state -> pending
+	oidcSessions = make(map[string]*adminSession) // session id -> session
+)
+
+// initOIDC 拉取发现文档，注册登录相关路由
+func initOIDC(mux *http.ServeMux) {
+	cfg := &GlobalConfig.OIDC
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		log.Fatalln("❌ oidc 需要 issuer / client_id / redirect_url")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = oidcDefaultScopes
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = oidcDefaultGroups
+	}
+	if cfg.SessionHours <= 0 {
+		cfg.SessionHours = oidcDefaultSession
+	}
+
+	p, err := discoverOIDC(cfg.Issuer)
+	if err != nil {
+		log.Fatalf("❌ OIDC 发现文档拉取失败 %v\n", err)
+	}
+	p.verifier = &jwtVerifier{
+		KeySet:   newJWKSCache(p.JWKSURI),
+		Issuer:   cfg.Issuer,
+		Audience: cfg.ClientID,
+		Leeway:   30 * time.Second,
+	}
+	oidc = p
+
+	mux.HandleFunc("GET /admin/login", oidcLoginHandler)
+	mux.HandleFunc("GET /admin/callback", oidcCallbackHandler)
+	mux.HandleFunc("GET /admin/me", oidcMeHandler)
+	mux.HandleFunc("POST /admin/logout", oidcLogoutHandler)
+	go oidcSweepLoop()
+	log.Printf("✅ 管理后台 OIDC 登录已启用：issuer=%s\n", cfg.Issuer)
+}
+
+func discoverOIDC(issuer string) (*oidcProvider, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery: %s", resp.Status)
+	}
+	var p oidcProvider
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("发现文档缺少 authorization_endpoint / token_endpoint / jwks_uri")
+	}
+	return &p, nil
+}
+
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := GlobalConfig.OIDC
+	returnTo := r.URL.Query().Get("return_to")
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/admin/me"
+	}
+
+	state := randomHex(16)
+	pending := &oidcPending{
+		nonce:     randomHex(16),
+		verifier:  randomHex(32),
+		returnTo:  returnTo,
+		expiresAt: time.Now().Add(oidcStateTTL),
+	}
+	oidcMu.Lock()
+	oidcPendings[state] = pending
+	oidcMu.Unlock()
+
+	challenge := sha256.Sum256([]byte(pending.verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", cfg.ClientID)
+	q.Set("redirect_uri", cfg.RedirectURL)
+	q.Set("scope", strings.Join(cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", pending.nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(oidc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, oidc.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		log.Printf("❌ OIDC 登录失败: %s %s\n", e, q.Get("error_description"))
+		http.Error(w, "login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	state := q.Get("state")
+	oidcMu.Lock()
+	pending := oidcPendings[state]
+	delete(oidcPendings, state)
+	oidcMu.Unlock()
+	if pending == nil || time.Now().After(pending.expiresAt) {
+		http.Error(w, "invalid or expired state", http.StatusBadRequest)
+		return
+	}
+
+	idToken, err := oidcExchangeCode(q.Get("code"), pending.verifier)
+	if err != nil {
+		log.Println("❌ OIDC 换取 token 失败:", err)
+		http.Error(w, "token exchange failed", http.StatusBadGateway)
+		return
+	}
+	claims, err := oidc.verifier.verify(idToken)
+	if err != nil {
+		log.Println("❌ OIDC id_token 校验失败:", err)
+		http.Error(w, "invalid id_token", http.StatusUnauthorized)
+		return
+	}
+	if claims.str("nonce") != pending.nonce {
+		http.Error(w, "invalid nonce", http.StatusUnauthorized)
+		return
+	}
+
+	role := oidcRoleForClaims(claims)
+	if role == "" {
+		log.Printf("❌ OIDC 用户 %s 没有映射到任何管理角色\n", claims.str("sub"))
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	sid := randomHex(32)
+	sess := &adminSession{
+		Subject:   claims.str("sub"),
+		Email:     claims.str("email"),
+		Name:      claims.str("name"),
+		Role:      role,
+		ExpiresAt: time.Now().Add(time.Duration(GlobalConfig.OIDC.SessionHours) * time.Hour),
+	}
+	oidcMu.Lock()
+	oidcSessions[sid] = sess
+	oidcMu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcSessionCookie,
+		Value:    sid,
+		Path:     "/",
+		Expires:  sess.ExpiresAt,
+		HttpOnly: true,
+		Secure:   requestIsTLS(r) || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Printf("✅ 管理员登录 sub=%s email=%s role=%s\n", sess.Subject, sess.Email, sess.Role)
+	http.Redirect(w, r, pending.returnTo, http.StatusFound)
+}
+
+// oidcExchangeCode 用授权码换 id_token
+func oidcExchangeCode(code, verifier string) (string, error) {
+	cfg := GlobalConfig.OIDC
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", cfg.RedirectURL)
+	form.Set("client_id", cfg.ClientID)
+	form.Set("code_verifier", verifier)
+	if secret := liveSecret("oidc.client_secret", cfg.ClientSecret); secret != "" {
+		form.Set("client_secret", secret)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.PostForm(oidc.TokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || out.IDToken == "" {
+		return "", fmt.Errorf("%s %s", resp.Status, out.Error)
+	}
+	return out.IDToken, nil
+}
+
+// oidcRoleForClaims 按 role_mapping 把组映射为角色，admin 优先
+func oidcRoleForClaims(claims jwtClaims) string {
+	var groups []string
+	switch v := claims[GlobalConfig.OIDC.GroupsClaim].(type) {
+	case string:
+		groups = []string{v}
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+
+	role := ""
+	for _, g := range groups {
+		switch GlobalConfig.OIDC.RoleMapping[g] {
+		case roleAdmin:
+			return roleAdmin
+		case roleViewer:
+			role = roleViewer
+		}
+	}
+	return role
+}
+
+// sessionFromRequest 取未过期的会话
+func sessionFromRequest(r *http.Request) *adminSession {
+	cookie, err := r.Cookie(oidcSessionCookie)
+	if err != nil {
+		return nil
+	}
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	sess := oidcSessions[cookie.Value]
+	if sess == nil || time.Now().After(sess.ExpiresAt) {
+		return nil
+	}
+	return sess
+}
+
+func oidcMeHandler(w http.ResponseWriter, r *http.Request) {
+	sess := sessionFromRequest(r)
+	if sess == nil {
+		writeProblem(w, r, http.StatusUnauthorized, problemUnauthorized, "not logged in")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": sess,
+	})
+}
+
+func oidcLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(oidcSessionCookie); err == nil {
+		oidcMu.Lock()
+		delete(oidcSessions, cookie.Value)
+		oidcMu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcSessionCookie, Value: "", Path: "/", MaxAge: -1})
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+	})
+}
+
+// oidcSweepLoop 清理过期的 state 和会话
+func oidcSweepLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		oidcMu.Lock()
+		for k, p := range oidcPendings {
+			if now.After(p.expiresAt) {
+				delete(oidcPendings, k)
+			}
+		}
+		for k, s := range oidcSessions {
+			if now.After(s.ExpiresAt) {
+				delete(oidcSessions, k)
+			}
+		}
+		oidcMu.Unlock()
+	}
+}
+
+// ===== oidc_session：管理后台登录会话（admin 认证链） =====
+
+type oidcSessionAuthenticator struct{}
+
+func (oidcSessionAuthenticator) Name() string { return "oidc_session" }
+
+func (oidcSessionAuthenticator) Authenticate(r *http.Request) (*authPrincipal, error) {
+	if _, err := r.Cookie(oidcSessionCookie); err != nil {
+		return nil, errNoCredentials
+	}
+	sess := sessionFromRequest(r)
+	if sess == nil {
+		return nil, errors.New("admin session expired")
+	}
+	return &authPrincipal{Method: "oidc_session", Subject: sess.Subject, Role: sess.Role}, nil
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ===== OpenAPI 描述 =====
+//
+// GET /api/openapi.json 返回当前实例 HTTP API 的 OpenAPI 3.0 描述，供其它语言生成客户端 SDK：
+//
+//	openapi-generator generate -i http://relay:3000/api/openapi.json -g python -o relay-py
+//
+// 描述按运行中的配置生成：push_path / ws_path 等取实际配置的路径，只列出已开启功能的接口
+// （如 groups / kv / ack_retry / 历史查询），认证方式按 auth.push / auth.admin 配置的认证链给出。
+// 请求体的字段由 Go 结构体的 json 标签反射得到，与实际解码保持一致。
+// 覆盖业务后端集成用到的推送 / 查询接口；管理后台接口和协议兼容端点不在其中。
+// 描述本身不含密钥，不需要认证。
+
+const openAPIPath = "/api/openapi.json"
+
+// openAPIVersion API 描述的版本，/v1 契约有变化时递增
+const openAPIVersion = "1.0.0"
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(buildOpenAPISpec(r))
+}
+
+// buildOpenAPISpec 按当前 GlobalConfig 生成描述，servers 取请求的 Host
+func buildOpenAPISpec(r *http.Request) map[string]interface{} {
+	push := openAPISecurity(GlobalConfig.Auth.Push)
+	admin := openAPISecurity(GlobalConfig.Auth.Admin)
+
+	paths := make(map[string]interface{})
+	add := func(path, method string, op openAPIOperation) {
+		item, _ := paths[path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[method] = op
+	}
+
+	// 推送
+	add(v1PushPath, "post", openAPIOp("push", "Push a message to users, a channel, a group or everyone", push).
+		params(openAPIHeader(idempotencyHeader, "Retry-safe key; repeated requests replay the first response")).
+		jsonBody("PushRequest").
+		ok("200", "Sent immediately").
+		ok("201", "Registered as a recurring push (cron)").
+		ok("202", "Scheduled or queued"))
+	if GlobalConfig.PushPath != v1PushPath {
+		add(GlobalConfig.PushPath, "post", openAPIOp("pushLegacy", "Legacy alias of /v1/push with the original response format", push).
+			jsonBody("PushRequest").
+			ok("200", "Accepted"))
+	}
+	add(pushPBPath, "post", openAPIOp("pushProtobuf", "Push with a protobuf-encoded request body (proto/push.proto)", push).
+		params(openAPIHeader(idempotencyHeader, "Retry-safe key; repeated requests replay the first response")).
+		body("application/x-protobuf", map[string]interface{}{"type": "string", "format": "binary"}).
+		ok("200", "Sent immediately").
+		ok("202", "Scheduled or queued"))
+
+	// 在线状态与延迟 / 周期任务
+	add("/v1/presence", "get", openAPIOp("getPresence", "Online status of up to "+strconv.Itoa(v1PresenceMaxUsers)+" users", push).
+		params(openAPIQueryArray("user_id", "User ID, repeatable", true)).
+		ok("200", "Presence per user"))
+	add("/v1/jobs", "get", openAPIOp("listJobs", "List delayed push jobs", push).
+		params(openAPIQuery("status", "Filter by job status", false)).
+		ok("200", "Jobs"))
+	add("/v1/jobs/{id}", "get", openAPIOp("getJob", "Get a delayed push job", push).
+		params(openAPIPathParam("id")).ok("200", "Job"))
+	add("/v1/jobs/{id}", "delete", openAPIOp("cancelJob", "Cancel a delayed push job", push).
+		params(openAPIPathParam("id")).ok("200", "Cancelled job"))
+	add("/v1/recurring-jobs", "get", openAPIOp("listRecurringJobs", "List recurring (cron) pushes", push).
+		ok("200", "Recurring jobs"))
+	add("/v1/recurring-jobs/{id}", "get", openAPIOp("getRecurringJob", "Get a recurring push", push).
+		params(openAPIPathParam("id")).ok("200", "Recurring job"))
+	add("/v1/recurring-jobs/{id}", "delete", openAPIOp("cancelRecurringJob", "Cancel a recurring push", push).
+		params(openAPIPathParam("id")).ok("200", "Cancelled recurring job"))
+	if GlobalConfig.AckRetry.Enabled {
+		add("/v1/messages/{id}/delivery", "get", openAPIOp("getDelivery", "Ack / retry status of a require_ack push", push).
+			params(openAPIPathParam("id")).ok("200", "Delivery status"))
+	}
+
+	// 具名连接组
+	if GlobalConfig.Groups.Enabled {
+		add("/api/groups", "get", openAPIOp("listGroups", "List named connection groups", push).
+			ok("200", "Groups"))
+		add("/api/groups/{name}", "get", openAPIOp("getGroup", "Get a group", push).
+			params(openAPIPathParam("name")).ok("200", "Group"))
+		add("/api/groups/{name}", "delete", openAPIOp("deleteGroup", "Delete a group", push).
+			params(openAPIPathParam("name")).ok("200", "Deleted group"))
+		add("/api/groups/{name}/members", "post", openAPIOp("addGroupMembers", "Add users or connections to a group", push).
+			params(openAPIPathParam("name")).jsonBody("GroupMembersRequest").ok("200", "Group"))
+		add("/api/groups/{name}/members", "delete", openAPIOp("removeGroupMembers", "Remove users or connections from a group", push).
+			params(openAPIPathParam("name"),
+				openAPIQueryArray("user_id", "User ID, repeatable", false),
+				openAPIQueryArray("connection_id", "Connection ID, repeatable", false)).
+			ok("200", "Group"))
+	}
+
+	// 每用户 KV
+	if GlobalConfig.KV.Enabled {
+		add("/api/users/{id}/kv", "get", openAPIOp("getUserKV", "All KV entries of a user", push).
+			params(openAPIPathParam("id")).ok("200", "KV entries"))
+		add("/api/users/{id}/kv/{key}", "put", openAPIOp("setUserKV", "Set a KV entry and sync it to the user's connections", push).
+			params(openAPIPathParam("id"), openAPIPathParam("key")).
+			body("application/json", map[string]interface{}{}).ok("200", "Entry"))
+		add("/api/users/{id}/kv/{key}", "delete", openAPIOp("deleteUserKV", "Delete a KV entry", push).
+			params(openAPIPathParam("id"), openAPIPathParam("key")).ok("200", "Deleted"))
+	}
+
+	// 消息历史
+	if archiveFiles != nil || GlobalConfig.ChannelHistory.Size > 0 {
+		add("/api/history", "get", openAPIOp("getHistory", "Query channel history (and the archive when enabled)", admin).
+			params(openAPIQuery("channel", "Channel name", false),
+				openAPIQuery("user_id", "User ID (archive only)", false),
+				openAPIQuery("event", "Event name (archive only)", false),
+				openAPIQuery("since", "Unix timestamp in milliseconds", false),
+				openAPIQuery("until", "Unix timestamp in milliseconds (archive only)", false),
+				openAPIQuery("limit", "1-"+strconv.Itoa(archiveQueryMaxLimit), false)).
+			ok("200", "Messages"))
+	}
+
+	// 连接入口：只描述升级请求本身，帧格式见 README
+	add(GlobalConfig.WSPath, "get", openAPIUpgradeOp("connectWebSocket", "Open a native WebSocket connection"))
+	for _, ns := range GlobalConfig.Namespaces {
+		add(ns.Path, "get", openAPIUpgradeOp("connectNamespace_"+ns.Name, "Open a WebSocket connection in namespace "+ns.Name))
+	}
+	if GlobalConfig.SSE.Enabled {
+		add(GlobalConfig.SSE.Path, "get", openAPIOp("connectSSE", "Receive messages as Server-Sent Events", nil).
+			params(openAPIQuery("token", "User token", false)).
+			response("200", "Event stream", "text/event-stream"))
+	}
+
+	add("/health", "get", openAPIOp("health", "Health check", nil).ok("200", "OK"))
+	add(openAPIPath, "get", openAPIOp("openAPI", "This document", nil).ok("200", "OpenAPI description"))
+
+	schemes := make(map[string]interface{})
+	for _, sec := range append(push, admin...) {
+		for name := range sec {
+			schemes[name] = openAPISecuritySchemes[name]
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Go WebSocket Relay",
+			"version": openAPIVersion,
+		},
+		"servers": []map[string]string{{"url": requestBaseURL(r)}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"PushRequest":         openAPISchemaOf(reflect.TypeOf(PushRequest{})),
+				"GroupMembersRequest": openAPISchemaOf(reflect.TypeOf(groupMembersRequest{})),
+				"Problem":             openAPIProblemSchema,
+			},
+			"securitySchemes": schemes,
+		},
+	}
+}
+
+// requestBaseURL 按请求推断对外地址（反向代理设置了 X-Forwarded-Proto 时以它为准）
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); p == "http" || p == "https" {
+		scheme = p
+	}
+	return scheme + "://" + r.Host
+}
+
+// ===== 认证 =====
+
+var openAPISecuritySchemes = map[string]interface{}{
+	"apiKey":    map[string]string{"type": "apiKey", "in": "header", "name": "X-API-KEY"},
+	"bearerJWT": map[string]string{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+	"hmacSignature": map[string]string{
+		"type": "apiKey", "in": "header", "name": "X-Relay-Signature",
+		"description": "HMAC request signature, see push_signing in README",
+	},
+	"callback": map[string]string{
+		"type": "apiKey", "in": "header", "name": "Authorization",
+		"description": "Credentials verified by the configured callback",
+	},
+}
+
+// openAPISecurity 认证链里的每一环都是可选的一种认证方式（OpenAPI 中 security 数组为“或”的关系）
+func openAPISecurity(cfgs []AuthProviderConfig) []map[string][]string {
+	if len(cfgs) == 0 {
+		cfgs = []AuthProviderConfig{{Type: "static"}}
+	}
+	var out []map[string][]string
+	seen := make(map[string]bool)
+	addScheme := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, map[string][]string{name: {}})
+		}
+	}
+	// OIDC 登录会话是浏览器 cookie，不适合 SDK，不列出
+	for _, c := range cfgs {
+		switch c.Type {
+		case "static":
+			addScheme("apiKey")
+		case "hmac":
+			addScheme("hmacSignature")
+		case "jwt":
+			addScheme("bearerJWT")
+		case "callback":
+			addScheme("callback")
+		}
+	}
+	return out
+}
+
+// ===== 接口描述 =====
+
+// openAPIOperation 单个接口的描述，链式补充参数 / 请求体 / 响应
+type openAPIOperation map[string]interface{}
+
+func openAPIOp(id, summary string, security []map[string][]string) openAPIOperation {
+	op := openAPIOperation{
+		"operationId": id,
+		"summary":     summary,
+		"responses":   map[string]interface{}{},
+	}
+	if security != nil {
+		op["security"] = security
+		op.problem("401", "Authentication failed")
+	} else {
+		op["security"] = []map[string][]string{}
+	}
+	return op
+}
+
+// openAPIUpgradeOp WebSocket 升级请求
+func openAPIUpgradeOp(id, summary string) openAPIOperation {
+	return openAPIOp(id, summary, nil).
+		params(openAPIQuery("token", "User token; may also be sent later in an identify frame", false)).
+		response("101", "Switching Protocols", "")
+}
+
+func (op openAPIOperation) params(ps ...map[string]interface{}) openAPIOperation {
+	list, _ := op["parameters"].([]map[string]interface{})
+	op["parameters"] = append(list, ps...)
+	return op.problem("400", "Invalid request")
+}
+
+func (op openAPIOperation) jsonBody(schema string) openAPIOperation {
+	return op.body("application/json", map[string]string{"$ref": "#/components/schemas/" + schema})
+}
+
+func (op openAPIOperation) body(contentType string, schema interface{}) openAPIOperation {
+	op["requestBody"] = map[string]interface{}{
+		"required": true,
+		"content":  map[string]interface{}{contentType: map[string]interface{}{"schema": schema}},
+	}
+	return op.problem("400", "Invalid request")
+}
+
+// ok 成功响应，统一为 {"code":0,"msg":"ok","data":...}
+func (op openAPIOperation) ok(status, desc string) openAPIOperation {
+	op["responses"].(map[string]interface{})[status] = map[string]interface{}{
+		"description": desc,
+		"content": map[string]interface{}{"application/json": map[string]interface{}{
+			"schema": openAPIEnvelopeSchema,
+		}},
+	}
+	return op
+}
+
+func (op openAPIOperation) problem(status, desc string) openAPIOperation {
+	op["responses"].(map[string]interface{})[status] = map[string]interface{}{
+		"description": desc,
+		"content": map[string]interface{}{problemContentType: map[string]interface{}{
+			"schema": map[string]string{"$ref": "#/components/schemas/Problem"},
+		}},
+	}
+	return op
+}
+
+func (op openAPIOperation) response(status, desc, contentType string) openAPIOperation {
+	resp := map[string]interface{}{"description": desc}
+	if contentType != "" {
+		resp["content"] = map[string]interface{}{contentType: map[string]interface{}{}}
+	}
+	op["responses"].(map[string]interface{})[status] = resp
+	return op
+}
+
+func openAPIPathParam(name string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "path", "required": true, "schema": map[string]string{"type": "string"}}
+}
+
+func openAPIQuery(name, desc string, required bool) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "query", "description": desc, "required": required, "schema": map[string]string{"type": "string"}}
+}
+
+func openAPIQueryArray(name, desc string, required bool) map[string]interface{} {
+	return map[string]interface{}{
+		"name": name, "in": "query", "description": desc, "required": required, "explode": true,
+		"schema": map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}},
+	}
+}
+
+func openAPIHeader(name, desc string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "header", "description": desc, "schema": map[string]string{"type": "string"}}
+}
+
+// ===== schema =====
+
+var openAPIEnvelopeSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"code": map[string]string{"type": "integer"},
+		"msg":  map[string]string{"type": "string"},
+		"data": map[string]interface{}{},
+	},
+}
+
+var openAPIProblemSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"type":     map[string]string{"type": "string"},
+		"title":    map[string]string{"type": "string"},
+		"status":   map[string]string{"type": "integer"},
+		"detail":   map[string]string{"type": "string"},
+		"instance": map[string]string{"type": "string"},
+		"code":     map[string]string{"type": "string"},
+		"field":    map[string]string{"type": "string"},
+	},
+}
+
+// openAPISchemaOf 按 json 标签把 Go 类型转成 schema；interface{} 字段（token / subject）不限制类型
+func openAPISchemaOf(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return openAPISchemaOf(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": openAPISchemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": openAPISchemaOf(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]interface{}, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = openAPISchemaOf(f.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ===== 按用户保序投递 =====
+//
+// 同一用户的消息可能同时从几个地方发出：HTTP 推送接口、异步推送队列的多个 worker、到点的延迟任务。
+// 它们各自并发扇出，客户端就可能先收到后续通知、再收到它依赖的状态更新。
+// 开启 ordered_delivery 后，单用户推送都经过该用户唯一的派发队列，按进入队列的顺序逐条发送：
+//
+//   - 直接发送和延迟任务在发送时入队，等轮到自己发完再返回（delivered 计数不变）
+//   - 异步推送队列在接受请求时就占好位置，worker 取出后填进去，多个 worker 之间也不会乱序
+//
+// 用户的队列为空时派发协程退出，不常驻；频道、组和广播推送不经过派发队列。
+
+// OrderedDeliveryConfig 按用户保序投递配置
+type OrderedDeliveryConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// orderedSlot 派发队列里的一个位置，填入发送函数后按顺序执行
+type orderedSlot struct {
+	ready  chan func() int
+	result chan int
+}
+
+// userOrder 一个用户的派发队列
+type userOrder struct {
+	slots []*orderedSlot
+}
+
+var (
+	orderMu    sync.Mutex
+	userOrders = make(map[string]*userOrder)
+
+	orderedPending atomic.Int64 // 已占位还没发送完的推送数
+)
+
+// reserveUserOrder 在用户的派发队列末尾占一个位置，队列原本为空时启动派发协程
+func reserveUserOrder(userID string) *orderedSlot {
+	s := &orderedSlot{ready: make(chan func() int, 1), result: make(chan int, 1)}
+	orderMu.Lock()
+	q, ok := userOrders[userID]
+	if !ok {
+		q = &userOrder{}
+		userOrders[userID] = q
+		go drainUserOrder(userID, q)
+	}
+	q.slots = append(q.slots, s)
+	orderMu.Unlock()
+	orderedPending.Add(1)
+	return s
+}
+
+// run 填入发送函数，等排在前面的都发完、自己也发完后返回投递数
+func (s *orderedSlot) run(send func() int) int {
+	s.ready <- send
+	return <-s.result
+}
+
+// drainUserOrder 按占位顺序逐个执行，队列空了就退出
+func drainUserOrder(userID string, q *userOrder) {
+	for {
+		orderMu.Lock()
+		if len(q.slots) == 0 {
+			delete(userOrders, userID)
+			orderMu.Unlock()
+			return
+		}
+		s := q.slots[0]
+		orderMu.Unlock()
+
+		send := <-s.ready
+		s.result <- send()
+
+		orderMu.Lock()
+		q.slots[0] = nil
+		q.slots = q.slots[1:]
+		orderMu.Unlock()
+		orderedPending.Add(-1)
+	}
+}
+
+// orderedUsers 当前有派发队列的用户数
+func orderedUsers() int {
+	orderMu.Lock()
+	defer orderMu.Unlock()
+	return len(userOrders)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 一次编码、多处共享的下发消息 =====
+//
+// 一次推送（单用户 / 频道 / 广播）只创建一个 outboundMessage，每种帧格式只编码一次，
+// 编码结果是只读的 []byte，所有接收方直接 WriteMessage 写出，不再每个连接各自 WriteJSON。
+// 帧格式由 Client.frameKey 区分：原生 JSON、协商的其它线上格式、各兼容协议、端到端加密二进制帧各自缓存一份。
+// 需要按客户端能力降级的连接（见 transform.go）先取改写后的消息，每组规则各自缓存一份。
+
+const (
+	frameKeyNative = "native"
+	frameKeyE2E    = "e2e-binary"
+	frameKeyCodec  = "codec:" // 加上协商的格式名，见 codec.go
+)
+
+// outboundMessage 一条待下发的消息及其按帧格式缓存的编码结果
+type outboundMessage struct {
+	msg WSMessage
+
+	// 创建它的 Hub 的降级规则和签名，改写后的消息沿用
+	rules []TransformRule
+	sign  func(WSMessage) WSMessage
+
+	mu       sync.Mutex
+	frames   map[string]encodedFrame
+	variants map[uint64]*outboundMessage // 规则位图 -> 改写后的消息
+}
+
+// encodedFrame 编码好的一帧；err 非空表示这种格式编码失败，同格式的连接都会拿到同一个错误
+type encodedFrame struct {
+	messageType int
+	data        []byte
+	err         error
+}
+
+// newOutbound 按 Hub 的配置创建一条待下发的消息
+func (h *Hub) newOutbound(msg WSMessage) *outboundMessage {
+	return &outboundMessage{msg: msg, rules: h.cfg.Transforms, sign: h.hooks.SignMessage}
+}
+
+// encoded 返回某种帧格式的编码结果，第一次用到时编码；key 为空表示不可共享，每次都重新编码
+func (o *outboundMessage) encoded(key string, encode func(WSMessage) encodedFrame) encodedFrame {
+	if key == "" {
+		return encode(o.msg)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if f, ok := o.frames[key]; ok {
+		return f
+	}
+	f := encode(o.msg)
+	if o.frames == nil {
+		o.frames = make(map[string]encodedFrame, 2)
+	}
+	o.frames[key] = f
+	return f
+}
+
+// transformed 返回按规则位图改写后的消息，第一次用到时改写；原消息签过名时改写结果重新签名
+func (o *outboundMessage) transformed(mask uint64) *outboundMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if v, ok := o.variants[mask]; ok {
+		return v
+	}
+	msg := transformMessage(o.msg, o.rules, mask)
+	if o.msg.Sig != "" && msg.Sig == "" && o.sign != nil {
+		msg = o.sign(msg)
+	}
+	v := &outboundMessage{msg: msg, rules: o.rules, sign: o.sign}
+	if o.variants == nil {
+		o.variants = make(map[uint64]*outboundMessage, 1)
+	}
+	o.variants[mask] = v
+	return v
+}
+
+// deliverOutbound 按连接协议写出一条共享的下发消息；瞬时消息遇到连接正忙时返回 errEphemeralDropped
+func (c *Client) deliverOutbound(o *outboundMessage) error {
+	f := c.encodeOutbound(o)
+	if f.err != nil {
+		return f.err
+	}
+
+	if o.msg.Ephemeral {
+		// 瞬时消息不等锁：连接正忙着写别的消息说明它已经跟不上了，这条直接丢弃
+		if !c.mu.TryLock() {
+			ephemeralDropped.Add(1)
+			return errEphemeralDropped
+		}
+	} else {
+		c.mu.Lock()
+	}
+	defer c.mu.Unlock()
+	return c.writeFrameLocked(f)
+}
+
+// deliverPush 推送路径（Hub 的分发）的下发：连接还在补发积压消息时先攒着，补完按顺序写出
+func (c *Client) deliverPush(o *outboundMessage) error {
+	if c.pushHold.Load() && c.holdPush(o) {
+		return nil
+	}
+	return c.deliverOutbound(o)
+}
+
+// holdPush 补发期间把推送攒起来，返回 false 表示补发已经结束，应直接写出
+func (c *Client) holdPush(outs ...*outboundMessage) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.pushHold.Load() {
+		return false
+	}
+	c.heldPushes = append(c.heldPushes, outs...)
+	return true
+}
+
+// holdPushes 开始补发积压消息（断线补发 / 离线队列）：要在连接加入用户组之前调用，
+// 之后发给它的推送先攒着，releasePushes 时再写出，实时推送不会插到补发的消息前面
+func (c *Client) holdPushes() {
+	c.pushHold.Store(true)
+}
+
+// releasePushes 补发结束，按顺序写出期间攒下的推送；写出和清除标记在同一把写锁内，不会和新推送交错
+func (c *Client) releasePushes() error {
+	if !c.pushHold.Load() {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	held := c.heldPushes
+	c.heldPushes = nil
+	c.pushHold.Store(false)
+	for _, o := range held {
+		f := c.encodeOutbound(o)
+		if f.err != nil {
+			continue
+		}
+		if err := c.writeFrameLocked(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliverOutbounds 在同一把写锁内连续写出多条消息，中间不会插入其它推送；任何一条编码失败时一条都不写。
+// 补发期间和 deliverPush 一样先攒着
+func (c *Client) deliverOutbounds(outs []*outboundMessage) error {
+	frames := make([]encodedFrame, len(outs))
+	for i, o := range outs {
+		if frames[i] = c.encodeOutbound(o); frames[i].err != nil {
+			return frames[i].err
+		}
+	}
+	if c.pushHold.Load() && c.holdPush(outs...) {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range frames {
+		if err := c.writeFrameLocked(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFrameLocked 写出一帧；调用方持有 c.mu
+func (c *Client) writeFrameLocked(f encodedFrame) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := c.conn.WriteMessage(f.messageType, f.data); err != nil {
+		return err
+	}
+	c.countOutbound(len(f.data))
+	return nil
+}
+
+// encodeOutbound 按连接的帧格式（和能力降级规则）取编码好的一帧
+func (c *Client) encodeOutbound(o *outboundMessage) encodedFrame {
+	if len(o.rules) > 0 {
+		if mask := c.transformMask(o.rules, o.msg); mask != 0 {
+			o = o.transformed(mask)
+		}
+	}
+	var f encodedFrame
+	switch {
+	case c.e2eBinary && isEncryptedPayload(o.msg):
+		f = o.encoded(frameKeyE2E, encodeE2EFrame)
+	case c.frame == nil && c.codec != nil:
+		f = o.encoded(frameKeyCodec+c.codec.Name(), func(msg WSMessage) encodedFrame { return encodeCodecFrame(c.codec, msg) })
+	case c.frame == nil:
+		f = o.encoded(frameKeyNative, func(msg WSMessage) encodedFrame { return encodeJSONFrame(msg) })
+	default:
+		f = o.encoded(c.frameKey, func(msg WSMessage) encodedFrame {
+			v := c.frame(msg)
+			if raw, ok := v.(rawFrame); ok {
+				return encodedFrame{messageType: websocket.TextMessage, data: raw}
+			}
+			return encodeJSONFrame(v)
+		})
+	}
+	return f
+}
+
+func encodeJSONFrame(v interface{}) encodedFrame {
+	data, err := json.Marshal(v)
+	return encodedFrame{messageType: websocket.TextMessage, data: data, err: err}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ===== 管理列表接口的分页 / 排序 =====
+//
+// 列表接口统一支持 ?limit=&cursor=&sort=：
+//   - sort 为字段名，前面加 - 表示倒序，如 sort=-connected_at
+//   - 响应里的 next_cursor 原样带到下一次请求的 cursor，为空表示没有下一页
+//
+// 游标记录的是上一页最后一条的排序键和 ID（keyset 分页），翻页期间有连接加入 / 断开也不会重复或跳过。
+// 游标和 sort 绑定，换了 sort 需要从第一页重新开始。
+
+const (
+	pageDefaultLimit = 100
+	pageMaxLimit     = 1000
+)
+
+// pageParams 分页参数
+type pageParams struct {
+	limit int
+	sort  string // 排序字段（不含 -）
+	desc  bool
+	after *pageCursor
+}
+
+// pageCursor 游标内容
+type pageCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"i"`
+}
+
+// pageSorter 一个可排序字段：key 返回可按字典序比较的排序键
+type pageSorter[T any] map[string]func(T) string
+
+// parsePageParams 解析分页参数，defaultSort 可带 - 前缀；出错时同时返回出错的参数名
+func parsePageParams(q url.Values, defaultSort string, allowed []string) (pageParams, string, error) {
+	p := pageParams{limit: pageDefaultLimit}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return p, "limit", errors.New("limit must be a positive integer")
+		}
+		p.limit = min(n, pageMaxLimit)
+	}
+
+	sortBy := q.Get("sort")
+	if sortBy == "" {
+		sortBy = defaultSort
+	}
+	p.sort, p.desc = strings.TrimPrefix(sortBy, "-"), strings.HasPrefix(sortBy, "-")
+	if !slices.Contains(allowed, p.sort) {
+		return p, "sort", fmt.Errorf("sort must be one of %s (prefix - for descending)", strings.Join(allowed, ", "))
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		var c pageCursor
+		if err != nil || json.Unmarshal(raw, &c) != nil {
+			return p, "cursor", errors.New("invalid cursor")
+		}
+		if c.Sort != sortBy {
+			return p, "cursor", errors.New("cursor was issued for a different sort")
+		}
+		p.after = &c
+	}
+	return p, "", nil
+}
+
+// paginate 按 p 排序并取一页，id 用于排序键相同时的次序和游标定位
+func paginate[T any](items []T, p pageParams, sorters pageSorter[T], id func(T) string) (page []T, next string) {
+	key := sorters[p.sort]
+	less := func(ka, ia, kb, ib string) bool {
+		if ka != kb {
+			return (ka < kb) != p.desc
+		}
+		return ia < ib
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return less(key(items[i]), id(items[i]), key(items[j]), id(items[j]))
+	})
+
+	start := 0
+	if p.after != nil {
+		start = sort.Search(len(items), func(i int) bool {
+			return less(p.after.Key, p.after.ID, key(items[i]), id(items[i]))
+		})
+	}
+	end := min(start+p.limit, len(items))
+	page = items[start:end]
+
+	if end < len(items) && len(page) > 0 {
+		last := page[len(page)-1]
+		sortBy := p.sort
+		if p.desc {
+			sortBy = "-" + sortBy
+		}
+		raw, _ := json.Marshal(pageCursor{Sort: sortBy, Key: key(last), ID: id(last)})
+		next = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return page, next
+}
+
+// timeSortKey 时间排序键（定长，字典序即时间序）
+func timeSortKey(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// intSortKey 非负整数排序键
+func intSortKey(n int) string {
+	return fmt.Sprintf("%020d", n)
+}
+
+// parseTimeParam 解析时间过滤参数：RFC3339 或 Unix 秒
+func parseTimeParam(v string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
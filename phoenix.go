@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// ===== Phoenix Channels 协议兼容 =====
+//
+// 兼容 phoenix.js：new Socket("/socket") 会连接 /socket/websocket?vsn=2.0.0
+// vsn=2.0.0 使用数组帧 [join_ref, ref, topic, event, payload]，
+// vsn=1.0.0 使用对象帧 {topic, event, payload, ref, join_ref}。
+//
+// topic 直接映射为频道；另有一个“用户 topic”（默认 relay），
+// 客户端 join 时在 payload 里带 token 即注册到用户组，单用户推送和广播都在这个 topic 下发。
+
+// PhoenixConfig Phoenix 兼容端点配置
+type PhoenixConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Path      string `json:"path"`       // 默认 /socket/websocket
+	UserTopic string `json:"user_topic"` // 默认 relay
+}
+
+const (
+	phoenixDefaultPath      = "/socket/websocket"
+	phoenixDefaultUserTopic = "relay"
+	phoenixSystemTopic      = "phoenix"
+)
+
+// phoenixMessage 解码后的 Phoenix 消息，两种 vsn 统一成这个结构
+type phoenixMessage struct {
+	JoinRef *string         `json:"join_ref"`
+	Ref     *string         `json:"ref"`
+	Topic   string          `json:"topic"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func registerPhoenixRoutes(mux *http.ServeMux) {
+	mux.HandleFunc(GlobalConfig.Phoenix.Path, upgradeGuard(phoenixWSHandler))
+	log.Printf("✅ Phoenix 兼容端点已启用：ws %s，用户 topic=%s\n",
+		GlobalConfig.Phoenix.Path, GlobalConfig.Phoenix.UserTopic)
+}
+
+// phoenixEncode 按 vsn 编码一条出站消息
+func phoenixEncode(v2 bool, joinRef, ref *string, topic, event string, payload interface{}) interface{} {
+	if v2 {
+		return []interface{}{joinRef, ref, topic, event, payload}
+	}
+	return map[string]interface{}{
+		"join_ref": joinRef,
+		"ref":      ref,
+		"topic":    topic,
+		"event":    event,
+		"payload":  payload,
+	}
+}
+
+// phoenixDecode 解析入站帧：数组帧或对象帧
+func phoenixDecode(raw []byte) (phoenixMessage, error) {
+	var msg phoenixMessage
+	if len(raw) > 0 && raw[0] == '[' {
+		var arr []json.RawMessage
+		if err := json.Unmarshal(raw, &arr); err != nil {
+			return msg, err
+		}
+		if len(arr) != 5 {
+			return msg, errors.New("phoenix frame must have 5 elements")
+		}
+		_ = json.Unmarshal(arr[0], &msg.JoinRef)
+		_ = json.Unmarshal(arr[1], &msg.Ref)
+		if err := json.Unmarshal(arr[2], &msg.Topic); err != nil {
+			return msg, err
+		}
+		if err := json.Unmarshal(arr[3], &msg.Event); err != nil {
+			return msg, err
+		}
+		msg.Payload = arr[4]
+		return msg, nil
+	}
+	err := json.Unmarshal(raw, &msg)
+	return msg, err
+}
+
+func phoenixWSHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, withAffinityCookie(r, nil))
+	if err != nil {
+		log.Println("Phoenix WebSocket upgrade error:", err)
+		return
+	}
+
+	userTopic := GlobalConfig.Phoenix.UserTopic
+	v2 := r.URL.Query().Get("vsn") != "1.0.0"
+
+	client := newClient(conn, r)
+	client.frame = func(msg WSMessage) interface{} {
+		topic := msg.Channel
+		if topic == "" {
+			topic = userTopic
+		}
+		return phoenixEncode(v2, nil, nil, topic, msg.Event, msg.Data)
+	}
+	client.frameKey = "phoenix/v1"
+	if v2 {
+		client.frameKey = "phoenix/v2"
+	}
+	addClient(client)
+
+	// 和原生端点一样，支持连接参数 ?token=xxx
+	if token := r.URL.Query().Get("token"); token != "" {
+		if _, err := identifyUser(client, token); err != nil {
+			removeClient(client)
+			conn.Close()
+			return
+		}
+	}
+
+	defer func() {
+		conn.Close()
+		removeClient(client)
+	}()
+
+	userJoined := false
+	reply := func(m phoenixMessage, status string, response interface{}) error {
+		return client.sendJSON(phoenixEncode(v2, m.JoinRef, m.Ref, m.Topic, "phx_reply", map[string]interface{}{
+			"status":   status,
+			"response": response,
+		}))
+	}
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			noteReadError(client, "Phoenix", err)
+			break
+		}
+
+		msg, err := phoenixDecode(raw)
+		if err != nil {
+			log.Println("⚠️ Phoenix message parse error:", err)
+			continue
+		}
+
+		switch {
+		case msg.Topic == phoenixSystemTopic && msg.Event == "heartbeat":
+			reply(msg, "ok", map[string]interface{}{})
+
+		case msg.Event == "phx_join" && msg.Topic == userTopic:
+			var params IdentifyData
+			_ = json.Unmarshal(msg.Payload, &params)
+			if params.Token != "" {
+				if _, err := identifyUser(client, params.Token); err != nil {
+					reply(msg, "error", map[string]interface{}{"reason": "unauthorized"})
+					continue
+				}
+			}
+			userJoined = true
+			reply(msg, "ok", map[string]interface{}{})
+
+		case msg.Event == "phx_join" && !nativeChannelAllowed(msg.Topic):
+			reply(msg, "error", map[string]interface{}{"reason": errCodeChannelForbidden})
+
+		case msg.Event == "phx_join":
+			total := subscribeChannel(client, msg.Topic)
+			log.Printf("📡 Phoenix join topic=%s conn=%s, 频道连接数=%d\n", msg.Topic, client.id, total)
+			reply(msg, "ok", map[string]interface{}{})
+
+		case msg.Event == "phx_leave":
+			if msg.Topic == userTopic {
+				userJoined = false
+			} else {
+				unsubscribeChannel(client, msg.Topic)
+			}
+			reply(msg, "ok", map[string]interface{}{})
+
+		case readOnly.Load() && (msg.Topic == userTopic && userJoined || isSubscribed(client, msg.Topic)):
+			reply(msg, "error", map[string]interface{}{"reason": "read_only"})
+
+		case isSystemEvent(msg.Event) || !eventAllowed(inboundConfigFor(client), msg.Event):
+			if !rejectInboundWith(client, inboundEventError(client, msg.Event), func(e *clientError) error {
+				return reply(msg, "error", map[string]interface{}{"reason": e.Code})
+			}) {
+				return
+			}
+
+		case msg.Topic == userTopic && userJoined, isSubscribed(client, msg.Topic):
+			if shouldLogEvent(msg.Event) {
+				log.Printf("📨 [Phoenix event] topic=%s %s %s\n", msg.Topic, msg.Event, redactLog(msg.Payload))
+			}
+			publishInbound(client, "phoenix", msg.Event, msg.Topic, msg.Payload)
+			reply(msg, "ok", map[string]interface{}{})
+
+		default:
+			reply(msg, "error", map[string]interface{}{"reason": "unmatched topic"})
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 在线用户变化流 =====
+//
+// 后端想在内存里维护一份在线用户表时，不必轮询 /v1/presence：GET /api/presence/stream（admin 认证）
+// 先给一份当前快照，之后每当有用户上线、下线或连接数变化就推一条：
+//
+//	{"event":"presence_snapshot","data":{"users":{"u1":2,"u2":1},"ts":1760580000000}}
+//	{"event":"presence","data":{"user_id":"u1","online":true,"connections":3,"change":"join","conn_id":"1700000000.7","ts":...}}
+//	{"event":"presence","data":{"user_id":"u2","online":false,"connections":0,"change":"leave","conn_id":"1700000000.4","ts":...}}
+//
+// 普通 GET 返回 SSE（event 为事件名，data 为 data 部分），带 WebSocket 升级头时走 WebSocket，内容相同。
+// connections 是变化后的绝对值，快照和订阅之间重叠的变化重复应用也不会出错。
+// 订阅方消费跟不上、缓冲满了时直接断开（而不是悄悄丢事件），重连后拿到新的快照即可重新对齐。
+// 只反映本节点的连接，集群部署时需要订阅每个节点（事件带 node_id）。
+
+// PresenceStreamConfig 在线用户变化流配置
+type PresenceStreamConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+const (
+	presenceStreamPath      = "/api/presence/stream"
+	presenceStreamBuffer    = 1024
+	presenceStreamKeepAlive = 25 * time.Second
+
+	presenceEvent         = "presence"
+	presenceSnapshotEvent = "presence_snapshot"
+)
+
+// presenceChange 一次在线状态变化
+type presenceChange struct {
+	UserID      string `json:"user_id"`
+	Online      bool   `json:"online"`
+	Connections int    `json:"connections"`
+	Change      string `json:"change"` // join / leave
+	ConnID      string `json:"conn_id"`
+	Ts          int64  `json:"ts"`
+	NodeID      string `json:"node_id,omitempty"`
+}
+
+// presenceSubscriber 一个订阅方；缓冲满时关闭 overflow，由订阅协程断开连接
+type presenceSubscriber struct {
+	C        chan presenceChange
+	overflow chan struct{}
+	once     sync.Once
+}
+
+var (
+	presenceSubsMu sync.Mutex
+	presenceSubs   = make(map[*presenceSubscriber]struct{})
+	presenceActive atomic.Int32 // 订阅方数量，没有订阅方时注册 / 注销不做任何事
+
+	presenceOverflows atomic.Uint64
+)
+
+// publishPresence 用户组成员变化后调用；调用方持有 usersMu，保证同一用户的事件顺序与注册表一致
+func publishPresence(userID string, connections int, c *Client, joined bool) {
+	if presenceActive.Load() == 0 {
+		return
+	}
+	ev := presenceChange{
+		UserID: userID, Online: connections > 0, Connections: connections,
+		Change: "leave", ConnID: c.id, Ts: time.Now().UnixMilli(),
+	}
+	if joined {
+		ev.Change = "join"
+	}
+	if GlobalConfig.Cluster.Enabled {
+		ev.NodeID = GlobalConfig.Cluster.NodeID
+	}
+
+	presenceSubsMu.Lock()
+	defer presenceSubsMu.Unlock()
+	for s := range presenceSubs {
+		select {
+		case s.C <- ev:
+		default:
+			s.once.Do(func() {
+				presenceOverflows.Add(1)
+				close(s.overflow)
+			})
+		}
+	}
+}
+
+func subscribePresence() *presenceSubscriber {
+	s := &presenceSubscriber{C: make(chan presenceChange, presenceStreamBuffer), overflow: make(chan struct{})}
+	presenceSubsMu.Lock()
+	presenceSubs[s] = struct{}{}
+	presenceSubsMu.Unlock()
+	presenceActive.Add(1)
+	return s
+}
+
+func unsubscribePresence(s *presenceSubscriber) {
+	presenceSubsMu.Lock()
+	delete(presenceSubs, s)
+	presenceSubsMu.Unlock()
+	presenceActive.Add(-1)
+}
+
+// presenceSnapshot 当前各用户的连接数；订阅之后再取，重叠部分由绝对值兜底
+func presenceSnapshot() map[string]interface{} {
+	return map[string]interface{}{"users": defaultHub.userGroupSizes(), "ts": time.Now().UnixMilli()}
+}
+
+func registerPresenceStreamRoutes(mux *http.ServeMux) {
+	mux.Handle("GET "+presenceStreamPath, checkAuth("admin", http.HandlerFunc(presenceStreamHandler)))
+	log.Printf("✅ 在线用户变化流已启用：%s\n", presenceStreamPath)
+}
+
+func presenceStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		presenceStreamWS(w, r)
+		return
+	}
+	presenceStreamSSE(w, r)
+}
+
+func presenceStreamSSE(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	send := func(event string, v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_ = rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := w.Write(sseEncode(0, event, data)); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	sub := subscribePresence()
+	defer unsubscribePresence(sub)
+	log.Printf("👀 在线用户变化流订阅开始（SSE）from=%s\n", r.RemoteAddr)
+
+	if err := send(presenceSnapshotEvent, presenceSnapshot()); err != nil {
+		return
+	}
+	ticker := time.NewTicker(presenceStreamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Println("👀 在线用户变化流订阅结束（SSE）")
+			return
+		case <-sub.overflow:
+			log.Printf("⚠️ 在线用户变化流订阅方消费跟不上，断开 from=%s\n", r.RemoteAddr)
+			return
+		case ev := <-sub.C:
+			if err := send(presenceEvent, ev); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if _, err := w.Write([]byte(": keepalive\n\n")); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func presenceStreamWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("presence stream upgrade error:", err)
+		return
+	}
+	defer conn.Close()
+
+	send := func(event string, v interface{}) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(WSMessage{Event: event, Data: v})
+	}
+
+	sub := subscribePresence()
+	defer unsubscribePresence(sub)
+	log.Printf("👀 在线用户变化流订阅开始（WebSocket）from=%s\n", r.RemoteAddr)
+
+	// 读循环只用来发现对端关闭
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := send(presenceSnapshotEvent, presenceSnapshot()); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-done:
+			log.Println("👀 在线用户变化流订阅结束（WebSocket）")
+			return
+		case <-sub.overflow:
+			log.Printf("⚠️ 在线用户变化流订阅方消费跟不上，断开 from=%s\n", r.RemoteAddr)
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater,
+					closeReasonWithAdvice(websocket.CloseTryAgainLater, "presence stream overflow")), time.Now().Add(time.Second))
+			return
+		case ev := <-sub.C:
+			if err := send(presenceEvent, ev); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// ===== HTTP 错误响应（RFC 7807 problem+json） =====
+//
+// HTTP API 的错误统一用 application/problem+json 返回，调用方按 code（稳定，不随文案变化）分支：
+//
+//	HTTP/1.1 401 Unauthorized
+//	Content-Type: application/problem+json
+//
+//	{"type":"urn:relay:problem:unauthorized","title":"Unauthorized","status":401,
+//	 "detail":"invalid api key","instance":"/api/push","code":"unauthorized"}
+//
+// 成功响应仍是 {"code":0,"msg":"ok","data":...}。
+// Pusher / SignalR 兼容端点按各自协议返回错误，OIDC 登录回调面向浏览器，都不在此列。
+
+const (
+	problemContentType = "application/problem+json"
+	problemTypePrefix  = "urn:relay:problem:"
+)
+
+// 稳定错误码
+const (
+	problemUnauthorized    = "unauthorized"      // 认证失败（API Key / token / 签名）
+	problemForbidden       = "forbidden"         // 已认证但没有权限
+	problemNotFound        = "not_found"         // 资源不存在（如任务 ID）
+	problemConflict        = "conflict"          // 与进行中或已完成的请求冲突（如幂等键重复使用）
+	problemInvalidJSON     = "invalid_json"      // 请求体不是合法 JSON
+	problemInvalidProtobuf = "invalid_protobuf"  // 请求体不是合法的 protobuf 消息（/api/push.pb）
+	problemValidation      = "validation_failed" // 字段缺失或取值不合法，field 指出字段
+	problemPayloadTooLarge = "payload_too_large" // 请求体超过上限
+	problemRateLimited     = "rate_limited"      // 超过频率限制，带 Retry-After
+	problemQuotaExceeded   = "quota_exceeded"    // 超过配额（如每用户 KV 键数）
+	problemBanned          = "banned"            // 来源因滥用被临时封禁，带 Retry-After
+	problemMaintenance     = "maintenance"       // 维护中，带 Retry-After
+	problemUnavailable     = "unavailable"       // 功能未就绪 / 节点不可用
+	problemUpstream        = "upstream_failed"   // 调用其它节点 / 外部服务失败
+	problemInternal        = "internal_error"
+)
+
+// pushMaxBodyBytes 推送请求体上限
+const pushMaxBodyBytes = 1 << 20
+
+// writeProblem 输出 problem+json 错误响应，r 为 nil 时不带 instance
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeProblemWith(w, r, status, code, detail, nil)
+}
+
+// writeProblemWith 同 writeProblem，ext 中的字段作为扩展成员一起输出（如 field、retry_after）
+func writeProblemWith(w http.ResponseWriter, r *http.Request, status int, code, detail string, ext map[string]interface{}) {
+	body := make(map[string]interface{}, len(ext)+6)
+	for k, v := range ext {
+		body[k] = v
+	}
+	body["type"] = problemTypePrefix + code
+	body["title"] = http.StatusText(status)
+	body["status"] = status
+	body["code"] = code
+	if detail != "" {
+		body["detail"] = detail
+	}
+	if r != nil {
+		body["instance"] = r.URL.Path
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// writeValidationProblem 字段校验失败
+func writeValidationProblem(w http.ResponseWriter, r *http.Request, field, detail string) {
+	writeProblemWith(w, r, http.StatusBadRequest, problemValidation, detail, map[string]interface{}{"field": field})
+}
+
+// writeBodyError 请求体读取 / 解析失败：超过上限返回 413，其余按 invalid_json 返回 400
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, problemPayloadTooLarge,
+			"request body exceeds "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+		return
+	}
+	writeProblem(w, r, http.StatusBadRequest, problemInvalidJSON, "request body is not valid JSON")
+}
+
+// limitBody 限制请求体大小，Content-Length 已经超出时直接返回 413，否则读到上限时报错
+func limitBody(max int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			writeBodyError(w, r, &http.MaxBytesError{Limit: max})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
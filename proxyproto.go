@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== PROXY protocol（v1 / v2） =====
+//
+// 放在 TCP 层负载均衡（HAProxy、AWS NLB、云厂商 L4 LB 等）后面时，HTTP 头里拿不到真实客户端 IP。
+// 开启后监听器会先读取 LB 加在连接最前面的 PROXY 头，r.RemoteAddr 即为真实客户端地址；
+// v2 头里的 TLS 信息（LB 终止 TLS 时）也会保留下来，见 proxyInfoFromRequest。
+//
+// 配了 trusted_cidrs 时只有来自这些地址的连接才解析 PROXY 头，其他连接按普通连接处理；
+// 没配时所有连接都必须带 PROXY 头，否则直接断开。
+
+// ProxyProtocolConfig PROXY protocol 配置
+type ProxyProtocolConfig struct {
+	Enabled              bool     `json:"enabled"`
+	TrustedCIDRs         []string `json:"trusted_cidrs"`          // 可信的 LB 地址段，为空表示所有连接都必须带 PROXY 头
+	HeaderTimeoutSeconds int      `json:"header_timeout_seconds"` // 读取 PROXY 头的超时，默认 5
+}
+
+const (
+	proxyDefaultHeaderTimeout = 5
+	proxyV1MaxLen             = 107
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyInfo 从 PROXY 头解析出来的连接信息
+type proxyInfo struct {
+	Source     net.Addr
+	Dest       net.Addr
+	TLS        bool   // LB 与客户端之间是 TLS
+	TLSVersion string // 如 TLSv1.3
+	TLSCN      string // 客户端证书 CN（mTLS）
+	Authority  string // SNI
+}
+
+type proxyInfoKey struct{}
+
+// proxyInfoFromRequest 取出请求所在连接的 PROXY 信息，没有时返回 nil
+func proxyInfoFromRequest(r *http.Request) *proxyInfo {
+	pc, ok := r.Context().Value(proxyInfoKey{}).(*proxyConn)
+	if !ok {
+		return nil
+	}
+	pc.readHeader()
+	return pc.info
+}
+
+// requestIsTLS 客户端到服务端（含 LB）是否走的 TLS
+func requestIsTLS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	info := proxyInfoFromRequest(r)
+	return info != nil && info.TLS
+}
+
+// proxyListener 包装监听器，Accept 不阻塞，PROXY 头在连接自己的 goroutine 里首次使用时读取
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+func newProxyListener(ln net.Listener, cfg ProxyProtocolConfig) (*proxyListener, error) {
+	pl := &proxyListener{Listener: ln, timeout: time.Duration(cfg.HeaderTimeoutSeconds) * time.Second}
+	if pl.timeout <= 0 {
+		pl.timeout = proxyDefaultHeaderTimeout * time.Second
+	}
+	for _, cidr := range cfg.TrustedCIDRs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_cidrs 无效 %q: %w", cidr, err)
+		}
+		pl.trusted = append(pl.trusted, n)
+	}
+	return pl, nil
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	if len(l.trusted) == 0 {
+		return true
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn 读取 PROXY 头之后的连接
+type proxyConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once sync.Once
+	info *proxyInfo
+	err  error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.info, c.err = readProxyHeader(c.r)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			log.Printf("⚠️ PROXY 头解析失败 from=%s: %v\n", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.info != nil && c.info.Source != nil {
+		return c.info.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.info != nil && c.info.Dest != nil {
+		return c.info.Dest
+	}
+	return c.Conn.LocalAddr()
+}
+
+// proxyConnContext 把连接放进请求 context（http.Server.ConnContext）。
+// 这里在 Accept 循环里执行，不能读 PROXY 头，用到时再由 proxyInfoFromRequest 读取（那时请求早已读完）
+func proxyConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if pc, ok := conn.(*proxyConn); ok {
+		return context.WithValue(ctx, proxyInfoKey{}, pc)
+	}
+	return ctx
+}
+
+// readProxyHeader 按首字节区分 v1 文本头和 v2 二进制头
+func readProxyHeader(r *bufio.Reader) (*proxyInfo, error) {
+	peek, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(peek, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if bytes.HasPrefix(peek, []byte("PROXY ")) {
+		return readProxyV1(r)
+	}
+	return nil, errors.New("缺少 PROXY 头")
+}
+
+// readProxyV1 解析 "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n"
+func readProxyV1(r *bufio.Reader) (*proxyInfo, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("PROXY v1 头过长或未以 CRLF 结尾")
+	}
+
+	fields := strings.Split(s, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return &proxyInfo{}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("PROXY v1 头格式错误: %q", s)
+	}
+	src, err := proxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := proxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	return &proxyInfo{Source: src, Dest: dst}, nil
+}
+
+func proxyV1Addr(ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("PROXY v1 地址无效: %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("PROXY v1 端口无效: %q", port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+// v2 TLV 类型
+const (
+	pp2TypeAuthority     = 0x02
+	pp2TypeSSL           = 0x20
+	pp2SubtypeSSLVersion = 0x21
+	pp2SubtypeSSLCN      = 0x22
+	pp2ClientSSL         = 0x01
+)
+
+// readProxyV2 解析二进制头：12 字节签名 + ver_cmd + fam + 2 字节长度 + 地址 + TLV
+func readProxyV2(r *bufio.Reader) (*proxyInfo, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("PROXY v2 版本不支持: %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	info := &proxyInfo{}
+	// LOCAL 命令（LB 自己的健康检查）不带地址
+	if hdr[12]&0x0f == 0x00 {
+		return info, nil
+	}
+
+	var tlvs []byte
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("PROXY v2 IPv4 地址长度不足")
+		}
+		info.Source = &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}
+		info.Dest = &net.TCPAddr{IP: net.IP(body[4:8]), Port: int(binary.BigEndian.Uint16(body[10:12]))}
+		tlvs = body[12:]
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("PROXY v2 IPv6 地址长度不足")
+		}
+		info.Source = &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}
+		info.Dest = &net.TCPAddr{IP: net.IP(body[16:32]), Port: int(binary.BigEndian.Uint16(body[34:36]))}
+		tlvs = body[36:]
+	default:
+		// UNSPEC / UDP / unix socket：保留原始地址
+		return info, nil
+	}
+
+	parseProxyTLVs(tlvs, func(typ byte, value []byte) {
+		switch typ {
+		case pp2TypeAuthority:
+			info.Authority = string(value)
+		case pp2TypeSSL:
+			// client(1) + verify(4) + 子 TLV
+			if len(value) < 5 {
+				return
+			}
+			info.TLS = value[0]&pp2ClientSSL != 0
+			parseProxyTLVs(value[5:], func(sub byte, v []byte) {
+				switch sub {
+				case pp2SubtypeSSLVersion:
+					info.TLSVersion = string(v)
+				case pp2SubtypeSSLCN:
+					info.TLSCN = string(v)
+				}
+			})
+		}
+	})
+	return info, nil
+}
+
+// parseProxyTLVs 遍历 type(1) + len(2) + value 格式的 TLV，长度不对时停止
+func parseProxyTLVs(b []byte, fn func(typ byte, value []byte)) {
+	for len(b) >= 3 {
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return
+		}
+		fn(b[0], b[3:3+n])
+		b = b[3+n:]
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// proxyV2Header 拼一个 PROXY v2 头：签名 + ver_cmd + fam + 长度 + body
+func proxyV2Header(verCmd, fam byte, body []byte) []byte {
+	b := append([]byte{}, proxyV2Signature...)
+	b = append(b, verCmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(b[14:16], uint16(len(body)))
+	return append(b, body...)
+}
+
+func proxyTLV(typ byte, value []byte) []byte {
+	return append([]byte{typ, byte(len(value) >> 8), byte(len(value))}, value...)
+}
+
+func parseProxy(b []byte) (*proxyInfo, error) {
+	return readProxyHeader(bufio.NewReader(bytes.NewReader(b)))
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4 := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x04, 0xd2, 0x00, 0x50}
+	ssl := proxyTLV(pp2TypeSSL, append([]byte{pp2ClientSSL, 0, 0, 0, 0}, proxyTLV(pp2SubtypeSSLCN, []byte("alice"))...))
+	validV2 := proxyV2Header(0x21, 0x11, append(append(append([]byte{}, ipv4...), proxyTLV(pp2TypeAuthority, []byte("relay.example"))...), ssl...))
+
+	cases := []struct {
+		name    string
+		input   []byte
+		wantErr bool
+		check   func(*proxyInfo) bool
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n"), false,
+			func(p *proxyInfo) bool { return p.Source.String() == "1.2.3.4:1234" }},
+		{"v1 TCP6", []byte("PROXY TCP6 ::1 ::2 1234 80\r\n"), false,
+			func(p *proxyInfo) bool { return p.Source.String() == "[::1]:1234" }},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), false,
+			func(p *proxyInfo) bool { return p.Source == nil }},
+		{"v1 过长", []byte("PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n"), true, nil},
+		{"v1 缺 CRLF", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\n"), true, nil},
+		{"v1 字段不够", []byte("PROXY TCP4 1.2.3.4\r\n"), true, nil},
+		{"v1 地址无效", []byte("PROXY TCP4 1.2.3 5.6.7.8 1234 80\r\n"), true, nil},
+		{"v1 端口溢出", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 70000 80\r\n"), true, nil},
+		{"v1 协议未知", []byte("PROXY UDP4 1.2.3.4 5.6.7.8 1234 80\r\n"), true, nil},
+		{"v2 TCP4 + TLV", validV2, false, func(p *proxyInfo) bool {
+			return p.Source.String() == "10.0.0.1:1234" && p.Authority == "relay.example" && p.TLS && p.TLSCN == "alice"
+		}},
+		{"v2 LOCAL", proxyV2Header(0x20, 0x00, nil), false,
+			func(p *proxyInfo) bool { return p.Source == nil }},
+		{"v2 版本错误", proxyV2Header(0x11, 0x11, ipv4), true, nil},
+		{"v2 IPv4 地址不足", proxyV2Header(0x21, 0x11, ipv4[:8]), true, nil},
+		{"v2 IPv6 地址不足", proxyV2Header(0x21, 0x21, make([]byte, 20)), true, nil},
+		{"v2 声明长度超过实际", append(proxyV2Header(0x21, 0x11, nil)[:14], 0xff, 0xff), true, nil},
+		{"v2 TLV 长度越界", proxyV2Header(0x21, 0x11, append(append([]byte{}, ipv4...), pp2TypeAuthority, 0xff, 0xff, 'x')), false,
+			func(p *proxyInfo) bool { return p.Authority == "" }},
+		{"v2 SSL TLV 太短", proxyV2Header(0x21, 0x11, append(append([]byte{}, ipv4...), proxyTLV(pp2TypeSSL, []byte{1, 0})...)), false,
+			func(p *proxyInfo) bool { return !p.TLS }},
+		{"v2 子 TLV 截断", proxyV2Header(0x21, 0x11, append(append([]byte{}, ipv4...), proxyTLV(pp2TypeSSL, []byte{1, 0, 0, 0, 0, pp2SubtypeSSLCN, 0, 9, 'a'})...)), false,
+			func(p *proxyInfo) bool { return p.TLS && p.TLSCN == "" }},
+		{"没有 PROXY 头", []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"), true, nil},
+		{"空输入", nil, true, nil},
+	}
+	for _, tc := range cases {
+		info, err := parseProxy(tc.input)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if tc.check != nil && !tc.check(info) {
+			t.Errorf("%s: 解析结果 %+v", tc.name, info)
+		}
+	}
+}
+
+func TestReadProxyHeaderTruncated(t *testing.T) {
+	for _, valid := range [][]byte{
+		[]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n"),
+		proxyV2Header(0x21, 0x21, make([]byte, 36)),
+	} {
+		// 合法头的任意前缀都只能报错，不能 panic 或被当成完整的头
+		for i := 0; i < len(valid); i++ {
+			if info, err := parseProxy(valid[:i]); err == nil {
+				t.Fatalf("前 %d 字节被解析成 %+v", i, info)
+			}
+		}
+	}
+}
@@ -0,0 +1,591 @@
+// Package pushclient 是中继 HTTP 推送接口（/v1）的 Go 客户端，给内部 Go 服务用，
+// 不用再手写 HTTP 请求、复制信封结构体。
+//
+//	c := pushclient.New("https://relay.example.com", os.Getenv("RELAY_API_KEY"))
+//	res, err := c.Push(ctx, pushclient.PushRequest{
+//		EventName: "order.paid",
+//		Token:     "42",
+//		Subject:   map[string]interface{}{"order_id": 1001},
+//	})
+//	var apiErr *pushclient.Error
+//	if errors.As(err, &apiErr) && apiErr.Code == pushclient.CodeValidation { ... }
+//
+// 每次 Push 都带 Idempotency-Key（没指定时自动生成），网络错误、429、502/503/504 按指数退避重试，
+// 服务端对同一个键只执行一次，重试不会重复推送。开启 push_signing 时用 WithSigningSecret 给每次请求签名。
+package pushclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout          = 10 * time.Second
+	defaultMaxRetries       = 3
+	defaultMinBackoff       = 200 * time.Millisecond
+	defaultMaxBackoff       = 5 * time.Second
+	defaultBatchConcurrency = 8
+	maxErrorBody            = 4 << 10
+
+	userAgent = "GoRelay-pushclient/1"
+)
+
+// 服务端 problem+json 的稳定错误码，见中继的 problem.go
+const (
+	CodeUnauthorized    = "unauthorized"
+	CodeForbidden       = "forbidden"
+	CodeNotFound        = "not_found"
+	CodeConflict        = "conflict"
+	CodeInvalidJSON     = "invalid_json"
+	CodeValidation      = "validation_failed"
+	CodePayloadTooLarge = "payload_too_large"
+	CodeRateLimited     = "rate_limited"
+	CodeMaintenance     = "maintenance"
+	CodeUnavailable     = "unavailable"
+)
+
+// Client 推送接口客户端，可以被多个协程共用
+type Client struct {
+	baseURL          string
+	apiKey           string
+	bearerToken      string
+	signingSecret    string
+	httpClient       *http.Client
+	maxRetries       int
+	minBackoff       time.Duration
+	maxBackoff       time.Duration
+	batchConcurrency int
+}
+
+// Option 构造 Client 时的可选项
+type Option func(*Client)
+
+// WithHTTPClient 使用自己的 http.Client（代理、TLS、超时等）
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithBearerToken 用 Authorization: Bearer 认证（服务端 push 认证链配置了 jwt 时），代替 API Key
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.bearerToken = token }
+}
+
+// WithSigningSecret 服务端开启 push_signing（或 hmac 认证）时的签名密钥
+func WithSigningSecret(secret string) Option {
+	return func(c *Client) { c.signingSecret = secret }
+}
+
+// WithRetries 失败后最多重试几次，0 表示不重试，默认 3
+func WithRetries(n int) Option {
+	return func(c *Client) { c.maxRetries = n }
+}
+
+// WithBackoff 重试间隔的下限和上限，每次翻倍并加随机抖动，默认 200ms ~ 5s
+func WithBackoff(lo, hi time.Duration) Option {
+	return func(c *Client) { c.minBackoff, c.maxBackoff = lo, hi }
+}
+
+// WithBatchConcurrency PushBatch 的并发数，默认 8
+func WithBatchConcurrency(n int) Option {
+	return func(c *Client) { c.batchConcurrency = n }
+}
+
+// New 创建客户端，baseURL 为中继地址（如 https://relay.example.com），apiKey 为空时只用 Bearer / 签名认证
+func New(baseURL, apiKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:          strings.TrimRight(baseURL, "/"),
+		apiKey:           apiKey,
+		httpClient:       &http.Client{Timeout: defaultTimeout},
+		maxRetries:       defaultMaxRetries,
+		minBackoff:       defaultMinBackoff,
+		maxBackoff:       defaultMaxBackoff,
+		batchConcurrency: defaultBatchConcurrency,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+	if c.minBackoff <= 0 {
+		c.minBackoff = defaultMinBackoff
+	}
+	if c.maxBackoff < c.minBackoff {
+		c.maxBackoff = c.minBackoff
+	}
+	if c.batchConcurrency <= 0 {
+		c.batchConcurrency = 1
+	}
+	return c
+}
+
+// ===== 请求 / 响应结构 =====
+
+// PushRequest POST /v1/push 的请求体，字段含义同 README“推送接口”
+type PushRequest struct {
+	EventName string `json:"event_name"`
+	// Token 目标用户：用户 ID（字符串 / 数字）或带 id / user_id 的对象，也可以是它们的数组（多用户推送）；为空表示广播
+	Token   interface{} `json:"token,omitempty"`
+	Subject interface{} `json:"subject,omitempty"`
+	// Channel 发给频道内所有订阅连接，不能和 Token / DeviceID / ClientID / Selector / Namespace 同时使用
+	Channel string `json:"channel,omitempty"`
+	// Group 发给具名组内所有在线连接（服务端需开启 groups），可以再用 Selector / Namespace 过滤
+	Group string `json:"group,omitempty"`
+
+	DelaySeconds int    `json:"delay_seconds,omitempty"`
+	DeliverAt    string `json:"deliver_at,omitempty"` // RFC3339；配合 Timezone 时为当地时间
+	Timezone     string `json:"timezone,omitempty"`
+	// Cron 5 段 cron 表达式，登记为周期推送（结果在 PushResult.RecurringJob）
+	Cron string `json:"cron,omitempty"`
+
+	DeviceID  string            `json:"device_id,omitempty"`
+	ClientID  string            `json:"client_id,omitempty"`
+	Selector  map[string]string `json:"selector,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+
+	Ciphertext string `json:"ciphertext,omitempty"`
+	KeyID      string `json:"key_id,omitempty"`
+
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// RequireAck 要求原生客户端回 ack，超时重发（服务端需开启 ack_retry），结果用 Delivery 查询
+	RequireAck bool `json:"require_ack,omitempty"`
+	// CallbackURL 推送有了最终结果后服务端 POST 到这个地址（服务端需开启 push_callbacks）
+	CallbackURL string `json:"callback_url,omitempty"`
+	// Events 多个事件作为一笔事务按顺序连续发给每个目标连接，此时 EventName 可省略、不能再带 Subject
+	Events []Event `json:"events,omitempty"`
+	// ExcludeTokens 广播 / 频道 / 组推送时跳过这些用户的所有连接，元素格式与 Token 相同
+	ExcludeTokens []interface{} `json:"exclude_tokens,omitempty"`
+	// Sync 扇出完成后再返回，服务端开启 push_queue 时也等队列发送完，结果在 PushResult.Delivered；不能和定时 / Cron 同时使用
+	Sync bool `json:"sync,omitempty"`
+
+	// IdempotencyKey 不发给服务端请求体，作为 Idempotency-Key 头；为空时自动生成
+	IdempotencyKey string `json:"-"`
+}
+
+// Event 事务推送里的一个事件
+type Event struct {
+	EventName string      `json:"event_name"`
+	Subject   interface{} `json:"subject,omitempty"`
+}
+
+// PushResult /v1/push 的结果
+type PushResult struct {
+	EventName    string `json:"event_name"`
+	Broadcast    bool   `json:"broadcast"`
+	TargetUserID string `json:"target_user_id,omitempty"`
+	Channel      string `json:"channel,omitempty"`
+	Group        string `json:"group,omitempty"`
+	Delivered    int    `json:"delivered"`            // 立即发送（或带 Sync 等队列发送完）时投递到的连接数
+	Queued       bool   `json:"queued,omitempty"`     // 服务端开启 push_queue 时已入队
+	MessageID    string `json:"message_id,omitempty"` // 开启 receipts 或入队时的消息 ID
+	Job          *Job   `json:"job,omitempty"`        // 延迟发送时的任务
+
+	RecurringJob *RecurringJob `json:"recurring_job,omitempty"` // 带 Cron 时登记的周期任务
+
+	// Token 为数组时：去重后的目标用户，以及立即发送时各用户投递到的连接数
+	TargetUserIDs []string       `json:"target_user_ids,omitempty"`
+	Users         map[string]int `json:"users,omitempty"`
+
+	IdempotencyKey string `json:"-"` // 本次使用的幂等键
+	Replayed       bool   `json:"-"` // 服务端按幂等键回放了之前的结果（之前某次重试其实已经成功）
+}
+
+// Job 延迟推送任务
+type Job struct {
+	ID            string     `json:"id"`
+	Status        string     `json:"status"` // scheduled / delivered / cancelled / missed
+	EventName     string     `json:"event_name"`
+	TargetUserID  string     `json:"target_user_id,omitempty"`
+	TargetUserIDs []string   `json:"target_user_ids,omitempty"`
+	Channel       string     `json:"channel,omitempty"`
+	Group         string     `json:"group,omitempty"`
+	Broadcast     bool       `json:"broadcast"`
+	CreatedAt     time.Time  `json:"created_at"`
+	RunAt         time.Time  `json:"run_at"`
+	DeliverAt     string     `json:"deliver_at,omitempty"`
+	Timezone      string     `json:"timezone,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	Delivered     int        `json:"delivered"`
+	Misfire       string     `json:"misfire,omitempty"`
+	MessageID     string     `json:"message_id,omitempty"`
+}
+
+// RecurringJob 周期推送任务
+type RecurringJob struct {
+	ID            string     `json:"id"`
+	Cron          string     `json:"cron"`
+	Timezone      string     `json:"timezone,omitempty"`
+	EventName     string     `json:"event_name"`
+	TargetUserID  string     `json:"target_user_id,omitempty"`
+	TargetUserIDs []string   `json:"target_user_ids,omitempty"`
+	Channel       string     `json:"channel,omitempty"`
+	Group         string     `json:"group,omitempty"`
+	Broadcast     bool       `json:"broadcast"`
+	CreatedAt     time.Time  `json:"created_at"`
+	NextRunAt     time.Time  `json:"next_run_at"`
+	LastRunAt     *time.Time `json:"last_run_at,omitempty"`
+	LastDelivered int        `json:"last_delivered"`
+	Runs          uint64     `json:"runs"`
+}
+
+// Delivery require_ack 推送的投递状态
+type Delivery struct {
+	MessageID    string    `json:"message_id"`
+	EventName    string    `json:"event_name"`
+	TargetUserID string    `json:"target_user_id,omitempty"`
+	Channel      string    `json:"channel,omitempty"`
+	Group        string    `json:"group,omitempty"`
+	SentAt       time.Time `json:"sent_at"`
+	Status       string    `json:"status"` // pending / acked / partial / failed / no_recipients
+	Attempts     int       `json:"attempts"`
+	Counts       struct {
+		Pending int `json:"pending"`
+		Acked   int `json:"acked"`
+		Failed  int `json:"failed"`
+	} `json:"counts"`
+	Connections []DeliveryAttempt `json:"connections"`
+}
+
+// DeliveryAttempt 一个连接的确认状态
+type DeliveryAttempt struct {
+	ConnectionID string     `json:"connection_id"`
+	UserID       string     `json:"user_id,omitempty"`
+	Status       string     `json:"status"` // pending / acked / failed
+	Attempts     int        `json:"attempts"`
+	LastSentAt   time.Time  `json:"last_sent_at"`
+	AckedAt      *time.Time `json:"acked_at,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// Presence 用户在线状态
+type Presence struct {
+	Online      bool `json:"online"`
+	Connections int  `json:"connections"`
+}
+
+// BatchResult PushBatch 中一条推送的结果，Result 和 Err 只有一个非空
+type BatchResult struct {
+	Result *PushResult
+	Err    error
+}
+
+// Error 服务端返回的 problem+json 错误
+type Error struct {
+	Status   int    `json:"status"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance"`
+	Code     string `json:"code"`   // 稳定错误码，见 Code* 常量
+	Field    string `json:"field"`  // 校验失败的字段
+	Reason   string `json:"reason"` // 细分原因，如限流的 queue_full、幂等冲突的 in_progress
+
+	RetryAfter time.Duration `json:"-"` // 响应带 Retry-After 时的建议等待时间
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("relay: %d %s", e.Status, e.Code)
+	if e.Field != "" {
+		msg += " (" + e.Field + ")"
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// Retryable 是否值得稍后重试（限流、维护、节点暂时不可用、同一幂等键的请求还在处理）
+func (e *Error) Retryable() bool {
+	switch e.Status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusConflict:
+		return e.Reason == "in_progress"
+	}
+	return false
+}
+
+// ===== 接口 =====
+
+// Push 发送一条推送；失败时按配置重试，所有重试使用同一个幂等键
+func (c *Client) Push(ctx context.Context, req PushRequest) (*PushResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	key := req.IdempotencyKey
+	if key == "" {
+		key = newIdempotencyKey()
+	}
+
+	var res PushResult
+	hdr, err := c.do(ctx, http.MethodPost, "/v1/push", body, key, &res)
+	if err != nil {
+		return nil, err
+	}
+	res.IdempotencyKey = key
+	res.Replayed = hdr.Get("Idempotent-Replayed") == "true"
+	return &res, nil
+}
+
+// PushBatch 并发发送多条推送，结果与 reqs 一一对应；单条失败不影响其它条
+func (c *Client) PushBatch(ctx context.Context, reqs []PushRequest) []BatchResult {
+	results := make([]BatchResult, len(reqs))
+	sem := make(chan struct{}, c.batchConcurrency)
+	var wg sync.WaitGroup
+	for i := range reqs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			res, err := c.Push(ctx, reqs[i])
+			results[i] = BatchResult{Result: res, Err: err}
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// Presence 查询用户是否在线（一次最多 100 个）
+func (c *Client) Presence(ctx context.Context, userIDs ...string) (map[string]Presence, error) {
+	q := url.Values{"user_id": userIDs}
+	var out struct {
+		Users map[string]Presence `json:"users"`
+	}
+	if _, err := c.do(ctx, http.MethodGet, "/v1/presence?"+q.Encode(), nil, "", &out); err != nil {
+		return nil, err
+	}
+	return out.Users, nil
+}
+
+// Jobs 列出延迟推送任务，status 为空表示全部
+func (c *Client) Jobs(ctx context.Context, status string) ([]Job, error) {
+	p := "/v1/jobs"
+	if status != "" {
+		p += "?status=" + url.QueryEscape(status)
+	}
+	var out struct {
+		Jobs []Job `json:"jobs"`
+	}
+	if _, err := c.do(ctx, http.MethodGet, p, nil, "", &out); err != nil {
+		return nil, err
+	}
+	return out.Jobs, nil
+}
+
+// Job 查询一个延迟推送任务
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	return c.job(ctx, http.MethodGet, id)
+}
+
+// CancelJob 取消一个还没发送的延迟推送任务
+func (c *Client) CancelJob(ctx context.Context, id string) (*Job, error) {
+	return c.job(ctx, http.MethodDelete, id)
+}
+
+// RecurringJobs 列出周期推送任务
+func (c *Client) RecurringJobs(ctx context.Context) ([]RecurringJob, error) {
+	var out struct {
+		RecurringJobs []RecurringJob `json:"recurring_jobs"`
+	}
+	if _, err := c.do(ctx, http.MethodGet, "/v1/recurring-jobs", nil, "", &out); err != nil {
+		return nil, err
+	}
+	return out.RecurringJobs, nil
+}
+
+// RecurringJob 查询一个周期推送任务
+func (c *Client) RecurringJob(ctx context.Context, id string) (*RecurringJob, error) {
+	return c.recurringJob(ctx, http.MethodGet, id)
+}
+
+// DeleteRecurringJob 删除一个周期推送任务，之后不再发送
+func (c *Client) DeleteRecurringJob(ctx context.Context, id string) (*RecurringJob, error) {
+	return c.recurringJob(ctx, http.MethodDelete, id)
+}
+
+// Delivery 查询 require_ack 推送的投递状态，id 为推送结果里的 MessageID
+func (c *Client) Delivery(ctx context.Context, messageID string) (*Delivery, error) {
+	var out Delivery
+	if _, err := c.do(ctx, http.MethodGet, "/v1/messages/"+url.PathEscape(messageID)+"/delivery", nil, "", &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) job(ctx context.Context, method, id string) (*Job, error) {
+	var out struct {
+		Job Job `json:"job"`
+	}
+	if _, err := c.do(ctx, method, "/v1/jobs/"+url.PathEscape(id), nil, "", &out); err != nil {
+		return nil, err
+	}
+	return &out.Job, nil
+}
+
+func (c *Client) recurringJob(ctx context.Context, method, id string) (*RecurringJob, error) {
+	var out struct {
+		RecurringJob RecurringJob `json:"recurring_job"`
+	}
+	if _, err := c.do(ctx, method, "/v1/recurring-jobs/"+url.PathEscape(id), nil, "", &out); err != nil {
+		return nil, err
+	}
+	return &out.RecurringJob, nil
+}
+
+// ===== 发送与重试 =====
+
+// do 发送请求并把 data 解到 out，返回最后一次响应的头
+func (c *Client) do(ctx context.Context, method, path string, body []byte, idempotencyKey string, out interface{}) (http.Header, error) {
+	for attempt := 0; ; attempt++ {
+		hdr, err := c.once(ctx, method, path, body, idempotencyKey, out)
+		if err == nil {
+			return hdr, nil
+		}
+		wait, retry := c.retryDelay(err, attempt)
+		if !retry || attempt >= c.maxRetries {
+			return nil, err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// once 发送一次请求
+func (c *Client) once(ctx context.Context, method, path string, body []byte, idempotencyKey string, out interface{}) (http.Header, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-KEY", c.apiKey)
+	}
+	if c.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	if c.signingSecret != "" {
+		sign(req, c.signingSecret, body)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, decodeError(resp)
+	}
+	var env struct {
+		Code int             `json:"code"`
+		Msg  string          `json:"msg"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return nil, fmt.Errorf("relay: decode response: %w", err)
+	}
+	if out != nil && len(env.Data) > 0 {
+		if err := json.Unmarshal(env.Data, out); err != nil {
+			return nil, fmt.Errorf("relay: decode response data: %w", err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// retryDelay 判断是否重试以及等多久：网络错误和可重试的状态码按指数退避，服务端给了更长的 Retry-After 时以它为准
+func (c *Client) retryDelay(err error, attempt int) (time.Duration, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) && !apiErr.Retryable() {
+		return 0, false
+	}
+
+	backoff := c.minBackoff << attempt
+	if backoff <= 0 || backoff > c.maxBackoff {
+		backoff = c.maxBackoff
+	}
+	// 抖动：在 [backoff/2, backoff] 之间取值，避免大量调用方同时重试
+	wait := backoff/2 + randDuration(backoff/2+1)
+	if apiErr != nil && apiErr.RetryAfter > wait {
+		wait = apiErr.RetryAfter
+	}
+	return wait, true
+}
+
+// decodeError 把非 2xx 响应转成 *Error；不是 problem+json 时用状态码和响应体开头填充
+func decodeError(resp *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	e := &Error{}
+	if err := json.Unmarshal(raw, e); err != nil || e.Code == "" {
+		e = &Error{Title: http.StatusText(resp.StatusCode), Detail: strings.TrimSpace(string(raw))}
+	}
+	e.Status = resp.StatusCode
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+	return e
+}
+
+// sign 按 push_signing 的规则签名：hex(HMAC-SHA256(secret, timestamp + "\n" + nonce + "\n" + body))，每次重试都换新的 nonce
+func sign(req *http.Request, secret string, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := randomHex(16)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "\n" + nonce + "\n"))
+	mac.Write(body)
+	req.Header.Set("X-Relay-Timestamp", ts)
+	req.Header.Set("X-Relay-Nonce", nonce)
+	req.Header.Set("X-Relay-Signature", hex.EncodeToString(mac.Sum(nil)))
+}
+
+func newIdempotencyKey() string {
+	return randomHex(16)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func randDuration(n time.Duration) time.Duration {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(v.Int64())
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ===== Pusher Channels 协议兼容 =====
+//
+// 客户端：pusher-js 把 wsHost 指向本服务即可，连接 /app/{key}
+// 服务端：HTTP 触发接口 POST /apps/{app_id}/events，签名规则与官方一致
+
+// PusherConfig Pusher 兼容端点配置
+type PusherConfig struct {
+	Enabled bool   `json:"enabled"`
+	AppID   string `json:"app_id"`
+	Key     string `json:"key"`
+	Secret  string `json:"secret"`
+}
+
+const (
+	pusherActivityTimeout = 120 // 秒，告诉客户端多久没消息就发 pusher:ping
+	pusherMaxChannels     = 100 // 单次 trigger 最多频道数
+	pusherAuthMaxSkew     = 600 // 秒，auth_timestamp 允许的时间偏差
+)
+
+// pusherFrame Pusher 协议的消息帧，data 在协议里是 JSON 字符串
+type pusherFrame struct {
+	Event   string      `json:"event"`
+	Channel string      `json:"channel,omitempty"`
+	Data    interface{} `json:"data"`
+}
+
+// pusherSubscribeData pusher:subscribe 的 data
+type pusherSubscribeData struct {
+	Channel     string `json:"channel"`
+	Auth        string `json:"auth"`
+	ChannelData string `json:"channel_data"`
+}
+
+// pusherTriggerRequest POST /apps/{app_id}/events 的请求体
+type pusherTriggerRequest struct {
+	Name     string   `json:"name"`
+	Data     string   `json:"data"`
+	Channel  string   `json:"channel"`
+	Channels []string `json:"channels"`
+	SocketID string   `json:"socket_id"`
+}
+
+func registerPusherRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/app/{key}", upgradeGuard(pusherWSHandler))
+	// 签名要读完整个 body 才能校验，先限制大小，超过上限回 413
+	mux.Handle("POST /apps/{app_id}/events", limitBody(pushMaxBodyBytes, http.HandlerFunc(pusherTriggerHandler)))
+	log.Printf("✅ Pusher 兼容端点已启用：ws /app/%s，trigger /apps/%s/events\n",
+		GlobalConfig.Pusher.Key, GlobalConfig.Pusher.AppID)
+}
+
+// toPusherFrame 把标准消息转成 Pusher 帧：data 统一序列化为字符串
+func toPusherFrame(msg WSMessage) interface{} {
+	data, ok := msg.Data.(string)
+	if !ok {
+		data = toJSON(msg.Data)
+	}
+	return pusherFrame{Event: msg.Event, Channel: msg.Channel, Data: data}
+}
+
+// ===== WebSocket 端 =====
+
+func pusherWSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("key") != GlobalConfig.Pusher.Key {
+		log.Println("❌ Pusher 连接 app key 不匹配:", r.PathValue("key"))
+		http.Error(w, "unknown app key", http.StatusNotFound)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, withAffinityCookie(r, nil))
+	if err != nil {
+		log.Println("Pusher WebSocket upgrade error:", err)
+		return
+	}
+
+	client := newClient(conn, r)
+	client.frame, client.frameKey = toPusherFrame, "pusher"
+	addClient(client)
+
+	defer func() {
+		conn.Close()
+		removeClient(client)
+	}()
+
+	established, _ := json.Marshal(map[string]interface{}{
+		"socket_id":        client.id,
+		"activity_timeout": pusherActivityTimeout,
+	})
+	if err := client.sendJSON(pusherFrame{Event: "pusher:connection_established", Data: string(established)}); err != nil {
+		log.Println("⚠️ Pusher connection_established 发送失败:", err)
+		return
+	}
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			noteReadError(client, "Pusher", err)
+			break
+		}
+
+		var msg struct {
+			Event   string          `json:"event"`
+			Channel string          `json:"channel"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			log.Println("⚠️ Pusher message parse error:", err)
+			continue
+		}
+
+		switch msg.Event {
+		case "pusher:ping":
+			_ = client.sendJSON(pusherFrame{Event: "pusher:pong", Data: "{}"})
+		case "pusher:subscribe":
+			pusherSubscribe(client, msg.Data)
+		case "pusher:unsubscribe":
+			var sub pusherSubscribeData
+			if err := json.Unmarshal(msg.Data, &sub); err == nil && sub.Channel != "" {
+				unsubscribeChannel(client, sub.Channel)
+			}
+		default:
+			if readOnly.Load() {
+				pusherSendError(client, 4301, "server is in read-only mode")
+				continue
+			}
+			if e := inboundEventError(client, msg.Event); e != nil {
+				if !rejectInboundWith(client, e, func(e *clientError) error {
+					return client.sendJSON(pusherFrame{Event: "pusher:error", Data: map[string]interface{}{"code": 4301, "message": e.Msg}})
+				}) {
+					return
+				}
+				continue
+			}
+			if shouldLogEvent(msg.Event) {
+				log.Printf("📨 [Pusher event] %s channel=%s %s\n", msg.Event, msg.Channel, redactLog(msg.Data))
+			}
+			publishInbound(client, "pusher", msg.Event, msg.Channel, msg.Data)
+		}
+	}
+}
+
+func pusherSubscribe(c *Client, raw json.RawMessage) {
+	var sub pusherSubscribeData
+	if err := json.Unmarshal(raw, &sub); err != nil || sub.Channel == "" {
+		pusherSendError(c, 4009, "invalid subscribe payload")
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(sub.Channel, "presence-"):
+		pusherSendError(c, 4009, "presence channels are not supported")
+		return
+	case strings.HasPrefix(sub.Channel, "private-"):
+		if !pusherCheckChannelAuth(c.id, sub.Channel, sub.Auth) {
+			log.Printf("❌ Pusher 私有频道鉴权失败 channel=%s socket_id=%s\n", sub.Channel, c.id)
+			pusherSendError(c, 4009, "invalid channel auth")
+			return
+		}
+	}
+
+	total := subscribeChannel(c, sub.Channel)
+	log.Printf("📡 Pusher 订阅 channel=%s socket_id=%s, 频道连接数=%d\n", sub.Channel, c.id, total)
+	_ = c.sendJSON(pusherFrame{
+		Event:   "pusher_internal:subscription_succeeded",
+		Channel: sub.Channel,
+		Data:    "{}",
+	})
+}
+
+func pusherSendError(c *Client, code int, message string) {
+	_ = c.sendJSON(pusherFrame{
+		Event: "pusher:error",
+		Data:  map[string]interface{}{"code": code, "message": message},
+	})
+}
+
+// pusherCheckChannelAuth 校验私有频道签名：auth = key:hex(hmac_sha256(secret, socket_id:channel))
+func pusherCheckChannelAuth(socketID, channel, auth string) bool {
+	cfg := GlobalConfig.Pusher
+	key, sig, ok := strings.Cut(auth, ":")
+	if !ok || key != cfg.Key {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(pusherSign(liveSecret("pusher.secret", cfg.Secret), socketID+":"+channel)))
+}
+
+func pusherSign(secret, s string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ===== HTTP trigger 端 =====
+
+func pusherTriggerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("app_id") != GlobalConfig.Pusher.AppID {
+		http.Error(w, "unknown app id", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	if msg, ok := pusherCheckRequestAuth(r, body); !ok {
+		log.Println("❌ Pusher trigger 签名校验失败:", msg)
+		http.Error(w, msg, http.StatusUnauthorized)
+		return
+	}
+
+	var req pusherTriggerRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	channels := req.Channels
+	if req.Channel != "" {
+		channels = append(channels, req.Channel)
+	}
+	if req.Name == "" || len(channels) == 0 {
+		http.Error(w, "name and channel(s) are required", http.StatusBadRequest)
+		return
+	}
+	if len(channels) > pusherMaxChannels {
+		http.Error(w, "too many channels", http.StatusBadRequest)
+		return
+	}
+
+	for _, ch := range channels {
+		sent := emitToChannel(ch, WSMessage{Event: req.Name, Channel: ch, Data: req.Data}, req.SocketID)
+		if shouldLogEvent(req.Name) {
+			log.Printf("📡 Pusher trigger \"%s\" channel=%s, 送达连接数=%d\n", req.Name, ch, sent)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+}
+
+// pusherCheckRequestAuth 按 Pusher HTTP API 规则校验 auth_signature，失败时返回原因
+func pusherCheckRequestAuth(r *http.Request, body []byte) (string, bool) {
+	cfg := GlobalConfig.Pusher
+	q := r.URL.Query()
+
+	if q.Get("auth_key") != cfg.Key {
+		return "invalid auth_key", false
+	}
+
+	ts, err := strconv.ParseInt(q.Get("auth_timestamp"), 10, 64)
+	if err != nil {
+		return "invalid auth_timestamp", false
+	}
+	if skew := time.Now().Unix() - ts; skew > pusherAuthMaxSkew || skew < -pusherAuthMaxSkew {
+		return "auth_timestamp expired", false
+	}
+
+	if len(body) > 0 {
+		sum := md5.Sum(body)
+		if q.Get("body_md5") != hex.EncodeToString(sum[:]) {
+			return "body_md5 mismatch", false
+		}
+	}
+
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		if k != "auth_signature" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, strings.ToLower(k)+"="+q.Get(k))
+	}
+
+	toSign := r.Method + "\n" + r.URL.Path + "\n" + strings.Join(parts, "&")
+	if !hmac.Equal([]byte(q.Get("auth_signature")), []byte(pusherSign(liveSecret("pusher.secret", cfg.Secret), toSign))) {
+		return "invalid auth_signature", false
+	}
+	return "", true
+}
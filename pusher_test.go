@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPusherTriggerBodyLimit(t *testing.T) {
+	useConfig(t, func(cfg *Config) {
+		cfg.Pusher = PusherConfig{Enabled: true, AppID: "app", Key: "key", Secret: "secret"}
+	})
+	mux := http.NewServeMux()
+	registerPusherRoutes(mux)
+
+	// 签名校验之前就要挡住超大的请求体
+	body := strings.NewReader(`{"name":"x","data":"` + strings.Repeat("a", pushMaxBodyBytes) + `"}`)
+	r := httptest.NewRequest(http.MethodPost, "/apps/app/events", body)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", w.Code)
+	}
+
+	// 没有 Content-Length 时读到上限也要 413
+	r = httptest.NewRequest(http.MethodPost, "/apps/app/events", strings.NewReader(strings.Repeat("a", pushMaxBodyBytes+1)))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("chunked status = %d, want 413", w.Code)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// pbField 编码一个 length-delimited 字段
+func pbField(field uint64, v []byte) []byte {
+	b := binary.AppendUvarint(nil, field<<3|protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// pbVarint 编码一个 varint 字段
+func pbVarint(field, v uint64) []byte {
+	return binary.AppendUvarint(binary.AppendUvarint(nil, field<<3|protoVarint), v)
+}
+
+func TestDecodePushRequest(t *testing.T) {
+	fields := [][]byte{
+		pbField(1, []byte("order.paid")),
+		pbField(2, []byte(`{"id":1}`)),
+		pbField(3, []byte("u1")),
+		pbVarint(4, 30),
+		pbField(9, append(pbField(1, []byte("plan")), pbField(2, []byte("pro"))...)),
+		pbField(19, append(pbField(1, []byte("a")), pbField(2, []byte(`[1]`))...)),
+		pbField(20, []byte("u2")),
+		{15<<3 | 5, 1, 2, 3, 4}, // 未知的 fixed32 字段
+	}
+	var valid []byte
+	boundaries := map[int]bool{0: true}
+	for _, f := range fields {
+		valid = append(valid, f...)
+		boundaries[len(valid)] = true
+	}
+
+	req, field, err := decodePushRequest(valid)
+	if err != nil || field != "" {
+		t.Fatalf("合法请求: field=%q err=%v", field, err)
+	}
+	if req.EventName != "order.paid" || req.Token != "u1" || req.DelaySeconds != 30 || req.Selector["plan"] != "pro" ||
+		len(req.Events) != 1 || req.Events[0].EventName != "a" || len(req.ExcludeTokens) != 1 {
+		t.Fatalf("解码结果 %+v", req)
+	}
+
+	// 截在字段中间的前缀必须报错，截在字段边界上的前缀是合法的短消息
+	for i := 0; i < len(valid); i++ {
+		_, _, err := decodePushRequest(valid[:i])
+		if boundaries[i] != (err == nil) {
+			t.Fatalf("前 %d 字节: err = %v", i, err)
+		}
+	}
+
+	cases := []struct {
+		name      string
+		input     []byte
+		wantField string
+	}{
+		{"key varint 未结束", []byte{0x80, 0x80}, ""},
+		{"key varint 超过 10 字节", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, ""},
+		{"值 varint 未结束", []byte{4 << 3, 0xff}, ""},
+		{"长度超过剩余字节", []byte{1<<3 | protoBytes, 0x05, 'a'}, ""},
+		{"长度接近 2^64", append([]byte{1<<3 | protoBytes}, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01), ""},
+		{"fixed64 截断", []byte{15<<3 | 1, 1, 2, 3}, ""},
+		{"group 线型", []byte{15<<3 | 3}, ""},
+		{"未知线型 7", []byte{15<<3 | 7}, ""},
+		{"subject 不是 JSON", pbField(2, []byte("{")), "subject"},
+		{"selector 条目线型错误", pbField(9, pbVarint(1, 1)), ""},
+		{"selector 条目长度越界", pbField(9, []byte{1<<3 | protoBytes, 9}), ""},
+		{"event 长度越界", pbField(19, []byte{1<<3 | protoBytes, 9}), "events"},
+		{"event subject 不是 JSON", pbField(19, pbField(2, []byte("nope"))), "events"},
+	}
+	for _, tc := range cases {
+		_, field, err := decodePushRequest(tc.input)
+		if err == nil || field != tc.wantField {
+			t.Errorf("%s: field=%q err=%v", tc.name, field, err)
+		}
+	}
+}
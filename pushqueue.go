@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 异步推送队列 =====
+//
+// 默认 /api/push、/v1/push 在请求处理协程里直接扇出，广播给大量连接或有慢连接时调用方要一直等。
+// 开启 push_queue 后立即发送的推送先放进有界队列，立即返回 message_id，由固定数量的 worker 按入队顺序取出并发发送：
+//
+//	{"code":0,"msg":"ok","data":{"event_name":"order.paid","queued":true,"message_id":"msg_9f2c..."}}
+//
+// 队列满时返回 429 rate_limited 和 Retry-After，调用方退避后重试（水位和等待时间阈值见 backpressure.go）。延迟推送任务到点后仍由任务调度直接发送，不经过队列。
+// 入队后不再有 delivered 计数，需要投递结果时配合 receipts 按 message_id 查询；
+// 请求带 "sync": true 时仍然入队（受同样的背压约束），请求协程等 worker 发送完再带 delivered 响应。
+// 队列只在内存里，进程退出时尚未发送的推送会丢失。
+
+// PushQueueConfig 异步推送队列配置
+type PushQueueConfig struct {
+	Enabled bool `json:"enabled"`
+	Size    int  `json:"size"`    // 队列容量，默认 10000
+	Workers int  `json:"workers"` // 发送 worker 数，默认 8
+}
+
+const (
+	pushQueueDefaultSize    = 10000
+	pushQueueDefaultWorkers = 8
+)
+
+// queuedPush 队列中的一条推送
+type queuedPush struct {
+	p          *preparedPush
+	enqueuedAt time.Time
+}
+
+var (
+	pushQueueMu    sync.Mutex
+	pushQueueCond  = sync.NewCond(&pushQueueMu)
+	pushQueueItems []queuedPush
+
+	pushQueueEnqueued  atomic.Uint64
+	pushQueueRejected  atomic.Uint64
+	pushQueueProcessed atomic.Uint64
+)
+
+func preparePushQueue(cfg *PushQueueConfig) {
+	if cfg.Size <= 0 {
+		cfg.Size = pushQueueDefaultSize
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = pushQueueDefaultWorkers
+	}
+}
+
+// startPushQueue 启动发送 worker
+func startPushQueue() {
+	for i := 0; i < GlobalConfig.PushQueue.Workers; i++ {
+		go pushQueueWorker()
+	}
+	log.Printf("✅ 异步推送队列已启用：容量 %d，worker %d\n", GlobalConfig.PushQueue.Size, GlobalConfig.PushQueue.Workers)
+}
+
+// enqueuePush 分配 message_id 后入队；队列满或超过背压水位时不入队，返回拒绝原因（见 backpressure.go）
+func enqueuePush(p *preparedPush) string {
+	now := time.Now()
+	pushQueueMu.Lock()
+	defer pushQueueMu.Unlock()
+	if reason := queueBackpressureLocked(now); reason != "" {
+		pushQueueRejected.Add(1)
+		return reason
+	}
+	if p.message.ID == "" {
+		p.message.ID = newMessageID()
+	}
+	if p.body.Sync {
+		p.done = make(chan int, 1)
+	}
+	// 保序投递在接受请求时占位，多个 worker 并发取出也按入队顺序发送
+	if GlobalConfig.OrderedDelivery.Enabled && p.target != "" {
+		p.order = reserveUserOrder(p.target)
+	}
+	pushQueueItems = append(pushQueueItems, queuedPush{p: p, enqueuedAt: now})
+	pushQueueEnqueued.Add(1)
+	pushQueueCond.Signal()
+	return ""
+}
+
+func pushQueueWorker() {
+	for {
+		pushQueueMu.Lock()
+		for len(pushQueueItems) == 0 {
+			pushQueueCond.Wait()
+		}
+		q := pushQueueItems[0]
+		pushQueueItems[0] = queuedPush{}
+		pushQueueItems = pushQueueItems[1:]
+		pushQueueMu.Unlock()
+
+		delivered := q.p.emit()
+		pushQueueProcessed.Add(1)
+		if q.p.done != nil {
+			q.p.done <- delivered
+		}
+	}
+}
+
+// awaitQueuedPush 等 sync 推送被 worker 发送完，返回投递连接数；调用方先断开时返回 false，推送照常发送
+func awaitQueuedPush(r *http.Request, p *preparedPush) (int, bool) {
+	select {
+	case delivered := <-p.done:
+		return delivered, true
+	case <-r.Context().Done():
+		log.Printf("⚠️ sync 推送 %s 发送完成前调用方已断开\n", p.message.ID)
+		return 0, false
+	}
+}
+
+// pushQueueStats 当前排队数和最早一条的等待时间，用于指标
+func pushQueueStats() (depth int, oldest time.Duration) {
+	pushQueueMu.Lock()
+	defer pushQueueMu.Unlock()
+	if len(pushQueueItems) > 0 {
+		oldest = time.Since(pushQueueItems[0].enqueuedAt)
+	}
+	return len(pushQueueItems), oldest
+}
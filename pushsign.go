@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ===== 推送请求 HMAC 签名 + 防重放 =====
+//
+// 开启后 /push 请求除 API Key 外还需带：
+//
+//	X-Relay-Timestamp: 1700000000          （秒）
+//	X-Relay-Nonce:     随机串，每次请求不同
+//	X-Relay-Signature: hex(HMAC-SHA256(secret, timestamp + "\n" + nonce + "\n" + body))
+//
+// 时间戳超出 max_skew_seconds 的直接拒绝；窗口内见过的 nonce 再次出现视为重放。
+// 适用于推送会触发客户端动作（如强制下线）的场景。
+
+// PushSigningConfig 推送请求签名配置
+type PushSigningConfig struct {
+	Enabled        bool   `json:"enabled"`
+	Secret         string `json:"secret"`           // 支持 env:// / file:// 等引用
+	MaxSkewSeconds int    `json:"max_skew_seconds"` // 时间戳允许的偏差，默认 300
+}
+
+const (
+	pushSigningDefaultSkew = 300
+	pushSigningMaxNonceLen = 128
+	pushSigningMaxBody     = 1 << 20
+)
+
+var (
+	pushNonces   = make(map[string]time.Time) // nonce -> 过期时间
+	pushNoncesMu sync.Mutex
+)
+
+// checkPushSignature 推送请求签名中间件（push_signing），与认证链独立，开启后必须通过
+func checkPushSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// hmac 认证已经按同样的请求头校验过签名并登记了 nonce，再校验一次只会报 replayed nonce
+		if p := principalFromRequest(r); p != nil && p.Method == "hmac" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cfg := GlobalConfig.PushSigning
+		secret := liveSecret("push_signing.secret", cfg.Secret)
+		if err := verifyRequestSignature(w, r, secret, time.Duration(cfg.MaxSkewSeconds)*time.Second); err != nil {
+			log.Println("❌ 推送签名校验失败:", err)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeBodyError(w, r, err)
+				return
+			}
+			writeProblem(w, r, http.StatusUnauthorized, problemUnauthorized, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifyRequestSignature 校验签名、时间戳和 nonce，读取过的 body 会放回 r.Body 供后续 handler 使用；
+// body 超过 pushSigningMaxBody 时返回 *http.MaxBytesError（调用方回 413）。w 可以为 nil（认证链里拿不到 ResponseWriter）
+func verifyRequestSignature(w http.ResponseWriter, r *http.Request, secret string, window time.Duration) error {
+	ts, err := strconv.ParseInt(r.Header.Get("X-Relay-Timestamp"), 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	// 按秒比较整数，不用 time.Since：极大的 ts 会让 Duration 饱和成 MinInt64，取反后仍是负数，窗口检查就被绕过了
+	now, skew := time.Now().Unix(), int64(window/time.Second)
+	if ts < now-skew || ts > now+skew {
+		return errors.New("timestamp expired")
+	}
+
+	nonce := r.Header.Get("X-Relay-Nonce")
+	if nonce == "" || len(nonce) > pushSigningMaxNonceLen {
+		return errors.New("invalid nonce")
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, pushSigningMaxBody))
+	if err != nil {
+		return fmt.Errorf("read body failed: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10) + "\n" + nonce + "\n"))
+	mac.Write(body)
+	if !hmac.Equal([]byte(r.Header.Get("X-Relay-Signature")), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		return errors.New("invalid signature")
+	}
+
+	// 签名通过后再登记 nonce，避免伪造请求占满缓存；有效期覆盖整个时间窗口（前后各一个 skew）
+	if !rememberPushNonce(nonce, time.Unix(ts, 0).Add(window)) {
+		return errors.New("replayed nonce")
+	}
+	return nil
+}
+
+// rememberPushNonce 登记 nonce，已存在且未过期时返回 false
+func rememberPushNonce(nonce string, expiresAt time.Time) bool {
+	pushNoncesMu.Lock()
+	defer pushNoncesMu.Unlock()
+	if exp, ok := pushNonces[nonce]; ok && time.Now().Before(exp) {
+		return false
+	}
+	pushNonces[nonce] = expiresAt
+	return true
+}
+
+var pushNonceSweepOnce sync.Once
+
+// startPushNonceSweep 启动 nonce 清理（push_signing 和 hmac 认证共用一份缓存，只启动一次）
+func startPushNonceSweep() {
+	pushNonceSweepOnce.Do(func() { go pushNonceSweepLoop() })
+}
+
+// pushNonceSweepLoop 定期清理过期的 nonce
+func pushNonceSweepLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		pushNoncesMu.Lock()
+		for nonce, exp := range pushNonces {
+			if now.After(exp) {
+				delete(pushNonces, nonce)
+			}
+		}
+		pushNoncesMu.Unlock()
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ===== 投递回执 =====
+//
+// 开启 receipts 后，每条 /api/push、/v1/push（含延迟推送）都会分配 message_id，下发的消息带 "id" 字段，
+// 每个目标连接的投递结果（写出成功 / 失败、耗时）记一条回执；原生客户端收到后可以回
+//
+//	{"type":"ack","id":"msg_9f2c..."}
+//
+// 把该连接的回执标记为 acked。客服排查“这条通知到底有没有送到这个用户”时查
+// GET /api/messages/{id}/receipts?user_id=42，不用再翻日志。
+// 回执按 retention_seconds 和 max_messages 淘汰，配置 store_file 后定期落盘。
+// 目标用户不在线时消息仍有记录，只是没有回执。
+
+// ReceiptsConfig 投递回执配置
+type ReceiptsConfig struct {
+	Enabled          bool   `json:"enabled"`
+	RetentionSeconds int    `json:"retention_seconds"` // 回执保留时间，默认 86400
+	MaxMessages      int    `json:"max_messages"`      // 最多保留的消息数，默认 10000，超出时淘汰最早的
+	MaxPerMessage    int    `json:"max_per_message"`   // 每条消息最多记录的连接回执数，默认 1000，超出的只计数
+	StoreFile        string `json:"store_file"`        // 落盘文件（相对当前工作目录），留空只保存在内存
+}
+
+const (
+	receiptsDefaultRetention     = 86400
+	receiptsDefaultMaxMessages   = 10000
+	receiptsDefaultMaxPerMessage = 1000
+	receiptsSaveInterval         = 10 * time.Second
+
+	receiptDelivered = "delivered"
+	receiptFailed    = "failed"
+	receiptAcked     = "acked"
+)
+
+// deliveryReceipt 一个连接对一条消息的投递结果
+type deliveryReceipt struct {
+	ConnectionID string     `json:"connection_id"`
+	UserID       string     `json:"user_id,omitempty"`
+	Platform     string     `json:"platform,omitempty"`
+	Status       string     `json:"status"` // delivered / failed / acked
+	Error        string     `json:"error,omitempty"`
+	LatencyMs    int64      `json:"latency_ms"` // 从开始发送到写出完成
+	AckedAt      *time.Time `json:"acked_at,omitempty"`
+	AckLatencyMs int64      `json:"ack_latency_ms,omitempty"` // 从开始发送到收到 ack
+}
+
+// receiptCounts 按状态汇总，包括超出 max_per_message 没有单独记录的连接
+type receiptCounts struct {
+	Delivered int `json:"delivered"`
+	Failed    int `json:"failed"`
+	Acked     int `json:"acked"`
+}
+
+// messageReceipts 一条消息及其回执
+type messageReceipts struct {
+	MessageID    string            `json:"message_id"`
+	EventName    string            `json:"event_name"`
+	TargetUserID string            `json:"target_user_id,omitempty"`
+	Channel      string            `json:"channel,omitempty"`
+	Group        string            `json:"group,omitempty"`
+	Broadcast    bool              `json:"broadcast"`
+	SentAt       time.Time         `json:"sent_at"`
+	Counts       receiptCounts     `json:"counts"`
+	Receipts     []deliveryReceipt `json:"receipts"`
+
+	byConn map[string]int // 连接 ID → Receipts 下标
+}
+
+var (
+	receiptsMu    sync.Mutex
+	receipts      = make(map[string]*messageReceipts)
+	receiptsOrder []string // 按发送时间排列的 message_id，用于淘汰
+	receiptsDirty bool
+)
+
+func prepareReceipts(cfg *ReceiptsConfig) {
+	if cfg.RetentionSeconds <= 0 {
+		cfg.RetentionSeconds = receiptsDefaultRetention
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = receiptsDefaultMaxMessages
+	}
+	if cfg.MaxPerMessage <= 0 {
+		cfg.MaxPerMessage = receiptsDefaultMaxPerMessage
+	}
+}
+
+// newMessageID 分配消息 ID
+func newMessageID() string {
+	return "msg_" + randomHex(12)
+}
+
+// trackMessage 发送前登记一条消息，之后的投递结果按 message_id 记到它下面
+func trackMessage(p *preparedPush) {
+	m := &messageReceipts{
+		MessageID:    p.message.ID,
+		EventName:    p.body.EventName,
+		TargetUserID: p.target,
+		Channel:      p.body.Channel,
+		Group:        p.body.Group,
+		Broadcast:    p.broadcast(),
+		SentAt:       time.Now(),
+		Receipts:     []deliveryReceipt{},
+		byConn:       make(map[string]int),
+	}
+
+	receiptsMu.Lock()
+	defer receiptsMu.Unlock()
+	receipts[m.MessageID] = m
+	receiptsOrder = append(receiptsOrder, m.MessageID)
+	receiptsDirty = true
+	pruneReceiptsLocked(m.SentAt)
+}
+
+// recordReceipt 记录一个连接的投递结果；消息没有 ID（未开启回执或不是推送消息）时不做任何事
+func recordReceipt(msgID string, c *Client, err error) {
+	if msgID == "" {
+		return
+	}
+	receiptsMu.Lock()
+	defer receiptsMu.Unlock()
+
+	m, ok := receipts[msgID]
+	if !ok {
+		return
+	}
+	if err != nil {
+		m.Counts.Failed++
+	} else {
+		m.Counts.Delivered++
+	}
+	if len(m.Receipts) >= GlobalConfig.Receipts.MaxPerMessage {
+		return
+	}
+
+	rc := deliveryReceipt{
+		ConnectionID: c.id,
+		UserID:       c.userID,
+		Status:       receiptDelivered,
+		LatencyMs:    time.Since(m.SentAt).Milliseconds(),
+	}
+	if c.device != nil {
+		rc.Platform = c.device.Platform
+	}
+	if err != nil {
+		rc.Status = receiptFailed
+		rc.Error = err.Error()
+	}
+	m.byConn[c.id] = len(m.Receipts)
+	m.Receipts = append(m.Receipts, rc)
+	receiptsDirty = true
+}
+
+// ackReceipt 客户端确认收到消息；未知或已淘汰的 message_id 忽略
+func ackReceipt(c *Client, msgID string) {
+	receiptsMu.Lock()
+	defer receiptsMu.Unlock()
+
+	m, ok := receipts[msgID]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	i, ok := m.byConn[c.id]
+	if !ok {
+		// 超出 max_per_message 没有单独记录，或是重连后从历史补发的消息
+		if len(m.Receipts) >= GlobalConfig.Receipts.MaxPerMessage {
+			m.Counts.Acked++
+			receiptsDirty = true
+			return
+		}
+		i = len(m.Receipts)
+		m.byConn[c.id] = i
+		m.Receipts = append(m.Receipts, deliveryReceipt{ConnectionID: c.id, UserID: c.userID, Status: receiptDelivered})
+	}
+	rc := &m.Receipts[i]
+	if rc.Status == receiptAcked {
+		return
+	}
+	rc.Status = receiptAcked
+	rc.AckedAt = &now
+	rc.AckLatencyMs = now.Sub(m.SentAt).Milliseconds()
+	m.Counts.Acked++
+	receiptsDirty = true
+}
+
+// pruneReceiptsLocked 淘汰过期和超出数量上限的消息；调用方持有 receiptsMu
+func pruneReceiptsLocked(now time.Time) {
+	cfg := GlobalConfig.Receipts
+	cutoff := now.Add(-time.Duration(cfg.RetentionSeconds) * time.Second)
+
+	n := 0
+	for _, id := range receiptsOrder {
+		m, ok := receipts[id]
+		if !ok {
+			n++
+			continue
+		}
+		if len(receiptsOrder)-n <= cfg.MaxMessages && !m.SentAt.Before(cutoff) {
+			break
+		}
+		delete(receipts, id)
+		n++
+	}
+	if n > 0 {
+		receiptsOrder = append([]string(nil), receiptsOrder[n:]...)
+		receiptsDirty = true
+	}
+}
+
+// forgetUserReceipts 删除某用户的回执以及发给该用户的消息，返回删除的回执数
+func forgetUserReceipts(userID string) int {
+	receiptsMu.Lock()
+	defer receiptsMu.Unlock()
+
+	n := 0
+	for id, m := range receipts {
+		if m.TargetUserID == userID {
+			n += len(m.Receipts)
+			delete(receipts, id)
+			continue
+		}
+		kept := m.Receipts[:0]
+		for _, rc := range m.Receipts {
+			if rc.UserID == userID {
+				n++
+				continue
+			}
+			kept = append(kept, rc)
+		}
+		m.Receipts = kept
+		m.reindex()
+	}
+	if n > 0 {
+		receiptsDirty = true
+	}
+	return n
+}
+
+func (m *messageReceipts) reindex() {
+	m.byConn = make(map[string]int, len(m.Receipts))
+	for i, rc := range m.Receipts {
+		m.byConn[rc.ConnectionID] = i
+	}
+}
+
+// messageReceiptsHandler GET /api/messages/{id}/receipts?user_id=&status=
+func messageReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	q := r.URL.Query()
+	userID, status := q.Get("user_id"), q.Get("status")
+	switch status {
+	case "", receiptDelivered, receiptFailed, receiptAcked:
+	default:
+		writeValidationProblem(w, r, "status", "status must be delivered, failed or acked")
+		return
+	}
+
+	receiptsMu.Lock()
+	m, ok := receipts[id]
+	var out messageReceipts
+	if ok {
+		out = *m
+		out.Receipts = make([]deliveryReceipt, 0, len(m.Receipts))
+		for _, rc := range m.Receipts {
+			if (userID == "" || rc.UserID == userID) && (status == "" || rc.Status == status) {
+				out.Receipts = append(out.Receipts, rc)
+			}
+		}
+	}
+	receiptsMu.Unlock()
+
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "message not found or expired: "+id)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": out,
+	})
+}
+
+// ===== 落盘 =====
+
+func receiptsStorePath() string {
+	if GlobalConfig.Receipts.StoreFile == "" {
+		return ""
+	}
+	return filepath.Join(getCurrentDir(), GlobalConfig.Receipts.StoreFile)
+}
+
+// loadReceipts 启动时从文件恢复回执，已过期的丢弃
+func loadReceipts() {
+	path := receiptsStorePath()
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 读取投递回执失败 %s: %v\n", path, err)
+		}
+		return
+	}
+
+	var stored []*messageReceipts
+	if err := json.Unmarshal(data, &stored); err != nil {
+		log.Printf("⚠️ 解析投递回执失败 %s: %v\n", path, err)
+		return
+	}
+
+	receiptsMu.Lock()
+	defer receiptsMu.Unlock()
+	for _, m := range stored {
+		if m.Receipts == nil {
+			m.Receipts = []deliveryReceipt{}
+		}
+		m.reindex()
+		receipts[m.MessageID] = m
+		receiptsOrder = append(receiptsOrder, m.MessageID)
+	}
+	pruneReceiptsLocked(time.Now())
+	receiptsDirty = false
+	log.Printf("✅ 已恢复投递回执：%d 条消息\n", len(receipts))
+}
+
+// receiptsSaveLoop 定期淘汰过期回执，有变更且配置了 store_file 时落盘
+func receiptsSaveLoop() {
+	path := receiptsStorePath()
+	ticker := time.NewTicker(receiptsSaveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		receiptsMu.Lock()
+		pruneReceiptsLocked(time.Now())
+		if path == "" || !receiptsDirty {
+			receiptsMu.Unlock()
+			continue
+		}
+		// 回执条目在锁外会被继续修改，落盘用深拷贝
+		snapshot := make([]messageReceipts, 0, len(receiptsOrder))
+		for _, id := range receiptsOrder {
+			if m, ok := receipts[id]; ok {
+				cp := *m
+				cp.Receipts = append([]deliveryReceipt(nil), m.Receipts...)
+				snapshot = append(snapshot, cp)
+			}
+		}
+		receiptsDirty = false
+		receiptsMu.Unlock()
+
+		if err := writeFileAtomic(path, snapshot); err != nil {
+			log.Printf("❌ 投递回执落盘失败 %s: %v\n", path, err)
+		}
+	}
+}
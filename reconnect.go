@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 重连退避建议 =====
+//
+// 客户端的重连退避参数写死在客户端里，遇到重连风暴只能发版调整。开启后由中继统一下发建议值：
+//
+//	"reconnect_advice": { "enabled": true, "min_delay_ms": 1000, "max_delay_ms": 30000, "jitter": 0.5 }
+//
+// hello 的 data.reconnect 带完整参数；服务端主动关闭（连接回收、节点交接、认证过期、订阅流溢出等）时
+// 关闭原因后面追加一段 "; retry=1000-30000ms jitter=0.5"，没收到 hello 的客户端也能从关闭帧拿到。
+// 客户端按 min_delay_ms 起步指数退避，不超过 max_delay_ms，每次实际等待在计算值上随机浮动 ±jitter 比例。
+// 运行中可以通过 PUT /api/admin/reconnect-advice 调整（只保存在内存中），变更时向所有连接广播 reconnect_advice 事件。
+// 踢下线、封禁、要求升级、用户删除这类不应该自动重连的关闭不带建议。
+
+// ReconnectAdviceConfig 重连退避建议
+type ReconnectAdviceConfig struct {
+	Enabled    bool     `json:"enabled"`
+	MinDelayMs int      `json:"min_delay_ms"` // 首次重连前的等待，默认 1000
+	MaxDelayMs int      `json:"max_delay_ms"` // 退避上限，默认 30000
+	Jitter     *float64 `json:"jitter"`       // 随机浮动比例 0～1，不写时默认 0.5，写 0 表示不浮动
+}
+
+// advice 按配置生成建议值，没写 jitter 时用默认值
+func (cfg ReconnectAdviceConfig) advice() reconnectAdvice {
+	a := reconnectAdvice{MinDelayMs: cfg.MinDelayMs, MaxDelayMs: cfg.MaxDelayMs, Jitter: reconnectDefaultJitter}
+	if cfg.Jitter != nil {
+		a.Jitter = *cfg.Jitter
+	}
+	return a
+}
+
+const (
+	reconnectDefaultMinDelayMs = 1000
+	reconnectDefaultMaxDelayMs = 30000
+	reconnectDefaultJitter     = 0.5
+
+	// closeReasonMaxBytes 关闭帧的 reason 最长 123 字节（125 减去 2 字节状态码）
+	closeReasonMaxBytes = 123
+)
+
+// reconnectAdvice 下发给客户端的建议值
+type reconnectAdvice struct {
+	MinDelayMs int     `json:"min_delay_ms"`
+	MaxDelayMs int     `json:"max_delay_ms"`
+	Jitter     float64 `json:"jitter"`
+}
+
+// currentReconnect 当前生效的建议，nil 表示不下发
+var currentReconnect atomic.Pointer[reconnectAdvice]
+
+func prepareReconnectAdvice(cfg *ReconnectAdviceConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.MinDelayMs <= 0 {
+		cfg.MinDelayMs = reconnectDefaultMinDelayMs
+	}
+	if cfg.MaxDelayMs <= 0 {
+		cfg.MaxDelayMs = max(reconnectDefaultMaxDelayMs, cfg.MinDelayMs)
+	}
+	a := cfg.advice()
+	if _, err := a.validate(); err != nil {
+		log.Printf("⚠️ reconnect_advice 配置无效，已关闭: %v\n", err)
+		noteConfigProblem("reconnect_advice: " + err.Error())
+		cfg.Enabled = false
+		return
+	}
+	currentReconnect.Store(&a)
+}
+
+// validate 校验建议值，出错时同时返回出错的字段
+func (a reconnectAdvice) validate() (string, error) {
+	switch {
+	case a.MinDelayMs <= 0:
+		return "min_delay_ms", errors.New("min_delay_ms must be positive")
+	case a.MaxDelayMs < a.MinDelayMs:
+		return "max_delay_ms", errors.New("max_delay_ms must not be less than min_delay_ms")
+	case a.Jitter < 0 || a.Jitter > 1:
+		return "jitter", errors.New("jitter must be between 0 and 1")
+	}
+	return "", nil
+}
+
+// closeSuffix 追加到关闭原因后面的建议
+func (a *reconnectAdvice) closeSuffix() string {
+	return fmt.Sprintf("; retry=%d-%dms jitter=%s", a.MinDelayMs, a.MaxDelayMs, strconv.FormatFloat(a.Jitter, 'f', -1, 64))
+}
+
+// reconnectable 该关闭码是否期望客户端自动重连
+func reconnectable(code int) bool {
+	switch code {
+	case websocket.CloseGoingAway, websocket.CloseServiceRestart, websocket.CloseTryAgainLater,
+		CloseAuthExpired, CloseSessionExpired:
+		return true
+	}
+	return false
+}
+
+// closeReasonWithAdvice 服务端主动关闭时给关闭原因加上重连建议；没开启、不该重连或超出长度时原样返回
+func closeReasonWithAdvice(code int, reason string) string {
+	a := currentReconnect.Load()
+	if a == nil || !reconnectable(code) {
+		return reason
+	}
+	out := reason + a.closeSuffix()
+	if len(out) > closeReasonMaxBytes {
+		return reason
+	}
+	return out
+}
+
+// adminReconnectAdviceHandler GET 查询 / PUT 替换重连建议，PUT {"enabled": false} 停止下发
+func adminReconnectAdviceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var body ReconnectAdviceConfig
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+		var next *reconnectAdvice
+		if body.Enabled {
+			a := body.advice()
+			if field, err := a.validate(); err != nil {
+				writeValidationProblem(w, r, field, err.Error())
+				return
+			}
+			next = &a
+		}
+		currentReconnect.Store(next)
+		log.Println("🔁 重连建议更新:", toJSON(next))
+		broadcastToAll(WSMessage{Event: "reconnect_advice", Data: next})
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": currentReconnect.Load(),
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ===== 敏感字段脱敏 =====
+//
+// redaction.fields 配置的字段路径（点分隔，如 subject.email、token）在写日志、进入流量总线
+// （firehose / gRPC 订阅 / 归档）之前替换成 [REDACTED]，下发给客户端的消息不受影响。
+// 推送请求体和下发的 data 都是 {subject, token, ...} 结构，同一套路径对两者都适用。
+// 列表里包含 token 时，连接参数 / identify 里的 token 在日志中也只保留前后几位；
+// 没开 client_jwt 时 user_id 就是 token，日志和 firehose / gRPC 订阅里的 user_id 同样处理。
+
+// RedactionConfig 日志与旁路输出的脱敏规则
+type RedactionConfig struct {
+	Fields []string `json:"fields"` // 字段路径，点分隔；路径上遇到数组会逐个元素处理
+}
+
+const redactedValue = "[REDACTED]"
+
+// redactLog 按 redaction.fields 脱敏后序列化，用于日志
+func redactLog(v interface{}) string {
+	return toJSON(redactPaths(v, GlobalConfig.Redaction.Fields))
+}
+
+// redactTraffic 流量总线上的 data 脱敏
+func redactTraffic(data interface{}) interface{} {
+	return redactPaths(data, GlobalConfig.Redaction.Fields)
+}
+
+// logToken 日志里的连接 token：redaction.fields 含 token 时只保留前后 4 位
+func logToken(token string) string {
+	return logTokenFor(&GlobalConfig, token)
+}
+
+// logTokenFor 同 logToken，按指定的配置（Hub 用自己的配置）
+func logTokenFor(cfg *Config, token string) string {
+	for _, f := range cfg.Redaction.Fields {
+		if f != "token" {
+			continue
+		}
+		if len(token) <= 12 {
+			return fmt.Sprintf("%s(len=%d)", redactedValue, len(token))
+		}
+		return fmt.Sprintf("%s…%s(len=%d)", token[:4], token[len(token)-4:], len(token))
+	}
+	return token
+}
+
+// logUserID 日志和流量总线里的 user_id：没开 client_jwt 时 user_id 就是客户端 token 本身，按 logToken 处理；
+// 开了 client_jwt 时 user_id 来自声明，不是凭据，原样输出
+func logUserID(userID string) string {
+	return logUserIDFor(&GlobalConfig, userID)
+}
+
+// logUserIDFor 同 logUserID，按指定的配置
+func logUserIDFor(cfg *Config, userID string) string {
+	if cfg.ClientJWT.Enabled {
+		return userID
+	}
+	return logTokenFor(cfg, userID)
+}
+
+// redactPaths 把 data 中指定路径（点分隔）的字段替换成 [REDACTED]，数组会逐个元素处理。
+// 返回脱敏后的副本，不修改原对象；没有需要处理的路径时原样返回。
+func redactPaths(data interface{}, paths []string) interface{} {
+	if len(paths) == 0 || data == nil {
+		return data
+	}
+	raw, ok := data.(json.RawMessage)
+	if !ok {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return data
+		}
+		raw = b
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return data
+	}
+	for _, p := range paths {
+		redactPath(v, strings.Split(p, "."))
+	}
+	return v
+}
+
+func redactPath(v interface{}, keys []string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		child, ok := t[keys[0]]
+		if !ok {
+			return
+		}
+		if len(keys) == 1 {
+			t[keys[0]] = redactedValue
+			return
+		}
+		redactPath(child, keys[1:])
+	case []interface{}:
+		for _, e := range t {
+			redactPath(e, keys)
+		}
+	}
+}
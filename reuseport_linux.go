@@ -0,0 +1,6 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package main
+
+// 标准库 syscall 在 Linux 上没有导出 SO_REUSEPORT（内核 3.9 才加入），这里直接写值
+const soReusePort = 0xf
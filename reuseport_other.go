@@ -0,0 +1,13 @@
+//go:build !((linux && !(mips || mipsle || mips64 || mips64le)) || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortControl 当前平台不支持 SO_REUSEPORT
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("reuse_port 在当前平台不受支持")
+}
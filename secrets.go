@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== 配置中的密钥引用 =====
+//
+// api_key 等敏感字段可以写成引用，加载配置时解析，明文不落在 config.json 里：
+//
+//	"env://RELAY_KEY"               读取环境变量
+//	"file:///run/secrets/relay_key" 读取文件内容（去掉末尾换行）
+//	"exec://cat /run/key"           执行命令（sh -c），取标准输出
+//
+// 不带 scheme 的值按明文处理。新的来源在 secretResolvers 里注册对应 scheme 即可。
+//
+// 引用会被记录下来，refreshSecrets 定期重新解析，值变化时热更新到运行中的服务（读取方用 liveSecret）。
+
+const secretExecTimeout = 10 * time.Second
+
+// secretResolvers scheme -> 解析函数，参数为 "scheme://" 之后的部分
+var secretResolvers = map[string]func(ref string) (string, error){
+	"env":   resolveEnvSecret,
+	"file":  resolveFileSecret,
+	"exec":  resolveExecSecret,
+	"vault": resolveVaultSecret,       // 见 vault.go
+	"awssm": resolveAWSSecretsManager, // 见 aws.go
+	"ssm":   resolveAWSParameter,
+}
+
+var (
+	secretRefs  = make(map[string]string) // 字段名 -> 引用，只记录写成引用的字段
+	liveSecrets = make(map[string]string) // 字段名 -> 当前生效的值（引用解析结果）
+	secretsMu   sync.RWMutex
+)
+
+// secretsRefreshLoop 按固定间隔刷新密钥引用
+func secretsRefreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		refreshSecrets()
+	}
+}
+
+// secretField 配置中一个需要解析的密钥字段
+type secretField struct {
+	name string
+	ptr  *string
+}
+
+// configSecretFields 列出配置中所有允许写成引用的字段
+func configSecretFields(cfg *Config) []secretField {
+	fields := []secretField{
+		{"api_key", &cfg.APIKey},
+		{"pusher.secret", &cfg.Pusher.Secret},
+		{"identity_cookie.secret", &cfg.IdentityCookie.Secret},
+		{"signing.private_key", &cfg.Signing.PrivateKey},
+		{"push_signing.secret", &cfg.PushSigning.Secret},
+		{"oidc.client_secret", &cfg.OIDC.ClientSecret},
+		{"client_jwt.client_secret", &cfg.ClientJWT.ClientSecret},
+		{"cluster.secret", &cfg.Cluster.Secret},
+		{"archive.s3.secret_key", &cfg.Archive.S3.SecretKey},
+		{"admission.secret", &cfg.Admission.Secret},
+		{"initial_data.secret", &cfg.InitialData.Secret},
+		{"occupancy.secret", &cfg.Occupancy.Secret},
+		{"expiry.callback_secret", &cfg.Expiry.CallbackSecret},
+		{"aggregation.secret", &cfg.Aggregation.Secret},
+		{"push_callbacks.secret", &cfg.PushCallbacks.Secret},
+		// 写成引用时解析出的是 PEM 内容而不是文件路径，见 server.go
+		{"server.tls_cert", &cfg.Server.TLSCert},
+		{"server.tls_key", &cfg.Server.TLSKey},
+	}
+	// 认证链里的密钥按位置命名（auth.push[0].secret 等），认证时用同样的名字取 liveSecret
+	for _, section := range []struct {
+		name string
+		cfgs []AuthProviderConfig
+	}{{"auth.push", cfg.Auth.Push}, {"auth.admin", cfg.Auth.Admin}, {"auth.firehose", cfg.Auth.Firehose}} {
+		for i := range section.cfgs {
+			p := &section.cfgs[i]
+			prefix := fmt.Sprintf("%s[%d]", section.name, i)
+			for j := range p.Keys {
+				fields = append(fields, secretField{fmt.Sprintf("%s.keys[%d]", prefix, j), &p.Keys[j]})
+			}
+			fields = append(fields, secretField{prefix + ".secret", &p.Secret}, secretField{prefix + ".public_key", &p.PublicKey})
+		}
+	}
+	return fields
+}
+
+// resolveConfigSecrets 就地解析配置中的密钥引用，任何一个解析失败都返回错误
+func resolveConfigSecrets(cfg *Config) error {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, f := range configSecretFields(cfg) {
+		ref := *f.ptr
+		val, resolved, err := resolveSecret(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		if resolved {
+			*f.ptr = val
+			secretRefs[f.name] = ref
+			liveSecrets[f.name] = val
+			log.Printf("🔑 已解析密钥引用 %s\n", f.name)
+		}
+	}
+	return nil
+}
+
+// liveSecret 返回密钥字段当前生效的值：引用轮换后取最新值，否则取配置里的 fallback
+func liveSecret(name, fallback string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	if v, ok := liveSecrets[name]; ok {
+		return v
+	}
+	return fallback
+}
+
+// isSecretRef 字段在配置里是否写成了引用
+func isSecretRef(name string) bool {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	_, ok := secretRefs[name]
+	return ok
+}
+
+// refreshSecrets 重新解析所有引用，值有变化的热更新；单个失败只打日志，继续使用旧值
+func refreshSecrets() {
+	secretsMu.RLock()
+	refs := make(map[string]string, len(secretRefs))
+	for name, ref := range secretRefs {
+		refs[name] = ref
+	}
+	secretsMu.RUnlock()
+
+	for name, ref := range refs {
+		val, _, err := resolveSecret(ref)
+		if err != nil {
+			log.Printf("⚠️ 密钥引用 %s 刷新失败，继续使用旧值: %v\n", name, err)
+			continue
+		}
+		secretsMu.Lock()
+		if liveSecrets[name] != val {
+			liveSecrets[name] = val
+			log.Printf("🔄 密钥 %s 已轮换（指纹 %s）\n", name, keyFingerprint(val))
+		}
+		secretsMu.Unlock()
+	}
+}
+
+// resolveSecret 解析单个值；第二个返回值表示它是否是引用
+func resolveSecret(value string) (string, bool, error) {
+	scheme, ref, ok := strings.Cut(value, "://")
+	if !ok {
+		return value, false, nil
+	}
+	resolver, ok := secretResolvers[scheme]
+	if !ok {
+		return value, false, nil
+	}
+	secret, err := resolver(ref)
+	if err != nil {
+		return "", true, err
+	}
+	if secret == "" {
+		return "", true, fmt.Errorf("%s:// 引用解析结果为空", scheme)
+	}
+	return secret, true, nil
+}
+
+func resolveEnvSecret(name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("环境变量 %s 未设置", name)
+	}
+	return v, nil
+}
+
+func resolveFileSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func resolveExecSecret(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretExecTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "sh", "-c", command).Output()
+	if err != nil {
+		return "", fmt.Errorf("执行命令失败: %w", err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== relay selftest：端到端自检 =====
+//
+// 不带 -url 时在本进程里用默认配置起一个监听 127.0.0.1 随机端口的 relay（不读写 config.json），
+// 带 -url 时对已部署的实例做冒烟测试。两种模式跑同一组检查：
+// 连接 + identify → 单用户推送 → 广播 → 延迟推送 → 断线重连。
+// 每项输出 PASS / FAIL，全部通过退出码为 0，可直接用于 CI 或发布后的检查。
+// 对线上实例做检查时用的是随机生成的用户 ID，广播默认跳过（会发给所有在线用户），需要时加 -broadcast。
+
+const (
+	selftestEvent      = "selftest"
+	selftestQuietWait  = 300 * time.Millisecond // 断言「收不到」时等待的时间
+	selftestDelaySecs  = 1
+	selftestReadBuffer = 64
+)
+
+// selftestOptions 命令行参数
+type selftestOptions struct {
+	baseURL   string
+	apiKey    string
+	wsPath    string
+	pushPath  string
+	query     string
+	timeout   time.Duration
+	broadcast bool
+}
+
+// selftestCheck 一项检查
+type selftestCheck struct {
+	name string
+	run  func() error
+}
+
+func runSelftest(args []string) int {
+	opts := selftestOptions{}
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	fs.StringVar(&opts.baseURL, "url", "", "被测实例地址，如 https://relay.example.com；为空时在本进程内启动一个临时实例")
+	fs.StringVar(&opts.apiKey, "api-key", os.Getenv("RELAY_API_KEY"), "推送接口的 API Key（-url 模式必填）")
+	fs.StringVar(&opts.wsPath, "ws-path", "/ws", "WebSocket 路径（-url 模式）")
+	fs.StringVar(&opts.pushPath, "push-path", "/api/push", "推送接口路径（-url 模式）")
+	fs.StringVar(&opts.query, "query", "", "连接 WebSocket 时额外带的查询参数，如 app_version=3.0")
+	fs.DurationVar(&opts.timeout, "timeout", 5*time.Second, "每项检查等待消息的超时时间")
+	fs.BoolVar(&opts.broadcast, "broadcast", false, "-url 模式下也执行广播检查（会推给所有在线连接）")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if opts.baseURL == "" {
+		stop, err := startSelftestServer(&opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "❌ 启动临时实例失败:", err)
+			return 1
+		}
+		defer stop()
+		opts.broadcast = true
+	} else if opts.apiKey == "" {
+		fmt.Fprintln(os.Stderr, "❌ -url 模式需要 -api-key（或环境变量 RELAY_API_KEY）")
+		return 2
+	}
+	opts.baseURL = strings.TrimRight(opts.baseURL, "/")
+
+	st := &selftestRun{opts: opts, userA: "selftest-a-" + randomHex(4), userB: "selftest-b-" + randomHex(4)}
+	defer st.closeAll()
+
+	checks := []selftestCheck{
+		{"connect + identify", st.connect},
+		{"single-user push", st.userPush},
+		{"broadcast", st.broadcastPush},
+		{"delayed push", st.delayedPush},
+		{"reconnect", st.reconnect},
+	}
+
+	fmt.Printf("🧪 relay selftest → %s\n", opts.baseURL)
+	failed := 0
+	for _, check := range checks {
+		start := time.Now()
+		err := check.run()
+		took := time.Since(start).Round(time.Millisecond)
+		switch {
+		case errors.Is(err, errSelftestSkipped):
+			fmt.Printf("⏭️  SKIP %s\n", check.name)
+		case err != nil:
+			failed++
+			fmt.Printf("❌ FAIL %s (%s): %v\n", check.name, took, err)
+		default:
+			fmt.Printf("✅ PASS %s (%s)\n", check.name, took)
+		}
+		// 连接建立失败时后面的检查都没有意义
+		if err != nil && check.name == "connect + identify" {
+			break
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("💥 %d 项检查失败\n", failed)
+		return 1
+	}
+	fmt.Println("🎉 全部检查通过")
+	return 0
+}
+
+var errSelftestSkipped = errors.New("skipped")
+
+// startSelftestServer 用默认配置在随机端口启动一个临时实例，填好 opts 中的地址和密钥
+func startSelftestServer(opts *selftestOptions) (func(), error) {
+	defaults := getDefaultConfig()
+	GlobalConfig = defaults
+	GlobalConfig.APIKey = randomHex(16)
+	postProcessConfig(defaults)
+
+	// 临时实例的逐条日志对自检结果没有意义，只保留检查输出
+	log.SetOutput(io.Discard)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := newHTTPServer(newMux())
+	go func() { _ = srv.Serve(ln) }()
+
+	opts.baseURL = "http://" + ln.Addr().String()
+	opts.apiKey = GlobalConfig.APIKey
+	opts.wsPath = GlobalConfig.WSPath
+	opts.pushPath = GlobalConfig.PushPath
+	return func() { _ = srv.Close() }, nil
+}
+
+// ===== 真实连接的检查 =====
+
+type selftestRun struct {
+	opts         selftestOptions
+	userA, userB string
+
+	a1, a2, b *selftestClient
+}
+
+// selftestClient 一个真实的 WebSocket 客户端，读循环把收到的消息放进 channel，
+// 这样「一段时间内收不到消息」的断言不需要设置读超时（gorilla 的连接读超时后就不能再用了）
+type selftestClient struct {
+	conn  *websocket.Conn
+	msgs  chan WSMessage
+	pongs chan struct{}
+	done  chan struct{}
+}
+
+func (st *selftestRun) dial(token string) (*selftestClient, error) {
+	u, err := url.Parse(st.opts.baseURL + st.opts.wsPath)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	q, _ := url.ParseQuery(st.opts.query)
+	if token != "" {
+		q.Set("token", token)
+	}
+	u.RawQuery = q.Encode()
+
+	dialer := websocket.Dialer{HandshakeTimeout: st.opts.timeout}
+	conn, resp, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("连接 %s 失败: %v（HTTP %d）", u.Redacted(), err, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("连接 %s 失败: %v", u.Redacted(), err)
+	}
+
+	c := &selftestClient{
+		conn:  conn,
+		msgs:  make(chan WSMessage, selftestReadBuffer),
+		pongs: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(c.done)
+		for {
+			_, raw, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var pong PingMessage
+			if json.Unmarshal(raw, &pong) == nil && pong.Type == "pong" {
+				select {
+				case c.pongs <- struct{}{}:
+				default:
+				}
+				continue
+			}
+			var msg WSMessage
+			if json.Unmarshal(raw, &msg) == nil && msg.Event != "" {
+				select {
+				case c.msgs <- msg:
+				default:
+				}
+			}
+		}
+	}()
+	return c, nil
+}
+
+// expect 等待指定事件，期间的其它事件（hello 等）直接丢弃
+func (c *selftestClient) expect(event string, timeout time.Duration) (WSMessage, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-c.msgs:
+			if msg.Event == event {
+				return msg, nil
+			}
+		case <-c.done:
+			return WSMessage{}, fmt.Errorf("等待 %s 时连接已断开", event)
+		case <-deadline:
+			return WSMessage{}, fmt.Errorf("%s 内没有收到 %s", timeout, event)
+		}
+	}
+}
+
+// expectNone 在 wait 时间内不应收到指定事件
+func (c *selftestClient) expectNone(event string, wait time.Duration) error {
+	if msg, err := c.expect(event, wait); err == nil {
+		return fmt.Errorf("不应收到 %s，实际收到 %s", event, toJSON(msg.Data))
+	}
+	return nil
+}
+
+// sync 发一个 ping 等 pong：服务端按顺序处理上行消息，收到 pong 说明之前的 identify 已经处理完
+func (c *selftestClient) sync(timeout time.Duration) error {
+	if err := c.conn.WriteJSON(PingMessage{Type: "ping", Ts: time.Now().UnixMilli()}); err != nil {
+		return err
+	}
+	select {
+	case <-c.pongs:
+		return nil
+	case <-c.done:
+		return errors.New("等待 pong 时连接已断开")
+	case <-time.After(timeout):
+		return fmt.Errorf("%s 内没有收到 pong", timeout)
+	}
+}
+
+func (st *selftestRun) closeAll() {
+	for _, c := range []*selftestClient{st.a1, st.a2, st.b} {
+		if c != nil {
+			_ = c.conn.Close()
+		}
+	}
+}
+
+// push 调用推送接口，检查返回 code=0
+func (st *selftestRun) push(body map[string]interface{}) error {
+	raw, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, st.opts.baseURL+st.opts.pushPath, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-KEY", st.opts.apiKey)
+
+	client := http.Client{Timeout: st.opts.timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 成功是 {code:0,...}，失败是 problem+json（code 为字符串错误码）
+	var result struct {
+		Code   json.RawMessage `json:"code"`
+		Detail string          `json:"detail"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("推送接口返回 HTTP %d，响应无法解析: %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || string(result.Code) != "0" {
+		return fmt.Errorf("推送接口返回 HTTP %d: %s %s", resp.StatusCode, result.Code, result.Detail)
+	}
+	return nil
+}
+
+// connect a1 通过 identify 消息注册，a2 / b 通过 ?token= 注册
+func (st *selftestRun) connect() error {
+	var err error
+	if st.a1, err = st.dial(""); err != nil {
+		return err
+	}
+	if err := st.a1.conn.WriteJSON(WSMessage{Event: "identify", Data: IdentifyData{Token: st.userA}}); err != nil {
+		return err
+	}
+	if st.a2, err = st.dial(st.userA); err != nil {
+		return err
+	}
+	if st.b, err = st.dial(st.userB); err != nil {
+		return err
+	}
+	for _, c := range []*selftestClient{st.a1, st.a2, st.b} {
+		if err := c.sync(st.opts.timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userPush 推给用户 a：a 的两个连接都收到，b 收不到
+func (st *selftestRun) userPush() error {
+	if err := st.push(map[string]interface{}{"event_name": selftestEvent + ".user", "token": st.userA}); err != nil {
+		return err
+	}
+	for _, c := range []*selftestClient{st.a1, st.a2} {
+		if _, err := c.expect(selftestEvent+".user", st.opts.timeout); err != nil {
+			return err
+		}
+	}
+	return st.b.expectNone(selftestEvent+".user", selftestQuietWait)
+}
+
+// broadcastPush 广播：所有连接都收到
+func (st *selftestRun) broadcastPush() error {
+	if !st.opts.broadcast {
+		return errSelftestSkipped
+	}
+	if err := st.push(map[string]interface{}{"event_name": selftestEvent + ".broadcast"}); err != nil {
+		return err
+	}
+	for _, c := range []*selftestClient{st.a1, st.a2, st.b} {
+		if _, err := c.expect(selftestEvent+".broadcast", st.opts.timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delayedPush 延迟推送：到点之前收不到，到点之后收到
+func (st *selftestRun) delayedPush() error {
+	start := time.Now()
+	if err := st.push(map[string]interface{}{
+		"event_name":    selftestEvent + ".delayed",
+		"token":         st.userB,
+		"delay_seconds": selftestDelaySecs,
+	}); err != nil {
+		return err
+	}
+	if err := st.b.expectNone(selftestEvent+".delayed", selftestQuietWait); err != nil {
+		return fmt.Errorf("延迟推送提前到达: %w", err)
+	}
+	if _, err := st.b.expect(selftestEvent+".delayed", st.opts.timeout+selftestDelaySecs*time.Second); err != nil {
+		return err
+	}
+	// 留一点余量，服务端计时和这里的计时起点不完全一致
+	if took := time.Since(start); took < selftestDelaySecs*time.Second-100*time.Millisecond {
+		return fmt.Errorf("延迟 %ds 的推送 %s 后就到达了", selftestDelaySecs, took.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// reconnect a1 断开后用同一个 token 重连，新连接能收到推送，旧连接不影响投递
+func (st *selftestRun) reconnect() error {
+	_ = st.a1.conn.Close()
+	<-st.a1.done
+
+	var err error
+	if st.a1, err = st.dial(st.userA); err != nil {
+		return err
+	}
+	if err := st.a1.sync(st.opts.timeout); err != nil {
+		return err
+	}
+	if err := st.push(map[string]interface{}{"event_name": selftestEvent + ".reconnect", "token": st.userA}); err != nil {
+		return err
+	}
+	for _, c := range []*selftestClient{st.a1, st.a2} {
+		if _, err := c.expect(selftestEvent+".reconnect", st.opts.timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
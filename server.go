@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ===== HTTP 服务与监听 =====
+//
+// 默认行为与以前一样：单个 HTTP/1.1 监听。可选项：
+//   - tls_cert / tls_key：启用 HTTPS，REST 接口自动走 HTTP/2（WebSocket 升级仍走 HTTP/1.1）；
+//     可以是文件路径，也可以写成密钥引用（vault:// 等），引用解析出 PEM 内容，轮换后新握手用新证书
+//   - h2c：明文 HTTP/2（prior knowledge），给内网服务调用 push / admin 接口用
+//   - reuse_port + listeners：用 SO_REUSEPORT 在同一端口上开多个监听，
+//     既能在一个进程里开多个 accept 循环，也能同一台机器跑多个进程共享端口
+//   - 各类超时和 max_header_bytes
+
+// ServerConfig HTTP 服务配置
+type ServerConfig struct {
+	TLSCert string `json:"tls_cert"` // 证书文件路径或密钥引用（PEM），和 tls_key 一起配置时启用 HTTPS + HTTP/2
+	TLSKey  string `json:"tls_key"`  // 私钥文件路径或密钥引用（PEM）
+	H2C     bool   `json:"h2c"`      // 明文端口同时接受 HTTP/2（prior knowledge）
+
+	ReusePort bool `json:"reuse_port"` // 监听时设置 SO_REUSEPORT
+	Listeners int  `json:"listeners"`  // reuse_port 时本进程开几个监听，默认 1
+
+	MaxHeaderBytes           int `json:"max_header_bytes"`            // 默认 1MB（net/http 默认值）
+	ReadHeaderTimeoutSeconds int `json:"read_header_timeout_seconds"` // 默认 10
+	ReadTimeoutSeconds       int `json:"read_timeout_seconds"`        // 默认 0（不限制）
+	WriteTimeoutSeconds      int `json:"write_timeout_seconds"`       // 默认 0（不限制），对已升级的 WebSocket 无影响
+	IdleTimeoutSeconds       int `json:"idle_timeout_seconds"`        // keep-alive 空闲超时，默认 120
+}
+
+const (
+	serverDefaultReadHeaderTimeout = 10
+	serverDefaultIdleTimeout       = 120
+)
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// newHTTPServer 按配置构造 http.Server
+func newHTTPServer(handler http.Handler) *http.Server {
+	cfg := GlobalConfig.Server
+	if cfg.ReadHeaderTimeoutSeconds <= 0 {
+		cfg.ReadHeaderTimeoutSeconds = serverDefaultReadHeaderTimeout
+	}
+	if cfg.IdleTimeoutSeconds <= 0 {
+		cfg.IdleTimeoutSeconds = serverDefaultIdleTimeout
+	}
+
+	if GlobalConfig.Bans.Enabled {
+		handler = banGuard(handler)
+	}
+	server := &http.Server{
+		Handler:           handler,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ReadHeaderTimeout: seconds(cfg.ReadHeaderTimeoutSeconds),
+		ReadTimeout:       seconds(cfg.ReadTimeoutSeconds),
+		WriteTimeout:      seconds(cfg.WriteTimeoutSeconds),
+		IdleTimeout:       seconds(cfg.IdleTimeoutSeconds),
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	if cfg.TLSCert != "" {
+		protocols.SetHTTP2(true)
+	}
+	if cfg.H2C {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	server.Protocols = protocols
+
+	if GlobalConfig.ProxyProtocol.Enabled {
+		server.ConnContext = proxyConnContext
+	}
+	return server
+}
+
+// listen 按配置创建监听（含 SO_REUSEPORT 和 PROXY protocol 包装）
+func listen(addr string) ([]net.Listener, error) {
+	cfg := GlobalConfig.Server
+	count := 1
+	lc := net.ListenConfig{}
+	if cfg.ReusePort {
+		lc.Control = reusePortControl
+		if cfg.Listeners > 1 {
+			count = cfg.Listeners
+		}
+	}
+
+	var listeners []net.Listener
+	for i := 0; i < count; i++ {
+		ln, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		if GlobalConfig.ProxyProtocol.Enabled {
+			pl, err := newProxyListener(ln, GlobalConfig.ProxyProtocol)
+			if err != nil {
+				ln.Close()
+				return nil, err
+			}
+			ln = pl
+		} else if GlobalConfig.Bans.Enabled {
+			ln = banListener{ln}
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// serve 在所有监听上启动服务，任何一个退出都返回错误
+func serve(server *http.Server, listeners []net.Listener) error {
+	cfg := GlobalConfig.Server
+	useTLS := cfg.TLSCert != "" && cfg.TLSKey != ""
+	if useTLS {
+		certs, err := newCertLoader(cfg)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate}
+	}
+
+	errCh := make(chan error, len(listeners))
+	var wg sync.WaitGroup
+	for _, ln := range listeners {
+		wg.Add(1)
+		go func(ln net.Listener) {
+			defer wg.Done()
+			if useTLS {
+				errCh <- server.ServeTLS(ln, "", "")
+			} else {
+				errCh <- server.Serve(ln)
+			}
+		}(ln)
+	}
+
+	err := <-errCh
+	_ = server.Close()
+	wg.Wait()
+	return err
+}
+
+// certLoader 提供 HTTPS 证书。tls_cert / tls_key 是文件路径时启动时读一次（和以前一样，换证书要重启）；
+// 写成密钥引用时每次握手取 liveSecret 的当前值，refreshSecrets 轮换后重新解析，新握手即用新证书
+type certLoader struct {
+	certFile, keyFile string // 文件路径配置时读到的 PEM，引用配置时为空
+
+	mu      sync.Mutex
+	certPEM string
+	keyPEM  string
+	cert    *tls.Certificate
+	bad     string // 最近一次加载失败的证书 + 私钥，同一组值不再反复解析和打日志
+}
+
+func newCertLoader(cfg ServerConfig) (*certLoader, error) {
+	l := &certLoader{}
+	for _, f := range []struct {
+		name, value string
+		pem         *string
+	}{{"server.tls_cert", cfg.TLSCert, &l.certFile}, {"server.tls_key", cfg.TLSKey, &l.keyFile}} {
+		if isSecretRef(f.name) {
+			continue
+		}
+		data, err := os.ReadFile(f.value)
+		if err != nil {
+			return nil, err
+		}
+		*f.pem = string(data)
+	}
+	// 启动时先加载一次，证书有问题直接退出
+	if _, err := l.getCertificate(nil); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// getCertificate 用作 tls.Config.GetCertificate
+func (l *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certPEM := liveSecret("server.tls_cert", l.certFile)
+	keyPEM := liveSecret("server.tls_key", l.keyFile)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cert != nil && (certPEM == l.certPEM && keyPEM == l.keyPEM || certPEM+keyPEM == l.bad) {
+		return l.cert, nil
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		if l.cert == nil {
+			return nil, errors.New("加载 TLS 证书失败: " + err.Error())
+		}
+		// 证书和私钥分两次刷新，中间可能短暂不匹配，继续用旧证书，等下一次刷新
+		l.bad = certPEM + keyPEM
+		log.Printf("⚠️ 轮换后的 TLS 证书无法加载，继续使用旧证书: %v\n", err)
+		return l.cert, nil
+	}
+	l.certPEM, l.keyPEM, l.cert = certPEM, keyPEM, &cert
+	log.Println("🔐 已加载 TLS 证书")
+	return l.cert, nil
+}
+
+// logServerSetup 打印监听相关的启动信息
+func logServerSetup(listeners int) {
+	cfg := GlobalConfig.Server
+	if cfg.TLSCert != "" {
+		log.Println("✅ HTTPS 已启用（REST 接口支持 HTTP/2）")
+	}
+	if cfg.H2C {
+		log.Println("✅ 明文 HTTP/2 (h2c) 已启用")
+	}
+	if cfg.ReusePort {
+		log.Printf("✅ SO_REUSEPORT 已启用，本进程监听数 %d\n", listeners)
+	}
+	if GlobalConfig.ProxyProtocol.Enabled {
+		log.Printf("✅ PROXY protocol 已启用，可信地址段 %v\n", GlobalConfig.ProxyProtocol.TrustedCIDRs)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCertPEM 生成一张自签名证书，返回 PEM 格式的证书和私钥
+func testCertPEM(t *testing.T, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func certName(t *testing.T, l *certLoader) string {
+	t.Helper()
+	cert, err := l.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestTLSCertFromSecretRefRotates(t *testing.T) {
+	t.Cleanup(func() {
+		secretsMu.Lock()
+		for _, name := range []string{"server.tls_cert", "server.tls_key"} {
+			delete(secretRefs, name)
+			delete(liveSecrets, name)
+		}
+		secretsMu.Unlock()
+	})
+	certV1, keyV1 := testCertPEM(t, "v1")
+	t.Setenv("RELAY_TEST_TLS_CERT", certV1)
+	t.Setenv("RELAY_TEST_TLS_KEY", keyV1)
+
+	cfg := getDefaultConfig()
+	cfg.Server.TLSCert, cfg.Server.TLSKey = "env://RELAY_TEST_TLS_CERT", "env://RELAY_TEST_TLS_KEY"
+	if err := resolveConfigSecrets(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	l, err := newCertLoader(cfg.Server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := certName(t, l); name != "v1" {
+		t.Fatalf("证书 = %s, want v1", name)
+	}
+
+	// 只轮换了证书、私钥还没跟上时继续用旧证书
+	certV2, keyV2 := testCertPEM(t, "v2")
+	t.Setenv("RELAY_TEST_TLS_CERT", certV2)
+	refreshSecrets()
+	if name := certName(t, l); name != "v1" {
+		t.Fatalf("证书和私钥不匹配时 = %s, want 旧证书 v1", name)
+	}
+
+	t.Setenv("RELAY_TEST_TLS_KEY", keyV2)
+	refreshSecrets()
+	if name := certName(t, l); name != "v2" {
+		t.Fatalf("轮换后证书 = %s, want v2", name)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// ===== 单会话策略（同一用户重复 identify） =====
+//
+// 默认同一用户可以同时有多个连接（多端 / 多标签页）。要求“同一时间只能一处登录”的应用可以配置
+// single_session.policy：
+//   - allow（默认）：都保留
+//   - kick_older：新连接 identify 成功后，旧连接收到 logged_in_elsewhere 事件并以 4409 断开
+//   - reject_new：已有在线连接时拒绝新连接的 identify（error 事件 code=session_conflict），旧连接不受影响
+//
+// 策略作用于所有协议的 identify（原生 / SSE / Pusher / Phoenix / SignalR / Centrifugo），
+// 匿名访客分组、连接迁移和断线续接恢复的会话不参与。
+
+// SingleSessionConfig 同一用户重复 identify 时的处理策略
+type SingleSessionConfig struct {
+	Policy string `json:"policy"` // allow（默认）/ kick_older / reject_new
+}
+
+const (
+	sessionPolicyAllow     = "allow"
+	sessionPolicyKickOlder = "kick_older"
+	sessionPolicyRejectNew = "reject_new"
+)
+
+// CloseSessionReplaced 被同一用户的新连接顶掉时的关闭码（对应 HTTP 409）
+const CloseSessionReplaced = 4409
+
+// errSessionConflict reject_new 策略下用户已有在线连接
+var errSessionConflict = errors.New("user already has an active session")
+
+func prepareSingleSession(cfg *SingleSessionConfig) {
+	switch cfg.Policy {
+	case "":
+		cfg.Policy = sessionPolicyAllow
+	case sessionPolicyAllow, sessionPolicyKickOlder, sessionPolicyRejectNew:
+	default:
+		log.Fatalf("❌ single_session.policy 不支持: %s（可选 allow / kick_older / reject_new）\n", cfg.Policy)
+	}
+}
+
+// otherSessions 用户在本节点上除 c 以外的连接
+func otherSessions(c *Client, userID string) []*Client {
+	var out []*Client
+	for _, other := range defaultHub.userConns(userID) {
+		if other != c {
+			out = append(out, other)
+		}
+	}
+	return out
+}
+
+// checkSingleSession identify 注册用户组之前调用：reject_new 且已有其他连接时返回 errSessionConflict
+func checkSingleSession(c *Client, userID string) error {
+	if GlobalConfig.SingleSession.Policy != sessionPolicyRejectNew {
+		return nil
+	}
+	if others := otherSessions(c, userID); len(others) > 0 {
+		log.Printf("⛔ 用户已在其他连接登录，拒绝 identify conn=%s user_id=%s（在线 %d 个）\n", c.id, logUserID(userID), len(others))
+		return errSessionConflict
+	}
+	return nil
+}
+
+// kickOlderSessions identify 注册用户组之后调用：kick_older 时顶掉该用户的其他连接
+func kickOlderSessions(c *Client, userID string) {
+	if GlobalConfig.SingleSession.Policy != sessionPolicyKickOlder {
+		return
+	}
+	others := otherSessions(c, userID)
+	if len(others) == 0 {
+		return
+	}
+
+	data := map[string]interface{}{
+		"reason": "logged_in_elsewhere",
+		"ts":     time.Now().UnixMilli(),
+	}
+	if c.device != nil && c.device.Platform != "" {
+		data["platform"] = c.device.Platform
+	}
+	for _, old := range others {
+		// 先退出用户组，关闭前不会再收到该用户的消息
+		unregisterUser(old)
+		_ = old.deliver(WSMessage{Event: "logged_in_elsewhere", Data: data})
+		old.closeWithCode(CloseSessionReplaced, "logged in elsewhere")
+	}
+	log.Printf("👥 用户在新连接登录，已断开旧连接 %d 个 user_id=%s conn=%s\n", len(others), logUserID(userID), c.id)
+}
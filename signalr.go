@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ===== ASP.NET Core SignalR 兼容 =====
+//
+// 流程与官方一致：POST {path}/negotiate 拿到 connectionToken，
+// 再 ws 连接 {path}?id={connectionToken}，先做握手 {"protocol":"json","version":1}，
+// 之后每条消息都是以 0x1E 结尾的 JSON 记录。
+//
+// 服务端推送映射为 Invocation：target = 事件名，arguments = [data]（频道消息为 [data, channel]）。
+// 客户端可调用的 hub 方法：Identify(token)、Subscribe(channel)、Unsubscribe(channel)。
+// accessTokenFactory 传入的 access_token 直接作为用户标识注册到用户组。
+
+// SignalRConfig SignalR 兼容端点配置
+type SignalRConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"` // hub 路径，默认 /hub
+}
+
+const (
+	signalRDefaultPath   = "/hub"
+	signalRRecordSep     = 0x1e
+	signalRPingInterval  = 15 * time.Second
+	signalRTokenLifetime = 30 * time.Second // negotiate 之后必须在这个时间内连上来
+)
+
+// SignalR 消息类型
+const (
+	signalRInvocation = 1
+	signalRCompletion = 3
+	signalRPing       = 6
+	signalRClose      = 7
+)
+
+type signalRMessage struct {
+	Type         int               `json:"type"`
+	Target       string            `json:"target,omitempty"`
+	Arguments    []json.RawMessage `json:"arguments,omitempty"`
+	InvocationID string            `json:"invocationId,omitempty"`
+}
+
+var (
+	signalRTokensMu sync.Mutex
+	signalRTokens   = make(map[string]time.Time) // connectionToken -> 过期时间
+)
+
+func registerSignalRRoutes(mux *http.ServeMux) {
+	path := GlobalConfig.SignalR.Path
+	mux.HandleFunc("POST "+path+"/negotiate", upgradeGuard(signalRNegotiateHandler))
+	mux.HandleFunc(path, upgradeGuard(signalRWSHandler))
+	log.Printf("✅ SignalR 兼容端点已启用：negotiate %s/negotiate，ws %s\n", path, path)
+}
+
+// signalREncode 编码一条以 0x1E 结尾的 JSON 记录
+func signalREncode(v interface{}) rawFrame {
+	b, _ := json.Marshal(v)
+	return append(b, signalRRecordSep)
+}
+
+func toSignalRFrame(msg WSMessage) interface{} {
+	args := []interface{}{msg.Data}
+	if msg.Channel != "" {
+		args = append(args, msg.Channel)
+	}
+	return signalREncode(map[string]interface{}{
+		"type":      signalRInvocation,
+		"target":    msg.Event,
+		"arguments": args,
+	})
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ===== negotiate =====
+
+func signalRNegotiateHandler(w http.ResponseWriter, r *http.Request) {
+	connectionID := randomHex(16)
+	token := randomHex(16)
+
+	now := time.Now()
+	signalRTokensMu.Lock()
+	for t, exp := range signalRTokens {
+		if now.After(exp) {
+			delete(signalRTokens, t)
+		}
+	}
+	signalRTokens[token] = now.Add(signalRTokenLifetime)
+	signalRTokensMu.Unlock()
+
+	resp := map[string]interface{}{
+		"connectionId":     connectionID,
+		"connectionToken":  token,
+		"negotiateVersion": 1,
+		"availableTransports": []map[string]interface{}{
+			{"transport": "WebSockets", "transferFormats": []string{"Text"}},
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// signalRTakeToken 校验并消费 negotiate 颁发的 connectionToken
+func signalRTakeToken(token string) bool {
+	signalRTokensMu.Lock()
+	defer signalRTokensMu.Unlock()
+
+	exp, ok := signalRTokens[token]
+	delete(signalRTokens, token)
+	return ok && time.Now().Before(exp)
+}
+
+// ===== WebSocket =====
+
+func signalRWSHandler(w http.ResponseWriter, r *http.Request) {
+	// 带了 id 的必须是 negotiate 颁发的；不带 id 的是 skipNegotiation 模式
+	if id := r.URL.Query().Get("id"); id != "" && !signalRTakeToken(id) {
+		http.Error(w, "No Connection with that ID", http.StatusNotFound)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, withAffinityCookie(r, nil))
+	if err != nil {
+		log.Println("SignalR WebSocket upgrade error:", err)
+		return
+	}
+
+	client := newClient(conn, r)
+	client.frame, client.frameKey = toSignalRFrame, "signalr"
+	done := make(chan struct{})
+	handshaken := false
+
+	defer func() {
+		close(done)
+		conn.Close()
+		if handshaken {
+			removeClient(client)
+		}
+	}()
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			noteReadError(client, "SignalR", err)
+			return
+		}
+
+		for _, rec := range bytes.Split(raw, []byte{signalRRecordSep}) {
+			if len(bytes.TrimSpace(rec)) == 0 {
+				continue
+			}
+
+			if !handshaken {
+				var hs struct {
+					Protocol string `json:"protocol"`
+					Version  int    `json:"version"`
+				}
+				if err := json.Unmarshal(rec, &hs); err != nil || hs.Protocol != "json" {
+					_ = client.sendRaw(signalREncode(map[string]string{"error": "Only the json protocol is supported."}))
+					return
+				}
+				_ = client.sendRaw(signalREncode(struct{}{}))
+				handshaken = true
+				addClient(client)
+				if token := r.URL.Query().Get("access_token"); token != "" {
+					if _, err := identifyUser(client, token); err != nil {
+						_ = client.sendRaw(signalREncode(map[string]interface{}{"type": signalRClose, "error": "Unauthorized"}))
+						return
+					}
+				}
+				go signalRPingLoop(client, done)
+				continue
+			}
+
+			var msg signalRMessage
+			if err := json.Unmarshal(rec, &msg); err != nil {
+				log.Println("⚠️ SignalR message parse error:", err)
+				continue
+			}
+
+			switch msg.Type {
+			case signalRPing:
+			case signalRClose:
+				return
+			case signalRInvocation:
+				signalRInvoke(client, &msg)
+			default:
+				log.Printf("📨 [SignalR] 忽略消息 type=%d\n", msg.Type)
+			}
+		}
+	}
+}
+
+// signalRInvoke 处理客户端调用的 hub 方法
+func signalRInvoke(c *Client, msg *signalRMessage) {
+	var arg string
+	if len(msg.Arguments) > 0 {
+		_ = json.Unmarshal(msg.Arguments[0], &arg)
+	}
+
+	errMsg := ""
+	switch msg.Target {
+	case "Identify":
+		if arg == "" {
+			errMsg = "token is required"
+			break
+		}
+		if _, err := identifyUser(c, arg); err != nil {
+			errMsg = "unauthorized"
+		}
+	case "Subscribe":
+		if arg == "" {
+			errMsg = "channel is required"
+			break
+		}
+		if !nativeChannelAllowed(arg) {
+			errMsg = errCodeChannelForbidden
+			break
+		}
+		total := subscribeChannel(c, arg)
+		log.Printf("📡 SignalR 订阅 channel=%s conn=%s, 频道连接数=%d\n", arg, c.id, total)
+	case "Unsubscribe":
+		unsubscribeChannel(c, arg)
+	default:
+		log.Printf("📨 [SignalR invoke] %s %d args\n", msg.Target, len(msg.Arguments))
+		errMsg = "Unknown hub method '" + msg.Target + "'"
+	}
+
+	// 非阻塞调用（没有 invocationId）不需要回复
+	if msg.InvocationID == "" {
+		return
+	}
+	completion := map[string]interface{}{
+		"type":         signalRCompletion,
+		"invocationId": msg.InvocationID,
+	}
+	if errMsg != "" {
+		completion["error"] = errMsg
+	} else {
+		completion["result"] = nil
+	}
+	_ = c.sendRaw(signalREncode(completion))
+}
+
+func signalRPingLoop(c *Client, done <-chan struct{}) {
+	ticker := time.NewTicker(signalRPingInterval)
+	defer ticker.Stop()
+	ping := signalREncode(map[string]int{"type": signalRPing})
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.sendRaw(ping); err != nil {
+				return
+			}
+		}
+	}
+}
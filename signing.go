@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ===== 服务端消息签名（Ed25519） =====
+//
+// 开启后每条推送消息带上 ts 和 sig：
+//
+//	sig = base64(Ed25519(event + "\n" + ts + "\n" + data 的 JSON))
+//
+// data 的 JSON 即帧里 "data": 之后到 ,"ts": 之前的原始字节，客户端按原文验证即可，不要重新序列化。
+// 因此开启签名时原生连接只协商 JSON 线上格式，msgpack / CBOR 不可用（见 codec.go）。
+// 公钥通过 GET /.well-known/relay-signing-key 公开。
+
+// SigningConfig 消息签名配置
+type SigningConfig struct {
+	Enabled    bool   `json:"enabled"`
+	PrivateKey string `json:"private_key"` // base64 的 32 字节 seed 或 64 字节私钥，支持 env:// / file:// 等引用；为空时随机生成
+	KeyID      string `json:"key_id"`      // 可选：密钥标识，默认取公钥 sha256 前 8 字节
+}
+
+const signingWellKnownPath = "/.well-known/relay-signing-key"
+
+var (
+	signingMu      sync.Mutex
+	signingRaw     string // 当前私钥对应的配置值，轮换时用来判断是否需要重新解析
+	signingPrivKey ed25519.PrivateKey
+)
+
+// prepareSigning 校验 / 生成签名私钥
+func prepareSigning(cfg *SigningConfig) {
+	if cfg.PrivateKey == "" {
+		_, priv, _ := ed25519.GenerateKey(rand.Reader)
+		cfg.PrivateKey = base64.StdEncoding.EncodeToString(priv.Seed())
+		log.Println("⚠️ signing 未配置 private_key，已随机生成，重启后公钥会变化")
+	}
+	if _, err := parseSigningKey(cfg.PrivateKey); err != nil {
+		log.Fatalf("❌ signing.private_key 无效 %v\n", err)
+	}
+}
+
+func parseSigningKey(s string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("不是合法的 base64")
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	default:
+		return nil, fmt.Errorf("长度应为 %d 或 %d 字节，实际 %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(raw))
+	}
+}
+
+// currentSigningKey 返回当前私钥，密钥引用轮换后自动切换
+func currentSigningKey() ed25519.PrivateKey {
+	raw := liveSecret("signing.private_key", GlobalConfig.Signing.PrivateKey)
+
+	signingMu.Lock()
+	defer signingMu.Unlock()
+	if raw != signingRaw || signingPrivKey == nil {
+		key, err := parseSigningKey(raw)
+		if err != nil {
+			log.Println("⚠️ 轮换后的签名私钥无效，继续使用旧密钥:", err)
+			return signingPrivKey
+		}
+		signingRaw, signingPrivKey = raw, key
+	}
+	return signingPrivKey
+}
+
+func signingKeyID(pub ed25519.PublicKey) string {
+	if GlobalConfig.Signing.KeyID != "" {
+		return GlobalConfig.Signing.KeyID
+	}
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// signMessage 给消息加上 ts / sig；未开启或已签名时原样返回
+func signMessage(msg WSMessage) WSMessage {
+	if !GlobalConfig.Signing.Enabled || msg.Sig != "" {
+		return msg
+	}
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return msg
+	}
+	msg.Ts = time.Now().UnixMilli()
+	signed := msg.Event + "\n" + strconv.FormatInt(msg.Ts, 10) + "\n" + string(data)
+	msg.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(currentSigningKey(), []byte(signed)))
+	return msg
+}
+
+// signingKeyHandler GET /.well-known/relay-signing-key 公开验签公钥
+func signingKeyHandler(w http.ResponseWriter, r *http.Request) {
+	pub := currentSigningKey().Public().(ed25519.PublicKey)
+	keyID := signingKeyID(pub)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{
+			"alg":        "Ed25519",
+			"key_id":     keyID,
+			"public_key": base64.StdEncoding.EncodeToString(pub),
+			"jwk": map[string]string{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"x":   base64.RawURLEncoding.EncodeToString(pub),
+				"kid": keyID,
+				"use": "sig",
+			},
+		},
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useSigning 开启签名，返回验签公钥
+func useSigning(t *testing.T, edit func(cfg *Config)) ed25519.PublicKey {
+	t.Helper()
+	seed := make([]byte, ed25519.SeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	useConfig(t, func(cfg *Config) {
+		cfg.Signing = SigningConfig{Enabled: true, PrivateKey: base64.StdEncoding.EncodeToString(seed)}
+		if edit != nil {
+			edit(cfg)
+		}
+	})
+	return ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+}
+
+// verifyFrame 按客户端的方式验签：data 取帧里的原始字节，不重新序列化
+func verifyFrame(t *testing.T, pub ed25519.PublicKey, frame []byte) map[string]interface{} {
+	t.Helper()
+	var f struct {
+		Event string          `json:"event"`
+		Data  json.RawMessage `json:"data"`
+		Ts    int64           `json:"ts"`
+		Sig   string          `json:"sig"`
+	}
+	if err := json.Unmarshal(frame, &f); err != nil {
+		t.Fatalf("帧解析失败: %v", err)
+	}
+	if f.Sig == "" || f.Ts == 0 {
+		t.Fatalf("帧没有签名: %s", frame)
+	}
+	sig, err := base64.StdEncoding.DecodeString(f.Sig)
+	if err != nil {
+		t.Fatalf("sig 不是合法的 base64: %v", err)
+	}
+	signed := f.Event + "\n" + strconv.FormatInt(f.Ts, 10) + "\n" + string(f.Data)
+	if !ed25519.Verify(pub, []byte(signed), sig) {
+		t.Fatalf("验签失败: %s", frame)
+	}
+	var data map[string]interface{}
+	_ = json.Unmarshal(f.Data, &data)
+	return data
+}
+
+func TestSignMessage(t *testing.T) {
+	pub := useSigning(t, nil)
+
+	msg := signMessage(WSMessage{Event: "order.paid", Data: map[string]interface{}{"id": 42}})
+	frame, _ := json.Marshal(msg)
+	verifyFrame(t, pub, frame)
+
+	// 已签名的消息原样返回，补发时带的是原始签名
+	if again := signMessage(msg); again.Sig != msg.Sig || again.Ts != msg.Ts {
+		t.Fatalf("重复签名改变了 sig / ts")
+	}
+
+	GlobalConfig.Signing.Enabled = false
+	if plain := signMessage(WSMessage{Event: "x", Data: 1}); plain.Sig != "" || plain.Ts != 0 {
+		t.Fatalf("未开启签名时不应带 sig / ts: %+v", plain)
+	}
+}
+
+func TestSignedMessageTransformedVariant(t *testing.T) {
+	pub := useSigning(t, func(cfg *Config) {
+		cfg.Transforms = []TransformRule{{Event: "order.*", UnlessCapability: "order_v2", Drop: []string{"items"}}}
+	})
+
+	h := NewHub(WithConfig(&GlobalConfig), WithHooks(globalHooks{}))
+	_, conn := newMemClient(h, "u1")
+
+	h.emitToUser("u1", WSMessage{Event: "order.updated", Data: map[string]interface{}{"id": 1, "items": []int{1, 2}}})
+	f, ok := conn.next(time.Second)
+	if !ok {
+		t.Fatal("没有收到消息")
+	}
+	// 降级改写后的 data 和原来不同，必须重新签名才能验过
+	data := verifyFrame(t, pub, f.Data)
+	if _, dropped := data["items"]; dropped || data["id"] == nil {
+		t.Fatalf("改写结果不对: %v", data)
+	}
+}
+
+func TestNegotiateCodecWithSigning(t *testing.T) {
+	useSigning(t, nil)
+
+	r := newTestUpgradeRequest("msgpack", "json")
+	codec, header := negotiateCodec(r, nil)
+	if codec != nil || header.Get("Sec-WebSocket-Protocol") != "json" {
+		t.Fatalf("开启签名时应当协商 json，实际 codec=%v protocol=%q", codec, header.Get("Sec-WebSocket-Protocol"))
+	}
+
+	GlobalConfig.Signing.Enabled = false
+	codec, header = negotiateCodec(newTestUpgradeRequest("msgpack", "json"), nil)
+	if codec == nil || header.Get("Sec-WebSocket-Protocol") != "msgpack" {
+		t.Fatalf("未开启签名时应当协商 msgpack，实际 protocol=%q", header.Get("Sec-WebSocket-Protocol"))
+	}
+}
+
+// newTestUpgradeRequest 带子协议列表的升级请求
+func newTestUpgradeRequest(protocols ...string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	return r
+}
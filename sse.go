@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== Server-Sent Events 传输 =====
+//
+// GET {path}?token=xxx 建立事件流，和 WebSocket 连接一样进 hub（可被单用户推送 / 广播命中）。
+// 开启 history 后单用户消息带 id，浏览器断线重连时会自动带上 Last-Event-ID，
+// 服务端据此从用户历史里补发缺失的消息，前端不需要任何额外代码。
+
+// SSEConfig SSE 传输配置
+type SSEConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"` // 默认 /sse
+}
+
+const (
+	sseDefaultPath   = "/sse"
+	sseKeepAlive     = 25 * time.Second
+	sseRetryMillis   = 3000
+	sseCloseMsgError = "sse connection closed"
+)
+
+// sseConn 把 http.ResponseWriter 包装成 clientConn
+type sseConn struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	done   chan struct{}
+	closed bool
+}
+
+func newSSEConn(w http.ResponseWriter) *sseConn {
+	return &sseConn{w: w, rc: http.NewResponseController(w), done: make(chan struct{})}
+}
+
+func (s *sseConn) WriteMessage(messageType int, data []byte) error {
+	// SSE 没有关闭帧，关闭由 Close 负责
+	if messageType == websocket.CloseMessage {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New(sseCloseMsgError)
+	}
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// WriteJSON 没经过 frame 转换的数据（比如直接 sendJSON 的回复）按无名事件发送
+func (s *sseConn) WriteJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.WriteMessage(0, sseEncode(0, "", b))
+}
+
+func (s *sseConn) SetWriteDeadline(t time.Time) error {
+	return s.rc.SetWriteDeadline(t)
+}
+
+func (s *sseConn) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	return nil
+}
+
+// sseEncode 编码一个 SSE 事件，id 为 0 时不带 id 行
+func sseEncode(id uint64, event string, data []byte) []byte {
+	var b bytes.Buffer
+	if id > 0 {
+		b.WriteString("id: ")
+		b.WriteString(strconv.FormatUint(id, 10))
+		b.WriteByte('\n')
+	}
+	if event != "" {
+		b.WriteString("event: ")
+		b.WriteString(event)
+		b.WriteByte('\n')
+	}
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		b.WriteString("data: ")
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+func toSSEFrame(msg WSMessage) interface{} {
+	data, _ := json.Marshal(msg.Data)
+	return rawFrame(sseEncode(msg.Seq, msg.Event, data))
+}
+
+func registerSSERoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+GlobalConfig.SSE.Path, upgradeGuard(sseHandler))
+	log.Printf("✅ SSE 传输已启用：%s\n", GlobalConfig.SSE.Path)
+}
+
+func sseHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	// 浏览器自动重连时带 Last-Event-ID 头；首次连接也允许用查询参数指定
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	after, _ := strconv.ParseUint(lastID, 10, 64)
+	if GlobalConfig.History.Size == 0 {
+		after = 0
+	}
+
+	visitorID, cookieHeader := visitorIdentity(r)
+	for k, v := range withAffinityCookie(r, cookieHeader) {
+		w.Header()[k] = v
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // 关闭 Nginx 缓冲
+	w.WriteHeader(http.StatusOK)
+
+	conn := newSSEConn(w)
+	client := newClient(conn, r)
+	client.frame, client.frameKey = toSSEFrame, "sse"
+	client.visitorID = visitorID
+	client.heartbeat = sseHeartbeat()
+
+	if err := client.sendRaw([]byte("retry: " + strconv.Itoa(sseRetryMillis) + "\n\n")); err != nil {
+		return
+	}
+
+	addClient(client)
+	defer removeClient(client)
+
+	if err := sendHello(client); err != nil {
+		return
+	}
+	if err := sendWelcome(client, "sse"); err != nil {
+		return
+	}
+
+	userID := admittedUserID(r)
+	switch {
+	case userID != "":
+		if err := registerAdmittedUser(client, userID, after); err != nil {
+			sendInvalidToken(client, err)
+			return
+		}
+	case token != "":
+		log.Println("🔐 SSE 连接携带 token:", logToken(token))
+		if _, err := identifyUserSince(client, token, after); err != nil {
+			sendInvalidToken(client, err)
+			return
+		}
+	case visitorID != "":
+		registerUser(client, visitorUserID(visitorID))
+	}
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-conn.done:
+			return
+		case <-ticker.C:
+			if err := client.sendRaw([]byte(": ping\n\n")); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ===== 运行时状态快照 =====
+//
+// GET /api/admin/state 输出整个 Hub 的脱敏快照：连接、用户组、频道订阅、流量总线各订阅方的缓冲深度、
+// 待发送的延迟推送任务，排查路由问题时让用户 / 支持同学抓一份现场。
+// 快照里不含消息内容、token、来源地址和请求头的值（只保留采集到了哪些请求头），设备只保留平台。
+// relay dump 子命令从运行中的实例拉取快照，写到标准输出或文件。
+
+// stateConnection 快照里的一条连接
+type stateConnection struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id,omitempty"`
+	Anonymous   bool      `json:"anonymous"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Platform    string    `json:"platform,omitempty"`
+	Channels    []string  `json:"channels,omitempty"`
+	MetaKeys    []string  `json:"meta_keys,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Recycling   bool      `json:"recycling,omitempty"`
+}
+
+// stateSnapshot GET /api/admin/state 的 data
+type stateSnapshot struct {
+	GeneratedAt time.Time           `json:"generated_at"`
+	NodeID      string              `json:"node_id,omitempty"`
+	Maintenance bool                `json:"maintenance"`
+	ReadOnly    bool                `json:"read_only"`
+	Totals      stateTotals         `json:"totals"`
+	Connections []stateConnection   `json:"connections"`
+	Users       map[string][]string `json:"users"`    // user_id → 连接 ID
+	Channels    map[string][]string `json:"channels"` // 频道 → 连接 ID
+	Queues      []trafficQueue      `json:"queues"`
+	Jobs        []pushJob           `json:"jobs"` // 尚未发送的延迟推送
+}
+
+type stateTotals struct {
+	Connections int `json:"connections"`
+	Users       int `json:"users"`
+	Channels    int `json:"channels"`
+	Jobs        int `json:"jobs"`
+}
+
+// snapshotState 取当前 Hub 的脱敏快照
+func snapshotState(h *Hub) stateSnapshot {
+	hs := h.state()
+
+	// 连接所在频道由 channels 反查，和用户组 / 频道列表来自同一次加锁
+	chansOf := make(map[string][]string)
+	for ch, ids := range hs.channels {
+		for _, id := range ids {
+			chansOf[id] = append(chansOf[id], ch)
+		}
+	}
+
+	conns := make([]stateConnection, 0, len(hs.clients))
+	for _, c := range hs.clients {
+		sc := stateConnection{
+			ID:          c.id,
+			UserID:      c.userID,
+			Anonymous:   c.userID == "",
+			Namespace:   c.namespaceName(),
+			Channels:    chansOf[c.id],
+			ConnectedAt: c.connectedAt,
+			Recycling:   c.recycling.Load(),
+		}
+		if c.device != nil {
+			sc.Platform = c.device.Platform
+		}
+		for k := range c.meta {
+			sc.MetaKeys = append(sc.MetaKeys, k)
+		}
+		sort.Strings(sc.Channels)
+		sort.Strings(sc.MetaKeys)
+		conns = append(conns, sc)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+
+	jobs := listPushJobs(jobStatusScheduled)
+	snap := stateSnapshot{
+		GeneratedAt: h.now(),
+		Maintenance: currentMaintenance() != nil,
+		ReadOnly:    readOnly.Load(),
+		Totals: stateTotals{
+			Connections: len(conns),
+			Users:       len(hs.users),
+			Channels:    len(hs.channels),
+			Jobs:        len(jobs),
+		},
+		Connections: conns,
+		Users:       hs.users,
+		Channels:    hs.channels,
+		Queues:      trafficQueues(),
+		Jobs:        jobs,
+	}
+	if GlobalConfig.Cluster.Enabled {
+		snap.NodeID = GlobalConfig.Cluster.NodeID
+	}
+	return snap
+}
+
+// adminStateHandler GET /api/admin/state
+func adminStateHandler(w http.ResponseWriter, r *http.Request) {
+	snap := snapshotState(defaultHub)
+	log.Printf("📸 导出运行时状态快照：%d 个连接，%d 个用户，%d 个频道，%d 个待发送任务\n",
+		snap.Totals.Connections, snap.Totals.Users, snap.Totals.Channels, snap.Totals.Jobs)
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": snap,
+	})
+}
+
+// ===== relay dump =====
+
+// runDump relay dump 子命令：从运行中的实例拉取状态快照，返回进程退出码
+func runDump(args []string) int {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	baseURL := fs.String("url", "http://127.0.0.1:3000", "实例地址")
+	apiKey := fs.String("api-key", os.Getenv("RELAY_API_KEY"), "管理接口的 API Key（默认取环境变量 RELAY_API_KEY）")
+	out := fs.String("o", "", "输出文件，为空时写到标准输出")
+	timeout := fs.Duration("timeout", 10*time.Second, "请求超时")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *apiKey == "" {
+		fmt.Fprintln(os.Stderr, "❌ 需要 -api-key（或环境变量 RELAY_API_KEY）")
+		return 2
+	}
+
+	body, err := fetchState(strings.TrimRight(*baseURL, "/"), *apiKey, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ 拉取状态快照失败:", err)
+		return 1
+	}
+
+	if *out == "" {
+		_, _ = os.Stdout.Write(body)
+		return 0
+	}
+	if err := os.WriteFile(*out, body, 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, "❌ 写入文件失败:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "✅ 状态快照已写入 %s\n", *out)
+	return 0
+}
+
+// fetchState 请求 /api/admin/state，返回缩进后的 data 部分
+func fetchState(baseURL, apiKey string, timeout time.Duration) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/api/admin/state", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-API-KEY", apiKey)
+
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var p struct {
+			Detail string `json:"detail"`
+		}
+		if json.Unmarshal(raw, &p) == nil && p.Detail != "" {
+			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, p.Detail)
+		}
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	var env struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &env); err != nil || len(env.Data) == 0 {
+		return nil, errors.New("unexpected response body")
+	}
+	out, err := json.MarshalIndent(env.Data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
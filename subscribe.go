@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ===== 原生协议频道订阅 =====
+//
+// 原生 WebSocket / TCP 客户端用控制帧加入 / 离开频道（房间）：
+//
+//	{"type":"subscribe","channel":"room:42"}    → {"event":"subscribed","channel":"room:42","data":null}
+//	{"type":"unsubscribe","channel":"room:42"}  → {"event":"unsubscribed","channel":"room:42","data":null}
+//
+// 频道注册表和 Pusher / Centrifugo / Phoenix / SignalR 共用（Hub.channels），断开时统一清理；
+// 推送接口带 "channel" 时发给频道内所有连接，不论连接走的是哪种协议。
+// private- / presence- 开头的频道需要 Pusher 的频道鉴权，原生协议和 Centrifugo / Phoenix / SignalR 都不能直接订阅。
+// 重复订阅、退订未订阅的频道都按成功处理。
+// 频道名里整段的 * / # 是通配订阅（如 orders.*、chat.#），见 topics.go；退订时用订阅时的原样名字。
+
+const errCodeChannelForbidden = "channel_forbidden"
+
+// nativeChannelAllowed 不经过 Pusher 频道鉴权的协议（原生 / Centrifugo / Phoenix / SignalR）能否直接订阅该频道
+func nativeChannelAllowed(channel string) bool {
+	return !strings.HasPrefix(channel, "private-") && !strings.HasPrefix(channel, "presence-")
+}
+
+// handleNativeSubscription 处理 subscribe / unsubscribe 控制帧，返回 false 表示应断开连接；
+// history 大于 0 且开启 channel_history 时，回 subscribed 之后补发最近的频道消息；ttl 见 subttl.go
+func handleNativeSubscription(c *Client, kind, channel string, history, ttl int) bool {
+	if kind == "unsubscribe" {
+		armSubscriptionTTL(c, channel, 0)
+		unsubscribeChannel(c, channel)
+		log.Printf("📡 原生退订 channel=%s conn=%s\n", channel, c.id)
+		return c.deliver(WSMessage{Event: "unsubscribed", Channel: channel}) == nil
+	}
+
+	if !nativeChannelAllowed(channel) {
+		return rejectInbound(c, &clientError{Code: errCodeChannelForbidden, Msg: "private- and presence- channels require Pusher channel auth", Field: "channel"})
+	}
+	total := subscribeChannel(c, channel)
+	ttl = subscriptionTTLFor(ttl)
+	armSubscriptionTTL(c, channel, ttl)
+	log.Printf("📡 原生订阅 channel=%s conn=%s, 频道连接数=%d\n", channel, c.id, total)
+	reply := WSMessage{Event: "subscribed", Channel: channel}
+	if ttl > 0 {
+		reply.Data = map[string]int{"ttl": ttl}
+	}
+	if err := c.deliver(reply); err != nil {
+		return false
+	}
+	if history > 0 && GlobalConfig.ChannelHistory.Size > 0 {
+		return sendChannelHistory(c, channel, history) == nil
+	}
+	return true
+}
+
+// validatePushChannel 推送请求里的 channel：不能和按用户 / 连接的定向字段同时使用
+func validatePushChannel(body PushRequest) (string, error) {
+	switch {
+	case len(body.Channel) > GlobalConfig.Inbound.MaxChannelLength:
+		return "channel", fmt.Errorf("channel longer than %d bytes", GlobalConfig.Inbound.MaxChannelLength)
+	case body.Token != nil && parseUserToID(body.Token) != "":
+		return "channel", errors.New("channel cannot be combined with token")
+	case body.DeviceID != "" || body.ClientID != "":
+		return "channel", errors.New("channel cannot be combined with device_id / client_id")
+	case len(body.Selector) > 0 || body.Namespace != "":
+		return "channel", errors.New("channel cannot be combined with selector / namespace")
+	case isChannelPattern(body.Channel):
+		return "channel", errors.New("channel cannot contain * / # wildcard segments")
+	}
+	return "", nil
+}
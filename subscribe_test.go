@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAdaptersRejectAuthChannels(t *testing.T) {
+	useConfig(t, nil)
+
+	for _, channel := range []string{"private-orders", "presence-room"} {
+		c, _ := newMemClient(defaultHub, "")
+		t.Cleanup(func() { defaultHub.removeClient(c) })
+
+		// 原生协议
+		injectFrame(c, []byte(`{"type":"subscribe","channel":"`+channel+`"}`))
+		// Centrifugo
+		centrifugoHandleCommand(c, &centrifugoCommand{ID: 1, Subscribe: &centrifugoChannelRequest{Channel: channel}})
+		// SignalR
+		arg, _ := json.Marshal(channel)
+		signalRInvoke(c, &signalRMessage{Type: 1, Target: "Subscribe", Arguments: []json.RawMessage{arg}, InvocationID: "1"})
+
+		if isSubscribed(c, channel) {
+			t.Fatalf("%s 不经过 Pusher 频道鉴权就订阅成功了", channel)
+		}
+	}
+
+	// 普通频道不受影响
+	c, _ := newMemClient(defaultHub, "")
+	t.Cleanup(func() { defaultHub.removeClient(c) })
+	centrifugoHandleCommand(c, &centrifugoCommand{ID: 1, Subscribe: &centrifugoChannelRequest{Channel: "news"}})
+	if !isSubscribed(c, "news") {
+		t.Fatal("普通频道订阅失败")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ===== 带有效期的频道订阅 =====
+//
+// 长会话里客户端常常只是临时关注某个对象（比如下单后盯 10 分钟订单状态），忘了退订就会越攒越多。
+// 原生订阅可以带 ttl（秒），到期由中继自动退订并通知客户端：
+//
+//	{"type":"subscribe","channel":"order/123","ttl":600}
+//	→ {"event":"subscribed","channel":"order/123","data":{"ttl":600}}
+//	…600 秒后…
+//	→ {"event":"subscription_expired","channel":"order/123","data":{"ttl":600}}
+//
+// 到期前再次订阅会按新的 ttl 重新计时（不带 ttl 时变为长期订阅）；主动退订、断开连接、频道过期都会取消计时。
+// 配置 subscription_ttl.default_seconds 后，不带 ttl 的原生订阅也按它过期。
+
+// SubscriptionTTLConfig 带有效期的订阅配置
+type SubscriptionTTLConfig struct {
+	MaxSeconds     int `json:"max_seconds"`     // 客户端可以指定的最长 ttl，默认 86400
+	DefaultSeconds int `json:"default_seconds"` // 不带 ttl 的原生订阅的有效期，0 表示不过期
+}
+
+const (
+	subscriptionTTLDefaultMax = 86400
+
+	subscriptionExpiredEvent = "subscription_expired"
+)
+
+var (
+	subTTLMu     sync.Mutex
+	subTTLTimers = make(map[*Client]map[string]*time.Timer)
+)
+
+func prepareSubscriptionTTL(cfg *SubscriptionTTLConfig) {
+	if cfg.MaxSeconds <= 0 {
+		cfg.MaxSeconds = subscriptionTTLDefaultMax
+	}
+	if cfg.DefaultSeconds < 0 {
+		cfg.DefaultSeconds = 0
+	}
+	if cfg.DefaultSeconds > cfg.MaxSeconds {
+		log.Printf("⚠️ subscription_ttl.default_seconds 超过 max_seconds，按 %d 处理\n", cfg.MaxSeconds)
+		cfg.DefaultSeconds = cfg.MaxSeconds
+	}
+}
+
+// subscriptionTTLFor 订阅帧里的 ttl，没带时取默认值
+func subscriptionTTLFor(ttl int) int {
+	if ttl > 0 {
+		return ttl
+	}
+	return GlobalConfig.SubscriptionTTL.DefaultSeconds
+}
+
+// armSubscriptionTTL 为连接的频道订阅（重新）计时，ttl 为 0 时取消计时
+func armSubscriptionTTL(c *Client, channel string, ttl int) {
+	subTTLMu.Lock()
+	defer subTTLMu.Unlock()
+
+	timers := subTTLTimers[c]
+	if t, ok := timers[channel]; ok {
+		t.Stop()
+		delete(timers, channel)
+	}
+	if ttl <= 0 {
+		if len(timers) == 0 {
+			delete(subTTLTimers, c)
+		}
+		return
+	}
+	if timers == nil {
+		timers = make(map[string]*time.Timer)
+		subTTLTimers[c] = timers
+	}
+	var t *time.Timer
+	t = time.AfterFunc(time.Duration(ttl)*time.Second, func() { expireSubscription(c, channel, ttl, t) })
+	timers[channel] = t
+}
+
+// expireSubscription 计时到期：确认还是这一次计时后退订并通知客户端
+func expireSubscription(c *Client, channel string, ttl int, t *time.Timer) {
+	subTTLMu.Lock()
+	timers := subTTLTimers[c]
+	if timers[channel] != t {
+		subTTLMu.Unlock()
+		return // 已被重新订阅 / 退订
+	}
+	delete(timers, channel)
+	if len(timers) == 0 {
+		delete(subTTLTimers, c)
+	}
+	subTTLMu.Unlock()
+
+	if !isSubscribed(c, channel) {
+		return // 频道过期等其他途径已经退订
+	}
+	unsubscribeChannel(c, channel)
+	log.Printf("⌛ 订阅到期 channel=%s conn=%s ttl=%ds\n", channel, c.id, ttl)
+	_ = c.deliver(WSMessage{Event: subscriptionExpiredEvent, Channel: channel, Data: map[string]int{"ttl": ttl}})
+}
+
+// forgetSubscriptionTTLs 连接断开时取消它的全部计时
+func forgetSubscriptionTTLs(c *Client) {
+	subTTLMu.Lock()
+	defer subTTLMu.Unlock()
+	for _, t := range subTTLTimers[c] {
+		t.Stop()
+	}
+	delete(subTTLTimers, c)
+}
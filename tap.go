@@ -0,0 +1,174 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 流量旁路（tap） =====
+//
+// relay 内部的一条「流量总线」：客户端上行事件、服务端下发的每条消息都会发布一份 trafficEvent，
+// 订阅方（gRPC 订阅接口、firehose 等）按自己的过滤条件接收。没有订阅方时发布几乎零开销。
+// 每个订阅方一个有界缓冲，消费跟不上时丢弃并计数，绝不阻塞推送主路径。
+// 发布前 data 已按 redaction.fields 脱敏（redact.go），订阅方拿不到原始敏感字段；
+// user_id 是 token 时订阅方拿到的也是脱敏后的（logUserID），只有要按 user_id 查询 / 删除的归档保留原值。
+
+const (
+	trafficInbound  = "in"  // 客户端上行
+	trafficOutbound = "out" // 服务端下发
+
+	tapDefaultBuffer = 1024
+)
+
+// trafficEvent 流经 relay 的一条消息
+type trafficEvent struct {
+	Direction string      `json:"direction"`
+	Event     string      `json:"event"`
+	Channel   string      `json:"channel,omitempty"`
+	UserID    string      `json:"user_id,omitempty"`
+	ConnID    string      `json:"conn_id,omitempty"`
+	Protocol  string      `json:"protocol,omitempty"` // native / pusher / centrifugo / phoenix
+	Data      interface{} `json:"data,omitempty"`
+	Seq       uint64      `json:"seq,omitempty"`
+	Ts        int64       `json:"ts"` // 毫秒
+
+	// 仅下发消息：目标类型（user / channel / broadcast）、命中连接数、成功投递数、投递耗时
+	Target     string `json:"target,omitempty"`
+	Recipients int    `json:"recipients,omitempty"`
+	Delivered  int    `json:"delivered,omitempty"`
+	DurationUs int64  `json:"duration_us,omitempty"`
+	Ephemeral  bool   `json:"ephemeral,omitempty"` // 瞬时消息，不归档
+}
+
+// tapSubscriber 一个流量订阅方
+type tapSubscriber struct {
+	name    string // 订阅方名称，用于状态快照
+	C       chan trafficEvent
+	match   func(*trafficEvent) bool
+	dropped atomic.Uint64
+
+	rawUserID bool // 收到原始 user_id（归档）
+}
+
+var (
+	tapMu          sync.RWMutex
+	tapSubscribers = make(map[*tapSubscriber]struct{})
+	tapCount       atomic.Int32
+)
+
+// subscribeTraffic 注册订阅方，match 为 nil 表示全部（match 看到的是原始 user_id）；用完必须 unsubscribeTraffic
+func subscribeTraffic(name string, match func(*trafficEvent) bool) *tapSubscriber {
+	return addTrafficSubscriber(&tapSubscriber{name: name, C: make(chan trafficEvent, tapDefaultBuffer), match: match})
+}
+
+// subscribeTrafficRaw 同 subscribeTraffic，但收到的 user_id 不脱敏
+func subscribeTrafficRaw(name string, match func(*trafficEvent) bool) *tapSubscriber {
+	return addTrafficSubscriber(&tapSubscriber{name: name, C: make(chan trafficEvent, tapDefaultBuffer), match: match, rawUserID: true})
+}
+
+func addTrafficSubscriber(s *tapSubscriber) *tapSubscriber {
+	tapMu.Lock()
+	tapSubscribers[s] = struct{}{}
+	tapCount.Store(int32(len(tapSubscribers)))
+	tapMu.Unlock()
+	return s
+}
+
+func unsubscribeTraffic(s *tapSubscriber) {
+	tapMu.Lock()
+	delete(tapSubscribers, s)
+	tapCount.Store(int32(len(tapSubscribers)))
+	tapMu.Unlock()
+}
+
+// trafficQueue 一个订阅方的缓冲占用情况
+type trafficQueue struct {
+	Name     string `json:"name"`
+	Depth    int    `json:"depth"`
+	Capacity int    `json:"capacity"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// trafficQueues 各订阅方当前的缓冲深度，按名称排序
+func trafficQueues() []trafficQueue {
+	tapMu.RLock()
+	out := make([]trafficQueue, 0, len(tapSubscribers))
+	for s := range tapSubscribers {
+		out = append(out, trafficQueue{Name: s.name, Depth: len(s.C), Capacity: cap(s.C), Dropped: s.dropped.Load()})
+	}
+	tapMu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// publishTraffic 把一条流量发给所有匹配的订阅方，缓冲满时丢弃
+func publishTraffic(ev trafficEvent) {
+	if tapCount.Load() == 0 {
+		return
+	}
+	if ev.Ts == 0 {
+		ev.Ts = time.Now().UnixMilli()
+	}
+	ev.Data = redactTraffic(ev.Data)
+	redacted := ev
+	redacted.UserID = logUserID(ev.UserID)
+
+	tapMu.RLock()
+	defer tapMu.RUnlock()
+	for s := range tapSubscribers {
+		if s.match != nil && !s.match(&ev) {
+			continue
+		}
+		out := redacted
+		if s.rawUserID {
+			out = ev
+		}
+		select {
+		case s.C <- out:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// publishInbound 客户端上行事件
+func publishInbound(c *Client, protocol, event, channel string, data interface{}) {
+	if GlobalConfig.Aggregation.Enabled {
+		aggregateInbound(c, protocol, event, channel, data)
+	}
+	publishTraffic(trafficEvent{
+		Direction: trafficInbound,
+		Event:     event,
+		Channel:   channel,
+		UserID:    c.userID,
+		ConnID:    c.id,
+		Protocol:  protocol,
+		Data:      data,
+	})
+}
+
+// publishOutbound 服务端下发的一条消息及其投递情况
+func publishOutbound(target, targetID string, msg WSMessage, recipients, delivered int, start time.Time) {
+	if tapCount.Load() == 0 {
+		return
+	}
+	ev := trafficEvent{
+		Direction:  trafficOutbound,
+		Event:      msg.Event,
+		Channel:    msg.Channel,
+		Data:       msg.Data,
+		Seq:        msg.Seq,
+		Target:     target,
+		Recipients: recipients,
+		Delivered:  delivered,
+		DurationUs: time.Since(start).Microseconds(),
+		Ephemeral:  msg.Ephemeral,
+	}
+	if target == "user" {
+		ev.UserID = targetID
+	}
+	publishTraffic(ev)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ===== 原始 TCP 传输（长度前缀 JSON） =====
+//
+// 给没有 WebSocket 栈的嵌入式 / IoT 客户端用。每一帧 = 4 字节大端长度 + JSON，
+// 上下行消息格式与原生 WebSocket 完全一致：
+//
+//	上行：{"event":"identify","data":{"token":"xxx"}}、{"type":"ping","ts":123}
+//	下行：{"event":"hello",...}、{"event":"xxx","data":...}、{"type":"pong","ts":123}
+//
+// 服务端主动断开（版本过低、认证过期等）前会下发 {"event":"close","data":{"code":4426,"reason":"..."}}。
+// 客户端需要在 read_timeout_seconds 内至少发一帧（比如 ping），否则视为断线。
+
+// TCPConfig 原始 TCP 监听配置
+type TCPConfig struct {
+	Enabled            bool   `json:"enabled"`
+	Addr               string `json:"addr"`                 // 监听地址，默认 :4000
+	MaxFrameBytes      int    `json:"max_frame_bytes"`      // 单帧上限，默认 64KB
+	ReadTimeoutSeconds int    `json:"read_timeout_seconds"` // 多久收不到任何帧就断开，默认 90
+}
+
+const (
+	tcpDefaultAddr          = ":4000"
+	tcpDefaultMaxFrameBytes = 64 << 10
+	tcpDefaultReadTimeout   = 90
+)
+
+// tcpConn 把 net.Conn 包装成 clientConn，写操作由 Client.mu 串行化
+type tcpConn struct {
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+func newTCPConn(conn net.Conn) *tcpConn {
+	return &tcpConn{conn: conn, w: bufio.NewWriter(conn)}
+}
+
+func (t *tcpConn) writeFrame(data []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	if _, err := t.w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := t.w.Write(data); err != nil {
+		return err
+	}
+	return t.w.Flush()
+}
+
+func (t *tcpConn) WriteMessage(messageType int, data []byte) error {
+	if messageType == websocket.CloseMessage {
+		// 把 WebSocket 关闭帧转成 close 事件，TCP 客户端也能拿到关闭码
+		code, reason := websocket.CloseNormalClosure, ""
+		if len(data) >= 2 {
+			code = int(binary.BigEndian.Uint16(data[:2]))
+			reason = string(data[2:])
+		}
+		return t.WriteJSON(WSMessage{Event: "close", Data: map[string]interface{}{"code": code, "reason": reason}})
+	}
+	return t.writeFrame(data)
+}
+
+func (t *tcpConn) WriteJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return t.writeFrame(b)
+}
+
+func (t *tcpConn) SetWriteDeadline(d time.Time) error {
+	return t.conn.SetWriteDeadline(d)
+}
+
+func (t *tcpConn) Close() error {
+	return t.conn.Close()
+}
+
+// readTCPFrame 读取一帧，超过 max 直接报错（不读 body）
+func readTCPFrame(r *bufio.Reader, max int) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if int64(n) > int64(max) {
+		return nil, fmt.Errorf("帧长度 %d 超过上限 %d", n, max)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// startTCPListener 启动 TCP 监听（开启 proxy_protocol 时同样解析 PROXY 头）
+func startTCPListener() {
+	cfg := &GlobalConfig.TCP
+	if cfg.Addr == "" {
+		cfg.Addr = tcpDefaultAddr
+	}
+	if cfg.MaxFrameBytes <= 0 {
+		cfg.MaxFrameBytes = tcpDefaultMaxFrameBytes
+	}
+	if cfg.ReadTimeoutSeconds <= 0 {
+		cfg.ReadTimeoutSeconds = tcpDefaultReadTimeout
+	}
+
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		log.Fatalln("❌ TCP 监听失败:", err)
+	}
+	if GlobalConfig.ProxyProtocol.Enabled {
+		pl, err := newProxyListener(ln, GlobalConfig.ProxyProtocol)
+		if err != nil {
+			log.Fatalln("❌ proxy_protocol 配置错误:", err)
+		}
+		ln = pl
+	} else if GlobalConfig.Bans.Enabled {
+		ln = banListener{ln}
+	}
+	log.Printf("✅ TCP 传输已启用：%s\n", cfg.Addr)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					continue
+				}
+				log.Println("❌ TCP accept 失败:", err)
+				return
+			}
+			go serveTCPConn(conn)
+		}
+	}()
+}
+
+func serveTCPConn(conn net.Conn) {
+	tc := newTCPConn(conn)
+	defer conn.Close()
+
+	// 复用基于 http.Request 的连接初始化（设备描述、元数据、PROXY 信息）
+	req := (&http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{},
+		Header:     http.Header{},
+		RemoteAddr: conn.RemoteAddr().String(),
+	}).WithContext(proxyConnContext(context.Background(), conn))
+	client := newClient(tc, req)
+	client.heartbeat = tcpHeartbeat()
+
+	// 开启 proxy_protocol 时监听器拿不到真实地址，在这里补一次封禁检查
+	if _, banned := ipBanned(client.remoteAddr); banned {
+		banRejected.Add(1)
+		return
+	}
+
+	if m := currentMaintenance(); m != nil {
+		_ = tc.WriteJSON(WSMessage{Event: "maintenance", Data: m})
+		return
+	}
+
+	addClient(client)
+	defer removeClient(client)
+
+	if err := sendHello(client); err != nil {
+		return
+	}
+	if err := sendWelcome(client, "tcp"); err != nil {
+		return
+	}
+
+	cfg := GlobalConfig.TCP
+	r := bufio.NewReader(conn)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Duration(cfg.ReadTimeoutSeconds) * time.Second))
+		raw, err := readTCPFrame(r, cfg.MaxFrameBytes)
+		if err != nil {
+			noteReadError(client, "TCP", err)
+			return
+		}
+		if !handleNativeMessage(client, raw) {
+			return
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import "strings"
+
+// ===== 频道通配订阅 =====
+//
+// 频道名按 . 分段，订阅时整段为 * 或 # 的频道是通配订阅：
+//
+//	orders.*       匹配 orders.123、orders.123.updated（* 匹配一段或多段）
+//	chat.#         匹配 chat、chat.room1、chat.room1.typing（# 匹配零段或多段）
+//	orders.*.paid  匹配 orders.1.paid、orders.eu.1.paid
+//
+// 只有整段的 * / # 是通配符，room*、a#b 仍按普通频道名处理；一个订阅最多 topicMaxWildcards 个通配段。
+// 通配订阅和普通订阅一样登记在 Hub.channels 里（退订、断开清理、统计都不变），另外按段建一棵前缀树；
+// 频道推送时先取精确订阅者，再在树里查出匹配的通配订阅合并，同一连接只收一次，消息的 channel 是实际推送的频道。
+// 没有通配订阅时树为空，查找不产生额外开销。
+// private- / presence- 频道需要 Pusher 鉴权，不会通过通配订阅下发；推送接口不能直接推到通配频道名。
+
+const (
+	topicSeparator   = "."
+	topicWildcardOne = "*" // 一段或多段
+	topicWildcardAny = "#" // 零段或多段
+
+	topicMaxWildcards = 8 // 一个通配订阅最多几个通配段
+)
+
+// isChannelPattern 频道名里是否有整段的通配符
+func isChannelPattern(channel string) bool {
+	if !strings.Contains(channel, topicWildcardOne) && !strings.Contains(channel, topicWildcardAny) {
+		return false
+	}
+	for _, seg := range strings.Split(channel, topicSeparator) {
+		if seg == topicWildcardOne || seg == topicWildcardAny {
+			return true
+		}
+	}
+	return false
+}
+
+// channelWildcardCount 频道名里整段通配符的个数
+func channelWildcardCount(channel string) int {
+	n := 0
+	for _, seg := range strings.Split(channel, topicSeparator) {
+		if seg == topicWildcardOne || seg == topicWildcardAny {
+			n++
+		}
+	}
+	return n
+}
+
+// topicTrie 通配订阅的前缀树，每个节点是一段；调用方持有 Hub.channelsMu
+type topicTrie struct {
+	root topicNode
+	size int
+}
+
+type topicNode struct {
+	children map[string]*topicNode
+	pattern  string // 在此结束的通配订阅，为空表示没有
+}
+
+// insert 登记一个通配频道（频道第一次有订阅者时调用）
+func (t *topicTrie) insert(pattern string) {
+	n := &t.root
+	for _, seg := range strings.Split(pattern, topicSeparator) {
+		if n.children == nil {
+			n.children = make(map[string]*topicNode)
+		}
+		next, ok := n.children[seg]
+		if !ok {
+			next = &topicNode{}
+			n.children[seg] = next
+		}
+		n = next
+	}
+	if n.pattern == "" {
+		t.size++
+	}
+	n.pattern = pattern
+}
+
+// remove 删除一个通配频道（频道没有订阅者时调用），顺带剪掉空分支
+func (t *topicTrie) remove(pattern string) {
+	if t.removeFrom(&t.root, strings.Split(pattern, topicSeparator)) {
+		t.size--
+	}
+}
+
+func (t *topicTrie) removeFrom(n *topicNode, segs []string) bool {
+	if len(segs) == 0 {
+		if n.pattern == "" {
+			return false
+		}
+		n.pattern = ""
+		return true
+	}
+	child, ok := n.children[segs[0]]
+	if !ok || !t.removeFrom(child, segs[1:]) {
+		return false
+	}
+	if child.pattern == "" && len(child.children) == 0 {
+		delete(n.children, segs[0])
+	}
+	return true
+}
+
+// match 返回匹配 channel 的所有通配频道（不重复）。
+// 同一节点从同一段位置出发的结果总是一样的，按 (节点, 段位置) 记下走过的组合，
+// 连续的 # / * 也不会让遍历指数膨胀（调用方持有 channelsMu 读锁，不能让一个订阅拖住所有推送）
+func (t *topicTrie) match(channel string) []string {
+	if t.size == 0 {
+		return nil
+	}
+	type visit struct {
+		n *topicNode
+		i int
+	}
+	segs := strings.Split(channel, topicSeparator)
+	visited := make(map[visit]struct{})
+	seen := make(map[string]struct{})
+	var out []string
+	var walk func(n *topicNode, i int)
+	walk = func(n *topicNode, i int) {
+		if _, done := visited[visit{n, i}]; done {
+			return
+		}
+		visited[visit{n, i}] = struct{}{}
+		if i == len(segs) && n.pattern != "" {
+			if _, dup := seen[n.pattern]; !dup {
+				seen[n.pattern] = struct{}{}
+				out = append(out, n.pattern)
+			}
+		}
+		if i < len(segs) {
+			if child, ok := n.children[segs[i]]; ok {
+				walk(child, i+1)
+			}
+		}
+		// * 吃掉一段或多段，# 吃掉零段或多段
+		if child, ok := n.children[topicWildcardOne]; ok {
+			for j := i + 1; j <= len(segs); j++ {
+				walk(child, j)
+			}
+		}
+		if child, ok := n.children[topicWildcardAny]; ok {
+			for j := i; j <= len(segs); j++ {
+				walk(child, j)
+			}
+		}
+	}
+	walk(&t.root, 0)
+	return out
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopicTrieMatch(t *testing.T) {
+	var trie topicTrie
+	for _, p := range []string{"orders.*", "chat.#", "orders.*.paid", "#", "a.#.b"} {
+		trie.insert(p)
+	}
+
+	cases := []struct {
+		channel string
+		want    []string
+	}{
+		{"orders", []string{"#"}},
+		{"orders.1", []string{"orders.*", "#"}},
+		{"orders.1.paid", []string{"orders.*", "orders.*.paid", "#"}},
+		{"orders.eu.1.paid", []string{"orders.*", "orders.*.paid", "#"}},
+		{"chat", []string{"chat.#", "#"}},
+		{"chat.room1.typing", []string{"chat.#", "#"}},
+		{"a.b", []string{"#", "a.#.b"}},
+		{"a.x.y.b", []string{"#", "a.#.b"}},
+		{"a.x.y", []string{"#"}},
+	}
+	for _, tc := range cases {
+		got := trie.match(tc.channel)
+		slices.Sort(got)
+		slices.Sort(tc.want)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("match(%q) = %v, want %v", tc.channel, got, tc.want)
+		}
+	}
+
+	trie.remove("#")
+	trie.remove("chat.#")
+	if got := trie.match("chat.room1"); len(got) != 0 {
+		t.Errorf("删除后 match(chat.room1) = %v, want 空", got)
+	}
+	if got := trie.match("orders.1"); !slices.Equal(got, []string{"orders.*"}) {
+		t.Errorf("删除其它订阅后 match(orders.1) = %v", got)
+	}
+}
+
+func TestTopicTrieMatchPathological(t *testing.T) {
+	// 连续的通配段在没有记忆化时是指数级的，这里必须很快返回
+	var trie topicTrie
+	pattern := strings.Repeat("#.", topicMaxWildcards-1) + "#.x"
+	trie.insert(pattern)
+	channel := strings.Repeat("a.", 200) + "b"
+
+	start := time.Now()
+	if got := trie.match(channel); len(got) != 0 {
+		t.Fatalf("match = %v, want 空", got)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("match 耗时 %s", took)
+	}
+	if got := trie.match(channel + ".x"); !slices.Equal(got, []string{pattern}) {
+		t.Fatalf("match = %v, want [%s]", got, pattern)
+	}
+}
+
+func TestSubscribeWildcardCap(t *testing.T) {
+	useConfig(t, nil)
+	cfg := GlobalConfig.Inbound
+
+	ok := `{"type":"subscribe","channel":"` + strings.Repeat("*.", topicMaxWildcards-1) + `*"}`
+	if _, err := parseInbound([]byte(ok), cfg); err != nil {
+		t.Fatalf("%d 个通配段应当允许: %v", topicMaxWildcards, err)
+	}
+
+	tooMany := `{"type":"subscribe","channel":"` + strings.Repeat("#.", topicMaxWildcards) + `#"}`
+	_, err := parseInbound([]byte(tooMany), cfg)
+	if err == nil || err.Code != errCodeInvalidValue || err.Field != "channel" {
+		t.Fatalf("超过上限应当返回 invalid_value/channel，实际 %+v", err)
+	}
+
+	// 退订不受限制，已有的订阅总能退掉
+	unsub := `{"type":"unsubscribe","channel":"` + strings.Repeat("#.", topicMaxWildcards) + `#"}`
+	if _, err := parseInbound([]byte(unsub), cfg); err != nil {
+		t.Fatalf("退订不应受通配段上限限制: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ===== 按客户端能力降级下发消息 =====
+//
+// 后端总是推送最新的消息结构；老版本客户端在连接时声明自己支持的能力：
+//
+//	ws://host/ws?caps=order_v2,rich_text   （或请求头 X-Client-Capabilities: order_v2,rich_text）
+//
+// 服务端在 hello 里回显双方都认识的能力，下发时对缺少某项能力的连接按 transforms 规则改写 data：
+//
+//	"transforms": [
+//	  {"event": "order.*", "unless_capability": "order_v2", "rename": {"subject.amount_cents": "amount"}, "drop": ["subject.items"]}
+//	]
+//
+// 规则只改写对象形式的 data，字段路径用 . 访问嵌套对象；rename 的目标是同一层的新字段名。
+// 同一条推送里应用了同一组规则的连接共享一次改写和编码结果（见 outbound.go）。
+// 端到端加密的载荷无法改写，原样下发。
+
+// TransformRule 一条降级规则
+type TransformRule struct {
+	Event            string            `json:"event"`             // 事件名，支持 * 通配
+	UnlessCapability string            `json:"unless_capability"` // 连接声明了该能力时不改写
+	Rename           map[string]string `json:"rename"`            // 字段路径 -> 新字段名
+	Drop             []string          `json:"drop"`              // 删除的字段路径
+}
+
+const (
+	capabilitiesQueryKey = "caps"
+	capabilitiesHeader   = "X-Client-Capabilities"
+	maxTransformRules    = 64 // 连接命中的规则用 uint64 位图表示
+)
+
+// knownCapabilities transforms 里出现过的能力，启动后只读
+var knownCapabilities = make(map[string]bool)
+
+func prepareTransforms(rules []TransformRule) {
+	if len(rules) > maxTransformRules {
+		log.Fatalf("❌ transforms 最多 %d 条规则\n", maxTransformRules)
+	}
+	for i, rule := range rules {
+		if rule.Event == "" || rule.UnlessCapability == "" {
+			log.Fatalf("❌ transforms[%d] 配置错误：event 和 unless_capability 不能为空\n", i)
+		}
+		if _, err := path.Match(rule.Event, ""); err != nil {
+			log.Fatalf("❌ transforms[%d].event 无效 %q: %v\n", i, rule.Event, err)
+		}
+		knownCapabilities[rule.UnlessCapability] = true
+	}
+}
+
+// capabilitiesFromRequest 连接声明的能力，只保留服务端认识的
+func capabilitiesFromRequest(r *http.Request) map[string]bool {
+	raw := r.URL.Query().Get(capabilitiesQueryKey)
+	if raw == "" {
+		raw = r.Header.Get(capabilitiesHeader)
+	}
+	if raw == "" || len(knownCapabilities) == 0 {
+		return nil
+	}
+	caps := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); knownCapabilities[name] {
+			caps[name] = true
+		}
+	}
+	return caps
+}
+
+// capabilityList 协商后的能力列表，随 hello 下发
+func (c *Client) capabilityList() []string {
+	out := make([]string, 0, len(c.caps))
+	for name := range c.caps {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// transformMask 这条消息对该连接需要应用的规则，第 i 位对应 rules[i]
+func (c *Client) transformMask(rules []TransformRule, msg WSMessage) uint64 {
+	var mask uint64
+	for i, rule := range rules {
+		if c.caps[rule.UnlessCapability] {
+			continue
+		}
+		if matched, _ := path.Match(rule.Event, msg.Event); matched {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
+
+// transformMessage 按位图应用规则，返回改写后的消息；data 不是对象时原样返回。
+// 改写后原来的 sig 不再对应 data，一并清掉，由调用方重新签名（见 outboundMessage.transformed）
+func transformMessage(msg WSMessage, rules []TransformRule, mask uint64) WSMessage {
+	if isEncryptedPayload(msg) {
+		return msg
+	}
+	raw, err := json.Marshal(msg.Data)
+	if err != nil {
+		return msg
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(raw, &data); err != nil || data == nil {
+		return msg
+	}
+	for i, rule := range rules {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		for from, to := range rule.Rename {
+			if parent, key := fieldParent(data, from); parent != nil {
+				if v, ok := parent[key]; ok {
+					delete(parent, key)
+					parent[to] = v
+				}
+			}
+		}
+		for _, field := range rule.Drop {
+			if parent, key := fieldParent(data, field); parent != nil {
+				delete(parent, key)
+			}
+		}
+	}
+	msg.Data = data
+	msg.Sig = ""
+	return msg
+}
+
+// fieldParent 按 a.b.c 找到字段所在的对象和字段名，中间路径不是对象时返回 nil
+func fieldParent(data map[string]interface{}, field string) (map[string]interface{}, string) {
+	parts := strings.Split(field, ".")
+	cur := data
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := cur[p].(map[string]interface{})
+		if !ok {
+			return nil, ""
+		}
+		cur = next
+	}
+	return cur, parts[len(parts)-1]
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ===== 多事件事务推送 =====
+//
+// 一次业务变更有时要拆成几种事件（比如“订单状态变了”+“库存变了”+“角标数变了”），客户端必须一起应用，
+// 分开推送时中间可能插进别的消息，或者只到了一半。推送请求可以带 events，作为一笔事务整体发送：
+//
+//	{"token":"u1","events":[
+//	  {"event_name":"order.updated","subject":{"id":42,"status":"paid"}},
+//	  {"event_name":"inventory.changed","subject":{"sku":"A1","left":3}}
+//	]}
+//
+// 每个目标连接上，这几帧按顺序连续写出，中间不会插入其它推送；每帧带 txn，客户端收齐 count 帧后一起应用：
+//
+//	{"event":"order.updated","data":{...},"txn":{"id":"msg_9f2c...","index":0,"count":2}}
+//	{"event":"inventory.changed","data":{...},"txn":{"id":"msg_9f2c...","index":1,"count":2}}
+//
+// 整笔事务是一次推送：校验不通过时一个事件都不发，进异步队列、延迟任务、周期任务时占一个位置，
+// 任何一帧编码失败时这个连接一帧都不写。写到一半连接断开时客户端收不齐 count 帧，应当丢弃这笔事务。
+// 定向方式（token / channel / group / selector 等）与普通推送相同，作用于所有事件；event_name 可选，
+// 作为整笔事务的名称出现在响应、任务和回调里，默认取第一个事件名。
+
+const txnMaxEvents = 32
+
+// PushEvent 事务里的一个事件
+type PushEvent struct {
+	EventName string      `json:"event_name"`
+	Subject   interface{} `json:"subject"`
+}
+
+// txnInfo 事务推送的每一帧带的位置信息
+type txnInfo struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+	Count int    `json:"count"`
+}
+
+// buildTxnEvents 校验 events 并构造每个事件的消息（载荷结构与普通推送相同），出错时同时返回字段名
+func buildTxnEvents(body *PushRequest) ([]WSMessage, string, error) {
+	switch {
+	case len(body.Events) > txnMaxEvents:
+		return nil, "events", fmt.Errorf("at most %d events per push", txnMaxEvents)
+	case body.Subject != nil:
+		return nil, "events", errors.New("events cannot be combined with subject")
+	case body.Ciphertext != "":
+		return nil, "events", errors.New("events cannot be combined with ciphertext")
+	case body.Ephemeral:
+		return nil, "events", errors.New("ephemeral pushes cannot have events")
+	case body.RequireAck:
+		return nil, "events", errors.New("events cannot be combined with require_ack")
+	}
+
+	now := time.Now().UnixMilli()
+	msgs := make([]WSMessage, len(body.Events))
+	for i, ev := range body.Events {
+		field := fmt.Sprintf("events[%d].event_name", i)
+		switch {
+		case ev.EventName == "":
+			return nil, field, errors.New("event_name is required")
+		case isSystemEvent(ev.EventName):
+			return nil, field, errors.New(systemEventReservedMsg)
+		}
+		msgs[i] = WSMessage{
+			Event:   ev.EventName,
+			Channel: body.Channel,
+			Data:    Payload{Subject: ev.Subject, Ts: now, Token: body.Token},
+		}
+	}
+	if body.EventName == "" {
+		body.EventName = body.Events[0].EventName
+	}
+	return msgs, "", nil
+}
+
+// routeTxn 按组 / 频道 / 用户 / 广播取目标连接，每个连接上连续写出整笔事务，返回成功投递的连接数
+func (p *preparedPush) routeTxn() int {
+	body := p.body
+	msgs := make([]WSMessage, len(p.events))
+	for i, m := range p.events {
+		m.Txn = &txnInfo{ID: p.message.ID, Index: i, Count: len(p.events)}
+		msgs[i] = signMessage(m)
+	}
+
+	// kind / targetID 与单条推送在流量订阅里的口径一致：组推送按选择器广播计
+	var (
+		kind, targetID string
+		clients        []*Client
+	)
+	switch {
+	case body.Group != "":
+		kind = "broadcast"
+		if match := groupPushMatcher(body.Group, p.match); match != nil {
+			clients = defaultHub.clientsMatching(match)
+		}
+	case body.Channel != "":
+		kind, targetID = "channel", body.Channel
+		if GlobalConfig.Expiry.Enabled {
+			touchChannel(body.Channel, false)
+		}
+		if GlobalConfig.ChannelHistory.Size > 0 {
+			for _, m := range msgs {
+				recordChannelHistory(body.Channel, m)
+			}
+		}
+		for _, c := range defaultHub.channelMembers(body.Channel) {
+			if p.match == nil || p.match(c) {
+				clients = append(clients, c)
+			}
+		}
+	case p.target != "":
+		kind, targetID = "user", p.target
+		// 与 emitToUser 一样，只有发给用户全部连接的消息才进用户历史
+		if p.match == nil && defaultHub.history != nil && GlobalConfig.History.Size > 0 {
+			for i := range msgs {
+				msgs[i] = defaultHub.history.Record(p.target, msgs[i])
+			}
+		}
+		for _, c := range defaultHub.userConns(p.target) {
+			if p.match == nil || p.match(c) {
+				clients = append(clients, c)
+			}
+		}
+	default:
+		kind = "broadcast"
+		clients = defaultHub.clientsMatching(p.match)
+	}
+
+	if p.logIt {
+		log.Printf("🧾 事务推送 \"%s\"（%d 个事件）给 %s，连接数=%d, payload=%s\n",
+			body.EventName, len(msgs), txnScope(p), len(clients), redactLog(body.Events))
+	}
+	return deliverTxn(kind, targetID, clients, msgs, p.message.ID)
+}
+
+// txnScope 日志里的目标描述
+func txnScope(p *preparedPush) string {
+	switch {
+	case p.body.Group != "":
+		return "group=" + p.body.Group
+	case p.body.Channel != "":
+		return "channel=" + p.body.Channel
+	case p.target != "":
+		return "user_id=" + p.target
+	}
+	return "全站广播"
+}
+
+// deliverTxn 在每个连接上连续写出整笔事务；写失败的连接清理掉
+func deliverTxn(kind, targetID string, clients []*Client, msgs []WSMessage, msgID string) int {
+	start, sent := time.Now(), 0
+	outs := make([]*outboundMessage, len(msgs))
+	for i, m := range msgs {
+		outs[i] = newOutbound(m)
+	}
+	for _, c := range clients {
+		err := c.deliverOutbounds(outs)
+		recordReceipt(msgID, c, err)
+		if err != nil {
+			log.Printf("🧹 事务推送时发送失败，清理连接 conn=%s: %v\n", c.id, err)
+			c.conn.Close()
+			defaultHub.removeClient(c)
+			continue
+		}
+		sent++
+	}
+	for _, m := range msgs {
+		if kind == "channel" && defaultHub.metrics != nil {
+			defaultHub.metrics.ChannelMessage(targetID, sent)
+		}
+		publishOutbound(kind, targetID, m, len(clients), sent, start)
+	}
+	return sent
+}
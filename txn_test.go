@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// txnFrames 读出 n 帧事务消息
+func txnFrames(t *testing.T, conn *memConn, n int) []WSMessage {
+	t.Helper()
+	out := make([]WSMessage, 0, n)
+	for len(out) < n {
+		f, ok := conn.next(time.Second)
+		if !ok {
+			t.Fatalf("只收到 %d 帧，want %d", len(out), n)
+		}
+		var msg WSMessage
+		if err := json.Unmarshal(f.Data, &msg); err != nil {
+			t.Fatalf("帧解析失败: %v", err)
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+func TestBuildTxnEvents(t *testing.T) {
+	body := &PushRequest{Token: "u1", Events: []PushEvent{{EventName: "order.updated"}, {EventName: "inventory.changed"}}}
+	msgs, _, err := buildTxnEvents(body)
+	if err != nil || len(msgs) != 2 || body.EventName != "order.updated" {
+		t.Fatalf("msgs=%v event_name=%q err=%v", msgs, body.EventName, err)
+	}
+
+	bad := []struct {
+		body  PushRequest
+		field string
+	}{
+		{PushRequest{Events: []PushEvent{{EventName: "a"}}, Ephemeral: true}, "events"},
+		{PushRequest{Events: []PushEvent{{EventName: "a"}}, Subject: 1}, "events"},
+		{PushRequest{Events: []PushEvent{{EventName: "a"}, {}}}, "events[1].event_name"},
+		{PushRequest{Events: make([]PushEvent, txnMaxEvents+1)}, "events"},
+	}
+	for i, tc := range bad {
+		if _, field, err := buildTxnEvents(&tc.body); err == nil || field != tc.field {
+			t.Errorf("case %d: field=%q err=%v, want field %q", i, field, err, tc.field)
+		}
+	}
+}
+
+func TestDeliverTxn(t *testing.T) {
+	useConfig(t, nil)
+	a, connA := newMemClient(defaultHub, "txn-a")
+	b, connB := newMemClient(defaultHub, "txn-b")
+	broken, connBroken := newMemClient(defaultHub, "txn-b")
+	connBroken.failWith(errors.New("write failed"))
+	t.Cleanup(func() {
+		defaultHub.removeClient(a)
+		defaultHub.removeClient(b)
+	})
+
+	msgs := make([]WSMessage, 3)
+	for i := range msgs {
+		msgs[i] = WSMessage{Event: "step", Data: i, Txn: &txnInfo{ID: "msg_1", Index: i, Count: len(msgs)}}
+	}
+	if sent := deliverTxn("broadcast", "", []*Client{a, b, broken}, msgs, "msg_1"); sent != 2 {
+		t.Fatalf("sent = %d, want 2", sent)
+	}
+
+	for _, conn := range []*memConn{connA, connB} {
+		for i, msg := range txnFrames(t, conn, len(msgs)) {
+			if msg.Txn == nil || msg.Txn.ID != "msg_1" || msg.Txn.Index != i || msg.Txn.Count != len(msgs) {
+				t.Fatalf("第 %d 帧 txn = %+v", i, msg.Txn)
+			}
+		}
+	}
+	if !connBroken.isClosed() || len(defaultHub.userConns("txn-b")) != 1 {
+		t.Fatalf("写失败的连接没有被清理")
+	}
+}
+
+func TestDeliverTxnHeldDuringBacklog(t *testing.T) {
+	useConfig(t, nil)
+	c, conn := newMemClient(NewHub(), "")
+
+	// 补发期间到达的事务先攒着，补发的消息写完之后整笔按顺序写出
+	c.holdPushes()
+	outs := []*outboundMessage{newOutbound(WSMessage{Event: "t0"}), newOutbound(WSMessage{Event: "t1"})}
+	if err := c.deliverOutbounds(outs); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.deliver(WSMessage{Event: "backlog"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.releasePushes(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, msg := range txnFrames(t, conn, 3) {
+		got = append(got, msg.Event)
+	}
+	if got[0] != "backlog" || got[1] != "t0" || got[2] != "t1" {
+		t.Fatalf("顺序 = %v, want [backlog t0 t1]", got)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===== 按命名空间 / API Key 的用量统计 =====
+//
+// 多租户部署时一个命名空间（或一个 API Key）对应一个租户，运维需要按租户看负载、计费或限流：
+//
+//	GET /api/admin/stats/namespaces            各命名空间的连接数、用户数、收发消息数和字节数、最近一分钟的速率
+//	GET /api/admin/stats/namespaces/{name}     单个命名空间，不属于任何命名空间的连接归到 _default
+//	GET /api/admin/stats/api-keys              各 API Key（按指纹）的请求数、请求体字节数、速率和最后使用时间
+//	GET /api/admin/stats/api-keys/{fingerprint}
+//
+// 下行按写出的事件帧计数（所有协议，pong 这类协议控制帧不计），上行只统计原生协议（WebSocket / TCP）的帧；
+// 计数从进程启动开始累计。
+// 速率由后台每 10 秒采样一次，取最近约一分钟的平均值。只有 static 认证（api_key / auth.*.keys）能归到某个 key。
+
+const (
+	defaultNamespaceName = "_default" // 不属于任何命名空间的连接
+	usageSampleInterval  = 10 * time.Second
+	usageSampleKeep      = 7 // 保留的样本数，覆盖约一分钟
+)
+
+// trafficCounters 一组连接的收发计数
+type trafficCounters struct {
+	messagesIn  atomic.Uint64
+	messagesOut atomic.Uint64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+}
+
+// trafficSnapshot trafficCounters 的一次读数
+type trafficSnapshot struct {
+	MessagesIn  uint64 `json:"messages_in"`
+	MessagesOut uint64 `json:"messages_out"`
+	BytesIn     uint64 `json:"bytes_in"`
+	BytesOut    uint64 `json:"bytes_out"`
+}
+
+// trafficRates 每秒速率
+type trafficRates struct {
+	MessagesIn  float64 `json:"messages_in_per_sec"`
+	MessagesOut float64 `json:"messages_out_per_sec"`
+	BytesIn     float64 `json:"bytes_in_per_sec"`
+	BytesOut    float64 `json:"bytes_out_per_sec"`
+}
+
+func (t *trafficCounters) snapshot() trafficSnapshot {
+	return trafficSnapshot{
+		MessagesIn:  t.messagesIn.Load(),
+		MessagesOut: t.messagesOut.Load(),
+		BytesIn:     t.bytesIn.Load(),
+		BytesOut:    t.bytesOut.Load(),
+	}
+}
+
+// defaultTraffic 不属于任何命名空间的连接的计数
+var defaultTraffic trafficCounters
+
+// traffic 连接计数归属的那一组
+func (c *Client) traffic() *trafficCounters {
+	if c.namespace == nil {
+		return &defaultTraffic
+	}
+	return &c.namespace.traffic
+}
+
+// countOutbound / countInbound 记一帧下行 / 上行
+func (c *Client) countOutbound(n int) {
+	t := c.traffic()
+	t.messagesOut.Add(1)
+	t.bytesOut.Add(uint64(n))
+}
+
+func (c *Client) countInbound(n int) {
+	t := c.traffic()
+	t.messagesIn.Add(1)
+	t.bytesIn.Add(uint64(n))
+}
+
+// trafficByNamespace 各命名空间（含 _default）当前的计数
+func trafficByNamespace() map[string]trafficSnapshot {
+	out := make(map[string]trafficSnapshot, len(namespaces)+1)
+	out[defaultNamespaceName] = defaultTraffic.snapshot()
+	for name, ns := range namespaces {
+		out[name] = ns.traffic.snapshot()
+	}
+	return out
+}
+
+// ===== API Key 用量 =====
+
+// apiKeyUsage 一个 key 的累计用量
+type apiKeyUsage struct {
+	requests   uint64
+	byEndpoint map[string]uint64
+	bytes      uint64
+	lastUsed   time.Time
+}
+
+var (
+	apiKeyUsageMu sync.Mutex
+	apiKeyUsages  = make(map[string]*apiKeyUsage) // 指纹 -> 用量
+)
+
+// recordAPIKeyUsage 认证通过后记一次请求
+func recordAPIKeyUsage(fingerprint, endpoint string, r *http.Request) {
+	apiKeyUsageMu.Lock()
+	defer apiKeyUsageMu.Unlock()
+
+	u, ok := apiKeyUsages[fingerprint]
+	if !ok {
+		u = &apiKeyUsage{byEndpoint: make(map[string]uint64)}
+		apiKeyUsages[fingerprint] = u
+	}
+	u.requests++
+	u.byEndpoint[endpoint]++
+	if r.ContentLength > 0 {
+		u.bytes += uint64(r.ContentLength)
+	}
+	u.lastUsed = time.Now()
+}
+
+// apiKeyRequestTotals 各 key 的累计请求数，用于采样
+func apiKeyRequestTotals() map[string]uint64 {
+	apiKeyUsageMu.Lock()
+	defer apiKeyUsageMu.Unlock()
+	out := make(map[string]uint64, len(apiKeyUsages))
+	for fp, u := range apiKeyUsages {
+		out[fp] = u.requests
+	}
+	return out
+}
+
+// ===== 速率采样 =====
+
+// usageSample 某一时刻的累计计数
+type usageSample struct {
+	at         time.Time
+	namespaces map[string]trafficSnapshot
+	apiKeys    map[string]uint64
+}
+
+var (
+	usageSamplesMu   sync.Mutex
+	usageSamples     []usageSample
+	usageSamplerOnce sync.Once
+)
+
+// startUsageSampler 启动速率采样
+func startUsageSampler() {
+	usageSamplerOnce.Do(func() {
+		takeUsageSample()
+		go func() {
+			ticker := time.NewTicker(usageSampleInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				takeUsageSample()
+			}
+		}()
+	})
+}
+
+func takeUsageSample() {
+	s := usageSample{at: time.Now(), namespaces: trafficByNamespace(), apiKeys: apiKeyRequestTotals()}
+	usageSamplesMu.Lock()
+	usageSamples = append(usageSamples, s)
+	if len(usageSamples) > usageSampleKeep {
+		usageSamples = usageSamples[len(usageSamples)-usageSampleKeep:]
+	}
+	usageSamplesMu.Unlock()
+}
+
+// oldestUsageSample 最早的样本，没有样本时 ok 为 false
+func oldestUsageSample() (usageSample, bool) {
+	usageSamplesMu.Lock()
+	defer usageSamplesMu.Unlock()
+	if len(usageSamples) == 0 {
+		return usageSample{}, false
+	}
+	return usageSamples[0], true
+}
+
+// perSecond (cur - prev) / 经过的秒数
+func perSecond(cur, prev uint64, elapsed float64) float64 {
+	if elapsed <= 0 || cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / elapsed
+}
+
+// ===== 统计结果 =====
+
+// namespaceStats 一个命名空间的统计
+type namespaceStats struct {
+	Name           string `json:"name"`
+	Path           string `json:"path,omitempty"`
+	Connections    int    `json:"connections"`
+	MaxConnections int    `json:"max_connections,omitempty"`
+	Users          int    `json:"users"` // 去重后的用户组数（含匿名访客）
+	trafficSnapshot
+	Rates trafficRates `json:"rates"`
+}
+
+// collectNamespaceStats 各命名空间的统计，按名字排序，_default 在最前
+func collectNamespaceStats() []namespaceStats {
+	conns := make(map[string]int, len(namespaces)+1)
+	users := make(map[string]map[string]struct{}, len(namespaces)+1)
+	for _, c := range defaultHub.clientsMatching(nil) {
+		name := c.namespaceName()
+		if name == "" {
+			name = defaultNamespaceName
+		}
+		conns[name]++
+		if c.userID == "" {
+			continue
+		}
+		if users[name] == nil {
+			users[name] = make(map[string]struct{})
+		}
+		users[name][c.userID] = struct{}{}
+	}
+
+	prev, havePrev := oldestUsageSample()
+	elapsed := time.Since(prev.at).Seconds()
+	out := make([]namespaceStats, 0, len(namespaces)+1)
+	for name, cur := range trafficByNamespace() {
+		st := namespaceStats{Name: name, Connections: conns[name], Users: len(users[name]), trafficSnapshot: cur}
+		if ns := namespaces[name]; ns != nil {
+			st.Path = ns.cfg.Path
+			st.MaxConnections = ns.cfg.MaxConnections
+		}
+		if p, ok := prev.namespaces[name]; havePrev && ok {
+			st.Rates = trafficRates{
+				MessagesIn:  perSecond(cur.MessagesIn, p.MessagesIn, elapsed),
+				MessagesOut: perSecond(cur.MessagesOut, p.MessagesOut, elapsed),
+				BytesIn:     perSecond(cur.BytesIn, p.BytesIn, elapsed),
+				BytesOut:    perSecond(cur.BytesOut, p.BytesOut, elapsed),
+			}
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if (out[i].Name == defaultNamespaceName) != (out[j].Name == defaultNamespaceName) {
+			return out[i].Name == defaultNamespaceName
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// apiKeyStats 一个 API Key 的用量
+type apiKeyStats struct {
+	Name           string            `json:"name,omitempty"` // 配置里的位置；已轮换掉的 key 为空
+	Fingerprint    string            `json:"fingerprint"`
+	Requests       uint64            `json:"requests"`
+	ByEndpoint     map[string]uint64 `json:"by_endpoint"`
+	RequestBytes   uint64            `json:"request_bytes"`
+	RequestsPerSec float64           `json:"requests_per_sec"`
+	LastUsedAt     *time.Time        `json:"last_used_at,omitempty"`
+}
+
+// collectAPIKeyStats 当前配置的各个 key（同一个 key 配在多处时只列一次）和已经不再生效但用过的 key
+func collectAPIKeyStats() []apiKeyStats {
+	prev, havePrev := oldestUsageSample()
+	elapsed := time.Since(prev.at).Seconds()
+
+	apiKeyUsageMu.Lock()
+	defer apiKeyUsageMu.Unlock()
+
+	seen := make(map[string]bool)
+	var out []apiKeyStats
+	add := func(name, fp string) {
+		if seen[fp] {
+			return
+		}
+		seen[fp] = true
+		st := apiKeyStats{Name: name, Fingerprint: fp, ByEndpoint: map[string]uint64{}}
+		if u, ok := apiKeyUsages[fp]; ok {
+			st.Requests = u.requests
+			st.RequestBytes = u.bytes
+			for ep, n := range u.byEndpoint {
+				st.ByEndpoint[ep] = n
+			}
+			lastUsed := u.lastUsed
+			st.LastUsedAt = &lastUsed
+			if havePrev {
+				st.RequestsPerSec = perSecond(u.requests, prev.apiKeys[fp], elapsed)
+			}
+		}
+		out = append(out, st)
+	}
+	for _, k := range apiKeyFingerprints() {
+		add(k.Name, k.Fingerprint)
+	}
+	var retired []string
+	for fp := range apiKeyUsages {
+		if !seen[fp] {
+			retired = append(retired, fp)
+		}
+	}
+	sort.Strings(retired)
+	for _, fp := range retired {
+		add("", fp)
+	}
+	return out
+}
+
+// ===== 管理接口 =====
+
+// adminNamespaceStatsHandler GET /api/admin/stats/namespaces
+func adminNamespaceStatsHandler(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{"namespaces": collectNamespaceStats()},
+	})
+}
+
+// adminNamespaceStatHandler GET /api/admin/stats/namespaces/{name}
+func adminNamespaceStatHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	for _, st := range collectNamespaceStats() {
+		if st.Name == name {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"code": 0,
+				"msg":  "ok",
+				"data": st,
+			})
+			return
+		}
+	}
+	writeProblem(w, r, http.StatusNotFound, problemNotFound, "namespace not found: "+name)
+}
+
+// adminAPIKeyStatsHandler GET /api/admin/stats/api-keys
+func adminAPIKeyStatsHandler(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"msg":  "ok",
+		"data": map[string]interface{}{"api_keys": collectAPIKeyStats()},
+	})
+}
+
+// adminAPIKeyStatHandler GET /api/admin/stats/api-keys/{fingerprint}
+func adminAPIKeyStatHandler(w http.ResponseWriter, r *http.Request) {
+	fp := r.PathValue("fingerprint")
+	for _, st := range collectAPIKeyStats() {
+		if st.Fingerprint == fp {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"code": 0,
+				"msg":  "ok",
+				"data": st,
+			})
+			return
+		}
+	}
+	writeProblem(w, r, http.StatusNotFound, problemNotFound, "api key not found: "+fp)
+}
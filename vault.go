@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== HashiCorp Vault 密钥来源 =====
+//
+// 开启后配置里可以写 "vault://secret/data/relay#api_key"：
+// # 前面是 Vault API 路径（/v1/ 之后的部分），后面是字段名；KV v2 / v1 都支持。
+//
+// 认证方式：
+//   - token：使用 vault.token（本身也可以是 env:// / file:// 引用），为空时读 VAULT_TOKEN
+//   - kubernetes：用 ServiceAccount JWT 登录 auth/{mount}/login
+//
+// token 过半生命周期时自动续期（renew-self），不可续期或续期失败时 kubernetes 方式会重新登录；
+// 每 refresh_seconds 重新读取所有密钥引用，值变化时热更新到运行中的服务。
+
+// VaultConfig Vault 配置
+type VaultConfig struct {
+	Enabled        bool   `json:"enabled"`
+	Address        string `json:"address"`         // 为空时读 VAULT_ADDR
+	Namespace      string `json:"namespace"`       // 可选：Vault Enterprise 命名空间
+	AuthMethod     string `json:"auth_method"`     // token（默认）或 kubernetes
+	Token          string `json:"token"`           // token 认证使用，为空时读 VAULT_TOKEN
+	Role           string `json:"role"`            // kubernetes 认证的角色名
+	MountPath      string `json:"mount_path"`      // kubernetes 认证挂载路径，默认 kubernetes
+	JWTPath        string `json:"jwt_path"`        // ServiceAccount token 文件，默认 /var/run/secrets/kubernetes.io/serviceaccount/token
+	RefreshSeconds int    `json:"refresh_seconds"` // 重新读取密钥的间隔，默认 300
+}
+
+const (
+	vaultDefaultMountPath      = "kubernetes"
+	vaultDefaultJWTPath        = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	vaultDefaultRefreshSeconds = 300
+	vaultRequestTimeout        = 10 * time.Second
+)
+
+// vaultClient 当前 Vault 会话
+type vaultClient struct {
+	cfg  VaultConfig
+	http *http.Client
+
+	mu        sync.Mutex
+	token     string
+	renewable bool
+	expiresAt time.Time // 零值表示不过期
+}
+
+var vault *vaultClient
+
+// vaultAuth Vault 登录 / 续期接口返回的 auth 段
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// initVault 建立 Vault 会话，需在解析密钥引用之前调用
+func initVault(cfg VaultConfig) error {
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("VAULT_ADDR")
+	}
+	if cfg.Address == "" {
+		return fmt.Errorf("vault.address 和 VAULT_ADDR 都为空")
+	}
+	cfg.Address = strings.TrimRight(cfg.Address, "/")
+	if cfg.AuthMethod == "" {
+		cfg.AuthMethod = "token"
+	}
+	if cfg.MountPath == "" {
+		cfg.MountPath = vaultDefaultMountPath
+	}
+	if cfg.JWTPath == "" {
+		cfg.JWTPath = vaultDefaultJWTPath
+	}
+
+	v := &vaultClient{cfg: cfg, http: &http.Client{Timeout: vaultRequestTimeout}}
+	if err := v.login(); err != nil {
+		return err
+	}
+	vault = v
+	log.Printf("✅ Vault 已连接：%s（认证方式 %s）\n", cfg.Address, cfg.AuthMethod)
+	return nil
+}
+
+// login 按认证方式获取 token
+func (v *vaultClient) login() error {
+	switch v.cfg.AuthMethod {
+	case "token":
+		token, _, err := resolveSecret(v.cfg.Token)
+		if err != nil {
+			return fmt.Errorf("vault.token: %w", err)
+		}
+		if token == "" {
+			token = os.Getenv("VAULT_TOKEN")
+		}
+		if token == "" {
+			return fmt.Errorf("vault.token 和 VAULT_TOKEN 都为空")
+		}
+		v.setToken(token, 0, false)
+
+		// 查询 token 自身的 TTL，用于决定何时续期
+		var resp struct {
+			Data struct {
+				TTL       int  `json:"ttl"`
+				Renewable bool `json:"renewable"`
+			} `json:"data"`
+		}
+		if err := v.do(http.MethodGet, "auth/token/lookup-self", nil, &resp); err != nil {
+			return fmt.Errorf("token lookup-self 失败: %w", err)
+		}
+		v.setToken(token, resp.Data.TTL, resp.Data.Renewable)
+		return nil
+
+	case "kubernetes":
+		jwt, err := os.ReadFile(v.cfg.JWTPath)
+		if err != nil {
+			return fmt.Errorf("读取 ServiceAccount token 失败: %w", err)
+		}
+		var resp struct {
+			Auth vaultAuth `json:"auth"`
+		}
+		body := map[string]string{"role": v.cfg.Role, "jwt": strings.TrimSpace(string(jwt))}
+		if err := v.do(http.MethodPost, "auth/"+v.cfg.MountPath+"/login", body, &resp); err != nil {
+			return fmt.Errorf("kubernetes 登录失败: %w", err)
+		}
+		v.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+		return nil
+
+	default:
+		return fmt.Errorf("不支持的 vault.auth_method: %s", v.cfg.AuthMethod)
+	}
+}
+
+func (v *vaultClient) setToken(token string, ttlSeconds int, renewable bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.token = token
+	v.renewable = renewable
+	v.expiresAt = time.Time{}
+	if ttlSeconds > 0 {
+		v.expiresAt = time.Now().Add(time.Duration(ttlSeconds) * time.Second)
+	}
+}
+
+// ensureToken 剩余生命周期不足一半刷新间隔时续期，续期失败则重新登录
+func (v *vaultClient) ensureToken() {
+	v.mu.Lock()
+	expiresAt, renewable := v.expiresAt, v.renewable
+	v.mu.Unlock()
+
+	if expiresAt.IsZero() {
+		return
+	}
+	margin := time.Duration(v.cfg.RefreshSeconds) * time.Second
+	if time.Until(expiresAt) > margin {
+		return
+	}
+
+	if renewable {
+		var resp struct {
+			Auth vaultAuth `json:"auth"`
+		}
+		err := v.do(http.MethodPost, "auth/token/renew-self", map[string]string{}, &resp)
+		if err == nil {
+			v.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+			log.Printf("🔄 Vault token 已续期，有效期 %ds\n", resp.Auth.LeaseDuration)
+			return
+		}
+		log.Println("⚠️ Vault token 续期失败:", err)
+	}
+	if err := v.login(); err != nil {
+		log.Println("❌ Vault 重新登录失败:", err)
+		return
+	}
+	log.Println("🔄 Vault 已重新登录")
+}
+
+// do 调用 Vault HTTP API
+func (v *vaultClient) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, v.cfg.Address+"/v1/"+strings.TrimLeft(path, "/"), reader)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	token := v.token
+	v.mu.Unlock()
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+
+	resp, err := v.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("vault %s %s: %s %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
+
+// resolveVaultSecret 解析 vault://path#field
+func resolveVaultSecret(ref string) (string, error) {
+	if vault == nil {
+		return "", fmt.Errorf("使用了 vault:// 引用但 vault 未启用")
+	}
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || field == "" {
+		return "", fmt.Errorf("vault 引用缺少 #字段名: %s", ref)
+	}
+
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := vault.do(http.MethodGet, path, nil, &resp); err != nil {
+		return "", err
+	}
+
+	// KV v2 的值在 data.data 下，KV v1 直接在 data 下
+	data := resp.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		data = inner
+	}
+	val, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("vault 路径 %s 中没有字段 %s", path, field)
+	}
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	return toJSON(val), nil
+}
+
+// vaultRefreshLoop 续期 token 并重新读取密钥引用
+func vaultRefreshLoop() {
+	ticker := time.NewTicker(time.Duration(vault.cfg.RefreshSeconds) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		vault.ensureToken()
+		refreshSecrets()
+	}
+}
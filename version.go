@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ===== 最低客户端版本 =====
+//
+// 客户端在连接时（?app_version= 或 X-App-Version 请求头）或 identify 时（data.version）上报版本，
+// 低于 min_client_version 的连接会收到 upgrade_required 事件，随后以 CloseUpgradeRequired 关闭，
+// 便于服务端做不兼容改动时平滑淘汰旧客户端。
+
+// CloseUpgradeRequired 客户端版本过低的关闭码（对应 HTTP 426）
+const CloseUpgradeRequired = 4426
+
+// clientVersionFromRequest 从升级请求里取客户端版本
+func clientVersionFromRequest(r *http.Request) string {
+	if v := r.URL.Query().Get("app_version"); v != "" {
+		return v
+	}
+	return r.Header.Get("X-App-Version")
+}
+
+// enforceClientVersion 检查版本，不满足时通知并关闭连接，返回是否放行
+func enforceClientVersion(c *Client, version string) bool {
+	min := GlobalConfig.MinClientVersion
+	if min == "" {
+		return true
+	}
+	if version == "" {
+		if !GlobalConfig.RequireClientVersion {
+			return true
+		}
+	} else if compareVersions(version, min) >= 0 {
+		return true
+	}
+
+	log.Printf("⛔ 客户端版本过低 conn=%s version=%q min=%s，通知升级并断开\n", c.id, version, min)
+	_ = c.deliver(WSMessage{
+		Event: "upgrade_required",
+		Data: map[string]interface{}{
+			"min_version":     min,
+			"current_version": version,
+		},
+	})
+	c.closeWithCode(CloseUpgradeRequired, "upgrade required")
+	return false
+}
+
+// compareVersions 按点分段比较版本号，数字段按数值比较，其余按字符串比较；
+// 可带 v 前缀，预发布后缀（-beta 等）忽略
+func compareVersions(a, b string) int {
+	pa := versionParts(a)
+	pb := versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y string
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if c := compareVersionPart(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []string {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	v, _, _ = strings.Cut(v, "-")
+	v, _, _ = strings.Cut(v, "+")
+	return strings.Split(v, ".")
+}
+
+func compareVersionPart(x, y string) int {
+	if x == "" {
+		x = "0"
+	}
+	if y == "" {
+		y = "0"
+	}
+	nx, errX := strconv.Atoi(x)
+	ny, errY := strconv.Atoi(y)
+	if errX == nil && errY == nil {
+		switch {
+		case nx < ny:
+			return -1
+		case nx > ny:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(x, y)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ===== 发往业务后端的 webhook =====
+//
+// 推送结果回调、频道占用、过期回调、上行聚合都是同一种投递：JSON POST，配置了 secret 时签名，
+// 失败按 backoff << attempt 退避重试。统一走 deliverWebhook，各功能只管组装载荷和计数。
+// 准入 webhook 和初始数据要读响应、不重试，只共用 signWebhookRequest。
+
+// webhookRetry 一次投递用的 HTTP 客户端和重试策略
+type webhookRetry struct {
+	client  *http.Client
+	retries int           // 失败后再试几次，0 表示不重试
+	backoff time.Duration // 第 n 次重试前等待 backoff << n
+}
+
+// deliverWebhook 把 payload 序列化成 JSON POST 给 url，失败按 retry 重试，返回最后一次的错误。
+// 在调用方的发送协程里同步执行，重试期间会阻塞该协程
+func deliverWebhook(url, secret string, payload interface{}, retry webhookRetry) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		if err = postWebhook(retry.client, url, secret, body); err == nil {
+			return nil
+		}
+		if attempt >= retry.retries {
+			return err
+		}
+		time.Sleep(retry.backoff << attempt)
+	}
+}
+
+func postWebhook(client *http.Client, url, secret string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signWebhookRequest(req, secret, body)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New("webhook returned " + resp.Status)
+	}
+	return nil
+}
+
+// signWebhookRequest secret 非空时给发往业务后端的请求签名：
+// X-Relay-Signature = hex(HMAC-SHA256(secret, timestamp + "\n" + body))
+func signWebhookRequest(req *http.Request, secret string, body []byte) {
+	if secret == "" {
+		return
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "\n"))
+	mac.Write(body)
+	req.Header.Set("X-Relay-Timestamp", ts)
+	req.Header.Set("X-Relay-Signature", hex.EncodeToString(mac.Sum(nil)))
+}
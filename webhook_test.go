@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliverWebhook(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("hook-secret"))
+		mac.Write([]byte(r.Header.Get("X-Relay-Timestamp") + "\n"))
+		mac.Write(body)
+		if r.Header.Get("X-Relay-Signature") != hex.EncodeToString(mac.Sum(nil)) || string(body) != `{"event":"ping"}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// 前两次失败，第三次成功
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	payload := map[string]string{"event": "ping"}
+
+	retry := webhookRetry{client: srv.Client(), retries: 1, backoff: time.Millisecond}
+	if err := deliverWebhook(srv.URL, "hook-secret", payload, retry); err == nil || calls.Load() != 2 {
+		t.Fatalf("重试用完后 err=%v calls=%d, want 失败且共 2 次", err, calls.Load())
+	}
+
+	calls.Store(0)
+	retry.retries = 2
+	if err := deliverWebhook(srv.URL, "hook-secret", payload, retry); err != nil || calls.Load() != 3 {
+		t.Fatalf("err=%v calls=%d, want 第 3 次成功", err, calls.Load())
+	}
+
+	// 签名不对时后端拒绝，不会被当成成功
+	calls.Store(0)
+	if err := deliverWebhook(srv.URL, "wrong-secret", payload, webhookRetry{client: srv.Client()}); err == nil {
+		t.Fatal("错误的签名不应投递成功")
+	}
+}
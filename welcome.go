@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ===== 连接 welcome 事件与断线续接 =====
+//
+// hello 之后紧跟一条 welcome，把客户端自我配置需要的信息一次给全，不用再靠文档或额外的配置接口
+// （heartbeat 为本连接协商后的心跳参数，见 heartbeat.go）：
+//
+//	{"event":"welcome","data":{"connection_id":"123.1","node_id":"relay-1","server_time":1700000000000,
+//	 "heartbeat":{"class":"default","ping_interval_ms":25000,"timeout_ms":0},
+//	 "format":{"transport":"websocket","encoding":"json","e2e":"json","max_frame_bytes":65536},
+//	 "resume_token":"9f2c...","resume_ttl_ms":120000}}
+//
+// 原生 WebSocket 连接带 resume_token：连接意外断开（不是服务端主动关闭）后，会话（用户、频道、设备）
+// 保留 resume_ttl_seconds，客户端重连时带上 ?resume=token（可再加 &last_seq=）即可恢复，不需要重新 identify。
+// token 一次性，新连接的 welcome 会带新的 token。
+
+// WelcomeConfig 断线续接配置（心跳参数见 heartbeat 配置）
+type WelcomeConfig struct {
+	ResumeTTLSeconds int `json:"resume_ttl_seconds"` // 断开后保留续接会话的时间，默认 120；-1 表示关闭续接
+}
+
+const (
+	welcomeDefaultResumeTTL = 120
+	resumeQueryKey          = "resume"
+)
+
+type resumeEntry struct {
+	session   handoffSession
+	expiresAt time.Time
+}
+
+var (
+	resumeMu       sync.Mutex
+	resumeSessions = make(map[string]*resumeEntry)
+)
+
+// serverNodeID 节点标识：集群模式取 cluster.node_id，否则取主机名
+var serverNodeID = sync.OnceValue(func() string {
+	if GlobalConfig.Cluster.Enabled && GlobalConfig.Cluster.NodeID != "" {
+		return GlobalConfig.Cluster.NodeID
+	}
+	host, _ := os.Hostname()
+	return host
+})
+
+func prepareWelcome(cfg *WelcomeConfig) {
+	if cfg.ResumeTTLSeconds == 0 {
+		cfg.ResumeTTLSeconds = welcomeDefaultResumeTTL
+	}
+}
+
+// welcomeData 构造 welcome 事件的 data，transport 为 websocket / sse / tcp
+func welcomeData(c *Client, transport string) map[string]interface{} {
+	cfg := GlobalConfig.Welcome
+
+	format := map[string]interface{}{
+		"transport":       transport,
+		"encoding":        "json",
+		"max_frame_bytes": inboundConfigFor(c).MaxFrameBytes,
+	}
+	switch transport {
+	case "websocket":
+		format["e2e"] = "json"
+		if c.e2eBinary {
+			format["e2e"] = "binary"
+		}
+	case "sse":
+		delete(format, "max_frame_bytes")
+	case "tcp":
+		format["max_frame_bytes"] = GlobalConfig.TCP.MaxFrameBytes
+	}
+
+	data := map[string]interface{}{
+		"connection_id": c.id,
+		"node_id":       serverNodeID(),
+		"server_time":   time.Now().UnixMilli(),
+		"heartbeat":     c.heartbeat,
+		"format":        format,
+	}
+	if ns := c.namespaceName(); ns != "" {
+		data["namespace"] = ns
+	}
+	if c.resumeToken != "" {
+		data["resume_token"] = c.resumeToken
+		data["resume_ttl_ms"] = cfg.ResumeTTLSeconds * 1000
+	}
+	return data
+}
+
+// sendWelcome 下发 welcome 事件；原生 WebSocket 连接同时分配续接凭证
+func sendWelcome(c *Client, transport string) error {
+	if transport == "websocket" && GlobalConfig.Welcome.ResumeTTLSeconds > 0 {
+		c.resumeToken = randomHex(16)
+	}
+	if err := c.deliver(WSMessage{Event: "welcome", Data: welcomeData(c, transport)}); err != nil {
+		log.Println("⚠️ welcome 发送失败:", err)
+		return err
+	}
+	return nil
+}
+
+// parkResumeSession 连接断开时保留会话供续接；服务端主动关闭、正在回收或未识别的连接不保留
+func parkResumeSession(c *Client) {
+	if c.resumeToken == "" || c.userID == "" || c.serverClosed.Load() || c.recycling.Load() {
+		return
+	}
+	s := exportSession(c)
+	s.Seq, s.History = 0, nil // 本节点续接直接读本地历史
+
+	now := time.Now()
+	resumeMu.Lock()
+	for t, e := range resumeSessions {
+		if now.After(e.expiresAt) {
+			delete(resumeSessions, t)
+		}
+	}
+	resumeSessions[c.resumeToken] = &resumeEntry{
+		session:   s,
+		expiresAt: now.Add(time.Duration(GlobalConfig.Welcome.ResumeTTLSeconds) * time.Second),
+	}
+	resumeMu.Unlock()
+}
+
+// restoreResume 原生连接带 ?resume= 时恢复断开前的会话，返回是否成功
+func restoreResume(c *Client, r *http.Request) bool {
+	token := r.URL.Query().Get(resumeQueryKey)
+	resumeMu.Lock()
+	e, ok := resumeSessions[token]
+	delete(resumeSessions, token)
+	resumeMu.Unlock()
+
+	if !ok || time.Now().After(e.expiresAt) {
+		_ = c.deliver(WSMessage{Event: "error", Data: map[string]interface{}{"code": "invalid_resume"}})
+		return false
+	}
+	if !restoreSession(c, e.session, r) {
+		return false
+	}
+	log.Printf("🔁 连接 %s 已续接断开前的会话 user_id=%s，频道 %d 个\n", c.id, logUserID(e.session.UserID), len(e.session.Channels))
+	return true
+}
+
+// dropUserResumeSessions 作废某用户保留的续接会话，返回作废数量
+func dropUserResumeSessions(userID string) int {
+	resumeMu.Lock()
+	defer resumeMu.Unlock()
+
+	n := 0
+	for t, e := range resumeSessions {
+		if e.session.UserID == userID {
+			delete(resumeSessions, t)
+			n++
+		}
+	}
+	return n
+}